@@ -22,13 +22,44 @@ import (
 
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/version"
+
 	metadatasyncer "sigs.k8s.io/vsphere-csi-driver/pkg/syncer"
 )
 
+// simulatorClusterID is the cluster ID used against the vCenter simulator
+const simulatorClusterID = "vsphere-csi-simulator"
+
+var simulator = flag.Bool("simulator", false,
+	"Run against an in-process vCenter simulator with CNS instead of the configured vCenter, for local development")
+
 // main is ignored when this package is built as a go plug-in.
 func main() {
 	klog.InitFlags(nil)
+	backoff.InitFlags(nil)
+	slowlog.InitFlags(nil)
+	faultinjection.InitFlags(nil)
 	flag.Parse()
+	if err := backoff.Get().Validate(); err != nil {
+		klog.Fatalf("Invalid retry flags. Err: %v", err)
+	}
+	if err := faultinjection.Init(); err != nil {
+		klog.Fatalf("Invalid fault injection flags. Err: %v", err)
+	}
+	supportbundle.CaptureLogs(supportbundle.DefaultLogBufferSize)
+	if *simulator {
+		stopSimulator, err := cnsconfig.StartSimulator(simulatorClusterID)
+		if err != nil {
+			klog.Fatalf("Failed to start the vCenter simulator. Err: %v", err)
+		}
+		defer stopSimulator()
+	}
+	klog.V(2).Infof("Syncer version: %+v", version.Get())
 	metadataSyncer := metadatasyncer.NewInformer()
 	if err := metadataSyncer.Init(); err != nil {
 		klog.Errorf("Error initializing Metadata Syncer")
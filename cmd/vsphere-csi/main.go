@@ -19,18 +19,58 @@ package main
 import (
 	"context"
 	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/rexray/gocsi"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/provider"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
 )
 
+// simulatorClusterID is the cluster ID used against the vCenter simulator
+const simulatorClusterID = "vsphere-csi-simulator"
+
+var simulator = flag.Bool("simulator", false,
+	"Run against an in-process vCenter simulator with CNS instead of the configured vCenter, for local development")
+
 // main is ignored when this package is built as a go plug-in.
 func main() {
 	klog.InitFlags(nil)
+	backoff.InitFlags(nil)
+	slowlog.InitFlags(nil)
+	faultinjection.InitFlags(nil)
 	flag.Parse()
+	if err := backoff.Get().Validate(); err != nil {
+		klog.Fatalf("Invalid retry flags. Err: %v", err)
+	}
+	if err := faultinjection.Init(); err != nil {
+		klog.Fatalf("Invalid fault injection flags. Err: %v", err)
+	}
+	supportbundle.CaptureLogs(supportbundle.DefaultLogBufferSize)
+	if *simulator {
+		stopSimulator, err := cnsconfig.StartSimulator(simulatorClusterID)
+		if err != nil {
+			klog.Fatalf("Failed to start the vCenter simulator. Err: %v", err)
+		}
+		defer stopSimulator()
+	}
+	lis, err := service.GetEndpointListener()
+	if err != nil {
+		klog.Fatalf("Failed to listen on the CSI endpoint. Err: %v", err)
+	}
+	if lis != nil {
+		serveListener(context.Background(), provider.New(), lis)
+		return
+	}
 	gocsi.Run(
 		context.Background(),
 		service.Name,
@@ -39,8 +79,50 @@ func main() {
 		provider.New())
 }
 
+// serveListener serves the CSI endpoint on a listener gocsi cannot create, that is the listener
+// passed by systemd socket activation or a Windows named pipe.
+// There is no socket file owned by the driver, hence none is removed on exit.
+func serveListener(ctx context.Context, sp gocsi.StoragePluginProvider, lis net.Listener) {
+	klog.V(2).Infof("Serving %s on %s", service.Name, lis.Addr())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		klog.V(2).Infof("Received signal %v, stopping gracefully", sig)
+		sp.GracefulStop(ctx)
+	}()
+	if err := sp.Serve(ctx, lis); err != nil {
+		klog.Fatalf("Failed to serve %s. Err: %v", service.Name, err)
+	}
+}
+
 const usage = `    VSPHERE_CSI_CONFIG
         Specifies the path to the csi-vsphere.conf file
 
         The default value is "/etc/cloud/csi-vsphere.conf"
+
+    CSI_ENDPOINT
+        Specifies the CSI endpoint, for example unix:///csi/csi.sock
+
+        On Windows the endpoint may be a named pipe, for example
+        npipe://./pipe/csi-vsphere
+
+        Not required if the endpoint socket is passed by systemd socket
+        activation, in which case LISTEN_PID and LISTEN_FDS are set by
+        systemd and the socket must be the only one passed.
+
+    X_CSI_ENDPOINT_PERMS
+        Specifies the file mode of the CSI endpoint socket file
+
+        The default value is 0755.
+
+    X_CSI_ENDPOINT_USER
+        Specifies the user owning the CSI endpoint socket file
+
+        The default value is the user running the driver.
+
+    X_CSI_ENDPOINT_GROUP
+        Specifies the group owning the CSI endpoint socket file
+
+        The default value is the group running the driver.
 `
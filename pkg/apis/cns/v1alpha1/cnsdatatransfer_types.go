@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CnsDataTransferResource is the plural resource name of CnsDataTransfer
+const CnsDataTransferResource = "cnsdatatransfers"
+
+// CnsDataTransferGVR is the GroupVersionResource of CnsDataTransfer
+var CnsDataTransferGVR = SchemeGroupVersion.WithResource(CnsDataTransferResource)
+
+// CnsDataTransferOperation is the operation of a CnsDataTransfer
+type CnsDataTransferOperation string
+
+const (
+	// CnsDataTransferExport opens a snapshot of a volume for read, so that its data can be exported
+	CnsDataTransferExport CnsDataTransferOperation = "Export"
+	// CnsDataTransferRestore creates a new First Class Disk, so that exported data can be restored into it
+	CnsDataTransferRestore CnsDataTransferOperation = "Restore"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsDataTransfer lets backup data movers access the First Class Disks of CSI volumes without raw datastore access.
+// An Export publishes the location of a snapshot, which the data mover opens for read over the NBD transport of
+// VDDK with its own vCenter credentials. A Restore creates a First Class Disk which the data mover writes the
+// exported data to, then imports with a CnsRegisterVolume. The snapshot of an Export must not be deleted until
+// the data is exported, and the disk of a Restore is not deleted along with the CnsDataTransfer.
+type CnsDataTransfer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CnsDataTransferSpec   `json:"spec,omitempty"`
+	Status CnsDataTransferStatus `json:"status,omitempty"`
+}
+
+// CnsDataTransferSpec is the spec of CnsDataTransfer
+type CnsDataTransferSpec struct {
+	// Operation is Export or Restore
+	Operation CnsDataTransferOperation `json:"operation"`
+	// SnapshotID is the CSI snapshot handle of the snapshot to export. Required for Export.
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// CapacityInMB is the capacity of the disk to restore into. Required for Restore.
+	CapacityInMB int64 `json:"capacityInMB,omitempty"`
+	// DatastoreURL is the URL of the datastore to create the disk to restore into on. Required for Restore.
+	DatastoreURL string `json:"datastoreURL,omitempty"`
+}
+
+// CnsDataTransferStatus is the status of CnsDataTransfer
+type CnsDataTransferStatus struct {
+	// Ready is set to true once the data mover can open the disk
+	Ready bool `json:"ready"`
+	// VCenterHost is the vCenter the data mover connects to
+	VCenterHost string `json:"vCenterHost,omitempty"`
+	// Datastore is the managed object id of the datastore of the disk, such as datastore-12
+	Datastore string `json:"datastore,omitempty"`
+	// DiskID is the id of the First Class Disk, the volume of the snapshot for Export or the new disk for Restore.
+	// It is recorded as soon as the disk of a Restore is created, even if the Restore fails afterwards.
+	DiskID string `json:"diskID,omitempty"`
+	// SnapshotID is the id of the First Class Disk snapshot to open for Export
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// DiskPath is the datastore path of the VMDK backing the First Class Disk
+	DiskPath string `json:"diskPath,omitempty"`
+	// CapacityInMB is the capacity of the First Class Disk
+	CapacityInMB int64 `json:"capacityInMB,omitempty"`
+	// Error is the last error encountered while preparing the transfer
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready, InProgress and Failed conditions of the transfer
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsDataTransferList is a list of CnsDataTransfer
+type CnsDataTransferList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CnsDataTransfer `json:"items"`
+}
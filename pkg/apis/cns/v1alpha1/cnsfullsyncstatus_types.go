@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CnsFullSyncStatusResource is the plural resource name of CnsFullSyncStatus
+const CnsFullSyncStatusResource = "cnsfullsyncstatuses"
+
+// CnsFullSyncStatusName is the name of the CnsFullSyncStatus updated by the syncer
+const CnsFullSyncStatusName = "full-sync"
+
+// CnsFullSyncStatusGVR is the GroupVersionResource of CnsFullSyncStatus
+var CnsFullSyncStatusGVR = SchemeGroupVersion.WithResource(CnsFullSyncStatusResource)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsFullSyncStatus reports the outcome of the last full sync run of the syncer.
+type CnsFullSyncStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status CnsFullSyncStatusStatus `json:"status,omitempty"`
+}
+
+// CnsFullSyncStatusStatus is the status of CnsFullSyncStatus
+type CnsFullSyncStatusStatus struct {
+	// LastRunStartTime is the time the last full sync run started
+	LastRunStartTime metav1.Time `json:"lastRunStartTime,omitempty"`
+	// LastRunEndTime is the time the last full sync run ended
+	LastRunEndTime metav1.Time `json:"lastRunEndTime,omitempty"`
+	// LastSuccessTime is the time the last successful full sync run ended
+	LastSuccessTime metav1.Time `json:"lastSuccessTime,omitempty"`
+	// VolumesScanned is the number of PersistentVolumes scanned by the last run
+	VolumesScanned int64 `json:"volumesScanned"`
+	// VolumesCreated is the number of volumes missing in CNS registered by the last run
+	VolumesCreated int64 `json:"volumesCreated"`
+	// MetadataRepaired is the number of volumes whose CNS metadata was repaired by the last run
+	MetadataRepaired int64 `json:"metadataRepaired"`
+	// OrphansFound is the number of CNS volumes without a PersistentVolume found by the last run
+	OrphansFound int64 `json:"orphansFound"`
+	// OrphansDeleted is the number of CNS volumes without a PersistentVolume deleted by the last run
+	OrphansDeleted int64 `json:"orphansDeleted"`
+	// Error is the error the last run failed with, empty if it succeeded
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready, InProgress and Failed conditions of full sync
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsFullSyncStatusList is a list of CnsFullSyncStatus
+type CnsFullSyncStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CnsFullSyncStatus `json:"items"`
+}
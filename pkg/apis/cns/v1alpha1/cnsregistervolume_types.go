@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CnsRegisterVolumeResource is the plural resource name of CnsRegisterVolume
+const CnsRegisterVolumeResource = "cnsregistervolumes"
+
+// CnsRegisterVolumeGVR is the GroupVersionResource of CnsRegisterVolume
+var CnsRegisterVolumeGVR = SchemeGroupVersion.WithResource(CnsRegisterVolumeResource)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsRegisterVolume imports an existing disk, given by its First Class Disk id or the URL path of its VMDK,
+// and creates a PersistentVolume and a PersistentVolumeClaim bound to it in the namespace of the resource.
+type CnsRegisterVolume struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CnsRegisterVolumeSpec   `json:"spec,omitempty"`
+	Status CnsRegisterVolumeStatus `json:"status,omitempty"`
+}
+
+// CnsRegisterVolumeSpec is the spec of CnsRegisterVolume
+type CnsRegisterVolumeSpec struct {
+	// PvcName is the name of the PersistentVolumeClaim created for the volume
+	PvcName string `json:"pvcName"`
+	// VolumeID is the id of the First Class Disk to import. Exactly one of VolumeID and DiskURLPath is specified.
+	VolumeID string `json:"volumeID,omitempty"`
+	// DiskURLPath is the URL path of the VMDK to import, such as
+	// https://vc/folder/vm/disk.vmdk?dcPath=datacenter&dsName=datastore. The VMDK is registered as a First Class Disk.
+	DiskURLPath string `json:"diskURLPath,omitempty"`
+	// AccessMode is the access mode of the PersistentVolume and PersistentVolumeClaim. Defaults to ReadWriteOnce.
+	AccessMode v1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+	// FsType is the filesystem type of the volume. Defaults to ext4.
+	FsType string `json:"fsType,omitempty"`
+}
+
+// CnsRegisterVolumeStatus is the status of CnsRegisterVolume
+type CnsRegisterVolumeStatus struct {
+	// Registered is set to true once the PersistentVolume and PersistentVolumeClaim are created
+	Registered bool `json:"registered"`
+	// VolumeID is the id of the First Class Disk of the volume
+	VolumeID string `json:"volumeID,omitempty"`
+	// PersistentVolumeName is the name of the PersistentVolume created for the volume
+	PersistentVolumeName string `json:"persistentVolumeName,omitempty"`
+	// Error is the last error encountered while registering the volume
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready, InProgress and Failed conditions of the registration
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsRegisterVolumeList is a list of CnsRegisterVolume
+type CnsRegisterVolumeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CnsRegisterVolume `json:"items"`
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CnsUnregisterVolumeResource is the plural resource name of CnsUnregisterVolume
+const CnsUnregisterVolumeResource = "cnsunregistervolumes"
+
+// CnsUnregisterVolumeGVR is the GroupVersionResource of CnsUnregisterVolume
+var CnsUnregisterVolumeGVR = SchemeGroupVersion.WithResource(CnsUnregisterVolumeResource)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsUnregisterVolume removes a volume from CNS and deletes its PersistentVolume and PersistentVolumeClaim,
+// preserving the First Class Disk backing the volume on its datastore.
+type CnsUnregisterVolume struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CnsUnregisterVolumeSpec   `json:"spec,omitempty"`
+	Status CnsUnregisterVolumeStatus `json:"status,omitempty"`
+}
+
+// CnsUnregisterVolumeSpec is the spec of CnsUnregisterVolume
+type CnsUnregisterVolumeSpec struct {
+	// VolumeID is the id of the volume to unregister. The PersistentVolumeClaim bound to its PersistentVolume,
+	// if any, must be in the namespace of the CnsUnregisterVolume.
+	VolumeID string `json:"volumeID"`
+}
+
+// CnsUnregisterVolumeStatus is the status of CnsUnregisterVolume
+type CnsUnregisterVolumeStatus struct {
+	// Unregistered is set to true once the volume is removed from CNS and Kubernetes
+	Unregistered bool `json:"unregistered"`
+	// Error is the last error encountered while unregistering the volume
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready, InProgress and Failed conditions of the unregistration
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsUnregisterVolumeList is a list of CnsUnregisterVolume
+type CnsUnregisterVolumeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CnsUnregisterVolume `json:"items"`
+}
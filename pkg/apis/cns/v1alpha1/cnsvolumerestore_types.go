@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CnsVolumeRestoreResource is the plural resource name of CnsVolumeRestore
+const CnsVolumeRestoreResource = "cnsvolumerestores"
+
+// CnsVolumeRestoreGVR is the GroupVersionResource of CnsVolumeRestore
+var CnsVolumeRestoreGVR = SchemeGroupVersion.WithResource(CnsVolumeRestoreResource)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsVolumeRestore restores a volume which has been moved to trash by the controller
+// and creates a PersistentVolume for it.
+type CnsVolumeRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CnsVolumeRestoreSpec   `json:"spec,omitempty"`
+	Status CnsVolumeRestoreStatus `json:"status,omitempty"`
+}
+
+// CnsVolumeRestoreSpec is the spec of CnsVolumeRestore
+type CnsVolumeRestoreSpec struct {
+	// VolumeID is the id of the volume in trash
+	VolumeID string `json:"volumeID"`
+	// DatastoreURL is the URL of the datastore the volume resides on.
+	// All datastores are searched for the volume if not specified.
+	DatastoreURL string `json:"datastoreURL,omitempty"`
+	// PersistentVolumeName is the name of the PersistentVolume created for the restored volume.
+	// Defaults to the name the volume had before it was moved to trash.
+	PersistentVolumeName string `json:"persistentVolumeName,omitempty"`
+	// FsType is the filesystem type of the restored volume. Defaults to ext4.
+	FsType string `json:"fsType,omitempty"`
+}
+
+// CnsVolumeRestoreStatus is the status of CnsVolumeRestore
+type CnsVolumeRestoreStatus struct {
+	// Restored is set to true once the volume is restored and the PersistentVolume is created
+	Restored bool `json:"restored"`
+	// PersistentVolumeName is the name of the PersistentVolume created for the restored volume
+	PersistentVolumeName string `json:"persistentVolumeName,omitempty"`
+	// Error is the last error encountered while restoring the volume
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready, InProgress and Failed conditions of the restore
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CnsVolumeRestoreList is a list of CnsVolumeRestore
+type CnsVolumeRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CnsVolumeRestore `json:"items"`
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionType is the type of a condition of a driver custom resource
+type ConditionType string
+
+const (
+	// ConditionReady is True once the driver has carried out the request of the resource
+	ConditionReady ConditionType = "Ready"
+	// ConditionInProgress is True while the driver is working on the request of the resource
+	ConditionInProgress ConditionType = "InProgress"
+	// ConditionFailed is True if the driver failed to carry out the request of the resource
+	ConditionFailed ConditionType = "Failed"
+)
+
+// Condition describes the state of a driver custom resource at a certain point
+type Condition struct {
+	// Type of the condition
+	Type ConditionType `json:"type"`
+	// Status of the condition, one of True, False or Unknown
+	Status v1.ConditionStatus `json:"status"`
+	// Reason is a machine-readable CamelCase reason for the last transition of the condition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable message with details about the last transition
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the last time the condition changed status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backoff holds the retry policy shared by all retry loops of the driver and the syncer,
+// so that operators can tune how aggressively vCenter is retried with a single set of flags.
+package backoff
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultInitialInterval is the default delay before the first retry
+	DefaultInitialInterval = time.Second
+	// DefaultMaxInterval is the default upper bound of the delay between retries
+	DefaultMaxInterval = 30 * time.Second
+	// DefaultMultiplier is the default factor the delay grows by after each retry
+	DefaultMultiplier = 2.0
+	// DefaultJitter is the default fraction of the delay randomly added to it
+	DefaultJitter = 0.1
+	// DefaultMaxAttempts is the default number of attempts, including the first one, before giving up
+	DefaultMaxAttempts = 5
+)
+
+// Config is the retry policy
+type Config struct {
+	// InitialInterval is the delay before the first retry
+	InitialInterval time.Duration
+	// MaxInterval is the upper bound of the delay between retries, before jitter is added
+	MaxInterval time.Duration
+	// Multiplier is the factor the delay grows by after each retry
+	Multiplier float64
+	// Jitter is the fraction of the delay randomly added to it, so that retries of many callers spread out
+	Jitter float64
+	// MaxAttempts is the number of attempts, including the first one, before giving up
+	MaxAttempts int
+}
+
+// config is the retry policy in effect, set by the flags registered with InitFlags
+var config = DefaultConfig()
+
+// DefaultConfig returns the default retry policy
+func DefaultConfig() Config {
+	return Config{
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		Multiplier:      DefaultMultiplier,
+		Jitter:          DefaultJitter,
+		MaxAttempts:     DefaultMaxAttempts,
+	}
+}
+
+// InitFlags registers the flags of the retry policy on the given flagset, or on flag.CommandLine if it is nil
+func InitFlags(flagset *flag.FlagSet) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	flagset.DurationVar(&config.InitialInterval, "retry-initial-interval", config.InitialInterval,
+		"Delay before the first retry of a failed vCenter or Kubernetes operation")
+	flagset.DurationVar(&config.MaxInterval, "retry-max-interval", config.MaxInterval,
+		"Upper bound of the delay between retries")
+	flagset.Float64Var(&config.Multiplier, "retry-multiplier", config.Multiplier,
+		"Factor the delay between retries grows by after each retry")
+	flagset.Float64Var(&config.Jitter, "retry-jitter", config.Jitter,
+		"Fraction of the delay between retries randomly added to it")
+	flagset.IntVar(&config.MaxAttempts, "retry-max-attempts", config.MaxAttempts,
+		"Number of attempts of an operation, including the first one, before giving up")
+}
+
+// Get returns the retry policy in effect
+func Get() Config {
+	return config
+}
+
+// Set validates and sets the retry policy in effect
+func Set(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	config = c
+	return nil
+}
+
+// Validate returns an error if the retry policy is invalid
+func (c Config) Validate() error {
+	if c.InitialInterval <= 0 {
+		return fmt.Errorf("retry initial interval must be positive, got %v", c.InitialInterval)
+	}
+	if c.MaxInterval < c.InitialInterval {
+		return fmt.Errorf("retry max interval %v must not be less than the initial interval %v", c.MaxInterval, c.InitialInterval)
+	}
+	if c.Multiplier < 1 {
+		return fmt.Errorf("retry multiplier must be at least 1, got %v", c.Multiplier)
+	}
+	if c.Jitter < 0 {
+		return fmt.Errorf("retry jitter must not be negative, got %v", c.Jitter)
+	}
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("retry max attempts must be at least 1, got %d", c.MaxAttempts)
+	}
+	return nil
+}
+
+// Delay returns the delay before the given retry, counting from 1, without jitter
+func (c Config) Delay(retry int) time.Duration {
+	delay := float64(c.InitialInterval)
+	for i := 1; i < retry && delay < float64(c.MaxInterval); i++ {
+		delay *= c.Multiplier
+	}
+	if delay > float64(c.MaxInterval) {
+		return c.MaxInterval
+	}
+	return time.Duration(delay)
+}
+
+// OnError calls fn until it succeeds, returns an error isRetriable returns false for,
+// the attempts of the retry policy in effect are used up or ctx is done. No retry is made
+// if its delay would end after the deadline of ctx. The last error of fn is returned.
+func OnError(ctx context.Context, operation string, isRetriable func(error) bool, fn func() error) error {
+	c := Get()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+		if attempt >= c.MaxAttempts {
+			klog.Errorf("%s failed after %d attempts with err: %v", operation, attempt, err)
+			return err
+		}
+		delay := wait.Jitter(c.Delay(attempt), c.Jitter)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			klog.Errorf("%s failed after %d attempts with err: %v. Retry budget used up", operation, attempt, err)
+			return err
+		}
+		klog.V(3).Infof("%s failed on attempt %d with err: %v. Retrying in %v", operation, attempt, err, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// OnErrorWithin is OnError with a budget: no retry is made once the given time since the first attempt
+// would have elapsed when it starts, so that retries do not outlast the callers waiting for the operation.
+// Attempts in progress are not interrupted when the budget is used up.
+func OnErrorWithin(ctx context.Context, operation string, budget time.Duration, isRetriable func(error) bool, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	return OnError(ctx, operation, isRetriable, fn)
+}
+
+// Always is an isRetriable function of OnError which retries all errors
+func Always(error) bool {
+	return true
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	c := Config{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      3,
+	}
+	tests := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 3 * time.Second},
+		{3, 9 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if delay := c.Delay(test.retry); delay != test.expected {
+			t.Errorf("Delay(%d) = %v, expected %v", test.retry, delay, test.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		valid  bool
+	}{
+		{"default", func(*Config) {}, true},
+		{"zero initial interval", func(c *Config) { c.InitialInterval = 0 }, false},
+		{"max below initial interval", func(c *Config) { c.MaxInterval = c.InitialInterval / 2 }, false},
+		{"shrinking multiplier", func(c *Config) { c.Multiplier = 0.5 }, false},
+		{"negative jitter", func(c *Config) { c.Jitter = -1 }, false},
+		{"no attempts", func(c *Config) { c.MaxAttempts = 0 }, false},
+	}
+	for _, test := range tests {
+		c := DefaultConfig()
+		test.modify(&c)
+		if err := c.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: Validate() returned %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	if err := Set(Config{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond, Multiplier: 1, MaxAttempts: 3}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	errRetriable := errors.New("retriable")
+	errFatal := errors.New("fatal")
+	isRetriable := func(err error) bool { return err == errRetriable }
+	tests := []struct {
+		name             string
+		errs             []error
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{"succeeds", []error{nil}, nil, 1},
+		{"succeeds on retry", []error{errRetriable, nil}, nil, 2},
+		{"fatal error", []error{errRetriable, errFatal}, errFatal, 2},
+		{"attempts used up", []error{errRetriable, errRetriable, errRetriable, nil}, errRetriable, 3},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := OnError(context.Background(), test.name, isRetriable, func() error {
+			err := test.errs[attempts]
+			attempts++
+			return err
+		})
+		if err != test.expectedErr || attempts != test.expectedAttempts {
+			t.Errorf("%s: got err %v after %d attempts, expected err %v after %d attempts",
+				test.name, err, attempts, test.expectedErr, test.expectedAttempts)
+		}
+	}
+}
+
+func TestOnErrorWithin(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	if err := Set(Config{InitialInterval: 100 * time.Millisecond, MaxInterval: 100 * time.Millisecond, Multiplier: 1, MaxAttempts: 3}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	errRetriable := errors.New("retriable")
+	tests := []struct {
+		name             string
+		budget           time.Duration
+		expectedAttempts int
+	}{
+		{"budget below first delay", 50 * time.Millisecond, 1},
+		{"budget of one delay", 150 * time.Millisecond, 2},
+		{"budget above attempts", time.Minute, 3},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := OnErrorWithin(context.Background(), test.name, test.budget, Always, func() error {
+			attempts++
+			return errRetriable
+		})
+		if err != errRetriable || attempts != test.expectedAttempts {
+			t.Errorf("%s: got err %v after %d attempts, expected err %v after %d attempts",
+				test.name, err, attempts, errRetriable, test.expectedAttempts)
+		}
+	}
+}
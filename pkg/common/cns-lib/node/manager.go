@@ -20,11 +20,13 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/vmware/govmomi/vim25/types"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
 
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
 )
 
 var (
@@ -56,6 +58,12 @@ type Manager interface {
 	GetAllNodes() ([]*vsphere.VirtualMachine, error)
 	// UnregisterNode unregisters a registered node given its name.
 	UnregisterNode(nodeName string) error
+	// GetRegisteredNodeUUIDs returns the UUIDs of all registered nodes by node name, whether or not
+	// their virtual machine was discovered, without refreshing them.
+	GetRegisteredNodeUUIDs() map[string]string
+	// NodeVMMigrated rediscovers the registered node whose VM is the given virtual machine of the given
+	// vCenter, as the VM may have moved to another datacenter or vCenter. Other virtual machines are ignored.
+	NodeVMMigrated(vcHost string, vmRef types.ManagedObjectReference) error
 }
 
 // Metadata represents node metadata.
@@ -75,6 +83,9 @@ func GetManager() Manager {
 		managerInstance = &nodeManager{
 			nodeVMs: sync.Map{},
 		}
+		supportbundle.Register("nodes.json", supportbundle.JSONCollector(func() interface{} {
+			return managerInstance.registeredNodes()
+		}))
 		klog.V(1).Info("node.nodeManager initialized")
 	})
 	return managerInstance
@@ -249,3 +260,51 @@ func (m *nodeManager) UnregisterNode(nodeName string) error {
 	klog.V(2).Infof("Successfully unregistered node with nodeName %s", nodeName)
 	return nil
 }
+
+// GetRegisteredNodeUUIDs returns the UUIDs of all registered nodes by node name.
+func (m *nodeManager) GetRegisteredNodeUUIDs() map[string]string {
+	nodeUUIDs := make(map[string]string)
+	m.nodeNameToUUID.Range(func(nodeName, nodeUUID interface{}) bool {
+		nodeUUIDs[nodeName.(string)] = nodeUUID.(string)
+		return true
+	})
+	return nodeUUIDs
+}
+
+// NodeVMMigrated rediscovers the registered node whose VM is the given virtual machine of the given vCenter.
+func (m *nodeManager) NodeVMMigrated(vcHost string, vmRef types.ManagedObjectReference) error {
+	var nodeUUID string
+	m.nodeVMs.Range(func(nodeUUIDInf, vmInf interface{}) bool {
+		if vm, ok := vmInf.(*vsphere.VirtualMachine); ok && vm.VirtualCenterHost == vcHost && vm.Reference() == vmRef {
+			nodeUUID = nodeUUIDInf.(string)
+			return false
+		}
+		return true
+	})
+	if nodeUUID == "" {
+		return nil
+	}
+	klog.V(2).Infof("VM %v of node with nodeUUID %s migrated, rediscovering it", vmRef, nodeUUID)
+	return m.DiscoverNode(nodeUUID)
+}
+
+// registeredNode is the state of a registered node recorded in the support bundle
+type registeredNode struct {
+	NodeUUID string `json:"nodeUUID"`
+	// VM is the inventory path of the node VM if discovered
+	VM string `json:"vm,omitempty"`
+}
+
+// registeredNodes returns the registered nodes by node name without refreshing them
+func (m *nodeManager) registeredNodes() map[string]registeredNode {
+	nodes := make(map[string]registeredNode)
+	m.nodeNameToUUID.Range(func(nodeName, nodeUUID interface{}) bool {
+		node := registeredNode{NodeUUID: nodeUUID.(string)}
+		if vm, ok := m.nodeVMs.Load(node.NodeUUID); ok {
+			node.VM = vm.(*vsphere.VirtualMachine).InventoryPath
+		}
+		nodes[nodeName.(string)] = node
+		return true
+	})
+	return nodes
+}
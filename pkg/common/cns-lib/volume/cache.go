@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"sync"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+// queryPageSize is the number of volumes requested per CNS QueryVolume call when paging through volumes
+const queryPageSize = 100
+
+// volumeCacheTTL is the time after which a cached volume is queried from CNS again, bounding how long changes
+// made to the volume outside of this driver, e.g. by Storage vMotion, go unnoticed
+const volumeCacheTTL = 10 * time.Minute
+
+// volumeCache caches CNS volumes by volume id.
+type volumeCache struct {
+	lock    sync.RWMutex
+	volumes map[string]cachedVolume
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// cachedVolume is a CNS volume and the time it was added to the cache.
+type cachedVolume struct {
+	volume cnstypes.CnsVolume
+	added  time.Time
+}
+
+func newVolumeCache() *volumeCache {
+	return &volumeCache{
+		volumes: make(map[string]cachedVolume),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached volume with the given id, unless it expired.
+func (c *volumeCache) get(volumeID string) (cnstypes.CnsVolume, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cached, ok := c.volumes[volumeID]
+	if !ok || c.now().Sub(cached.added) >= volumeCacheTTL {
+		return cnstypes.CnsVolume{}, false
+	}
+	return cached.volume, true
+}
+
+// add caches the given volumes.
+func (c *volumeCache) add(volumes ...cnstypes.CnsVolume) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	added := c.now()
+	for _, volume := range volumes {
+		c.volumes[volume.VolumeId.Id] = cachedVolume{volume: volume, added: added}
+	}
+}
+
+// remove evicts the volume with the given id from the cache.
+func (c *volumeCache) remove(volumeID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.volumes, volumeID)
+}
+
+// list returns the cached volumes.
+func (c *volumeCache) list() []cnstypes.CnsVolume {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	volumes := make([]cnstypes.CnsVolume, 0, len(c.volumes))
+	for _, cached := range c.volumes {
+		volumes = append(volumes, cached.volume)
+	}
+	return volumes
+}
+
+// len returns the number of cached volumes.
+func (c *volumeCache) len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.volumes)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+func TestVolumeCacheExpiry(t *testing.T) {
+	now := time.Unix(1577836800, 0)
+	cache := newVolumeCache()
+	cache.now = func() time.Time { return now }
+	cache.add(cnstypes.CnsVolume{VolumeId: cnstypes.CnsVolumeId{Id: "vol-1"}, DatastoreUrl: "ds:///vmfs/volumes/ds-1/"})
+
+	if volume, ok := cache.get("vol-1"); !ok || volume.DatastoreUrl != "ds:///vmfs/volumes/ds-1/" {
+		t.Errorf("get() = %v, %v, expected the cached volume", volume, ok)
+	}
+	now = now.Add(volumeCacheTTL)
+	if _, ok := cache.get("vol-1"); ok {
+		t.Errorf("get() of an expired volume = true, expected false")
+	}
+	cache.add(cnstypes.CnsVolume{VolumeId: cnstypes.CnsVolumeId{Id: "vol-1"}, DatastoreUrl: "ds:///vmfs/volumes/ds-2/"})
+	if volume, ok := cache.get("vol-1"); !ok || volume.DatastoreUrl != "ds:///vmfs/volumes/ds-2/" {
+		t.Errorf("get() = %v, %v, expected the volume added again", volume, ok)
+	}
+	cache.remove("vol-1")
+	if _, ok := cache.get("vol-1"); ok {
+		t.Errorf("get() of a removed volume = true, expected false")
+	}
+}
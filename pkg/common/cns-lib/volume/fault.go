@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// Fault is the error of a CNS volume operation which failed with a vSphere fault.
+// It records what VMware support needs to look up the failed operation in vCenter.
+type Fault struct {
+	// Type is the type of the vSphere fault, e.g. NotFound
+	Type string
+	// Message is the localized message of the fault
+	Message string
+	// TaskID is the ID of the vCenter task of the operation
+	TaskID string
+	// OpID is the activation ID of the task, which correlates the operation across vCenter logs
+	OpID string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s (fault: %s, task: %s, opId: %s)", f.Message, f.Type, f.TaskID, f.OpID)
+}
+
+// FaultType returns the type of the vSphere fault, so that the fault is classified by cnsvsphere.ErrorCode
+func (f *Fault) FaultType() string {
+	return f.Type
+}
+
+// newFault returns the Fault of the given fault of a CNS volume operation of the task with the given info
+func newFault(fault *vimtypes.LocalizedMethodFault, taskInfo *vimtypes.TaskInfo) *Fault {
+	f := &Fault{Message: fault.LocalizedMessage, Type: cnsvsphere.FaultType(fault.Fault)}
+	if taskInfo != nil {
+		f.TaskID = taskInfo.Task.Value
+		f.OpID = taskInfo.ActivationId
+	}
+	return f
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	neturl "net/url"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+)
+
+// injectedTimeout is the net.Error of an injected timeout
+type injectedTimeout struct{}
+
+func (injectedTimeout) Error() string   { return "i/o timeout (injected)" }
+func (injectedTimeout) Timeout() bool   { return true }
+func (injectedTimeout) Temporary() bool { return true }
+
+// injectFault returns the error of a fault of the given kinds injected into the CNS operation, or nil if none is.
+// Injected errors look like the real ones, so that they take the same code paths.
+func injectFault(operation string, kinds ...faultinjection.Kind) error {
+	switch faultinjection.Inject(operation, kinds...) {
+	case faultinjection.Timeout:
+		return &neturl.Error{Op: "Post", URL: operation, Err: injectedTimeout{}}
+	case faultinjection.NotAuthenticated:
+		fault := &soap.Fault{Code: "ServerFaultCode", String: "The session is not authenticated. (injected)"}
+		fault.Detail.Fault = vimtypes.NotAuthenticated{}
+		return soap.WrapSoapFault(fault)
+	case faultinjection.TaskFailure:
+		return &Fault{Type: "SystemError", Message: "A general system error occurred: injected task failure"}
+	}
+	return nil
+}
+
+// submitTask submits the task of the CNS operation once the rate limit of vCenter allows it,
+// unless a fault is injected into the submission
+func submitTask(ctx context.Context, vc *cnsvsphere.VirtualCenter, operation string, submit func() (*object.Task, error)) (*object.Task, error) {
+	if err := injectFault(operation, faultinjection.Timeout, faultinjection.NotAuthenticated); err != nil {
+		return nil, err
+	}
+	if err := vc.WaitForAPI(ctx, cnsvsphere.ReconfigureAPI); err != nil {
+		return nil, err
+	}
+	return submit()
+}
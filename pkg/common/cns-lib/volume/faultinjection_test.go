@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+)
+
+func TestInjectFault(t *testing.T) {
+	defer faultinjection.Configure("", 1)
+	if err := faultinjection.Configure("timeout:timeout:1,notAuthenticated:not-authenticated:1,taskFailure:task-failure:1", 1); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	if err := injectFault("timeout", faultinjection.Timeout); !cnsvsphere.IsNetworkError(err) {
+		t.Errorf("expected an injected timeout to be a network error, got %v", err)
+	}
+	err := injectFault("notAuthenticated", faultinjection.NotAuthenticated)
+	if !soap.IsSoapFault(err) {
+		t.Fatalf("expected an injected NotAuthenticated to be a soap fault, got %v", err)
+	}
+	if _, ok := soap.ToSoapFault(err).VimFault().(vimtypes.NotAuthenticated); !ok {
+		t.Errorf("expected a NotAuthenticated fault, got %v", soap.ToSoapFault(err).VimFault())
+	}
+	if _, ok := injectFault("taskFailure", faultinjection.TaskFailure).(*Fault); !ok {
+		t.Errorf("expected an injected task failure to be a Fault")
+	}
+	if err := injectFault("other", faultinjection.Timeout, faultinjection.NotAuthenticated, faultinjection.TaskFailure); err != nil {
+		t.Errorf("expected no fault injected into other operations, got %v", err)
+	}
+}
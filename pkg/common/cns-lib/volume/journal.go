@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/cns"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// TaskJournal records the CNS tasks in flight, so that a restarted controller resumes waiting on the tasks it
+// submitted before restarting instead of submitting them again, which would create duplicate volumes.
+// Implementations persist the journal and are safe for concurrent use.
+type TaskJournal interface {
+	// Get returns the id of the task recorded under the given key, or an empty string
+	Get(key string) string
+	// Record records the task with the given id under the given key
+	Record(key string, taskID string)
+	// Remove removes the task recorded under the given key
+	Remove(key string)
+	// List returns the ids of the tasks recorded under keys with the given prefix, by the rest of their key
+	List(prefix string) map[string]string
+}
+
+// journaledTaskTypes are the types of the tasks recorded in the task journal
+var journaledTaskTypes = []string{
+	prometheus.TaskTypeCreateVolume,
+	prometheus.TaskTypeAttachVolume,
+	prometheus.TaskTypeDeleteVolume,
+}
+
+// taskJournal is the task journal in effect, nil if tasks are not journaled
+var taskJournal TaskJournal
+
+// SetTaskJournal sets the journal the CNS create, attach and delete tasks are recorded in
+func SetTaskJournal(journal TaskJournal) {
+	taskJournal = journal
+}
+
+// GetTaskJournal returns the task journal in effect, nil if tasks are not journaled
+func GetTaskJournal() TaskJournal {
+	return taskJournal
+}
+
+// GetJournalKey returns the key of the task of the given type on the given object on the vCenter with the given host
+// in the task journal
+func GetJournalKey(taskType string, host string, objectID string) string {
+	return fmt.Sprintf("%s.%s.%s", taskType, host, objectID)
+}
+
+// getJournalKey returns the key of the task of the given type on the given object in the task journal
+func (m *volumeManager) getJournalKey(taskType string, objectID string) string {
+	return GetJournalKey(taskType, m.virtualCenter.Config.Host, objectID)
+}
+
+// submitJournaledTask returns the task recorded under the given key in the task journal if vCenter still knows it,
+// as it was submitted before the controller restarted, otherwise it submits the task and records it. The task is
+// removed from the journal by waitForJournaledTask.
+func (m *volumeManager) submitJournaledTask(ctx context.Context, key string, taskType string,
+	submit func() (*object.Task, error)) (*object.Task, error) {
+	if taskJournal == nil {
+		return submitTask(ctx, m.virtualCenter, taskType, submit)
+	}
+	if taskID := taskJournal.Get(key); taskID != "" {
+		task := object.NewTask(m.virtualCenter.Client.Client, vimtypes.ManagedObjectReference{Type: "Task", Value: taskID})
+		var moTask mo.Task
+		err := task.Properties(ctx, task.Reference(), []string{"info.state"}, &moTask)
+		if err == nil {
+			klog.V(2).Infof("Resuming %s task %q recorded under %q", taskType, taskID, key)
+			return task, nil
+		}
+		klog.Warningf("%s task %q recorded under %q is gone, submitting it again. err=%v", taskType, taskID, key, err)
+		taskJournal.Remove(key)
+	}
+	task, err := submitTask(ctx, m.virtualCenter, taskType, submit)
+	if err != nil {
+		return nil, err
+	}
+	taskJournal.Record(key, task.Reference().Value)
+	return task, nil
+}
+
+// waitForJournaledTask waits for the task submitted by submitJournaledTask like waitForTask, then removes it
+// from the task journal, unless the task may still be in flight as ctx is done or vCenter could not be reached
+func (m *volumeManager) waitForJournaledTask(ctx context.Context, key string, task *object.Task,
+	taskType string) (*vimtypes.TaskInfo, error) {
+	taskInfo, err := waitForTask(ctx, m.virtualCenter, task, taskType)
+	if taskJournal != nil && ctx.Err() == nil && !cnsvsphere.IsNetworkError(err) {
+		taskJournal.Remove(key)
+	}
+	return taskInfo, err
+}
+
+// ReplayJournal resolves the tasks on the vCenter of the manager recorded in the task journal, which were in flight
+// when the controller restarted. Tasks still running are waited for. Tasks which failed or which vCenter no longer
+// knows are removed, as the retries of their operations submit them again. Tasks which succeeded are left for the
+// retries of their operations to resume, except CreateVolume tasks of volumes which are no longer wanted, as the
+// PVC they were created for is gone, whose volume is deleted along with its disk instead of being left unreferenced.
+func (m *volumeManager) ReplayJournal(ctx context.Context, isVolumeWanted func(name string) bool) {
+	if taskJournal == nil {
+		return
+	}
+	if err := m.virtualCenter.ConnectCNS(ctx); err != nil {
+		klog.Errorf("ConnectCNS failed with err: %+v", err)
+		return
+	}
+	for _, taskType := range journaledTaskTypes {
+		for objectID, taskID := range taskJournal.List(m.getJournalKey(taskType, "")) {
+			m.replayTask(ctx, taskType, objectID, taskID, isVolumeWanted)
+		}
+	}
+}
+
+// replayTask resolves the task with the given id of the given type on the given object recorded in the task
+// journal, see ReplayJournal
+func (m *volumeManager) replayTask(ctx context.Context, taskType string, objectID string, taskID string,
+	isVolumeWanted func(name string) bool) {
+	key := m.getJournalKey(taskType, objectID)
+	klog.V(2).Infof("Replaying %s task %q recorded under %q", taskType, taskID, key)
+	task := object.NewTask(m.virtualCenter.Client.Client, vimtypes.ManagedObjectReference{Type: "Task", Value: taskID})
+	taskInfo, err := waitForTask(ctx, m.virtualCenter, task, taskType)
+	if err != nil {
+		if ctx.Err() == nil && !cnsvsphere.IsNetworkError(err) {
+			klog.V(2).Infof("%s task %q recorded under %q failed or is gone, the retry submits it again. err=%v", taskType, taskID, key, err)
+			taskJournal.Remove(key)
+		}
+		return
+	}
+	if taskType != prometheus.TaskTypeCreateVolume || isVolumeWanted(objectID) {
+		return
+	}
+	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
+	if err != nil || taskResult == nil {
+		klog.Warningf("Failed to get the result of %s task %q recorded under %q. err=%v", taskType, taskID, key, err)
+		return
+	}
+	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
+	if volumeOperationRes.Fault != nil {
+		taskJournal.Remove(key)
+		return
+	}
+	volumeID := volumeOperationRes.VolumeId.Id
+	klog.Warningf("Rolling back volume %s %q created by %s task %q before the controller restarted, as it is no longer wanted",
+		volumeID, objectID, taskType, taskID)
+	if err = m.DeleteVolume(ctx, volumeID, true); err != nil {
+		klog.Warningf("Failed to roll back volume %s %q. err=%v", volumeID, objectID, err)
+		return
+	}
+	taskJournal.Remove(key)
+}
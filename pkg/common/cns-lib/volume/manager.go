@@ -20,33 +20,60 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/vmware/govmomi/cns"
 	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/soap"
 	vimtypes "github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
 )
 
 // Manager provides functionality to manage volumes.
 type Manager interface {
 	// CreateVolume creates a new volume given its spec.
-	CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error)
+	CreateVolume(ctx context.Context, spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error)
 	// AttachVolume attaches a volume to a virtual machine given the spec.
-	AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) (string, error)
+	AttachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error)
+	// AttachVolumes attaches volumes to a virtual machine in a single CNS task. It returns the disk UUIDs of the
+	// volumes attached and the errors of the volumes which failed to attach, by volume id.
+	AttachVolumes(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeIDs []string) (map[string]string, map[string]error)
 	// DetachVolume detaches a volume from the virtual machine given the spec.
-	DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) error
+	DetachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error
 	// DeleteVolume deletes a volume given its spec.
-	DeleteVolume(volumeID string, deleteDisk bool) error
+	DeleteVolume(ctx context.Context, volumeID string, deleteDisk bool) error
 	// UpdateVolumeMetadata updates a volume metadata given its spec.
-	UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpdateSpec) error
+	UpdateVolumeMetadata(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error
 	// QueryVolume returns volumes matching the given filter.
-	QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error)
+	QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error)
 	// QueryAllVolume returns all volumes matching the given filter and selection.
-	QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error)
+	QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error)
+	// GetVolume returns the volume with the given id from the volume cache, querying CNS on a cache miss.
+	// Only fields which do not change over the lifetime of the volume, e.g. its name and type, may be read
+	// from the returned volume. Returns nil if the volume is not found.
+	GetVolume(volumeID string) (*cnstypes.CnsVolume, error)
+	// RefreshVolume queries the volume with the given id from CNS and replaces it in the volume cache. Used to
+	// read fields which change over the lifetime of the volume, e.g. its datastore, which changes when the volume
+	// is relocated by Storage vMotion, or its capacity. Returns nil if the volume is not found.
+	RefreshVolume(volumeID string) (*cnstypes.CnsVolume, error)
+	// WarmCache pages through the volumes matching the given filter and adds them to the volume cache.
+	WarmCache(queryFilter cnstypes.CnsQueryFilter) error
+	// EvictVolume evicts the volume with the given id from the volume cache, so that it is queried from CNS
+	// on its next use. Used after changing the volume outside of CNS.
+	EvictVolume(volumeID string)
+	// ReplayJournal resolves the tasks on the vCenter recorded in the task journal before the controller restarted,
+	// deleting the volumes created by CreateVolume tasks for which isVolumeWanted returns false.
+	ReplayJournal(ctx context.Context, isVolumeWanted func(name string) bool)
 }
 
 var (
@@ -60,26 +87,41 @@ var (
 func GetManager(vc *cnsvsphere.VirtualCenter) Manager {
 	onceForManager.Do(func() {
 		klog.V(1).Infof("Initializing volume.volumeManager...")
-		managerInstance = &volumeManager{
-			virtualCenter: vc,
-		}
+		managerInstance = newVolumeManager(vc)
+		supportbundle.Register("volume-cache.json", supportbundle.JSONCollector(func() interface{} {
+			return managerInstance.cache.list()
+		}))
 		klog.V(1).Infof("volume.volumeManager initialized")
 	})
 	return managerInstance
 }
 
+// NewManager returns a Manager of the volumes of the given vCenter other than the one of the Manager singleton,
+// for clusters whose node VMs span several vCenters
+func NewManager(vc *cnsvsphere.VirtualCenter) Manager {
+	return newVolumeManager(vc)
+}
+
+func newVolumeManager(vc *cnsvsphere.VirtualCenter) *volumeManager {
+	return &volumeManager{
+		virtualCenter: vc,
+		cache:         newVolumeCache(),
+	}
+}
+
 // DefaultManager provides functionality to manage volumes.
 type volumeManager struct {
 	virtualCenter *cnsvsphere.VirtualCenter
+	cache         *volumeCache
 }
 
 // CreateVolume creates a new volume given its spec.
-func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error) {
+func (m *volumeManager) CreateVolume(ctx context.Context, spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -98,17 +140,34 @@ func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnsty
 		spec.Metadata.ContainerCluster.VSphereUser = s.UserName
 	}
 
+	var volumeID *cnstypes.CnsVolumeId
+	err = retryTransient(ctx, prometheus.TaskTypeCreateVolume, func() (err error) {
+		volumeID, err = m.createVolume(ctx, spec)
+		return err
+	})
+	if volumeID != nil {
+		// CNS returns the existing volume if the create is a retry of an earlier one
+		m.cache.remove(volumeID.Id)
+	}
+	return volumeID, err
+}
+
+// createVolume creates a new volume given its spec, once
+func (m *volumeManager) createVolume(ctx context.Context, spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error) {
 	// Construct the CNS VolumeCreateSpec list
 	var cnsCreateSpecList []cnstypes.CnsVolumeCreateSpec
 	cnsCreateSpecList = append(cnsCreateSpecList, *spec)
 	// Call the CNS CreateVolume
-	task, err := m.virtualCenter.CnsClient.CreateVolume(ctx, cnsCreateSpecList)
+	journalKey := m.getJournalKey(prometheus.TaskTypeCreateVolume, spec.Name)
+	task, err := m.submitJournaledTask(ctx, journalKey, prometheus.TaskTypeCreateVolume, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.CreateVolume(ctx, cnsCreateSpecList)
+	})
 	if err != nil {
-		klog.Errorf("CNS CreateVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS CreateVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return nil, err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := m.waitForJournaledTask(ctx, journalKey, task, prometheus.TaskTypeCreateVolume)
 	if err != nil {
 		klog.Errorf("Failed to get taskInfo for CreateVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
 		return nil, err
@@ -130,7 +189,7 @@ func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnsty
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
 		klog.Errorf("failed to create cns volume. createSpec: %q, fault: %q, opId: %q", spew.Sdump(spec), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return nil, errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		return nil, newFault(volumeOperationRes.Fault, taskInfo)
 	}
 	klog.V(2).Infof("CreateVolume: Volume created successfully. VolumeName: %q, opId: %q, volumeID: %q", spec.Name, taskInfo.ActivationId, volumeOperationRes.VolumeId.Id)
 	return &cnstypes.CnsVolumeId{
@@ -139,12 +198,12 @@ func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnsty
 }
 
 // AttachVolume attaches a volume to a virtual machine given the spec.
-func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
+func (m *volumeManager) AttachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
 	err := validateManager(m)
 	if err != nil {
 		return "", err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// Set up the VC connection
@@ -153,6 +212,17 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 		klog.Errorf("ConnectCNS failed with err: %+v", err)
 		return "", err
 	}
+	m.cache.remove(volumeID)
+	var diskUUID string
+	err = retryTransient(ctx, prometheus.TaskTypeAttachVolume, func() (err error) {
+		diskUUID, err = m.attachVolume(ctx, vm, volumeID)
+		return err
+	})
+	return diskUUID, err
+}
+
+// attachVolume attaches a volume to a virtual machine, once
+func (m *volumeManager) attachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
 	// Construct the CNS AttachSpec list
 	var cnsAttachSpecList []cnstypes.CnsVolumeAttachDetachSpec
 	cnsAttachSpec := cnstypes.CnsVolumeAttachDetachSpec{
@@ -163,13 +233,16 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 	}
 	cnsAttachSpecList = append(cnsAttachSpecList, cnsAttachSpec)
 	// Call the CNS AttachVolume
-	task, err := m.virtualCenter.CnsClient.AttachVolume(ctx, cnsAttachSpecList)
+	journalKey := m.getJournalKey(prometheus.TaskTypeAttachVolume, volumeID+"."+vm.UUID)
+	task, err := m.submitJournaledTask(ctx, journalKey, prometheus.TaskTypeAttachVolume, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.AttachVolume(ctx, cnsAttachSpecList)
+	})
 	if err != nil {
-		klog.Errorf("CNS AttachVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS AttachVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return "", err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := m.waitForJournaledTask(ctx, journalKey, task, prometheus.TaskTypeAttachVolume)
 	if err != nil {
 		klog.Errorf("Failed to get taskInfo for AttachVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
 		return "", err
@@ -188,6 +261,13 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 		return "", errors.New("taskResult is empty")
 	}
 
+	return m.processAttachResult(ctx, vm, volumeID, taskResult, taskInfo)
+}
+
+// processAttachResult returns the disk UUID of the volume attached to the virtual machine by the task with the
+// given info and result of the volume, making sure the volume outlives the VM
+func (m *volumeManager) processAttachResult(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string,
+	taskResult cnstypes.BaseCnsVolumeOperationResult, taskInfo *vimtypes.TaskInfo) (string, error) {
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
 		if volumeOperationRes.Fault.LocalizedMessage == CNSVolumeResourceInUseFaultMessage {
@@ -197,24 +277,116 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 				return "", err
 			}
 			if diskUUID != "" {
+				// Volumes attached before keepAfterDeleteVm was enforced may not have it set.
+				if err = EnsureKeepAfterDeleteVM(ctx, m.virtualCenter, vm, volumeID); err != nil {
+					klog.Errorf("Failed to set keepAfterDeleteVm on volume %q attached to vm %q with err: %v", volumeID, vm.String(), err)
+				}
 				return diskUUID, nil
 			}
 		}
 		klog.Errorf("failed to attach cns volume: %q to node vm: %q. fault: %q. opId: %q", volumeID, vm.String(), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return "", errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		return "", newFault(volumeOperationRes.Fault, taskInfo)
 	}
 	diskUUID := interface{}(taskResult).(*cnstypes.CnsVolumeAttachResult).DiskUUID
+	// Make sure the volume outlives the VM it is attached to. The volume is attached regardless, hence failing
+	// to do so does not fail the attach, which would otherwise be retried against an attached volume.
+	if err := EnsureKeepAfterDeleteVM(ctx, m.virtualCenter, vm, volumeID); err != nil {
+		klog.Errorf("Failed to set keepAfterDeleteVm on volume %q attached to vm %q with err: %v. opId: %q", volumeID, vm.String(), err, taskInfo.ActivationId)
+	}
 	klog.V(2).Infof("AttachVolume: Volume attached successfully. volumeID: %q, opId: %q, vm: %q, diskUUID: %q", volumeID, taskInfo.ActivationId, vm.String(), diskUUID)
 	return diskUUID, nil
 }
 
+// AttachVolumes attaches volumes to a virtual machine in a single CNS task. Volumes failing to attach in the task
+// with a transient fault are attached on their own, with retries.
+func (m *volumeManager) AttachVolumes(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeIDs []string) (map[string]string, map[string]error) {
+	diskUUIDs := make(map[string]string)
+	errs := make(map[string]error)
+	failAll := func(err error) (map[string]string, map[string]error) {
+		for _, volumeID := range volumeIDs {
+			errs[volumeID] = err
+		}
+		return diskUUIDs, errs
+	}
+	if err := validateManager(m); err != nil {
+		return failAll(err)
+	}
+	for _, volumeID := range volumeIDs {
+		m.cache.remove(volumeID)
+	}
+	if len(volumeIDs) == 1 {
+		diskUUID, err := m.AttachVolume(ctx, vm, volumeIDs[0])
+		if err != nil {
+			return failAll(err)
+		}
+		diskUUIDs[volumeIDs[0]] = diskUUID
+		return diskUUIDs, errs
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Set up the VC connection
+	if err := m.virtualCenter.ConnectCNS(ctx); err != nil {
+		klog.Errorf("ConnectCNS failed with err: %+v", err)
+		return failAll(err)
+	}
+	var cnsAttachSpecList []cnstypes.CnsVolumeAttachDetachSpec
+	for _, volumeID := range volumeIDs {
+		cnsAttachSpecList = append(cnsAttachSpecList, cnstypes.CnsVolumeAttachDetachSpec{
+			VolumeId: cnstypes.CnsVolumeId{Id: volumeID},
+			Vm:       vm.Reference(),
+		})
+	}
+	var taskInfo *vimtypes.TaskInfo
+	err := retryTransient(ctx, prometheus.TaskTypeAttachVolume, func() error {
+		task, err := submitTask(ctx, m.virtualCenter, prometheus.TaskTypeAttachVolume, func() (*object.Task, error) {
+			return m.virtualCenter.CnsClient.AttachVolume(ctx, cnsAttachSpecList)
+		})
+		if err != nil {
+			klog.Errorf("CNS AttachVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
+			return err
+		}
+		taskInfo, err = waitForTask(ctx, m.virtualCenter, task, prometheus.TaskTypeAttachVolume)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("Failed to attach volumes %v to vm %q in a batch from vCenter %q with err: %v", volumeIDs, vm.String(), m.virtualCenter.Config.Host, err)
+		return failAll(err)
+	}
+	klog.V(2).Infof("AttachVolumes: volumeIDs: %v, vm: %q, opId: %q", volumeIDs, vm.String(), taskInfo.ActivationId)
+	results := make(map[string]cnstypes.BaseCnsVolumeOperationResult)
+	if batchResult, ok := taskInfo.Result.(cnstypes.CnsVolumeOperationBatchResult); ok {
+		for _, result := range batchResult.VolumeResults {
+			results[result.GetCnsVolumeOperationResult().VolumeId.Id] = result
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		result, ok := results[volumeID]
+		if !ok {
+			klog.Errorf("taskResult of volume %q is missing from AttachVolume task: %q, opId: %q", volumeID, taskInfo.Task.Value, taskInfo.ActivationId)
+			errs[volumeID] = errors.New("taskResult is empty")
+			continue
+		}
+		diskUUID, err := m.processAttachResult(ctx, vm, volumeID, result, taskInfo)
+		if err != nil && cnsvsphere.IsTransientError(err) {
+			klog.V(2).Infof("Attaching volume %q to vm %q on its own after transient fault in batch: %v", volumeID, vm.String(), err)
+			diskUUID, err = m.AttachVolume(ctx, vm, volumeID)
+		}
+		if err != nil {
+			errs[volumeID] = err
+			continue
+		}
+		diskUUIDs[volumeID] = diskUUID
+	}
+	return diskUUIDs, errs
+}
+
 // DetachVolume detaches a volume from the virtual machine given the spec.
-func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) error {
+func (m *volumeManager) DetachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -222,6 +394,14 @@ func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 		klog.Errorf("ConnectCNS failed with err: %+v", err)
 		return err
 	}
+	m.cache.remove(volumeID)
+	return retryTransient(ctx, prometheus.TaskTypeDetachVolume, func() error {
+		return m.detachVolume(ctx, vm, volumeID)
+	})
+}
+
+// detachVolume detaches a volume from the virtual machine, once
+func (m *volumeManager) detachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error {
 	// Construct the CNS DetachSpec list
 	var cnsDetachSpecList []cnstypes.CnsVolumeAttachDetachSpec
 	cnsDetachSpec := cnstypes.CnsVolumeAttachDetachSpec{
@@ -232,13 +412,15 @@ func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 	}
 	cnsDetachSpecList = append(cnsDetachSpecList, cnsDetachSpec)
 	// Call the CNS DetachVolume
-	task, err := m.virtualCenter.CnsClient.DetachVolume(ctx, cnsDetachSpecList)
+	task, err := submitTask(ctx, m.virtualCenter, prometheus.TaskTypeDetachVolume, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.DetachVolume(ctx, cnsDetachSpecList)
+	})
 	if err != nil {
-		klog.Errorf("CNS DetachVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS DetachVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := waitForTask(ctx, m.virtualCenter, task, prometheus.TaskTypeDetachVolume)
 	if err != nil {
 		klog.Errorf("Failed to get taskInfo for DetachVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
 		return err
@@ -261,19 +443,20 @@ func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 
 	if volumeOperationRes.Fault != nil {
 		klog.Errorf("failed to detach cns volume:%q from node vm: %q. fault: %q, opId: %q", volumeID, vm.InventoryPath, spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		return newFault(volumeOperationRes.Fault, taskInfo)
 	}
 	klog.V(2).Infof("DetachVolume: Volume detached successfully. volumeID: %q, vm: %q, opId: %q", volumeID, taskInfo.ActivationId, vm.String())
 	return nil
 }
 
 // DeleteVolume deletes a volume given its spec.
-func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
+func (m *volumeManager) DeleteVolume(ctx context.Context, volumeID string, deleteDisk bool) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	m.cache.remove(volumeID)
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -281,6 +464,13 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 		klog.Errorf("ConnectCNS failed with err: %+v", err)
 		return err
 	}
+	return retryTransient(ctx, prometheus.TaskTypeDeleteVolume, func() error {
+		return m.deleteVolume(ctx, volumeID, deleteDisk)
+	})
+}
+
+// deleteVolume deletes a volume, once
+func (m *volumeManager) deleteVolume(ctx context.Context, volumeID string, deleteDisk bool) error {
 	// Construct the CNS VolumeId list
 	var cnsVolumeIDList []cnstypes.CnsVolumeId
 	cnsVolumeID := cnstypes.CnsVolumeId{
@@ -288,7 +478,10 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 	}
 	// Call the CNS DeleteVolume
 	cnsVolumeIDList = append(cnsVolumeIDList, cnsVolumeID)
-	task, err := m.virtualCenter.CnsClient.DeleteVolume(ctx, cnsVolumeIDList, deleteDisk)
+	journalKey := m.getJournalKey(prometheus.TaskTypeDeleteVolume, volumeID)
+	task, err := m.submitJournaledTask(ctx, journalKey, prometheus.TaskTypeDeleteVolume, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.DeleteVolume(ctx, cnsVolumeIDList, deleteDisk)
+	})
 	if err != nil {
 		if soap.IsSoapFault(err) {
 			soapFault := soap.ToSoapFault(err)
@@ -297,11 +490,11 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 				return nil
 			}
 		}
-		klog.Errorf("CNS DeleteVolume failed from the  vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS DeleteVolume failed from the vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := m.waitForJournaledTask(ctx, journalKey, task, prometheus.TaskTypeDeleteVolume)
 	if err != nil {
 		klog.Errorf("Failed to get taskInfo for DeleteVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
 		return err
@@ -322,19 +515,19 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
 		klog.Errorf("Failed to delete volume: %q, fault: %q, opID: %q", volumeID, spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		return newFault(volumeOperationRes.Fault, taskInfo)
 	}
 	klog.V(2).Infof("DeleteVolume: Volume deleted successfully. volumeID: %q, opId: %q", volumeID, taskInfo.ActivationId)
 	return nil
 }
 
 // UpdateVolume updates a volume given its spec.
-func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+func (m *volumeManager) UpdateVolumeMetadata(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -352,6 +545,7 @@ func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpd
 		klog.V(4).Infof("Update VSphereUser from %s to %s", spec.Metadata.ContainerCluster.VSphereUser, s.UserName)
 		spec.Metadata.ContainerCluster.VSphereUser = s.UserName
 	}
+	m.cache.remove(spec.VolumeId.Id)
 
 	var cnsUpdateSpecList []cnstypes.CnsVolumeMetadataUpdateSpec
 	cnsUpdateSpec := cnstypes.CnsVolumeMetadataUpdateSpec{
@@ -361,13 +555,15 @@ func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpd
 		Metadata: spec.Metadata,
 	}
 	cnsUpdateSpecList = append(cnsUpdateSpecList, cnsUpdateSpec)
-	task, err := m.virtualCenter.CnsClient.UpdateVolumeMetadata(ctx, cnsUpdateSpecList)
+	task, err := submitTask(ctx, m.virtualCenter, prometheus.TaskTypeUpdateVolumeMetadata, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.UpdateVolumeMetadata(ctx, cnsUpdateSpecList)
+	})
 	if err != nil {
-		klog.Errorf("CNS UpdateVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS UpdateVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := waitForTask(ctx, m.virtualCenter, task, prometheus.TaskTypeUpdateVolumeMetadata)
 	if err != nil {
 		klog.Errorf("Failed to get taskInfo for UpdateVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
 		return err
@@ -388,19 +584,19 @@ func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpd
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
 		klog.Errorf("Failed to update volume. updateSpec: %q, fault: %q, opID: %q", spew.Sdump(spec), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		return newFault(volumeOperationRes.Fault, taskInfo)
 	}
 	klog.V(2).Infof("UpdateVolumeMetadata: Volume metadata updated successfully. volumeID: %q, opId: %q", spec.VolumeId.Id, taskInfo.ActivationId)
 	return nil
 }
 
 // QueryVolume returns volumes matching the given filter.
-func (m *volumeManager) QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
+func (m *volumeManager) QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -409,21 +605,33 @@ func (m *volumeManager) QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnsty
 		return nil, err
 	}
 	//Call the CNS QueryVolume
-	res, err := m.virtualCenter.CnsClient.QueryVolume(ctx, queryFilter)
+	var res *cnstypes.CnsQueryResult
+	start := time.Now()
+	defer slowlog.ObserveCNS("QueryVolume", "", start)
+	err = backoff.OnError(ctx, "CNS QueryVolume", cnsvsphere.IsNetworkError, func() (err error) {
+		if err = injectFault("queryVolume", faultinjection.Timeout, faultinjection.NotAuthenticated); err != nil {
+			return err
+		}
+		if err = m.virtualCenter.WaitForAPI(ctx, cnsvsphere.QueryAPI); err != nil {
+			return err
+		}
+		res, err = m.virtualCenter.CnsClient.QueryVolume(ctx, queryFilter)
+		return err
+	})
 	if err != nil {
-		klog.Errorf("CNS QueryVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS QueryVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return nil, err
 	}
 	return res, err
 }
 
 // QueryAllVolume returns all volumes matching the given filter and selection.
-func (m *volumeManager) QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
+func (m *volumeManager) QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -432,10 +640,70 @@ func (m *volumeManager) QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, quer
 		return nil, err
 	}
 	//Call the CNS QueryAllVolume
-	res, err := m.virtualCenter.CnsClient.QueryAllVolume(ctx, queryFilter, querySelection)
+	var res *cnstypes.CnsQueryResult
+	start := time.Now()
+	defer slowlog.ObserveCNS("QueryAllVolume", "", start)
+	err = backoff.OnError(ctx, "CNS QueryAllVolume", cnsvsphere.IsNetworkError, func() (err error) {
+		if err = injectFault("queryAllVolume", faultinjection.Timeout, faultinjection.NotAuthenticated); err != nil {
+			return err
+		}
+		if err = m.virtualCenter.WaitForAPI(ctx, cnsvsphere.QueryAPI); err != nil {
+			return err
+		}
+		res, err = m.virtualCenter.CnsClient.QueryAllVolume(ctx, queryFilter, querySelection)
+		return err
+	})
 	if err != nil {
-		klog.Errorf("CNS QueryAllVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS QueryAllVolume failed from vCenter %q with err: %v. opId: %q", m.virtualCenter.Config.Host, err, opid.FromContext(ctx))
 		return nil, err
 	}
 	return res, err
 }
+
+// GetVolume returns the volume with the given id from the volume cache, querying CNS on a cache miss.
+// Only fields which do not change over the lifetime of the volume may be read from the returned volume.
+// Returns nil if the volume is not found.
+func (m *volumeManager) GetVolume(volumeID string) (*cnstypes.CnsVolume, error) {
+	if volume, ok := m.cache.get(volumeID); ok {
+		return &volume, nil
+	}
+	queryFilter := cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	}
+	queryResult, err := m.QueryVolume(context.Background(), queryFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryResult.Volumes) == 0 {
+		return nil, nil
+	}
+	m.cache.add(queryResult.Volumes[0])
+	return &queryResult.Volumes[0], nil
+}
+
+// WarmCache pages through the volumes matching the given filter and adds them to the volume cache.
+func (m *volumeManager) WarmCache(queryFilter cnstypes.CnsQueryFilter) error {
+	err := ForEachVolumePage(context.Background(), m, queryFilter, func(volumes []cnstypes.CnsVolume) error {
+		m.cache.add(volumes...)
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("Failed to warm volume cache with err: %v", err)
+		return err
+	}
+	klog.V(2).Infof("Volume cache warmed with %d volumes", m.cache.len())
+	return nil
+}
+
+// RefreshVolume queries the volume with the given id from CNS and replaces it in the volume cache.
+// Returns nil if the volume is not found.
+func (m *volumeManager) RefreshVolume(volumeID string) (*cnstypes.CnsVolume, error) {
+	m.cache.remove(volumeID)
+	return m.GetVolume(volumeID)
+}
+
+// EvictVolume evicts the volume with the given id from the volume cache, so that it is queried from CNS
+// on its next use. Used after changing the volume outside of CNS.
+func (m *volumeManager) EvictVolume(volumeID string) {
+	m.cache.remove(volumeID)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere/fake"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+)
+
+func TestVolumeOperations(t *testing.T) {
+	cnsClient := fake.NewCNSClient()
+	m := newVolumeManager(&cnsvsphere.VirtualCenter{
+		Config:    &cnsvsphere.VirtualCenterConfig{Host: "vc"},
+		CnsClient: cnsClient,
+	})
+	ctx := opid.NewContext(context.Background(), "csi-0000002a")
+	volumeID, err := m.createVolume(ctx, &cnstypes.CnsVolumeCreateSpec{Name: "pvc-1", VolumeType: "BLOCK"})
+	if err != nil {
+		t.Fatalf("createVolume() failed: %v", err)
+	}
+	ref := vimtypes.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	vm := &cnsvsphere.VirtualMachine{VirtualMachine: object.NewVirtualMachine(nil, ref)}
+	if _, err := cnsClient.AttachVolume(ctx, []cnstypes.CnsVolumeAttachDetachSpec{{VolumeId: *volumeID, Vm: ref}}); err != nil {
+		t.Fatalf("AttachVolume() failed: %v", err)
+	}
+
+	err = m.deleteVolume(ctx, volumeID.Id, true)
+	if fault, ok := err.(*Fault); !ok || fault.Type != "ResourceInUse" || fault.OpID != "csi-0000002a" {
+		t.Errorf("deleteVolume() of an attached volume = %v, expected a ResourceInUse fault of opId csi-0000002a", err)
+	}
+	if err := m.detachVolume(ctx, vm, volumeID.Id); err != nil {
+		t.Errorf("detachVolume() failed: %v", err)
+	}
+	if err := m.deleteVolume(ctx, volumeID.Id, true); err != nil {
+		t.Errorf("deleteVolume() failed: %v", err)
+	}
+	err = m.deleteVolume(ctx, volumeID.Id, true)
+	if fault, ok := err.(*Fault); !ok || fault.Type != "NotFound" {
+		t.Errorf("deleteVolume() of a deleted volume = %v, expected a NotFound fault", err)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// defaultRetryBudget is how long the CNS operations without a budget of their own are retried
+const defaultRetryBudget = time.Minute
+
+// retryBudgets are how long the CNS operations of each task type are retried on transient failures.
+// They are well below the 5 minute timeout of the CSI sidecars, so that the sidecars get a response
+// before giving up on the call.
+var retryBudgets = map[string]time.Duration{
+	prometheus.TaskTypeCreateVolume: 2 * time.Minute,
+	prometheus.TaskTypeAttachVolume: 2 * time.Minute,
+	prometheus.TaskTypeDetachVolume: 2 * time.Minute,
+	prometheus.TaskTypeDeleteVolume: time.Minute,
+}
+
+// retryTransient calls fn until it succeeds or fails with an error which is not transient, with the retry policy
+// in effect, within the retry budget of the task type. Short vCenter outages and tasks failing because another
+// task holds the VM or the volume then do not fail the CSI call.
+func retryTransient(ctx context.Context, taskType string, fn func() error) error {
+	budget, ok := retryBudgets[taskType]
+	if !ok {
+		budget = defaultRetryBudget
+	}
+	return backoff.OnErrorWithin(ctx, "CNS "+taskType, budget, cnsvsphere.IsTransientError, fn)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
+)
+
+const (
+	// maxSCSIControllers is the number of SCSI controllers a VM can have
+	maxSCSIControllers = 4
+	// scsiControllerUnits is the number of units of SCSI controllers, one of which is taken by the controller
+	scsiControllerUnits = 16
+	// pvscsiControllerUnits is the number of units of PVSCSI controllers from hardware version 14 on
+	pvscsiControllerUnits = 65
+	// pvscsiHardwareVersion is the first hardware version whose PVSCSI controllers have 64 targets
+	pvscsiHardwareVersion = 14
+)
+
+// EnsurePVSCSISlot adds a PVSCSI controller to the VM if none of its PVSCSI controllers has a free disk slot
+// left and the VM has fewer SCSI controllers than it can have, so that a volume can be attached to it.
+func EnsurePVSCSISlot(ctx context.Context, vm *cnsvsphere.VirtualMachine) error {
+	var vmMo mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware.device", "config.version"}, &vmMo)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return err
+	}
+	if vmMo.Config == nil {
+		return nil
+	}
+	vmDevices := object.VirtualDeviceList(vmMo.Config.Hardware.Device)
+	if !needsPVSCSIController(vmDevices, vmMo.Config.Version) {
+		return nil
+	}
+	controller, err := vmDevices.CreateSCSIController("pvscsi")
+	if err != nil {
+		klog.Errorf("Failed to create PVSCSI controller for vm: %s", vm.InventoryPath)
+		return err
+	}
+	klog.V(2).Infof("PVSCSI controllers of vm %s are full, adding a PVSCSI controller", vm.InventoryPath)
+	start := time.Now()
+	err = vm.AddDevice(ctx, controller)
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeAddSCSIController, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeAddSCSIController, "", start)
+	if err != nil {
+		klog.Errorf("Failed to add PVSCSI controller to vm %s with err: %v", vm.InventoryPath, err)
+	}
+	return err
+}
+
+// needsPVSCSIController returns true if the devices of a VM of the given hardware version include PVSCSI
+// controllers, none of which has a free disk slot left, and a SCSI controller can still be added to the VM
+func needsPVSCSIController(devices object.VirtualDeviceList, hardwareVersion string) bool {
+	version, _ := strconv.Atoi(strings.TrimPrefix(hardwareVersion, "vmx-"))
+	controllers := devices.SelectByType((*vimtypes.VirtualSCSIController)(nil))
+	if len(controllers) >= maxSCSIControllers {
+		return false
+	}
+	full := false
+	for _, device := range controllers {
+		pvscsi, ok := device.(*vimtypes.ParaVirtualSCSIController)
+		if !ok {
+			continue
+		}
+		units := scsiControllerUnits
+		if version >= pvscsiHardwareVersion {
+			units = pvscsiControllerUnits
+		}
+		// One unit is taken by the controller itself
+		if len(pvscsi.Device) < units-1 {
+			return false
+		}
+		full = true
+	}
+	return full
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+func TestNeedsPVSCSIController(t *testing.T) {
+	pvscsi := func(disks int) vimtypes.BaseVirtualDevice {
+		return &vimtypes.ParaVirtualSCSIController{VirtualSCSIController: vimtypes.VirtualSCSIController{
+			VirtualController: vimtypes.VirtualController{Device: make([]int32, disks)}}}
+	}
+	lsiLogic := func(disks int) vimtypes.BaseVirtualDevice {
+		return &vimtypes.VirtualLsiLogicController{VirtualSCSIController: vimtypes.VirtualSCSIController{
+			VirtualController: vimtypes.VirtualController{Device: make([]int32, disks)}}}
+	}
+	tests := []struct {
+		name            string
+		devices         object.VirtualDeviceList
+		hardwareVersion string
+		expected        bool
+	}{
+		{"no SCSI controller", nil, "vmx-14", false},
+		{"LSI Logic only", object.VirtualDeviceList{lsiLogic(15)}, "vmx-14", false},
+		{"free PVSCSI slot", object.VirtualDeviceList{pvscsi(63)}, "vmx-14", false},
+		{"full PVSCSI", object.VirtualDeviceList{pvscsi(64)}, "vmx-14", true},
+		{"full PVSCSI before hardware version 14", object.VirtualDeviceList{pvscsi(15)}, "vmx-13", true},
+		{"one of two PVSCSI full", object.VirtualDeviceList{pvscsi(64), pvscsi(1)}, "vmx-14", false},
+		{"full PVSCSI and full LSI Logic", object.VirtualDeviceList{lsiLogic(15), pvscsi(64)}, "vmx-14", true},
+		{"four full controllers", object.VirtualDeviceList{pvscsi(64), pvscsi(64), pvscsi(64), pvscsi(64)}, "vmx-14", false},
+	}
+	for _, test := range tests {
+		if actual := needsPVSCSIController(test.devices, test.hardwareVersion); actual != test.expected {
+			t.Errorf("%s: needsPVSCSIController() = %t, expected %t", test.name, actual, test.expected)
+		}
+	}
+}
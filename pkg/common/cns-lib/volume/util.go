@@ -19,11 +19,20 @@ package volume
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	govmomitask "github.com/vmware/govmomi/task"
 	vimtypes "github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/faultinjection"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
 )
 
 // version and namespace constants for task client
@@ -40,6 +49,68 @@ func validateManager(m *volumeManager) error {
 	return nil
 }
 
+// waitForTask waits for the CNS task of the given type submitted to the virtual center to complete and returns its info.
+// Failures to reach vCenter while polling the task are retried with the retry policy in effect.
+// If the task fails, a Fault is returned. The duration of the task is recorded in the task duration metric.
+func waitForTask(ctx context.Context, vc *cnsvsphere.VirtualCenter, task *object.Task, taskType string) (*vimtypes.TaskInfo, error) {
+	var taskInfo *vimtypes.TaskInfo
+	start := time.Now()
+	err := backoff.OnError(ctx, "Polling task "+task.Reference().Value, cnsvsphere.IsNetworkError, func() (err error) {
+		taskInfo, err = vc.CnsClient.GetTaskInfo(ctx, task)
+		return err
+	})
+	prometheus.ObserveVCenterTask(taskType, start, err)
+	var opID string
+	if taskInfo != nil {
+		opID = taskInfo.ActivationId
+	}
+	slowlog.ObserveCNS(taskType, opID, start)
+	if err == nil {
+		// The task failure is injected after the task completed, so that retries of the operation
+		// find it already done
+		if err = injectFault(taskType, faultinjection.TaskFailure); err != nil {
+			return nil, err
+		}
+	}
+	if taskErr, ok := err.(govmomitask.Error); ok {
+		return nil, &Fault{
+			Type:    cnsvsphere.FaultType(taskErr.Fault()),
+			Message: taskErr.LocalizedMessage,
+			TaskID:  task.Reference().Value,
+		}
+	}
+	return taskInfo, err
+}
+
+// ForEachVolumePage pages through the volumes matching the given filter using CNS QueryVolume
+// and calls processPage for each page of volumes, so that all volumes need not be held in memory at once.
+func ForEachVolumePage(ctx context.Context, m Manager, queryFilter cnstypes.CnsQueryFilter, processPage func(volumes []cnstypes.CnsVolume) error) error {
+	queryFilter.Cursor = &cnstypes.CnsCursor{
+		Limit: queryPageSize,
+	}
+	for {
+		queryResult, err := m.QueryVolume(ctx, queryFilter)
+		if err != nil {
+			return err
+		}
+		if err = processPage(queryResult.Volumes); err != nil {
+			return err
+		}
+		queryFilter.Cursor.Offset += int64(len(queryResult.Volumes))
+		if len(queryResult.Volumes) == 0 || queryFilter.Cursor.Offset >= queryResult.Cursor.TotalRecords {
+			return nil
+		}
+	}
+}
+
+// GetCapacityMB returns the capacity of the CNS volume, or 0 if CNS did not return its backing object details
+func GetCapacityMB(volume *cnstypes.CnsVolume) int64 {
+	if volume.BackingObjectDetails == nil {
+		return 0
+	}
+	return volume.BackingObjectDetails.GetCnsBackingObjectDetails().CapacityInMb
+}
+
 // GetDiskAttachedToVM checks if the volume is attached to the VM.
 // If the volume is attached to the VM, return disk uuid of the volume, else return empty string
 func GetDiskAttachedToVM(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
@@ -65,3 +136,200 @@ func GetDiskAttachedToVM(ctx context.Context, vm *cnsvsphere.VirtualMachine, vol
 	klog.V(3).Infof("Volume %s is not attached to VM: %s", volumeID, vm.InventoryPath)
 	return "", nil
 }
+
+// GetAttachedVolumes returns the IDs of the First Class Disks attached to the VM
+func GetAttachedVolumes(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]string, error) {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return nil, err
+	}
+	var volumeIDs []string
+	for _, device := range vmDevices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+		if virtualDisk := device.(*vimtypes.VirtualDisk); virtualDisk.VDiskId != nil {
+			volumeIDs = append(volumeIDs, virtualDisk.VDiskId.Id)
+		}
+	}
+	return volumeIDs, nil
+}
+
+// EnsureKeepAfterDeleteVM makes sure the keepAfterDeleteVm control flag is set on the First Class Disk
+// backing the given volume attached to the VM, so that deleting the VM does not delete the volume.
+// If the volume is not attached to the VM, no action is taken.
+func EnsureKeepAfterDeleteVM(ctx context.Context, vc *cnsvsphere.VirtualCenter, vm *cnsvsphere.VirtualMachine, volumeID string) error {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return err
+	}
+	for _, device := range vmDevices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+		virtualDisk := device.(*vimtypes.VirtualDisk)
+		if virtualDisk.VDiskId == nil || virtualDisk.VDiskId.Id != volumeID {
+			continue
+		}
+		backing, ok := virtualDisk.Backing.(*vimtypes.VirtualDiskFlatVer2BackingInfo)
+		if !ok || backing.Datastore == nil {
+			return nil
+		}
+		keepAfterDeleteVM, err := isKeepAfterDeleteVMSet(ctx, vc, *backing.Datastore, volumeID)
+		if err != nil || keepAfterDeleteVM {
+			return err
+		}
+		klog.V(2).Infof("Setting keepAfterDeleteVm on volume %s attached to vm %s", volumeID, vm.InventoryPath)
+		return vc.SetVStorageObjectControlFlags(ctx, *backing.Datastore, volumeID,
+			[]string{string(vimtypes.VslmVStorageObjectControlFlagKeepAfterDeleteVm)})
+	}
+	klog.V(3).Infof("Volume %s is not attached to VM: %s", volumeID, vm.InventoryPath)
+	return nil
+}
+
+// ExtendAttachedDisk grows the First Class Disk backing the given volume attached to the VM to the given capacity
+// by reconfiguring the VM. The disk is left as is if it is already at or above the capacity.
+func ExtendAttachedDisk(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string, capacityMB int64) error {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return err
+	}
+	for _, device := range vmDevices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+		virtualDisk := device.(*vimtypes.VirtualDisk)
+		if virtualDisk.VDiskId == nil || virtualDisk.VDiskId.Id != volumeID {
+			continue
+		}
+		capacityKB := capacityMB * 1024
+		if virtualDisk.CapacityInKB >= capacityKB {
+			return nil
+		}
+		klog.V(2).Infof("Extending volume %s attached to vm %s from %d KB to %d KB", volumeID, vm.InventoryPath,
+			virtualDisk.CapacityInKB, capacityKB)
+		virtualDisk.CapacityInKB = capacityKB
+		virtualDisk.CapacityInBytes = capacityKB * 1024
+		start := time.Now()
+		err = vm.EditDevice(ctx, virtualDisk)
+		prometheus.ObserveVCenterTask(prometheus.TaskTypeExtendAttachedDisk, start, err)
+		slowlog.ObserveCNS(prometheus.TaskTypeExtendAttachedDisk, "", start)
+		if err != nil {
+			klog.Errorf("Failed to extend volume %s attached to vm %s with err: %v", volumeID, vm.InventoryPath, err)
+		}
+		return err
+	}
+	return fmt.Errorf("volume %s is not attached to vm %s", volumeID, vm.InventoryPath)
+}
+
+// AttachMultiWriterDisk attaches the First Class Disk with the given id and backing file on the given datastore
+// to the VM with multi-writer sharing, so that it can be attached to other VMs at the same time.
+// Returns the UUID of the disk, which is left as is if it is already attached.
+func AttachMultiWriterDisk(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string,
+	datastore vimtypes.ManagedObjectReference, filePath string) (string, error) {
+	diskUUID, err := GetDiskAttachedToVM(ctx, vm, volumeID)
+	if err != nil || diskUUID != "" {
+		return diskUUID, err
+	}
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return "", err
+	}
+	controller, err := vmDevices.FindDiskController("scsi")
+	if err != nil {
+		klog.Errorf("Failed to find SCSI controller of vm: %s", vm.InventoryPath)
+		return "", err
+	}
+	disk := &vimtypes.VirtualDisk{
+		VirtualDevice: vimtypes.VirtualDevice{
+			Backing: &vimtypes.VirtualDiskFlatVer2BackingInfo{
+				VirtualDeviceFileBackingInfo: vimtypes.VirtualDeviceFileBackingInfo{
+					FileName:  filePath,
+					Datastore: &datastore,
+				},
+				// Disks shared by several VMs cannot be snapshotted along with any of them
+				DiskMode: string(vimtypes.VirtualDiskModeIndependent_persistent),
+				Sharing:  string(vimtypes.VirtualDiskSharingSharingMultiWriter),
+			},
+		},
+	}
+	vmDevices.AssignController(disk, controller)
+	klog.V(2).Infof("Attaching volume %s to vm %s with multi-writer sharing", volumeID, vm.InventoryPath)
+	start := time.Now()
+	err = vm.AddDevice(ctx, disk)
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeAttachMultiWriterDisk, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeAttachMultiWriterDisk, "", start)
+	if err != nil {
+		klog.Errorf("Failed to attach volume %s to vm %s with err: %v", volumeID, vm.InventoryPath, err)
+		return "", err
+	}
+	diskUUID, err = GetDiskAttachedToVM(ctx, vm, volumeID)
+	if err == nil && diskUUID == "" {
+		err = fmt.Errorf("volume %s is not found on vm %s after attaching it", volumeID, vm.InventoryPath)
+	}
+	return diskUUID, err
+}
+
+// DetachMultiWriterDisk detaches the First Class Disk with the given id from the VM if it is attached with
+// multi-writer sharing, keeping its backing file. Returns false if the disk is not attached with multi-writer sharing.
+func DetachMultiWriterDisk(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (bool, error) {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return false, err
+	}
+	for _, device := range vmDevices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+		virtualDisk := device.(*vimtypes.VirtualDisk)
+		if virtualDisk.VDiskId == nil || virtualDisk.VDiskId.Id != volumeID {
+			continue
+		}
+		backing, ok := virtualDisk.Backing.(*vimtypes.VirtualDiskFlatVer2BackingInfo)
+		if !ok || backing.Sharing != string(vimtypes.VirtualDiskSharingSharingMultiWriter) {
+			return false, nil
+		}
+		klog.V(2).Infof("Detaching multi-writer volume %s from vm %s", volumeID, vm.InventoryPath)
+		start := time.Now()
+		err = vm.RemoveDevice(ctx, true, virtualDisk)
+		prometheus.ObserveVCenterTask(prometheus.TaskTypeDetachMultiWriterDisk, start, err)
+		slowlog.ObserveCNS(prometheus.TaskTypeDetachMultiWriterDisk, "", start)
+		if err != nil {
+			klog.Errorf("Failed to detach volume %s from vm %s with err: %v", volumeID, vm.InventoryPath, err)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// GetVolumesWithoutKeepAfterDeleteVM returns the IDs of the First Class Disks attached to the VM
+// which do not have the keepAfterDeleteVm control flag set. Such disks are deleted along with the VM.
+func GetVolumesWithoutKeepAfterDeleteVM(ctx context.Context, vc *cnsvsphere.VirtualCenter, vm *cnsvsphere.VirtualMachine) ([]string, error) {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return nil, err
+	}
+	var volumeIDs []string
+	for _, device := range vmDevices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+		virtualDisk := device.(*vimtypes.VirtualDisk)
+		if virtualDisk.VDiskId == nil {
+			// Not a First Class Disk, hence not managed by CNS.
+			continue
+		}
+		backing, ok := virtualDisk.Backing.(*vimtypes.VirtualDiskFlatVer2BackingInfo)
+		if !ok || backing.Datastore == nil {
+			continue
+		}
+		keepAfterDeleteVM, err := isKeepAfterDeleteVMSet(ctx, vc, *backing.Datastore, virtualDisk.VDiskId.Id)
+		if err != nil {
+			return nil, err
+		}
+		if !keepAfterDeleteVM {
+			volumeIDs = append(volumeIDs, virtualDisk.VDiskId.Id)
+		}
+	}
+	return volumeIDs, nil
+}
+
+func isKeepAfterDeleteVMSet(ctx context.Context, vc *cnsvsphere.VirtualCenter, datastore vimtypes.ManagedObjectReference, volumeID string) (bool, error) {
+	vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore, volumeID)
+	if err != nil {
+		return false, err
+	}
+	keepAfterDeleteVM := vStorageObject.Config.KeepAfterDeleteVm
+	return keepAfterDeleteVM != nil && *keepAfterDeleteVM, nil
+}
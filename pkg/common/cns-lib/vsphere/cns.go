@@ -20,18 +20,51 @@ import (
 	"context"
 
 	"github.com/vmware/govmomi/cns"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 )
 
+// CNSClient is the client of the CNS API of a virtual center. Besides the govmomi CNS client, it is implemented by
+// the in-memory client of the fake package, so that the volume operations can be tested without a vCenter.
+type CNSClient interface {
+	// CreateVolume submits the task creating the volumes with the given specs
+	CreateVolume(ctx context.Context, createSpecList []cnstypes.CnsVolumeCreateSpec) (*object.Task, error)
+	// UpdateVolumeMetadata submits the task updating the metadata of the volumes with the given specs
+	UpdateVolumeMetadata(ctx context.Context, updateSpecList []cnstypes.CnsVolumeMetadataUpdateSpec) (*object.Task, error)
+	// DeleteVolume submits the task deleting the volumes with the given ids, and their disks if deleteDisk is set
+	DeleteVolume(ctx context.Context, volumeIDList []cnstypes.CnsVolumeId, deleteDisk bool) (*object.Task, error)
+	// AttachVolume submits the task attaching the volumes to the VMs of the given specs
+	AttachVolume(ctx context.Context, attachSpecList []cnstypes.CnsVolumeAttachDetachSpec) (*object.Task, error)
+	// DetachVolume submits the task detaching the volumes from the VMs of the given specs
+	DetachVolume(ctx context.Context, detachSpecList []cnstypes.CnsVolumeAttachDetachSpec) (*object.Task, error)
+	// QueryVolume returns the volumes matching the given filter
+	QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error)
+	// QueryAllVolume returns the given properties of the volumes matching the given filter
+	QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error)
+	// GetTaskInfo waits for the given task submitted by the client to complete and returns its info
+	GetTaskInfo(ctx context.Context, task *object.Task) (*vimtypes.TaskInfo, error)
+}
+
+// govmomiCNSClient is the CNSClient of the govmomi CNS client
+type govmomiCNSClient struct {
+	*cns.Client
+}
+
+func (c *govmomiCNSClient) GetTaskInfo(ctx context.Context, task *object.Task) (*vimtypes.TaskInfo, error) {
+	return cns.GetTaskInfo(ctx, task)
+}
+
 // NewCNSClient creates a new CNS client
-func NewCNSClient(ctx context.Context, c *vim25.Client) (*cns.Client, error) {
+func NewCNSClient(ctx context.Context, c *vim25.Client) (CNSClient, error) {
 	cnsClient, err := cns.NewClient(ctx, c)
 	if err != nil {
 		klog.Errorf("Failed to create a new client for CNS. err: %v", err)
 		return nil, err
 	}
-	return cnsClient, nil
+	return &govmomiCNSClient{Client: cnsClient}, nil
 }
 
 // ConnectCNS creates a CNS client for the virtual center.
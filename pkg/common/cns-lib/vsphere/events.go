@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/event"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// vmMigrationEventTypes are the types of the events posted when a virtual machine moves to another host,
+// cluster, datacenter or vCenter, with or without vMotion
+var vmMigrationEventTypes = []string{"VmMigratedEvent", "DrsVmMigratedEvent", "VmRelocatedEvent", "VmEmigratingEvent"}
+
+// WatchVirtualMachineMigrations calls onMigrated with the reference of each virtual machine migrated on the
+// vCenter, starting with the latest migrations, until ctx is done or reading the events fails.
+func (vc *VirtualCenter) WatchVirtualMachineMigrations(ctx context.Context, onMigrated func(vm types.ManagedObjectReference)) error {
+	if err := vc.Connect(ctx); err != nil {
+		return err
+	}
+	manager := event.NewManager(vc.Client.Client)
+	root := []types.ManagedObjectReference{vc.Client.ServiceContent.RootFolder}
+	return manager.Events(ctx, root, 10, true, false, func(_ types.ManagedObjectReference, events []types.BaseEvent) error {
+		for _, e := range events {
+			if vm := e.GetEvent().Vm; vm != nil {
+				onMigrated(vm.Vm)
+			}
+		}
+		return nil
+	}, vmMigrationEventTypes...)
+}
@@ -0,0 +1,345 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory CNS client, so that the volume operations can be unit tested without a vCenter.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+)
+
+// resourceInUseMessage is the message of the fault of CNS when the volume is attached to a VM
+const resourceInUseMessage = "The resource 'volume' is in use."
+
+// CNSClient is an in-memory cnsvsphere.CNSClient. Its tasks complete when they are submitted, and the result of the
+// operation on each volume is recorded in the task info like CNS does, faults included. Safe for concurrent use.
+type CNSClient struct {
+	lock sync.Mutex
+	// volumes are the volumes by id, and order the ids of the volumes in creation order
+	volumes map[string]*cnstypes.CnsVolume
+	order   []string
+	// attachments are the VMs the volumes are attached to by volume id, and diskUUIDs the UUIDs of their disks
+	attachments map[string]vimtypes.ManagedObjectReference
+	diskUUIDs   map[string]string
+	tasks       map[string]*vimtypes.TaskInfo
+	errors      map[string]error
+	next        int
+}
+
+// NewCNSClient returns an in-memory CNS client without volumes
+func NewCNSClient() *CNSClient {
+	return &CNSClient{
+		volumes:     make(map[string]*cnstypes.CnsVolume),
+		attachments: make(map[string]vimtypes.ManagedObjectReference),
+		diskUUIDs:   make(map[string]string),
+		tasks:       make(map[string]*vimtypes.TaskInfo),
+		errors:      make(map[string]error),
+	}
+}
+
+// SetError makes the calls of the method with the given name, e.g. "AttachVolume", fail with err, as when vCenter
+// cannot be reached. A nil err clears it.
+func (c *CNSClient) SetError(method string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err == nil {
+		delete(c.errors, method)
+	} else {
+		c.errors[method] = err
+	}
+}
+
+// AttachedVM returns the VM the volume with the given id is attached to, if any
+func (c *CNSClient) AttachedVM(volumeID string) (vimtypes.ManagedObjectReference, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	vm, ok := c.attachments[volumeID]
+	return vm, ok
+}
+
+// CreateVolume creates the volumes with the given specs
+func (c *CNSClient) CreateVolume(ctx context.Context, createSpecList []cnstypes.CnsVolumeCreateSpec) (*object.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["CreateVolume"]; err != nil {
+		return nil, err
+	}
+	var results []cnstypes.BaseCnsVolumeOperationResult
+	for _, spec := range createSpecList {
+		c.next++
+		volumeID := cnstypes.CnsVolumeId{Id: fmt.Sprintf("00000000-0000-0000-0000-%012d", c.next)}
+		volume := &cnstypes.CnsVolume{
+			VolumeId:             volumeID,
+			Name:                 spec.Name,
+			VolumeType:           spec.VolumeType,
+			Metadata:             spec.Metadata,
+			BackingObjectDetails: spec.BackingObjectDetails,
+			ComplianceStatus:     "compliant",
+		}
+		for _, profile := range spec.Profile {
+			if profile, ok := profile.(*vimtypes.VirtualMachineDefinedProfileSpec); ok {
+				volume.StoragePolicyId = profile.ProfileId
+			}
+		}
+		c.volumes[volumeID.Id] = volume
+		c.order = append(c.order, volumeID.Id)
+		c.diskUUIDs[volumeID.Id] = fmt.Sprintf("6000C290-0000-0000-0000-%012d", c.next)
+		results = append(results, &cnstypes.CnsVolumeCreateResult{
+			CnsVolumeOperationResult: cnstypes.CnsVolumeOperationResult{VolumeId: volumeID},
+		})
+	}
+	return c.newTask(ctx, results), nil
+}
+
+// UpdateVolumeMetadata replaces the metadata of the volumes with the given specs
+func (c *CNSClient) UpdateVolumeMetadata(ctx context.Context, updateSpecList []cnstypes.CnsVolumeMetadataUpdateSpec) (*object.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["UpdateVolumeMetadata"]; err != nil {
+		return nil, err
+	}
+	var results []cnstypes.BaseCnsVolumeOperationResult
+	for _, spec := range updateSpecList {
+		result := &cnstypes.CnsVolumeOperationResult{VolumeId: spec.VolumeId}
+		if volume, ok := c.volumes[spec.VolumeId.Id]; ok {
+			volume.Metadata = spec.Metadata
+		} else {
+			result.Fault = notFound(spec.VolumeId)
+		}
+		results = append(results, result)
+	}
+	return c.newTask(ctx, results), nil
+}
+
+// DeleteVolume deletes the volumes with the given ids, failing for the volumes attached to a VM.
+// The volumes are deleted whether or not deleteDisk is set, as the fake does not track disks apart from volumes.
+func (c *CNSClient) DeleteVolume(ctx context.Context, volumeIDList []cnstypes.CnsVolumeId, deleteDisk bool) (*object.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["DeleteVolume"]; err != nil {
+		return nil, err
+	}
+	var results []cnstypes.BaseCnsVolumeOperationResult
+	for _, volumeID := range volumeIDList {
+		result := &cnstypes.CnsVolumeOperationResult{VolumeId: volumeID}
+		if _, ok := c.volumes[volumeID.Id]; !ok {
+			result.Fault = notFound(volumeID)
+		} else if _, ok := c.attachments[volumeID.Id]; ok {
+			result.Fault = resourceInUse()
+		} else {
+			c.remove(volumeID.Id)
+		}
+		results = append(results, result)
+	}
+	return c.newTask(ctx, results), nil
+}
+
+// AttachVolume attaches the volumes to the VMs of the given specs, failing for the volumes already attached
+func (c *CNSClient) AttachVolume(ctx context.Context, attachSpecList []cnstypes.CnsVolumeAttachDetachSpec) (*object.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["AttachVolume"]; err != nil {
+		return nil, err
+	}
+	var results []cnstypes.BaseCnsVolumeOperationResult
+	for _, spec := range attachSpecList {
+		result := &cnstypes.CnsVolumeAttachResult{
+			CnsVolumeOperationResult: cnstypes.CnsVolumeOperationResult{VolumeId: spec.VolumeId},
+		}
+		if _, ok := c.volumes[spec.VolumeId.Id]; !ok {
+			result.Fault = notFound(spec.VolumeId)
+		} else if _, ok := c.attachments[spec.VolumeId.Id]; ok {
+			result.Fault = resourceInUse()
+		} else {
+			c.attachments[spec.VolumeId.Id] = spec.Vm
+			result.DiskUUID = c.diskUUIDs[spec.VolumeId.Id]
+		}
+		results = append(results, result)
+	}
+	return c.newTask(ctx, results), nil
+}
+
+// DetachVolume detaches the volumes from the VMs of the given specs. Detaching a volume which is not attached
+// to the VM succeeds.
+func (c *CNSClient) DetachVolume(ctx context.Context, detachSpecList []cnstypes.CnsVolumeAttachDetachSpec) (*object.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["DetachVolume"]; err != nil {
+		return nil, err
+	}
+	var results []cnstypes.BaseCnsVolumeOperationResult
+	for _, spec := range detachSpecList {
+		result := &cnstypes.CnsVolumeOperationResult{VolumeId: spec.VolumeId}
+		if _, ok := c.volumes[spec.VolumeId.Id]; !ok {
+			result.Fault = notFound(spec.VolumeId)
+		} else if vm, ok := c.attachments[spec.VolumeId.Id]; ok && vm == spec.Vm {
+			delete(c.attachments, spec.VolumeId.Id)
+		}
+		results = append(results, result)
+	}
+	return c.newTask(ctx, results), nil
+}
+
+// QueryVolume returns the volumes matching the ids, names, cluster ids and storage policy of the given filter,
+// in creation order, a page at a time if the filter has a cursor
+func (c *CNSClient) QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["QueryVolume"]; err != nil {
+		return nil, err
+	}
+	volumes := c.query(queryFilter)
+	result := &cnstypes.CnsQueryResult{
+		Cursor: cnstypes.CnsCursor{TotalRecords: int64(len(volumes))},
+	}
+	if cursor := queryFilter.Cursor; cursor != nil {
+		start, end := cursor.Offset, cursor.Offset+cursor.Limit
+		if start > int64(len(volumes)) {
+			start = int64(len(volumes))
+		}
+		if cursor.Limit <= 0 || end > int64(len(volumes)) {
+			end = int64(len(volumes))
+		}
+		volumes = volumes[start:end]
+		result.Cursor.Offset, result.Cursor.Limit = end, cursor.Limit
+	}
+	result.Volumes = volumes
+	return result, nil
+}
+
+// QueryAllVolume returns all the volumes matching the given filter. All their properties are returned whatever
+// the selection.
+func (c *CNSClient) QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.errors["QueryAllVolume"]; err != nil {
+		return nil, err
+	}
+	volumes := c.query(queryFilter)
+	return &cnstypes.CnsQueryResult{
+		Volumes: volumes,
+		Cursor:  cnstypes.CnsCursor{TotalRecords: int64(len(volumes))},
+	}, nil
+}
+
+// GetTaskInfo returns the info of the given task submitted to the client
+func (c *CNSClient) GetTaskInfo(ctx context.Context, task *object.Task) (*vimtypes.TaskInfo, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	taskInfo, ok := c.tasks[task.Reference().Value]
+	if !ok {
+		return nil, fmt.Errorf("task %q not found", task.Reference().Value)
+	}
+	return taskInfo, nil
+}
+
+// newTask returns a completed task with the given results. Its activation id is the operation id of ctx,
+// as vCenter sets it to the operationID of the call submitting the task. Must be called with the lock held.
+func (c *CNSClient) newTask(ctx context.Context, results []cnstypes.BaseCnsVolumeOperationResult) *object.Task {
+	c.next++
+	ref := vimtypes.ManagedObjectReference{Type: "Task", Value: fmt.Sprintf("task-%d", c.next)}
+	c.tasks[ref.Value] = &vimtypes.TaskInfo{
+		Key:          ref.Value,
+		Task:         ref,
+		State:        vimtypes.TaskInfoStateSuccess,
+		ActivationId: opid.FromContext(ctx),
+		Result:       cnstypes.CnsVolumeOperationBatchResult{VolumeResults: results},
+	}
+	return object.NewTask(nil, ref)
+}
+
+// query returns copies of the volumes matching the given filter, in creation order. Must be called with the
+// lock held.
+func (c *CNSClient) query(queryFilter cnstypes.CnsQueryFilter) []cnstypes.CnsVolume {
+	var volumes []cnstypes.CnsVolume
+	for _, id := range c.order {
+		if volume := c.volumes[id]; matches(volume, queryFilter) {
+			volumes = append(volumes, *volume)
+		}
+	}
+	return volumes
+}
+
+// remove removes the volume with the given id. Must be called with the lock held.
+func (c *CNSClient) remove(volumeID string) {
+	delete(c.volumes, volumeID)
+	delete(c.diskUUIDs, volumeID)
+	for i, id := range c.order {
+		if id == volumeID {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// matches returns whether the volume matches the given filter
+func matches(volume *cnstypes.CnsVolume, queryFilter cnstypes.CnsQueryFilter) bool {
+	if len(queryFilter.VolumeIds) > 0 {
+		found := false
+		for _, volumeID := range queryFilter.VolumeIds {
+			found = found || volumeID.Id == volume.VolumeId.Id
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(queryFilter.Names) > 0 {
+		found := false
+		for _, name := range queryFilter.Names {
+			found = found || name == volume.Name
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(queryFilter.ContainerClusterIds) > 0 {
+		found := false
+		for _, clusterID := range queryFilter.ContainerClusterIds {
+			found = found || clusterID == volume.Metadata.ContainerCluster.ClusterId
+			for _, cluster := range volume.Metadata.ContainerClusterArray {
+				found = found || clusterID == cluster.ClusterId
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return queryFilter.StoragePolicyId == "" || queryFilter.StoragePolicyId == volume.StoragePolicyId
+}
+
+// notFound returns the fault of CNS for a volume which does not exist
+func notFound(volumeID cnstypes.CnsVolumeId) *vimtypes.LocalizedMethodFault {
+	return &vimtypes.LocalizedMethodFault{
+		Fault:            &vimtypes.NotFound{},
+		LocalizedMessage: fmt.Sprintf("The object or item referred to could not be found. Volume: %s", volumeID.Id),
+	}
+}
+
+// resourceInUse returns the fault of CNS for a volume attached to a VM
+func resourceInUse() *vimtypes.LocalizedMethodFault {
+	return &vimtypes.LocalizedMethodFault{
+		Fault:            &vimtypes.ResourceInUse{},
+		LocalizedMessage: resourceInUseMessage,
+	}
+}
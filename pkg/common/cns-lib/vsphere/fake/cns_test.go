@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vmware/govmomi/cns"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+var _ cnsvsphere.CNSClient = &CNSClient{}
+
+// taskResult returns the result of the operation on the first volume of the given task
+func taskResult(t *testing.T, c *CNSClient, task *object.Task, err error) cnstypes.BaseCnsVolumeOperationResult {
+	if err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+	taskInfo, err := c.GetTaskInfo(context.Background(), task)
+	if err != nil {
+		t.Fatalf("GetTaskInfo() failed: %v", err)
+	}
+	result, err := cns.GetTaskResult(context.Background(), taskInfo)
+	if err != nil {
+		t.Fatalf("GetTaskResult() failed: %v", err)
+	}
+	return result
+}
+
+func TestCNSClient(t *testing.T) {
+	ctx := context.Background()
+	c := NewCNSClient()
+	var volumeIDs []cnstypes.CnsVolumeId
+	for _, name := range []string{"pvc-1", "pvc-2", "pvc-3"} {
+		task, err := c.CreateVolume(ctx, []cnstypes.CnsVolumeCreateSpec{{
+			Name:     name,
+			Metadata: cnstypes.CnsVolumeMetadata{ContainerCluster: cnstypes.CnsContainerCluster{ClusterId: "cluster-1"}},
+		}})
+		volumeIDs = append(volumeIDs, taskResult(t, c, task, err).GetCnsVolumeOperationResult().VolumeId)
+	}
+
+	// Query
+	queryResult, err := c.QueryVolume(ctx, cnstypes.CnsQueryFilter{Names: []string{"pvc-2"}})
+	if err != nil || len(queryResult.Volumes) != 1 || queryResult.Volumes[0].VolumeId != volumeIDs[1] {
+		t.Errorf("QueryVolume() by name = %+v, %v, expected volume %s", queryResult, err, volumeIDs[1].Id)
+	}
+	queryResult, err = c.QueryVolume(ctx, cnstypes.CnsQueryFilter{
+		ContainerClusterIds: []string{"cluster-1"},
+		Cursor:              &cnstypes.CnsCursor{Offset: 2, Limit: 2},
+	})
+	if err != nil || len(queryResult.Volumes) != 1 || queryResult.Volumes[0].VolumeId != volumeIDs[2] || queryResult.Cursor.TotalRecords != 3 {
+		t.Errorf("QueryVolume() of the last page = %+v, %v, expected volume %s of 3", queryResult, err, volumeIDs[2].Id)
+	}
+
+	// Attach
+	vm := vimtypes.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	attachSpec := []cnstypes.CnsVolumeAttachDetachSpec{{VolumeId: volumeIDs[0], Vm: vm}}
+	task, err := c.AttachVolume(ctx, attachSpec)
+	if result := taskResult(t, c, task, err).(*cnstypes.CnsVolumeAttachResult); result.Fault != nil || result.DiskUUID == "" {
+		t.Errorf("AttachVolume() = %+v, expected a disk UUID", result)
+	}
+	if attached, ok := c.AttachedVM(volumeIDs[0].Id); !ok || attached != vm {
+		t.Errorf("AttachedVM() = %v, %t, expected %v", attached, ok, vm)
+	}
+	task, err = c.AttachVolume(ctx, attachSpec)
+	if fault := taskResult(t, c, task, err).GetCnsVolumeOperationResult().Fault; fault == nil || fault.LocalizedMessage != resourceInUseMessage {
+		t.Errorf("AttachVolume() of an attached volume returned fault %+v, expected %q", fault, resourceInUseMessage)
+	}
+	task, err = c.DeleteVolume(ctx, volumeIDs[:1], true)
+	if fault := taskResult(t, c, task, err).GetCnsVolumeOperationResult().Fault; fault == nil {
+		t.Errorf("DeleteVolume() of an attached volume succeeded")
+	}
+
+	// Detach and delete
+	task, err = c.DetachVolume(ctx, attachSpec)
+	if fault := taskResult(t, c, task, err).GetCnsVolumeOperationResult().Fault; fault != nil {
+		t.Errorf("DetachVolume() returned fault %+v", fault)
+	}
+	task, err = c.DeleteVolume(ctx, volumeIDs[:1], true)
+	if fault := taskResult(t, c, task, err).GetCnsVolumeOperationResult().Fault; fault != nil {
+		t.Errorf("DeleteVolume() returned fault %+v", fault)
+	}
+	task, err = c.DeleteVolume(ctx, volumeIDs[:1], true)
+	if fault := taskResult(t, c, task, err).GetCnsVolumeOperationResult().Fault; fault == nil || cnsvsphere.FaultType(fault.Fault) != "NotFound" {
+		t.Errorf("DeleteVolume() of a deleted volume returned fault %+v, expected NotFound", fault)
+	}
+	if queryResult, err := c.QueryAllVolume(ctx, cnstypes.CnsQueryFilter{}, cnstypes.CnsQuerySelection{}); err != nil || len(queryResult.Volumes) != 2 {
+		t.Errorf("QueryAllVolume() = %+v, %v, expected 2 volumes", queryResult, err)
+	}
+
+	// Errors
+	unavailable := errors.New("connection refused")
+	c.SetError("QueryVolume", unavailable)
+	if _, err := c.QueryVolume(ctx, cnstypes.CnsQueryFilter{}); err != unavailable {
+		t.Errorf("QueryVolume() = %v, expected %v", err, unavailable)
+	}
+	c.SetError("QueryVolume", nil)
+	if _, err := c.QueryVolume(ctx, cnstypes.CnsQueryFilter{}); err != nil {
+		t.Errorf("QueryVolume() = %v after the error was cleared", err)
+	}
+}
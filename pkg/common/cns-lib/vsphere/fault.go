@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"reflect"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+)
+
+// TypedFault is implemented by the errors of operations which failed with a vSphere fault,
+// such as the errors of failed CNS tasks
+type TypedFault interface {
+	error
+	// FaultType returns the type of the vSphere fault, e.g. NotFound
+	FaultType() string
+}
+
+// faultCodes are the gRPC codes of the vSphere faults which do not mean the driver failed. Faults which may
+// go away, such as a VM being reconfigured by another task, have codes the CSI sidecars retry the operation on
+// right away, while the others tell the sidecars and users what to fix.
+var faultCodes = map[string]codes.Code{
+	"ResourceInUse":              codes.FailedPrecondition,
+	"InvalidState":               codes.Aborted,
+	"TaskInProgress":             codes.Aborted,
+	"ConcurrentAccess":           codes.Aborted,
+	"NotFound":                   codes.NotFound,
+	"ManagedObjectNotFound":      codes.NotFound,
+	"FileNotFound":               codes.NotFound,
+	"AlreadyExists":              codes.AlreadyExists,
+	"DuplicateName":              codes.AlreadyExists,
+	"FileAlreadyExists":          codes.AlreadyExists,
+	"InvalidArgument":            codes.InvalidArgument,
+	"OutOfBounds":                codes.OutOfRange,
+	"NoPermission":               codes.PermissionDenied,
+	"NotAuthenticated":           codes.Unauthenticated,
+	"InvalidLogin":               codes.Unauthenticated,
+	"InsufficientResourcesFault": codes.ResourceExhausted,
+	"InsufficientStorageSpace":   codes.ResourceExhausted,
+	"HostCommunication":          codes.Unavailable,
+	"HostNotConnected":           codes.Unavailable,
+	"HostNotReachable":           codes.Unavailable,
+	"Timedout":                   codes.DeadlineExceeded,
+}
+
+// FaultType returns the type name of the given vSphere fault
+func FaultType(fault types.BaseMethodFault) string {
+	if fault == nil {
+		return ""
+	}
+	return reflect.Indirect(reflect.ValueOf(fault)).Type().Name()
+}
+
+// FaultCode returns the gRPC code of the vSphere fault of the given type, and false if the fault has no code
+// of its own, in which case the failed operation is an internal error
+func FaultCode(faultType string) (codes.Code, bool) {
+	code, ok := faultCodes[faultType]
+	return code, ok
+}
+
+// ErrorCode returns the gRPC code of the error of an operation which failed with a vSphere fault, a SOAP fault or
+// a failure to reach vCenter, which is Unavailable, and false if the error has no code of its own
+func ErrorCode(err error) (codes.Code, bool) {
+	switch {
+	case err == nil:
+		return codes.OK, false
+	case IsNetworkError(err):
+		return codes.Unavailable, true
+	case soap.IsSoapFault(err):
+		if fault := soap.ToSoapFault(err).VimFault(); fault != nil {
+			return FaultCode(reflect.Indirect(reflect.ValueOf(fault)).Type().Name())
+		}
+		return codes.OK, false
+	}
+	if fault, ok := err.(TypedFault); ok {
+		return FaultCode(fault.FaultType())
+	}
+	return codes.OK, false
+}
+
+// IsTransientError returns whether the error is a failure to reach vCenter or a vSphere fault which may go away
+// when the operation is retried shortly, such as a VM being reconfigured by another task
+func IsTransientError(err error) bool {
+	code, ok := ErrorCode(err)
+	return ok && (code == codes.Unavailable || code == codes.Aborted)
+}
@@ -34,6 +34,18 @@ type HostSystem struct {
 
 // GetAllAccessibleDatastores gets the list of accessible datastores for the given host
 func (host *HostSystem) GetAllAccessibleDatastores(ctx context.Context) ([]*DatastoreInfo, error) {
+	return host.getAccessibleDatastores(ctx, true)
+}
+
+// GetPlaceableDatastores gets the list of accessible datastores for the given host which new volumes can be
+// placed on, i.e. which are not in or entering maintenance mode
+func (host *HostSystem) GetPlaceableDatastores(ctx context.Context) ([]*DatastoreInfo, error) {
+	return host.getAccessibleDatastores(ctx, false)
+}
+
+// getAccessibleDatastores returns the datastores mounted and accessible on the host, including the datastores
+// in or entering maintenance mode if inMaintenance is true
+func (host *HostSystem) getAccessibleDatastores(ctx context.Context, inMaintenance bool) ([]*DatastoreInfo, error) {
 	var hostSystemMo mo.HostSystem
 	s := object.NewSearchIndex(host.Client())
 	err := s.Properties(ctx, host.Reference(), []string{"datastore"}, &hostSystemMo)
@@ -46,7 +58,7 @@ func (host *HostSystem) GetAllAccessibleDatastores(ctx context.Context) ([]*Data
 
 	var dsMoList []mo.Datastore
 	pc := property.DefaultCollector(host.Client())
-	properties := []string{"info"}
+	properties := []string{"info", "host", "summary"}
 	err = pc.Retrieve(ctx, dsRefList, properties, &dsMoList)
 	if err != nil {
 		klog.Errorf("Failed to get datastore managed objects from datastore objects %v with properties %v: %v", dsRefList, properties, err)
@@ -54,6 +66,20 @@ func (host *HostSystem) GetAllAccessibleDatastores(ctx context.Context) ([]*Data
 	}
 	var dsObjList []*DatastoreInfo
 	for _, dsMo := range dsMoList {
+		url := dsMo.Info.GetDatastoreInfo().Url
+		if !dsMo.Summary.Accessible {
+			klog.V(4).Infof("Datastore %s is not accessible", url)
+			continue
+		}
+		// vVol datastores stay mounted while their protocol endpoints are unreachable from the host
+		if !isAccessibleFromHost(dsMo, host.Reference()) {
+			klog.V(4).Infof("Datastore %s is not mounted or not accessible on host %v", url, host)
+			continue
+		}
+		if !inMaintenance && isInMaintenanceMode(dsMo) {
+			klog.V(4).Infof("Datastore %s is in maintenance mode %s", url, dsMo.Summary.MaintenanceMode)
+			continue
+		}
 		dsObjList = append(dsObjList,
 			&DatastoreInfo{
 				&Datastore{object.NewDatastore(host.Client(), dsMo.Reference()),
@@ -62,3 +88,22 @@ func (host *HostSystem) GetAllAccessibleDatastores(ctx context.Context) ([]*Data
 	}
 	return dsObjList, nil
 }
+
+// isAccessibleFromHost returns false if the datastore is reported as unmounted or not accessible on the host
+func isAccessibleFromHost(dsMo mo.Datastore, host types.ManagedObjectReference) bool {
+	for _, mount := range dsMo.Host {
+		if mount.Key == host {
+			if mount.MountInfo.Mounted != nil && !*mount.MountInfo.Mounted {
+				return false
+			}
+			return mount.MountInfo.Accessible == nil || *mount.MountInfo.Accessible
+		}
+	}
+	return true
+}
+
+// isInMaintenanceMode returns true if the datastore is in or entering maintenance mode
+func isInMaintenanceMode(dsMo mo.Datastore) bool {
+	mode := dsMo.Summary.MaintenanceMode
+	return mode != "" && mode != string(types.DatastoreSummaryMaintenanceModeStateNormal)
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 
 	"github.com/vmware/govmomi/pbm"
+	pbmmethods "github.com/vmware/govmomi/pbm/methods"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
 	"k8s.io/klog"
 )
 
@@ -58,3 +60,59 @@ func (vc *VirtualCenter) GetStoragePolicyIDByName(ctx context.Context, storagePo
 	}
 	return storagePolicyID, nil
 }
+
+// GetCompatibleDatastores returns the datastores among the given ones which are compatible with the storage policy
+// with the given ID, as checked by the SPBM placement solver.
+func (vc *VirtualCenter) GetCompatibleDatastores(ctx context.Context, storagePolicyID string,
+	datastores []*DatastoreInfo) ([]*DatastoreInfo, error) {
+	var hubs []pbmtypes.PbmPlacementHub
+	for _, datastore := range datastores {
+		ref := datastore.Reference()
+		hubs = append(hubs, pbmtypes.PbmPlacementHub{HubType: ref.Type, HubId: ref.Value})
+	}
+	requirements := []pbmtypes.BasePbmPlacementRequirement{
+		&pbmtypes.PbmPlacementCapabilityProfileRequirement{
+			ProfileId: pbmtypes.PbmProfileId{UniqueId: storagePolicyID},
+		},
+	}
+	result, err := vc.PbmClient.CheckRequirements(ctx, hubs, nil, requirements)
+	if err != nil {
+		klog.Errorf("Failed to check compatibility of datastores with StoragePolicyID %s with err: %v", storagePolicyID, err)
+		return nil, err
+	}
+	return filterCompatibleDatastores(datastores, result.CompatibleDatastores()), nil
+}
+
+// GetDefaultStoragePolicyID returns the ID of the default storage policy of the datastore, which vVol datastores
+// require objects without a storage policy to be provisioned with, or "" if the datastore has none.
+func (vc *VirtualCenter) GetDefaultStoragePolicyID(ctx context.Context, datastore *DatastoreInfo) (string, error) {
+	ref := datastore.Reference()
+	req := pbmtypes.PbmQueryDefaultRequirementProfile{
+		This: vc.PbmClient.ServiceContent.ProfileManager,
+		Hub:  pbmtypes.PbmPlacementHub{HubType: ref.Type, HubId: ref.Value},
+	}
+	res, err := pbmmethods.PbmQueryDefaultRequirementProfile(ctx, vc.PbmClient, &req)
+	if err != nil {
+		klog.Errorf("Failed to get default storage policy of datastore %s with err: %v", datastore.Info.Url, err)
+		return "", err
+	}
+	if res.Returnval == nil {
+		return "", nil
+	}
+	return res.Returnval.UniqueId, nil
+}
+
+// filterCompatibleDatastores returns the datastores among the given ones which are one of the compatible hubs
+func filterCompatibleDatastores(datastores []*DatastoreInfo, compatibleHubs []pbmtypes.PbmPlacementHub) []*DatastoreInfo {
+	compatible := make(map[string]bool)
+	for _, hub := range compatibleHubs {
+		compatible[hub.HubId] = true
+	}
+	var compatibleDatastores []*DatastoreInfo
+	for _, datastore := range datastores {
+		if compatible[datastore.Reference().Value] {
+			compatibleDatastores = append(compatibleDatastores, datastore)
+		}
+	}
+	return compatibleDatastores
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog"
+)
+
+// APIClass is the class of a call to vCenter, each class having its own rate limit
+type APIClass int
+
+const (
+	// QueryAPI is the class of the calls reading vCenter, such as CNS QueryVolume
+	QueryAPI APIClass = iota
+	// ReconfigureAPI is the class of the calls changing vCenter, such as CNS CreateVolume or AttachVolume
+	ReconfigureAPI
+)
+
+func (class APIClass) String() string {
+	if class == ReconfigureAPI {
+		return "reconfigure"
+	}
+	return "query"
+}
+
+// apiLimiters are the rate limiters of the calls to a vCenter by class
+type apiLimiters struct {
+	query       *rate.Limiter
+	reconfigure *rate.Limiter
+}
+
+// newAPILimiters returns the rate limiters of the calls to the vCenter with the given config
+func newAPILimiters(config *VirtualCenterConfig) *apiLimiters {
+	return &apiLimiters{
+		query:       newLimiter(config.QueryQPS, config.QueryBurst),
+		reconfigure: newLimiter(config.ReconfigureQPS, config.ReconfigureBurst),
+	}
+}
+
+// newLimiter returns a rate limiter allowing the given calls per second in bursts of the given size,
+// which allows all calls if qps is not positive. Bursts are at least one call.
+func newLimiter(qps float64, burst int) *rate.Limiter {
+	if qps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// WaitForAPI blocks until the rate limit of the given class of calls to the virtual center allows a call,
+// and returns an error if ctx is done first or the wait would outlast its deadline
+func (vc *VirtualCenter) WaitForAPI(ctx context.Context, class APIClass) error {
+	vc.limitersOnce.Do(func() {
+		vc.limiters = newAPILimiters(vc.Config)
+	})
+	limiter := vc.limiters.query
+	if class == ReconfigureAPI {
+		limiter = vc.limiters.reconfigure
+	}
+	start := time.Now()
+	if err := limiter.Wait(ctx); err != nil {
+		klog.Errorf("Rate limit of %s calls to vCenter %q not met. err=%v", class, vc.Config.Host, err)
+		return err
+	}
+	if waited := time.Since(start); waited > time.Second {
+		klog.V(3).Infof("%s call to vCenter %q was throttled for %v", class, vc.Config.Host, waited)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// GetDatastoreClusterByName returns the Storage DRS datastore cluster with the given name or inventory path
+// in the datacenter.
+func (dc *Datacenter) GetDatastoreClusterByName(ctx context.Context, name string) (*object.StoragePod, error) {
+	finder := find.NewFinder(dc.Client(), false)
+	finder.SetDatacenter(dc.Datacenter)
+	pod, err := finder.DatastoreCluster(ctx, name)
+	if err != nil {
+		klog.Errorf("Failed to find datastore cluster %q in datacenter %s. err: %v", name, dc.InventoryPath, err)
+		return nil, err
+	}
+	return pod, nil
+}
+
+// RecommendDatastores asks Storage DRS for the member datastores of the datastore cluster to create a disk of
+// the given capacity on, in the order of the recommendations. As Storage DRS places the disks of VMs, it is asked
+// to place a VM with the given name and a single disk, which is never created.
+func (dc *Datacenter) RecommendDatastores(ctx context.Context, pod *object.StoragePod, name string,
+	capacityMB int64) ([]types.ManagedObjectReference, error) {
+	pool, err := getStoragePodResourcePool(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := dc.Folders(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get folders of datacenter %s. err: %v", dc.InventoryPath, err)
+		return nil, err
+	}
+	controller, err := object.VirtualDeviceList{}.CreateSCSIController("pvscsi")
+	if err != nil {
+		return nil, err
+	}
+	controller.GetVirtualDevice().Key = -100
+	disk := &types.VirtualDisk{
+		VirtualDevice: types.VirtualDevice{
+			Key:           -101,
+			ControllerKey: controller.GetVirtualDevice().Key,
+			UnitNumber:    types.NewInt32(0),
+			Backing: &types.VirtualDiskFlatVer2BackingInfo{
+				DiskMode:        string(types.VirtualDiskModePersistent),
+				ThinProvisioned: types.NewBool(true),
+			},
+		},
+		CapacityInKB: capacityMB * 1024,
+	}
+	podRef := pod.Reference()
+	poolRef := pool.Reference()
+	folderRef := folders.VmFolder.Reference()
+	spec := types.StoragePlacementSpec{
+		Type:         string(types.StoragePlacementSpecPlacementTypeCreate),
+		ResourcePool: &poolRef,
+		Folder:       &folderRef,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{
+			StoragePod: &podRef,
+			InitialVmConfig: []types.VmPodConfigForPlacement{
+				{
+					StoragePod: podRef,
+					Disk: []types.PodDiskLocator{
+						{DiskId: disk.Key, DiskBackingInfo: disk.Backing},
+					},
+				},
+			},
+		},
+		ConfigSpec: &types.VirtualMachineConfigSpec{
+			Name: name,
+			DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+				&types.VirtualDeviceConfigSpec{
+					Operation: types.VirtualDeviceConfigSpecOperationAdd,
+					Device:    controller,
+				},
+				&types.VirtualDeviceConfigSpec{
+					Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+					FileOperation: types.VirtualDeviceConfigSpecFileOperationCreate,
+					Device:        disk,
+				},
+			},
+		},
+	}
+	result, err := object.NewStorageResourceManager(dc.Client()).RecommendDatastores(ctx, spec)
+	if err != nil {
+		klog.Errorf("Failed to get Storage DRS recommendations for datastore cluster %s. err: %v", pod.InventoryPath, err)
+		return nil, err
+	}
+	var datastores []types.ManagedObjectReference
+	for _, recommendation := range result.Recommendations {
+		for _, action := range recommendation.Action {
+			if placement, ok := action.(*types.StoragePlacementAction); ok {
+				datastores = append(datastores, placement.Destination)
+			}
+		}
+	}
+	klog.V(4).Infof("Storage DRS recommended datastores %v of datastore cluster %s", datastores, pod.InventoryPath)
+	return datastores, nil
+}
+
+// getStoragePodResourcePool returns the root resource pool of a host mounting a member datastore of the
+// datastore cluster, which Storage DRS requires to place a VM.
+func getStoragePodResourcePool(ctx context.Context, pod *object.StoragePod) (*object.ResourcePool, error) {
+	pc := property.DefaultCollector(pod.Client())
+	var podMo mo.StoragePod
+	if err := pc.RetrieveOne(ctx, pod.Reference(), []string{"childEntity"}, &podMo); err != nil {
+		klog.Errorf("Failed to get member datastores of datastore cluster %s. err: %v", pod.InventoryPath, err)
+		return nil, err
+	}
+	for _, child := range podMo.ChildEntity {
+		if child.Type != "Datastore" {
+			continue
+		}
+		var dsMo mo.Datastore
+		if err := pc.RetrieveOne(ctx, child, []string{"host"}, &dsMo); err != nil {
+			klog.Errorf("Failed to get hosts of datastore %s. err: %v", child.Value, err)
+			return nil, err
+		}
+		if len(dsMo.Host) > 0 {
+			return object.NewHostSystem(pod.Client(), dsMo.Host[0].Key).ResourcePool(ctx)
+		}
+	}
+	return nil, fmt.Errorf("no member datastore of datastore cluster %s is mounted on a host", pod.InventoryPath)
+}
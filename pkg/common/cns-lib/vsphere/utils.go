@@ -22,7 +22,10 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net"
+	neturl "net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -44,6 +47,25 @@ func IsInvalidCredentialsError(err error) bool {
 	return isInvalidCredentialsError
 }
 
+// IsNotFoundError returns true if error is of type NotFound
+func IsNotFoundError(err error) bool {
+	isNotFoundError := false
+	if soap.IsSoapFault(err) {
+		_, isNotFoundError = soap.ToSoapFault(err).VimFault().(types.NotFound)
+	}
+	return isNotFoundError
+}
+
+// IsNetworkError returns true if the error is caused by a failure to reach vCenter,
+// for example a refused connection, a timeout or a failure to resolve its host name
+func IsNetworkError(err error) bool {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		err = urlErr.Err
+	}
+	_, isNetworkError := err.(net.Error)
+	return isNetworkError
+}
+
 // GetCnsKubernetesEntityMetaData creates a CnsKubernetesEntityMetadataObject object from given parameters
 func GetCnsKubernetesEntityMetaData(entityName string, labels map[string]string, deleteFlag bool, entityType string, namespace string) *cnstypes.CnsKubernetesEntityMetadata {
 	// Create new metadata spec
@@ -77,25 +99,51 @@ func GetContainerCluster(clusterid string, username string) cnstypes.CnsContaine
 }
 
 // GetVirtualCenterConfig returns VirtualCenterConfig Object created using vSphere Configuration
-// specified in the argurment.
+// specified in the argurment. If several vCenters are configured, the config of the first one is returned.
 func GetVirtualCenterConfig(cfg *config.Config) (*VirtualCenterConfig, error) {
 	var err error
 	vCenterIPs, err := GetVcenterIPs(cfg) //  make([]string, 0)
 	if err != nil {
 		return nil, err
 	}
-	host := vCenterIPs[0]
+	return getVirtualCenterConfig(cfg, vCenterIPs[0])
+}
+
+// GetVirtualCenterConfigs returns the VirtualCenterConfig objects of all the vCenters of the vSphere
+// Configuration, starting with the one returned by GetVirtualCenterConfig
+func GetVirtualCenterConfigs(cfg *config.Config) ([]*VirtualCenterConfig, error) {
+	vCenterIPs, err := GetVcenterIPs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var vcConfigs []*VirtualCenterConfig
+	for _, host := range vCenterIPs {
+		vcConfig, err := getVirtualCenterConfig(cfg, host)
+		if err != nil {
+			return nil, err
+		}
+		vcConfigs = append(vcConfigs, vcConfig)
+	}
+	return vcConfigs, nil
+}
+
+// getVirtualCenterConfig returns the VirtualCenterConfig of the vCenter with the given host
+func getVirtualCenterConfig(cfg *config.Config, host string) (*VirtualCenterConfig, error) {
 	port, err := strconv.Atoi(cfg.VirtualCenter[host].VCenterPort)
 	if err != nil {
 		return nil, err
 	}
 	vcConfig := &VirtualCenterConfig{
-		Host:            host,
-		Port:            port,
-		Username:        cfg.VirtualCenter[host].User,
-		Password:        cfg.VirtualCenter[host].Password,
-		Insecure:        cfg.VirtualCenter[host].InsecureFlag,
-		DatacenterPaths: strings.Split(cfg.VirtualCenter[host].Datacenters, ","),
+		Host:             host,
+		Port:             port,
+		Username:         cfg.VirtualCenter[host].User,
+		Password:         cfg.VirtualCenter[host].Password,
+		Insecure:         cfg.VirtualCenter[host].InsecureFlag,
+		DatacenterPaths:  strings.Split(cfg.VirtualCenter[host].Datacenters, ","),
+		QueryQPS:         cfg.VirtualCenter[host].QueryQPS,
+		QueryBurst:       cfg.VirtualCenter[host].QueryBurst,
+		ReconfigureQPS:   cfg.VirtualCenter[host].ReconfigureQPS,
+		ReconfigureBurst: cfg.VirtualCenter[host].ReconfigureBurst,
 	}
 	for idx := range vcConfig.DatacenterPaths {
 		vcConfig.DatacenterPaths[idx] = strings.TrimSpace(vcConfig.DatacenterPaths[idx])
@@ -106,13 +154,15 @@ func GetVirtualCenterConfig(cfg *config.Config) (*VirtualCenterConfig, error) {
 	return vcConfig, nil
 }
 
-// GetVcenterIPs returns list of vCenter IPs from VSphereConfig
+// GetVcenterIPs returns list of vCenter IPs from VSphereConfig, in the order of their names so that
+// the first vCenter is the same across restarts
 func GetVcenterIPs(cfg *config.Config) ([]string, error) {
 	var err error
 	vCenterIPs := make([]string, 0)
 	for key := range cfg.VirtualCenter {
 		vCenterIPs = append(vCenterIPs, key)
 	}
+	sort.Strings(vCenterIPs)
 	if len(vCenterIPs) == 0 {
 		err = errors.New("Unable get vCenter Hosts from VSphereConfig")
 	}
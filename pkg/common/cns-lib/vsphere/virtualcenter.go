@@ -23,12 +23,14 @@ import (
 	"fmt"
 	"net"
 	neturl "net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	csictx "github.com/rexray/gocsi/context"
 	"github.com/vmware/govmomi"
-	"github.com/vmware/govmomi/cns"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/pbm"
@@ -48,6 +50,9 @@ const (
 	DefaultScheme = "https"
 	// DefaultRoundTripperCount is the default SOAP round tripper count.
 	DefaultRoundTripperCount = 3
+	// credentialsDrainPeriod is how long the session of the clients replaced on credential rotation is kept,
+	// so that the calls in flight on them complete
+	credentialsDrainPeriod = 10 * time.Minute
 )
 
 // VirtualCenter holds details of a virtual center instance.
@@ -59,8 +64,13 @@ type VirtualCenter struct {
 	// PbmClient represents the govmomi PBM Client instance.
 	PbmClient *pbm.Client
 	// CnsClient represents the CNS client instance.
-	CnsClient       *cns.Client
+	CnsClient       CNSClient
 	credentialsLock sync.Mutex
+	// resolvedAddrs are the addresses the host name of the virtual center resolved to on the last connect
+	resolvedAddrs []string
+	// limiters are the rate limiters of the calls to the virtual center, created on first use
+	limiters     *apiLimiters
+	limitersOnce sync.Once
 }
 
 func (vc *VirtualCenter) String() string {
@@ -91,6 +101,13 @@ type VirtualCenterConfig struct {
 	RoundTripperCount int
 	// DatacenterPaths represents paths of datacenters on the virtual center.
 	DatacenterPaths []string
+	// QueryQPS and QueryBurst are the rate limit of the calls reading the virtual center. 0 QPS means no limit.
+	QueryQPS   float64
+	QueryBurst int
+	// ReconfigureQPS and ReconfigureBurst are the rate limit of the calls changing the virtual center.
+	// 0 QPS means no limit.
+	ReconfigureQPS   float64
+	ReconfigureBurst int
 }
 
 func (vcc *VirtualCenterConfig) String() string {
@@ -246,13 +263,22 @@ func (vc *VirtualCenter) connect(ctx context.Context) error {
 	// SessionMgr.UserSession(ctx) retrieves and returns the SessionManager's CurrentSession field
 	// Nil is returned if the session is not authenticated or timed out.
 	if userSession, err := sessionMgr.UserSession(ctx); err != nil {
-		klog.Errorf("Failed to obtain user session with err: %v", err)
-		return err
+		if !IsNetworkError(err) {
+			klog.Errorf("Failed to obtain user session with err: %v", err)
+			return err
+		}
+		// The connection is dropped, for example by a VCHA failover or a migration of the virtual center
+		// to another address. Reconnect to the addresses the host name resolves to now.
+		klog.Warningf("Failed to reach vCenter %s with err: %v. Reconnecting", vc.Config.Host, err)
 	} else if userSession != nil {
 		return nil
+	} else {
+		// If session has expired, create a new instance.
+		klog.Warning("Creating a new client session as the existing session isn't valid or not authenticated")
 	}
-	// If session has expired, create a new instance.
-	klog.Warning("Creating a new client session as the existing session isn't valid or not authenticated")
+	// Drop pooled connections to the addresses the host name resolved to previously
+	vc.Client.Client.CloseIdleConnections()
+	vc.resolveHost(ctx)
 	if vc.Client, err = vc.newClient(ctx); err != nil {
 		klog.Errorf("Failed to create govmomi client with err: %v", err)
 		return err
@@ -274,6 +300,24 @@ func (vc *VirtualCenter) connect(ctx context.Context) error {
 	return nil
 }
 
+// resolveHost resolves the host name of the virtual center and logs if its addresses changed since the last connect.
+// The client dials the host name, hence new connections always use the addresses the host name resolves to at the time.
+func (vc *VirtualCenter) resolveHost(ctx context.Context) {
+	if net.ParseIP(vc.Config.Host) != nil {
+		return
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, vc.Config.Host)
+	if err != nil {
+		klog.Warningf("Failed to resolve vCenter host %s with err: %v", vc.Config.Host, err)
+		return
+	}
+	sort.Strings(addrs)
+	if vc.resolvedAddrs != nil && !reflect.DeepEqual(vc.resolvedAddrs, addrs) {
+		klog.V(2).Infof("vCenter host %s now resolves to %v, previously %v", vc.Config.Host, addrs, vc.resolvedAddrs)
+	}
+	vc.resolvedAddrs = addrs
+}
+
 // listDatacenters returns all Datacenters.
 func (vc *VirtualCenter) listDatacenters(ctx context.Context) ([]*Datacenter, error) {
 	finder := find.NewFinder(vc.Client.Client, false)
@@ -341,6 +385,54 @@ func (vc *VirtualCenter) UpdateCredentials(username, password string) {
 	vc.Config.Password = password
 }
 
+// RotateCredentials updates the username and password and, if they changed, replaces the clients with clients
+// logged in with them, so that the credentials in use are known to be valid without waiting for the session to
+// expire. Calls in flight on the replaced clients complete, their session is logged out after a drain period.
+// The clients are kept if logging in with the new credentials fails.
+func (vc *VirtualCenter) RotateCredentials(ctx context.Context, username, password string) error {
+	vc.credentialsLock.Lock()
+	unchanged := vc.Config.Username == username && vc.Config.Password == password
+	vc.credentialsLock.Unlock()
+	if unchanged {
+		return nil
+	}
+	vc.UpdateCredentials(username, password)
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	if vc.Client == nil {
+		// The credentials are used on the first connect
+		return nil
+	}
+	client, err := vc.newClient(ctx)
+	if err != nil {
+		klog.Errorf("Failed to log in to vCenter %s with the new credentials. err: %v", vc.Config.Host, err)
+		return err
+	}
+	var pbmClient *pbm.Client
+	if vc.PbmClient != nil {
+		if pbmClient, err = pbm.NewClient(ctx, client.Client); err != nil {
+			klog.Errorf("Failed to create pbm client with err: %v", err)
+			return err
+		}
+	}
+	var cnsClient CNSClient
+	if vc.CnsClient != nil {
+		if cnsClient, err = NewCNSClient(ctx, client.Client); err != nil {
+			klog.Errorf("Failed to create CNS client on vCenter host %v with err: %v", vc.Config.Host, err)
+			return err
+		}
+	}
+	oldClient := vc.Client
+	vc.Client, vc.PbmClient, vc.CnsClient = client, pbmClient, cnsClient
+	klog.Infof("Rotated credentials of vCenter %s, the previous session is logged out in %v", vc.Config.Host, credentialsDrainPeriod)
+	time.AfterFunc(credentialsDrainPeriod, func() {
+		if err := oldClient.Logout(context.Background()); err != nil {
+			klog.Warningf("Failed to log out the previous session of vCenter %s. err: %v", vc.Config.Host, err)
+		}
+	})
+	return nil
+}
+
 // GetHostsByCluster return hosts inside the cluster using cluster moref.
 func (vc *VirtualCenter) GetHostsByCluster(ctx context.Context, clusterMorefValue string) ([]*HostSystem, error) {
 	clusterMoref := types.ManagedObjectReference{
@@ -362,3 +454,30 @@ func (vc *VirtualCenter) GetHostsByCluster(ctx context.Context, clusterMorefValu
 	}
 	return hostObjList, nil
 }
+
+// IsDatastoreAccessible returns whether the datastore is accessible, reconnecting to the virtual center if needed
+func (vc *VirtualCenter) IsDatastoreAccessible(ctx context.Context, datastore types.ManagedObjectReference) (bool, error) {
+	if err := vc.Connect(ctx); err != nil {
+		return false, err
+	}
+	var datastoreMo mo.Datastore
+	if err := vc.Client.RetrieveOne(ctx, datastore, []string{"summary.accessible"}, &datastoreMo); err != nil {
+		klog.Errorf("Failed to retrieve summary of datastore %v with err: %v", datastore, err)
+		return false, err
+	}
+	return datastoreMo.Summary.Accessible, nil
+}
+
+// GetDatastoreSummary returns the summary of the datastore, which reports whether it is accessible, its maintenance
+// mode and its free space, reconnecting to the virtual center if needed
+func (vc *VirtualCenter) GetDatastoreSummary(ctx context.Context, datastore types.ManagedObjectReference) (*types.DatastoreSummary, error) {
+	if err := vc.Connect(ctx); err != nil {
+		return nil, err
+	}
+	var datastoreMo mo.Datastore
+	if err := vc.Client.RetrieveOne(ctx, datastore, []string{"summary"}, &datastoreMo); err != nil {
+		klog.Errorf("Failed to retrieve summary of datastore %v with err: %v", datastore, err)
+		return nil, err
+	}
+	return &datastoreMo.Summary, nil
+}
@@ -21,6 +21,8 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/vmware/govmomi/object"
@@ -64,13 +66,37 @@ func (vm *VirtualMachine) IsActive(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
+// GetHardwareVersion returns the hardware version of the virtual machine, such as 13 for "vmx-13".
+func (vm *VirtualMachine) GetHardwareVersion(ctx context.Context) (int, error) {
+	var vmMo mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.version"}, &vmMo)
+	if err != nil {
+		klog.Errorf("Failed to get hardware version of VM %v. err: %+v", vm, err)
+		return 0, err
+	}
+	if vmMo.Config == nil {
+		return 0, fmt.Errorf("config of VM %v is not available", vm)
+	}
+	return ParseHardwareVersion(vmMo.Config.Version)
+}
+
+// ParseHardwareVersion returns the number of the given hardware version of a virtual machine, such as 13 for "vmx-13"
+func ParseHardwareVersion(version string) (int, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(version, "vmx-"))
+	if err != nil || !strings.HasPrefix(version, "vmx-") {
+		return 0, fmt.Errorf("invalid hardware version %q", version)
+	}
+	return number, nil
+}
+
 // renew renews the virtual machine and datacenter objects given its virtual center.
 func (vm *VirtualMachine) renew(vc *VirtualCenter) {
 	vm.VirtualMachine = object.NewVirtualMachine(vc.Client.Client, vm.VirtualMachine.Reference())
 	vm.Datacenter.Datacenter = object.NewDatacenter(vc.Client.Client, vm.Datacenter.Reference())
 }
 
-// GetAllAccessibleDatastores gets the list of accessible Datastores for the given Virtual Machine
+// GetAllAccessibleDatastores gets the list of accessible Datastores for the given Virtual Machine which new volumes
+// can be placed on, leaving out the datastores in or entering maintenance mode
 func (vm *VirtualMachine) GetAllAccessibleDatastores(ctx context.Context) ([]*DatastoreInfo, error) {
 	host, err := vm.HostSystem(ctx)
 	if err != nil {
@@ -80,7 +106,7 @@ func (vm *VirtualMachine) GetAllAccessibleDatastores(ctx context.Context) ([]*Da
 	hostObj := &HostSystem{
 		HostSystem: object.NewHostSystem(vm.Client(), host.Reference()),
 	}
-	return hostObj.GetAllAccessibleDatastores(ctx)
+	return hostObj.GetPlaceableDatastores(ctx)
 }
 
 // Renew renews the virtual machine and datacenter information. If reconnect is
@@ -266,29 +292,49 @@ func (vm *VirtualMachine) GetAncestors(ctx context.Context) ([]mo.ManagedEntity,
 	return objects, nil
 }
 
-// GetZoneRegion returns zone and region of the node vm
+// GetZoneRegion returns zone and region of the node vm, from the tags attached to the vm itself, or else
+// to the closest of its host and the ancestors of its host
 func (vm *VirtualMachine) GetZoneRegion(ctx context.Context, zoneCategoryName string, regionCategoryName string) (zone string, region string, err error) {
 	klog.V(4).Infof("GetZoneRegion: called with zoneCategoryName: %s, regionCategoryName: %s", zoneCategoryName, regionCategoryName)
+	tags, err := vm.GetTopology(ctx, []string{zoneCategoryName, regionCategoryName})
+	if err != nil {
+		return "", "", err
+	}
+	return tags[zoneCategoryName], tags[regionCategoryName], nil
+}
+
+// GetTopology returns the tags of the node vm in the given tag categories by category, from the tags attached to
+// the vm itself, or else to the closest of its host and the ancestors of its host. Categories the vm has no tag
+// in are left out.
+func (vm *VirtualMachine) GetTopology(ctx context.Context, categoryNames []string) (map[string]string, error) {
+	klog.V(4).Infof("GetTopology: called with categoryNames: %v", categoryNames)
 	tagManager, err := vm.GetTagManager(ctx)
 	if err != nil || tagManager == nil {
 		klog.Errorf("Failed to get tagManager. Error: %v", err)
-		return "", "", err
+		return nil, err
 	}
 	defer tagManager.Logout(ctx)
 	var objects []mo.ManagedEntity
 	objects, err = vm.GetAncestors(ctx)
 	if err != nil {
 		klog.Errorf("GetAncestors failed for %s with err %v", vm.Reference(), err)
-		return "", "", err
+		return nil, err
 	}
-	// search the hierarchy, example order: ["Host", "Cluster", "Datacenter", "Folder"]
+	wanted := make(map[string]bool)
+	for _, categoryName := range categoryNames {
+		wanted[categoryName] = true
+	}
+	topology := make(map[string]string)
+	// Tags attached to the vm take precedence, so that nodes can be placed in zones without tagging the hosts
+	objects = append(objects, mo.ManagedEntity{ExtensibleManagedObject: mo.ExtensibleManagedObject{Self: vm.Reference()}})
+	// search the hierarchy, example order: ["VirtualMachine", "Host", "Cluster", "Datacenter", "Folder"]
 	for i := range objects {
 		obj := objects[len(objects)-1-i]
 		klog.V(4).Infof("Name: %s, Type: %s", obj.Self.Value, obj.Self.Type)
 		tags, err := tagManager.ListAttachedTags(ctx, obj)
 		if err != nil {
 			klog.Errorf("Cannot list attached tags. Err: %v", err)
-			return "", "", err
+			return nil, err
 		}
 		if len(tags) > 0 {
 			klog.V(4).Infof("Object [%v] has attached Tags [%v]", obj, tags)
@@ -297,57 +343,53 @@ func (vm *VirtualMachine) GetZoneRegion(ctx context.Context, zoneCategoryName st
 			tag, err := tagManager.GetTag(ctx, value)
 			if err != nil {
 				klog.Errorf("Failed to get tag:%s, error:%v", value, err)
-				return "", "", err
+				return nil, err
 			}
 			klog.V(4).Infof("Found tag: %s for object %v", tag.Name, obj)
 			category, err := tagManager.GetCategory(ctx, tag.CategoryID)
 			if err != nil {
 				klog.Errorf("Failed to get category for tag: %s, error: %v", tag.Name, tag)
-				return "", "", err
+				return nil, err
 			}
 			klog.V(4).Infof("Found category: %s for object %v with tag: %s", category.Name, obj, tag.Name)
 
-			if category.Name == zoneCategoryName && zone == "" {
-				zone = tag.Name
-			} else if category.Name == regionCategoryName && region == "" {
-				region = tag.Name
+			if _, found := topology[category.Name]; wanted[category.Name] && !found {
+				topology[category.Name] = tag.Name
 			}
-			if zone != "" && region != "" {
-				return zone, region, nil
+			if len(topology) == len(wanted) {
+				return topology, nil
 			}
 		}
 	}
-	return zone, region, err
+	return topology, nil
 }
 
 // IsInZoneRegion checks if virtual machine belongs to specified zone and region
 // This function returns true if virtual machine belongs to specified zone/region, else returns false.
 func (vm *VirtualMachine) IsInZoneRegion(ctx context.Context, zoneCategoryName string, regionCategoryName string, zoneValue string, regionValue string) (bool, error) {
 	klog.V(4).Infof("IsInZoneRegion: called with zoneCategoryName: %s, regionCategoryName: %s, zoneValue: %s, regionValue: %s", zoneCategoryName, regionCategoryName, zoneValue, regionValue)
-	tagManager, err := vm.GetTagManager(ctx)
-	if err != nil || tagManager == nil {
-		klog.Errorf("Failed to get tagManager. Error: %v", err)
-		return false, err
-	}
-	defer tagManager.Logout(ctx)
 	vmZone, vmRegion, err := vm.GetZoneRegion(ctx, zoneCategoryName, regionCategoryName)
 	if err != nil {
 		klog.Errorf("failed to get accessibleTopology for vm: %v, err: %v", vm.Reference(), err)
 		return false, err
 	}
+	inZoneRegion := IsZoneRegionMatch(vmZone, vmRegion, zoneValue, regionValue)
+	if inZoneRegion {
+		klog.V(4).Infof("MoRef [%v] belongs to zone [%s] and region [%s]", vm.Reference(), zoneValue, regionValue)
+	}
+	return inZoneRegion, nil
+}
+
+// IsZoneRegionMatch returns whether a virtual machine in the given zone and region belongs to the specified
+// zone and region. An unspecified zone or region matches any.
+func IsZoneRegionMatch(vmZone string, vmRegion string, zoneValue string, regionValue string) bool {
 	if regionValue == "" && zoneValue != "" && vmZone == zoneValue {
 		// region is not specified, if zone matches with look up zone value, return true
-		klog.V(4).Infof("MoRef [%v] belongs to zone [%s]", vm.Reference(), zoneValue)
-		return true, nil
+		return true
 	}
 	if zoneValue == "" && regionValue != "" && vmRegion == regionValue {
 		// zone is not specified, if region matches with look up region value, return true
-		klog.V(4).Infof("MoRef [%v] belongs to region [%s]", vm.Reference(), regionValue)
-		return true, nil
+		return true
 	}
-	if vmZone != "" && vmRegion != "" && vmRegion == regionValue && vmZone == zoneValue {
-		klog.V(4).Infof("MoRef [%v] belongs to zone [%s] and region [%s]", vm.Reference(), zoneValue, regionValue)
-		return true, nil
-	}
-	return false, nil
+	return vmZone != "" && vmRegion != "" && vmRegion == regionValue && vmZone == zoneValue
 }
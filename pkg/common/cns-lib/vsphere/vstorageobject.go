@@ -0,0 +1,409 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
+)
+
+// RetrieveVStorageObject returns the VStorageObject (First Class Disk) with the given id
+// residing on the given datastore.
+func (vc *VirtualCenter) RetrieveVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, volumeID string) (*types.VStorageObject, error) {
+	req := types.RetrieveVStorageObject{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: volumeID},
+		Datastore: datastore,
+	}
+	if err := vc.WaitForAPI(ctx, QueryAPI); err != nil {
+		return nil, err
+	}
+	res, err := methods.RetrieveVStorageObject(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to retrieve VStorageObject %q on datastore %v with err: %v", volumeID, datastore, err)
+		return nil, err
+	}
+	return &res.Returnval, nil
+}
+
+// SetVStorageObjectControlFlags sets the given control flags on the VStorageObject (First Class Disk)
+// with the given id residing on the given datastore.
+func (vc *VirtualCenter) SetVStorageObjectControlFlags(ctx context.Context, datastore types.ManagedObjectReference, volumeID string, controlFlags []string) error {
+	req := types.SetVStorageObjectControlFlags{
+		This:         *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:           types.ID{Id: volumeID},
+		Datastore:    datastore,
+		ControlFlags: controlFlags,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return err
+	}
+	_, err := methods.SetVStorageObjectControlFlags(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to set control flags %v on VStorageObject %q on datastore %v with err: %v", controlFlags, volumeID, datastore, err)
+		return err
+	}
+	return nil
+}
+
+// ListVStorageObjects returns the ids of all VStorageObjects (First Class Disks) residing on the given datastore.
+func (vc *VirtualCenter) ListVStorageObjects(ctx context.Context, datastore types.ManagedObjectReference) ([]types.ID, error) {
+	req := types.ListVStorageObject{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Datastore: datastore,
+	}
+	if err := vc.WaitForAPI(ctx, QueryAPI); err != nil {
+		return nil, err
+	}
+	res, err := methods.ListVStorageObject(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to list VStorageObjects on datastore %v with err: %v", datastore, err)
+		return nil, err
+	}
+	return res.Returnval, nil
+}
+
+// RegisterDisk registers the virtual disk with the given URL path as a VStorageObject (First Class Disk)
+// with the given name, and returns the VStorageObject.
+func (vc *VirtualCenter) RegisterDisk(ctx context.Context, path string, name string) (*types.VStorageObject, error) {
+	req := types.RegisterDisk{
+		This: *vc.Client.ServiceContent.VStorageObjectManager,
+		Path: path,
+		Name: name,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return nil, err
+	}
+	res, err := methods.RegisterDisk(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to register disk %q as VStorageObject with err: %v", path, err)
+		return nil, err
+	}
+	return &res.Returnval, nil
+}
+
+// RenameVStorageObject renames the VStorageObject (First Class Disk) with the given id
+// residing on the given datastore.
+func (vc *VirtualCenter) RenameVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, volumeID string, name string) error {
+	req := types.RenameVStorageObject{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: volumeID},
+		Datastore: datastore,
+		Name:      name,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return err
+	}
+	_, err := methods.RenameVStorageObject(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to rename VStorageObject %q on datastore %v to %q with err: %v", volumeID, datastore, name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteVStorageObject deletes the VStorageObject (First Class Disk) with the given id
+// residing on the given datastore and waits for the task to complete.
+func (vc *VirtualCenter) DeleteVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, volumeID string) error {
+	req := types.DeleteVStorageObject_Task{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: volumeID},
+		Datastore: datastore,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return err
+	}
+	res, err := methods.DeleteVStorageObject_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to delete VStorageObject %q on datastore %v with err: %v", volumeID, datastore, err)
+		return err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		return task.Wait(ctx)
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeDeleteVStorageObject, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeDeleteVStorageObject, "", start)
+	if err != nil {
+		klog.Errorf("Delete task for VStorageObject %q on datastore %v failed with err: %v", volumeID, datastore, err)
+		return err
+	}
+	return nil
+}
+
+// CreateVStorageObjectSnapshot creates a snapshot with the given description of the VStorageObject (First Class Disk)
+// with the given id residing on the given datastore, waits for the task to complete and returns the snapshot id.
+func (vc *VirtualCenter) CreateVStorageObjectSnapshot(ctx context.Context, datastore types.ManagedObjectReference, volumeID string, description string) (string, error) {
+	req := types.VStorageObjectCreateSnapshot_Task{
+		This:        *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:          types.ID{Id: volumeID},
+		Datastore:   datastore,
+		Description: description,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return "", err
+	}
+	res, err := methods.VStorageObjectCreateSnapshot_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to create snapshot of VStorageObject %q on datastore %v with err: %v", volumeID, datastore, err)
+		return "", err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	var taskInfo *types.TaskInfo
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		taskInfo, err = task.WaitForResult(ctx, nil)
+		return err
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeCreateSnapshot, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeCreateSnapshot, "", start)
+	if err != nil {
+		klog.Errorf("Create snapshot task for VStorageObject %q on datastore %v failed with err: %v", volumeID, datastore, err)
+		return "", err
+	}
+	snapshotID, ok := taskInfo.Result.(types.ID)
+	if !ok {
+		return "", fmt.Errorf("unexpected result %+v of create snapshot task for VStorageObject %q", taskInfo.Result, volumeID)
+	}
+	return snapshotID.Id, nil
+}
+
+// RetrieveVStorageObjectSnapshots returns the snapshots of the VStorageObject (First Class Disk) with the given id
+// residing on the given datastore.
+func (vc *VirtualCenter) RetrieveVStorageObjectSnapshots(ctx context.Context, datastore types.ManagedObjectReference, volumeID string) ([]types.VStorageObjectSnapshotInfoVStorageObjectSnapshot, error) {
+	req := types.RetrieveSnapshotInfo{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: volumeID},
+		Datastore: datastore,
+	}
+	if err := vc.WaitForAPI(ctx, QueryAPI); err != nil {
+		return nil, err
+	}
+	res, err := methods.RetrieveSnapshotInfo(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to retrieve snapshots of VStorageObject %q on datastore %v with err: %v", volumeID, datastore, err)
+		return nil, err
+	}
+	return res.Returnval.Snapshots, nil
+}
+
+// DeleteVStorageObjectSnapshot deletes the snapshot with the given id of the VStorageObject (First Class Disk)
+// with the given id residing on the given datastore and waits for the task to complete.
+func (vc *VirtualCenter) DeleteVStorageObjectSnapshot(ctx context.Context, datastore types.ManagedObjectReference, volumeID string, snapshotID string) error {
+	req := types.DeleteSnapshot_Task{
+		This:       *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:         types.ID{Id: volumeID},
+		Datastore:  datastore,
+		SnapshotId: types.ID{Id: snapshotID},
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return err
+	}
+	res, err := methods.DeleteSnapshot_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to delete snapshot %q of VStorageObject %q on datastore %v with err: %v", snapshotID, volumeID, datastore, err)
+		return err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		return task.Wait(ctx)
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeDeleteSnapshot, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeDeleteSnapshot, "", start)
+	if err != nil {
+		klog.Errorf("Delete snapshot task for snapshot %q of VStorageObject %q on datastore %v failed with err: %v", snapshotID, volumeID, datastore, err)
+		return err
+	}
+	return nil
+}
+
+// CreateVStorageObjectFromSnapshot creates a VStorageObject (First Class Disk) with the given name and profile from
+// the snapshot with the given id of the VStorageObject with the given id residing on the given datastore. It waits for
+// the task to complete and returns the id of the new VStorageObject, which resides on the same datastore.
+func (vc *VirtualCenter) CreateVStorageObjectFromSnapshot(ctx context.Context, datastore types.ManagedObjectReference, volumeID string,
+	snapshotID string, name string, profile []types.BaseVirtualMachineProfileSpec) (string, error) {
+	req := types.CreateDiskFromSnapshot_Task{
+		This:       *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:         types.ID{Id: volumeID},
+		Datastore:  datastore,
+		SnapshotId: types.ID{Id: snapshotID},
+		Name:       name,
+		Profile:    profile,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return "", err
+	}
+	res, err := methods.CreateDiskFromSnapshot_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to create VStorageObject %q from snapshot %q of VStorageObject %q on datastore %v with err: %v", name, snapshotID, volumeID, datastore, err)
+		return "", err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	var taskInfo *types.TaskInfo
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		taskInfo, err = task.WaitForResult(ctx, nil)
+		return err
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeCreateDiskFromSnapshot, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeCreateDiskFromSnapshot, "", start)
+	if err != nil {
+		klog.Errorf("Create task for VStorageObject %q from snapshot %q of VStorageObject %q on datastore %v failed with err: %v", name, snapshotID, volumeID, datastore, err)
+		return "", err
+	}
+	vStorageObject, ok := taskInfo.Result.(types.VStorageObject)
+	if !ok {
+		return "", fmt.Errorf("unexpected result %+v of create task for VStorageObject %q from snapshot %q", taskInfo.Result, name, snapshotID)
+	}
+	return vStorageObject.Config.Id.Id, nil
+}
+
+// CloneVStorageObject clones the VStorageObject (First Class Disk) with the given id residing on the given datastore
+// to a VStorageObject with the given name and profile on the target datastore. It waits for the task to complete and
+// returns the id of the clone, which is kept when a VM it is attached to is deleted.
+func (vc *VirtualCenter) CloneVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, volumeID string,
+	target types.ManagedObjectReference, name string, profile []types.BaseVirtualMachineProfileSpec) (string, error) {
+	req := types.CloneVStorageObject_Task{
+		This:      *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: volumeID},
+		Datastore: datastore,
+		Spec: types.VslmCloneSpec{
+			VslmMigrateSpec: types.VslmMigrateSpec{
+				BackingSpec: &types.VslmCreateSpecDiskFileBackingSpec{
+					VslmCreateSpecBackingSpec: types.VslmCreateSpecBackingSpec{Datastore: target},
+				},
+				Profile: profile,
+			},
+			Name:              name,
+			KeepAfterDeleteVm: types.NewBool(true),
+		},
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return "", err
+	}
+	res, err := methods.CloneVStorageObject_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to clone VStorageObject %q on datastore %v to %q with err: %v", volumeID, datastore, name, err)
+		return "", err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	var taskInfo *types.TaskInfo
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		taskInfo, err = task.WaitForResult(ctx, nil)
+		return err
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeCloneVStorageObject, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeCloneVStorageObject, "", start)
+	if err != nil {
+		klog.Errorf("Clone task for VStorageObject %q on datastore %v to %q failed with err: %v", volumeID, datastore, name, err)
+		return "", err
+	}
+	vStorageObject, ok := taskInfo.Result.(types.VStorageObject)
+	if !ok {
+		return "", fmt.Errorf("unexpected result %+v of clone task for VStorageObject %q", taskInfo.Result, volumeID)
+	}
+	return vStorageObject.Config.Id.Id, nil
+}
+
+// ExtendVStorageObject extends the VStorageObject (First Class Disk) with the given id residing on the given datastore
+// to the given capacity and waits for the task to complete. The VStorageObject must not be attached to a VM.
+func (vc *VirtualCenter) ExtendVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, volumeID string, capacityMB int64) error {
+	req := types.ExtendDisk_Task{
+		This:            *vc.Client.ServiceContent.VStorageObjectManager,
+		Id:              types.ID{Id: volumeID},
+		Datastore:       datastore,
+		NewCapacityInMB: capacityMB,
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return err
+	}
+	res, err := methods.ExtendDisk_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to extend VStorageObject %q on datastore %v to %d MB with err: %v", volumeID, datastore, capacityMB, err)
+		return err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		return task.Wait(ctx)
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeExtendVStorageObject, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeExtendVStorageObject, "", start)
+	if err != nil {
+		klog.Errorf("Extend task for VStorageObject %q on datastore %v failed with err: %v", volumeID, datastore, err)
+		return err
+	}
+	return nil
+}
+
+// CreateVStorageObject creates a VStorageObject (First Class Disk) with the given name, capacity, provisioning type
+// and profile on the given datastore and waits for the task to complete. Returns the id of the VStorageObject.
+func (vc *VirtualCenter) CreateVStorageObject(ctx context.Context, datastore types.ManagedObjectReference, name string,
+	capacityMB int64, provisioningType string, profile []types.BaseVirtualMachineProfileSpec) (string, error) {
+	req := types.CreateDisk_Task{
+		This: *vc.Client.ServiceContent.VStorageObjectManager,
+		Spec: types.VslmCreateSpec{
+			Name:              name,
+			KeepAfterDeleteVm: types.NewBool(true),
+			BackingSpec: &types.VslmCreateSpecDiskFileBackingSpec{
+				VslmCreateSpecBackingSpec: types.VslmCreateSpecBackingSpec{Datastore: datastore},
+				ProvisioningType:          provisioningType,
+			},
+			CapacityInMB: capacityMB,
+			Profile:      profile,
+		},
+	}
+	if err := vc.WaitForAPI(ctx, ReconfigureAPI); err != nil {
+		return "", err
+	}
+	res, err := methods.CreateDisk_Task(ctx, vc.Client, &req)
+	if err != nil {
+		klog.Errorf("Failed to create VStorageObject %q on datastore %v with err: %v", name, datastore, err)
+		return "", err
+	}
+	task := object.NewTask(vc.Client.Client, res.Returnval)
+	start := time.Now()
+	var taskInfo *types.TaskInfo
+	err = backoff.OnError(ctx, "Polling task "+res.Returnval.Value, IsNetworkError, func() error {
+		taskInfo, err = task.WaitForResult(ctx, nil)
+		return err
+	})
+	prometheus.ObserveVCenterTask(prometheus.TaskTypeCreateVStorageObject, start, err)
+	slowlog.ObserveCNS(prometheus.TaskTypeCreateVStorageObject, "", start)
+	if err != nil {
+		klog.Errorf("Create task for VStorageObject %q on datastore %v failed with err: %v", name, datastore, err)
+		return "", err
+	}
+	vStorageObject, ok := taskInfo.Result.(types.VStorageObject)
+	if !ok {
+		return "", fmt.Errorf("unexpected result %+v of create task for VStorageObject %q", taskInfo.Result, name)
+	}
+	return vStorageObject.Config.Id.Id, nil
+}
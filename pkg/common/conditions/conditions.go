@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conditions provides the helpers and the standardized reasons used to report the
+// Ready, InProgress and Failed conditions of the driver custom resources.
+package conditions
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+)
+
+// Standardized machine-readable reasons of the conditions of driver custom resources
+const (
+	// ReasonSucceeded is the reason of the Ready condition once the request is carried out
+	ReasonSucceeded = "Succeeded"
+	// ReasonInProgress is the reason of the InProgress condition while the request is carried out
+	ReasonInProgress = "InProgress"
+	// ReasonInvalidSpec indicates that the spec of the resource is invalid
+	ReasonInvalidSpec = "InvalidSpec"
+	// ReasonNotFound indicates that an object referred to by the resource was not found
+	ReasonNotFound = "NotFound"
+	// ReasonConflict indicates that the request conflicts with the current state of the object
+	ReasonConflict = "Conflict"
+	// ReasonVCenterError indicates that an operation on vCenter failed
+	ReasonVCenterError = "VCenterError"
+	// ReasonKubernetesError indicates that an operation on the Kubernetes API server failed
+	ReasonKubernetesError = "KubernetesError"
+)
+
+// Error is an error along with the reason to report in the Failed condition
+type Error struct {
+	Reason string
+	Err    error
+}
+
+// Error returns the message of the underlying error
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// NewError returns an error reported in the Failed condition with the given reason
+func NewError(reason string, err error) error {
+	return &Error{Reason: reason, Err: err}
+}
+
+// ReasonForError returns the reason of the given error if it was created by NewError, else defaultReason
+func ReasonForError(err error, defaultReason string) string {
+	if e, ok := err.(*Error); ok {
+		return e.Reason
+	}
+	return defaultReason
+}
+
+// Get returns the condition of the given type, or nil if not set
+func Get(conditions []cnsv1alpha1.Condition, conditionType cnsv1alpha1.ConditionType) *cnsv1alpha1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsTrue returns true if the condition of the given type is set and True
+func IsTrue(conditions []cnsv1alpha1.Condition, conditionType cnsv1alpha1.ConditionType) bool {
+	condition := Get(conditions, conditionType)
+	return condition != nil && condition.Status == v1.ConditionTrue
+}
+
+// Set sets the condition of the given type. The last transition time is only updated if the status changes.
+func Set(conditions *[]cnsv1alpha1.Condition, conditionType cnsv1alpha1.ConditionType, status v1.ConditionStatus, reason string, message string) {
+	condition := Get(*conditions, conditionType)
+	if condition == nil {
+		*conditions = append(*conditions, cnsv1alpha1.Condition{Type: conditionType})
+		condition = &(*conditions)[len(*conditions)-1]
+	}
+	if condition.Status != status {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Status = status
+	condition.Reason = reason
+	condition.Message = message
+}
+
+// MarkInProgress sets the InProgress condition and clears the Ready and Failed conditions
+func MarkInProgress(conditions *[]cnsv1alpha1.Condition, message string) {
+	Set(conditions, cnsv1alpha1.ConditionInProgress, v1.ConditionTrue, ReasonInProgress, message)
+	Set(conditions, cnsv1alpha1.ConditionReady, v1.ConditionFalse, ReasonInProgress, message)
+	Set(conditions, cnsv1alpha1.ConditionFailed, v1.ConditionFalse, ReasonInProgress, message)
+}
+
+// MarkReady sets the Ready condition and clears the InProgress and Failed conditions
+func MarkReady(conditions *[]cnsv1alpha1.Condition, message string) {
+	Set(conditions, cnsv1alpha1.ConditionReady, v1.ConditionTrue, ReasonSucceeded, message)
+	Set(conditions, cnsv1alpha1.ConditionInProgress, v1.ConditionFalse, ReasonSucceeded, message)
+	Set(conditions, cnsv1alpha1.ConditionFailed, v1.ConditionFalse, ReasonSucceeded, message)
+}
+
+// MarkFailed sets the Failed condition with the reason of the given error and clears the Ready and InProgress conditions
+func MarkFailed(conditions *[]cnsv1alpha1.Condition, err error, defaultReason string) {
+	reason := ReasonForError(err, defaultReason)
+	Set(conditions, cnsv1alpha1.ConditionFailed, v1.ConditionTrue, reason, err.Error())
+	Set(conditions, cnsv1alpha1.ConditionReady, v1.ConditionFalse, reason, err.Error())
+	Set(conditions, cnsv1alpha1.ConditionInProgress, v1.ConditionFalse, reason, err.Error())
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conditions
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+)
+
+func TestConditionTransitions(t *testing.T) {
+	var conditions []cnsv1alpha1.Condition
+
+	MarkInProgress(&conditions, "restoring")
+	if len(conditions) != 3 || !IsTrue(conditions, cnsv1alpha1.ConditionInProgress) || IsTrue(conditions, cnsv1alpha1.ConditionReady) {
+		t.Fatalf("unexpected conditions after MarkInProgress: %+v", conditions)
+	}
+
+	MarkFailed(&conditions, NewError(ReasonNotFound, errors.New("volume not found")), ReasonVCenterError)
+	failed := Get(conditions, cnsv1alpha1.ConditionFailed)
+	if failed == nil || failed.Status != v1.ConditionTrue || failed.Reason != ReasonNotFound || failed.Message != "volume not found" {
+		t.Fatalf("unexpected Failed condition: %+v", failed)
+	}
+	if IsTrue(conditions, cnsv1alpha1.ConditionInProgress) {
+		t.Fatalf("InProgress condition is not cleared by MarkFailed: %+v", conditions)
+	}
+
+	MarkFailed(&conditions, errors.New("connection refused"), ReasonVCenterError)
+	if reason := Get(conditions, cnsv1alpha1.ConditionFailed).Reason; reason != ReasonVCenterError {
+		t.Fatalf("expected default reason %s, got %s", ReasonVCenterError, reason)
+	}
+
+	MarkReady(&conditions, "done")
+	if len(conditions) != 3 || !IsTrue(conditions, cnsv1alpha1.ConditionReady) || IsTrue(conditions, cnsv1alpha1.ConditionFailed) {
+		t.Fatalf("unexpected conditions after MarkReady: %+v", conditions)
+	}
+}
@@ -25,6 +25,8 @@ import (
 	"strings"
 
 	"gopkg.in/gcfg.v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog"
 )
 
@@ -55,6 +57,17 @@ var (
 	// ErrMissingVCenter is returned when the provided configuration does not
 	// define any vCenters.
 	ErrMissingVCenter = errors.New("No Virtual Center hosts defined")
+
+	// ErrInvalidDatastoreAccess is returned when a datastore access rule
+	// does not match any namespace or does not list any datastore.
+	ErrInvalidDatastoreAccess = errors.New("Datastore access rule must list namespaces or a namespace selector, and datastore URLs")
+
+	// ErrInvalidRateLimit is returned when a rate limit of vCenter calls is negative.
+	ErrInvalidRateLimit = errors.New("vCenter rate limits must not be negative")
+
+	// ErrInvalidTopology is returned when the topology categories or keys are not unique, when a topology key
+	// is not a valid label name, or when the number of topology keys does not match the number of categories.
+	ErrInvalidTopology = errors.New("Topology categories and keys must be unique, with one valid label name per category")
 )
 
 func getEnvKeyValue(match string, partial bool) (string, string, error) {
@@ -127,6 +140,12 @@ func FromEnv(cfg *Config) error {
 	if v := os.Getenv("VSPHERE_LABEL_ZONE"); v != "" {
 		cfg.Labels.Zone = v
 	}
+	if v := os.Getenv("VSPHERE_LABEL_TOPOLOGY_CATEGORIES"); v != "" {
+		cfg.Labels.TopologyCategories = v
+	}
+	if v := os.Getenv("VSPHERE_LABEL_TOPOLOGY_KEYS"); v != "" {
+		cfg.Labels.TopologyKeys = v
+	}
 	//Build VirtualCenter from ENVs
 	for _, e := range os.Environ() {
 		pair := strings.Split(e, "=")
@@ -235,10 +254,102 @@ func validateConfig(cfg *Config) error {
 		if !insecure {
 			vcConfig.InsecureFlag = cfg.Global.InsecureFlag
 		}
+		if vcConfig.QueryQPS == 0 {
+			vcConfig.QueryQPS = cfg.Global.QueryQPS
+		}
+		if vcConfig.QueryBurst == 0 {
+			vcConfig.QueryBurst = cfg.Global.QueryBurst
+		}
+		if vcConfig.ReconfigureQPS == 0 {
+			vcConfig.ReconfigureQPS = cfg.Global.ReconfigureQPS
+		}
+		if vcConfig.ReconfigureBurst == 0 {
+			vcConfig.ReconfigureBurst = cfg.Global.ReconfigureBurst
+		}
+		if vcConfig.QueryQPS < 0 || vcConfig.QueryBurst < 0 || vcConfig.ReconfigureQPS < 0 || vcConfig.ReconfigureBurst < 0 {
+			klog.Errorf("Rate limits of vc %s are invalid", vcServer)
+			return ErrInvalidRateLimit
+		}
+	}
+	for name, rule := range cfg.DatastoreAccess {
+		if (rule.Namespaces == "" && rule.NamespaceSelector == "") || rule.DatastoreURLs == "" {
+			klog.Errorf("Datastore access rule %q is invalid", name)
+			return ErrInvalidDatastoreAccess
+		}
+		if _, err := labels.Parse(rule.NamespaceSelector); err != nil {
+			klog.Errorf("Datastore access rule %q has an invalid namespace selector. Err: %v", name, err)
+			return err
+		}
+	}
+	return validateTopology(cfg)
+}
+
+// validateTopology returns ErrInvalidTopology if the topology categories or keys of the config are invalid
+func validateTopology(cfg *Config) error {
+	categories, keys := cfg.GetTopologyCategories(), splitList(cfg.Labels.TopologyKeys)
+	if cfg.Labels.TopologyCategories != "" && len(keys) == 0 {
+		klog.Errorf("Topology keys are required with topology categories %q", cfg.Labels.TopologyCategories)
+		return ErrInvalidTopology
+	}
+	if len(keys) != 0 && len(keys) != len(categories) {
+		klog.Errorf("Topology keys %q do not match topology categories %q", keys, categories)
+		return ErrInvalidTopology
+	}
+	if hasDuplicates(categories) || hasDuplicates(keys) {
+		klog.Errorf("Topology categories %q or keys %q are not unique", categories, keys)
+		return ErrInvalidTopology
+	}
+	for _, key := range keys {
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			klog.Errorf("Topology key %q is invalid: %s", key, strings.Join(errs, ", "))
+			return ErrInvalidTopology
+		}
 	}
 	return nil
 }
 
+// GetTopologyCategories returns the tag categories of the levels of the topology of the nodes from the outermost:
+// the configured topology categories if any, otherwise the region and zone categories if both are set.
+// Returns nil if the topology of the nodes is not configured.
+func (cfg *Config) GetTopologyCategories() []string {
+	if cfg.Labels.TopologyCategories != "" {
+		return splitList(cfg.Labels.TopologyCategories)
+	}
+	if cfg.Labels.Zone != "" && cfg.Labels.Region != "" {
+		return []string{cfg.Labels.Region, cfg.Labels.Zone}
+	}
+	return nil
+}
+
+// GetTopologyKeys returns the configured topology keys of the levels of the topology of the nodes,
+// nil if the default keys are used
+func (cfg *Config) GetTopologyKeys() []string {
+	return splitList(cfg.Labels.TopologyKeys)
+}
+
+// splitList returns the trimmed, non-empty items of the given comma separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// hasDuplicates returns whether an item occurs several times in the given list
+func hasDuplicates(items []string) bool {
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item] {
+			return true
+		}
+		seen[item] = true
+	}
+	return false
+}
+
 // ReadConfig parses vSphere cloud config file and stores it into VSphereConfig.
 // Environment variables are also checked
 func ReadConfig(config io.Reader) (*Config, error) {
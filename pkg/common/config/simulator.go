@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	cnssim "github.com/vmware/govmomi/cns/simulator"
+	pbmsim "github.com/vmware/govmomi/pbm/simulator"
+	"github.com/vmware/govmomi/simulator"
+	"k8s.io/klog"
+)
+
+// simulatorConfigTemplate is the config file of the driver against a vcsim instance
+const simulatorConfigTemplate = `[Global]
+cluster-id = %q
+
+[VirtualCenter %q]
+user = %q
+password = %q
+port = %q
+insecure-flag = "true"
+datacenters = %q
+`
+
+// StartSimulator starts a vcsim instance with the CNS and PBM simulators, so that the driver can be run
+// locally without a vCenter. The config file for use against the instance is written to a temporary directory
+// and EnvCloudConfig is set to its path, so that it is picked up in place of the configured one.
+// The returned function stops the instance and removes the config file.
+func StartSimulator(clusterID string) (func(), error) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		return nil, err
+	}
+	model.Service.TLS = new(tls.Config)
+	s := model.Service.NewServer()
+	model.Service.RegisterSDK(cnssim.New())
+	model.Service.RegisterSDK(pbmsim.New())
+	stop := func() {
+		s.Close()
+		model.Remove()
+	}
+
+	dir, err := ioutil.TempDir("", "vsphere-csi-simulator")
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	password, _ := s.URL.User.Password()
+	datacenter := simulator.Map.Any("Datacenter").(*simulator.Datacenter).Name
+	cfgPath := filepath.Join(dir, "csi-vsphere.conf")
+	cfg := fmt.Sprintf(simulatorConfigTemplate, clusterID, s.URL.Hostname(), s.URL.User.Username(), password,
+		s.URL.Port(), datacenter)
+	if err = ioutil.WriteFile(cfgPath, []byte(cfg), 0600); err != nil {
+		stop()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err = os.Setenv(EnvCloudConfig, cfgPath); err != nil {
+		stop()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	klog.Infof("Started vCenter simulator on %s with config %s", s.URL.Host, cfgPath)
+	// Kubernetes nodes are registered by the UUID in their provider ID, hence list the UUIDs of the simulated VMs.
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		klog.Infof("Simulated VM %s has provider ID vsphere://%s", vm.Name, vm.Config.Uuid)
+	}
+	return func() {
+		stop()
+		os.RemoveAll(dir)
+	}, nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStartSimulator(t *testing.T) {
+	defer os.Unsetenv(EnvCloudConfig)
+	stop, err := StartSimulator("test-cluster")
+	if err != nil {
+		t.Fatalf("StartSimulator failed: %v", err)
+	}
+	defer stop()
+	cfg, err := GetCnsconfig(os.Getenv(EnvCloudConfig))
+	if err != nil {
+		t.Fatalf("Failed to read the simulator config: %v", err)
+	}
+	if cfg.Global.ClusterID != "test-cluster" || len(cfg.VirtualCenter) != 1 {
+		t.Errorf("unexpected simulator config: %+v", cfg)
+	}
+	for host, vc := range cfg.VirtualCenter {
+		if host == "" || vc.User == "" || vc.Password == "" || vc.VCenterPort == "" || !vc.InsecureFlag || vc.Datacenters == "" {
+			t.Errorf("unexpected simulator vCenter config %q: %+v", host, vc)
+		}
+	}
+}
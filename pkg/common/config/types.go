@@ -38,15 +38,42 @@ type Config struct {
 		CAFile string `gcfg:"ca-file"`
 		// Datacenter in which Node VMs are located.
 		Datacenters string `gcfg:"datacenters"`
+		// Prefix of the names of volumes created by the driver, replacing the "pvc-" prefix of the PV names,
+		// e.g. "pvc-cluster1-". Optional; volumes are named after their PV if not configured.
+		VolumeNamePrefix string `gcfg:"volume-name-prefix"`
+		// Specifies whether to add a PVSCSI controller to a node VM whose PVSCSI controllers have no free
+		// disk slot left when attaching a volume, up to 4 SCSI controllers. Attaching fails otherwise.
+		HotAddSCSIControllers bool `gcfg:"hot-add-scsi-controllers"`
+		// Rate limits of the calls to vCenter, in calls per second and bursts of calls, for each vCenter.
+		// Calls reading vCenter and calls changing it, such as creating volumes or attaching them to node VMs,
+		// have separate limits. Optional; calls are not limited if not configured.
+		QueryQPS         float64 `gcfg:"query-qps"`
+		QueryBurst       int     `gcfg:"query-burst"`
+		ReconfigureQPS   float64 `gcfg:"reconfigure-qps"`
+		ReconfigureBurst int     `gcfg:"reconfigure-burst"`
 	}
 
 	// Virtual Center configurations
 	VirtualCenter map[string]*VirtualCenterConfig
 
 	// Tag categories and tags which correspond to "built-in node labels: zones and region"
-	Labels struct {
-		Zone   string `gcfg:"zone"`
-		Region string `gcfg:"region"`
+	Labels Labels
+
+	// Datastore access rules restricting the datastores volumes of namespaces are placed on
+	DatastoreAccess map[string]*DatastoreAccessConfig
+
+	// Placement of volumes among the candidate datastores
+	Placement struct {
+		// Strategy choosing the datastore of a volume: most-free-space, round-robin, weighted, policy, random,
+		// or cns to leave the choice to CNS. Optional; X_CSI_PLACEMENT_STRATEGY is used if not configured.
+		Strategy string `gcfg:"strategy"`
+		// Comma separated list of datastore URL=weight pairs of the weighted strategy, which places volumes on
+		// a random datastore with a probability proportional to its free space times its weight. Datastores
+		// not listed weigh 1.
+		DatastoreWeights string `gcfg:"datastore-weights"`
+		// Comma separated list of storage policy name=strategy pairs of the policy strategy. Volumes of other
+		// storage policies are placed with most-free-space.
+		PolicyStrategies string `gcfg:"policy-strategies"`
 	}
 }
 
@@ -63,4 +90,33 @@ type VirtualCenterConfig struct {
 	InsecureFlag bool `gcfg:"insecure-flag"`
 	// Datacenter in which VMs are located.
 	Datacenters string `gcfg:"datacenters"`
+	// Rate limits of the calls to the vCenter, overriding the global ones.
+	QueryQPS         float64 `gcfg:"query-qps"`
+	QueryBurst       int     `gcfg:"query-burst"`
+	ReconfigureQPS   float64 `gcfg:"reconfigure-qps"`
+	ReconfigureBurst int     `gcfg:"reconfigure-burst"`
+}
+
+// Labels contains the tag categories and tags which correspond to "built-in node labels: zones and region".
+type Labels struct {
+	Zone   string `gcfg:"zone"`
+	Region string `gcfg:"region"`
+	// Comma separated tag categories of the levels of the topology of the nodes from the outermost,
+	// such as "k8s-datacenter,k8s-cluster,k8s-host-group", in place of Zone and Region
+	TopologyCategories string `gcfg:"topology-categories"`
+	// Comma separated topology keys published for the levels of the topology, in the order of the categories.
+	// Required with TopologyCategories, and defaults to the topology labels for Zone and Region.
+	TopologyKeys string `gcfg:"topology-keys"`
+}
+
+// DatastoreAccessConfig reserves datastores to the namespaces it matches. Volumes of matching namespaces
+// can only be placed on the datastores of the rules they match, and volumes of other namespaces cannot
+// be placed on the datastores of any rule.
+type DatastoreAccessConfig struct {
+	// Comma separated list of namespaces matched by the rule.
+	Namespaces string `gcfg:"namespaces"`
+	// Label selector of namespaces matched by the rule.
+	NamespaceSelector string `gcfg:"namespace-selector"`
+	// Comma separated list of URLs of the datastores reserved to the matched namespaces.
+	DatastoreURLs string `gcfg:"datastore-urls"`
 }
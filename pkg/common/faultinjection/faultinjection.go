@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package faultinjection injects faults into CNS operations at configurable rates, so that retry, idempotency
+// and full sync code paths can be exercised in resilience tests. It is disabled unless the flag is set.
+package faultinjection
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// Kind is a kind of fault
+type Kind string
+
+const (
+	// Timeout fails the call to vCenter with a network timeout before it is made
+	Timeout Kind = "timeout"
+	// NotAuthenticated fails the call to vCenter with a NotAuthenticated fault before it is made
+	NotAuthenticated Kind = "not-authenticated"
+	// TaskFailure fails the operation after its vCenter task completed successfully
+	TaskFailure Kind = "task-failure"
+
+	// AnyOperation matches all operations in a rule
+	AnyOperation = "*"
+)
+
+// rule injects faults of a kind into an operation at a rate
+type rule struct {
+	operation string
+	kind      Kind
+	rate      float64
+}
+
+var (
+	lock   sync.Mutex
+	rules  []rule
+	random *rand.Rand
+
+	// spec and seed are set by the flags registered with InitFlags
+	spec string
+	seed int64 = 1
+)
+
+// InitFlags registers the fault injection flags on the given flagset, or on flag.CommandLine if it is nil
+func InitFlags(flagset *flag.FlagSet) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	flagset.StringVar(&spec, "fault-injection", spec,
+		"Comma separated list of operation:kind:rate rules injecting faults into CNS operations, for resilience testing only. "+
+			"Operations are CNS operation names, e.g. createVolume, or * for all. Kinds are timeout, not-authenticated and task-failure. "+
+			"Rates are between 0 and 1")
+	flagset.Int64Var(&seed, "fault-injection-seed", seed, "Seed of the random faults injected, so that runs are reproducible")
+}
+
+// Init enables the fault injection rules set by the flags. Must be called after flags are parsed.
+func Init() error {
+	return Configure(spec, seed)
+}
+
+// Configure sets the fault injection rules from the given spec and seeds the random faults.
+// An empty spec disables fault injection.
+func Configure(spec string, seed int64) error {
+	parsed, err := parseRules(spec)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	rules = parsed
+	random = rand.New(rand.NewSource(seed))
+	if len(rules) > 0 {
+		klog.Warningf("Fault injection is enabled with rules %q", spec)
+	}
+	return nil
+}
+
+func parseRules(spec string) ([]rule, error) {
+	var parsed []rule
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		fields := strings.Split(r, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("fault injection rule %q is not of the form operation:kind:rate", r)
+		}
+		kind := Kind(fields[1])
+		if kind != Timeout && kind != NotAuthenticated && kind != TaskFailure {
+			return nil, fmt.Errorf("fault injection rule %q has unknown kind %q", r, kind)
+		}
+		rate, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("fault injection rule %q has invalid rate %q", r, fields[2])
+		}
+		parsed = append(parsed, rule{operation: fields[0], kind: kind, rate: rate})
+	}
+	return parsed, nil
+}
+
+// Inject returns the kind of fault to inject into the operation among the given kinds, or "" for none.
+// Rules are evaluated in order and the first one drawn wins.
+func Inject(operation string, kinds ...Kind) Kind {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, r := range rules {
+		if r.operation != operation && r.operation != AnyOperation {
+			continue
+		}
+		for _, kind := range kinds {
+			if r.kind == kind && random.Float64() < r.rate {
+				klog.Warningf("Injecting %s fault into %s", kind, operation)
+				return kind
+			}
+		}
+	}
+	return ""
+}
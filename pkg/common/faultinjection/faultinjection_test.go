@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faultinjection
+
+import (
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		spec  string
+		rules int
+		valid bool
+	}{
+		{"", 0, true},
+		{"CreateVolume:timeout:0.5", 1, true},
+		{"CreateVolume:timeout:0.5, *:task-failure:1", 2, true},
+		{"CreateVolume:timeout", 0, false},
+		{"CreateVolume:crash:0.5", 0, false},
+		{"CreateVolume:timeout:2", 0, false},
+		{"CreateVolume:timeout:x", 0, false},
+	}
+	for _, test := range tests {
+		rules, err := parseRules(test.spec)
+		if (err == nil) != test.valid || len(rules) != test.rules {
+			t.Errorf("parseRules(%q) returned %d rules and err %v, expected %d rules and valid %t",
+				test.spec, len(rules), err, test.rules, test.valid)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	defer Configure("", 1)
+	if err := Configure("CreateVolume:timeout:1,*:task-failure:1,DeleteVolume:not-authenticated:0", 1); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	tests := []struct {
+		operation string
+		kinds     []Kind
+		expected  Kind
+	}{
+		{"CreateVolume", []Kind{Timeout, NotAuthenticated}, Timeout},
+		{"CreateVolume", []Kind{TaskFailure}, TaskFailure},
+		{"DeleteVolume", []Kind{Timeout, NotAuthenticated}, ""},
+		{"DeleteVolume", []Kind{TaskFailure}, TaskFailure},
+	}
+	for _, test := range tests {
+		if actual := Inject(test.operation, test.kinds...); actual != test.expected {
+			t.Errorf("Inject(%s, %v) = %q, expected %q", test.operation, test.kinds, actual, test.expected)
+		}
+	}
+}
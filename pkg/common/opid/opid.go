@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package opid assigns operation IDs to the CSI requests. The operation ID of a request is carried by its context,
+// logged by the driver and sent to vCenter as the operationID of the SOAP calls made with the context, so that the
+// driver logs can be correlated with the vCenter and CNS logs.
+package opid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+// prefix identifies the operation IDs of the driver in the vCenter logs
+const prefix = "csi-"
+
+// fallback numbers the operation IDs if the random source fails
+var fallback uint64
+
+// New returns a new operation ID
+func New() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s%08x", prefix, atomic.AddUint64(&fallback, 1))
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// NewContext returns a copy of ctx carrying the given operation ID. govmomi sends it as the operationID of the
+// SOAP calls made with the returned context.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, vimtypes.ID{}, id)
+}
+
+// FromContext returns the operation ID carried by ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(vimtypes.ID{}).(string)
+	return id
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+func TestOperationID(t *testing.T) {
+	id := New()
+	if !strings.HasPrefix(id, prefix) || len(id) != len(prefix)+8 {
+		t.Errorf("New() = %q, expected %s followed by 8 hex digits", id, prefix)
+	}
+	if other := New(); other == id {
+		t.Errorf("New() returned %q twice", id)
+	}
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("FromContext() = %q without an operation ID, expected an empty string", id)
+	}
+	ctx := NewContext(context.Background(), id)
+	if got := FromContext(ctx); got != id {
+		t.Errorf("FromContext() = %q, expected %q", got, id)
+	}
+	// govmomi reads the operationID of the SOAP calls from this key
+	if got, _ := ctx.Value(vimtypes.ID{}).(string); got != id {
+		t.Errorf("operationID sent to vCenter = %q, expected %q", got, id)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/version"
+)
+
+const (
+	// namespace is the prefix of all metrics of the driver
+	namespace = "vsphere_csi"
+
+	// EnvMetricsAddress is the environment variable to set the address metrics are served on
+	EnvMetricsAddress = "METRICS_ADDRESS"
+
+	// DefaultControllerMetricsAddress is the default address the controller serves metrics on
+	DefaultControllerMetricsAddress = ":2112"
+
+	// DefaultSyncerMetricsAddress is the default address the syncer serves metrics on
+	DefaultSyncerMetricsAddress = ":2113"
+
+	// FullSyncResultSuccess is the result label of a successful full sync
+	FullSyncResultSuccess = "success"
+	// FullSyncResultFailure is the result label of a failed full sync
+	FullSyncResultFailure = "failure"
+
+	// TaskTypeCreateVolume is the task type label of CNS CreateVolume tasks
+	TaskTypeCreateVolume = "createVolume"
+	// TaskTypeAttachVolume is the task type label of CNS AttachVolume tasks, which reconfigure the node VM
+	TaskTypeAttachVolume = "attachVolume"
+	// TaskTypeDetachVolume is the task type label of CNS DetachVolume tasks, which reconfigure the node VM
+	TaskTypeDetachVolume = "detachVolume"
+	// TaskTypeDeleteVolume is the task type label of CNS DeleteVolume tasks
+	TaskTypeDeleteVolume = "deleteVolume"
+	// TaskTypeUpdateVolumeMetadata is the task type label of CNS UpdateVolumeMetadata tasks
+	TaskTypeUpdateVolumeMetadata = "updateVolumeMetadata"
+	// TaskTypeDeleteVStorageObject is the task type label of DeleteVStorageObject tasks
+	TaskTypeDeleteVStorageObject = "deleteVStorageObject"
+	// TaskTypeCreateSnapshot is the task type label of VStorageObjectCreateSnapshot tasks
+	TaskTypeCreateSnapshot = "createSnapshot"
+	// TaskTypeDeleteSnapshot is the task type label of DeleteSnapshot tasks
+	TaskTypeDeleteSnapshot = "deleteSnapshot"
+	// TaskTypeCreateDiskFromSnapshot is the task type label of CreateDiskFromSnapshot tasks
+	TaskTypeCreateDiskFromSnapshot = "createDiskFromSnapshot"
+	// TaskTypeCloneVStorageObject is the task type label of CloneVStorageObject tasks
+	TaskTypeCloneVStorageObject = "cloneVStorageObject"
+	// TaskTypeExtendVStorageObject is the task type label of ExtendDisk tasks
+	TaskTypeExtendVStorageObject = "extendVStorageObject"
+	// TaskTypeExtendAttachedDisk is the task type label of VM reconfigure tasks extending an attached disk
+	TaskTypeExtendAttachedDisk = "extendAttachedDisk"
+	// TaskTypeCreateVStorageObject is the task type label of CreateDisk tasks
+	TaskTypeCreateVStorageObject = "createVStorageObject"
+	// TaskTypeAttachMultiWriterDisk is the task type label of VM reconfigure tasks attaching a multi-writer disk
+	TaskTypeAttachMultiWriterDisk = "attachMultiWriterDisk"
+	// TaskTypeDetachMultiWriterDisk is the task type label of VM reconfigure tasks detaching a multi-writer disk
+	TaskTypeDetachMultiWriterDisk = "detachMultiWriterDisk"
+	// TaskTypeAddSCSIController is the task type label of VM reconfigure tasks adding a SCSI controller
+	TaskTypeAddSCSIController = "addSCSIController"
+
+	// TaskResultSuccess is the result label of a successful vCenter task
+	TaskResultSuccess = "success"
+	// TaskResultFailure is the result label of a failed vCenter task
+	TaskResultFailure = "failure"
+
+	// AttachmentDriftMissing is the direction label of volumes with a VolumeAttachment which are not attached to the node VM
+	AttachmentDriftMissing = "missing"
+	// AttachmentDriftUnexpected is the direction label of volumes attached to a node VM without a VolumeAttachment
+	AttachmentDriftUnexpected = "unexpected"
+)
+
+var (
+	// BuildInfo records the build information of the driver as labels of a constant 1 gauge
+	BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build information of the driver.",
+	}, []string{"version", "git_commit", "csi_spec_version", "go_version", "feature_gates"})
+
+	// VCenterTaskDuration records how long vCenter tasks take by task type and result,
+	// from the time the driver starts waiting for the task until it completes
+	VCenterTaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vcenter_task_duration_seconds",
+		Help:      "Duration of vCenter tasks in seconds by task type and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"task_type", "result"})
+
+	// AttachmentDrift records the number of attachments found diverging from the VolumeAttachments
+	// by the last attachment reconciliation, by direction
+	AttachmentDrift = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "attachment_drift",
+		Help:      "Number of volume attachments diverging from the VolumeAttachments found by the last reconciliation by direction.",
+	}, []string{"direction"})
+
+	// AttachmentDriftCorrections counts the attachments corrected by attachment reconciliations by direction and result
+	AttachmentDriftCorrections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attachment_drift_corrections_total",
+		Help:      "Number of volume attachments corrected by reconciliations by direction and result.",
+	}, []string{"direction", "result"})
+
+	// DatastoreQuarantined records whether a datastore is kept out of volume placement after repeated provisioning failures
+	DatastoreQuarantined = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "datastore_quarantined",
+		Help:      "Whether the datastore is kept out of volume placement after repeated provisioning failures (1) or not (0).",
+	}, []string{"datastore_url"})
+
+	// VCenterOperationsWaiting records the number of controller operations waiting for a vCenter worker by priority
+	VCenterOperationsWaiting = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "vcenter_operations_waiting",
+		Help:      "Number of controller operations waiting for a vCenter worker by priority.",
+	}, []string{"priority"})
+
+	// FullSyncRuns counts the full sync runs by result
+	FullSyncRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "full_sync_runs_total",
+		Help:      "Number of full sync runs by result.",
+	}, []string{"result"})
+
+	// FullSyncDuration records the duration of full sync runs
+	FullSyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "full_sync_duration_seconds",
+		Help:      "Duration of full sync runs in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	// FullSyncLastRunTimestamp records the time the last full sync run completed
+	FullSyncLastRunTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_last_run_timestamp_seconds",
+		Help:      "Unix time the last full sync run completed.",
+	})
+
+	// FullSyncVolumesScanned records the number of PVs scanned by the last full sync run
+	FullSyncVolumesScanned = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_volumes_scanned",
+		Help:      "Number of PersistentVolumes scanned by the last full sync run.",
+	})
+
+	// FullSyncVolumesCreated records the number of volumes registered with CNS by the last full sync run
+	FullSyncVolumesCreated = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_volumes_created",
+		Help:      "Number of volumes missing in CNS registered by the last full sync run.",
+	})
+
+	// FullSyncMetadataRepaired records the number of volumes whose metadata was repaired by the last full sync run
+	FullSyncMetadataRepaired = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_metadata_repaired",
+		Help:      "Number of volumes whose CNS metadata was repaired by the last full sync run.",
+	})
+
+	// FullSyncOrphansFound records the number of CNS volumes without a PV found by the last full sync run
+	FullSyncOrphansFound = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_orphan_volumes_found",
+		Help:      "Number of CNS volumes without a PersistentVolume found by the last full sync run.",
+	})
+
+	// FullSyncOrphansDeleted records the number of CNS volumes without a PV deleted by the last full sync run
+	FullSyncOrphansDeleted = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "full_sync_orphan_volumes_deleted",
+		Help:      "Number of CNS volumes without a PersistentVolume deleted by the last full sync run.",
+	})
+)
+
+// ObserveVCenterTask records the duration of a vCenter task of the given type waited for since start,
+// which completed with the given error
+func ObserveVCenterTask(taskType string, start time.Time, err error) {
+	result := TaskResultSuccess
+	if err != nil {
+		result = TaskResultFailure
+	}
+	VCenterTaskDuration.WithLabelValues(taskType, result).Observe(time.Since(start).Seconds())
+}
+
+// ServeMetrics serves the registered metrics on /metrics, the build information on /version
+// and the support bundle on /debug/support-bundle of the given address in the background
+func ServeMetrics(address string) {
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.CSISpecVersion, info.GoVersion, strings.Join(info.FeatureGates, ",")).Set(1)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc(supportbundle.Path, supportbundle.Handler)
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			klog.Errorf("Failed to write version. err: %v", err)
+		}
+	})
+	go func() {
+		klog.V(2).Infof("Serving metrics on %s", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve metrics on %s. err: %v", address, err)
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package slowlog logs a warning for CNS and Kubernetes operations taking longer than a configurable threshold,
+// so that latency regressions are visible in the logs.
+package slowlog
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultCNSThreshold is the default duration after which a CNS operation is logged as slow
+	DefaultCNSThreshold = 30 * time.Second
+	// DefaultKubernetesThreshold is the default duration after which a Kubernetes API request is logged as slow
+	DefaultKubernetesThreshold = 5 * time.Second
+
+	kindCNS        = "CNS"
+	kindKubernetes = "Kubernetes"
+
+	// auditIDHeader is the header of the API server response holding the audit ID of the request
+	auditIDHeader = "Audit-Id"
+)
+
+var (
+	cnsThreshold        = DefaultCNSThreshold
+	kubernetesThreshold = DefaultKubernetesThreshold
+)
+
+// InitFlags registers the flags of the slow operation thresholds on the given flagset, or on flag.CommandLine if it is nil
+func InitFlags(flagset *flag.FlagSet) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	flagset.DurationVar(&cnsThreshold, "slow-cns-operation-threshold", cnsThreshold,
+		"Duration after which a CNS operation is logged as slow. 0 disables the log")
+	flagset.DurationVar(&kubernetesThreshold, "slow-kubernetes-operation-threshold", kubernetesThreshold,
+		"Duration after which a Kubernetes API request is logged as slow. 0 disables the log")
+}
+
+// ObserveCNS logs a warning if the CNS operation with the given opId started at start exceeded the threshold
+func ObserveCNS(operation, opID string, start time.Time) {
+	observe(kindCNS, cnsThreshold, operation, opID, time.Since(start))
+}
+
+// ObserveKubernetes logs a warning if the Kubernetes operation with the given audit ID started at start exceeded the threshold
+func ObserveKubernetes(operation, auditID string, start time.Time) {
+	observe(kindKubernetes, kubernetesThreshold, operation, auditID, time.Since(start))
+}
+
+func observe(kind string, threshold time.Duration, operation, opID string, elapsed time.Duration) {
+	if !isSlow(threshold, elapsed) {
+		return
+	}
+	klog.Warningf("Slow %s operation: operation=%q opId=%q elapsed=%v threshold=%v", kind, operation, opID, elapsed, threshold)
+}
+
+// isSlow returns whether elapsed exceeds the threshold. A threshold of 0 disables the check.
+func isSlow(threshold, elapsed time.Duration) bool {
+	return threshold > 0 && elapsed >= threshold
+}
+
+// WrapTransport wraps the transport of a Kubernetes client, so that slow API requests are logged.
+// It is meant to be set as WrapTransport of the client's rest config.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &roundTripper{rt: rt}
+}
+
+type roundTripper struct {
+	rt http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.rt.RoundTrip(req)
+	var auditID string
+	if res != nil {
+		auditID = res.Header.Get(auditIDHeader)
+	}
+	ObserveKubernetes(req.Method+" "+req.URL.Path, auditID, start)
+	return res, err
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slowlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsSlow(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		elapsed   time.Duration
+		expected  bool
+	}{
+		{"below threshold", time.Second, time.Millisecond, false},
+		{"at threshold", time.Second, time.Second, true},
+		{"above threshold", time.Second, time.Minute, true},
+		{"disabled", 0, time.Hour, false},
+	}
+	for _, test := range tests {
+		if actual := isSlow(test.threshold, test.elapsed); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
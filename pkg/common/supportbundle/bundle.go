@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supportbundle packages the state of the driver into a tarball to be attached to support cases.
+// Subsystems register collectors for the files of the bundle, credentials are redacted from the config.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/version"
+)
+
+const (
+	// Path is the path the support bundle is served on
+	Path = "/debug/support-bundle"
+
+	versionFile = "version.json"
+	logsFile    = "logs.txt"
+
+	redacted = "<redacted>"
+)
+
+// Collector returns the content of a file of the support bundle
+type Collector func() ([]byte, error)
+
+var (
+	collectorsLock sync.Mutex
+	collectors     = map[string]Collector{}
+
+	// credentialPattern matches the credential keys of the config file and their values
+	credentialPattern = regexp.MustCompile(`(?im)^(\s*(password|user)\s*=\s*).*$`)
+)
+
+// Register registers the collector of the file with the given name, replacing any earlier collector of the file
+func Register(name string, collect Collector) {
+	collectorsLock.Lock()
+	defer collectorsLock.Unlock()
+	collectors[name] = collect
+}
+
+// JSONCollector returns a collector of the JSON encoding of the value returned by get
+func JSONCollector(get func() interface{}) Collector {
+	return func() ([]byte, error) {
+		return json.MarshalIndent(get(), "", "  ")
+	}
+}
+
+// ConfigCollector returns a collector of the config file at the given path with credentials redacted
+func ConfigCollector(path string) Collector {
+	return func() ([]byte, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return RedactConfig(data), nil
+	}
+}
+
+// RedactConfig returns the given config file content with the values of user and password keys redacted
+func RedactConfig(data []byte) []byte {
+	return credentialPattern.ReplaceAll(data, []byte("${1}"+redacted))
+}
+
+// Write writes the support bundle as a gzipped tarball to w.
+// Failures of collectors are recorded in the bundle as <name>.error files.
+func Write(w io.Writer) error {
+	files := map[string]Collector{
+		versionFile: JSONCollector(func() interface{} { return version.Get() }),
+		logsFile:    recentLogs,
+	}
+	collectorsLock.Lock()
+	for name, collect := range collectors {
+		files[name] = collect
+	}
+	collectorsLock.Unlock()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+	for _, name := range names {
+		data, err := files[name]()
+		if err != nil {
+			klog.Warningf("Failed to collect %s for the support bundle. err: %v", name, err)
+			name += ".error"
+			data = []byte(err.Error())
+		}
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Handler serves the support bundle as a download
+func Handler(w http.ResponseWriter, r *http.Request) {
+	fileName := fmt.Sprintf("vsphere-csi-support-bundle-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	if err := Write(w); err != nil {
+		klog.Errorf("Failed to write the support bundle. err: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRedactConfig(t *testing.T) {
+	config := `[Global]
+cluster-id = "cluster"
+
+[VirtualCenter "vc.example.com"]
+user = "administrator@vsphere.local"
+Password = "secret"
+port = "443"
+`
+	expected := `[Global]
+cluster-id = "cluster"
+
+[VirtualCenter "vc.example.com"]
+user = <redacted>
+Password = <redacted>
+port = "443"
+`
+	if actual := string(RedactConfig([]byte(config))); actual != expected {
+		t.Errorf("expected redacted config:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := &logBuffer{size: 8}
+	b.Write([]byte("12345"))
+	b.Write([]byte("67890"))
+	if actual := string(b.bytes()); actual != "34567890" {
+		t.Errorf("expected the last 8 bytes, got %q", actual)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	Register("ok.txt", func() ([]byte, error) { return []byte("ok"), nil })
+	Register("failed.txt", func() ([]byte, error) { return nil, errors.New("failed") })
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+	expected := map[string]string{"ok.txt": "ok", "failed.txt.error": "failed"}
+	for name, content := range expected {
+		if files[name] != content {
+			t.Errorf("expected %s to contain %q, got %q", name, content, files[name])
+		}
+	}
+	if _, ok := files[versionFile]; !ok {
+		t.Errorf("expected %s in the bundle, got %v", versionFile, files)
+	}
+}
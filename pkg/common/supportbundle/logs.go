@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// DefaultLogBufferSize is the default number of bytes of recent logs kept for the support bundle
+const DefaultLogBufferSize = 4 << 20
+
+// logBuffer keeps the last size bytes written to it
+type logBuffer struct {
+	lock sync.Mutex
+	size int
+	data []byte
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.size {
+		b.data = append([]byte(nil), b.data[len(b.data)-b.size:]...)
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte(nil), b.data...)
+}
+
+// logs holds the recent logs once CaptureLogs is called
+var logs *logBuffer
+
+// CaptureLogs keeps the last size bytes of logs in memory for the support bundle, while still logging to stderr.
+// Logs are only captured if klog logs to stderr only, which is the default, as klog writes log files
+// through the same outputs. Must be called after flags are parsed.
+func CaptureLogs(size int) {
+	if flagValue("logtostderr") != "true" || flagValue("log_file") != "" || flagValue("log_dir") != "" {
+		klog.V(2).Info("Not capturing logs for the support bundle as logs are written to files")
+		return
+	}
+	logs = &logBuffer{size: size}
+	// Messages are written to the output of their severity and all lower ones, hence only the
+	// lowest one is captured.
+	for _, severity := range []string{"WARNING", "ERROR", "FATAL"} {
+		klog.SetOutputBySeverity(severity, ioutil.Discard)
+	}
+	klog.SetOutputBySeverity("INFO", logs)
+	_ = flag.Set("logtostderr", "false")
+	_ = flag.Set("alsologtostderr", "true")
+}
+
+func flagValue(name string) string {
+	if f := flag.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// recentLogs is the collector of the recent logs
+func recentLogs() ([]byte, error) {
+	if logs == nil {
+		return nil, errors.New("logs are not captured as they are written to files")
+	}
+	return logs.bytes(), nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vcsim is a test harness running the driver against govmomi's vCenter simulator. The simulator serves
+// the CNS, PBM and tagging APIs, and its VMs are registered as the nodes of a fake Kubernetes cluster, so that the
+// placement, topology and attach flows can be tested without a vCenter.
+package vcsim
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	cnssim "github.com/vmware/govmomi/cns/simulator"
+	_ "github.com/vmware/govmomi/pbm/simulator" // serves the PBM API
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/rest"
+	_ "github.com/vmware/govmomi/vapi/simulator" // serves the tagging API
+	"github.com/vmware/govmomi/vapi/tags"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog"
+
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+// Options are the inventory of the simulated vCenter. Zero values are replaced by the defaults of vcsim.
+type Options struct {
+	// ClusterID is the id of the Kubernetes cluster in the config of the driver
+	ClusterID string
+	// Hosts is the number of hosts of the vSphere cluster
+	Hosts int
+	// NodeVMs is the number of node VMs, placed on the hosts of the vSphere cluster
+	NodeVMs int
+	// Datastores is the number of datastores, each mounted on all the hosts
+	Datastores int
+}
+
+// Harness is a running vCenter simulator. As the simulator inventory is global, a single harness can run at a time.
+type Harness struct {
+	// Config is the config of the driver for the simulated vCenter
+	Config *config.Config
+	// VirtualCenter is the simulated vCenter, registered with the virtual center manager and connected to CNS
+	VirtualCenter *cnsvsphere.VirtualCenter
+	// KubeClient is a fake Kubernetes client with a node for each node VM, named after the VM. The nodes are
+	// registered with the node manager.
+	KubeClient *testclient.Clientset
+	// Nodes are the names of the nodes
+	Nodes []string
+	// Hosts are the hosts of the vSphere cluster
+	Hosts []vimtypes.ManagedObjectReference
+	// Datacenter is the datacenter of the simulated inventory
+	Datacenter vimtypes.ManagedObjectReference
+
+	model  *simulator.Model
+	server *simulator.Server
+}
+
+// Start starts a vCenter simulator with the given inventory, registers it and its node VMs with the driver,
+// and returns the harness, which has to be stopped with Stop
+func Start(ctx context.Context, options Options) (*Harness, error) {
+	model := simulator.VPX()
+	model.Host = 0
+	if options.Hosts > 0 {
+		model.ClusterHost = options.Hosts
+	}
+	if options.NodeVMs > 0 {
+		model.Machine = options.NodeVMs
+	}
+	if options.Datastores > 0 {
+		model.Datastore = options.Datastores
+	}
+	if err := model.Create(); err != nil {
+		return nil, err
+	}
+	model.Service.TLS = new(tls.Config)
+	// The tagging and PBM simulators register their endpoints when the server starts
+	model.Service.RegisterEndpoints = true
+	h := &Harness{
+		KubeClient: testclient.NewSimpleClientset(),
+		model:      model,
+		server:     model.Service.NewServer(),
+	}
+	model.Service.RegisterSDK(cnssim.New())
+
+	datacenter := simulator.Map.Any("Datacenter").(*simulator.Datacenter)
+	h.Datacenter = datacenter.Reference()
+	password, _ := h.server.URL.User.Password()
+	h.Config = &config.Config{}
+	h.Config.Global.ClusterID = options.ClusterID
+	h.Config.Global.VCenterIP = h.server.URL.Hostname()
+	h.Config.Global.VCenterPort = h.server.URL.Port()
+	h.Config.Global.User = h.server.URL.User.Username()
+	h.Config.Global.Password = password
+	h.Config.Global.InsecureFlag = true
+	h.Config.Global.Datacenters = datacenter.Name
+	h.Config.VirtualCenter = map[string]*config.VirtualCenterConfig{
+		h.server.URL.Hostname(): {
+			User:         h.Config.Global.User,
+			Password:     password,
+			VCenterPort:  h.Config.Global.VCenterPort,
+			InsecureFlag: true,
+			Datacenters:  datacenter.Name,
+		},
+	}
+	vcConfig, err := cnsvsphere.GetVirtualCenterConfig(h.Config)
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+	if h.VirtualCenter, err = cnsvsphere.GetVirtualCenterManager().RegisterVirtualCenter(vcConfig); err != nil {
+		h.Stop()
+		return nil, err
+	}
+	if err = h.VirtualCenter.ConnectCNS(ctx); err != nil {
+		h.Stop()
+		return nil, err
+	}
+
+	for _, obj := range simulator.Map.All("HostSystem") {
+		h.Hosts = append(h.Hosts, obj.Reference())
+	}
+	nodeManager := cnsnode.GetManager()
+	nodeManager.SetKubernetesClient(h.KubeClient)
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: vm.Name},
+			Spec:       v1.NodeSpec{ProviderID: "vsphere://" + vm.Config.Uuid},
+		}
+		if _, err = h.KubeClient.CoreV1().Nodes().Create(node); err != nil {
+			h.Stop()
+			return nil, err
+		}
+		if err = nodeManager.RegisterNode(vm.Config.Uuid, vm.Name); err != nil {
+			h.Stop()
+			return nil, fmt.Errorf("failed to register node %s: %v", vm.Name, err)
+		}
+		h.Nodes = append(h.Nodes, vm.Name)
+	}
+	klog.Infof("Started vCenter simulator on %s with %d hosts and nodes %v", h.server.URL.Host, len(h.Hosts), h.Nodes)
+	return h, nil
+}
+
+// Stop unregisters the nodes and the simulated vCenter, and stops the simulator
+func (h *Harness) Stop() {
+	nodeManager := cnsnode.GetManager()
+	for _, node := range h.Nodes {
+		if err := nodeManager.UnregisterNode(node); err != nil {
+			klog.Warningf("Failed to unregister node %s. err=%v", node, err)
+		}
+	}
+	if h.VirtualCenter != nil {
+		if err := cnsvsphere.GetVirtualCenterManager().UnregisterVirtualCenter(h.VirtualCenter.Config.Host); err != nil {
+			klog.Warningf("Failed to unregister vCenter simulator. err=%v", err)
+		}
+	}
+	h.server.Close()
+	h.model.Remove()
+}
+
+// NodeVM returns the VM of the node with the given name
+func (h *Harness) NodeVM(nodeName string) (*cnsvsphere.VirtualMachine, error) {
+	return cnsnode.GetManager().GetNodeByName(nodeName)
+}
+
+// AttachTag attaches the tag with the given name in the category with the given name to the object, creating the
+// category and the tag if needed. Tags of the zone and region categories place the nodes in zones and regions.
+func (h *Harness) AttachTag(ctx context.Context, ref vimtypes.ManagedObjectReference, categoryName string, tagName string) error {
+	restClient := rest.NewClient(h.VirtualCenter.Client.Client)
+	if err := restClient.Login(ctx, h.server.URL.User); err != nil {
+		return err
+	}
+	defer restClient.Logout(ctx)
+	tagManager := tags.NewManager(restClient)
+	var categoryID string
+	if category, err := tagManager.GetCategory(ctx, categoryName); err == nil {
+		categoryID = category.ID
+	} else if categoryID, err = tagManager.CreateCategory(ctx, &tags.Category{Name: categoryName, Cardinality: "SINGLE"}); err != nil {
+		return err
+	}
+	tagID := ""
+	if tag, err := tagManager.GetTagForCategory(ctx, tagName, categoryID); err == nil {
+		tagID = tag.ID
+	} else if tagID, err = tagManager.CreateTag(ctx, &tags.Tag{Name: tagName, CategoryID: categoryID}); err != nil {
+		return err
+	}
+	return tagManager.AttachTag(ctx, tagID, ref)
+}
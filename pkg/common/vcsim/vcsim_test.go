@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcsim
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestHarness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := Start(ctx, Options{ClusterID: "test-cluster", Hosts: 2, NodeVMs: 2, Datastores: 2})
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer h.Stop()
+
+	// Nodes
+	nodeVMs, err := cnsnode.GetManager().GetAllNodes()
+	if err != nil || len(nodeVMs) != 2 || len(h.Nodes) != 2 {
+		t.Fatalf("expected 2 registered nodes, got %v, %v", nodeVMs, err)
+	}
+	vm, err := h.NodeVM(h.Nodes[0])
+	if err != nil {
+		t.Fatalf("NodeVM(%q) failed: %v", h.Nodes[0], err)
+	}
+	datastores, err := vm.GetAllAccessibleDatastores(ctx)
+	if err != nil || len(datastores) < 2 {
+		t.Fatalf("expected the node VM to access at least 2 datastores, got %v, %v", datastores, err)
+	}
+
+	// Topology
+	if err = h.AttachTag(ctx, h.Datacenter, "k8s-region", "region-1"); err != nil {
+		t.Fatalf("AttachTag() of the region failed: %v", err)
+	}
+	if err = h.AttachTag(ctx, vm.Reference(), "k8s-zone", "zone-a"); err != nil {
+		t.Fatalf("AttachTag() of the zone failed: %v", err)
+	}
+	zone, region, err := vm.GetZoneRegion(ctx, "k8s-zone", "k8s-region")
+	if err != nil || zone != "zone-a" || region != "region-1" {
+		t.Errorf("GetZoneRegion() = %q, %q, %v, expected zone-a, region-1", zone, region, err)
+	}
+	topology, err := vm.GetTopology(ctx, []string{"k8s-region", "k8s-zone", "k8s-rack"})
+	expectedTopology := map[string]string{"k8s-region": "region-1", "k8s-zone": "zone-a"}
+	if err != nil || !reflect.DeepEqual(topology, expectedTopology) {
+		t.Errorf("GetTopology() = %v, %v, expected %v", topology, err, expectedTopology)
+	}
+
+	// Volume lifecycle
+	manager := volume.NewManager(h.VirtualCenter)
+	volumeID, err := manager.CreateVolume(ctx, &cnstypes.CnsVolumeCreateSpec{
+		Name:       "pvc-1",
+		VolumeType: "BLOCK",
+		Datastores: []vimtypes.ManagedObjectReference{datastores[0].Reference()},
+		BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{
+			CnsBackingObjectDetails: cnstypes.CnsBackingObjectDetails{CapacityInMb: 1024},
+		},
+		Metadata: cnstypes.CnsVolumeMetadata{
+			ContainerCluster: cnsvsphere.GetContainerCluster(h.Config.Global.ClusterID, h.Config.Global.User),
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() failed: %v", err)
+	}
+	if diskUUID, err := manager.AttachVolume(ctx, vm, volumeID.Id); err != nil || diskUUID == "" {
+		t.Errorf("AttachVolume() = %q, %v, expected a disk UUID", diskUUID, err)
+	}
+	if err = manager.DetachVolume(ctx, vm, volumeID.Id); err != nil {
+		t.Errorf("DetachVolume() failed: %v", err)
+	}
+	if err = manager.DeleteVolume(ctx, volumeID.Id, true); err != nil {
+		t.Errorf("DeleteVolume() failed: %v", err)
+	}
+
+	// Datastores in maintenance mode are left out of placement
+	inMaintenance := simulator.Map.Get(datastores[1].Reference()).(*simulator.Datastore)
+	inMaintenance.Summary.MaintenanceMode = string(vimtypes.DatastoreSummaryMaintenanceModeStateInMaintenance)
+	placeable, err := vm.GetAllAccessibleDatastores(ctx)
+	if err != nil || len(placeable) != len(datastores)-1 {
+		t.Errorf("expected %d datastores outside maintenance mode, got %v, %v", len(datastores)-1, placeable, err)
+	}
+	for _, datastore := range placeable {
+		if datastore.Info.Url == datastores[1].Info.Url {
+			t.Errorf("datastore %s in maintenance mode is accessible for placement", datastore.Info.Url)
+		}
+	}
+}
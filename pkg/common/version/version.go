@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version provides the build information of the driver binaries.
+package version
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// CSISpecVersion is the version of the CSI spec supported by the driver
+	CSISpecVersion = "1.0.0"
+
+	// EnvFeatureGates is the environment variable to enable feature gates of the driver,
+	// specified as a comma separated list of <name>=<true|false> pairs
+	EnvFeatureGates = "X_CSI_FEATURE_GATES"
+)
+
+var (
+	// Version is the version of the driver, set via ldflags
+	Version string
+	// GitCommit is the git commit the driver is built from, set via ldflags
+	GitCommit string
+)
+
+// Info is the build information of the driver
+type Info struct {
+	Version        string   `json:"version"`
+	GitCommit      string   `json:"gitCommit"`
+	CSISpecVersion string   `json:"csiSpecVersion"`
+	GoVersion      string   `json:"goVersion"`
+	FeatureGates   []string `json:"featureGates"`
+}
+
+// Get returns the build information of the driver along with the enabled feature gates
+func Get() Info {
+	return Info{
+		Version:        Version,
+		GitCommit:      GitCommit,
+		CSISpecVersion: CSISpecVersion,
+		GoVersion:      runtime.Version(),
+		FeatureGates:   ParseFeatureGates(os.Getenv(EnvFeatureGates)),
+	}
+}
+
+// Manifest returns the build information as the manifest fields of the CSI GetPluginInfo response
+func (info Info) Manifest() map[string]string {
+	return map[string]string{
+		"gitCommit":      info.GitCommit,
+		"csiSpecVersion": info.CSISpecVersion,
+		"goVersion":      info.GoVersion,
+		"featureGates":   strings.Join(info.FeatureGates, ","),
+	}
+}
+
+// ParseFeatureGates returns the sorted names of the feature gates enabled by the given comma separated list
+// of <name>=<true|false> pairs. A name without a value enables the feature gate.
+func ParseFeatureGates(featureGates string) []string {
+	enabled := make(map[string]bool)
+	for _, featureGate := range strings.Split(featureGates, ",") {
+		parts := strings.SplitN(strings.TrimSpace(featureGate), "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			continue
+		}
+		enabled[name] = len(parts) == 1 || strings.EqualFold(strings.TrimSpace(parts[1]), "true")
+	}
+	names := []string{}
+	for name, isEnabled := range enabled {
+		if isEnabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFeatureGates(t *testing.T) {
+	tests := []struct {
+		featureGates string
+		expected     []string
+	}{
+		{"", []string{}},
+		{"TrashMode=true", []string{"TrashMode"}},
+		{"B=true, A=TRUE,C=false", []string{"A", "B"}},
+		{"A,B=false", []string{"A"}},
+		{"A=true,A=false", []string{}},
+		{" , =true", []string{}},
+	}
+	for _, test := range tests {
+		if actual := ParseFeatureGates(test.featureGates); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("ParseFeatureGates(%q): expected %v, got %v", test.featureGates, test.expected, actual)
+		}
+	}
+}
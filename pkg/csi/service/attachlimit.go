@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+const (
+	// scsiControllerUnits is the number of units of SCSI controllers, one of which is taken by the controller
+	scsiControllerUnits = 16
+	// pvscsiControllerUnits is the number of units of PVSCSI controllers from hardware version 14 on
+	pvscsiControllerUnits = 65
+	// pvscsiHardwareVersion is the first hardware version whose PVSCSI controllers have 64 targets
+	pvscsiHardwareVersion = 14
+)
+
+// getNodeMaxVolumes returns the number of volumes which can be attached to the node VM, which is the number of
+// disk slots of its SCSI controllers not taken by disks other than First Class Disks.
+func getNodeMaxVolumes(ctx context.Context, nodeVM *cnsvsphere.VirtualMachine) (int64, error) {
+	var vmMo mo.VirtualMachine
+	err := nodeVM.Properties(ctx, nodeVM.Reference(), []string{"config.hardware.device", "config.version"}, &vmMo)
+	if err != nil {
+		klog.Errorf("Failed to get devices of node VM %v. err: %v", nodeVM.Reference(), err)
+		return 0, err
+	}
+	if vmMo.Config == nil {
+		return 0, nil
+	}
+	return getMaxVolumes(object.VirtualDeviceList(vmMo.Config.Hardware.Device), vmMo.Config.Version), nil
+}
+
+// getMaxVolumes returns the number of First Class Disks which can be attached to a VM of the given hardware
+// version with the given devices. Disk slots taken by other disks, such as the boot disk, are not available.
+func getMaxVolumes(devices object.VirtualDeviceList, hardwareVersion string) int64 {
+	version, _ := strconv.Atoi(strings.TrimPrefix(hardwareVersion, "vmx-"))
+	var slots int64
+	for _, device := range devices.SelectByType((*types.VirtualSCSIController)(nil)) {
+		units := scsiControllerUnits
+		if _, ok := device.(*types.ParaVirtualSCSIController); ok && version >= pvscsiHardwareVersion {
+			units = pvscsiControllerUnits
+		}
+		// One unit is taken by the controller itself
+		slots += int64(units - 1)
+	}
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := device.(*types.VirtualDisk)
+		if disk.VDiskId == nil && isOnSCSIController(devices, disk) {
+			slots--
+		}
+	}
+	if slots < 0 {
+		return 0
+	}
+	return slots
+}
+
+// isOnSCSIController returns true if the disk is attached to one of the SCSI controllers among the devices
+func isOnSCSIController(devices object.VirtualDeviceList, disk *types.VirtualDisk) bool {
+	controller := devices.FindByKey(disk.ControllerKey)
+	if controller == nil {
+		return false
+	}
+	_, ok := controller.(types.BaseVirtualSCSIController)
+	return ok
+}
+
+// getNodeMaxVolumesOverride returns the number of volumes set by the max-volumes annotation of the node
+// with the given name, or 0 if the annotation is not set, is invalid or the node cannot be read
+func getNodeMaxVolumesOverride(nodeName string) int64 {
+	client, err := k8s.NewClient()
+	if err != nil {
+		klog.Warningf("Failed to create Kubernetes client to read the %s annotation of node %s. err: %v", common.AnnMaxVolumes, nodeName, err)
+		return 0
+	}
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Failed to get node %s to read its %s annotation. err: %v", nodeName, common.AnnMaxVolumes, err)
+		return 0
+	}
+	return getMaxVolumesOverride(node)
+}
+
+// getMaxVolumesOverride returns the positive number of volumes set by the max-volumes annotation of the node,
+// or 0 if the annotation is not set or invalid
+func getMaxVolumesOverride(node *v1.Node) int64 {
+	v, ok := node.Annotations[common.AnnMaxVolumes]
+	if !ok {
+		return 0
+	}
+	maxVolumes, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || maxVolumes <= 0 {
+		klog.Warningf("%s annotation %q of node %s is invalid, it must be a positive number", common.AnnMaxVolumes, v, node.Name)
+		return 0
+	}
+	return maxVolumes
+}
+
+// applyMaxVolumesOverride returns the attach limit of a node given the computed one, 0 if unknown, and the one
+// of the max-volumes annotation, 0 if unset. The annotation can lower the attach limit but not raise it above
+// the number of disk slots of the node VM.
+func applyMaxVolumesOverride(computed int64, override int64) int64 {
+	if override == 0 || (computed > 0 && override > computed) {
+		return computed
+	}
+	return override
+}
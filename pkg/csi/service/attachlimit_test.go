@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestGetMaxVolumes(t *testing.T) {
+	pvscsi := func(key int32) types.BaseVirtualDevice {
+		return &types.ParaVirtualSCSIController{VirtualSCSIController: types.VirtualSCSIController{
+			VirtualController: types.VirtualController{VirtualDevice: types.VirtualDevice{Key: key}}}}
+	}
+	lsiLogic := func(key int32) types.BaseVirtualDevice {
+		return &types.VirtualLsiLogicController{VirtualSCSIController: types.VirtualSCSIController{
+			VirtualController: types.VirtualController{VirtualDevice: types.VirtualDevice{Key: key}}}}
+	}
+	ide := &types.VirtualIDEController{VirtualController: types.VirtualController{VirtualDevice: types.VirtualDevice{Key: 200}}}
+	disk := func(controllerKey int32, fcd bool) types.BaseVirtualDevice {
+		d := &types.VirtualDisk{VirtualDevice: types.VirtualDevice{ControllerKey: controllerKey}}
+		if fcd {
+			d.VDiskId = &types.ID{Id: "fcd"}
+		}
+		return d
+	}
+	tests := []struct {
+		name            string
+		devices         object.VirtualDeviceList
+		hardwareVersion string
+		expected        int64
+	}{
+		{name: "no controller", devices: object.VirtualDeviceList{ide}, hardwareVersion: "vmx-14"},
+		{name: "pvscsi", devices: object.VirtualDeviceList{pvscsi(1000)}, hardwareVersion: "vmx-14", expected: 64},
+		{name: "pvscsi before hardware version 14", devices: object.VirtualDeviceList{pvscsi(1000)}, hardwareVersion: "vmx-13", expected: 15},
+		{name: "lsilogic", devices: object.VirtualDeviceList{lsiLogic(1000)}, hardwareVersion: "vmx-14", expected: 15},
+		{name: "boot disk", devices: object.VirtualDeviceList{lsiLogic(1000), pvscsi(1001), disk(1000, false)},
+			hardwareVersion: "vmx-15", expected: 78},
+		{name: "attached volumes", devices: object.VirtualDeviceList{pvscsi(1000), disk(1000, false), disk(1000, true), disk(1000, true)},
+			hardwareVersion: "vmx-14", expected: 63},
+		{name: "ide disk", devices: object.VirtualDeviceList{pvscsi(1000), ide, disk(200, false)}, hardwareVersion: "vmx-14", expected: 64},
+	}
+	for _, test := range tests {
+		if maxVolumes := getMaxVolumes(test.devices, test.hardwareVersion); maxVolumes != test.expected {
+			t.Errorf("%s: getMaxVolumes() returned %d, expected %d", test.name, maxVolumes, test.expected)
+		}
+	}
+}
+
+func TestGetMaxVolumesOverride(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    int64
+	}{
+		{"no annotation", nil, 0},
+		{"valid", map[string]string{common.AnnMaxVolumes: "20"}, 20},
+		{"zero", map[string]string{common.AnnMaxVolumes: "0"}, 0},
+		{"negative", map[string]string{common.AnnMaxVolumes: "-1"}, 0},
+		{"not a number", map[string]string{common.AnnMaxVolumes: "many"}, 0},
+	}
+	for _, test := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: test.annotations}}
+		if actual := getMaxVolumesOverride(node); actual != test.expected {
+			t.Errorf("%s: getMaxVolumesOverride() = %d, expected %d", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestApplyMaxVolumesOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		computed int64
+		override int64
+		expected int64
+	}{
+		{"no override", 59, 0, 59},
+		{"lower override", 59, 20, 20},
+		{"higher override", 59, 100, 59},
+		{"unknown computed", 0, 20, 20},
+		{"neither", 0, 0, 0},
+	}
+	for _, test := range tests {
+		if actual := applyMaxVolumesOverride(test.computed, test.override); actual != test.expected {
+			t.Errorf("%s: applyMaxVolumesOverride() = %d, expected %d", test.name, actual, test.expected)
+		}
+	}
+}
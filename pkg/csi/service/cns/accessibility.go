@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// hostDatastoresTTL is how long the datastores mounted by a host are cached
+const hostDatastoresTTL = 5 * time.Minute
+
+// hostDatastoreCache caches the datastores mounted by ESXi hosts
+type hostDatastoreCache struct {
+	lock  sync.Mutex
+	ttl   time.Duration
+	hosts map[string]*hostDatastores
+	// load returns the datastores mounted by the host
+	load func(ctx context.Context, host *object.HostSystem) (*hostDatastores, error)
+	now  func() time.Time
+}
+
+type hostDatastores struct {
+	// name is the name of the host
+	name string
+	// urls holds the URLs of the datastores mounted by the host
+	urls   map[string]bool
+	loaded time.Time
+}
+
+func newHostDatastoreCache() *hostDatastoreCache {
+	return &hostDatastoreCache{
+		ttl:   hostDatastoresTTL,
+		hosts: make(map[string]*hostDatastores),
+		load:  loadHostDatastores,
+		now:   time.Now,
+	}
+}
+
+// loadHostDatastores returns the datastores mounted by the host
+func loadHostDatastores(ctx context.Context, host *object.HostSystem) (*hostDatastores, error) {
+	name, err := host.ObjectName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	datastores, err := (&cnsvsphere.HostSystem{HostSystem: host}).GetAllAccessibleDatastores(ctx)
+	if err != nil {
+		return nil, err
+	}
+	urls := make(map[string]bool)
+	for _, datastore := range datastores {
+		urls[datastore.Info.Url] = true
+	}
+	return &hostDatastores{name: name, urls: urls}, nil
+}
+
+// mounts returns the name of the host on the vCenter and whether it mounts the datastore. The cached datastores
+// of the host are reloaded before reporting the datastore as not mounted, as it may have been mounted since.
+func (cache *hostDatastoreCache) mounts(ctx context.Context, vcHost string, host *object.HostSystem,
+	datastoreURL string) (string, bool, error) {
+	key := vcHost + "/" + host.Reference().Value
+	cache.lock.Lock()
+	cached, ok := cache.hosts[key]
+	cache.lock.Unlock()
+	if ok && cache.now().Sub(cached.loaded) < cache.ttl && cached.urls[datastoreURL] {
+		return cached.name, true, nil
+	}
+	loaded, err := cache.load(ctx, host)
+	if err != nil {
+		return "", false, err
+	}
+	loaded.loaded = cache.now()
+	cache.lock.Lock()
+	cache.hosts[key] = loaded
+	cache.lock.Unlock()
+	return loaded.name, loaded.urls[datastoreURL], nil
+}
+
+// verifyVolumeAccessible returns a FailedPrecondition error if the host of the node VM does not mount the datastore
+// of the volume, as vSphere would fail the attach with an opaque device error. Failures to verify are logged and
+// left to the attach to surface.
+func (c *controller) verifyVolumeAccessible(ctx context.Context, manager *common.Manager, vm *cnsvsphere.VirtualMachine,
+	nodeName string, volumeID string) error {
+	if c.hostDatastores == nil {
+		return nil
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil || volume == nil || volume.DatastoreUrl == "" {
+		klog.Warningf("Failed to get datastore of volume %q to verify it is accessible from node %q. err=%v", volumeID, nodeName, err)
+		return nil
+	}
+	host, err := vm.VirtualMachine.HostSystem(ctx)
+	if err != nil {
+		klog.Warningf("Failed to get host of node %q to verify volume %q is accessible. err=%v", nodeName, volumeID, err)
+		return nil
+	}
+	hostName, mounted, err := c.hostDatastores.mounts(ctx, vm.VirtualCenterHost, host, volume.DatastoreUrl)
+	if err != nil {
+		klog.Warningf("Failed to get datastores of host %v of node %q to verify volume %q is accessible. err=%v",
+			host.Reference(), nodeName, volumeID, err)
+		return nil
+	}
+	if !mounted {
+		msg := fmt.Sprintf("Datastore %s of volume %q is not mounted on host %s of node %q",
+			volume.DatastoreUrl, volumeID, hostName, nodeName)
+		klog.Error(msg)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	return nil
+}
+
+// verifyVolumeExpandable returns a FailedPrecondition error if the datastore of the volume cannot grow it to the given
+// capacity, see getDatastoreExpandFailure, as vSphere would fail the extend with a generic error. Failures to verify
+// are logged and left to the extend to surface.
+func verifyVolumeExpandable(ctx context.Context, manager *common.Manager, volume *cnstypes.CnsVolume, capacityMB int64) error {
+	volumeID := volume.VolumeId.Id
+	vc, err := common.GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Warningf("Failed to get vCenter to verify datastore of volume %q. err=%v", volumeID, err)
+		return nil
+	}
+	datastore, err := common.GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		klog.Warningf("Failed to get datastore %s to verify it for volume %q. err=%v", volume.DatastoreUrl, volumeID, err)
+		return nil
+	}
+	summary, err := vc.GetDatastoreSummary(ctx, datastore.Reference())
+	if err != nil {
+		klog.Warningf("Failed to get summary of datastore %s to verify it for volume %q. err=%v", volume.DatastoreUrl, volumeID, err)
+		return nil
+	}
+	if failure := getDatastoreExpandFailure(summary, capacityMB-cnsvolume.GetCapacityMB(volume)); failure != "" {
+		msg := fmt.Sprintf("Volume %q cannot be expanded to %d MB as datastore %s %s", volumeID, capacityMB, volume.DatastoreUrl, failure)
+		klog.Error(msg)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	return nil
+}
+
+// getDatastoreExpandFailure returns why a disk on the datastore with the given summary cannot grow by the given
+// number of MB, or an empty string if it can: the datastore is inaccessible, entering or in maintenance mode, or
+// lacks the free space for the growth. The growth is counted in full, which thin disks would only take up over time.
+func getDatastoreExpandFailure(summary *types.DatastoreSummary, growthMB int64) string {
+	switch {
+	case !summary.Accessible:
+		return "is not accessible"
+	case summary.MaintenanceMode == string(types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance) ||
+		summary.MaintenanceMode == string(types.DatastoreSummaryMaintenanceModeStateInMaintenance):
+		return fmt.Sprintf("is in maintenance mode %q", summary.MaintenanceMode)
+	case growthMB > 0 && summary.FreeSpace < growthMB*common.MbInBytes:
+		return fmt.Sprintf("has %d bytes of free space left", summary.FreeSpace)
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestHostDatastoreCache(t *testing.T) {
+	now := time.Now()
+	mounted := map[string]bool{"ds:///a/": true}
+	loads := 0
+	cache := newHostDatastoreCache()
+	cache.now = func() time.Time { return now }
+	cache.load = func(ctx context.Context, host *object.HostSystem) (*hostDatastores, error) {
+		loads++
+		urls := make(map[string]bool)
+		for url := range mounted {
+			urls[url] = true
+		}
+		return &hostDatastores{name: "esx-1", urls: urls}, nil
+	}
+	host := object.NewHostSystem(nil, types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"})
+	tests := []struct {
+		name            string
+		datastoreURL    string
+		elapsed         time.Duration
+		expectedMounted bool
+		expectedLoads   int
+	}{
+		{"first lookup", "ds:///a/", 0, true, 1},
+		{"cached", "ds:///a/", time.Minute, true, 1},
+		{"expired", "ds:///a/", hostDatastoresTTL, true, 2},
+		{"not mounted is reloaded", "ds:///b/", 0, false, 3},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		name, isMounted, err := cache.mounts(context.Background(), "vc", host, test.datastoreURL)
+		if err != nil {
+			t.Fatalf("%s: mounts() failed: %v", test.name, err)
+		}
+		if name != "esx-1" || isMounted != test.expectedMounted {
+			t.Errorf("%s: mounts() = %q, %v, expected %q, %v", test.name, name, isMounted, "esx-1", test.expectedMounted)
+		}
+		if loads != test.expectedLoads {
+			t.Errorf("%s: host datastores loaded %d times, expected %d", test.name, loads, test.expectedLoads)
+		}
+	}
+}
+
+func TestGetDatastoreExpandFailure(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name            string
+		summary         types.DatastoreSummary
+		growthMB        int64
+		expectedFailure bool
+	}{
+		{"healthy", types.DatastoreSummary{Accessible: true, MaintenanceMode: "normal", FreeSpace: 2048 * mb}, 1024, false},
+		{"not accessible", types.DatastoreSummary{FreeSpace: 2048 * mb}, 1024, true},
+		{"entering maintenance", types.DatastoreSummary{Accessible: true, MaintenanceMode: "enteringMaintenance", FreeSpace: 2048 * mb}, 1024, true},
+		{"in maintenance", types.DatastoreSummary{Accessible: true, MaintenanceMode: "inMaintenance", FreeSpace: 2048 * mb}, 1024, true},
+		{"short of free space", types.DatastoreSummary{Accessible: true, FreeSpace: 512 * mb}, 1024, true},
+		{"no growth", types.DatastoreSummary{Accessible: true}, 0, false},
+	}
+	for _, test := range tests {
+		if failure := getDatastoreExpandFailure(&test.summary, test.growthMB); (failure != "") != test.expectedFailure {
+			t.Errorf("%s: getDatastoreExpandFailure() = %q, expected failure %v", test.name, failure, test.expectedFailure)
+		}
+	}
+}
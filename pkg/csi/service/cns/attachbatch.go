@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// attachFunc attaches volumes to a VM in a single task, returning the disk UUIDs and the errors by volume id
+type attachFunc func(ctx context.Context, manager *common.Manager, vm *cnsvsphere.VirtualMachine,
+	volumeIDs []string) (map[string]string, map[string]error)
+
+// attachBatch is the volumes to attach to a VM in a single task
+type attachBatch struct {
+	manager   *common.Manager
+	vm        *cnsvsphere.VirtualMachine
+	volumeIDs []string
+	// opID is the operation id of the batch, which is the one of the call starting the batch
+	opID string
+	// done is closed once the batch is attached, with the results in diskUUIDs and errs
+	done      chan struct{}
+	diskUUIDs map[string]string
+	errs      map[string]error
+}
+
+// attachBatches coalesces the attaches of volumes to the same VM requested within the batch window into a single
+// CNS attach task, which cuts the vCenter tasks and the startup latency of pods using many volumes.
+// With a window which is not positive, each volume is attached on its own. A nil attachBatches attaches each
+// volume on its own with common.AttachVolumeUtil.
+type attachBatches struct {
+	window  time.Duration
+	attach  attachFunc
+	lock    sync.Mutex
+	pending map[string]*attachBatch
+}
+
+// getAttachBatchWindow returns the batch window of attaches read from X_CSI_ATTACH_BATCH_WINDOW_MS if set,
+// otherwise the default window
+func getAttachBatchWindow() time.Duration {
+	windowMillis := common.DefaultAttachBatchWindowMillis
+	if v := os.Getenv(common.EnvAttachBatchWindowMillis); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			windowMillis = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default window of %d milliseconds",
+				common.EnvAttachBatchWindowMillis, v, windowMillis)
+		}
+	}
+	klog.V(2).Infof("Attaches to the same node will be batched within %d milliseconds", windowMillis)
+	return time.Duration(windowMillis) * time.Millisecond
+}
+
+// newAttachBatches returns the batches of attaches within the given window attached with attach
+func newAttachBatches(window time.Duration, attach attachFunc) *attachBatches {
+	return &attachBatches{window: window, attach: attach, pending: make(map[string]*attachBatch)}
+}
+
+// attachVolume attaches the volume to the VM along with the other volumes requested to be attached to the VM
+// within the batch window, and returns the disk UUID of the volume
+func (b *attachBatches) attachVolume(ctx context.Context, manager *common.Manager, vm *cnsvsphere.VirtualMachine,
+	volumeID string) (string, error) {
+	if b == nil {
+		return common.AttachVolumeUtil(ctx, manager, vm, volumeID)
+	}
+	if b.window <= 0 {
+		diskUUIDs, errs := b.attach(ctx, manager, vm, []string{volumeID})
+		return diskUUIDs[volumeID], errs[volumeID]
+	}
+	key := manager.VcenterConfig.Host + "/" + vm.UUID
+	b.lock.Lock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &attachBatch{manager: manager, vm: vm, opID: opid.FromContext(ctx), done: make(chan struct{})}
+		if batch.opID == "" {
+			batch.opID = opid.New()
+		}
+		b.pending[key] = batch
+		time.AfterFunc(b.window, func() {
+			b.run(key, batch)
+		})
+	}
+	found := false
+	for _, id := range batch.volumeIDs {
+		found = found || id == volumeID
+	}
+	if !found {
+		batch.volumeIDs = append(batch.volumeIDs, volumeID)
+	}
+	if ok {
+		klog.V(4).Infof("Attach of volume %q to node VM %v joins the batch of opId %s", volumeID, vm, batch.opID)
+	}
+	b.lock.Unlock()
+	select {
+	case <-batch.done:
+		if err := batch.errs[volumeID]; err != nil {
+			return "", err
+		}
+		return batch.diskUUIDs[volumeID], nil
+	case <-ctx.Done():
+		// The batch is attached anyway, so that the retried call finds the volume attached
+		return "", ctx.Err()
+	}
+}
+
+// run attaches the batch with the given key once its window is over. The batch serves several calls, hence is not
+// bound to the context of any of them.
+func (b *attachBatches) run(key string, batch *attachBatch) {
+	b.lock.Lock()
+	delete(b.pending, key)
+	b.lock.Unlock()
+	if len(batch.volumeIDs) > 1 {
+		klog.V(2).Infof("Attaching volumes %v to node VM %v in a batch, opId %s", batch.volumeIDs, batch.vm, batch.opID)
+	}
+	ctx := opid.NewContext(context.Background(), batch.opID)
+	batch.diskUUIDs, batch.errs = b.attach(ctx, batch.manager, batch.vm, batch.volumeIDs)
+	close(batch.done)
+}
+
+// attachVolumes attaches the volumes to the node VM in a single task, serialized with the other operations on the VM
+func (c *controller) attachVolumes(ctx context.Context, manager *common.Manager, vm *cnsvsphere.VirtualMachine,
+	volumeIDs []string) (map[string]string, map[string]error) {
+	var diskUUIDs map[string]string
+	var errs map[string]error
+	err := c.vmLocks.run(ctx, vm, func() error {
+		diskUUIDs, errs = common.AttachVolumesUtil(ctx, manager, vm, volumeIDs)
+		return nil
+	})
+	if err != nil {
+		errs = make(map[string]error)
+		for _, volumeID := range volumeIDs {
+			errs[volumeID] = err
+		}
+	}
+	return diskUUIDs, errs
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestAttachBatches(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]string
+	errFailed := errors.New("failed")
+	attach := func(ctx context.Context, manager *common.Manager, vm *cnsvsphere.VirtualMachine,
+		volumeIDs []string) (map[string]string, map[string]error) {
+		lock.Lock()
+		batch := append([]string(nil), volumeIDs...)
+		sort.Strings(batch)
+		batches = append(batches, batch)
+		lock.Unlock()
+		diskUUIDs := make(map[string]string)
+		errs := make(map[string]error)
+		for _, volumeID := range volumeIDs {
+			if volumeID == "vol-3" {
+				errs[volumeID] = errFailed
+			} else {
+				diskUUIDs[volumeID] = "disk-" + volumeID
+			}
+		}
+		return diskUUIDs, errs
+	}
+	b := newAttachBatches(50*time.Millisecond, attach)
+	manager := &common.Manager{VcenterConfig: &cnsvsphere.VirtualCenterConfig{Host: "vc"}}
+	vm1 := &cnsvsphere.VirtualMachine{UUID: "vm-1"}
+	vm2 := &cnsvsphere.VirtualMachine{UUID: "vm-2"}
+
+	tests := []struct {
+		vm               *cnsvsphere.VirtualMachine
+		volumeID         string
+		expectedDiskUUID string
+		expectedErr      error
+	}{
+		{vm1, "vol-1", "disk-vol-1", nil},
+		{vm1, "vol-2", "disk-vol-2", nil},
+		{vm1, "vol-3", "", errFailed},
+		{vm2, "vol-4", "disk-vol-4", nil},
+	}
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		wg.Add(1)
+		go func(vm *cnsvsphere.VirtualMachine, volumeID, expectedDiskUUID string, expectedErr error) {
+			defer wg.Done()
+			diskUUID, err := b.attachVolume(context.Background(), manager, vm, volumeID)
+			if diskUUID != expectedDiskUUID || err != expectedErr {
+				t.Errorf("attachVolume(%s) = %q, %v, expected %q, %v", volumeID, diskUUID, err, expectedDiskUUID, expectedErr)
+			}
+		}(test.vm, test.volumeID, test.expectedDiskUUID, test.expectedErr)
+	}
+	wg.Wait()
+	sort.Slice(batches, func(i, j int) bool { return batches[i][0] < batches[j][0] })
+	expected := [][]string{{"vol-1", "vol-2", "vol-3"}, {"vol-4"}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("attach batches = %v, expected %v", batches, expected)
+	}
+
+	// A canceled call returns while its batch is still pending
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.attachVolume(ctx, manager, vm1, "vol-5"); err != context.Canceled {
+		t.Errorf("attachVolume() with a canceled context = %v, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+const (
+	// eventReasonPhantomAttachment is the reason of the event recorded on a PersistentVolume whose volume is
+	// found attached to a node VM without a VolumeAttachment or a pod using it
+	eventReasonPhantomAttachment = "PhantomAttachment"
+	// eventReasonPhantomAttachmentDetached is the reason of the event recorded on a PersistentVolume whose
+	// phantom attachment was detached
+	eventReasonPhantomAttachmentDetached = "PhantomAttachmentDetached"
+	// eventReasonPhantomAttachmentDetachFailed is the reason of the event recorded on a PersistentVolume whose
+	// phantom attachment failed to be detached
+	eventReasonPhantomAttachmentDetachFailed = "PhantomAttachmentDetachFailed"
+)
+
+// attachment is a volume, identified by its CSI volume id, attached, or to be attached, to the VM of a node
+type attachment struct {
+	nodeName string
+	volumeID string
+}
+
+// getAttachmentReconcileInterval returns the attachment reconcile interval read from
+// X_CSI_ATTACHMENT_RECONCILE_INTERVAL_MINUTES if set and valid, otherwise the default of 10 minutes
+func getAttachmentReconcileInterval() time.Duration {
+	intervalMinutes := common.DefaultAttachmentReconcileIntervalMinutes
+	if v := os.Getenv(common.EnvAttachmentReconcileIntervalMinutes); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			intervalMinutes = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default interval of %d minutes",
+				common.EnvAttachmentReconcileIntervalMinutes, v, intervalMinutes)
+		}
+	}
+	klog.V(2).Infof("Attachments will be reconciled every %d minutes", intervalMinutes)
+	return time.Duration(intervalMinutes) * time.Minute
+}
+
+// runAttachmentReconciler periodically corrects attachments of volumes to node VMs diverging from the VolumeAttachments
+func (c *controller) runAttachmentReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.reconcileAttachments()
+	}
+}
+
+func (c *controller) reconcileAttachments() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodeList, err := c.k8sClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Attachment reconciler failed to list nodes. err: %v", err)
+		return
+	}
+	// Node VMs are read before the VolumeAttachments are listed, so that a volume attached meanwhile
+	// has its VolumeAttachment listed and is not taken for an unexpected attachment.
+	nodes := make(map[string]*v1.Node)
+	vms := make(map[string]*cnsvsphere.VirtualMachine)
+	attachedVolumeIDs := make(map[string][]string)
+	drainPending := make(map[string]bool)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		vm, err := c.nodeMgr.GetNodeByName(node.Name)
+		if err != nil {
+			klog.Warningf("Attachment reconciler failed to get VM for node: %q. err: %v", node.Name, err)
+			continue
+		}
+		volumeIDs, err := volume.GetAttachedVolumes(ctx, vm)
+		if err != nil {
+			klog.Warningf("Attachment reconciler failed to get volumes attached to node: %q. err: %v", node.Name, err)
+			continue
+		}
+		nodes[node.Name] = node
+		vms[node.Name] = vm
+		attachedVolumeIDs[node.Name] = volumeIDs
+		drainPending[node.Name] = isDrainPending(node)
+	}
+	vaList, err := c.k8sClient.StorageV1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Attachment reconciler failed to list VolumeAttachments. err: %v", err)
+		return
+	}
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Attachment reconciler failed to list PersistentVolumes. err: %v", err)
+		return
+	}
+	missing, unexpected := getAttachmentDrift(vaList.Items, pvList.Items, attachedVolumeIDs)
+	prometheus.AttachmentDrift.WithLabelValues(prometheus.AttachmentDriftMissing).Set(float64(len(missing)))
+	prometheus.AttachmentDrift.WithLabelValues(prometheus.AttachmentDriftUnexpected).Set(float64(len(unexpected)))
+	for _, a := range missing {
+		if drainPending[a.nodeName] {
+			// Detached on purpose ahead of the drain of the node
+			continue
+		}
+		manager, volumeID, err := c.getVolumeManager(a.volumeID)
+		if err != nil {
+			klog.Warningf("Attachment reconciler failed to get vCenter of volume %q. err: %v", a.volumeID, err)
+			continue
+		}
+		// The attach may have completed since the node VM was read
+		if diskUUID, err := volume.GetDiskAttachedToVM(ctx, vms[a.nodeName], volumeID); err != nil || diskUUID != "" {
+			continue
+		}
+		klog.Warningf("Volume %q has a VolumeAttachment to node %q but is not attached to the node VM, attaching it", a.volumeID, a.nodeName)
+		vm := vms[a.nodeName]
+		c.correctAttachment(ctx, prometheus.AttachmentDriftMissing, func() error {
+			return c.vmLocks.run(ctx, vm, func() error {
+				_, err := common.AttachVolumeUtil(ctx, manager, vm, volumeID)
+				return err
+			})
+		})
+	}
+	if len(unexpected) == 0 {
+		return
+	}
+	podList, err := c.k8sClient.CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Attachment reconciler failed to list pods. err: %v", err)
+		return
+	}
+	pvcList, err := c.k8sClient.CoreV1().PersistentVolumeClaims("").List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Attachment reconciler failed to list PersistentVolumeClaims. err: %v", err)
+		return
+	}
+	pods := make([]*v1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	pvcs := make([]*v1.PersistentVolumeClaim, 0, len(pvcList.Items))
+	for i := range pvcList.Items {
+		pvcs = append(pvcs, &pvcList.Items[i])
+	}
+	pvs := make(map[string]*v1.PersistentVolume)
+	for i := range pvList.Items {
+		if pv := &pvList.Items[i]; pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName {
+			pvs[pv.Spec.CSI.VolumeHandle] = pv
+		}
+	}
+	pvPointers := make([]*v1.PersistentVolume, 0, len(pvs))
+	for _, pv := range pvs {
+		pvPointers = append(pvPointers, pv)
+	}
+	for _, a := range unexpected {
+		if getVolumesInUse(nodes[a.nodeName], pods, pvcs, pvPointers)[a.volumeID] {
+			klog.Warningf("Volume %q is attached to node %q without a VolumeAttachment but is in use on the node, leaving it attached",
+				a.volumeID, a.nodeName)
+			continue
+		}
+		// Phantom attachment, e.g. left behind by a namespace force deleted while its pods were running
+		pv := pvs[a.volumeID]
+		klog.Warningf("Volume %q of PersistentVolume %q is attached to node %q without a VolumeAttachment or a pod using it, detaching it",
+			a.volumeID, pv.Name, a.nodeName)
+		c.eventRecorder.Eventf(pv, v1.EventTypeWarning, eventReasonPhantomAttachment,
+			"Volume is attached to node %s without a VolumeAttachment or a pod using it, detaching it", a.nodeName)
+		c.correctAttachment(ctx, prometheus.AttachmentDriftUnexpected, func() error {
+			manager, volumeID, err := c.getVolumeManager(a.volumeID)
+			if err != nil {
+				return err
+			}
+			vm := vms[a.nodeName]
+			err = c.vmLocks.run(ctx, vm, func() error {
+				return common.DetachVolumeUtil(ctx, manager, vm, volumeID)
+			})
+			if err != nil {
+				c.eventRecorder.Eventf(pv, v1.EventTypeWarning, eventReasonPhantomAttachmentDetachFailed,
+					"Failed to detach volume from node %s: %v", a.nodeName, err)
+			} else {
+				c.eventRecorder.Eventf(pv, v1.EventTypeNormal, eventReasonPhantomAttachmentDetached,
+					"Detached volume from node %s", a.nodeName)
+			}
+			return err
+		})
+	}
+}
+
+// releaseStaleAttachment detaches the volume with the given CSI and CNS ids from the VMs of the nodes other than the
+// given one it is attached to without a VolumeAttachment or a pod using it, as the attachment reconciler would, so that
+// a volume moving to the node is attached right away instead of failing as in use until the next reconciliation.
+// Returns whether the volume was detached from any node.
+func (c *controller) releaseStaleAttachment(ctx context.Context, manager *common.Manager, volumeID string,
+	cnsVolumeID string, nodeName string) (bool, error) {
+	nodeList, err := c.k8sClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	vaList, err := c.k8sClient.StorageV1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	podList, err := c.k8sClient.CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	pvcList, err := c.k8sClient.CoreV1().PersistentVolumeClaims("").List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	var pv *v1.PersistentVolume
+	for i := range pvList.Items {
+		if p := &pvList.Items[i]; p.Spec.CSI != nil && p.Spec.CSI.Driver == common.DriverName && p.Spec.CSI.VolumeHandle == volumeID {
+			pv = p
+			break
+		}
+	}
+	if pv == nil {
+		// Volumes without a PersistentVolume of the driver are not managed by it
+		return false, nil
+	}
+	published := make(map[string]bool)
+	for _, name := range getAttachedNodeNames(vaList.Items, pvList.Items)[cnsVolumeID] {
+		published[name] = true
+	}
+	pods := make([]*v1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	pvcs := make([]*v1.PersistentVolumeClaim, 0, len(pvcList.Items))
+	for i := range pvcList.Items {
+		pvcs = append(pvcs, &pvcList.Items[i])
+	}
+	released := false
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if node.Name == nodeName || published[node.Name] {
+			continue
+		}
+		vm, err := c.nodeMgr.GetNodeByName(node.Name)
+		if err != nil {
+			klog.Warningf("Failed to get VM for node: %q to look for volume %q. err: %v", node.Name, volumeID, err)
+			continue
+		}
+		if vm.VirtualCenterHost != "" && vm.VirtualCenterHost != manager.VcenterConfig.Host {
+			continue
+		}
+		diskUUID, err := volume.GetDiskAttachedToVM(ctx, vm, cnsVolumeID)
+		if err != nil {
+			klog.Warningf("Failed to get disks of node: %q to look for volume %q. err: %v", node.Name, volumeID, err)
+			continue
+		}
+		if diskUUID == "" {
+			continue
+		}
+		if getVolumesInUse(node, pods, pvcs, []*v1.PersistentVolume{pv})[volumeID] {
+			klog.Warningf("Volume %q is attached to node %q without a VolumeAttachment but is in use on the node, leaving it attached",
+				volumeID, node.Name)
+			continue
+		}
+		klog.Warningf("Volume %q of PersistentVolume %q is attached to node %q without a VolumeAttachment or a pod using it, detaching it to attach it to node %q",
+			volumeID, pv.Name, node.Name, nodeName)
+		c.eventRecorder.Eventf(pv, v1.EventTypeWarning, eventReasonPhantomAttachment,
+			"Volume is attached to node %s without a VolumeAttachment or a pod using it, detaching it to attach it to node %s", node.Name, nodeName)
+		err = c.vmLocks.run(ctx, vm, func() error {
+			return common.DetachVolumeUtil(ctx, manager, vm, cnsVolumeID)
+		})
+		if err != nil {
+			c.eventRecorder.Eventf(pv, v1.EventTypeWarning, eventReasonPhantomAttachmentDetachFailed,
+				"Failed to detach volume from node %s: %v", node.Name, err)
+			return released, err
+		}
+		c.eventRecorder.Eventf(pv, v1.EventTypeNormal, eventReasonPhantomAttachmentDetached,
+			"Detached volume from node %s", node.Name)
+		released = true
+	}
+	return released, nil
+}
+
+// isVolumeInUse returns whether err is the fault of attaching a volume which is attached to another VM
+func isVolumeInUse(err error) bool {
+	fault, ok := err.(*volume.Fault)
+	return ok && fault.Message == volume.CNSVolumeResourceInUseFaultMessage
+}
+
+// correctAttachment runs the correction of an attachment drifted in the given direction within the attach workers
+func (c *controller) correctAttachment(ctx context.Context, direction string, correct func() error) {
+	if err := c.attachWorkers.acquire(ctx); err != nil {
+		return
+	}
+	defer c.attachWorkers.release()
+	p := priorityNormal
+	if direction == prometheus.AttachmentDriftUnexpected {
+		p = priorityHigh
+	}
+	if err := c.vcenterWorkers.acquire(ctx, p); err != nil {
+		return
+	}
+	defer c.vcenterWorkers.release()
+	result := prometheus.TaskResultSuccess
+	if err := correct(); err != nil {
+		klog.Warningf("Attachment reconciler failed to correct attachment. err: %v", err)
+		result = prometheus.TaskResultFailure
+	}
+	prometheus.AttachmentDriftCorrections.WithLabelValues(direction, result).Inc()
+}
+
+// getAttachmentDrift compares the VolumeAttachments of the driver with the volumes attached to the VMs of the nodes
+// in attachedVolumeIDs, which holds the CNS ids of the volumes. It returns the attachments marked attached in a
+// VolumeAttachment which are missing on the node VM, and the volumes of a PersistentVolume of the driver attached
+// to a node VM without any VolumeAttachment. VolumeAttachments being attached or detached are left to the
+// external attacher. File volumes are mounted by the nodes rather than attached to node VMs, hence are ignored.
+func getAttachmentDrift(vas []storagev1.VolumeAttachment, pvs []v1.PersistentVolume,
+	attachedVolumeIDs map[string][]string) (missing []attachment, unexpected []attachment) {
+	volumeIDs := make(map[string]string)
+	handles := make(map[string]string)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName && !common.IsFileVolumeID(pv.Spec.CSI.VolumeHandle) {
+			volumeIDs[pv.Name] = pv.Spec.CSI.VolumeHandle
+			cnsVolumeID, _ := common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+			handles[cnsVolumeID] = pv.Spec.CSI.VolumeHandle
+		}
+	}
+	// Volumes attached without a PersistentVolume of the driver are not managed by it, hence left out
+	attached := make(map[attachment]bool)
+	for nodeName, nodeVolumeIDs := range attachedVolumeIDs {
+		for _, cnsVolumeID := range nodeVolumeIDs {
+			if volumeID, ok := handles[cnsVolumeID]; ok {
+				attached[attachment{nodeName: nodeName, volumeID: volumeID}] = true
+			}
+		}
+	}
+	known := make(map[attachment]bool)
+	for _, va := range vas {
+		if va.Spec.Attacher != common.DriverName || va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		volumeID, ok := volumeIDs[*va.Spec.Source.PersistentVolumeName]
+		if !ok {
+			continue
+		}
+		a := attachment{nodeName: va.Spec.NodeName, volumeID: volumeID}
+		known[a] = true
+		if _, ok := attachedVolumeIDs[a.nodeName]; ok && va.Status.Attached && va.DeletionTimestamp == nil && !attached[a] {
+			missing = append(missing, a)
+		}
+	}
+	for a := range attached {
+		if !known[a] {
+			unexpected = append(unexpected, a)
+		}
+	}
+	sortAttachments(missing)
+	sortAttachments(unexpected)
+	return missing, unexpected
+}
+
+func sortAttachments(attachments []attachment) {
+	sort.Slice(attachments, func(i, j int) bool {
+		if attachments[i].nodeName != attachments[j].nodeName {
+			return attachments[i].nodeName < attachments[j].nodeName
+		}
+		return attachments[i].volumeID < attachments[j].volumeID
+	})
+}
+
+// getAttachedNodeName returns the name of the node the volume is attached to according to the VolumeAttachments
+// of the driver, or an empty string if none marks it attached.
+func getAttachedNodeName(vas []storagev1.VolumeAttachment, pvs []v1.PersistentVolume, volumeID string) string {
+	pvNames := make(map[string]bool)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName && pv.Spec.CSI.VolumeHandle == volumeID {
+			pvNames[pv.Name] = true
+		}
+	}
+	for _, va := range vas {
+		if va.Spec.Attacher != common.DriverName || va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		if pvNames[*va.Spec.Source.PersistentVolumeName] && va.Status.Attached && va.DeletionTimestamp == nil {
+			return va.Spec.NodeName
+		}
+	}
+	return ""
+}
+
+// getAttachedNode returns the VM of the node the volume is attached to according to the VolumeAttachments
+// of the driver, or nil if none marks it attached.
+func (c *controller) getAttachedNode(volumeID string) (*cnsvsphere.VirtualMachine, string, error) {
+	nodeName, err := c.getVolumeNodeName(volumeID)
+	if err != nil || nodeName == "" {
+		return nil, "", err
+	}
+	vm, err := c.nodeMgr.GetNodeByName(nodeName)
+	if err != nil {
+		return nil, nodeName, err
+	}
+	return vm, nodeName, nil
+}
+
+// getVolumeNodeName returns the name of the node the volume is attached to according to the VolumeAttachments
+// of the driver, or an empty string if none marks it attached
+func (c *controller) getVolumeNodeName(volumeID string) (string, error) {
+	vaList, err := c.k8sClient.StorageV1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	return getAttachedNodeName(vaList.Items, pvList.Items, volumeID), nil
+}
+
+// getPublishedNodeIDs returns the names of the nodes each volume, by CNS id, is attached to according to the
+// VolumeAttachments of the driver. The VolumeAttachments are listed once for all the volumes, rather than
+// reading the devices of every node VM.
+func (c *controller) getPublishedNodeIDs() (map[string][]string, error) {
+	vaList, err := c.k8sClient.StorageV1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return getAttachedNodeNames(vaList.Items, pvList.Items), nil
+}
+
+// getAttachedNodeNames returns the names of the nodes each volume, by CNS id, is attached to according to the
+// VolumeAttachments of the driver
+func getAttachedNodeNames(vas []storagev1.VolumeAttachment, pvs []v1.PersistentVolume) map[string][]string {
+	volumeIDs := make(map[string]string)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName {
+			volumeIDs[pv.Name], _ = common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+		}
+	}
+	nodeNames := make(map[string][]string)
+	for _, va := range vas {
+		if va.Spec.Attacher != common.DriverName || va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		volumeID, ok := volumeIDs[*va.Spec.Source.PersistentVolumeName]
+		if ok && va.Status.Attached && va.DeletionTimestamp == nil {
+			nodeNames[volumeID] = append(nodeNames[volumeID], va.Spec.NodeName)
+		}
+	}
+	for _, names := range nodeNames {
+		sort.Strings(names)
+	}
+	return nodeNames
+}
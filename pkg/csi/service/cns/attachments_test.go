@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func newTestPV(name, driver, volumeHandle string) v1.PersistentVolume {
+	return v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: volumeHandle},
+			},
+		},
+	}
+}
+
+func newTestVA(pvName, nodeName string, attached, deleting bool) storagev1.VolumeAttachment {
+	va := storagev1.VolumeAttachment{
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: common.DriverName,
+			NodeName: nodeName,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: attached},
+	}
+	if deleting {
+		now := metav1.Now()
+		va.DeletionTimestamp = &now
+	}
+	return va
+}
+
+func TestGetAttachmentDrift(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		newTestPV("pv-1", common.DriverName, "fcd-1"),
+		newTestPV("pv-2", common.DriverName, "fcd-2"),
+		newTestPV("pv-3", common.DriverName, "fcd-3"),
+		newTestPV("pv-4", common.DriverName, "fcd-4"),
+		newTestPV("pv-5", "other.csi.driver", "fcd-5"),
+		newTestPV("pv-6", common.DriverName, common.FileVolumePrefix+"share-6"),
+		newTestPV("pv-7", common.DriverName, common.EncodeVolumeID("fcd-7", "vc-b")),
+	}
+	tests := []struct {
+		name               string
+		vas                []storagev1.VolumeAttachment
+		attachedVolumeIDs  map[string][]string
+		expectedMissing    []attachment
+		expectedUnexpected []attachment
+	}{
+		{
+			name:              "in sync",
+			vas:               []storagev1.VolumeAttachment{newTestVA("pv-1", "node-1", true, false)},
+			attachedVolumeIDs: map[string][]string{"node-1": {"fcd-1"}},
+		},
+		{
+			name:              "missing",
+			vas:               []storagev1.VolumeAttachment{newTestVA("pv-1", "node-1", true, false), newTestVA("pv-2", "node-1", true, false)},
+			attachedVolumeIDs: map[string][]string{"node-1": {"fcd-1"}},
+			expectedMissing:   []attachment{{"node-1", "fcd-2"}},
+		},
+		{
+			name: "being attached or detached",
+			vas: []storagev1.VolumeAttachment{
+				newTestVA("pv-1", "node-1", false, false),
+				newTestVA("pv-2", "node-1", true, true),
+				newTestVA("pv-3", "node-1", false, false),
+			},
+			attachedVolumeIDs: map[string][]string{"node-1": {"fcd-3"}},
+		},
+		{
+			name:              "node VM not read",
+			vas:               []storagev1.VolumeAttachment{newTestVA("pv-1", "node-2", true, false)},
+			attachedVolumeIDs: map[string][]string{"node-1": {}},
+		},
+		{
+			name:              "file volume",
+			vas:               []storagev1.VolumeAttachment{newTestVA("pv-6", "node-1", true, false)},
+			attachedVolumeIDs: map[string][]string{"node-1": {}},
+		},
+		{
+			name:               "unexpected",
+			vas:                []storagev1.VolumeAttachment{newTestVA("pv-1", "node-1", true, false)},
+			attachedVolumeIDs:  map[string][]string{"node-1": {"fcd-1", "fcd-4", "fcd-5", "fcd-6"}, "node-2": {"fcd-1"}},
+			expectedUnexpected: []attachment{{"node-1", "fcd-4"}, {"node-2", "fcd-1"}},
+		},
+		{
+			name:              "other vCenter in sync",
+			vas:               []storagev1.VolumeAttachment{newTestVA("pv-7", "node-1", true, false)},
+			attachedVolumeIDs: map[string][]string{"node-1": {"fcd-7"}},
+		},
+		{
+			name:               "other vCenter",
+			vas:                []storagev1.VolumeAttachment{newTestVA("pv-7", "node-1", true, false)},
+			attachedVolumeIDs:  map[string][]string{"node-1": {}, "node-2": {"fcd-7"}},
+			expectedMissing:    []attachment{{"node-1", "fcd-7@vc-b"}},
+			expectedUnexpected: []attachment{{"node-2", "fcd-7@vc-b"}},
+		},
+	}
+	for _, test := range tests {
+		missing, unexpected := getAttachmentDrift(test.vas, pvs, test.attachedVolumeIDs)
+		if !reflect.DeepEqual(missing, test.expectedMissing) {
+			t.Errorf("%s: missing = %v, expected %v", test.name, missing, test.expectedMissing)
+		}
+		if !reflect.DeepEqual(unexpected, test.expectedUnexpected) {
+			t.Errorf("%s: unexpected = %v, expected %v", test.name, unexpected, test.expectedUnexpected)
+		}
+	}
+}
+
+func TestGetAttachedNodeName(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		newTestPV("pv-1", common.DriverName, "fcd-1"),
+		newTestPV("pv-2", common.DriverName, "fcd-2"),
+		newTestPV("pv-3", "other.csi.driver", "fcd-3"),
+	}
+	vas := []storagev1.VolumeAttachment{
+		newTestVA("pv-1", "node-1", false, false),
+		newTestVA("pv-1", "node-2", true, false),
+		newTestVA("pv-2", "node-1", true, true),
+		newTestVA("pv-3", "node-1", true, false),
+	}
+	tests := []struct {
+		volumeID string
+		expected string
+	}{
+		{"fcd-1", "node-2"},
+		{"fcd-2", ""},
+		{"fcd-3", ""},
+		{"fcd-4", ""},
+	}
+	for _, test := range tests {
+		if nodeName := getAttachedNodeName(vas, pvs, test.volumeID); nodeName != test.expected {
+			t.Errorf("getAttachedNodeName(%q) = %q, expected %q", test.volumeID, nodeName, test.expected)
+		}
+	}
+}
+
+func TestGetAttachedNodeNames(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		newTestPV("pv-1", common.DriverName, "fcd-1"),
+		newTestPV("pv-2", common.DriverName, "fcd-2"),
+		newTestPV("pv-3", "other.csi.driver", "fcd-3"),
+		newTestPV("pv-4", common.DriverName, common.EncodeVolumeID("fcd-4", "vc-b")),
+	}
+	vas := []storagev1.VolumeAttachment{
+		newTestVA("pv-1", "node-2", true, false),
+		newTestVA("pv-1", "node-1", true, false),
+		newTestVA("pv-1", "node-3", false, false),
+		newTestVA("pv-2", "node-1", true, true),
+		newTestVA("pv-3", "node-1", true, false),
+		newTestVA("pv-4", "node-2", true, false),
+	}
+	expected := map[string][]string{
+		"fcd-1": {"node-1", "node-2"},
+		"fcd-4": {"node-2"},
+	}
+	if nodeNames := getAttachedNodeNames(vas, pvs); !reflect.DeepEqual(nodeNames, expected) {
+		t.Errorf("getAttachedNodeNames() = %v, expected %v", nodeNames, expected)
+	}
+}
+
+func TestIsVolumeInUse(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"no error", nil, false},
+		{"in use fault", &volume.Fault{Type: "ResourceInUse", Message: volume.CNSVolumeResourceInUseFaultMessage}, true},
+		{"other fault", &volume.Fault{Type: "NotFound", Message: "The object or item referred to could not be found."}, false},
+		{"other error", errors.New(volume.CNSVolumeResourceInUseFaultMessage), false},
+	}
+	for _, test := range tests {
+		if inUse := isVolumeInUse(test.err); inUse != test.expected {
+			t.Errorf("%s: isVolumeInUse() = %v, expected %v", test.name, inUse, test.expected)
+		}
+	}
+}
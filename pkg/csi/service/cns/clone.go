@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// validateCloneSource returns an InvalidArgument error if the persistent volume of the source volume is not
+// compatible with the requested capabilities and filesystem type of the clone. The check is skipped if the
+// source volume has no persistent volume, e.g. when it is statically provisioned outside of Kubernetes.
+func (c *controller) validateCloneSource(sourceVolumeID string, caps []*csi.VolumeCapability, fsType string) error {
+	pvs, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		msg := fmt.Sprintf("Failed to list persistent volumes to validate source volume %q. Error: %+v", sourceVolumeID, err)
+		klog.Error(msg)
+		return status.Error(codes.Internal, msg)
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName && pv.Spec.CSI.VolumeHandle == sourceVolumeID {
+			if err = checkCloneCompatibility(pv, caps, fsType); err != nil {
+				klog.Error(err)
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			return nil
+		}
+	}
+	klog.V(4).Infof("No persistent volume found for source volume %q, skipping compatibility validation", sourceVolumeID)
+	return nil
+}
+
+// checkCloneCompatibility returns an error if a clone of the persistent volume cannot be used with the
+// capabilities and filesystem type, as the clone carries the data and filesystem of the source.
+func checkCloneCompatibility(pv *v1.PersistentVolume, caps []*csi.VolumeCapability, fsType string) error {
+	sourceBlock := pv.Spec.VolumeMode != nil && *pv.Spec.VolumeMode == v1.PersistentVolumeBlock
+	sourceFsType := pv.Spec.CSI.FSType
+	if sourceFsType == "" {
+		sourceFsType = pv.Spec.CSI.VolumeAttributes[common.AttributeFsType]
+	}
+	if sourceFsType == "" {
+		sourceFsType = common.DefaultFsType
+	}
+	if fsType == "" {
+		fsType = common.DefaultFsType
+	}
+	for _, volCap := range caps {
+		if block := volCap.GetBlock() != nil; block != sourceBlock {
+			return fmt.Errorf("volume mode of the clone does not match volume mode of source volume %q of persistent volume %q",
+				pv.Spec.CSI.VolumeHandle, pv.Name)
+		}
+		if sourceBlock {
+			continue
+		}
+		if mountFsType := volCap.GetMount().GetFsType(); mountFsType != "" {
+			fsType = mountFsType
+		}
+		if !strings.EqualFold(fsType, sourceFsType) {
+			return fmt.Errorf("filesystem type %q of the clone does not match filesystem type %q of source volume %q of persistent volume %q",
+				fsType, sourceFsType, pv.Spec.CSI.VolumeHandle, pv.Name)
+		}
+	}
+	for _, mode := range pv.Spec.AccessModes {
+		if mode == v1.ReadWriteMany {
+			return fmt.Errorf("source volume %q of persistent volume %q has unsupported access mode %s",
+				pv.Spec.CSI.VolumeHandle, pv.Name, mode)
+		}
+	}
+	return nil
+}
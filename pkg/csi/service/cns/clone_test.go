@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestCheckCloneCompatibility(t *testing.T) {
+	block := v1.PersistentVolumeBlock
+	newPV := func(volumeMode *v1.PersistentVolumeMode, fsType string, accessMode v1.PersistentVolumeAccessMode) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{}
+		pv.Name = "pv-source"
+		pv.Spec.VolumeMode = volumeMode
+		pv.Spec.AccessModes = []v1.PersistentVolumeAccessMode{accessMode}
+		pv.Spec.CSI = &v1.CSIPersistentVolumeSource{Driver: common.DriverName, VolumeHandle: "vol-source", FSType: fsType}
+		return pv
+	}
+	mount := func(fsType string) []*csi.VolumeCapability {
+		return []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+		}}
+	}
+	blockCaps := []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}}
+	tests := []struct {
+		name        string
+		pv          *v1.PersistentVolume
+		caps        []*csi.VolumeCapability
+		fsType      string
+		expectError bool
+	}{
+		{name: "default filesystem", pv: newPV(nil, "", v1.ReadWriteOnce), caps: mount("")},
+		{name: "matching filesystem", pv: newPV(nil, "xfs", v1.ReadWriteOnce), caps: mount(""), fsType: "xfs"},
+		{name: "mismatching filesystem", pv: newPV(nil, "ext4", v1.ReadWriteOnce), caps: mount("xfs"), expectError: true},
+		{name: "block volume", pv: newPV(&block, "", v1.ReadWriteOnce), caps: blockCaps},
+		{name: "block source cloned to filesystem", pv: newPV(&block, "", v1.ReadWriteOnce), caps: mount(""), expectError: true},
+		{name: "filesystem source cloned to block", pv: newPV(nil, "", v1.ReadWriteOnce), caps: blockCaps, expectError: true},
+		{name: "unsupported access mode", pv: newPV(nil, "", v1.ReadWriteMany), caps: mount(""), expectError: true},
+	}
+	for _, test := range tests {
+		err := checkCloneCompatibility(test.pv, test.caps, test.fsType)
+		if test.expectError != (err != nil) {
+			t.Errorf("%s: checkCloneCompatibility() returned %v, expected error: %t", test.name, err, test.expectError)
+		}
+	}
+}
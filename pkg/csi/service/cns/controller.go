@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,13 +29,19 @@ import (
 	"github.com/vmware/govmomi/units"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
 	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
 )
 
 var (
@@ -42,19 +49,52 @@ var (
 	controllerCaps = []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 	}
 )
 
 type nodeManager interface {
 	Initialize() error
 	GetSharedDatastoresInK8SCluster(ctx context.Context) ([]*cnsvsphere.DatastoreInfo, error)
-	GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneKey string, regionKey string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error)
+	GetAccessibleDatastoresInK8SCluster(ctx context.Context) ([]*cnsvsphere.DatastoreInfo, error)
+	GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, levels []common.TopologyLevel) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error)
 	GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine, error)
 }
 
 type controller struct {
 	manager *common.Manager
-	nodeMgr nodeManager
+	// managers are the managers of the vCenters other than the first one of the config by host,
+	// for clusters whose node VMs span several vCenters
+	managers    map[string]*common.Manager
+	nodeMgr     nodeManager
+	k8sClient   clientset.Interface
+	reclaimMode string
+	// provisioningWorkers limits concurrent CreateVolume and DeleteVolume operations
+	provisioningWorkers workers
+	// attachWorkers limits concurrent ControllerPublishVolume and ControllerUnpublishVolume operations
+	attachWorkers workers
+	// vcenterWorkers limits concurrent vCenter operations across subsystems, serving detaches first
+	vcenterWorkers *priorityWorkers
+	// eventRecorder records events of the controller on Kubernetes objects
+	eventRecorder record.EventRecorder
+	// hostDatastores caches the datastores mounted by hosts, to verify volumes are accessible before attaching them
+	hostDatastores *hostDatastoreCache
+	// detaches counts the ControllerUnpublishVolume calls in flight, which are waited for when a node is deleted
+	detaches *inflightDetaches
+	// attachBatches coalesces the attaches of volumes to the same node VM into a single CNS task
+	attachBatches *attachBatches
+	// vmLocks serializes the attaches, detaches and other reconfigurations of each node VM
+	vmLocks *vmLocks
+	// trashedVolumeSpecs records the spec of the PV of each volume moved to trash, nil unless in trash reclaim mode
+	trashedVolumeSpecs *k8s.TrashedVolumeSpecs
 }
 
 // New creates a CNS controller
@@ -67,22 +107,31 @@ func (c *controller) Init(config *config.Config) error {
 	klog.Infof("Initializing CNS controller")
 	// Get VirtualCenterManager instance and validate version
 	var err error
-	vcenterconfig, err := cnsvsphere.GetVirtualCenterConfig(config)
+	vcenterconfigs, err := cnsvsphere.GetVirtualCenterConfigs(config)
 	if err != nil {
 		klog.Errorf("Failed to get VirtualCenterConfig. err=%v", err)
 		return err
 	}
+	vcenterconfig := vcenterconfigs[0]
 	vcManager := cnsvsphere.GetVirtualCenterManager()
 	vcenter, err := vcManager.RegisterVirtualCenter(vcenterconfig)
 	if err != nil {
 		klog.Errorf("Failed to register VC with virtualCenterManager. err=%v", err)
 		return err
 	}
+	placement, err := common.NewDatastorePlacement(config)
+	if err != nil {
+		klog.Errorf("Invalid datastore placement config. err=%v", err)
+		return err
+	}
 	c.manager = &common.Manager{
 		VcenterConfig:  vcenterconfig,
 		CnsConfig:      config,
 		VolumeManager:  cnsvolume.GetManager(vcenter),
 		VcenterManager: cnsvsphere.GetVirtualCenterManager(),
+		// Datastores quarantined after repeated CreateVolume failures are left out of placement
+		DatastoreQuarantine: common.NewDatastoreQuarantine(),
+		DatastorePlacement:  placement,
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -97,12 +146,79 @@ func (c *controller) Init(config *config.Config) error {
 		klog.Errorf("checkAPI failed for vcenter API version: %s, err=%v", vc.Client.ServiceContent.About.ApiVersion, err)
 		return err
 	}
-	c.nodeMgr = &Nodes{}
+	// Warm the volume cache before serving requests, to avoid querying CNS for each volume after a restart
+	queryFilter := cnstypes.CnsQueryFilter{
+		ContainerClusterIds: []string{config.Global.ClusterID},
+	}
+	if err = c.manager.VolumeManager.WarmCache(queryFilter); err != nil {
+		klog.Warningf("Failed to warm volume cache. err=%v", err)
+	}
+	// Node VMs on the other vCenters are discovered by the node manager, which looks for them on all
+	// the registered vCenters
+	c.managers = make(map[string]*common.Manager)
+	for _, vcConfig := range vcenterconfigs[1:] {
+		vc, err := vcManager.RegisterVirtualCenter(vcConfig)
+		if err != nil {
+			klog.Errorf("Failed to register VC %q with virtualCenterManager. err=%v", vcConfig.Host, err)
+			return err
+		}
+		c.managers[vcConfig.Host] = &common.Manager{
+			VcenterConfig:       vcConfig,
+			CnsConfig:           config,
+			VolumeManager:       cnsvolume.NewManager(vc),
+			VcenterManager:      vcManager,
+			DatastoreQuarantine: c.manager.DatastoreQuarantine,
+			DatastorePlacement:  c.manager.DatastorePlacement,
+		}
+		klog.V(2).Infof("Registered vCenter %q", vcConfig.Host)
+	}
+	c.provisioningWorkers = newWorkers(common.EnvProvisioningWorkers, common.DefaultProvisioningWorkers)
+	c.attachWorkers = newWorkers(common.EnvAttachWorkers, common.DefaultAttachWorkers)
+	c.vcenterWorkers = newPriorityWorkers(common.EnvVCenterWorkers, common.DefaultVCenterWorkers)
+	c.hostDatastores = newHostDatastoreCache()
+	c.detaches = newInflightDetaches()
+	c.vmLocks = newVMLocks()
+	c.attachBatches = newAttachBatches(getAttachBatchWindow(), c.attachVolumes)
+	// The kubernetes client is created first, as the node manager hands deleted nodes to detachDeletedNode, which
+	// lists PersistentVolumes, as soon as it is initialized
+	c.k8sClient, err = k8s.NewClient()
+	if err != nil {
+		klog.Errorf("Creating Kubernetes client failed. Err: %v", err)
+		return err
+	}
+	c.eventRecorder = k8s.NewEventRecorder(c.k8sClient, "vsphere-csi-controller")
+	c.nodeMgr = &Nodes{detachIdleVolumes: c.detachIdleVolumes, detachDeletedNode: c.detachDeletedNode}
 	err = c.nodeMgr.Initialize()
 	if err != nil {
 		klog.Errorf("Failed to initialize nodeMgr. err=%v", err)
 		return err
 	}
+	c.reclaimMode, err = common.GetVolumeReclaimMode()
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	klog.V(2).Infof("Volume reclaim mode: %q", c.reclaimMode)
+	c.watchCredentials()
+	if name := getTaskJournalName(); name != "" {
+		journal, err := newConfigMapJournal(c.k8sClient, getConfigSecretNamespace(), name)
+		if err != nil {
+			klog.Errorf("Failed to load task journal %q. Err: %v", name, err)
+			return err
+		}
+		cnsvolume.SetTaskJournal(journal)
+		go c.replayTaskJournal()
+	}
+	if c.reclaimMode == common.VolumeReclaimModeTrash {
+		c.trashedVolumeSpecs = k8s.NewTrashedVolumeSpecs(c.k8sClient, getConfigSecretNamespace())
+		go c.runTrashJanitor(getTrashRetention())
+	}
+	if interval := getAttachmentReconcileInterval(); interval > 0 {
+		go c.runAttachmentReconciler(interval)
+	}
+	if interval := getRelocationReconcileInterval(); interval > 0 {
+		go c.runRelocationReconciler(interval)
+	}
 	return nil
 }
 
@@ -118,6 +234,15 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 		return nil, err
 	}
 
+	if err = c.provisioningWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.provisioningWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+
 	// Volume Size - Default is 10 GiB
 	volSizeBytes := int64(common.DefaultGbDiskSize * common.GbInBytes)
 	if req.GetCapacityRange() != nil && req.GetCapacityRange().RequiredBytes != 0 {
@@ -125,27 +250,113 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 	}
 	volSizeMB := int64(common.RoundUpSize(volSizeBytes, common.MbInBytes))
 
+	// Volumes created from a snapshot or cloned from a volume have the size of the source, and are created on the
+	// vCenter of the source with the ids the source has on that vCenter
+	sourceManager := c.manager
+	snapshotID := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()
+	var cnsSnapshotID string
+	if snapshotID != "" {
+		if sourceManager, cnsSnapshotID, err = c.getSnapshotManager(snapshotID); err != nil {
+			return nil, err
+		}
+		snapshot, err := common.GetSnapshotUtil(ctx, sourceManager, cnsSnapshotID)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get snapshot: %q. Error: %+v", snapshotID, err)
+			klog.Error(msg)
+			return nil, common.ToStatusError(codes.Internal, msg, err)
+		}
+		if snapshot == nil {
+			msg := fmt.Sprintf("Snapshot: %q not found", snapshotID)
+			klog.Error(msg)
+			return nil, status.Error(codes.NotFound, msg)
+		}
+		if err = validateContentSourceCapacity(req.GetCapacityRange(), "snapshot", snapshot.SizeBytes); err != nil {
+			return nil, err
+		}
+		volSizeMB = snapshot.SizeBytes / common.MbInBytes
+	}
+	sourceVolumeID := req.GetVolumeContentSource().GetVolume().GetVolumeId()
+	var cnsSourceVolumeID string
+	if sourceVolumeID != "" {
+		if sourceManager, cnsSourceVolumeID, err = c.getVolumeManager(sourceVolumeID); err != nil {
+			return nil, err
+		}
+		sourceVolume, err := sourceManager.VolumeManager.RefreshVolume(cnsSourceVolumeID)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get source volume: %q. Error: %+v", sourceVolumeID, err)
+			klog.Error(msg)
+			return nil, common.ToStatusError(codes.Internal, msg, err)
+		}
+		if sourceVolume == nil {
+			msg := fmt.Sprintf("Source volume: %q not found", sourceVolumeID)
+			klog.Error(msg)
+			return nil, status.Error(codes.NotFound, msg)
+		}
+		sourceSizeMB := cnsvolume.GetCapacityMB(sourceVolume)
+		if err = validateContentSourceCapacity(req.GetCapacityRange(), "volume", sourceSizeMB*common.MbInBytes); err != nil {
+			return nil, err
+		}
+		volSizeMB = sourceSizeMB
+	}
+
 	var datastoreURL string
+	var datastoreCluster string
 	var storagePolicyName string
 	var fsType string
+	var mkfsOptions string
+	var fsckMode string
 
 	// Support case insensitive parameters
 	for paramName := range req.Parameters {
 		param := strings.ToLower(paramName)
 		if param == common.AttributeDatastoreURL {
 			datastoreURL = req.Parameters[paramName]
+		} else if param == common.AttributeDatastoreCluster {
+			datastoreCluster = req.Parameters[paramName]
 		} else if param == common.AttributeStoragePolicyName {
 			storagePolicyName = req.Parameters[paramName]
 		} else if param == common.AttributeFsType {
 			fsType = req.Parameters[common.AttributeFsType]
+		} else if param == common.AttributeMkfsOptions {
+			mkfsOptions = req.Parameters[paramName]
+		} else if param == common.AttributeFsckMode {
+			fsckMode = req.Parameters[paramName]
+		}
+	}
+
+	if sourceVolumeID != "" {
+		if err = c.validateCloneSource(sourceVolumeID, req.GetVolumeCapabilities(), fsType); err != nil {
+			return nil, err
 		}
 	}
 
+	access, err := c.getDatastoreAccess(req)
+	if err != nil {
+		return nil, err
+	}
+	if access != nil && datastoreURL != "" && !access.isAllowed(datastoreURL) {
+		errMsg := fmt.Sprintf("DatastoreURL: %s specified in the storage class is not allowed for namespace %q",
+			datastoreURL, access.namespace)
+		klog.Errorf(errMsg)
+		return nil, status.Error(codes.PermissionDenied, errMsg)
+	}
+
+	// Filesystems accessed by several nodes are provisioned as vSAN file shares, which nodes mount over NFS
+	fileVolume := common.IsFileVolumeRequest(req.GetVolumeCapabilities())
+	// The disk format is validated by validateVanillaCreateVolumeRequest
+	provisioningType, _ := common.GetProvisioningType(getDiskFormat(req.Parameters))
+	// The changedblocktracking parameter is validated by validateVanillaCreateVolumeRequest
+	changedBlockTracking, _ := getChangedBlockTracking(req.Parameters)
 	var createVolumeSpec = common.CreateVolumeSpec{
-		CapacityMB:        volSizeMB,
-		Name:              req.Name,
-		DatastoreURL:      datastoreURL,
-		StoragePolicyName: storagePolicyName,
+		CapacityMB:           volSizeMB,
+		Name:                 common.GetVolumeName(c.manager.CnsConfig.Global.VolumeNamePrefix, req.Name),
+		DatastoreURL:         datastoreURL,
+		StoragePolicyName:    storagePolicyName,
+		MultiWriter:          !fileVolume && common.IsMultiWriterVolume(req.GetVolumeCapabilities()),
+		FileVolume:           fileVolume,
+		ProvisioningType:     provisioningType,
+		DatastoreCluster:     datastoreCluster,
+		ChangedBlockTracking: changedBlockTracking,
 	}
 	var sharedDatastores []*cnsvsphere.DatastoreInfo
 	var datastoreTopologyMap = make(map[string][]map[string]string)
@@ -154,14 +365,14 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 	topologyRequirement := req.GetAccessibilityRequirements()
 	if topologyRequirement != nil {
 		// Get shared accessible datastores for matching topology requirement
-		if c.manager.CnsConfig.Labels.Zone == "" || c.manager.CnsConfig.Labels.Region == "" {
-			// if zone and region label (vSphere category names) not specified in the config secret, then return
-			// NotFound error.
-			errMsg := fmt.Sprintf("Zone/Region vsphere category names not specified in the vsphere config secret")
+		levels := common.GetTopologyLevels(c.manager.CnsConfig)
+		if len(levels) == 0 {
+			// if the topology tag categories are not specified in the config secret, then return NotFound error.
+			errMsg := fmt.Sprintf("Topology vsphere category names not specified in the vsphere config secret")
 			klog.Errorf(errMsg)
 			return nil, status.Error(codes.NotFound, errMsg)
 		}
-		sharedDatastores, datastoreTopologyMap, err = c.nodeMgr.GetSharedDatastoresInTopology(ctx, topologyRequirement, c.manager.CnsConfig.Labels.Zone, c.manager.CnsConfig.Labels.Region)
+		sharedDatastores, datastoreTopologyMap, err = c.nodeMgr.GetSharedDatastoresInTopology(ctx, topologyRequirement, levels)
 		if err != nil || len(sharedDatastores) == 0 {
 			msg := fmt.Sprintf("Failed to get shared datastores in topology: %+v. Error: %+v", topologyRequirement, err)
 			klog.Errorf(msg)
@@ -194,30 +405,72 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 			return nil, status.Errorf(codes.Internal, msg)
 		}
 	}
-	volumeID, err := common.CreateVolumeUtil(ctx, c.manager, &createVolumeSpec, sharedDatastores)
+	if access != nil {
+		sharedDatastores = access.filter(sharedDatastores)
+		if len(sharedDatastores) == 0 {
+			msg := fmt.Sprintf("No shared datastore is allowed for namespace %q", access.namespace)
+			klog.Error(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+	}
+	// Volumes are placed on the datastores of a single vCenter, the one of the source for snapshots and clones
+	var vcHost string
+	if snapshotID != "" || sourceVolumeID != "" {
+		vcHost = sourceManager.VcenterConfig.Host
+		sharedDatastores = getVirtualCenterDatastores(sharedDatastores, vcHost)
+	} else {
+		vcHost, sharedDatastores = selectVirtualCenter(sharedDatastores, datastoreURL)
+	}
+	manager, err := c.getVirtualCenterManager(vcHost)
+	if err != nil {
+		return nil, err
+	}
+	var volumeID string
+	if snapshotID != "" {
+		volumeID, err = common.CreateVolumeFromSnapshotUtil(ctx, manager, &createVolumeSpec, cnsSnapshotID, sharedDatastores)
+	} else if sourceVolumeID != "" {
+		volumeID, err = common.CloneVolumeUtil(ctx, manager, &createVolumeSpec, cnsSourceVolumeID, sharedDatastores)
+	} else {
+		volumeID, err = common.CreateVolumeUtil(ctx, manager, &createVolumeSpec, sharedDatastores)
+	}
 	if err != nil {
 		msg := fmt.Sprintf("Failed to create volume. Error: %+v", err)
 		klog.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
 	}
 	attributes := make(map[string]string)
-	attributes[common.AttributeDiskType] = common.DiskTypeString
-	attributes[common.AttributeFsType] = fsType
+	if fileVolume {
+		attributes[common.AttributeDiskType] = common.FileDiskTypeString
+		attributes[common.AttributeFsType] = common.NfsV4FsType
+	} else {
+		attributes[common.AttributeDiskType] = common.DiskTypeString
+		attributes[common.AttributeFsType] = fsType
+		if mkfsOptions != "" {
+			attributes[common.AttributeMkfsOptions] = mkfsOptions
+		}
+		if fsckMode != "" {
+			attributes[common.AttributeFsckMode] = fsckMode
+		}
+		if changedBlockTracking {
+			attributes[common.AttributeChangedBlockTracking] = "true"
+		}
+	}
 	resp := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volumeID,
+			VolumeId:      c.encodeVolumeID(manager, volumeID),
 			CapacityBytes: int64(units.FileSize(volSizeMB * common.MbInBytes)),
 			VolumeContext: attributes,
 		},
 	}
-	// Call QueryVolume API and get the datastoreURL of the Provisioned Volume
+	// Call QueryVolume API and get the datastoreURL of the Provisioned Volume.
+	// File volumes are accessible over the network from any node, hence have no accessible topology.
 	var volumeAccessibleTopology = make(map[string]string)
-	if len(datastoreTopologyMap) > 0 {
+	if len(datastoreTopologyMap) > 0 && !fileVolume {
 		volumeIds := []cnstypes.CnsVolumeId{{Id: volumeID}}
 		queryFilter := cnstypes.CnsQueryFilter{
 			VolumeIds: volumeIds,
 		}
-		queryResult, err := c.manager.VolumeManager.QueryVolume(queryFilter)
+		queryResult, err := manager.VolumeManager.QueryVolume(ctx, queryFilter)
 		if err != nil {
 			klog.Errorf("QueryVolume failed for volumeID: %s", volumeID)
 			return nil, status.Error(codes.Internal, err.Error())
@@ -251,15 +504,64 @@ func (c *controller) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequ
 	if err != nil {
 		return nil, err
 	}
-	err = common.DeleteVolumeUtil(ctx, c.manager, req.VolumeId, true)
+
+	if err = c.provisioningWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.provisioningWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityNormal); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, volumeID, err := c.getVolumeManager(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	deleteDisk, err := c.isDeleteDiskEnabled(manager, volumeID)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to delete volume: %q. Error: %+v", req.VolumeId, err)
+		msg := fmt.Sprintf("Failed to determine whether to delete disk for volume: %q. Error: %+v", req.VolumeId, err)
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+	// File volumes are not backed by a First Class Disk, hence are not moved to trash
+	if deleteDisk && c.reclaimMode == common.VolumeReclaimModeTrash && !common.IsFileVolumeID(req.VolumeId) {
+		err = c.recordTrashedVolumeSpec(manager, req.VolumeId, volumeID)
+		if err == nil {
+			err = common.TrashVolumeUtil(ctx, manager, volumeID)
+		}
+	} else {
+		err = common.DeleteVolumeUtil(ctx, manager, volumeID, deleteDisk)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Failed to delete volume: %q. Error: %+v", req.VolumeId, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// isDeleteDiskEnabled returns whether the First Class Disk backing the volume should be deleted in DeleteVolume,
+// based on the reclaim mode of the controller and the AnnDeleteDisk annotation on the PersistentVolume.
+func (c *controller) isDeleteDiskEnabled(manager *common.Manager, volumeID string) (bool, error) {
+	// CNS volume name is the name of the PersistentVolume created by the external provisioner.
+	volume, err := manager.VolumeManager.GetVolume(volumeID)
+	if err != nil {
+		return false, err
+	}
+	if volume == nil {
+		// Volume is already deleted. DeleteVolumeUtil handles this case.
+		return common.IsDeleteDiskEnabled(c.reclaimMode, nil), nil
+	}
+	pv, err := c.k8sClient.CoreV1().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return common.IsDeleteDiskEnabled(c.reclaimMode, nil), nil
+		}
+		return false, err
+	}
+	return common.IsDeleteDiskEnabled(c.reclaimMode, pv.Annotations), nil
+}
+
 // ControllerPublishVolume attaches a volume to the Node VM.
 // volume id and node name is retrieved from ControllerPublishVolumeRequest
 func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (
@@ -272,6 +574,22 @@ func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.Contr
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+
+	if err = c.attachWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.attachWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityNormal); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, volumeID, err := c.getVolumeManager(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if common.IsFileVolumeID(req.VolumeId) {
+		return c.publishFileVolume(manager, volumeID)
+	}
 	node, err := c.nodeMgr.GetNodeByName(req.NodeId)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to find VirtualMachine for node:%q. Error: %v", req.NodeId, err)
@@ -279,11 +597,49 @@ func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.Contr
 		return nil, status.Errorf(codes.Internal, msg)
 	}
 	klog.V(4).Infof("Found VirtualMachine for node:%q.", req.NodeId)
-	diskUUID, err := common.AttachVolumeUtil(ctx, c.manager, node, req.VolumeId)
+	if node.VirtualCenterHost != "" && node.VirtualCenterHost != manager.VcenterConfig.Host {
+		msg := fmt.Sprintf("Volume %q on vCenter %q cannot be attached to node %q on vCenter %q",
+			req.VolumeId, manager.VcenterConfig.Host, req.NodeId, node.VirtualCenterHost)
+		klog.Error(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+	if err = c.verifyVolumeAccessible(ctx, manager, node, req.NodeId, volumeID); err != nil {
+		return nil, err
+	}
+	if err = verifyChangedBlockTracking(ctx, node, req.NodeId, req.VolumeId, req.GetVolumeContext()); err != nil {
+		return nil, err
+	}
+	if c.manager.CnsConfig.Global.HotAddSCSIControllers {
+		err = c.vmLocks.run(ctx, node, func() error {
+			return cnsvolume.EnsurePVSCSISlot(ctx, node)
+		})
+		if err != nil {
+			msg := fmt.Sprintf("Failed to add SCSI controller to node: %q err %+v", req.NodeId, err)
+			klog.Error(msg)
+			return nil, common.ToStatusError(codes.Internal, msg, err)
+		}
+	}
+	var diskUUID string
+	if common.IsMultiWriterVolume([]*csi.VolumeCapability{req.GetVolumeCapability()}) {
+		err = c.vmLocks.run(ctx, node, func() (err error) {
+			diskUUID, err = common.AttachMultiWriterVolumeUtil(ctx, manager, node, volumeID)
+			return err
+		})
+	} else {
+		diskUUID, err = c.attachBatches.attachVolume(ctx, manager, node, volumeID)
+		if isVolumeInUse(err) {
+			// The volume may be left attached to the VM of the node it moved from, e.g. a force deleted node
+			if released, releaseErr := c.releaseStaleAttachment(ctx, manager, req.VolumeId, volumeID, req.NodeId); releaseErr != nil {
+				klog.Warningf("Failed to release stale attachments of volume %q. err: %v", req.VolumeId, releaseErr)
+			} else if released {
+				diskUUID, err = c.attachBatches.attachVolume(ctx, manager, node, volumeID)
+			}
+		}
+	}
 	if err != nil {
 		msg := fmt.Sprintf("Failed to attach disk: %+q with node: %q err %+v", req.VolumeId, req.NodeId, err)
 		klog.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
 	}
 	publishInfo := make(map[string]string)
 	publishInfo[common.AttributeDiskType] = common.DiskTypeString
@@ -294,6 +650,34 @@ func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.Contr
 	return resp, nil
 }
 
+// publishFileVolume returns the NFSv4.1 access point of the file volume for the node to mount it.
+// File volumes are not attached to node VMs.
+func (c *controller) publishFileVolume(manager *common.Manager, volumeID string) (
+	*csi.ControllerPublishVolumeResponse, error) {
+	volume, err := manager.VolumeManager.GetVolume(volumeID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query file volume: %q. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	if volume == nil {
+		return nil, status.Errorf(codes.NotFound, "File volume %q not found", volumeID)
+	}
+	accessPoint := common.GetFileVolumeAccessPoint(volume)
+	if accessPoint == "" {
+		msg := fmt.Sprintf("File volume %q has no %s access point", volumeID, common.Nfsv4AccessPointKey)
+		klog.Error(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+	klog.V(4).Infof("File volume %q is accessible at %s", volumeID, accessPoint)
+	publishInfo := make(map[string]string)
+	publishInfo[common.AttributeDiskType] = common.FileDiskTypeString
+	publishInfo[common.Nfsv4AccessPoint] = accessPoint
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: publishInfo,
+	}, nil
+}
+
 // ControllerUnpublishVolume detaches a volume from the Node VM.
 // volume id and node name is retrieved from ControllerUnpublishVolumeRequest
 func (c *controller) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (
@@ -306,17 +690,43 @@ func (c *controller) ControllerUnpublishVolume(ctx context.Context, req *csi.Con
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+	// File volumes are not attached to node VMs, which unmount them in NodeUnpublishVolume
+	if common.IsFileVolumeID(req.VolumeId) {
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	c.detaches.start(req.NodeId)
+	defer c.detaches.done(req.NodeId)
+	if err = c.attachWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.attachWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityHigh); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
 	node, err := c.nodeMgr.GetNodeByName(req.NodeId)
+	if err == cnsnode.ErrNodeNotFound {
+		// Volumes still attached to the VM of a deleted node are detached before it is unregistered
+		klog.V(2).Infof("Node %q is not registered, volume %q is not attached to it", req.NodeId, req.VolumeId)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
 	if err != nil {
 		msg := fmt.Sprintf("Failed to find VirtualMachine for node:%q. Error: %v", req.NodeId, err)
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
-	err = common.DetachVolumeUtil(ctx, c.manager, node, req.VolumeId)
+	manager, volumeID, err := c.getVolumeManager(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	err = c.vmLocks.run(ctx, node, func() error {
+		return common.DetachVolumeUtil(ctx, manager, node, volumeID)
+	})
 	if err != nil {
 		msg := fmt.Sprintf("Failed to detach disk: %+q from node: %q err %+v", req.VolumeId, req.NodeId, err)
 		klog.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
 	}
 	resp := &csi.ControllerUnpublishVolumeResponse{}
 	return resp, nil
@@ -326,29 +736,251 @@ func (c *controller) ControllerUnpublishVolume(ctx context.Context, req *csi.Con
 func (c *controller) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (
 	*csi.ValidateVolumeCapabilitiesResponse, error) {
 
-	klog.V(4).Infof("ControllerGetCapabilities: called with args %+v", *req)
+	klog.V(4).Infof("ValidateVolumeCapabilities: called with args %+v", *req)
+	if err := common.ValidateVolumeCapabilitiesRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	volumeID := req.GetVolumeId()
+	manager, cnsVolumeID, err := c.getVolumeManager(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(cnsVolumeID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query volume: %q. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	if volume == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+	attached, multiWriter := false, false
+	if volume.VolumeType == common.BlockVolumeType {
+		vStorageObject, err := common.RetrieveVolumeDiskUtil(ctx, manager, volume)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve disk of volume: %q. Error: %+v", volumeID, err)
+			klog.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
+		}
+		attached = len(vStorageObject.Config.ConsumerId) > 0
+		multiWriter = common.IsMultiWriterDisk(vStorageObject)
+	}
 	volCaps := req.GetVolumeCapabilities()
-	var confirmed *csi.ValidateVolumeCapabilitiesResponse_Confirmed
-	if common.IsValidVolumeCapabilities(volCaps) {
-		confirmed = &csi.ValidateVolumeCapabilitiesResponse_Confirmed{VolumeCapabilities: volCaps}
+	if msg := checkVolumeCapabilities(volume.VolumeType, attached, multiWriter, volCaps); msg != "" {
+		klog.V(2).Infof("ValidateVolumeCapabilities: capabilities of volume %q not confirmed: %s", volumeID, msg)
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
 	}
 	return &csi.ValidateVolumeCapabilitiesResponse{
-		Confirmed: confirmed,
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: volCaps,
+			Parameters:         req.GetParameters(),
+		},
 	}, nil
 }
 
+// ListVolumes lists the volumes of the cluster on all vCenters ordered by volume ID, along with the nodes
+// they are attached to according to the VolumeAttachments
 func (c *controller) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 	*csi.ListVolumesResponse, error) {
 
 	klog.V(4).Infof("ListVolumes: called with args %+v", *req)
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.MaxEntries < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Max entries must not be negative")
+	}
+
+	if err := c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	volumes, err := c.queryAllVolumes(ctx)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query volumes. Error: %+v", err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	page, nextToken, ok := pageVolumes(volumes, req.StartingToken, int(req.MaxEntries))
+	if !ok {
+		msg := fmt.Sprintf("Invalid starting token: %q", req.StartingToken)
+		klog.Error(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	publishedNodeIDs, err := c.getPublishedNodeIDs()
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get volumes attached to nodes. Error: %+v", err)
+		klog.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+	resp := &csi.ListVolumesResponse{NextToken: nextToken}
+	for i := range page {
+		volume := &page[i]
+		cnsVolumeID, _ := common.DecodeVolumeID(volume.VolumeId.Id)
+		resp.Entries = append(resp.Entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      volume.VolumeId.Id,
+				CapacityBytes: cnsvolume.GetCapacityMB(volume) * common.MbInBytes,
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: publishedNodeIDs[cnsVolumeID],
+			},
+		})
+	}
+	return resp, nil
 }
 
+// queryAllVolumes returns the volumes of the cluster on all vCenters, with their CSI volume ids
+func (c *controller) queryAllVolumes(ctx context.Context) ([]cnstypes.CnsVolume, error) {
+	var volumes []cnstypes.CnsVolume
+	queryFilter := cnstypes.CnsQueryFilter{
+		ContainerClusterIds: []string{c.manager.CnsConfig.Global.ClusterID},
+	}
+	for _, manager := range c.getManagers() {
+		err := cnsvolume.ForEachVolumePage(ctx, manager.VolumeManager, queryFilter, func(page []cnstypes.CnsVolume) error {
+			for _, volume := range page {
+				volume.VolumeId.Id = c.encodeVolumeID(manager, volume.VolumeId.Id)
+				volumes = append(volumes, volume)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+// ControllerGetVolume returns the volume specified in ControllerGetVolumeRequest along with the nodes whose VMs it
+// is attached to, and its condition
+func (c *controller) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (
+	*csi.ControllerGetVolumeResponse, error) {
+
+	klog.V(4).Infof("ControllerGetVolume: called with args %+v", *req)
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+
+	if err := c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, cnsVolumeID, err := c.getVolumeManager(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	// The volume is queried rather than read from the volume cache, whose accessibility status may be stale
+	queryFilter := cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: cnsVolumeID}},
+	}
+	queryResult, err := manager.VolumeManager.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query volume: %q. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	if len(queryResult.Volumes) == 0 {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+	volume := &queryResult.Volumes[0]
+	condition, err := common.GetVolumeConditionUtil(ctx, manager, volume)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get condition of volume: %q. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+	if condition.Abnormal {
+		klog.Warningf("Volume %q is abnormal: %s", volumeID, condition.Message)
+	}
+	// Only the VolumeAttachments of this volume are looked up, rather than the published nodes of all volumes
+	nodeName, err := c.getVolumeNodeName(volumeID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get node volume: %q is attached to. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+	var publishedNodeIDs []string
+	if nodeName != "" {
+		publishedNodeIDs = []string{nodeName}
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: cnsvolume.GetCapacityMB(volume) * common.MbInBytes,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs,
+			VolumeCondition:  condition,
+		},
+	}, nil
+}
+
+// pageVolumes sorts the volumes by ID and returns the page starting at the volume with the given ID, and the ID
+// of the first volume of the next page if there is one. It returns false if the starting volume is not found.
+func pageVolumes(volumes []cnstypes.CnsVolume, startingToken string, maxEntries int) ([]cnstypes.CnsVolume, string, bool) {
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].VolumeId.Id < volumes[j].VolumeId.Id
+	})
+	start := 0
+	if startingToken != "" {
+		start = sort.Search(len(volumes), func(i int) bool {
+			return volumes[i].VolumeId.Id >= startingToken
+		})
+		if start == len(volumes) || volumes[start].VolumeId.Id != startingToken {
+			return nil, "", false
+		}
+	}
+	end := len(volumes)
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+		return volumes[start:end], volumes[end].VolumeId.Id, true
+	}
+	return volumes[start:end], "", true
+}
+
+// GetCapacity returns the free space of the datastores shared by the nodes in the topology segment specified in
+// GetCapacityRequest, or by all nodes of the cluster if none is specified
 func (c *controller) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (
 	*csi.GetCapacityResponse, error) {
 
 	klog.V(4).Infof("GetCapacity: called with args %+v", *req)
-	return nil, status.Error(codes.Unimplemented, "")
+	var datastoreURL string
+	for paramName, value := range req.Parameters {
+		if strings.ToLower(paramName) == common.AttributeDatastoreURL {
+			datastoreURL = value
+		}
+	}
+
+	if err := c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	var sharedDatastores []*cnsvsphere.DatastoreInfo
+	var err error
+	if topology := req.GetAccessibleTopology(); topology != nil {
+		levels := common.GetTopologyLevels(c.manager.CnsConfig)
+		if len(levels) == 0 {
+			errMsg := "Topology vsphere category names not specified in the vsphere config secret"
+			klog.Errorf(errMsg)
+			return nil, status.Error(codes.InvalidArgument, errMsg)
+		}
+		topologyRequirement := &csi.TopologyRequirement{Requisite: []*csi.Topology{topology}}
+		sharedDatastores, _, err = c.nodeMgr.GetSharedDatastoresInTopology(ctx, topologyRequirement, levels)
+	} else {
+		sharedDatastores, err = c.nodeMgr.GetSharedDatastoresInK8SCluster(ctx)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get shared datastores in topology: %+v. Error: %+v", req.GetAccessibleTopology(), err)
+		klog.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+	capacity := getAvailableCapacity(sharedDatastores, datastoreURL)
+	klog.V(4).Infof("GetCapacity: %d bytes available in topology: %+v", capacity, req.GetAccessibleTopology())
+	return &csi.GetCapacityResponse{AvailableCapacity: capacity}, nil
 }
 
 func (c *controller) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (
@@ -369,23 +1001,261 @@ func (c *controller) ControllerGetCapabilities(ctx context.Context, req *csi.Con
 	return &csi.ControllerGetCapabilitiesResponse{Capabilities: caps}, nil
 }
 
+// ControllerExpandVolume extends the First Class Disk backing the volume specified in ControllerExpandVolumeRequest.
+// Volumes attached to a node are extended online through the node VM. The filesystem is grown by NodeExpandVolume.
+// FailedPrecondition is returned if the datastore of the volume cannot grow it, or is not mounted on the host of
+// the node the volume is attached to.
+func (c *controller) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (
+	*csi.ControllerExpandVolumeResponse, error) {
+
+	klog.V(4).Infof("ControllerExpandVolume: called with args %+v", *req)
+	err := common.ValidateControllerExpandVolumeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if common.IsFileVolumeID(req.VolumeId) {
+		msg := fmt.Sprintf("Expanding file volume: %q is not supported", req.VolumeId)
+		klog.Error(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if err = c.vcenterWorkers.acquire(ctx, priorityNormal); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, volumeID, err := c.getVolumeManager(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query volume: %q. Error: %+v", req.VolumeId, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	if volume == nil {
+		msg := fmt.Sprintf("Volume: %q not found", req.VolumeId)
+		klog.Error(msg)
+		return nil, status.Error(codes.NotFound, msg)
+	}
+	volSizeMB := common.RoundUpSize(req.GetCapacityRange().GetRequiredBytes(), common.MbInBytes)
+	limitBytes := req.GetCapacityRange().GetLimitBytes()
+	if limitBytes != 0 && volSizeMB*common.MbInBytes > limitBytes {
+		msg := fmt.Sprintf("Volume: %q cannot be expanded to %d MB above the capacity limit %d bytes", req.VolumeId, volSizeMB, limitBytes)
+		klog.Error(msg)
+		return nil, status.Error(codes.OutOfRange, msg)
+	}
+	if cnsvolume.GetCapacityMB(volume) < volSizeMB {
+		if err = verifyVolumeExpandable(ctx, manager, volume, volSizeMB); err != nil {
+			return nil, err
+		}
+	}
+	capacityMB, err := common.ExtendVolumeUtil(ctx, manager, volume, volSizeMB)
+	if err == common.ErrVolumeInUse {
+		// First Class Disks cannot be extended while attached, the disk is extended through the node VM instead
+		var vm *cnsvsphere.VirtualMachine
+		var nodeName string
+		vm, nodeName, err = c.getAttachedNode(req.VolumeId)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get node volume: %q is attached to. Error: %+v", req.VolumeId, err)
+			klog.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
+		}
+		if vm == nil {
+			msg := fmt.Sprintf("Volume: %q is in use but not attached to a node of the cluster", req.VolumeId)
+			klog.Error(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+		if err = c.verifyVolumeAccessible(ctx, manager, vm, nodeName, volumeID); err != nil {
+			return nil, err
+		}
+		klog.V(2).Infof("Volume: %q is attached to node %q, extending it online", req.VolumeId, nodeName)
+		err = c.vmLocks.run(ctx, vm, func() error {
+			return common.ExtendAttachedVolumeUtil(ctx, manager, vm, volumeID, volSizeMB)
+		})
+		capacityMB = volSizeMB
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Failed to expand volume: %q to %d MB. Error: %+v", req.VolumeId, volSizeMB, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	capacityBytes := capacityMB * common.MbInBytes
+	// The volume is left as is if it is already above the requested capacity, which may exceed the limit
+	if limitBytes != 0 && capacityBytes > limitBytes {
+		msg := fmt.Sprintf("Volume: %q has capacity %d bytes above the capacity limit %d bytes", req.VolumeId, capacityBytes, limitBytes)
+		klog.Error(msg)
+		return nil, status.Error(codes.OutOfRange, msg)
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes: capacityBytes,
+		// Raw block volumes have no filesystem to grow
+		NodeExpansionRequired: req.GetVolumeCapability().GetBlock() == nil,
+	}, nil
+}
+
+// CreateSnapshot snapshots the First Class Disk backing the volume specified in CreateSnapshotRequest
 func (c *controller) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (
 	*csi.CreateSnapshotResponse, error) {
 
 	klog.V(4).Infof("CreateSnapshot: called with args %+v", *req)
-	return nil, status.Error(codes.Unimplemented, "")
+	err := common.ValidateCreateSnapshotRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if common.IsFileVolumeID(req.SourceVolumeId) {
+		msg := fmt.Sprintf("Snapshotting file volume: %q is not supported", req.SourceVolumeId)
+		klog.Error(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	changedBlockTracking, err := getChangedBlockTracking(req.Parameters)
+	if err != nil {
+		klog.Error(err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = c.provisioningWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.provisioningWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, volumeID, err := c.getVolumeManager(req.SourceVolumeId)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query volume: %q. Error: %+v", req.SourceVolumeId, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	if volume == nil {
+		msg := fmt.Sprintf("Volume: %q not found", req.SourceVolumeId)
+		klog.Error(msg)
+		return nil, status.Error(codes.NotFound, msg)
+	}
+	snapshot, err := common.CreateSnapshotUtil(ctx, manager, volume, req.Name, changedBlockTracking)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to create snapshot %q of volume: %q. Error: %+v", req.Name, req.SourceVolumeId, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	c.encodeSnapshot(manager, snapshot)
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
 }
 
+// DeleteSnapshot deletes the First Class Disk snapshot specified in DeleteSnapshotRequest
 func (c *controller) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (
 	*csi.DeleteSnapshotResponse, error) {
 
 	klog.V(4).Infof("DeleteSnapshot: called with args %+v", *req)
-	return nil, status.Error(codes.Unimplemented, "")
+	err := common.ValidateDeleteSnapshotRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.provisioningWorkers.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.provisioningWorkers.release()
+	if err = c.vcenterWorkers.acquire(ctx, priorityNormal); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	manager, snapshotID, err := c.getSnapshotManager(req.SnapshotId)
+	if err != nil {
+		return nil, err
+	}
+	if err = common.DeleteSnapshotUtil(ctx, manager, snapshotID); err != nil {
+		msg := fmt.Sprintf("Failed to delete snapshot: %q. Error: %+v", req.SnapshotId, err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
+// ListSnapshots lists the First Class Disk snapshots of the volumes of the cluster, or of the volume
+// or snapshot specified in ListSnapshotsRequest
 func (c *controller) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (
 	*csi.ListSnapshotsResponse, error) {
 
 	klog.V(4).Infof("ListSnapshots: called with args %+v", *req)
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.StartingToken != "" {
+		if _, _, ok := common.ParseSnapshotID(req.StartingToken); !ok {
+			msg := fmt.Sprintf("Invalid starting token: %q", req.StartingToken)
+			klog.Error(msg)
+			return nil, status.Error(codes.Aborted, msg)
+		}
+	}
+	if req.MaxEntries < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Max entries must not be negative")
+	}
+
+	if err := c.vcenterWorkers.acquire(ctx, priorityLow); err != nil {
+		return nil, err
+	}
+	defer c.vcenterWorkers.release()
+	volumeID := req.SourceVolumeId
+	if req.SnapshotId != "" {
+		snapshotVolumeID, _, ok := common.ParseSnapshotID(req.SnapshotId)
+		if !ok || (volumeID != "" && volumeID != snapshotVolumeID) {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		volumeID = snapshotVolumeID
+	}
+	// Volumes are listed with their CSI volume ids, so that the snapshots are listed with their CSI snapshot ids
+	var volumes []cnstypes.CnsVolume
+	if volumeID != "" {
+		manager, cnsVolumeID, err := c.getVolumeManager(volumeID)
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		volume, err := manager.VolumeManager.RefreshVolume(cnsVolumeID)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to query volume: %q. Error: %+v", volumeID, err)
+			klog.Error(msg)
+			return nil, common.ToStatusError(codes.Internal, msg, err)
+		}
+		if volume == nil || volume.VolumeType != common.BlockVolumeType {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		listedVolume := *volume
+		listedVolume.VolumeId.Id = volumeID
+		volumes = append(volumes, listedVolume)
+	} else {
+		allVolumes, err := c.queryAllVolumes(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to query volumes. Error: %+v", err)
+			klog.Error(msg)
+			return nil, common.ToStatusError(codes.Internal, msg, err)
+		}
+		// Only volumes backed by a First Class Disk have snapshots
+		for _, volume := range allVolumes {
+			if volume.VolumeType == common.BlockVolumeType {
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+	startingToken, maxEntries := req.StartingToken, int(req.MaxEntries)
+	if req.SnapshotId != "" {
+		// The snapshot is filtered out of all snapshots of its volume
+		startingToken, maxEntries = "", 0
+	}
+	snapshots, nextToken, err := common.ListSnapshotsUtil(ctx, c.getVolumeManager, volumes, startingToken, maxEntries)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to list snapshots. Error: %+v", err)
+		klog.Error(msg)
+		return nil, common.ToStatusError(codes.Internal, msg, err)
+	}
+	resp := &csi.ListSnapshotsResponse{NextToken: nextToken}
+	for _, snapshot := range snapshots {
+		if req.SnapshotId != "" && snapshot.SnapshotId != req.SnapshotId {
+			continue
+		}
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+	return resp, nil
 }
@@ -17,13 +17,18 @@ limitations under the License.
 package cns
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/types"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog"
 
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 )
 
@@ -35,14 +40,299 @@ func validateVanillaCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	params := req.GetParameters()
 	for paramName := range params {
 		paramName = strings.ToLower(paramName)
-		if paramName != common.AttributeDatastoreURL && paramName != common.AttributeStoragePolicyName && paramName != common.AttributeFsType {
+		if paramName != common.AttributeDatastoreURL && paramName != common.AttributeStoragePolicyName && paramName != common.AttributeFsType &&
+			paramName != common.AttributeDiskFormat && paramName != common.AttributeDatastoreCluster &&
+			paramName != common.AttributeMkfsOptions && paramName != common.AttributeFsckMode &&
+			paramName != common.AttributeChangedBlockTracking &&
+			!strings.HasPrefix(paramName, common.CreateMetadataPrefix) {
 			msg := fmt.Sprintf("Volume parameter %s is not a valid Vanilla CSI parameter.", paramName)
 			return status.Error(codes.InvalidArgument, msg)
 		}
 	}
+	if err := validateDiskFormat(req); err != nil {
+		return err
+	}
+	if err := validateDatastoreCluster(req); err != nil {
+		return err
+	}
+	if err := validateFsType(req); err != nil {
+		return err
+	}
+	if err := validateChangedBlockTracking(req); err != nil {
+		return err
+	}
+	if common.IsFileVolumeRequest(req.GetVolumeCapabilities()) {
+		if req.GetVolumeContentSource() != nil {
+			msg := "File volumes cannot be created from a snapshot or a volume."
+			return status.Error(codes.InvalidArgument, msg)
+		}
+		for _, volCap := range req.GetVolumeCapabilities() {
+			if volCap.GetBlock() != nil {
+				msg := "File volumes cannot be accessed as raw block volumes."
+				return status.Error(codes.InvalidArgument, msg)
+			}
+		}
+	}
+	if common.IsFileVolumeID(req.GetVolumeContentSource().GetVolume().GetVolumeId()) {
+		msg := "Cloning file volumes is not supported."
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	// Snapshots and clones are thin provisioned, hence cannot be shared by several nodes
+	if common.IsMultiWriterVolume(req.GetVolumeCapabilities()) && req.GetVolumeContentSource() != nil {
+		msg := "Multi-writer volumes cannot be created from a snapshot or a volume."
+		return status.Error(codes.InvalidArgument, msg)
+	}
 	return common.ValidateCreateVolumeRequest(req)
 }
 
+// validateDiskFormat returns an InvalidArgument error if the diskformat parameter of the request is not supported,
+// or cannot be combined with the capabilities or the content source of the request.
+func validateDiskFormat(req *csi.CreateVolumeRequest) error {
+	diskFormat := getDiskFormat(req.GetParameters())
+	if diskFormat == "" {
+		return nil
+	}
+	provisioningType, ok := common.GetProvisioningType(diskFormat)
+	if !ok {
+		msg := fmt.Sprintf("Disk format %q is not supported. Supported disk formats are thin, zeroedthick and eagerzeroedthick.", diskFormat)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if common.IsFileVolumeRequest(req.GetVolumeCapabilities()) {
+		msg := fmt.Sprintf("Disk format %q is not supported for file volumes.", diskFormat)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if req.GetVolumeContentSource() != nil {
+		msg := fmt.Sprintf("Disk format %q is not supported for volumes created from a snapshot or a volume.", diskFormat)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if common.IsMultiWriterVolume(req.GetVolumeCapabilities()) &&
+		provisioningType != string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick) {
+		msg := fmt.Sprintf("Disk format %q is not supported for multi-writer volumes, which are eagerzeroedthick.", diskFormat)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// getDiskFormat returns the case insensitive diskformat parameter, or "" if it is not set
+func getDiskFormat(params map[string]string) string {
+	for paramName, value := range params {
+		if strings.ToLower(paramName) == common.AttributeDiskFormat {
+			return value
+		}
+	}
+	return ""
+}
+
+// validateDatastoreCluster returns an InvalidArgument error if the datastorecluster parameter of the request is
+// set along with the datastoreurl parameter, or for a volume Storage DRS cannot place.
+func validateDatastoreCluster(req *csi.CreateVolumeRequest) error {
+	var datastoreCluster, datastoreURL string
+	for paramName, value := range req.GetParameters() {
+		switch strings.ToLower(paramName) {
+		case common.AttributeDatastoreCluster:
+			datastoreCluster = value
+		case common.AttributeDatastoreURL:
+			datastoreURL = value
+		}
+	}
+	if datastoreCluster == "" {
+		return nil
+	}
+	if datastoreURL != "" {
+		msg := fmt.Sprintf("Parameters %s and %s are mutually exclusive.", common.AttributeDatastoreCluster, common.AttributeDatastoreURL)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if common.IsFileVolumeRequest(req.GetVolumeCapabilities()) {
+		msg := fmt.Sprintf("Datastore cluster %q is not supported for file volumes, which are placed on vSAN datastores.", datastoreCluster)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	// Volumes created from a snapshot are restored on the datastore of the snapshot
+	if req.GetVolumeContentSource().GetSnapshot() != nil {
+		msg := fmt.Sprintf("Datastore cluster %q is not supported for volumes created from a snapshot.", datastoreCluster)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// validateFsType returns an InvalidArgument error if the filesystem type of the request, from the fstype parameter
+// or from its mount capabilities, is not supported for block volumes, if the fsckmode parameter is not supported,
+// or if mkfs options or an fsck mode are set for a file volume.
+func validateFsType(req *csi.CreateVolumeRequest) error {
+	var fsType, mkfsOptions, fsckMode string
+	for paramName, value := range req.GetParameters() {
+		switch strings.ToLower(paramName) {
+		case common.AttributeFsType:
+			fsType = value
+		case common.AttributeMkfsOptions:
+			mkfsOptions = value
+		case common.AttributeFsckMode:
+			fsckMode = value
+		}
+	}
+	if fsckMode != "" && !common.IsSupportedFsckMode(fsckMode) {
+		msg := fmt.Sprintf("Fsck mode %q is not supported. Supported fsck modes are fail, repair and skip.", fsckMode)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	// File volumes are mounted over NFS, whatever the filesystem type
+	if common.IsFileVolumeRequest(req.GetVolumeCapabilities()) {
+		if mkfsOptions != "" {
+			msg := "Mkfs options are not supported for file volumes."
+			return status.Error(codes.InvalidArgument, msg)
+		}
+		if fsckMode != "" {
+			msg := "Fsck modes are not supported for file volumes."
+			return status.Error(codes.InvalidArgument, msg)
+		}
+		return nil
+	}
+	fsTypes := []string{fsType}
+	for _, volCap := range req.GetVolumeCapabilities() {
+		fsTypes = append(fsTypes, volCap.GetMount().GetFsType())
+	}
+	for _, fsType := range fsTypes {
+		if fsType != "" && !common.IsSupportedFsType(fsType) {
+			msg := fmt.Sprintf("Filesystem type %q is not supported. Supported filesystem types are ext4, ext3, xfs and btrfs.", fsType)
+			return status.Error(codes.InvalidArgument, msg)
+		}
+	}
+	return nil
+}
+
+// validateChangedBlockTracking returns an InvalidArgument error if the changedblocktracking parameter of the request
+// is not a boolean, or enables Changed Block Tracking for a file volume, which has no First Class Disk.
+func validateChangedBlockTracking(req *csi.CreateVolumeRequest) error {
+	enabled, err := getChangedBlockTracking(req.GetParameters())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if enabled && common.IsFileVolumeRequest(req.GetVolumeCapabilities()) {
+		msg := "Changed Block Tracking is not supported for file volumes."
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// getChangedBlockTracking returns whether the case insensitive changedblocktracking parameter enables
+// Changed Block Tracking, false if it is not set, or an error if it is not a boolean
+func getChangedBlockTracking(params map[string]string) (bool, error) {
+	for paramName, value := range params {
+		if strings.ToLower(paramName) != common.AttributeChangedBlockTracking {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("parameter %s value %q is not a boolean", common.AttributeChangedBlockTracking, value)
+		}
+		return enabled, nil
+	}
+	return false, nil
+}
+
+// verifyChangedBlockTracking returns a FailedPrecondition error if the volume with the given context has Changed
+// Block Tracking enabled and the node VM has a hardware version which does not support it.
+func verifyChangedBlockTracking(ctx context.Context, vm *cnsvsphere.VirtualMachine, nodeName string, volumeID string,
+	volumeContext map[string]string) error {
+	if enabled, _ := strconv.ParseBool(volumeContext[common.AttributeChangedBlockTracking]); !enabled {
+		return nil
+	}
+	version, err := vm.GetHardwareVersion(ctx)
+	if err != nil {
+		klog.Warningf("Failed to get hardware version of node %q to verify it supports Changed Block Tracking of volume %q. err=%v",
+			nodeName, volumeID, err)
+		return nil
+	}
+	if version < common.MinChangedBlockTrackingHardwareVersion {
+		msg := fmt.Sprintf("Volume %q has Changed Block Tracking enabled, which needs hardware version %d or later, "+
+			"but node %q has hardware version %d", volumeID, common.MinChangedBlockTrackingHardwareVersion, nodeName, version)
+		klog.Error(msg)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	return nil
+}
+
+// validateContentSourceCapacity returns an OutOfRange error if the capacity range does not allow a volume of the
+// size of the content source, as volumes created from a snapshot or cloned from a volume have the size of the source.
+func validateContentSourceCapacity(capacityRange *csi.CapacityRange, source string, sourceSizeBytes int64) error {
+	if requiredBytes := capacityRange.GetRequiredBytes(); requiredBytes > sourceSizeBytes {
+		msg := fmt.Sprintf("Required capacity %d bytes is larger than the %s size %d bytes. "+
+			"Expanding volumes created from a %s is not supported.", requiredBytes, source, sourceSizeBytes, source)
+		return status.Error(codes.OutOfRange, msg)
+	}
+	if limitBytes := capacityRange.GetLimitBytes(); limitBytes != 0 && limitBytes < sourceSizeBytes {
+		msg := fmt.Sprintf("Capacity limit %d bytes is smaller than the %s size %d bytes.", limitBytes, source, sourceSizeBytes)
+		return status.Error(codes.OutOfRange, msg)
+	}
+	return nil
+}
+
+// getAvailableCapacity returns the total free space in bytes of the given datastores, counting each datastore once,
+// or the free space of the datastore with the given URL if it is not empty
+func getAvailableCapacity(datastores []*cnsvsphere.DatastoreInfo, datastoreURL string) int64 {
+	var capacity int64
+	counted := make(map[string]bool)
+	for _, datastore := range datastores {
+		url := datastore.Info.Url
+		if counted[url] || (datastoreURL != "" && url != datastoreURL) {
+			continue
+		}
+		counted[url] = true
+		capacity += datastore.Info.FreeSpace
+	}
+	return capacity
+}
+
+// checkVolumeCapabilities returns why the capabilities are not supported by a volume of the given CNS volume type,
+// attached to a node VM or not and provisioned for multi-writer access or not, or an empty string if they are all
+// supported
+func checkVolumeCapabilities(volumeType string, attached bool, multiWriter bool, volCaps []*csi.VolumeCapability) string {
+	if volumeType == common.FileVolumeType {
+		for _, volCap := range volCaps {
+			if volCap.GetMount() == nil {
+				return "File volumes can only be accessed as mounted filesystems"
+			}
+			if err := common.ValidateMountFlags(volCap.GetMount().GetMountFlags()); err != nil {
+				return fmt.Sprintf("Volume capability mount flags are not supported: %v", err)
+			}
+		}
+		return ""
+	}
+	if volumeType != common.BlockVolumeType {
+		return fmt.Sprintf("Volume type %q is not supported", volumeType)
+	}
+	for _, volCap := range volCaps {
+		if volCap.GetBlock() == nil && volCap.GetMount() == nil {
+			return "Volume capability access type is required"
+		}
+		if err := common.ValidateMountFlags(volCap.GetMount().GetMountFlags()); err != nil {
+			return fmt.Sprintf("Volume capability mount flags are not supported: %v", err)
+		}
+		mode := volCap.GetAccessMode().GetMode()
+		if volCap.GetMount() != nil && isMultiNodeAccessMode(mode) {
+			return fmt.Sprintf("Access mode %s is not supported by mounted volume type %s", mode, volumeType)
+		}
+		if mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER && volCap.GetBlock() != nil && !multiWriter {
+			return fmt.Sprintf("Access mode %s is not supported by volume not provisioned for multi-writer access", mode)
+		}
+		if attached && !multiWriter && isMultiNodeAccessMode(mode) {
+			return fmt.Sprintf("Access mode %s is not supported by volume attached to a node", mode)
+		}
+		if !common.IsValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+			return fmt.Sprintf("Access mode %s is not supported by volume type %s", mode, volumeType)
+		}
+	}
+	return ""
+}
+
+func isMultiNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
 // validateVanillaDeleteVolumeRequest is the helper function to validate
 // DeleteVolumeRequest for Vanilla CSI driver.
 // Function returns error if validation fails otherwise returns nil.
@@ -0,0 +1,366 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestValidateContentSourceCapacity(t *testing.T) {
+	snapshotSizeBytes := 2 * common.GbInBytes
+	tests := []struct {
+		name          string
+		capacityRange *csi.CapacityRange
+		expected      codes.Code
+	}{
+		{"no capacity range", nil, codes.OK},
+		{"snapshot size required", &csi.CapacityRange{RequiredBytes: snapshotSizeBytes}, codes.OK},
+		{"less than snapshot size required", &csi.CapacityRange{RequiredBytes: common.GbInBytes}, codes.OK},
+		{"more than snapshot size required", &csi.CapacityRange{RequiredBytes: 3 * common.GbInBytes}, codes.OutOfRange},
+		{"limit above snapshot size", &csi.CapacityRange{LimitBytes: 3 * common.GbInBytes}, codes.OK},
+		{"limit below snapshot size", &csi.CapacityRange{LimitBytes: common.GbInBytes}, codes.OutOfRange},
+	}
+	for _, test := range tests {
+		for _, source := range []string{"snapshot", "volume"} {
+			err := validateContentSourceCapacity(test.capacityRange, source, snapshotSizeBytes)
+			if code := status.Code(err); code != test.expected {
+				t.Errorf("%s: validateContentSourceCapacity() of %s returned %v, expected code %v", test.name, source, err, test.expected)
+			}
+		}
+	}
+}
+
+func TestGetAvailableCapacity(t *testing.T) {
+	newDatastoreInfo := func(url string, freeSpace int64) *cnsvsphere.DatastoreInfo {
+		return &cnsvsphere.DatastoreInfo{Info: &types.DatastoreInfo{Url: url, FreeSpace: freeSpace}}
+	}
+	datastores := []*cnsvsphere.DatastoreInfo{
+		newDatastoreInfo("ds:///vmfs/volumes/ds-1/", 10*common.GbInBytes),
+		newDatastoreInfo("ds:///vmfs/volumes/ds-2/", 5*common.GbInBytes),
+		newDatastoreInfo("ds:///vmfs/volumes/ds-1/", 10*common.GbInBytes),
+	}
+	tests := []struct {
+		name         string
+		datastoreURL string
+		expected     int64
+	}{
+		{name: "all datastores", expected: 15 * common.GbInBytes},
+		{name: "datastore of storage class", datastoreURL: "ds:///vmfs/volumes/ds-2/", expected: 5 * common.GbInBytes},
+		{name: "datastore not shared", datastoreURL: "ds:///vmfs/volumes/ds-3/", expected: 0},
+	}
+	for _, test := range tests {
+		if capacity := getAvailableCapacity(datastores, test.datastoreURL); capacity != test.expected {
+			t.Errorf("%s: getAvailableCapacity() = %d, expected %d", test.name, capacity, test.expected)
+		}
+	}
+}
+
+func TestValidateFileVolumeCreateRequest(t *testing.T) {
+	fileCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	snapshotSource := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "volume+snapshot"}}}
+	fileVolumeSource := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: common.FileVolumePrefix + "volume"}}}
+	tests := []struct {
+		name     string
+		caps     []*csi.VolumeCapability
+		source   *csi.VolumeContentSource
+		expected codes.Code
+	}{
+		{name: "file volume", caps: []*csi.VolumeCapability{fileCap}, expected: codes.OK},
+		{name: "file volume from snapshot", caps: []*csi.VolumeCapability{fileCap}, source: snapshotSource, expected: codes.InvalidArgument},
+		{name: "file volume accessed as block", caps: []*csi.VolumeCapability{fileCap, blockCap}, expected: codes.InvalidArgument},
+		{name: "clone of file volume", caps: []*csi.VolumeCapability{blockCap}, source: fileVolumeSource, expected: codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		req := &csi.CreateVolumeRequest{Name: "pvc-1", VolumeCapabilities: test.caps, VolumeContentSource: test.source}
+		if code := status.Code(validateVanillaCreateVolumeRequest(req)); code != test.expected {
+			t.Errorf("%s: validateVanillaCreateVolumeRequest() returned code %v, expected %v", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestValidateDiskFormat(t *testing.T) {
+	newCap := func(block bool, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		volCap := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+		if block {
+			volCap.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			volCap.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+		}
+		return volCap
+	}
+	singleWriter := newCap(false, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	sharedBlock := newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	file := newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	source := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "volume"}}}
+	tests := []struct {
+		name       string
+		diskFormat string
+		volCap     *csi.VolumeCapability
+		source     *csi.VolumeContentSource
+		expected   codes.Code
+	}{
+		{name: "no disk format", volCap: file, source: source, expected: codes.OK},
+		{name: "thin", diskFormat: "thin", volCap: singleWriter, expected: codes.OK},
+		{name: "zeroedthick", diskFormat: "ZeroedThick", volCap: singleWriter, expected: codes.OK},
+		{name: "unsupported", diskFormat: "thick", volCap: singleWriter, expected: codes.InvalidArgument},
+		{name: "file volume", diskFormat: "thin", volCap: file, expected: codes.InvalidArgument},
+		{name: "content source", diskFormat: "thin", volCap: singleWriter, source: source, expected: codes.InvalidArgument},
+		{name: "multi-writer eagerzeroedthick", diskFormat: "eagerzeroedthick", volCap: sharedBlock, expected: codes.OK},
+		{name: "multi-writer thin", diskFormat: "thin", volCap: sharedBlock, expected: codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		req := &csi.CreateVolumeRequest{
+			Name:                "pvc-1",
+			VolumeCapabilities:  []*csi.VolumeCapability{test.volCap},
+			VolumeContentSource: test.source,
+		}
+		if test.diskFormat != "" {
+			req.Parameters = map[string]string{"DiskFormat": test.diskFormat}
+		}
+		if code := status.Code(validateDiskFormat(req)); code != test.expected {
+			t.Errorf("%s: validateDiskFormat() returned code %v, expected %v", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestValidateDatastoreCluster(t *testing.T) {
+	singleWriter := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	file := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	clone := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "volume"}}}
+	restore := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot"}}}
+	tests := []struct {
+		name     string
+		params   map[string]string
+		volCap   *csi.VolumeCapability
+		source   *csi.VolumeContentSource
+		expected codes.Code
+	}{
+		{name: "no datastore cluster", params: map[string]string{common.AttributeDatastoreURL: "ds:///vmfs/volumes/ds1/"}, volCap: file, expected: codes.OK},
+		{name: "datastore cluster", params: map[string]string{"DatastoreCluster": "pod1"}, volCap: singleWriter, expected: codes.OK},
+		{name: "clone", params: map[string]string{"DatastoreCluster": "pod1"}, volCap: singleWriter, source: clone, expected: codes.OK},
+		{name: "datastore url", params: map[string]string{"DatastoreCluster": "pod1", "DatastoreURL": "ds:///vmfs/volumes/ds1/"},
+			volCap: singleWriter, expected: codes.InvalidArgument},
+		{name: "file volume", params: map[string]string{"DatastoreCluster": "pod1"}, volCap: file, expected: codes.InvalidArgument},
+		{name: "snapshot", params: map[string]string{"DatastoreCluster": "pod1"}, volCap: singleWriter, source: restore, expected: codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		req := &csi.CreateVolumeRequest{
+			Name:                "pvc-1",
+			Parameters:          test.params,
+			VolumeCapabilities:  []*csi.VolumeCapability{test.volCap},
+			VolumeContentSource: test.source,
+		}
+		if code := status.Code(validateDatastoreCluster(req)); code != test.expected {
+			t.Errorf("%s: validateDatastoreCluster() returned code %v, expected %v", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestValidateFsType(t *testing.T) {
+	newCap := func(fsType string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	singleWriter := newCap("", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	file := newCap("nfs4", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	tests := []struct {
+		name     string
+		params   map[string]string
+		volCap   *csi.VolumeCapability
+		expected codes.Code
+	}{
+		{name: "default", volCap: singleWriter, expected: codes.OK},
+		{name: "xfs with mkfs options", params: map[string]string{"FsType": "xfs", "MkfsOptions": "-i size=512"}, volCap: singleWriter, expected: codes.OK},
+		{name: "btrfs capability", volCap: newCap("btrfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), expected: codes.OK},
+		{name: "unsupported", params: map[string]string{"fstype": "zfs"}, volCap: singleWriter, expected: codes.InvalidArgument},
+		{name: "unsupported capability", volCap: newCap("vfat", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), expected: codes.InvalidArgument},
+		{name: "file volume", volCap: file, expected: codes.OK},
+		{name: "file volume with mkfs options", params: map[string]string{"mkfsoptions": "-m 0"}, volCap: file, expected: codes.InvalidArgument},
+		{name: "fsck mode", params: map[string]string{"FsckMode": "repair"}, volCap: singleWriter, expected: codes.OK},
+		{name: "unsupported fsck mode", params: map[string]string{"fsckmode": "ignore"}, volCap: singleWriter, expected: codes.InvalidArgument},
+		{name: "file volume with fsck mode", params: map[string]string{"fsckmode": "fail"}, volCap: file, expected: codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		req := &csi.CreateVolumeRequest{
+			Name:               "pvc-1",
+			Parameters:         test.params,
+			VolumeCapabilities: []*csi.VolumeCapability{test.volCap},
+		}
+		if code := status.Code(validateFsType(req)); code != test.expected {
+			t.Errorf("%s: validateFsType() returned code %v, expected %v", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestValidateChangedBlockTracking(t *testing.T) {
+	singleWriter := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	file := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "nfs4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	tests := []struct {
+		name     string
+		params   map[string]string
+		volCap   *csi.VolumeCapability
+		enabled  bool
+		expected codes.Code
+	}{
+		{name: "default", volCap: singleWriter, expected: codes.OK},
+		{name: "enabled", params: map[string]string{"changedBlockTracking": "true"}, volCap: singleWriter, enabled: true, expected: codes.OK},
+		{name: "disabled", params: map[string]string{"changedblocktracking": "false"}, volCap: singleWriter, expected: codes.OK},
+		{name: "not a boolean", params: map[string]string{"changedblocktracking": "yes"}, volCap: singleWriter, expected: codes.InvalidArgument},
+		{name: "file volume", params: map[string]string{"changedblocktracking": "true"}, volCap: file, enabled: true, expected: codes.InvalidArgument},
+		{name: "file volume disabled", params: map[string]string{"changedblocktracking": "false"}, volCap: file, expected: codes.OK},
+	}
+	for _, test := range tests {
+		req := &csi.CreateVolumeRequest{
+			Name:               "pvc-1",
+			Parameters:         test.params,
+			VolumeCapabilities: []*csi.VolumeCapability{test.volCap},
+		}
+		if code := status.Code(validateChangedBlockTracking(req)); code != test.expected {
+			t.Errorf("%s: validateChangedBlockTracking() returned code %v, expected %v", test.name, code, test.expected)
+		}
+		if enabled, _ := getChangedBlockTracking(test.params); enabled != test.enabled {
+			t.Errorf("%s: getChangedBlockTracking() = %v, expected %v", test.name, enabled, test.enabled)
+		}
+	}
+}
+
+func TestCheckVolumeCapabilities(t *testing.T) {
+	newCap := func(block bool, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		volCap := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+		if block {
+			volCap.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			volCap.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}}
+		}
+		return volCap
+	}
+	tests := []struct {
+		name       string
+		volumeType string
+		attached   bool
+		shared     bool
+		caps       []*csi.VolumeCapability
+		confirmed  bool
+	}{
+		{
+			name:       "mount single node writer",
+			volumeType: common.BlockVolumeType,
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+			confirmed:  true,
+		},
+		{
+			name:       "attached block single node writer",
+			volumeType: common.BlockVolumeType,
+			attached:   true,
+			caps:       []*csi.VolumeCapability{newCap(true, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+			confirmed:  true,
+		},
+		{
+			name:       "attached multi node reader",
+			volumeType: common.BlockVolumeType,
+			attached:   true,
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)},
+		},
+		{
+			name:       "mount multi node writer",
+			volumeType: common.BlockVolumeType,
+			shared:     true,
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		},
+		{
+			name:       "attached shared block multi node writer",
+			volumeType: common.BlockVolumeType,
+			attached:   true,
+			shared:     true,
+			caps:       []*csi.VolumeCapability{newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+			confirmed:  true,
+		},
+		{
+			name:       "block multi node writer not shared",
+			volumeType: common.BlockVolumeType,
+			caps:       []*csi.VolumeCapability{newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		},
+		{
+			name:       "no access type",
+			volumeType: common.BlockVolumeType,
+			caps: []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}},
+		},
+		{
+			name:       "file volume",
+			volumeType: common.FileVolumeType,
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+			confirmed:  true,
+		},
+		{
+			name:       "file volume multi node writer",
+			volumeType: common.FileVolumeType,
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+			confirmed:  true,
+		},
+		{
+			name:       "file volume block",
+			volumeType: common.FileVolumeType,
+			caps:       []*csi.VolumeCapability{newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		},
+		{
+			name:       "unknown volume type",
+			volumeType: "UNKNOWN",
+			caps:       []*csi.VolumeCapability{newCap(false, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+		},
+	}
+	for _, test := range tests {
+		msg := checkVolumeCapabilities(test.volumeType, test.attached, test.shared, test.caps)
+		if confirmed := msg == ""; confirmed != test.confirmed {
+			t.Errorf("%s: checkVolumeCapabilities() = %q, expected confirmed %t", test.name, msg, test.confirmed)
+		}
+	}
+}
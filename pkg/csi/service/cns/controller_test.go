@@ -33,6 +33,8 @@ import (
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	clientset "k8s.io/client-go/kubernetes"
 	testclient "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/klog"
@@ -108,6 +110,10 @@ func (f *FakeNodeManager) GetSharedDatastoresInK8SCluster(ctx context.Context) (
 	}, nil
 }
 
+func (f *FakeNodeManager) GetAccessibleDatastoresInK8SCluster(ctx context.Context) ([]*cnsvsphere.DatastoreInfo, error) {
+	return f.GetSharedDatastoresInK8SCluster(ctx)
+}
+
 func (f *FakeNodeManager) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine, error) {
 	var vm *cnsvsphere.VirtualMachine
 	if v := os.Getenv("VSPHERE_DATACENTER"); v != "" {
@@ -130,7 +136,7 @@ func (f *FakeNodeManager) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMac
 	return vm, nil
 }
 
-func (f *FakeNodeManager) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneKey string, regionKey string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
+func (f *FakeNodeManager) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, levels []common.TopologyLevel) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
 	return nil, nil, nil
 }
 
@@ -200,6 +206,8 @@ func getControllerTest(t *testing.T) *controllerTest {
 				sharedDatastoreURL: sharedDatastoreURL,
 				k8sClient:          k8sClient,
 			},
+			k8sClient:      k8sClient,
+			hostDatastores: newHostDatastoreCache(),
 		}
 		controllerTestInstance = &controllerTest{
 			controller: c,
@@ -354,6 +362,15 @@ func TestCompleteControllerFlow(t *testing.T) {
 	}
 	volID := respCreate.Volume.VolumeId
 
+	// Retrying the create, e.g. after a restart of the controller, returns the same volume
+	respCreate, err = ct.controller.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respCreate.Volume.VolumeId != volID {
+		t.Fatalf("Retried create returned volume %s, expected %s", respCreate.Volume.VolumeId, volID)
+	}
+
 	// Varify the volume has been created
 	queryFilter := cnstypes.CnsQueryFilter{
 		VolumeIds: []cnstypes.CnsVolumeId{
@@ -442,3 +459,125 @@ func TestCompleteControllerFlow(t *testing.T) {
 		t.Fatalf("Volume should not exist after deletion with ID: %s", volID)
 	}
 }
+
+func TestControllerExpandVolumeErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(t)
+	tests := []struct {
+		name     string
+		req      *csi.ControllerExpandVolumeRequest
+		expected codes.Code
+	}{
+		{
+			name:     "no capacity range",
+			req:      &csi.ControllerExpandVolumeRequest{VolumeId: "unknown-volume"},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "limit below required capacity",
+			req: &csi.ControllerExpandVolumeRequest{VolumeId: "unknown-volume",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * common.GbInBytes, LimitBytes: common.GbInBytes}},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "unknown volume",
+			req: &csi.ControllerExpandVolumeRequest{VolumeId: "unknown-volume",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * common.GbInBytes}},
+			expected: codes.NotFound,
+		},
+		{
+			name: "file volume",
+			req: &csi.ControllerExpandVolumeRequest{VolumeId: common.FileVolumePrefix + "unknown-volume",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * common.GbInBytes}},
+			expected: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		_, err := ct.controller.ControllerExpandVolume(ctx, test.req)
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s: ControllerExpandVolume() returned %v, expected code %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestControllerGetVolumeErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(t)
+	tests := []struct {
+		name     string
+		req      *csi.ControllerGetVolumeRequest
+		expected codes.Code
+	}{
+		{name: "no volume ID", req: &csi.ControllerGetVolumeRequest{}, expected: codes.InvalidArgument},
+		{name: "unknown volume", req: &csi.ControllerGetVolumeRequest{VolumeId: "unknown-volume"}, expected: codes.NotFound},
+	}
+	for _, test := range tests {
+		_, err := ct.controller.ControllerGetVolume(ctx, test.req)
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s: ControllerGetVolume() returned %v, expected code %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestValidateVolumeCapabilitiesErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(t)
+	caps := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	tests := []struct {
+		name     string
+		req      *csi.ValidateVolumeCapabilitiesRequest
+		expected codes.Code
+	}{
+		{name: "no volume ID", req: &csi.ValidateVolumeCapabilitiesRequest{VolumeCapabilities: caps}, expected: codes.InvalidArgument},
+		{name: "no capabilities", req: &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "unknown-volume"}, expected: codes.InvalidArgument},
+		{
+			name:     "unknown volume",
+			req:      &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "unknown-volume", VolumeCapabilities: caps},
+			expected: codes.NotFound,
+		},
+	}
+	for _, test := range tests {
+		_, err := ct.controller.ValidateVolumeCapabilities(ctx, test.req)
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s: ValidateVolumeCapabilities() returned %v, expected code %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestPageVolumes(t *testing.T) {
+	var volumes []cnstypes.CnsVolume
+	for _, id := range []string{"vol-c", "vol-a", "vol-d", "vol-b"} {
+		volumes = append(volumes, cnstypes.CnsVolume{VolumeId: cnstypes.CnsVolumeId{Id: id}})
+	}
+	tests := []struct {
+		name          string
+		startingToken string
+		maxEntries    int
+		expected      []string
+		nextToken     string
+		ok            bool
+	}{
+		{name: "all volumes", expected: []string{"vol-a", "vol-b", "vol-c", "vol-d"}, ok: true},
+		{name: "first page", maxEntries: 3, expected: []string{"vol-a", "vol-b", "vol-c"}, nextToken: "vol-d", ok: true},
+		{name: "next page", startingToken: "vol-b", maxEntries: 2, expected: []string{"vol-b", "vol-c"}, nextToken: "vol-d", ok: true},
+		{name: "last page", startingToken: "vol-d", maxEntries: 2, expected: []string{"vol-d"}, ok: true},
+		{name: "unknown starting token", startingToken: "vol-bb"},
+	}
+	for _, test := range tests {
+		page, nextToken, ok := pageVolumes(volumes, test.startingToken, test.maxEntries)
+		var ids []string
+		for _, volume := range page {
+			ids = append(ids, volume.VolumeId.Id)
+		}
+		if ok != test.ok || nextToken != test.nextToken || fmt.Sprint(ids) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: pageVolumes() = %v, %q, %t, expected %v, %q, %t",
+				test.name, ids, nextToken, ok, test.expected, test.nextToken, test.ok)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	csictx "github.com/rexray/gocsi/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+// watchCredentials watches the secret holding the config file, so that the vCenter credentials are rotated
+// as soon as they are updated in the secret rather than when the session expires or the controller restarts
+func (c *controller) watchCredentials() {
+	name := os.Getenv(common.EnvConfigSecretName)
+	if name == "" {
+		name = common.DefaultConfigSecretName
+	}
+	namespace := getConfigSecretNamespace()
+	klog.V(2).Infof("Watching secret %s/%s for vCenter credentials", namespace, name)
+	k8s.WatchSecret(c.k8sClient, namespace, name, c.rotateCredentials, make(chan struct{}))
+}
+
+// getConfigSecretNamespace returns the namespace of the secret holding the config file, which is the namespace
+// of the controller
+func getConfigSecretNamespace() string {
+	if namespace := os.Getenv(common.EnvConfigSecretNamespace); namespace != "" {
+		return namespace
+	}
+	return common.DefaultConfigSecretNamespace
+}
+
+// rotateCredentials rotates the credentials of the vCenters to the ones of the config file in the secret
+func (c *controller) rotateCredentials(secret *v1.Secret) {
+	hosts := []string{c.manager.VcenterConfig.Host}
+	for host := range c.managers {
+		hosts = append(hosts, host)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, host := range hosts {
+		username, password, err := getSecretCredentials(secret, host)
+		if err != nil {
+			klog.Warningf("Failed to read vCenter credentials from secret %s/%s. err=%v", secret.Namespace, secret.Name, err)
+			continue
+		}
+		vc, err := c.manager.VcenterManager.GetVirtualCenter(host)
+		if err != nil {
+			klog.Errorf("Failed to get VirtualCenter instance for host: %q. err=%v", host, err)
+			continue
+		}
+		if err = vc.RotateCredentials(ctx, username, password); err != nil {
+			klog.Errorf("Failed to rotate credentials of vCenter %q. err=%v", host, err)
+		}
+	}
+}
+
+// getSecretCredentials returns the username and password of the vCenter with the given host in the config file
+// held by the secret, which is the key named after the config file the driver reads
+func getSecretCredentials(secret *v1.Secret, host string) (string, string, error) {
+	cfgPath := csictx.Getenv(context.Background(), config.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = config.DefaultCloudConfigPath
+	}
+	data, ok := secret.Data[filepath.Base(cfgPath)]
+	if !ok {
+		return "", "", fmt.Errorf("secret has no %s key", filepath.Base(cfgPath))
+	}
+	cfg, err := config.ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	vcenterconfig, err := cnsvsphere.GetVirtualCenterConfig(cfg)
+	if err != nil {
+		return "", "", err
+	}
+	if vcenterconfig.Host != host {
+		return "", "", fmt.Errorf("secret configures vCenter %q rather than %q", vcenterconfig.Host, host)
+	}
+	return vcenterconfig.Username, vcenterconfig.Password, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetSecretCredentials(t *testing.T) {
+	const conf = `
+[Global]
+cluster-id = "cluster1"
+
+[VirtualCenter "vcenter1"]
+user = "administrator@vsphere.local"
+password = "rotated"
+datacenters = "dc1"
+`
+	tests := []struct {
+		name             string
+		data             map[string][]byte
+		host             string
+		expectedUsername string
+		expectedPassword string
+		expectErr        bool
+	}{
+		{name: "rotated", data: map[string][]byte{"csi-vsphere.conf": []byte(conf)}, host: "vcenter1",
+			expectedUsername: "administrator@vsphere.local", expectedPassword: "rotated"},
+		{name: "other vCenter", data: map[string][]byte{"csi-vsphere.conf": []byte(conf)}, host: "vcenter2", expectErr: true},
+		{name: "no config file", data: map[string][]byte{"other.conf": []byte(conf)}, host: "vcenter1", expectErr: true},
+	}
+	for _, test := range tests {
+		secret := &v1.Secret{Data: test.data}
+		username, password, err := getSecretCredentials(secret, test.host)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: getSecretCredentials() returned err %v, expected error: %t", test.name, err, test.expectErr)
+			continue
+		}
+		if username != test.expectedUsername || password != test.expectedPassword {
+			t.Errorf("%s: getSecretCredentials() = %q, %q, expected %q, %q",
+				test.name, username, password, test.expectedUsername, test.expectedPassword)
+		}
+	}
+}
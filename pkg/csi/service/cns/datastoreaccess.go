@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// datastoreAccess restricts the datastores the volumes of a namespace can be placed on
+type datastoreAccess struct {
+	namespace string
+	// allowed holds the datastores of the rules matching the namespace, nil if it matches none
+	allowed map[string]bool
+	// reserved holds the datastores of all rules
+	reserved map[string]bool
+}
+
+// newDatastoreAccess returns the datastore access of the namespace under the datastore access rules
+func newDatastoreAccess(rules map[string]*config.DatastoreAccessConfig, namespace *v1.Namespace) (*datastoreAccess, error) {
+	access := &datastoreAccess{namespace: namespace.Name, reserved: make(map[string]bool)}
+	for name, rule := range rules {
+		datastoreURLs := splitList(rule.DatastoreURLs)
+		for _, datastoreURL := range datastoreURLs {
+			access.reserved[datastoreURL] = true
+		}
+		matches := false
+		for _, ns := range splitList(rule.Namespaces) {
+			if ns == namespace.Name {
+				matches = true
+			}
+		}
+		if rule.NamespaceSelector != "" {
+			selector, err := labels.Parse(rule.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("datastore access rule %q has an invalid namespace selector. Err: %v", name, err)
+			}
+			if selector.Matches(labels.Set(namespace.Labels)) {
+				matches = true
+			}
+		}
+		if !matches {
+			continue
+		}
+		if access.allowed == nil {
+			access.allowed = make(map[string]bool)
+		}
+		for _, datastoreURL := range datastoreURLs {
+			access.allowed[datastoreURL] = true
+		}
+	}
+	return access, nil
+}
+
+// isAllowed returns true if volumes of the namespace can be placed on the datastore
+func (access *datastoreAccess) isAllowed(datastoreURL string) bool {
+	if access.allowed != nil {
+		return access.allowed[datastoreURL]
+	}
+	return !access.reserved[datastoreURL]
+}
+
+// filter returns the datastores volumes of the namespace can be placed on
+func (access *datastoreAccess) filter(datastores []*cnsvsphere.DatastoreInfo) []*cnsvsphere.DatastoreInfo {
+	var allowed []*cnsvsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if access.isAllowed(datastore.Info.Url) {
+			allowed = append(allowed, datastore)
+		} else {
+			klog.V(4).Infof("Skipping datastore %s not allowed for namespace %q", datastore.Info.Url, access.namespace)
+		}
+	}
+	return allowed
+}
+
+// splitList returns the non empty items of the comma separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getDatastoreAccess returns the datastore access of the namespace of the PVC the volume is created for,
+// or nil if no datastore access rules are configured. The namespace is taken from the parameters passed
+// by the external-provisioner with --extra-create-metadata, otherwise from the PVC the volume is named after.
+func (c *controller) getDatastoreAccess(req *csi.CreateVolumeRequest) (*datastoreAccess, error) {
+	rules := c.manager.CnsConfig.DatastoreAccess
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	var namespace string
+	for paramName, value := range req.Parameters {
+		if strings.ToLower(paramName) == common.AttributePVCNamespace {
+			namespace = value
+		}
+	}
+	if namespace == "" {
+		pvcs, err := c.k8sClient.CoreV1().PersistentVolumeClaims("").List(metav1.ListOptions{})
+		if err != nil {
+			msg := fmt.Sprintf("Failed to list persistent volume claims to enforce datastore access rules. Error: %+v", err)
+			klog.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
+		}
+		for _, pvc := range pvcs.Items {
+			if req.Name == "pvc-"+string(pvc.UID) {
+				namespace = pvc.Namespace
+				break
+			}
+		}
+	}
+	if namespace == "" {
+		msg := fmt.Sprintf("Failed to find the namespace of volume %q to enforce datastore access rules", req.Name)
+		klog.Error(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+	ns, err := c.k8sClient.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get namespace %q to enforce datastore access rules. Error: %+v", namespace, err)
+		klog.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+	access, err := newDatastoreAccess(rules, ns)
+	if err != nil {
+		klog.Error(err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return access, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func TestDatastoreAccess(t *testing.T) {
+	rules := map[string]*config.DatastoreAccessConfig{
+		"tenant-a": {Namespaces: "team-a, team-a-dev", DatastoreURLs: "ds:///a1/, ds:///a2/"},
+		"tenant-b": {NamespaceSelector: "tenant=b", DatastoreURLs: "ds:///b/"},
+		"shared":   {NamespaceSelector: "shared-storage", DatastoreURLs: "ds:///shared/"},
+	}
+	tests := []struct {
+		name      string
+		namespace v1.Namespace
+		allowed   []string
+		denied    []string
+	}{
+		{
+			name:      "listed namespace",
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a-dev"}},
+			allowed:   []string{"ds:///a1/", "ds:///a2/"},
+			denied:    []string{"ds:///b/", "ds:///shared/", "ds:///other/"},
+		},
+		{
+			name:      "selected namespace",
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"tenant": "b"}}},
+			allowed:   []string{"ds:///b/"},
+			denied:    []string{"ds:///a1/", "ds:///other/"},
+		},
+		{
+			name: "namespace matching several rules",
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a",
+				Labels: map[string]string{"shared-storage": "true"}}},
+			allowed: []string{"ds:///a1/", "ds:///shared/"},
+			denied:  []string{"ds:///b/"},
+		},
+		{
+			name:      "namespace matching no rule",
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"tenant": "c"}}},
+			allowed:   []string{"ds:///other/"},
+			denied:    []string{"ds:///a1/", "ds:///b/", "ds:///shared/"},
+		},
+	}
+	for _, test := range tests {
+		access, err := newDatastoreAccess(rules, &test.namespace)
+		if err != nil {
+			t.Errorf("%s: newDatastoreAccess() failed: %v", test.name, err)
+			continue
+		}
+		for _, datastoreURL := range test.allowed {
+			if !access.isAllowed(datastoreURL) {
+				t.Errorf("%s: datastore %s denied, expected allowed", test.name, datastoreURL)
+			}
+		}
+		for _, datastoreURL := range test.denied {
+			if access.isAllowed(datastoreURL) {
+				t.Errorf("%s: datastore %s allowed, expected denied", test.name, datastoreURL)
+			}
+		}
+	}
+}
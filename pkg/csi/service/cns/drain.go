@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// csiPluginName is the name of the kubernetes CSI volume plugin, which prefixes the unique names of CSI volumes
+// in the volumes in use reported by the kubelet
+const csiPluginName = "kubernetes.io/csi"
+
+// isDrainPending returns true if the node is marked with the drain-pending annotation or taint
+func isDrainPending(node *v1.Node) bool {
+	if node.Annotations[common.AnnDrainPending] == "true" {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == common.AnnDrainPending {
+			return true
+		}
+	}
+	return false
+}
+
+// isPodTerminated returns true if all containers of the pod have terminated for good
+func isPodTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+func (nodes *Nodes) nodeUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*v1.Node)
+	if oldNode == nil || !ok {
+		klog.Warningf("nodeUpdate: unrecognized old object %+v", oldObj)
+		return
+	}
+	newNode, ok := newObj.(*v1.Node)
+	if newNode == nil || !ok {
+		klog.Warningf("nodeUpdate: unrecognized new object %+v", newObj)
+		return
+	}
+	if !isDrainPending(oldNode) && isDrainPending(newNode) {
+		klog.V(2).Infof("Node %q is pending drain, detaching volumes not used by running pods", newNode.Name)
+		nodes.queueDrain(newNode.Name)
+	} else if isDrainPending(newNode) && len(newNode.Status.VolumesInUse) < len(oldNode.Status.VolumesInUse) {
+		// Volumes the kubelet no longer reports in use may have become idle
+		nodes.queueDrain(newNode.Name)
+	}
+}
+
+func (nodes *Nodes) podUpdate(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*v1.Pod)
+	if oldPod == nil || !ok {
+		klog.Warningf("podUpdate: unrecognized old object %+v", oldObj)
+		return
+	}
+	newPod, ok := newObj.(*v1.Pod)
+	if newPod == nil || !ok {
+		klog.Warningf("podUpdate: unrecognized new object %+v", newObj)
+		return
+	}
+	if !isPodTerminated(oldPod) && isPodTerminated(newPod) {
+		nodes.podGone(newPod)
+	}
+}
+
+func (nodes *Nodes) podDelete(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			klog.Warningf("podDelete: unrecognized object %+v", obj)
+			return
+		}
+		if pod, ok = tombstone.Obj.(*v1.Pod); !ok {
+			klog.Warningf("podDelete: unrecognized tombstone object %+v", tombstone.Obj)
+			return
+		}
+	}
+	if pod != nil {
+		nodes.podGone(pod)
+	}
+}
+
+// podGone drains the node of a terminated or deleted pod again if the node is pending drain
+func (nodes *Nodes) podGone(pod *v1.Pod) {
+	if pod.Spec.NodeName == "" || len(pod.Spec.Volumes) == 0 {
+		return
+	}
+	node, err := nodes.nodeLister.Get(pod.Spec.NodeName)
+	if err != nil {
+		klog.V(4).Infof("podGone: failed to get node %q of pod %s/%s. err=%v", pod.Spec.NodeName, pod.Namespace, pod.Name, err)
+		return
+	}
+	if isDrainPending(node) {
+		nodes.queueDrain(node.Name)
+	}
+}
+
+// queueDrain drains the node in the background. A node being drained is drained again once done,
+// so that pods terminated meanwhile are taken into account.
+func (nodes *Nodes) queueDrain(nodeName string) {
+	nodes.drainLock.Lock()
+	defer nodes.drainLock.Unlock()
+	if _, running := nodes.draining[nodeName]; running {
+		nodes.draining[nodeName] = true
+		return
+	}
+	nodes.draining[nodeName] = false
+	go func() {
+		for {
+			nodes.drain(nodeName)
+			nodes.drainLock.Lock()
+			again := nodes.draining[nodeName]
+			if !again {
+				delete(nodes.draining, nodeName)
+			} else {
+				nodes.draining[nodeName] = false
+			}
+			nodes.drainLock.Unlock()
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+// drain hands the volumes attached to the node VM which no running pod on the node uses to detachIdleVolumes
+func (nodes *Nodes) drain(nodeName string) {
+	// Empty caches would make all volumes look idle
+	if !nodes.informMgr.WaitForCacheSync() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := nodes.nodeLister.Get(nodeName)
+	if err != nil {
+		klog.Warningf("drain: failed to get node %q. err=%v", nodeName, err)
+		return
+	}
+	vm, err := nodes.cnsNodeManager.GetNodeByName(nodeName)
+	if err != nil {
+		klog.Warningf("drain: failed to get VM for node: %q. err=%v", nodeName, err)
+		return
+	}
+	attachedVolumeIDs, err := volume.GetAttachedVolumes(ctx, vm)
+	if err != nil {
+		klog.Warningf("drain: failed to get volumes attached to node: %q. err=%v", nodeName, err)
+		return
+	}
+	pods, err := nodes.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("drain: failed to list pods. err=%v", err)
+		return
+	}
+	pvs, err := nodes.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("drain: failed to list persistent volumes. err=%v", err)
+		return
+	}
+	pvcs, err := nodes.pvcLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("drain: failed to list persistent volume claims. err=%v", err)
+		return
+	}
+	if volumeIDs := getIdleVolumes(node, attachedVolumeIDs, pods, pvcs, pvs); len(volumeIDs) > 0 {
+		nodes.detachIdleVolumes(nodeName, vm, volumeIDs)
+	}
+}
+
+// getIdleVolumes returns the handles of the persistent volumes backed by the attached volumes, given by CNS id,
+// which are not in use on the node. Attached volumes without a persistent volume are left alone.
+func getIdleVolumes(node *v1.Node, attachedVolumeIDs []string, pods []*v1.Pod,
+	pvcs []*v1.PersistentVolumeClaim, pvs []*v1.PersistentVolume) []string {
+	inUse := getVolumesInUse(node, pods, pvcs, pvs)
+	handles := make(map[string]string)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil {
+			cnsVolumeID, _ := common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+			handles[cnsVolumeID] = pv.Spec.CSI.VolumeHandle
+		}
+	}
+	var volumeIDs []string
+	for _, cnsVolumeID := range attachedVolumeIDs {
+		if volumeID, ok := handles[cnsVolumeID]; ok && !inUse[volumeID] {
+			volumeIDs = append(volumeIDs, volumeID)
+		}
+	}
+	return volumeIDs
+}
+
+// getVolumesInUse returns the handles of the persistent volumes in use on the node, which are those used by the
+// pods on the node which have not terminated or are being deleted, and those the kubelet reports in use, as their
+// mounts may outlive the pods using them
+func getVolumesInUse(node *v1.Node, pods []*v1.Pod, pvcs []*v1.PersistentVolumeClaim, pvs []*v1.PersistentVolume) map[string]bool {
+	pvNames := make(map[string]string)
+	for _, pvc := range pvcs {
+		pvNames[pvc.Namespace+"/"+pvc.Name] = pvc.Spec.VolumeName
+	}
+	usedPVs := make(map[string]bool)
+	for _, pod := range pods {
+		// A terminating pod keeps its volumes mounted until the kubelet tears it down, whatever its phase
+		if pod.Spec.NodeName != node.Name || (isPodTerminated(pod) && pod.DeletionTimestamp == nil) {
+			continue
+		}
+		for _, podVolume := range pod.Spec.Volumes {
+			if podVolume.PersistentVolumeClaim != nil {
+				usedPVs[pvNames[pod.Namespace+"/"+podVolume.PersistentVolumeClaim.ClaimName]] = true
+			}
+		}
+	}
+	mounted := make(map[v1.UniqueVolumeName]bool)
+	for _, volumeName := range node.Status.VolumesInUse {
+		mounted[volumeName] = true
+	}
+	inUse := make(map[string]bool)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && (usedPVs[pv.Name] || mounted[getUniqueVolumeName(pv)]) {
+			inUse[pv.Spec.CSI.VolumeHandle] = true
+		}
+	}
+	return inUse
+}
+
+// getUniqueVolumeName returns the name the kubelet reports the volume of the CSI persistent volume in use with
+func getUniqueVolumeName(pv *v1.PersistentVolume) v1.UniqueVolumeName {
+	return v1.UniqueVolumeName(csiPluginName + "/" + pv.Spec.CSI.Driver + "^" + pv.Spec.CSI.VolumeHandle)
+}
+
+// detachIdleVolumes detaches volumes from a node pending drain. The ControllerUnpublishVolume calls
+// which follow once the pods are evicted find the volumes detached already and return right away.
+func (c *controller) detachIdleVolumes(nodeName string, vm *cnsvsphere.VirtualMachine, volumeIDs []string) {
+	c.detachVolumes(nodeName, vm, volumeIDs, "pending drain")
+}
+
+// detachVolumes detaches the volumes, given by CSI volume id, from the VM of the node, whose state is given
+// for logging, with the attach and vCenter workers of the controller
+func (c *controller) detachVolumes(nodeName string, vm *cnsvsphere.VirtualMachine, volumeIDs []string, nodeState string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, volumeID := range volumeIDs {
+		if err := c.attachWorkers.acquire(ctx); err != nil {
+			return
+		}
+		if err := c.vcenterWorkers.acquire(ctx, priorityHigh); err != nil {
+			c.attachWorkers.release()
+			return
+		}
+		klog.V(2).Infof("Detaching volume %q from node %q %s", volumeID, nodeName, nodeState)
+		manager, cnsVolumeID, err := c.getVolumeManager(volumeID)
+		if err == nil {
+			err = c.vmLocks.run(ctx, vm, func() error {
+				return common.DetachVolumeUtil(ctx, manager, vm, cnsVolumeID)
+			})
+		}
+		if err != nil {
+			klog.Warningf("Failed to detach volume %q from node %q %s. err=%v", volumeID, nodeName, nodeState, err)
+		}
+		c.vcenterWorkers.release()
+		c.attachWorkers.release()
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestIsDrainPending(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     v1.Node
+		expected bool
+	}{
+		{"unmarked", v1.Node{}, false},
+		{"annotation", v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{common.AnnDrainPending: "true"}}}, true},
+		{"annotation not true", v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{common.AnnDrainPending: "false"}}}, false},
+		{"taint", v1.Node{Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: common.AnnDrainPending, Effect: v1.TaintEffectNoSchedule}}}}, true},
+	}
+	for _, test := range tests {
+		if actual := isDrainPending(&test.node); actual != test.expected {
+			t.Errorf("%s: isDrainPending() = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestGetIdleVolumes(t *testing.T) {
+	newPod := func(name, nodeName string, phase v1.PodPhase, claimName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+			Spec: v1.PodSpec{
+				NodeName: nodeName,
+				Volumes: []v1.Volume{{
+					Name: "data",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				}},
+			},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	newPVC := func(name, volumeName string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		}
+	}
+	newPV := func(name, volumeHandle string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: common.DriverName, VolumeHandle: volumeHandle},
+				},
+			},
+		}
+	}
+	pvcs := []*v1.PersistentVolumeClaim{newPVC("running", "pv-1"), newPVC("succeeded", "pv-2"), newPVC("failed", "pv-3"), newPVC("unused", "pv-4"),
+		newPVC("terminating", "pv-7"), newPVC("mounted", "pv-8")}
+	pvs := []*v1.PersistentVolume{newPV("pv-1", "fcd-1"), newPV("pv-2", "fcd-2"), newPV("pv-3", "fcd-3"), newPV("pv-4", "fcd-4"),
+		newPV("pv-6", common.EncodeVolumeID("fcd-6", "vc-b")), newPV("pv-7", "fcd-7"), newPV("pv-8", "fcd-8")}
+	terminating := newPod("terminating", "node-1", v1.PodSucceeded, "terminating")
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	pods := []*v1.Pod{
+		newPod("running", "node-1", v1.PodRunning, "running"),
+		newPod("succeeded", "node-1", v1.PodSucceeded, "succeeded"),
+		newPod("failed", "node-1", v1.PodFailed, "failed"),
+		newPod("pending-elsewhere", "node-2", v1.PodPending, "unused"),
+		terminating,
+		newPod("mounted", "node-1", v1.PodSucceeded, "mounted"),
+	}
+	// The kubelet still reports the volume of pv-8 in use, e.g. while unmounting it
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{VolumesInUse: []v1.UniqueVolumeName{"kubernetes.io/csi/" + common.DriverName + "^fcd-8"}},
+	}
+	// fcd-5 has no persistent volume, hence is not managed by Kubernetes and left alone
+	attached := []string{"fcd-1", "fcd-2", "fcd-3", "fcd-4", "fcd-5", "fcd-6", "fcd-7", "fcd-8"}
+	expected := []string{"fcd-2", "fcd-3", "fcd-4", "fcd-6@vc-b"}
+	if actual := getIdleVolumes(node, attached, pods, pvcs, pvs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("getIdleVolumes() = %v, expected %v", actual, expected)
+	}
+}
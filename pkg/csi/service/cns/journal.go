@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// invalidJournalKeyChars matches the characters not allowed in ConfigMap keys
+var invalidJournalKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// volumeNameUIDPattern matches the names the external provisioner gives to volumes, the UID of their PVC prefixed
+var volumeNameUIDPattern = regexp.MustCompile(`-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+
+// configMapJournal is the task journal of the controller, persisted in a ConfigMap so that it survives restarts.
+// Failures to persist it are logged, as the journal only spares resubmitting tasks after a restart.
+type configMapJournal struct {
+	lock      sync.Mutex
+	client    clientset.Interface
+	namespace string
+	name      string
+	tasks     map[string]string
+}
+
+// getTaskJournalName returns the name of the ConfigMap of the task journal read from X_CSI_TASK_JOURNAL_NAME
+// if set, otherwise the default name. An empty name disables the journal.
+func getTaskJournalName() string {
+	if name, ok := os.LookupEnv(common.EnvTaskJournalName); ok {
+		return name
+	}
+	return common.DefaultTaskJournalName
+}
+
+// newConfigMapJournal returns the task journal persisted in the ConfigMap with the given namespace and name,
+// loading the tasks recorded in it before the controller restarted
+func newConfigMapJournal(client clientset.Interface, namespace string, name string) (*configMapJournal, error) {
+	j := &configMapJournal{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		tasks:     make(map[string]string),
+	}
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for key, taskID := range cm.Data {
+			j.tasks[key] = taskID
+		}
+	}
+	klog.V(2).Infof("Loaded %d tasks in flight from task journal %s/%s", len(j.tasks), namespace, name)
+	return j, nil
+}
+
+// Get returns the id of the task recorded under the given key, or an empty string
+func (j *configMapJournal) Get(key string) string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.tasks[journalKey(key)]
+}
+
+// Record records the task with the given id under the given key
+func (j *configMapJournal) Record(key string, taskID string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.tasks[journalKey(key)] = taskID
+	j.save()
+}
+
+// Remove removes the task recorded under the given key
+func (j *configMapJournal) Remove(key string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.tasks[journalKey(key)]; !ok {
+		return
+	}
+	delete(j.tasks, journalKey(key))
+	j.save()
+}
+
+// List returns the ids of the tasks recorded under keys with the given prefix, by the rest of their key
+func (j *configMapJournal) List(prefix string) map[string]string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	prefix = journalKey(prefix)
+	tasks := make(map[string]string)
+	for key, taskID := range j.tasks {
+		if strings.HasPrefix(key, prefix) {
+			tasks[strings.TrimPrefix(key, prefix)] = taskID
+		}
+	}
+	return tasks
+}
+
+// save writes the tasks to the ConfigMap, creating it if needed. Must be called with the lock held.
+func (j *configMapJournal) save() {
+	data := make(map[string]string, len(j.tasks))
+	for key, taskID := range j.tasks {
+		data[key] = taskID
+	}
+	configMaps := j.client.CoreV1().ConfigMaps(j.namespace)
+	cm, err := configMaps.Get(j.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: j.namespace, Name: j.name},
+			Data:       data,
+		}
+		_, err = configMaps.Create(cm)
+	} else if err == nil {
+		cm.Data = data
+		_, err = configMaps.Update(cm)
+	}
+	if err != nil {
+		klog.Warningf("Failed to save task journal %s/%s. err=%v", j.namespace, j.name, err)
+	}
+}
+
+// journalKey returns the ConfigMap key of the task journal key
+func journalKey(key string) string {
+	return invalidJournalKeyChars.ReplaceAllString(key, "_")
+}
+
+// replayTaskJournal resolves the CNS tasks recorded in the task journal before the controller restarted on each
+// vCenter, rolling back the volumes created for PVCs which are gone. The journal is replayed on the next restart
+// if the PersistentVolumes and PersistentVolumeClaims cannot be listed.
+func (c *controller) replayTaskJournal() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pvs, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Failed to list PersistentVolumes to replay the task journal. err=%v", err)
+		return
+	}
+	pvcs, err := c.k8sClient.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Failed to list PersistentVolumeClaims to replay the task journal. err=%v", err)
+		return
+	}
+	isVolumeWanted := getWantedVolumes(pvs.Items, pvcs.Items)
+	for _, manager := range c.getManagers() {
+		manager.VolumeManager.ReplayJournal(ctx, isVolumeWanted)
+	}
+}
+
+// getWantedVolumes returns whether the volume with the given name is wanted by the given PVs and PVCs: it has
+// a PV, or the external provisioner, which names volumes after the UID of their PVC, keeps retrying to create it
+// as its PVC is pending. Names which are not of that form are assumed to be wanted.
+func getWantedVolumes(pvs []v1.PersistentVolume, pvcs []v1.PersistentVolumeClaim) func(name string) bool {
+	pvNames := make(map[string]bool)
+	for _, pv := range pvs {
+		pvNames[pv.Name] = true
+	}
+	pendingUIDs := make(map[string]bool)
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == v1.ClaimPending && pvc.DeletionTimestamp == nil {
+			pendingUIDs[string(pvc.UID)] = true
+		}
+	}
+	return func(name string) bool {
+		if pvNames[name] {
+			return true
+		}
+		match := volumeNameUIDPattern.FindStringSubmatch(name)
+		if match == nil {
+			return true
+		}
+		return pendingUIDs[match[1]]
+	}
+}
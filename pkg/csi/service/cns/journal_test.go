@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapJournal(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	journal, err := newConfigMapJournal(client, "kube-system", "journal")
+	if err != nil {
+		t.Fatalf("newConfigMapJournal() failed: %v", err)
+	}
+	journal.Record("createVolume.vc1.pvc-1", "task-1")
+	journal.Record("attachVolume.[fd00::1].vol-1.vm-1", "task-2")
+	journal.Record("deleteVolume.vc1.vol-2", "task-3")
+	journal.Remove("deleteVolume.vc1.vol-2")
+	journal.Remove("deleteVolume.vc1.vol-3")
+
+	// A restarted controller loads the tasks in flight
+	restarted, err := newConfigMapJournal(client, "kube-system", "journal")
+	if err != nil {
+		t.Fatalf("newConfigMapJournal() failed: %v", err)
+	}
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"createVolume.vc1.pvc-1", "task-1"},
+		{"attachVolume.[fd00::1].vol-1.vm-1", "task-2"},
+		{"deleteVolume.vc1.vol-2", ""},
+	}
+	for _, test := range tests {
+		if taskID := restarted.Get(test.key); taskID != test.expected {
+			t.Errorf("Get(%q) = %q, expected %q", test.key, taskID, test.expected)
+		}
+	}
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get("journal", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get journal ConfigMap: %v", err)
+	}
+	expected := map[string]string{
+		"createVolume.vc1.pvc-1":            "task-1",
+		"attachVolume._fd00__1_.vol-1.vm-1": "task-2",
+	}
+	if !reflect.DeepEqual(cm.Data, expected) {
+		t.Errorf("journal ConfigMap data = %v, expected %v", cm.Data, expected)
+	}
+	tasks := restarted.List("attachVolume.[fd00::1].")
+	if expected := map[string]string{"vol-1.vm-1": "task-2"}; !reflect.DeepEqual(tasks, expected) {
+		t.Errorf("List() = %v, expected %v", tasks, expected)
+	}
+}
+
+func TestGetWantedVolumes(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba"}},
+	}
+	now := metav1.Now()
+	pvcs := []v1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{UID: "5b1c2d3e-e8c4-11e9-a4bd-005056a4c8ba"},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{UID: "6c2d3e4f-e8c4-11e9-a4bd-005056a4c8ba"},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{UID: "7d3e4f5a-e8c4-11e9-a4bd-005056a4c8ba", DeletionTimestamp: &now},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+		},
+	}
+	isVolumeWanted := getWantedVolumes(pvs, pvcs)
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba", true},
+		{"pvc-5b1c2d3e-e8c4-11e9-a4bd-005056a4c8ba", true},
+		{"custom-5b1c2d3e-e8c4-11e9-a4bd-005056a4c8ba", true},
+		{"pvc-6c2d3e4f-e8c4-11e9-a4bd-005056a4c8ba", false},
+		{"pvc-7d3e4f5a-e8c4-11e9-a4bd-005056a4c8ba", false},
+		{"pvc-8e4f5a6b-e8c4-11e9-a4bd-005056a4c8ba", false},
+		{"static-volume", true},
+	}
+	for _, test := range tests {
+		if wanted := isVolumeWanted(test.name); wanted != test.expected {
+			t.Errorf("isVolumeWanted(%q) = %t, expected %t", test.name, wanted, test.expected)
+		}
+	}
+}
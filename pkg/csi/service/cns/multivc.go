@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"sort"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// getVolumeManager returns the manager of the vCenter owning the volume with the given CSI volume id,
+// along with the CNS id of the volume
+func (c *controller) getVolumeManager(volumeID string) (*common.Manager, string, error) {
+	cnsVolumeID, vcHost := common.DecodeVolumeID(volumeID)
+	manager, err := c.getVirtualCenterManager(vcHost)
+	if err != nil {
+		return nil, "", status.Errorf(codes.NotFound, "vCenter %q of volume %q is not configured", vcHost, volumeID)
+	}
+	return manager, cnsVolumeID, nil
+}
+
+// getVirtualCenterManager returns the manager of the vCenter with the given host, which is the manager of the
+// first vCenter of the config if the host is empty
+func (c *controller) getVirtualCenterManager(vcHost string) (*common.Manager, error) {
+	if vcHost == "" || vcHost == c.manager.VcenterConfig.Host {
+		return c.manager, nil
+	}
+	manager, ok := c.managers[vcHost]
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "vCenter %q is not configured", vcHost)
+	}
+	return manager, nil
+}
+
+// encodeVolumeID returns the CSI volume id of the CNS volume with the given id created with the given manager
+func (c *controller) encodeVolumeID(manager *common.Manager, cnsVolumeID string) string {
+	if manager == c.manager {
+		return cnsVolumeID
+	}
+	return common.EncodeVolumeID(cnsVolumeID, manager.VcenterConfig.Host)
+}
+
+// getManagers returns the managers of all the vCenters of the config, the first vCenter first and the others
+// ordered by host
+func (c *controller) getManagers() []*common.Manager {
+	hosts := make([]string, 0, len(c.managers))
+	for host := range c.managers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	managers := []*common.Manager{c.manager}
+	for _, host := range hosts {
+		managers = append(managers, c.managers[host])
+	}
+	return managers
+}
+
+// getSnapshotManager returns the manager of the vCenter owning the volume of the snapshot with the given CSI
+// snapshot id, along with the id of the snapshot on that vCenter. Ids which are not CSI snapshot ids are
+// returned as is along with the manager of the first vCenter of the config.
+func (c *controller) getSnapshotManager(snapshotID string) (*common.Manager, string, error) {
+	volumeID, fcdSnapshotID, ok := common.ParseSnapshotID(snapshotID)
+	if !ok {
+		return c.manager, snapshotID, nil
+	}
+	manager, cnsVolumeID, err := c.getVolumeManager(volumeID)
+	if err != nil {
+		return nil, "", err
+	}
+	return manager, common.GetSnapshotID(cnsVolumeID, fcdSnapshotID), nil
+}
+
+// encodeSnapshot replaces the ids of the volume and of the snapshot taken with the given manager, which are
+// those on its vCenter, with their CSI ids
+func (c *controller) encodeSnapshot(manager *common.Manager, snapshot *csi.Snapshot) {
+	volumeID, fcdSnapshotID, _ := common.ParseSnapshotID(snapshot.SnapshotId)
+	snapshot.SourceVolumeId = c.encodeVolumeID(manager, snapshot.SourceVolumeId)
+	snapshot.SnapshotId = common.GetSnapshotID(c.encodeVolumeID(manager, volumeID), fcdSnapshotID)
+}
+
+// selectVirtualCenter returns the host of the vCenter to place a volume on, which is the vCenter of the datastore
+// with the given URL if set, otherwise the one of the first of the datastores, along with the datastores of the
+// vCenter, as volumes cannot be placed across vCenters
+func selectVirtualCenter(datastores []*cnsvsphere.DatastoreInfo, datastoreURL string) (string, []*cnsvsphere.DatastoreInfo) {
+	if len(datastores) == 0 {
+		return "", datastores
+	}
+	vcHost := getDatastoreVirtualCenterHost(datastores[0])
+	for _, datastore := range datastores {
+		if datastoreURL != "" && datastore.Info.Url == datastoreURL {
+			vcHost = getDatastoreVirtualCenterHost(datastore)
+			break
+		}
+	}
+	return vcHost, getVirtualCenterDatastores(datastores, vcHost)
+}
+
+// getVirtualCenterDatastores returns the datastores of the vCenter with the given host. Datastores whose vCenter
+// is unknown are deemed to be on any vCenter.
+func getVirtualCenterDatastores(datastores []*cnsvsphere.DatastoreInfo, vcHost string) []*cnsvsphere.DatastoreInfo {
+	var vcDatastores []*cnsvsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if host := getDatastoreVirtualCenterHost(datastore); host == "" || host == vcHost {
+			vcDatastores = append(vcDatastores, datastore)
+		}
+	}
+	return vcDatastores
+}
+
+// getDatastoreVirtualCenterHost returns the host of the vCenter of the datastore, or an empty string if unknown
+func getDatastoreVirtualCenterHost(datastore *cnsvsphere.DatastoreInfo) string {
+	if datastore.Datastore == nil || datastore.Datacenter == nil {
+		return ""
+	}
+	return datastore.Datacenter.VirtualCenterHost
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func newVirtualCenterDatastore(vcHost string, url string) *cnsvsphere.DatastoreInfo {
+	return &cnsvsphere.DatastoreInfo{
+		Datastore: &cnsvsphere.Datastore{
+			Datacenter: &cnsvsphere.Datacenter{VirtualCenterHost: vcHost},
+		},
+		Info: &types.DatastoreInfo{Url: url},
+	}
+}
+
+func TestSelectVirtualCenter(t *testing.T) {
+	a1 := newVirtualCenterDatastore("vc-a", "ds:///a1/")
+	a2 := newVirtualCenterDatastore("vc-a", "ds:///a2/")
+	b1 := newVirtualCenterDatastore("vc-b", "ds:///b1/")
+	unknown := &cnsvsphere.DatastoreInfo{Datastore: &cnsvsphere.Datastore{}, Info: &types.DatastoreInfo{Url: "ds:///u/"}}
+	tests := []struct {
+		name         string
+		datastores   []*cnsvsphere.DatastoreInfo
+		datastoreURL string
+		vcHost       string
+		expected     []*cnsvsphere.DatastoreInfo
+	}{
+		{"no datastores", nil, "", "", nil},
+		{"first datastore", []*cnsvsphere.DatastoreInfo{a1, b1, a2}, "", "vc-a", []*cnsvsphere.DatastoreInfo{a1, a2}},
+		{"datastore URL", []*cnsvsphere.DatastoreInfo{a1, b1, a2}, "ds:///b1/", "vc-b", []*cnsvsphere.DatastoreInfo{b1}},
+		{"unknown datastore URL", []*cnsvsphere.DatastoreInfo{b1, a1}, "ds:///c/", "vc-b", []*cnsvsphere.DatastoreInfo{b1}},
+		{"unknown vCenter", []*cnsvsphere.DatastoreInfo{a1, unknown, b1}, "", "vc-a", []*cnsvsphere.DatastoreInfo{a1, unknown}},
+	}
+	for _, test := range tests {
+		vcHost, datastores := selectVirtualCenter(test.datastores, test.datastoreURL)
+		if vcHost != test.vcHost || !reflect.DeepEqual(datastores, test.expected) {
+			t.Errorf("%s: selectVirtualCenter() = %q, %v, expected %q, %v", test.name, vcHost, datastores, test.vcHost, test.expected)
+		}
+	}
+}
+
+func TestGroupByVirtualCenter(t *testing.T) {
+	a1 := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc-a", UUID: "a1"}
+	a2 := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc-a", UUID: "a2"}
+	b1 := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc-b", UUID: "b1"}
+	groups := groupByVirtualCenter([]*cnsvsphere.VirtualMachine{b1, a1, a2})
+	expected := [][]*cnsvsphere.VirtualMachine{{b1}, {a1, a2}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("groupByVirtualCenter() = %v, expected %v", groups, expected)
+	}
+	if groups := groupByVirtualCenter(nil); len(groups) != 0 {
+		t.Errorf("groupByVirtualCenter(nil) = %v, expected no groups", groups)
+	}
+}
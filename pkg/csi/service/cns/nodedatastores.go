@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// nodeDatastoreCache caches the datastores accessible to node VMs, so that volume placement does not query
+// every node VM on every CreateVolume. Entries are dropped when their node is registered, unregistered or
+// its VM migrates, and expire after the TTL to catch datastores mounted or unmounted meanwhile.
+type nodeDatastoreCache struct {
+	lock sync.Mutex
+	ttl  time.Duration
+	vms  map[string]*nodeDatastores
+	// load returns the datastores accessible to the node VM
+	load func(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error)
+	now  func() time.Time
+}
+
+type nodeDatastores struct {
+	datastores []*cnsvsphere.DatastoreInfo
+	loaded     time.Time
+}
+
+// getNodeDatastoreCacheTTL returns the TTL of the node VM datastore cache read from
+// X_CSI_NODE_DATASTORE_CACHE_TTL_SECONDS if set and valid, otherwise the default of 5 minutes
+func getNodeDatastoreCacheTTL() time.Duration {
+	ttlSeconds := common.DefaultNodeDatastoreCacheTTLSeconds
+	if v := os.Getenv(common.EnvNodeDatastoreCacheTTLSeconds); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			ttlSeconds = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default TTL of %d seconds",
+				common.EnvNodeDatastoreCacheTTLSeconds, v, ttlSeconds)
+		}
+	}
+	klog.V(2).Infof("Datastores accessible to node VMs will be cached for %d seconds", ttlSeconds)
+	return time.Duration(ttlSeconds) * time.Second
+}
+
+// newNodeDatastoreCache returns a cache of the datastores accessible to node VMs with the given TTL,
+// or nil if the TTL is not positive
+func newNodeDatastoreCache(ttl time.Duration) *nodeDatastoreCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &nodeDatastoreCache{
+		ttl: ttl,
+		vms: make(map[string]*nodeDatastores),
+		load: func(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+			return vm.GetAllAccessibleDatastores(ctx)
+		},
+		now: time.Now,
+	}
+}
+
+// nodeVMKey returns the key of the VM with the given reference on the given vCenter in the node VM caches
+func nodeVMKey(vcHost string, vmRef types.ManagedObjectReference) string {
+	return vcHost + "/" + vmRef.Value
+}
+
+// get returns the datastores accessible to the node VM, from the cache unless expired.
+// A nil cache always queries the VM.
+func (cache *nodeDatastoreCache) get(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+	if cache == nil {
+		return vm.GetAllAccessibleDatastores(ctx)
+	}
+	key := nodeVMKey(vm.VirtualCenterHost, vm.Reference())
+	cache.lock.Lock()
+	cached, ok := cache.vms[key]
+	cache.lock.Unlock()
+	if ok && cache.now().Sub(cached.loaded) < cache.ttl {
+		return append([]*cnsvsphere.DatastoreInfo(nil), cached.datastores...), nil
+	}
+	datastores, err := cache.load(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+	cache.lock.Lock()
+	cache.vms[key] = &nodeDatastores{datastores: datastores, loaded: cache.now()}
+	cache.lock.Unlock()
+	return append([]*cnsvsphere.DatastoreInfo(nil), datastores...), nil
+}
+
+// forget drops the cached datastores of the VM with the given reference on the given vCenter
+func (cache *nodeDatastoreCache) forget(vcHost string, vmRef types.ManagedObjectReference) {
+	if cache == nil {
+		return
+	}
+	cache.lock.Lock()
+	delete(cache.vms, nodeVMKey(vcHost, vmRef))
+	cache.lock.Unlock()
+}
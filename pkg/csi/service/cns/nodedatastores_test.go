@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestNodeDatastoreCache(t *testing.T) {
+	now := time.Now()
+	loads := 0
+	cache := newNodeDatastoreCache(5 * time.Minute)
+	cache.now = func() time.Time { return now }
+	cache.load = func(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+		loads++
+		return []*cnsvsphere.DatastoreInfo{{Info: &types.DatastoreInfo{Url: "ds:///a/"}}}, nil
+	}
+	vmRef := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	vm := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc", VirtualMachine: object.NewVirtualMachine(nil, vmRef)}
+	tests := []struct {
+		name          string
+		elapsed       time.Duration
+		forget        bool
+		expectedLoads int
+	}{
+		{"first lookup", 0, false, 1},
+		{"cached", time.Minute, false, 1},
+		{"expired", 5 * time.Minute, false, 2},
+		{"forgotten", 0, true, 3},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if test.forget {
+			cache.forget("vc", vmRef)
+		}
+		datastores, err := cache.get(context.Background(), vm)
+		if err != nil {
+			t.Fatalf("%s: get() failed: %v", test.name, err)
+		}
+		if len(datastores) != 1 || datastores[0].Info.Url != "ds:///a/" {
+			t.Errorf("%s: get() = %v, expected datastore ds:///a/", test.name, datastores)
+		}
+		if loads != test.expectedLoads {
+			t.Errorf("%s: node VM datastores loaded %d times, expected %d", test.name, loads, test.expectedLoads)
+		}
+	}
+	if newNodeDatastoreCache(0) != nil {
+		t.Errorf("newNodeDatastoreCache(0) = non-nil, expected nil")
+	}
+}
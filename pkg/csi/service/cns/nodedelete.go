@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// deletedNodeDetachTimeout is how long the ControllerUnpublishVolume calls in flight for a deleted node
+// are waited for before the volumes still attached to its VM are detached
+const deletedNodeDetachTimeout = 5 * time.Minute
+
+// inflightDetaches counts the ControllerUnpublishVolume calls in flight by node name.
+// A nil inflightDetaches does not count them.
+type inflightDetaches struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	count map[string]int
+}
+
+func newInflightDetaches() *inflightDetaches {
+	d := &inflightDetaches{count: make(map[string]int)}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// start counts a detach from the node as in flight
+func (d *inflightDetaches) start(nodeName string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.count[nodeName]++
+}
+
+// done counts a detach from the node as completed
+func (d *inflightDetaches) done(nodeName string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.count[nodeName]--; d.count[nodeName] <= 0 {
+		delete(d.count, nodeName)
+		d.cond.Broadcast()
+	}
+}
+
+// wait blocks until no detach from the node is in flight or the timeout expires,
+// and returns whether no detach from the node is in flight
+func (d *inflightDetaches) wait(nodeName string, timeout time.Duration) bool {
+	if d == nil {
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.cond.Broadcast()
+	})
+	defer timer.Stop()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for d.count[nodeName] > 0 && time.Now().Before(deadline) {
+		d.cond.Wait()
+	}
+	return d.count[nodeName] == 0
+}
+
+// unregisterNode unregisters the deleted node, handing its VM to detachDeletedNode first if set
+func (nodes *Nodes) unregisterNode(nodeName string) {
+	if nodes.detachDeletedNode != nil {
+		if vm, err := nodes.cnsNodeManager.GetNodeByName(nodeName); err != nil {
+			klog.Warningf("unregisterNode: failed to get VM for node: %q. err=%v", nodeName, err)
+		} else {
+			nodes.detachDeletedNode(nodeName, vm)
+		}
+	}
+	nodes.forgetNodeVM(nodeName)
+	if err := nodes.cnsNodeManager.UnregisterNode(nodeName); err != nil {
+		klog.Warningf("Failed to unregister node:%q. err=%v", nodeName, err)
+	}
+}
+
+// detachDeletedNode waits for the ControllerUnpublishVolume calls in flight for the deleted node, then
+// detaches the volumes of PersistentVolumes of the driver still attached to its VM, so that they are neither
+// stuck attached to a VM nobody detaches them from nor deleted along with the VM. Volumes are only detached
+// from a VM which is powered off, as a VM still running may be writing to them. Other disks of the VM are left
+// alone. ControllerUnpublishVolume calls made for the node once it is unregistered succeed right away.
+func (c *controller) detachDeletedNode(nodeName string, vm *cnsvsphere.VirtualMachine) {
+	if !c.detaches.wait(nodeName, deletedNodeDetachTimeout) {
+		klog.Warningf("Detaches from deleted node %q still in flight after %v", nodeName, deletedNodeDetachTimeout)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	active, err := vm.IsActive(ctx)
+	if err != nil {
+		if code, ok := cnsvsphere.ErrorCode(err); ok && code == codes.NotFound {
+			klog.V(2).Infof("VM of deleted node %q is gone, no volume to detach", nodeName)
+		} else {
+			klog.Warningf("Failed to get power state of VM of deleted node %q, leaving its volumes attached. err=%v", nodeName, err)
+		}
+		return
+	}
+	if active {
+		klog.Warningf("VM of deleted node %q is powered on, leaving its volumes attached", nodeName)
+		return
+	}
+	cnsVolumeIDs, err := volume.GetAttachedVolumes(ctx, vm)
+	if err != nil {
+		klog.Warningf("Failed to get volumes attached to deleted node %q. err=%v", nodeName, err)
+		return
+	}
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Failed to list PersistentVolumes to detach from deleted node %q. err=%v", nodeName, err)
+		return
+	}
+	if volumeIDs := getDriverVolumes(cnsVolumeIDs, pvList.Items); len(volumeIDs) > 0 {
+		c.detachVolumes(nodeName, vm, volumeIDs, "deleted")
+	}
+}
+
+// getDriverVolumes returns the handles of the PersistentVolumes of the driver backed by the attached volumes,
+// given by CNS id. Attached volumes without a PersistentVolume of the driver are left out.
+func getDriverVolumes(attachedVolumeIDs []string, pvs []v1.PersistentVolume) []string {
+	handles := make(map[string]string)
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == common.DriverName {
+			cnsVolumeID, _ := common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+			handles[cnsVolumeID] = pv.Spec.CSI.VolumeHandle
+		}
+	}
+	var volumeIDs []string
+	for _, cnsVolumeID := range attachedVolumeIDs {
+		if volumeID, ok := handles[cnsVolumeID]; ok {
+			volumeIDs = append(volumeIDs, volumeID)
+		}
+	}
+	return volumeIDs
+}
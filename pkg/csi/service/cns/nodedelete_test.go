@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestInflightDetaches(t *testing.T) {
+	d := newInflightDetaches()
+	if !d.wait("node-1", time.Second) {
+		t.Error("wait() without detaches in flight returned false")
+	}
+	d.start("node-1")
+	d.start("node-1")
+	d.start("node-2")
+	if d.wait("node-1", 10*time.Millisecond) {
+		t.Error("wait() with detaches in flight returned true after the timeout")
+	}
+	go func() {
+		d.done("node-1")
+		time.Sleep(10 * time.Millisecond)
+		d.done("node-1")
+	}()
+	if !d.wait("node-1", time.Minute) {
+		t.Error("wait() returned false once detaches completed")
+	}
+	if d.wait("node-2", 10*time.Millisecond) {
+		t.Error("wait() for another node returned true after the timeout")
+	}
+
+	var nilDetaches *inflightDetaches
+	nilDetaches.start("node-1")
+	if !nilDetaches.wait("node-1", time.Second) {
+		t.Error("wait() of nil inflightDetaches returned false")
+	}
+}
+
+func TestGetDriverVolumes(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		newTestPV("pv-1", common.DriverName, "fcd-1"),
+		newTestPV("pv-2", "other.csi.driver", "fcd-2"),
+		newTestPV("pv-3", common.DriverName, common.EncodeVolumeID("fcd-3", "vc-b")),
+	}
+	// fcd-4 has no persistent volume, e.g. a disk added to the VM by hand, and is left attached
+	attached := []string{"fcd-1", "fcd-2", "fcd-3", "fcd-4"}
+	expected := []string{"fcd-1", "fcd-3@vc-b"}
+	if actual := getDriverVolumes(attached, pvs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("getDriverVolumes() = %v, expected %v", actual, expected)
+	}
+}
@@ -19,15 +19,23 @@ package cns
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/types"
 	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
 	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
-	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
 )
 
@@ -35,6 +43,26 @@ import (
 type Nodes struct {
 	cnsNodeManager cnsnode.Manager
 	informMgr      *k8s.InformerManager
+	// detachIdleVolumes, if set, is called with the CSI volume ids of the volumes attached to a node marked
+	// with the drain-pending annotation or taint which no running pod on the node uses, when the node is
+	// marked and whenever a pod on the node terminates.
+	detachIdleVolumes func(nodeName string, vm *cnsvsphere.VirtualMachine, volumeIDs []string)
+	// detachDeletedNode, if set, is called with the VM of a deleted node before the node is unregistered
+	detachDeletedNode func(nodeName string, vm *cnsvsphere.VirtualMachine)
+	nodeLister        corelisters.NodeLister
+	podLister         corelisters.PodLister
+	pvcLister         corelisters.PersistentVolumeClaimLister
+	pvLister          corelisters.PersistentVolumeLister
+	drainLock         sync.Mutex
+	// draining holds the nodes being drained, mapped to whether the node has to be drained again
+	draining map[string]bool
+	// registrationQueue holds the names of the nodes to register, failed registrations being rate limited
+	registrationQueue workqueue.RateLimitingInterface
+	eventRecorder     record.EventRecorder
+	// datastores caches the datastores accessible to node VMs, nil if they are not cached
+	datastores *nodeDatastoreCache
+	// topology caches the zone and region of node VMs, nil if they are not cached
+	topology *nodeTopologyCache
 }
 
 // Initialize helps initialize node manager and node informer manager
@@ -48,20 +76,104 @@ func (nodes *Nodes) Initialize() error {
 	}
 	nodes.cnsNodeManager.SetKubernetesClient(k8sclient)
 	nodes.informMgr = k8s.NewInformer(k8sclient)
-	nodes.informMgr.AddNodeListener(nodes.nodeAdd, nil, nodes.nodeDelete)
+	nodes.eventRecorder = k8s.NewEventRecorder(k8sclient, "vsphere-csi-controller")
+	nodes.registrationQueue = newRegistrationQueue()
+	nodes.datastores = newNodeDatastoreCache(getNodeDatastoreCacheTTL())
+	nodes.topology = newNodeTopologyCache(getNodeTopologyCacheTTL())
+	// Listers have to be requested before the informers are started
+	nodes.nodeLister = nodes.informMgr.GetNodeLister()
+	if nodes.detachIdleVolumes == nil {
+		nodes.informMgr.AddNodeListener(nodes.nodeAdd, nil, nodes.nodeDelete)
+	} else {
+		nodes.informMgr.AddNodeListener(nodes.nodeAdd, nodes.nodeUpdate, nodes.nodeDelete)
+		nodes.informMgr.AddPodListener(nil, nodes.podUpdate, nodes.podDelete)
+		nodes.draining = make(map[string]bool)
+		nodes.podLister = nodes.informMgr.GetPodLister()
+		nodes.pvcLister = nodes.informMgr.GetPVCLister()
+		nodes.pvLister = nodes.informMgr.GetPVLister()
+	}
 	nodes.informMgr.Listen()
+	go nodes.runRegistrationWorker()
+	if interval := getNodeReconcileInterval(); interval > 0 {
+		go nodes.runNodeReconciler(interval)
+	}
+	for _, vc := range cnsvsphere.GetVirtualCenterManager().GetAllVirtualCenters() {
+		go nodes.watchNodeVMMigrations(vc)
+	}
 	return nil
 }
 
+// watchNodeVMMigrations rediscovers the nodes whose VM is migrated on the vCenter, so that attach and
+// placement decisions do not rely on the datacenter or vCenter the VM was found in before.
+func (nodes *Nodes) watchNodeVMMigrations(vc *cnsvsphere.VirtualCenter) {
+	for retry := 1; ; retry++ {
+		err := vc.WatchVirtualMachineMigrations(context.Background(), func(vmRef types.ManagedObjectReference) {
+			nodes.datastores.forget(vc.Config.Host, vmRef)
+			nodes.topology.forget(vc.Config.Host, vmRef)
+			if err := nodes.cnsNodeManager.NodeVMMigrated(vc.Config.Host, vmRef); err != nil {
+				klog.Warningf("Failed to rediscover node of migrated VM %v. err=%v", vmRef, err)
+			}
+		})
+		delay := backoff.Get().Delay(retry)
+		klog.Warningf("Watching VM migrations on vCenter %q failed, retrying in %v. err=%v", vc.Config.Host, delay, err)
+		time.Sleep(delay)
+	}
+}
+
 func (nodes *Nodes) nodeAdd(obj interface{}) {
 	node, ok := obj.(*v1.Node)
 	if node == nil || !ok {
 		klog.Warningf("nodeAdd: unrecognized object %+v", obj)
 		return
 	}
-	err := nodes.cnsNodeManager.RegisterNode(common.GetUUIDFromProviderID(node.Spec.ProviderID), node.Name)
+	nodes.registrationQueue.Add(node.Name)
+	if nodes.detachIdleVolumes != nil && isDrainPending(node) {
+		nodes.queueDrain(node.Name)
+	}
+}
+
+// getNodeUUIDs returns the UUIDs the VM of the node may have: the one of the provider ID set by the vSphere
+// cloud provider, or if it is not set, the system UUID reported by kubelet as is and in vSphere format, as
+// the guest OS may report the BIOS UUID of the VM with the bytes of its first three fields swapped.
+func getNodeUUIDs(node *v1.Node) []string {
+	if node.Spec.ProviderID != "" {
+		return []string{common.GetUUIDFromProviderID(node.Spec.ProviderID)}
+	}
+	systemUUID := strings.ToLower(node.Status.NodeInfo.SystemUUID)
+	if systemUUID == "" {
+		klog.Warningf("Node %q has neither a provider ID nor a system UUID to find its VM with", node.Name)
+		return []string{""}
+	}
+	klog.V(2).Infof("Node %q has no provider ID, finding its VM by system UUID %q", node.Name, systemUUID)
+	nodeUUIDs := []string{systemUUID}
+	if convertedUUID, err := common.ConvertUUID(systemUUID); err == nil {
+		nodeUUIDs = append(nodeUUIDs, convertedUUID)
+	}
+	return nodeUUIDs
+}
+
+// auditKeepAfterDeleteVM flags volumes attached to the node VM which do not have keepAfterDeleteVm set,
+// as these volumes would be deleted along with the node VM.
+func (nodes *Nodes) auditKeepAfterDeleteVM(nodeName string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vm, err := nodes.cnsNodeManager.GetNodeByName(nodeName)
 	if err != nil {
-		klog.Warningf("Failed to register node:%q. err=%v", node.Name, err)
+		klog.Warningf("auditKeepAfterDeleteVM: failed to get VM for node: %q. err=%v", nodeName, err)
+		return
+	}
+	vc, err := cnsvsphere.GetVirtualCenterManager().GetVirtualCenter(vm.VirtualCenterHost)
+	if err != nil {
+		klog.Warningf("auditKeepAfterDeleteVM: failed to get vCenter %q for node: %q. err=%v", vm.VirtualCenterHost, nodeName, err)
+		return
+	}
+	volumeIDs, err := volume.GetVolumesWithoutKeepAfterDeleteVM(ctx, vc, vm)
+	if err != nil {
+		klog.Warningf("auditKeepAfterDeleteVM: failed to audit volumes attached to node: %q. err=%v", nodeName, err)
+		return
+	}
+	for _, volumeID := range volumeIDs {
+		klog.Warningf("Volume %q attached to node %q does not have keepAfterDeleteVm set and will be deleted if the node VM is deleted", volumeID, nodeName)
 	}
 }
 
@@ -71,9 +183,18 @@ func (nodes *Nodes) nodeDelete(obj interface{}) {
 		klog.Warningf("nodeDelete: unrecognized object %+v", obj)
 		return
 	}
-	err := nodes.cnsNodeManager.UnregisterNode(node.Name)
-	if err != nil {
-		klog.Warningf("Failed to unregister node:%q. err=%v", node.Name, err)
+	go nodes.unregisterNode(node.Name)
+}
+
+// forgetNodeVM drops the cached datastores, zone and region of the VM the node with the given name is
+// registered with, if any
+func (nodes *Nodes) forgetNodeVM(nodeName string) {
+	if nodes.datastores == nil && nodes.topology == nil {
+		return
+	}
+	if vm, err := nodes.cnsNodeManager.GetNodeByName(nodeName); err == nil {
+		nodes.datastores.forget(vm.VirtualCenterHost, vm.Reference())
+		nodes.topology.forget(vm.VirtualCenterHost, vm.Reference())
 	}
 }
 
@@ -85,6 +206,9 @@ func (nodes *Nodes) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine,
 
 // GetSharedDatastoresInTopology returns shared accessible datastores for specified topologyRequirement along with the map of
 // datastore URL and array of accessibleTopology map for each datastore returned from this function.
+// The node VMs in a topology are found by their tags in the categories of the given topology levels,
+// whose keys the segments of the topology are looked up under. Segments may use either the GA or the beta form of
+// the region and zone labels.
 // Here in this function, argument topologyRequirement can be passed in following form
 // topologyRequirement [requisite:<segments:<key:"failure-domain.beta.kubernetes.io/region" value:"k8s-region-us" >
 //                                 segments:<key:"failure-domain.beta.kubernetes.io/zone" value:"k8s-zone-us-east" > >
@@ -103,8 +227,8 @@ func (nodes *Nodes) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine,
 //      ds:///vmfs/volumes/vsan:524fae1aaca129a5-1ee55a87f26ae626/:
 //         [map[failure-domain.beta.kubernetes.io/region:k8s-region-us failure-domain.beta.kubernetes.io/zone:k8s-zone-us-west]
 //         map[failure-domain.beta.kubernetes.io/region:k8s-region-us failure-domain.beta.kubernetes.io/zone:k8s-zone-us-east]]]]
-func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneCategoryName string, regionCategoryName string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
-	klog.V(4).Infof("GetSharedDatastoresInTopology: called with topologyRequirement: %+v, zoneCategoryName: %s, regionCategoryName: %s", topologyRequirement, zoneCategoryName, regionCategoryName)
+func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, levels []common.TopologyLevel) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
+	klog.V(4).Infof("GetSharedDatastoresInTopology: called with topologyRequirement: %+v, levels: %+v", topologyRequirement, levels)
 	allNodes, err := nodes.cnsNodeManager.GetAllNodes()
 	if err != nil {
 		klog.Errorf("Failed to get Nodes from nodeManager with err %+v", err)
@@ -115,22 +239,23 @@ func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyR
 		klog.Errorf(errMsg)
 		return nil, nil, fmt.Errorf(errMsg)
 	}
-	// getNodesInZoneRegion takes zone and region as parameter and returns list of node VMs which belongs to specified
-	// zone and region.
-	getNodesInZoneRegion := func(zoneValue string, regionValue string) ([]*cnsvsphere.VirtualMachine, error) {
-		klog.V(4).Infof("getNodesInZoneRegion: called with zoneValue: %s, regionValue: %s", zoneValue, regionValue)
-		var nodeVMsInZoneAndRegion []*cnsvsphere.VirtualMachine
+	categoryNames := common.GetTopologyCategories(levels)
+	// getNodesInTopology takes topology segments as parameter and returns list of node VMs which belongs to
+	// the specified topology.
+	getNodesInTopology := func(segments map[string]string) ([]*cnsvsphere.VirtualMachine, error) {
+		klog.V(4).Infof("getNodesInTopology: called with segments: %v", segments)
+		var nodeVMsInTopology []*cnsvsphere.VirtualMachine
 		for _, nodeVM := range allNodes {
-			isNodeInZoneRegion, err := nodeVM.IsInZoneRegion(ctx, zoneCategoryName, regionCategoryName, zoneValue, regionValue)
+			tags, err := nodes.topology.get(ctx, nodeVM, categoryNames)
 			if err != nil {
-				klog.Errorf("Error checking if node VM: %v belongs to zone [%s] and region [%s]. err: %+v", nodeVM, zoneValue, regionValue, err)
+				klog.Errorf("Error checking if node VM: %v belongs to topology %v. err: %+v", nodeVM, segments, err)
 				return nil, err
 			}
-			if isNodeInZoneRegion {
-				nodeVMsInZoneAndRegion = append(nodeVMsInZoneAndRegion, nodeVM)
+			if common.IsTopologyMatch(levels, common.GetTopologySegments(levels, tags), segments) {
+				nodeVMsInTopology = append(nodeVMsInTopology, nodeVM)
 			}
 		}
-		return nodeVMsInZoneAndRegion, nil
+		return nodeVMsInTopology, nil
 	}
 
 	// getSharedDatastoresInTopology returns list of shared accessible datastores for requested topology along with the map of datastore URL and array of accessibleTopology
@@ -141,32 +266,33 @@ func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyR
 		datastoreTopologyMap := make(map[string][]map[string]string)
 		for _, topology := range topologyArr {
 			segments := topology.GetSegments()
-			zone := segments[csitypes.LabelZoneFailureDomain]
-			region := segments[csitypes.LabelRegionFailureDomain]
-			klog.V(4).Infof("Getting list of nodeVMs for zone [%s] and region [%s]", zone, region)
-			nodeVMsInZoneRegion, err := getNodesInZoneRegion(zone, region)
+			klog.V(4).Infof("Getting list of nodeVMs for topology %v", segments)
+			nodeVMsInTopology, err := getNodesInTopology(segments)
 			if err != nil {
-				klog.Errorf("Failed to find Nodes in the zone: [%s] and region: [%s]. Error: %+v", zone, region, err)
+				klog.Errorf("Failed to find Nodes in the topology %v. Error: %+v", segments, err)
 				return nil, nil, err
 			}
-			klog.V(4).Infof("Obtained list of nodeVMs [%+v] for zone [%s] and region [%s]", nodeVMsInZoneRegion, zone, region)
-			sharedDatastoresInZoneRegion, err := nodes.GetSharedDatastoresForVMs(ctx, nodeVMsInZoneRegion)
+			klog.V(4).Infof("Obtained list of nodeVMs [%+v] for topology %v", nodeVMsInTopology, segments)
+			sharedDatastoresInTopology, err := nodes.getSharedDatastoresPerVirtualCenter(ctx, nodeVMsInTopology)
 			if err != nil {
-				klog.Errorf("Failed to get shared datastores for nodes: %+v in zone [%s] and region [%s]. Error: %+v", nodeVMsInZoneRegion, zone, region, err)
+				klog.Errorf("Failed to get shared datastores for nodes: %+v in topology %v. Error: %+v", nodeVMsInTopology, segments, err)
 				return nil, nil, err
 			}
 			klog.V(4).Infof("Obtained shared datastores : %+v for topology: %+v", sharedDatastores, topology)
-			for _, datastore := range sharedDatastoresInZoneRegion {
+			for _, datastore := range sharedDatastoresInTopology {
 				accessibleTopology := make(map[string]string)
-				if zone != "" {
-					accessibleTopology[csitypes.LabelZoneFailureDomain] = zone
-				}
-				if region != "" {
-					accessibleTopology[csitypes.LabelRegionFailureDomain] = region
+				// The accessible topology is given under the keys of the requirement, whichever form of
+				// the region and zone labels it uses
+				for _, level := range levels {
+					for _, key := range level.GetKeys() {
+						if value := segments[key]; value != "" {
+							accessibleTopology[key] = value
+						}
+					}
 				}
 				datastoreTopologyMap[datastore.Info.Url] = append(datastoreTopologyMap[datastore.Info.Url], accessibleTopology)
 			}
-			sharedDatastores = append(sharedDatastores, sharedDatastoresInZoneRegion...)
+			sharedDatastores = append(sharedDatastores, sharedDatastoresInTopology...)
 		}
 		return sharedDatastores, datastoreTopologyMap, nil
 	}
@@ -214,27 +340,85 @@ func (nodes *Nodes) GetSharedDatastoresInK8SCluster(ctx context.Context) ([]*cns
 	return sharedDatastores, nil
 }
 
-// GetSharedDatastoresForVMs returns shared datastores accessible to specified nodeVMs list
-func (nodes *Nodes) GetSharedDatastoresForVMs(ctx context.Context, nodeVMs []*cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+// GetAccessibleDatastoresInK8SCluster returns the DatastoreInfo objects for datastores accessible to any
+// kubernetes node in the cluster.
+func (nodes *Nodes) GetAccessibleDatastoresInK8SCluster(ctx context.Context) ([]*cnsvsphere.DatastoreInfo, error) {
+	nodeVMs, err := nodes.cnsNodeManager.GetAllNodes()
+	if err != nil {
+		klog.Errorf("Failed to get Nodes from nodeManager with err %+v", err)
+		return nil, err
+	}
+	accessibleDatastores, err := nodes.getAccessibleDatastores(ctx, nodeVMs)
+	if err != nil {
+		klog.Errorf("Failed to get accessible datastores for node VMs. Err: %+v", err)
+		return nil, err
+	}
+	var datastores []*cnsvsphere.DatastoreInfo
+	urls := make(map[string]bool)
+	for _, nodeVMDatastores := range accessibleDatastores {
+		for _, datastore := range nodeVMDatastores {
+			if !urls[datastore.Info.Url] {
+				urls[datastore.Info.Url] = true
+				datastores = append(datastores, datastore)
+			}
+		}
+	}
+	return datastores, nil
+}
+
+// getSharedDatastoresPerVirtualCenter returns the datastores shared by the specified node VMs of each vCenter,
+// as datastores cannot be shared across vCenters
+func (nodes *Nodes) getSharedDatastoresPerVirtualCenter(ctx context.Context, nodeVMs []*cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
 	var sharedDatastores []*cnsvsphere.DatastoreInfo
-	for _, nodeVM := range nodeVMs {
-		klog.V(4).Infof("Getting accessible datastores for node %s", nodeVM.VirtualMachine)
-		accessibleDatastores, err := nodeVM.GetAllAccessibleDatastores(ctx)
+	for _, vcNodeVMs := range groupByVirtualCenter(nodeVMs) {
+		vcSharedDatastores, err := nodes.GetSharedDatastoresForVMs(ctx, vcNodeVMs)
 		if err != nil {
 			return nil, err
 		}
-		if len(sharedDatastores) == 0 {
-			sharedDatastores = accessibleDatastores
+		sharedDatastores = append(sharedDatastores, vcSharedDatastores...)
+	}
+	return sharedDatastores, nil
+}
+
+// groupByVirtualCenter groups the node VMs by vCenter, in the order of the first node VM of each vCenter
+func groupByVirtualCenter(nodeVMs []*cnsvsphere.VirtualMachine) [][]*cnsvsphere.VirtualMachine {
+	var groups [][]*cnsvsphere.VirtualMachine
+	index := make(map[string]int)
+	for _, nodeVM := range nodeVMs {
+		i, ok := index[nodeVM.VirtualCenterHost]
+		if !ok {
+			i = len(groups)
+			index[nodeVM.VirtualCenterHost] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], nodeVM)
+	}
+	return groups
+}
+
+// sharedDatastoreWorkers is the number of node VMs whose accessible datastores are queried concurrently
+const sharedDatastoreWorkers = 16
+
+// GetSharedDatastoresForVMs returns shared datastores accessible to specified nodeVMs list
+func (nodes *Nodes) GetSharedDatastoresForVMs(ctx context.Context, nodeVMs []*cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+	accessibleDatastores, err := nodes.getAccessibleDatastores(ctx, nodeVMs)
+	if err != nil {
+		return nil, err
+	}
+	var sharedDatastores []*cnsvsphere.DatastoreInfo
+	for i, nodeVM := range nodeVMs {
+		if i == 0 {
+			sharedDatastores = accessibleDatastores[i]
 		} else {
+			// Intersection is performed based on the datastoreUrl as this uniquely identifies the datastore.
+			accessibleURLs := make(map[string]bool, len(accessibleDatastores[i]))
+			for _, accessibleDs := range accessibleDatastores[i] {
+				accessibleURLs[accessibleDs.Info.Url] = true
+			}
 			var sharedAccessibleDatastores []*cnsvsphere.DatastoreInfo
 			for _, sharedDs := range sharedDatastores {
-				// Check if sharedDatastores is found in accessibleDatastores
-				for _, accessibleDs := range accessibleDatastores {
-					// Intersection is performed based on the datastoreUrl as this uniquely identifies the datastore.
-					if sharedDs.Info.Url == accessibleDs.Info.Url {
-						sharedAccessibleDatastores = append(sharedAccessibleDatastores, sharedDs)
-						break
-					}
+				if accessibleURLs[sharedDs.Info.Url] {
+					sharedAccessibleDatastores = append(sharedAccessibleDatastores, sharedDs)
 				}
 			}
 			sharedDatastores = sharedAccessibleDatastores
@@ -245,3 +429,31 @@ func (nodes *Nodes) GetSharedDatastoresForVMs(ctx context.Context, nodeVMs []*cn
 	}
 	return sharedDatastores, nil
 }
+
+// getAccessibleDatastores returns the datastores accessible to each of the specified node VMs
+func (nodes *Nodes) getAccessibleDatastores(ctx context.Context, nodeVMs []*cnsvsphere.VirtualMachine) ([][]*cnsvsphere.DatastoreInfo, error) {
+	accessibleDatastores := make([][]*cnsvsphere.DatastoreInfo, len(nodeVMs))
+	errs := make([]error, len(nodeVMs))
+	w := make(workers, sharedDatastoreWorkers)
+	var wg sync.WaitGroup
+	for i, nodeVM := range nodeVMs {
+		if err := w.acquire(ctx); err != nil {
+			errs[i] = err
+			break
+		}
+		wg.Add(1)
+		go func(i int, nodeVM *cnsvsphere.VirtualMachine) {
+			defer wg.Done()
+			defer w.release()
+			klog.V(4).Infof("Getting accessible datastores for node %s", nodeVM.VirtualMachine)
+			accessibleDatastores[i], errs[i] = nodes.datastores.get(ctx, nodeVM)
+		}(i, nodeVM)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accessibleDatastores, nil
+}
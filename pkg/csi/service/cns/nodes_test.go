@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestGetNodeUUIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     v1.Node
+		expected []string
+	}{
+		{
+			name: "provider id",
+			node: v1.Node{
+				Spec:   v1.NodeSpec{ProviderID: "vsphere://42208c6b-d10d-37d0-156f-435f999d94c1"},
+				Status: v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: "6B8C2042-0DD1-D037-156F-435F999D94C1"}},
+			},
+			expected: []string{"42208c6b-d10d-37d0-156f-435f999d94c1"},
+		},
+		{
+			name:     "system uuid",
+			node:     v1.Node{Status: v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: "6B8C2042-0DD1-D037-156F-435F999D94C1"}}},
+			expected: []string{"6b8c2042-0dd1-d037-156f-435f999d94c1", "42208c6b-d10d-37d0-156f-435f999d94c1"},
+		},
+		{
+			name:     "neither",
+			node:     v1.Node{},
+			expected: []string{""},
+		},
+	}
+	for _, test := range tests {
+		if uuids := getNodeUUIDs(&test.node); !reflect.DeepEqual(uuids, test.expected) {
+			t.Errorf("%s: getNodeUUIDs() = %v, expected %v", test.name, uuids, test.expected)
+		}
+	}
+}
+
+func TestGetSharedDatastoresForVMs(t *testing.T) {
+	accessible := map[string][]string{
+		"vm-1": {"ds:///a/", "ds:///b/", "ds:///c/"},
+		"vm-2": {"ds:///c/", "ds:///b/"},
+		"vm-3": {"ds:///b/", "ds:///c/", "ds:///d/"},
+		"vm-4": {"ds:///d/"},
+	}
+	cache := newNodeDatastoreCache(time.Minute)
+	cache.load = func(ctx context.Context, vm *cnsvsphere.VirtualMachine) ([]*cnsvsphere.DatastoreInfo, error) {
+		var datastores []*cnsvsphere.DatastoreInfo
+		for _, url := range accessible[vm.Reference().Value] {
+			datastores = append(datastores, &cnsvsphere.DatastoreInfo{Info: &types.DatastoreInfo{Url: url}})
+		}
+		return datastores, nil
+	}
+	nodes := &Nodes{datastores: cache}
+	nodeVMs := func(names ...string) []*cnsvsphere.VirtualMachine {
+		var vms []*cnsvsphere.VirtualMachine
+		for _, name := range names {
+			vmRef := types.ManagedObjectReference{Type: "VirtualMachine", Value: name}
+			vms = append(vms, &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc", VirtualMachine: object.NewVirtualMachine(nil, vmRef)})
+		}
+		return vms
+	}
+	tests := []struct {
+		name        string
+		nodeVMs     []*cnsvsphere.VirtualMachine
+		expected    []string
+		expectedErr bool
+	}{
+		{"single node", nodeVMs("vm-1"), []string{"ds:///a/", "ds:///b/", "ds:///c/"}, false},
+		{"shared", nodeVMs("vm-1", "vm-2", "vm-3"), []string{"ds:///b/", "ds:///c/"}, false},
+		{"none shared", nodeVMs("vm-1", "vm-2", "vm-4"), nil, true},
+	}
+	for _, test := range tests {
+		datastores, err := nodes.GetSharedDatastoresForVMs(context.Background(), test.nodeVMs)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("%s: GetSharedDatastoresForVMs() err = %v, expected error %v", test.name, err, test.expectedErr)
+			continue
+		}
+		var urls []string
+		for _, datastore := range datastores {
+			urls = append(urls, datastore.Info.Url)
+		}
+		if !reflect.DeepEqual(urls, test.expected) {
+			t.Errorf("%s: GetSharedDatastoresForVMs() = %v, expected %v", test.name, urls, test.expected)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// nodeTopologyCache caches the topology tags of node VMs, so that volume placement does not look up the
+// tags of every node VM and its ancestors for every topology segment of every CreateVolume. Entries are
+// dropped when the VM migrates and expire after the TTL to catch tags attached or detached meanwhile.
+type nodeTopologyCache struct {
+	lock sync.Mutex
+	ttl  time.Duration
+	vms  map[string]*nodeTopology
+	// load returns the tags of the node VM in the given categories by category
+	load func(ctx context.Context, vm *cnsvsphere.VirtualMachine, categoryNames []string) (map[string]string, error)
+	now  func() time.Time
+}
+
+type nodeTopology struct {
+	tags   map[string]string
+	loaded time.Time
+}
+
+// getNodeTopologyCacheTTL returns the TTL of the node VM topology cache read from
+// X_CSI_NODE_TOPOLOGY_CACHE_TTL_SECONDS if set and valid, otherwise the default of 5 minutes
+func getNodeTopologyCacheTTL() time.Duration {
+	ttlSeconds := common.DefaultNodeTopologyCacheTTLSeconds
+	if v := os.Getenv(common.EnvNodeTopologyCacheTTLSeconds); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			ttlSeconds = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default TTL of %d seconds",
+				common.EnvNodeTopologyCacheTTLSeconds, v, ttlSeconds)
+		}
+	}
+	klog.V(2).Infof("Topology tags of node VMs will be cached for %d seconds", ttlSeconds)
+	return time.Duration(ttlSeconds) * time.Second
+}
+
+// newNodeTopologyCache returns a cache of the topology tags of node VMs with the given TTL,
+// or nil if the TTL is not positive
+func newNodeTopologyCache(ttl time.Duration) *nodeTopologyCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &nodeTopologyCache{
+		ttl: ttl,
+		vms: make(map[string]*nodeTopology),
+		load: func(ctx context.Context, vm *cnsvsphere.VirtualMachine, categoryNames []string) (map[string]string, error) {
+			return vm.GetTopology(ctx, categoryNames)
+		},
+		now: time.Now,
+	}
+}
+
+// get returns the tags of the node VM in the given categories by category, from the cache unless expired.
+// A nil cache always looks up the tags.
+func (cache *nodeTopologyCache) get(ctx context.Context, vm *cnsvsphere.VirtualMachine, categoryNames []string) (map[string]string, error) {
+	if cache == nil {
+		return vm.GetTopology(ctx, categoryNames)
+	}
+	// The categories are part of the key as they may change with the config
+	key := nodeVMKey(vm.VirtualCenterHost, vm.Reference()) + "/" + strings.Join(categoryNames, "/")
+	cache.lock.Lock()
+	cached, ok := cache.vms[key]
+	cache.lock.Unlock()
+	if ok && cache.now().Sub(cached.loaded) < cache.ttl {
+		return cached.tags, nil
+	}
+	tags, err := cache.load(ctx, vm, categoryNames)
+	if err != nil {
+		return nil, err
+	}
+	cache.lock.Lock()
+	cache.vms[key] = &nodeTopology{tags: tags, loaded: cache.now()}
+	cache.lock.Unlock()
+	return tags, nil
+}
+
+// forget drops the cached topology tags of the VM with the given reference on the given vCenter
+func (cache *nodeTopologyCache) forget(vcHost string, vmRef types.ManagedObjectReference) {
+	if cache == nil {
+		return
+	}
+	prefix := nodeVMKey(vcHost, vmRef) + "/"
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for key := range cache.vms {
+		if strings.HasPrefix(key, prefix) {
+			delete(cache.vms, key)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestNodeTopologyCache(t *testing.T) {
+	now := time.Now()
+	loads := 0
+	cache := newNodeTopologyCache(5 * time.Minute)
+	cache.now = func() time.Time { return now }
+	cache.load = func(ctx context.Context, vm *cnsvsphere.VirtualMachine, categoryNames []string) (map[string]string, error) {
+		loads++
+		return map[string]string{categoryNames[0]: categoryNames[0] + "-a", categoryNames[1]: categoryNames[1] + "-1"}, nil
+	}
+	vmRef := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	vm := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc", VirtualMachine: object.NewVirtualMachine(nil, vmRef)}
+	tests := []struct {
+		name          string
+		zoneCategory  string
+		elapsed       time.Duration
+		forget        bool
+		expectedZone  string
+		expectedLoads int
+	}{
+		{"first lookup", "zone", 0, false, "zone-a", 1},
+		{"cached", "zone", time.Minute, false, "zone-a", 1},
+		{"other category", "k8s-zone", 0, false, "k8s-zone-a", 2},
+		{"expired", "zone", 5 * time.Minute, false, "zone-a", 3},
+		{"forgotten", "zone", 0, true, "zone-a", 4},
+		{"other category forgotten", "k8s-zone", 0, false, "k8s-zone-a", 5},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if test.forget {
+			cache.forget("vc", vmRef)
+		}
+		tags, err := cache.get(context.Background(), vm, []string{test.zoneCategory, "region"})
+		if err != nil {
+			t.Fatalf("%s: get() failed: %v", test.name, err)
+		}
+		if zone, region := tags[test.zoneCategory], tags["region"]; zone != test.expectedZone || region != "region-1" {
+			t.Errorf("%s: get() = %q, %q, expected %q, %q", test.name, zone, region, test.expectedZone, "region-1")
+		}
+		if loads != test.expectedLoads {
+			t.Errorf("%s: node VM topology loaded %d times, expected %d", test.name, loads, test.expectedLoads)
+		}
+	}
+}
+
+func TestIsZoneRegionMatch(t *testing.T) {
+	tests := []struct {
+		vmZone, vmRegion, zone, region string
+		expected                       bool
+	}{
+		{"zone-a", "region-1", "zone-a", "region-1", true},
+		{"zone-a", "region-1", "zone-a", "", true},
+		{"zone-a", "region-1", "", "region-1", true},
+		{"zone-a", "region-1", "zone-b", "region-1", false},
+		{"zone-a", "", "zone-a", "region-1", false},
+		{"", "", "", "", false},
+	}
+	for _, test := range tests {
+		if matches := cnsvsphere.IsZoneRegionMatch(test.vmZone, test.vmRegion, test.zone, test.region); matches != test.expected {
+			t.Errorf("IsZoneRegionMatch(%q, %q, %q, %q) = %v, expected %v",
+				test.vmZone, test.vmRegion, test.zone, test.region, matches, test.expected)
+		}
+	}
+}
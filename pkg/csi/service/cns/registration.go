@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+const (
+	// eventReasonNodeRegistrationFailed is the reason of the event recorded on a Node whose VM
+	// keeps failing to be registered, which fails attaching volumes to the node
+	eventReasonNodeRegistrationFailed = "NodeRegistrationFailed"
+	// registrationFailuresPerEvent is the number of consecutive registration failures of a node
+	// after which an event is recorded on the node
+	registrationFailuresPerEvent = 5
+)
+
+// newRegistrationQueue returns the queue of the nodes to register, whose failed registrations
+// are retried with the delays of the retry policy in effect, without giving up
+func newRegistrationQueue() workqueue.RateLimitingInterface {
+	policy := backoff.Get()
+	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(policy.InitialInterval, policy.MaxInterval)
+	return workqueue.NewNamedRateLimitingQueue(rateLimiter, "node-registration")
+}
+
+// runRegistrationWorker registers the nodes of the registration queue until the queue is shut down
+func (nodes *Nodes) runRegistrationWorker() {
+	for {
+		item, shutdown := nodes.registrationQueue.Get()
+		if shutdown {
+			return
+		}
+		nodes.processRegistration(item.(string))
+		nodes.registrationQueue.Done(item)
+	}
+}
+
+// processRegistration registers the node with the given name, requeuing it with rate limiting on failure.
+// Nodes deleted meanwhile are dropped from the queue.
+func (nodes *Nodes) processRegistration(nodeName string) {
+	node, err := nodes.nodeLister.Get(nodeName)
+	if apierrors.IsNotFound(err) {
+		klog.V(2).Infof("Node %q was deleted before being registered", nodeName)
+		nodes.registrationQueue.Forget(nodeName)
+		return
+	}
+	if err == nil {
+		err = nodes.registerNode(node)
+	}
+	if err != nil {
+		failures := nodes.registrationQueue.NumRequeues(nodeName) + 1
+		klog.Warningf("Failed to register node:%q on attempt %d. err=%v", nodeName, failures, err)
+		if node != nil && failures%registrationFailuresPerEvent == 0 {
+			nodes.eventRecorder.Eventf(node, v1.EventTypeWarning, eventReasonNodeRegistrationFailed,
+				"Failed to find the VM of the node in vCenter after %d attempts, volumes cannot be attached to the node: %v", failures, err)
+		}
+		nodes.registrationQueue.AddRateLimited(nodeName)
+		return
+	}
+	nodes.registrationQueue.Forget(nodeName)
+	// Datastores and topology cached for the VM before the node was registered again may be stale
+	nodes.forgetNodeVM(nodeName)
+	// Node add events are received for all existing nodes when the informer starts,
+	// hence this audits every node VM at startup.
+	nodes.auditKeepAfterDeleteVM(nodeName)
+}
+
+// registerNode registers the node with the node manager under the first of its candidate UUIDs
+// a VM is found for
+func (nodes *Nodes) registerNode(node *v1.Node) (err error) {
+	for _, nodeUUID := range getNodeUUIDs(node) {
+		if err = nodes.cnsNodeManager.RegisterNode(nodeUUID, node.Name); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// getNodeReconcileInterval returns the node reconcile interval read from
+// X_CSI_NODE_RECONCILE_INTERVAL_MINUTES if set and valid, otherwise the default of 10 minutes
+func getNodeReconcileInterval() time.Duration {
+	intervalMinutes := common.DefaultNodeReconcileIntervalMinutes
+	if v := os.Getenv(common.EnvNodeReconcileIntervalMinutes); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			intervalMinutes = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default interval of %d minutes",
+				common.EnvNodeReconcileIntervalMinutes, v, intervalMinutes)
+		}
+	}
+	klog.V(2).Infof("Nodes will be reconciled every %d minutes", intervalMinutes)
+	return time.Duration(intervalMinutes) * time.Minute
+}
+
+// runNodeReconciler periodically repairs the registered nodes diverging from the Kubernetes nodes
+func (nodes *Nodes) runNodeReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		nodes.reconcileNodes()
+	}
+}
+
+// reconcileNodes registers the Kubernetes nodes which are not registered, or registered under a UUID
+// they no longer have, and unregisters the registered nodes which no longer exist, as node add or delete
+// events may have been missed while the informer was disconnected.
+func (nodes *Nodes) reconcileNodes() {
+	k8sNodes, err := nodes.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("reconcileNodes: failed to list nodes. err=%v", err)
+		return
+	}
+	registered := nodes.cnsNodeManager.GetRegisteredNodeUUIDs()
+	for _, node := range k8sNodes {
+		nodeUUID, ok := registered[node.Name]
+		delete(registered, node.Name)
+		if ok && hasNodeUUID(node, nodeUUID) {
+			continue
+		}
+		if ok {
+			klog.Infof("reconcileNodes: node %q is registered with stale UUID %q, registering it again", node.Name, nodeUUID)
+		} else {
+			klog.Infof("reconcileNodes: node %q is not registered, registering it", node.Name)
+		}
+		nodes.registrationQueue.Add(node.Name)
+	}
+	for nodeName := range registered {
+		klog.Infof("reconcileNodes: registered node %q no longer exists, unregistering it", nodeName)
+		nodes.unregisterNode(nodeName)
+	}
+}
+
+// hasNodeUUID returns whether the given UUID is one of the UUIDs the VM of the node may have
+func hasNodeUUID(node *v1.Node, nodeUUID string) bool {
+	for _, candidate := range getNodeUUIDs(node) {
+		if candidate == nodeUUID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// fakeNodeManager registers nodes under the UUIDs of its vms
+type fakeNodeManager struct {
+	cnsnode.Manager
+	vms        map[string]bool
+	registered map[string]string
+}
+
+func (m *fakeNodeManager) RegisterNode(nodeUUID string, nodeName string) error {
+	if !m.vms[nodeUUID] {
+		return errors.New("vm not found")
+	}
+	m.registered[nodeName] = nodeUUID
+	return nil
+}
+
+func (m *fakeNodeManager) UnregisterNode(nodeName string) error {
+	delete(m.registered, nodeName)
+	return nil
+}
+
+func (m *fakeNodeManager) GetRegisteredNodeUUIDs() map[string]string {
+	nodeUUIDs := make(map[string]string)
+	for nodeName, nodeUUID := range m.registered {
+		nodeUUIDs[nodeName] = nodeUUID
+	}
+	return nodeUUIDs
+}
+
+func (m *fakeNodeManager) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine, error) {
+	return nil, cnsnode.ErrNodeNotFound
+}
+
+func TestProcessRegistration(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: v1.NodeSpec{ProviderID: "vsphere://vm-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}, Spec: v1.NodeSpec{ProviderID: "vsphere://vm-2"}},
+	} {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager := &fakeNodeManager{vms: map[string]bool{"vm-1": true}, registered: make(map[string]string)}
+	recorder := record.NewFakeRecorder(10)
+	nodes := &Nodes{
+		cnsNodeManager:    manager,
+		nodeLister:        corelisters.NewNodeLister(indexer),
+		registrationQueue: workqueue.NewRateLimitingQueue(workqueue.NewItemFastSlowRateLimiter(0, 0, 0)),
+		eventRecorder:     recorder,
+	}
+	defer nodes.registrationQueue.ShutDown()
+
+	nodes.processRegistration("node-1")
+	if manager.registered["node-1"] != "vm-1" || nodes.registrationQueue.NumRequeues("node-1") != 0 {
+		t.Errorf("node-1 not registered: %v, requeues: %d", manager.registered, nodes.registrationQueue.NumRequeues("node-1"))
+	}
+	for i := 1; i <= registrationFailuresPerEvent; i++ {
+		nodes.processRegistration("node-2")
+		if requeues := nodes.registrationQueue.NumRequeues("node-2"); requeues != i {
+			t.Errorf("node-2 requeued %d times after %d failures", requeues, i)
+		}
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected 1 event after %d failures, got %d", registrationFailuresPerEvent, len(recorder.Events))
+	}
+	nodes.processRegistration("node-3")
+	if requeues := nodes.registrationQueue.NumRequeues("node-3"); requeues != 0 {
+		t.Errorf("deleted node-3 requeued %d times", requeues)
+	}
+}
+
+func TestReconcileNodes(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "registered"}, Spec: v1.NodeSpec{ProviderID: "vsphere://vm-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "missed-add"}, Spec: v1.NodeSpec{ProviderID: "vsphere://vm-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "stale-uuid"}, Spec: v1.NodeSpec{ProviderID: "vsphere://vm-3"}},
+	} {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager := &fakeNodeManager{registered: map[string]string{"registered": "vm-1", "stale-uuid": "", "missed-delete": "vm-4"}}
+	nodes := &Nodes{
+		cnsNodeManager:    manager,
+		nodeLister:        corelisters.NewNodeLister(indexer),
+		registrationQueue: workqueue.NewRateLimitingQueue(workqueue.NewItemFastSlowRateLimiter(0, 0, 0)),
+	}
+	defer nodes.registrationQueue.ShutDown()
+
+	nodes.reconcileNodes()
+	if _, ok := manager.registered["missed-delete"]; ok {
+		t.Errorf("deleted node still registered: %v", manager.registered)
+	}
+	queued := make(map[string]bool)
+	for nodes.registrationQueue.Len() > 0 {
+		item, _ := nodes.registrationQueue.Get()
+		queued[item.(string)] = true
+		nodes.registrationQueue.Done(item)
+	}
+	if expected := map[string]bool{"missed-add": true, "stale-uuid": true}; !reflect.DeepEqual(queued, expected) {
+		t.Errorf("queued nodes %v, expected %v", queued, expected)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+const (
+	// eventReasonVolumeRelocated is the reason of the event recorded on a PersistentVolume whose volume is found
+	// relocated to another datastore
+	eventReasonVolumeRelocated = "VolumeRelocated"
+	// eventReasonVolumeTopologyStale is the reason of the event recorded on a PersistentVolume whose node affinity
+	// does not admit the topology of the datastore its volume was relocated to
+	eventReasonVolumeTopologyStale = "VolumeTopologyStale"
+
+	// relocationQueryBatchSize is the number of volumes whose datastores are queried from CNS at once
+	relocationQueryBatchSize = 500
+)
+
+// getRelocationReconcileInterval returns the relocation reconcile interval read from
+// X_CSI_RELOCATION_RECONCILE_INTERVAL_MINUTES if set and valid, otherwise the default of 10 minutes
+func getRelocationReconcileInterval() time.Duration {
+	intervalMinutes := common.DefaultRelocationReconcileIntervalMinutes
+	if v := os.Getenv(common.EnvRelocationReconcileIntervalMinutes); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			intervalMinutes = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default interval of %d minutes",
+				common.EnvRelocationReconcileIntervalMinutes, v, intervalMinutes)
+		}
+	}
+	klog.V(2).Infof("Volume relocations will be checked every %d minutes", intervalMinutes)
+	return time.Duration(intervalMinutes) * time.Minute
+}
+
+// runRelocationReconciler periodically repairs the PVs of volumes relocated to another datastore
+func (c *controller) runRelocationReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.reconcileRelocations()
+	}
+}
+
+// reconcileRelocations records the URL of the datastore of each volume on its PV, and repairs the topology labels
+// of the PVs of volumes relocated to another datastore, e.g. by Storage vMotion. The syncer then updates the CNS
+// metadata of the volumes with the new labels. The node affinity of a PV cannot be changed, hence an event is
+// recorded on the PV if it does not admit the topology of the new datastore.
+func (c *controller) reconcileRelocations() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pvList, err := c.k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Relocation reconciler failed to list PersistentVolumes. err: %v", err)
+		return
+	}
+	pvs := make(map[*common.Manager]map[string]*v1.PersistentVolume)
+	for i := range pvList.Items {
+		pv := &pvList.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != common.DriverName || pv.DeletionTimestamp != nil ||
+			common.IsFileVolumeID(pv.Spec.CSI.VolumeHandle) {
+			continue
+		}
+		manager, cnsVolumeID, err := c.getVolumeManager(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			klog.Warningf("Relocation reconciler skips PV %q. err: %v", pv.Name, err)
+			continue
+		}
+		if pvs[manager] == nil {
+			pvs[manager] = make(map[string]*v1.PersistentVolume)
+		}
+		pvs[manager][cnsVolumeID] = pv
+	}
+	levels := common.GetTopologyLevels(c.manager.CnsConfig)
+	var topologies map[string][]map[string]string
+	topologiesLoaded := false
+	for manager, managerPVs := range pvs {
+		datastoreURLs, err := getVolumeDatastoreURLs(ctx, manager, managerPVs)
+		if err != nil {
+			klog.Warningf("Relocation reconciler failed to query volumes on vCenter %q. err: %v", manager.VcenterConfig.Host, err)
+			continue
+		}
+		for volumeID, pv := range managerPVs {
+			datastoreURL := datastoreURLs[volumeID]
+			previousURL := pv.Annotations[common.AnnDatastoreURL]
+			if datastoreURL == "" || datastoreURL == previousURL {
+				continue
+			}
+			// The datastore of the cached volume is stale
+			manager.VolumeManager.EvictVolume(volumeID)
+			pv = pv.DeepCopy()
+			if pv.Annotations == nil {
+				pv.Annotations = make(map[string]string)
+			}
+			pv.Annotations[common.AnnDatastoreURL] = datastoreURL
+			var topology map[string]string
+			if previousURL != "" {
+				klog.Warningf("Volume %q of PV %q was relocated from datastore %q to %q", volumeID, pv.Name, previousURL, datastoreURL)
+				if !topologiesLoaded {
+					topologies = c.getDatastoreTopologies(ctx, levels)
+					topologiesLoaded = true
+				}
+				topology = getRelocatedTopology(pv.Labels, topologies[datastoreURL], levels)
+				if len(topology) != 0 && pv.Labels == nil {
+					pv.Labels = make(map[string]string)
+				}
+				for key, value := range topology {
+					pv.Labels[key] = value
+				}
+			}
+			if _, err := c.k8sClient.CoreV1().PersistentVolumes().Update(pv); err != nil {
+				klog.Warningf("Relocation reconciler failed to update PV %q. err: %v", pv.Name, err)
+				continue
+			}
+			if previousURL == "" {
+				continue
+			}
+			c.eventRecorder.Eventf(pv, v1.EventTypeNormal, eventReasonVolumeRelocated,
+				"Volume was relocated from datastore %s to %s", previousURL, datastoreURL)
+			if len(topology) != 0 && !isNodeAffinitySatisfied(pv.Spec.NodeAffinity, topology) {
+				c.eventRecorder.Eventf(pv, v1.EventTypeWarning, eventReasonVolumeTopologyStale,
+					"Volume is now accessible from topology %v, which the node affinity of the PersistentVolume does not admit", topology)
+			}
+		}
+	}
+}
+
+// getVolumeDatastoreURLs returns the URL of the datastore of each of the given volumes registered with CNS on
+// the vCenter of the given manager, by volume id
+func getVolumeDatastoreURLs(ctx context.Context, manager *common.Manager, pvs map[string]*v1.PersistentVolume) (map[string]string, error) {
+	var volumeIDs []cnstypes.CnsVolumeId
+	for volumeID := range pvs {
+		volumeIDs = append(volumeIDs, cnstypes.CnsVolumeId{Id: volumeID})
+	}
+	datastoreURLs := make(map[string]string)
+	for start := 0; start < len(volumeIDs); start += relocationQueryBatchSize {
+		end := start + relocationQueryBatchSize
+		if end > len(volumeIDs) {
+			end = len(volumeIDs)
+		}
+		queryFilter := cnstypes.CnsQueryFilter{VolumeIds: volumeIDs[start:end]}
+		err := volume.ForEachVolumePage(ctx, manager.VolumeManager, queryFilter, func(volumes []cnstypes.CnsVolume) error {
+			for _, vol := range volumes {
+				datastoreURLs[vol.VolumeId.Id] = vol.DatastoreUrl
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return datastoreURLs, nil
+}
+
+// getDatastoreTopologies returns the topologies each datastore is accessible from, by datastore URL, out of the
+// topologies of the Kubernetes nodes. It returns nil if topology is not configured or cannot be determined.
+func (c *controller) getDatastoreTopologies(ctx context.Context, levels []common.TopologyLevel) map[string][]map[string]string {
+	if len(levels) == 0 {
+		return nil
+	}
+	nodeList, err := c.k8sClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Relocation reconciler failed to list nodes. err: %v", err)
+		return nil
+	}
+	seen := make(map[string]bool)
+	var requisite []*csi.Topology
+	for _, node := range nodeList.Items {
+		segments := make(map[string]string)
+		for _, level := range levels {
+			for _, key := range level.GetKeys() {
+				if value := node.Labels[key]; value != "" {
+					segments[key] = value
+				}
+			}
+		}
+		if len(segments) == 0 || seen[fmt.Sprint(segments)] {
+			continue
+		}
+		seen[fmt.Sprint(segments)] = true
+		requisite = append(requisite, &csi.Topology{Segments: segments})
+	}
+	if len(requisite) == 0 {
+		return nil
+	}
+	_, datastoreTopologyMap, err := c.nodeMgr.GetSharedDatastoresInTopology(ctx, &csi.TopologyRequirement{Requisite: requisite}, levels)
+	if err != nil {
+		klog.Warningf("Relocation reconciler failed to get the topologies of datastores. err: %v", err)
+		return nil
+	}
+	return datastoreTopologyMap
+}
+
+// getRelocatedTopology returns the topology of a PV with the given labels whose volume was relocated to a datastore
+// accessible from the given topologies: the topology in its labels if the datastore is accessible from it, whichever
+// form of the region and zone labels they use, otherwise the first of the topologies ordered by the given levels from the outermost. It returns nil if there
+// are no topologies.
+func getRelocatedTopology(labels map[string]string, topologies []map[string]string, levels []common.TopologyLevel) map[string]string {
+	if len(topologies) == 0 {
+		return nil
+	}
+	for _, topology := range topologies {
+		matches := true
+		for _, level := range levels {
+			if value := level.GetValue(topology); value != "" && level.GetValue(labels) != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return topology
+		}
+	}
+	sorted := append([]map[string]string(nil), topologies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		for _, level := range levels {
+			if left, right := level.GetValue(sorted[i]), level.GetValue(sorted[j]); left != right {
+				return left < right
+			}
+		}
+		return false
+	})
+	return sorted[0]
+}
+
+// isNodeAffinitySatisfied returns whether the node affinity of a PV admits nodes in the given topology.
+// Only the requirements on the keys of the topology are considered.
+func isNodeAffinitySatisfied(affinity *v1.VolumeNodeAffinity, topology map[string]string) bool {
+	if affinity == nil || affinity.Required == nil || len(affinity.Required.NodeSelectorTerms) == 0 {
+		return true
+	}
+	for _, term := range affinity.Required.NodeSelectorTerms {
+		if isNodeSelectorTermSatisfied(term, topology) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNodeSelectorTermSatisfied(term v1.NodeSelectorTerm, topology map[string]string) bool {
+	for _, expression := range term.MatchExpressions {
+		value, ok := topology[expression.Key]
+		if !ok {
+			continue
+		}
+		found := false
+		for _, v := range expression.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		switch expression.Operator {
+		case v1.NodeSelectorOpIn:
+			if !found {
+				return false
+			}
+		case v1.NodeSelectorOpNotIn:
+			if found {
+				return false
+			}
+		}
+	}
+	return true
+}
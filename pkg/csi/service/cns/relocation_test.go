@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cns
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+func TestGetRelocatedTopology(t *testing.T) {
+	east := map[string]string{csitypes.LabelZoneFailureDomain: "east", csitypes.LabelRegionFailureDomain: "us"}
+	west := map[string]string{csitypes.LabelZoneFailureDomain: "west", csitypes.LabelRegionFailureDomain: "us"}
+	westGA := map[string]string{csitypes.LabelTopologyZone: "west", csitypes.LabelTopologyRegion: "us"}
+	levels := []common.TopologyLevel{
+		{Category: "k8s-region", Key: csitypes.LabelRegionFailureDomain, Aliases: []string{csitypes.LabelTopologyRegion}},
+		{Category: "k8s-zone", Key: csitypes.LabelZoneFailureDomain, Aliases: []string{csitypes.LabelTopologyZone}},
+	}
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		topologies []map[string]string
+		expected   map[string]string
+	}{
+		{"no topologies", east, nil, nil},
+		{"labels still accessible", west, []map[string]string{east, west}, west},
+		{"GA labels still accessible", westGA, []map[string]string{east, west}, west},
+		{"labels no longer accessible", west, []map[string]string{east}, east},
+		{"no labels", nil, []map[string]string{west, east}, east},
+	}
+	for _, test := range tests {
+		if topology := getRelocatedTopology(test.labels, test.topologies, levels); !reflect.DeepEqual(topology, test.expected) {
+			t.Errorf("%s: getRelocatedTopology() = %v, expected %v", test.name, topology, test.expected)
+		}
+	}
+}
+
+func TestIsNodeAffinitySatisfied(t *testing.T) {
+	affinity := func(operator v1.NodeSelectorOperator, zones ...string) *v1.VolumeNodeAffinity {
+		return &v1.VolumeNodeAffinity{
+			Required: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: csitypes.LabelZoneFailureDomain, Operator: operator, Values: zones},
+						{Key: "kubernetes.io/hostname", Operator: v1.NodeSelectorOpIn, Values: []string{"node-1"}},
+					},
+				}},
+			},
+		}
+	}
+	west := map[string]string{csitypes.LabelZoneFailureDomain: "west", csitypes.LabelRegionFailureDomain: "us"}
+	tests := []struct {
+		name     string
+		affinity *v1.VolumeNodeAffinity
+		expected bool
+	}{
+		{"no affinity", nil, true},
+		{"zone in", affinity(v1.NodeSelectorOpIn, "east", "west"), true},
+		{"zone not in", affinity(v1.NodeSelectorOpIn, "east"), false},
+		{"zone excluded", affinity(v1.NodeSelectorOpNotIn, "west"), false},
+		{"other zone excluded", affinity(v1.NodeSelectorOpNotIn, "east"), true},
+	}
+	for _, test := range tests {
+		if satisfied := isNodeAffinitySatisfied(test.affinity, west); satisfied != test.expected {
+			t.Errorf("%s: isNodeAffinitySatisfied() = %v, expected %v", test.name, satisfied, test.expected)
+		}
+	}
+}
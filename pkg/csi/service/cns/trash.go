@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+// trashJanitorInterval is the interval at which volumes in trash are checked for expiry
+const trashJanitorInterval = time.Hour
+
+// getTrashRetention returns the trash retention period read from X_CSI_VOLUME_TRASH_RETENTION_DAYS
+// if set and valid, otherwise the default of 7 days
+func getTrashRetention() time.Duration {
+	retentionDays := common.DefaultVolumeTrashRetentionDays
+	if v := os.Getenv(common.EnvVolumeTrashRetentionDays); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			retentionDays = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default retention of %d days",
+				common.EnvVolumeTrashRetentionDays, v, retentionDays)
+		}
+	}
+	klog.V(2).Infof("Volumes in trash will be deleted after %d days", retentionDays)
+	return time.Duration(retentionDays) * 24 * time.Hour
+}
+
+// recordTrashedVolumeSpec records the spec of the PV of the volume with the given CSI and CNS ids before the
+// volume is moved to trash, so that it is re-applied to the PV created when the volume is restored
+func (c *controller) recordTrashedVolumeSpec(manager *common.Manager, volumeID string, cnsVolumeID string) error {
+	volume, err := manager.VolumeManager.RefreshVolume(cnsVolumeID)
+	if err != nil || volume == nil {
+		// Volumes no longer registered with CNS are already in trash, see TrashVolumeUtil
+		return err
+	}
+	// CNS volume name is the name of the PersistentVolume created by the external provisioner.
+	pv, err := c.k8sClient.CoreV1().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Warningf("PV %q of volume %q not found, the spec of the volume in trash is not recorded", volume.Name, volumeID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.trashedVolumeSpecs.Record(volumeID, k8s.NewTrashedVolumeSpec(pv, volume.StoragePolicyId))
+}
+
+// runTrashJanitor periodically deletes volumes which have been in trash for longer than the retention period
+func (c *controller) runTrashJanitor(retention time.Duration) {
+	ticker := time.NewTicker(trashJanitorInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		c.purgeTrashVolumes(retention)
+	}
+}
+
+// purgeTrashVolumes deletes the volumes of the cluster which have been in trash for longer than the retention
+// period from the datastores of each vCenter accessible to any node, as volumes provisioned with topology
+// requirements may be on datastores not shared by all nodes
+func (c *controller) purgeTrashVolumes(retention time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	datastores, err := c.nodeMgr.GetAccessibleDatastoresInK8SCluster(ctx)
+	if err != nil {
+		klog.Warningf("Trash janitor failed to get accessible datastores. err: %v", err)
+		return
+	}
+	for _, manager := range c.getManagers() {
+		vc, err := common.GetVCenter(ctx, manager)
+		if err != nil {
+			klog.Warningf("Trash janitor failed to get vCenter %q. err: %v", manager.VcenterConfig.Host, err)
+			continue
+		}
+		vcDatastores := getVirtualCenterDatastores(datastores, manager.VcenterConfig.Host)
+		purged := common.PurgeTrashVolumes(ctx, vc, manager.CnsConfig.Global.ClusterID, vcDatastores, retention)
+		for _, volumeID := range purged {
+			if err := c.trashedVolumeSpecs.Remove(c.encodeVolumeID(manager, volumeID)); err != nil {
+				klog.Warningf("Trash janitor failed to remove the spec of volume %q. err: %v", volumeID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// vmLocks serializes the operations reconfiguring the same node VM, such as attaches and detaches, which otherwise
+// race and fail with task-in-progress faults, while the operations on different VMs run in parallel.
+// A nil vmLocks does not serialize them.
+type vmLocks struct {
+	lock  sync.Mutex
+	locks map[string]*vmLock
+}
+
+// vmLock is the lock of a VM, held while a value is buffered in held, and the number of operations holding
+// or waiting for it, so that it is dropped once unused
+type vmLock struct {
+	held  chan struct{}
+	users int
+}
+
+func newVMLocks() *vmLocks {
+	return &vmLocks{locks: make(map[string]*vmLock)}
+}
+
+// run runs op once no other operation on the VM runs, and returns its error, or an error if ctx is done first
+func (l *vmLocks) run(ctx context.Context, vm *cnsvsphere.VirtualMachine, op func() error) error {
+	if l == nil {
+		return op()
+	}
+	key := vm.VirtualCenterHost + "/" + vm.UUID
+	l.lock.Lock()
+	vl, ok := l.locks[key]
+	if !ok {
+		vl = &vmLock{held: make(chan struct{}, 1)}
+		l.locks[key] = vl
+	}
+	vl.users++
+	l.lock.Unlock()
+	defer func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if vl.users--; vl.users == 0 {
+			delete(l.locks, key)
+		}
+	}()
+	start := time.Now()
+	select {
+	case vl.held <- struct{}{}:
+	case <-ctx.Done():
+		return status.Errorf(codes.DeadlineExceeded, "timed out waiting for the operations on node VM %v: %v", vm, ctx.Err())
+	}
+	defer func() {
+		<-vl.held
+	}()
+	if waited := time.Since(start); waited > time.Second {
+		klog.V(3).Infof("Waited %v for the operations on node VM %v", waited, vm)
+	}
+	return op()
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestVMLocks(t *testing.T) {
+	l := newVMLocks()
+	vm1 := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc", UUID: "vm-1"}
+	vm2 := &cnsvsphere.VirtualMachine{VirtualCenterHost: "vc", UUID: "vm-2"}
+
+	// Operations on the same VM are serialized
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.run(context.Background(), vm1, func() error {
+				lock.Lock()
+				if running++; running > maxRunning {
+					maxRunning = running
+				}
+				lock.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 1 {
+		t.Errorf("%d operations on the same VM ran at once, expected 1", maxRunning)
+	}
+
+	// Operations on different VMs run in parallel, and waiting operations give up once ctx is done
+	held := make(chan struct{})
+	release := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		l.run(context.Background(), vm1, func() error {
+			close(held)
+			<-release
+			return nil
+		})
+		close(released)
+	}()
+	<-held
+	done := make(chan error)
+	go func() {
+		done <- l.run(context.Background(), vm2, func() error { return nil })
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("operation on another VM failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("operation on another VM was blocked")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.run(ctx, vm1, func() error {
+		t.Errorf("operation ran while the VM was locked")
+		return nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("run() = %v while the VM was locked, expected DeadlineExceeded", err)
+	}
+	close(release)
+	<-released
+	if err := l.run(context.Background(), vm1, func() error { return nil }); err != nil {
+		t.Errorf("run() = %v after the VM was unlocked", err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("expected unused locks to be dropped, got %v", l.locks)
+	}
+}
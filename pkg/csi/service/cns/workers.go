@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// workers limits the number of concurrent operations of a controller subsystem.
+// A nil workers does not limit concurrency.
+type workers chan struct{}
+
+// newWorkers returns workers allowing the number of concurrent operations read from the
+// given environment variable if set and valid, otherwise the given default
+func newWorkers(envName string, defaultCount int) workers {
+	count := defaultCount
+	if v := os.Getenv(envName); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value > 0 {
+			count = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default of %d workers", envName, v, defaultCount)
+		}
+	}
+	klog.V(2).Infof("%s: %d workers", envName, count)
+	return make(workers, count)
+}
+
+// acquire blocks until a worker is available or the context is done
+func (w workers) acquire(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	select {
+	case w <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return status.Errorf(codes.DeadlineExceeded, "timed out waiting for an available worker: %v", ctx.Err())
+	}
+}
+
+// release frees up a worker acquired earlier
+func (w workers) release() {
+	if w == nil {
+		return
+	}
+	<-w
+}
+
+// priority is the priority of an operation waiting for a worker of priorityWorkers
+type priority int
+
+const (
+	// priorityHigh is the priority of operations freeing up nodes, i.e. detaches, which pod failovers and node drains wait for
+	priorityHigh priority = iota
+	// priorityNormal is the priority of attaches and deletes
+	priorityNormal
+	// priorityLow is the priority of new provisioning, which may come in bulk
+	priorityLow
+	numPriorities
+)
+
+// priorityNames are the names of the priorities used as metric labels
+var priorityNames = [numPriorities]string{"high", "normal", "low"}
+
+// priorityWorkers limits the number of concurrent operations like workers, but hands workers freed up
+// to the waiting operations of the highest priority first. A nil priorityWorkers does not limit concurrency.
+type priorityWorkers struct {
+	lock  sync.Mutex
+	count int
+	busy  int
+	// waiting are the operations waiting for a worker by priority, in arrival order
+	waiting [numPriorities][]chan struct{}
+}
+
+// newPriorityWorkers returns priorityWorkers allowing the number of concurrent operations read from the
+// given environment variable if set and valid, otherwise the given default. 0 does not limit concurrency.
+func newPriorityWorkers(envName string, defaultCount int) *priorityWorkers {
+	count := defaultCount
+	if v := os.Getenv(envName); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			count = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default of %d workers", envName, v, defaultCount)
+		}
+	}
+	klog.V(2).Infof("%s: %d workers", envName, count)
+	if count == 0 {
+		return nil
+	}
+	return &priorityWorkers{count: count}
+}
+
+// acquire blocks until a worker is available for an operation of the given priority or the context is done
+func (w *priorityWorkers) acquire(ctx context.Context, p priority) error {
+	if w == nil {
+		return nil
+	}
+	w.lock.Lock()
+	if w.busy < w.count && !w.hasWaiting(p) {
+		w.busy++
+		w.lock.Unlock()
+		return nil
+	}
+	granted := make(chan struct{})
+	w.waiting[p] = append(w.waiting[p], granted)
+	prometheus.VCenterOperationsWaiting.WithLabelValues(priorityNames[p]).Inc()
+	w.lock.Unlock()
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		for i, ch := range w.waiting[p] {
+			if ch == granted {
+				w.waiting[p] = append(w.waiting[p][:i], w.waiting[p][i+1:]...)
+				prometheus.VCenterOperationsWaiting.WithLabelValues(priorityNames[p]).Dec()
+				return status.Errorf(codes.DeadlineExceeded, "timed out waiting for an available worker: %v", ctx.Err())
+			}
+		}
+		// The worker was handed over meanwhile
+		return nil
+	}
+}
+
+// hasWaiting returns whether operations of the given priority or a higher one are waiting
+func (w *priorityWorkers) hasWaiting(p priority) bool {
+	for i := priorityHigh; i <= p; i++ {
+		if len(w.waiting[i]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// release hands a worker acquired earlier over to the waiting operation of the highest priority, if any
+func (w *priorityWorkers) release() {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for p := priorityHigh; p < numPriorities; p++ {
+		if len(w.waiting[p]) > 0 {
+			close(w.waiting[p][0])
+			w.waiting[p] = w.waiting[p][1:]
+			prometheus.VCenterOperationsWaiting.WithLabelValues(priorityNames[p]).Dec()
+			return
+		}
+	}
+	w.busy--
+}
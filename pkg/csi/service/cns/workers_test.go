@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityWorkers(t *testing.T) {
+	w := &priorityWorkers{count: 1}
+	ctx := context.Background()
+	if err := w.acquire(ctx, priorityLow); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	// Queue operations of all priorities while the only worker is busy
+	order := make(chan priority, 3)
+	var wg sync.WaitGroup
+	for _, p := range []priority{priorityLow, priorityNormal, priorityHigh} {
+		wg.Add(1)
+		go func(p priority) {
+			defer wg.Done()
+			if err := w.acquire(ctx, p); err != nil {
+				t.Errorf("acquire failed: %v", err)
+				return
+			}
+			order <- p
+			w.release()
+		}(p)
+		waitForWaiting(t, w, p)
+	}
+	w.release()
+	var actual []priority
+	for i := 0; i < 3; i++ {
+		actual = append(actual, <-order)
+	}
+	wg.Wait()
+	expected := []priority{priorityHigh, priorityNormal, priorityLow}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("workers handed over in order %v, expected %v", actual, expected)
+	}
+	if w.busy != 0 {
+		t.Errorf("%d workers busy after all released, expected 0", w.busy)
+	}
+}
+
+func TestPriorityWorkersTimeout(t *testing.T) {
+	w := &priorityWorkers{count: 1}
+	if err := w.acquire(context.Background(), priorityHigh); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.acquire(ctx, priorityLow); err == nil {
+		t.Fatalf("acquire succeeded while the only worker is busy")
+	}
+	if len(w.waiting[priorityLow]) != 0 {
+		t.Errorf("timed out operation still waiting")
+	}
+	w.release()
+	if w.busy != 0 {
+		t.Errorf("%d workers busy after all released, expected 0", w.busy)
+	}
+}
+
+// waitForWaiting waits until an operation of the given priority waits for a worker
+func waitForWaiting(t *testing.T, w *priorityWorkers, p priority) {
+	for i := 0; i < 1000; i++ {
+		w.lock.Lock()
+		waiting := len(w.waiting[p])
+		w.lock.Unlock()
+		if waiting > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no operation of priority %d waiting", p)
+}
@@ -46,6 +46,11 @@ func ValidateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	if !IsValidVolumeCapabilities(volCaps) {
 		return status.Error(codes.InvalidArgument, "Volume capabilities not supported")
 	}
+	for _, volCap := range volCaps {
+		if err := ValidateMountFlags(volCap.GetMount().GetMountFlags()); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Volume capability mount flags not supported: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -133,3 +138,74 @@ func CheckAPI(version string) error {
 	}
 	return nil
 }
+
+// ValidateControllerExpandVolumeRequest is the helper function to validate
+// ControllerExpandVolumeRequest for all block controllers.
+// Function returns error if validation fails otherwise returns nil.
+func ValidateControllerExpandVolumeRequest(req *csi.ControllerExpandVolumeRequest) error {
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	} else if req.GetCapacityRange().GetRequiredBytes() <= 0 {
+		msg := "Required capacity is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	limitBytes := req.GetCapacityRange().GetLimitBytes()
+	if limitBytes != 0 && limitBytes < req.GetCapacityRange().GetRequiredBytes() {
+		msg := fmt.Sprintf("Capacity limit %d bytes is smaller than the required capacity %d bytes.",
+			limitBytes, req.GetCapacityRange().GetRequiredBytes())
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// ValidateVolumeCapabilitiesRequest is the helper function to validate
+// ValidateVolumeCapabilitiesRequest for all block controllers.
+// Function returns error if validation fails otherwise returns nil.
+func ValidateVolumeCapabilitiesRequest(req *csi.ValidateVolumeCapabilitiesRequest) error {
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	} else if len(req.VolumeCapabilities) == 0 {
+		msg := "Volume capabilities is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// ValidateCreateSnapshotRequest is the helper function to validate
+// CreateSnapshotRequest for all block controllers.
+// Function returns error if validation fails otherwise returns nil.
+func ValidateCreateSnapshotRequest(req *csi.CreateSnapshotRequest) error {
+	//check for required parameters
+	if len(req.Name) == 0 {
+		msg := "Snapshot name is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	} else if len(req.SourceVolumeId) == 0 {
+		msg := "Source volume ID is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// ValidateDeleteSnapshotRequest is the helper function to validate
+// DeleteSnapshotRequest for all block controllers.
+// Function returns error if validation fails otherwise returns nil.
+func ValidateDeleteSnapshotRequest(req *csi.DeleteSnapshotRequest) error {
+	//check for required parameters
+	if len(req.SnapshotId) == 0 {
+		msg := "Snapshot ID is a required parameter."
+		klog.Error(msg)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package common
 
 const (
+	// DriverName is the name of the CSI driver, which VolumeAttachments and PersistentVolumes of its volumes refer to.
+	DriverName = "csi.vsphere.vmware.com"
+
 	// MbInBytes is the number of bytes in one mebibyte.
 	MbInBytes = int64(1024 * 1024)
 
@@ -29,6 +32,9 @@ const (
 	// DiskTypeString is the value for the PersistentVolume's attribute "type"
 	DiskTypeString = "vSphere CNS Block Volume"
 
+	// FileDiskTypeString is the value for the PersistentVolume's attribute "type" of file volumes
+	FileDiskTypeString = "vSphere CNS File Volume"
+
 	// AttributeDiskType is a PersistentVolume's attribute.
 	AttributeDiskType = "type"
 
@@ -36,6 +42,11 @@ const (
 	// For Example: DatastoreURL: "ds:///vmfs/volumes/5c9bb20e-009c1e46-4b85-0200483b2a97/"
 	AttributeDatastoreURL = "datastoreurl"
 
+	// AttributeDatastoreCluster represents the name or inventory path of the Storage DRS datastore cluster
+	// in the StorageClass, whose member datastore to place the volume on is recommended by Storage DRS
+	// For Example: DatastoreCluster: "sdrs-pod-1"
+	AttributeDatastoreCluster = "datastorecluster"
+
 	// AttributeStoragePolicyName represents name of the Storage Policy in the Storage Class
 	// For Example: StoragePolicy: "vSAN Default Storage Policy"
 	AttributeStoragePolicyName = "storagepolicyname"
@@ -48,6 +59,56 @@ const (
 	// For Example: FsType: "ext4"
 	AttributeFsType = "fstype"
 
+	// AttributeMkfsOptions represents extra options of mkfs to format the volume with in the Storage Class
+	// For Example: mkfsOptions: "-i size=512"
+	AttributeMkfsOptions = "mkfsoptions"
+
+	// AttributeFsckMode represents what the node service does when checking the ext4, ext3 or xfs filesystem
+	// of the volume before mounting it finds errors in the Storage Class: "fail", "repair" or "skip".
+	// Filesystems are not checked if not specified.
+	// For Example: fsckMode: "repair"
+	AttributeFsckMode = "fsckmode"
+
+	// AttributeDiskFormat represents the provisioning type of the First Class Disk in the Storage Class:
+	// "thin", "zeroedthick" or "eagerzeroedthick". The provisioning type is left to CNS if not specified.
+	// For Example: diskformat: "eagerzeroedthick"
+	AttributeDiskFormat = "diskformat"
+
+	// AttributeChangedBlockTracking enables Changed Block Tracking on the First Class Disk of the volume in the
+	// Storage Class or on the source volume of the snapshots in the VolumeSnapshotClass, so that backup products
+	// can compute the blocks changed between snapshots. The node VMs need hardware version 7 or later.
+	// For Example: changedBlockTracking: "true"
+	AttributeChangedBlockTracking = "changedblocktracking"
+
+	// MinChangedBlockTrackingHardwareVersion is the minimum hardware version of the node VMs
+	// to which volumes with Changed Block Tracking enabled are attached
+	MinChangedBlockTrackingHardwareVersion = 7
+
+	// AttributePVCNamespace represents the namespace of the PVC, passed by the external-provisioner
+	// when it runs with --extra-create-metadata
+	AttributePVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+
+	// AttributeEphemeral is set to "true" in the volume context passed to NodePublishVolume by kubelet
+	// for CSI ephemeral inline volumes
+	AttributeEphemeral = "csi.storage.k8s.io/ephemeral"
+
+	// AttributeSize represents the size of a CSI ephemeral inline volume in the volume attributes of the pod spec
+	// For Example: size: "1Gi"
+	AttributeSize = "size"
+
+	// CreateMetadataPrefix is the prefix of the parameters passed by the external-provisioner
+	// when it runs with --extra-create-metadata
+	CreateMetadataPrefix = "csi.storage.k8s.io/"
+
+	// FsckModeSkip is the fsck mode of volumes whose filesystem is not checked before being mounted
+	FsckModeSkip = "skip"
+
+	// FsckModeFail is the fsck mode of volumes which fail to be staged if their filesystem has errors
+	FsckModeFail = "fail"
+
+	// FsckModeRepair is the fsck mode of volumes whose filesystem errors are repaired before they are mounted
+	FsckModeRepair = "repair"
+
 	// DefaultFsType represents the default filesystem type which will be used to format the volume
 	// during mount if user does not specify the filesystem type in the Storage Class
 	DefaultFsType = "ext4"
@@ -62,6 +123,35 @@ const (
 	// BlockVolumeType is the VolumeType for CNS Volume
 	BlockVolumeType = "BLOCK"
 
+	// FileVolumeType is the VolumeType for CNS File Volume backed by a vSAN file share
+	FileVolumeType = "FILE"
+
+	// FileVolumePrefix is the prefix of the ids CNS gives to file volumes.
+	// For Example: file:53bf6fb7-8ff8-4e09-9a21-4a3f3bde3d53
+	FileVolumePrefix = "file:"
+
+	// VirtualCenterSeparator separates the CNS volume id from the host of the vCenter owning the volume
+	// in the ids of volumes of vCenters other than the first one of the config.
+	// For Example: 4b1d6e16-0a3e-4f3c-8e0b-8d2d5e4b1c1a@vcenter2.example.com
+	VirtualCenterSeparator = "@"
+
+	// VsanDatastoreURLPrefix is the prefix of the URL of vSAN datastores, on which vSAN file shares are created
+	VsanDatastoreURLPrefix = "ds:///vmfs/volumes/vsan:"
+
+	// VvolDatastoreURLPrefix is the prefix of the URL of vVol datastores
+	VvolDatastoreURLPrefix = "ds:///vmfs/volumes/vvol:"
+
+	// Nfsv4AccessPointKey is the key of the NFSv4.1 access point of a vSAN file share
+	Nfsv4AccessPointKey = "NFSv4.1"
+
+	// Nfsv4AccessPoint is the publish context attribute holding the NFSv4.1 access point of a file volume,
+	// which NodePublishVolume mounts.
+	// For Example: nfsv4accesspoint: "10.83.28.38:/52d7e15c-d282-3bae-f64d-8851ad9d352c"
+	Nfsv4AccessPoint = "nfsv4accesspoint"
+
+	// NfsV4FsType is the filesystem type file volumes are mounted with
+	NfsV4FsType = "nfs4"
+
 	// MinSupportedVCenterMajor is the minimum, major version of vCenter
 	// on which CNS is supported.
 	MinSupportedVCenterMajor int = 6
@@ -72,4 +162,200 @@ const (
 
 	// MinSupportedVCenterPatch is the patch version supported with MinSupportedVCenterMajor and MinSupportedVCenterMinor
 	MinSupportedVCenterPatch int = 3
+
+	// AnnDeleteDisk is the PersistentVolume annotation which, when set to "false", makes DeleteVolume
+	// remove the CNS volume and its metadata but preserve the underlying First Class Disk.
+	AnnDeleteDisk = "cns.vmware.com/delete-disk"
+
+	// AnnDatastoreURL is the PersistentVolume annotation recording the URL of the datastore the volume was last
+	// found on by the controller, so that volumes relocated by Storage vMotion are detected.
+	AnnDatastoreURL = "cns.vmware.com/datastore-url"
+
+	// AnnDrainPending is the Node annotation or taint key which, when set on a node about to be drained,
+	// makes the controller detach volumes from the node VM as soon as no running pod on the node uses them.
+	AnnDrainPending = "cns.vmware.com/drain-pending"
+
+	// AnnMaxVolumes is the Node annotation which caps the number of volumes the node service reports can be
+	// attached to the node, e.g. on nodes which also have in-tree vSphere volumes attached.
+	AnnMaxVolumes = "cns.vmware.com/max-volumes"
+
+	// EnvVolumeReclaimMode is the environment variable to set the reclaim mode of the controller.
+	EnvVolumeReclaimMode = "X_CSI_VOLUME_RECLAIM_MODE"
+
+	// VolumeReclaimModeDelete deletes the underlying First Class Disk on DeleteVolume. This is the default.
+	VolumeReclaimModeDelete = "delete"
+
+	// VolumeReclaimModeDetachOnly preserves the underlying First Class Disk on DeleteVolume.
+	VolumeReclaimModeDetachOnly = "detach-only"
+
+	// VolumeReclaimModeTrash renames the underlying First Class Disk on DeleteVolume and
+	// defers its deletion until the trash retention period has elapsed.
+	VolumeReclaimModeTrash = "trash"
+
+	// EnvVolumeTrashRetentionDays is the environment variable to set the number of days
+	// a First Class Disk is kept in trash before it is deleted.
+	EnvVolumeTrashRetentionDays = "X_CSI_VOLUME_TRASH_RETENTION_DAYS"
+
+	// DefaultVolumeTrashRetentionDays is the default number of days a First Class Disk is kept in trash.
+	DefaultVolumeTrashRetentionDays = 7
+
+	// TrashVolumeNamePrefix is the prefix of the name given to a First Class Disk moved to trash, followed by
+	// the tag of the cluster and the time of deletion.
+	// For Example: cns-trash-7c3f5e2a-1570000000-pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba
+	TrashVolumeNamePrefix = "cns-trash-"
+
+	// TrashedVolumeSpecsName is the name of the ConfigMap recording the spec of the PersistentVolume of each
+	// volume in trash, re-applied to the PersistentVolume created when the volume is restored.
+	TrashedVolumeSpecsName = "vsphere-csi-trashed-volumes"
+
+	// SnapshotIDSeparator separates the volume id and the First Class Disk snapshot id in CSI snapshot ids.
+	// For Example: 98e5df87-88e8-49a4-ae54-51037a204cab+8d2a8b8c-5d4f-4b5e-9d8e-4b6f8b0b9c4a
+	SnapshotIDSeparator = "+"
+
+	// SnapshotDescriptionSeparator separates the name of a snapshot and the capacity in MB of its volume at the
+	// time of the snapshot in the description of First Class Disk snapshots.
+	// For Example: snapshot-5b5c2e4a-9d1f-4b0e-8f3a-2c6d7e8f9a0b@10240
+	SnapshotDescriptionSeparator = "@"
+
+	// EnvProvisioningWorkers is the environment variable to set the number of concurrent
+	// CreateVolume and DeleteVolume operations of the controller.
+	EnvProvisioningWorkers = "X_CSI_PROVISIONING_WORKERS"
+
+	// DefaultProvisioningWorkers is the default number of concurrent CreateVolume and DeleteVolume operations.
+	DefaultProvisioningWorkers = 32
+
+	// EnvAttachWorkers is the environment variable to set the number of concurrent
+	// ControllerPublishVolume and ControllerUnpublishVolume operations of the controller.
+	EnvAttachWorkers = "X_CSI_ATTACH_WORKERS"
+
+	// DefaultAttachWorkers is the default number of concurrent ControllerPublishVolume and ControllerUnpublishVolume operations.
+	DefaultAttachWorkers = 32
+
+	// EnvVCenterWorkers is the environment variable to set the number of concurrent vCenter operations of the
+	// controller across provisioning and attach operations. Once they are all busy, detaches are served first,
+	// then attaches and deletes, then creates. 0 does not limit them.
+	EnvVCenterWorkers = "X_CSI_VCENTER_WORKERS"
+
+	// DefaultVCenterWorkers is the default number of concurrent vCenter operations of the controller.
+	DefaultVCenterWorkers = 48
+
+	// EnvAttachmentReconcileIntervalMinutes is the environment variable to set the number of minutes between
+	// comparisons of the VolumeAttachments of the driver with the volumes attached to node VMs. 0 disables them.
+	EnvAttachmentReconcileIntervalMinutes = "X_CSI_ATTACHMENT_RECONCILE_INTERVAL_MINUTES"
+
+	// DefaultAttachmentReconcileIntervalMinutes is the default number of minutes between attachment reconciliations.
+	DefaultAttachmentReconcileIntervalMinutes = 10
+
+	// EnvRelocationReconcileIntervalMinutes is the environment variable to set the number of minutes between
+	// checks of the datastores of volumes for relocations by Storage vMotion. 0 disables them.
+	EnvRelocationReconcileIntervalMinutes = "X_CSI_RELOCATION_RECONCILE_INTERVAL_MINUTES"
+
+	// DefaultRelocationReconcileIntervalMinutes is the default number of minutes between relocation checks.
+	DefaultRelocationReconcileIntervalMinutes = 10
+
+	// EnvNodeReconcileIntervalMinutes is the environment variable to set the number of minutes between
+	// comparisons of the Kubernetes nodes with the nodes registered by the controller. 0 disables them.
+	EnvNodeReconcileIntervalMinutes = "X_CSI_NODE_RECONCILE_INTERVAL_MINUTES"
+
+	// DefaultNodeReconcileIntervalMinutes is the default number of minutes between node reconciliations.
+	DefaultNodeReconcileIntervalMinutes = 10
+
+	// EnvDatastoreQuarantineThreshold is the environment variable to set the number of CreateVolume failures on a
+	// datastore, among its last 10 CreateVolume outcomes, which keeps it out of volume placement. 0 disables quarantine.
+	EnvDatastoreQuarantineThreshold = "X_CSI_DATASTORE_QUARANTINE_THRESHOLD"
+
+	// DefaultDatastoreQuarantineThreshold is the default number of failures which quarantines a datastore.
+	DefaultDatastoreQuarantineThreshold = 5
+
+	// EnvDatastoreQuarantineMinutes is the environment variable to set the number of minutes after which
+	// a quarantined datastore is probed, and released if it is accessible.
+	EnvDatastoreQuarantineMinutes = "X_CSI_DATASTORE_QUARANTINE_MINUTES"
+
+	// DefaultDatastoreQuarantineMinutes is the default number of minutes a datastore is quarantined for.
+	DefaultDatastoreQuarantineMinutes = 10
+
+	// EnvEphemeralStateDir is the environment variable to set the directory where the node service keeps
+	// the state of the CSI ephemeral inline volumes published on the node, to clean them up on unpublish.
+	EnvEphemeralStateDir = "X_CSI_EPHEMERAL_STATE_DIR"
+
+	// DefaultEphemeralStateDir is the default directory of the state of ephemeral inline volumes, in the
+	// plugin directory of the node service.
+	DefaultEphemeralStateDir = "/csi/ephemeral"
+
+	// EnvDiskDiscoveryTimeoutSeconds is the environment variable to set the number of seconds the node service
+	// waits for the disk of an attached volume to show up on the node, as the guest OS may only see the disk
+	// some time after ControllerPublishVolume completes.
+	EnvDiskDiscoveryTimeoutSeconds = "X_CSI_DISK_DISCOVERY_TIMEOUT_SECONDS"
+
+	// DefaultDiskDiscoveryTimeoutSeconds is the default number of seconds to wait for the disk of a volume.
+	DefaultDiskDiscoveryTimeoutSeconds = 60
+
+	// EnvConfigSecretName is the environment variable to set the name of the secret holding the config file
+	// of the driver, which the controller watches to rotate the vCenter credentials without restarting.
+	EnvConfigSecretName = "X_CSI_CONFIG_SECRET_NAME"
+
+	// DefaultConfigSecretName is the default name of the secret holding the config file.
+	DefaultConfigSecretName = "vsphere-config-secret"
+
+	// EnvConfigSecretNamespace is the environment variable to set the namespace of the secret holding the
+	// config file of the driver.
+	EnvConfigSecretNamespace = "X_CSI_CONFIG_SECRET_NAMESPACE"
+
+	// DefaultConfigSecretNamespace is the default namespace of the secret holding the config file.
+	DefaultConfigSecretNamespace = "kube-system"
+
+	// EnvTaskJournalName is the environment variable to set the name of the ConfigMap the controller records
+	// the CNS tasks in flight in, in the namespace of the secret holding the config file, so that it resumes
+	// waiting on them after restarting. An empty name disables the journal.
+	EnvTaskJournalName = "X_CSI_TASK_JOURNAL_NAME"
+
+	// DefaultTaskJournalName is the default name of the ConfigMap of the task journal.
+	DefaultTaskJournalName = "vsphere-csi-task-journal"
+
+	// EnvAttachBatchWindowMillis is the environment variable to set the number of milliseconds the controller
+	// waits for other ControllerPublishVolume calls to the same node after one arrives, so that the volumes are
+	// attached in a single CNS task. 0 attaches each volume on its own.
+	EnvAttachBatchWindowMillis = "X_CSI_ATTACH_BATCH_WINDOW_MS"
+
+	// DefaultAttachBatchWindowMillis is the default number of milliseconds attaches to the same node are batched in.
+	DefaultAttachBatchWindowMillis = 100
+
+	// EnvNodeDatastoreCacheTTLSeconds is the environment variable to set the number of seconds the datastores
+	// accessible to a node VM are cached for volume placement. 0 disables the cache.
+	EnvNodeDatastoreCacheTTLSeconds = "X_CSI_NODE_DATASTORE_CACHE_TTL_SECONDS"
+
+	// DefaultNodeDatastoreCacheTTLSeconds is the default number of seconds the datastores of a node VM are cached.
+	DefaultNodeDatastoreCacheTTLSeconds = 300
+
+	// EnvNodeTopologyCacheTTLSeconds is the environment variable to set the number of seconds the zone and region
+	// of a node VM are cached for volume placement. 0 disables the cache.
+	EnvNodeTopologyCacheTTLSeconds = "X_CSI_NODE_TOPOLOGY_CACHE_TTL_SECONDS"
+
+	// DefaultNodeTopologyCacheTTLSeconds is the default number of seconds the zone and region of a node VM are cached.
+	DefaultNodeTopologyCacheTTLSeconds = 300
+
+	// EnvPlacementStrategy is the environment variable to set how the datastore of a new volume is chosen among
+	// the candidate datastores when the config file sets no placement strategy: most-free-space, round-robin,
+	// weighted, policy, random, or cns to leave the choice to CNS.
+	EnvPlacementStrategy = "X_CSI_PLACEMENT_STRATEGY"
+
+	// DefaultPlacementStrategy is the default strategy volumes are placed with.
+	DefaultPlacementStrategy = "most-free-space"
+
+	// EnvDatastoreMinFreeMB is the environment variable to set the number of MB a datastore must have left free
+	// once a new volume is fully allocated on it to be a candidate for the volume. 0 disables the check.
+	EnvDatastoreMinFreeMB = "X_CSI_DATASTORE_MIN_FREE_MB"
+
+	// DefaultDatastoreMinFreeMB is the default number of MB datastores must have left free.
+	DefaultDatastoreMinFreeMB = 0
+
+	// EnvKubeletDir is the environment variable to set the root directory of kubelet, under which the node
+	// service looks for mounts of volumes whose disk is gone when it starts.
+	EnvKubeletDir = "X_CSI_KUBELET_DIR"
+
+	// DefaultKubeletDir is the default root directory of kubelet.
+	DefaultKubeletDir = "/var/lib/kubelet"
+
+	// EnvEnableChannelz is the environment variable to serve the gRPC channelz service on the CSI endpoint.
+	EnvEnableChannelz = "X_CSI_ENABLE_CHANNELZ"
 )
@@ -0,0 +1,301 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+const (
+	// PlacementStrategyMostFreeSpace places volumes on the candidate datastore with the most free space
+	PlacementStrategyMostFreeSpace = "most-free-space"
+	// PlacementStrategyRoundRobin places successive volumes on successive candidate datastores
+	PlacementStrategyRoundRobin = "round-robin"
+	// PlacementStrategyWeighted places volumes on a random candidate datastore, with a probability proportional
+	// to its free space times its configured weight
+	PlacementStrategyWeighted = "weighted"
+	// PlacementStrategyPolicy places volumes with the strategy configured for their storage policy
+	PlacementStrategyPolicy = "policy"
+	// PlacementStrategyRandom places volumes on a random candidate datastore
+	PlacementStrategyRandom = "random"
+	// PlacementStrategyCNS leaves the choice among the candidate datastores to CNS
+	PlacementStrategyCNS = "cns"
+)
+
+// PlacementStrategy orders the candidate datastores of a volume by preference. Volumes are placed on the first
+// datastore, unless the choice is left to CNS. Implementations are safe for concurrent use.
+type PlacementStrategy interface {
+	// Order returns the candidate datastores for the volume of the spec in order of preference. It must not
+	// modify the given slice.
+	Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo
+}
+
+// PlacementStrategyFactory returns a placement strategy configured by the config
+type PlacementStrategyFactory func(cfg *config.Config) (PlacementStrategy, error)
+
+// placementStrategies are the registered placement strategies by name
+var placementStrategies = make(map[string]PlacementStrategyFactory)
+
+func init() {
+	RegisterPlacementStrategy(PlacementStrategyMostFreeSpace, func(*config.Config) (PlacementStrategy, error) {
+		return mostFreeSpaceStrategy{}, nil
+	})
+	RegisterPlacementStrategy(PlacementStrategyRoundRobin, func(*config.Config) (PlacementStrategy, error) {
+		return &roundRobinStrategy{}, nil
+	})
+	RegisterPlacementStrategy(PlacementStrategyWeighted, newWeightedStrategy)
+	RegisterPlacementStrategy(PlacementStrategyPolicy, newPolicyStrategy)
+	RegisterPlacementStrategy(PlacementStrategyRandom, func(*config.Config) (PlacementStrategy, error) {
+		return &weightedStrategy{float64: rand.Float64}, nil
+	})
+	RegisterPlacementStrategy(PlacementStrategyCNS, func(*config.Config) (PlacementStrategy, error) {
+		return cnsStrategy{}, nil
+	})
+}
+
+// RegisterPlacementStrategy registers the placement strategy returned by the factory under the given name,
+// for it to be selected in the config. It must be called at init time.
+func RegisterPlacementStrategy(name string, factory PlacementStrategyFactory) {
+	placementStrategies[name] = factory
+}
+
+// newPlacementStrategy returns the registered placement strategy with the given name
+func newPlacementStrategy(name string, cfg *config.Config) (PlacementStrategy, error) {
+	factory, ok := placementStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown placement strategy %q", name)
+	}
+	return factory(cfg)
+}
+
+// DatastorePlacement chooses the datastore volumes are placed on among the candidate datastores with a placement
+// strategy, and keeps datastores running out of space out of placement
+type DatastorePlacement struct {
+	name     string
+	strategy PlacementStrategy
+	// minFreeBytes is the free space a datastore must have left once the volume is fully allocated, 0 if not checked
+	minFreeBytes int64
+}
+
+// NewDatastorePlacement returns a DatastorePlacement with the placement strategy of the config, or the one set by
+// X_CSI_PLACEMENT_STRATEGY if the config sets none, and the minimum free space set by X_CSI_DATASTORE_MIN_FREE_MB
+func NewDatastorePlacement(cfg *config.Config) (*DatastorePlacement, error) {
+	name := cfg.Placement.Strategy
+	if name == "" {
+		name = DefaultPlacementStrategy
+		if v := os.Getenv(EnvPlacementStrategy); v != "" {
+			if _, ok := placementStrategies[v]; ok {
+				name = v
+			} else {
+				klog.Warningf("%s %s is invalid, will use the default of %s", EnvPlacementStrategy, v, name)
+			}
+		}
+	}
+	strategy, err := newPlacementStrategy(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	minFreeMB := getEnvInt(EnvDatastoreMinFreeMB, DefaultDatastoreMinFreeMB)
+	klog.V(2).Infof("Volumes will be placed with strategy %s on datastores with at least %d MB left free",
+		name, minFreeMB)
+	return newDatastorePlacement(name, strategy, int64(minFreeMB)*MbInBytes), nil
+}
+
+func newDatastorePlacement(name string, strategy PlacementStrategy, minFreeBytes int64) *DatastorePlacement {
+	return &DatastorePlacement{name: name, strategy: strategy, minFreeBytes: minFreeBytes}
+}
+
+// Order returns the candidate datastores for the volume of the spec in order of preference of the strategy,
+// without the datastores which would have less than the minimum free space left once the volume is fully
+// allocated. A nil placement returns the datastores as is.
+func (p *DatastorePlacement) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) ([]*vsphere.DatastoreInfo, error) {
+	if p == nil || len(datastores) == 0 {
+		return datastores, nil
+	}
+	var candidates []*vsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if p.minFreeBytes > 0 && datastore.Info.FreeSpace-spec.CapacityMB*MbInBytes < p.minFreeBytes {
+			klog.V(4).Infof("Skipping datastore %s with %d bytes free for volume %s of %d MB",
+				datastore.Info.Url, datastore.Info.FreeSpace, spec.Name, spec.CapacityMB)
+			continue
+		}
+		candidates = append(candidates, datastore)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("none of the %d candidate datastores can hold volume %s of %d MB and keep %d MB free",
+			len(datastores), spec.Name, spec.CapacityMB, p.minFreeBytes/MbInBytes)
+	}
+	return p.strategy.Order(spec, candidates), nil
+}
+
+// Choose returns the datastores to pass to CNS CreateVolume among the candidate datastores returned by Order:
+// the preferred one, or all of them if the choice is left to CNS
+func (p *DatastorePlacement) Choose(candidates []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	if p == nil || p.name == PlacementStrategyCNS || len(candidates) == 0 {
+		return candidates
+	}
+	klog.V(4).Infof("Placing volume on datastore %s with %d bytes free", candidates[0].Info.Url, candidates[0].Info.FreeSpace)
+	return candidates[:1]
+}
+
+// mostFreeSpaceStrategy orders the datastores by decreasing free space
+type mostFreeSpaceStrategy struct{}
+
+func (mostFreeSpaceStrategy) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	ordered := append([]*vsphere.DatastoreInfo(nil), datastores...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Info.FreeSpace > ordered[j].Info.FreeSpace
+	})
+	return ordered
+}
+
+// roundRobinStrategy orders the datastores by URL, starting with the datastore after the one preferred last time
+type roundRobinStrategy struct {
+	next uint64
+}
+
+func (s *roundRobinStrategy) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	sorted := append([]*vsphere.DatastoreInfo(nil), datastores...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Info.Url < sorted[j].Info.Url
+	})
+	first := int((atomic.AddUint64(&s.next, 1) - 1) % uint64(len(sorted)))
+	return append(sorted[first:], sorted[:first]...)
+}
+
+// weightedStrategy prefers a random datastore, with a probability proportional to its free space times its weight,
+// or with the same probability for all datastores if weights is nil
+type weightedStrategy struct {
+	// weights maps datastore URLs to their weight, datastores not listed weighing 1
+	weights map[string]float64
+	float64 func() float64
+}
+
+// newWeightedStrategy returns a weightedStrategy with the datastore weights of the config
+func newWeightedStrategy(cfg *config.Config) (PlacementStrategy, error) {
+	pairs, err := splitPairs(cfg.Placement.DatastoreWeights)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64)
+	for _, pair := range pairs {
+		weight, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q of datastore %s", pair[1], pair[0])
+		}
+		weights[pair[0]] = weight
+	}
+	return &weightedStrategy{weights: weights, float64: rand.Float64}, nil
+}
+
+func (s *weightedStrategy) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	ordered := append([]*vsphere.DatastoreInfo(nil), datastores...)
+	weights := make([]float64, len(ordered))
+	var total float64
+	for i, datastore := range ordered {
+		weights[i] = 1
+		if s.weights != nil {
+			weight, ok := s.weights[datastore.Info.Url]
+			if !ok {
+				weight = 1
+			}
+			weights[i] = weight * float64(datastore.Info.FreeSpace)
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return ordered
+	}
+	r := s.float64() * total
+	for i := range ordered {
+		if r < weights[i] || i == len(ordered)-1 {
+			ordered[0], ordered[i] = ordered[i], ordered[0]
+			break
+		}
+		r -= weights[i]
+	}
+	return ordered
+}
+
+// policyStrategy orders the datastores with the strategy of the storage policy of the volume, and with
+// most-free-space for volumes of other storage policies or without storage policy
+type policyStrategy struct {
+	byPolicy map[string]PlacementStrategy
+	fallback PlacementStrategy
+}
+
+// newPolicyStrategy returns a policyStrategy with the storage policy strategies of the config
+func newPolicyStrategy(cfg *config.Config) (PlacementStrategy, error) {
+	pairs, err := splitPairs(cfg.Placement.PolicyStrategies)
+	if err != nil {
+		return nil, err
+	}
+	s := &policyStrategy{byPolicy: make(map[string]PlacementStrategy), fallback: mostFreeSpaceStrategy{}}
+	for _, pair := range pairs {
+		if pair[1] == PlacementStrategyPolicy || pair[1] == PlacementStrategyCNS {
+			return nil, fmt.Errorf("placement strategy %q cannot be used for storage policy %q", pair[1], pair[0])
+		}
+		strategy, err := newPlacementStrategy(pair[1], cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.byPolicy[pair[0]] = strategy
+	}
+	return s, nil
+}
+
+func (s *policyStrategy) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	if strategy, ok := s.byPolicy[spec.StoragePolicyName]; ok {
+		return strategy.Order(spec, datastores)
+	}
+	return s.fallback.Order(spec, datastores)
+}
+
+// cnsStrategy keeps the order of the datastores, the choice being left to CNS
+type cnsStrategy struct{}
+
+func (cnsStrategy) Order(spec *CreateVolumeSpec, datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	return datastores
+}
+
+// splitPairs returns the key and value of each key=value pair of the comma separated list
+func splitPairs(list string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not a key=value pair", item)
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func newDatastoreWithFreeSpace(url string, freeMB int64) *vsphere.DatastoreInfo {
+	datastore := newDatastoreInfo(url)
+	datastore.Info.FreeSpace = freeMB * MbInBytes
+	return datastore
+}
+
+func getDatastoreURLs(datastores []*vsphere.DatastoreInfo) []string {
+	var urls []string
+	for _, datastore := range datastores {
+		urls = append(urls, datastore.Info.Url)
+	}
+	return urls
+}
+
+func TestDatastorePlacement(t *testing.T) {
+	datastores := []*vsphere.DatastoreInfo{
+		newDatastoreWithFreeSpace("ds:///a/", 100),
+		newDatastoreWithFreeSpace("ds:///b/", 500),
+		newDatastoreWithFreeSpace("ds:///c/", 300),
+	}
+	tests := []struct {
+		name        string
+		strategy    string
+		minFreeMB   int64
+		capacityMB  int64
+		expected    []string
+		expectedErr bool
+	}{
+		{"most free space", PlacementStrategyMostFreeSpace, 0, 1000, []string{"ds:///b/", "ds:///c/", "ds:///a/"}, false},
+		{"cns keeps order", PlacementStrategyCNS, 0, 10, []string{"ds:///a/", "ds:///b/", "ds:///c/"}, false},
+		{"below min free space", PlacementStrategyCNS, 100, 150, []string{"ds:///b/", "ds:///c/"}, false},
+		{"none with min free space", PlacementStrategyMostFreeSpace, 100, 450, nil, true},
+	}
+	for _, test := range tests {
+		strategy, err := newPlacementStrategy(test.strategy, &config.Config{})
+		if err != nil {
+			t.Fatalf("%s: newPlacementStrategy() failed: %v", test.name, err)
+		}
+		p := newDatastorePlacement(test.strategy, strategy, test.minFreeMB*MbInBytes)
+		candidates, err := p.Order(&CreateVolumeSpec{Name: "pvc-1", CapacityMB: test.capacityMB}, datastores)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("%s: Order() err = %v, expected error %v", test.name, err, test.expectedErr)
+			continue
+		}
+		if urls := getDatastoreURLs(candidates); !reflect.DeepEqual(urls, test.expected) {
+			t.Errorf("%s: Order() = %v, expected %v", test.name, urls, test.expected)
+		}
+	}
+	if datastores[0].Info.Url != "ds:///a/" {
+		t.Errorf("Order() reordered the given datastores")
+	}
+
+	mostFreeSpace := newDatastorePlacement(PlacementStrategyMostFreeSpace, mostFreeSpaceStrategy{}, 0)
+	if chosen := mostFreeSpace.Choose(datastores); len(chosen) != 1 || chosen[0] != datastores[0] {
+		t.Errorf("Choose() = %v, expected only %v", chosen, datastores[0])
+	}
+	if chosen := newDatastorePlacement(PlacementStrategyCNS, cnsStrategy{}, 0).Choose(datastores); len(chosen) != 3 {
+		t.Errorf("Choose() with strategy cns returned %d datastores, expected 3", len(chosen))
+	}
+	var p *DatastorePlacement
+	if candidates, err := p.Order(&CreateVolumeSpec{}, datastores); err != nil || len(p.Choose(candidates)) != 3 {
+		t.Errorf("nil DatastorePlacement did not return the datastores as is")
+	}
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	datastores := []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///c/"), newDatastoreInfo("ds:///a/"), newDatastoreInfo("ds:///b/")}
+	s := &roundRobinStrategy{}
+	for i, expected := range []string{"ds:///a/", "ds:///b/", "ds:///c/", "ds:///a/"} {
+		ordered := s.Order(&CreateVolumeSpec{}, datastores)
+		if len(ordered) != 3 || ordered[0].Info.Url != expected {
+			t.Errorf("Order() #%d = %v, expected %s first", i, getDatastoreURLs(ordered), expected)
+		}
+	}
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Placement.DatastoreWeights = "ds:///a/=3, ds:///c/=0"
+	strategy, err := newWeightedStrategy(cfg)
+	if err != nil {
+		t.Fatalf("newWeightedStrategy() failed: %v", err)
+	}
+	s := strategy.(*weightedStrategy)
+	// Weighted free space: a 300, b 200, c 0
+	datastores := []*vsphere.DatastoreInfo{
+		newDatastoreWithFreeSpace("ds:///a/", 100),
+		newDatastoreWithFreeSpace("ds:///b/", 200),
+		newDatastoreWithFreeSpace("ds:///c/", 500),
+	}
+	tests := []struct {
+		r        float64
+		expected string
+	}{
+		{0, "ds:///a/"},
+		{0.59, "ds:///a/"},
+		{0.61, "ds:///b/"},
+		{0.99, "ds:///b/"},
+	}
+	for _, test := range tests {
+		s.float64 = func() float64 { return test.r }
+		if ordered := s.Order(&CreateVolumeSpec{}, datastores); ordered[0].Info.Url != test.expected {
+			t.Errorf("Order() with random %v = %v, expected %s first", test.r, getDatastoreURLs(ordered), test.expected)
+		}
+	}
+
+	cfg.Placement.DatastoreWeights = "ds:///a/=heavy"
+	if _, err = newWeightedStrategy(cfg); err == nil {
+		t.Errorf("newWeightedStrategy() with an invalid weight succeeded")
+	}
+}
+
+func TestPolicyStrategy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Placement.PolicyStrategies = "gold=round-robin,vSAN Default Storage Policy=cns"
+	if _, err := newPolicyStrategy(cfg); err == nil {
+		t.Errorf("newPolicyStrategy() with strategy cns for a storage policy succeeded")
+	}
+	cfg.Placement.PolicyStrategies = "gold=round-robin"
+	strategy, err := newPolicyStrategy(cfg)
+	if err != nil {
+		t.Fatalf("newPolicyStrategy() failed: %v", err)
+	}
+	datastores := []*vsphere.DatastoreInfo{
+		newDatastoreWithFreeSpace("ds:///b/", 100),
+		newDatastoreWithFreeSpace("ds:///a/", 50),
+		newDatastoreWithFreeSpace("ds:///c/", 500),
+	}
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{"gold", "ds:///a/"},
+		{"silver", "ds:///c/"},
+		{"", "ds:///c/"},
+		{"gold", "ds:///b/"},
+	}
+	for _, test := range tests {
+		ordered := strategy.Order(&CreateVolumeSpec{StoragePolicyName: test.policy}, datastores)
+		if ordered[0].Info.Url != test.expected {
+			t.Errorf("Order() for storage policy %q = %v, expected %s first", test.policy, getDatastoreURLs(ordered), test.expected)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// quarantineWindow is the number of latest CreateVolume outcomes kept per datastore
+const quarantineWindow = 10
+
+// DatastoreQuarantine keeps datastores with repeated CreateVolume failures out of volume placement,
+// until a probe finds them accessible again.
+type DatastoreQuarantine struct {
+	threshold int
+	period    time.Duration
+	lock      sync.Mutex
+	// datastores maps datastore URLs to their health
+	datastores map[string]*datastoreHealth
+	// afterFunc schedules the probes of quarantined datastores
+	afterFunc func(time.Duration, func())
+}
+
+type datastoreHealth struct {
+	// failures are the latest CreateVolume outcomes, true for a failure
+	failures    []bool
+	quarantined bool
+}
+
+// NewDatastoreQuarantine returns a DatastoreQuarantine configured by X_CSI_DATASTORE_QUARANTINE_THRESHOLD and
+// X_CSI_DATASTORE_QUARANTINE_MINUTES if set and valid, otherwise the defaults. It returns nil if quarantine is disabled.
+func NewDatastoreQuarantine() *DatastoreQuarantine {
+	threshold := getEnvInt(EnvDatastoreQuarantineThreshold, DefaultDatastoreQuarantineThreshold)
+	if threshold == 0 {
+		klog.V(2).Infof("Datastore quarantine is disabled")
+		return nil
+	}
+	if threshold > quarantineWindow {
+		klog.Warningf("%s %d is more than the %d outcomes tracked, will use %d",
+			EnvDatastoreQuarantineThreshold, threshold, quarantineWindow, quarantineWindow)
+		threshold = quarantineWindow
+	}
+	minutes := getEnvInt(EnvDatastoreQuarantineMinutes, DefaultDatastoreQuarantineMinutes)
+	klog.V(2).Infof("Datastores with %d failures among their last %d CreateVolume outcomes will be quarantined for %d minutes",
+		threshold, quarantineWindow, minutes)
+	return newDatastoreQuarantine(threshold, time.Duration(minutes)*time.Minute)
+}
+
+func newDatastoreQuarantine(threshold int, period time.Duration) *DatastoreQuarantine {
+	return &DatastoreQuarantine{
+		threshold:  threshold,
+		period:     period,
+		datastores: make(map[string]*datastoreHealth),
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// getEnvInt returns the non negative integer read from the environment variable if set and valid, otherwise the default
+func getEnvInt(envName string, defaultValue int) int {
+	if v := os.Getenv(envName); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			return value
+		}
+		klog.Warningf("%s %s is invalid, will use the default of %d", envName, v, defaultValue)
+	}
+	return defaultValue
+}
+
+// Filter returns the datastores which are not quarantined. If all of them are, they are all returned,
+// as failing CreateVolume on a quarantined datastore is no worse than failing it for lack of datastores.
+func (q *DatastoreQuarantine) Filter(datastores []*vsphere.DatastoreInfo) []*vsphere.DatastoreInfo {
+	if q == nil {
+		return datastores
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var available []*vsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if health, ok := q.datastores[datastore.Info.Url]; ok && health.quarantined {
+			klog.V(4).Infof("Skipping quarantined datastore %s", datastore.Info.Url)
+			continue
+		}
+		available = append(available, datastore)
+	}
+	if len(available) == 0 && len(datastores) > 0 {
+		klog.Warningf("All %d candidate datastores are quarantined, placing volume on any of them", len(datastores))
+		return datastores
+	}
+	return available
+}
+
+// RecordSuccess records a successful CreateVolume on the datastore with the given URL
+func (q *DatastoreQuarantine) RecordSuccess(datastoreURL string) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.record(datastoreURL, false)
+}
+
+// RecordFailure records a failed CreateVolume on the datastore and quarantines the datastore if it failed
+// too often. The datastore is probed with vc once the quarantine period has elapsed.
+func (q *DatastoreQuarantine) RecordFailure(vc *vsphere.VirtualCenter, datastore *vsphere.DatastoreInfo) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	health := q.record(datastore.Info.Url, true)
+	if health.quarantined || countFailures(health.failures) < q.threshold {
+		return
+	}
+	klog.Warningf("Quarantining datastore %s for %v after %d failures among its last %d CreateVolume outcomes",
+		datastore.Info.Url, q.period, countFailures(health.failures), len(health.failures))
+	health.quarantined = true
+	prometheus.DatastoreQuarantined.WithLabelValues(datastore.Info.Url).Set(1)
+	q.scheduleProbe(datastore.Info.Url, func(ctx context.Context) (bool, error) {
+		return vc.IsDatastoreAccessible(ctx, datastore.Reference())
+	})
+}
+
+func (q *DatastoreQuarantine) record(datastoreURL string, failure bool) *datastoreHealth {
+	health, ok := q.datastores[datastoreURL]
+	if !ok {
+		health = &datastoreHealth{}
+		q.datastores[datastoreURL] = health
+	}
+	health.failures = append(health.failures, failure)
+	if len(health.failures) > quarantineWindow {
+		health.failures = health.failures[len(health.failures)-quarantineWindow:]
+	}
+	return health
+}
+
+func (q *DatastoreQuarantine) scheduleProbe(datastoreURL string, probe func(ctx context.Context) (bool, error)) {
+	q.afterFunc(q.period, func() {
+		q.probe(datastoreURL, probe)
+	})
+}
+
+// probe releases the quarantined datastore if it is accessible, otherwise extends its quarantine
+func (q *DatastoreQuarantine) probe(datastoreURL string, probe func(ctx context.Context) (bool, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accessible, err := probe(ctx)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err != nil || !accessible {
+		klog.Warningf("Quarantined datastore %s is not accessible, extending its quarantine by %v. err: %v", datastoreURL, q.period, err)
+		q.scheduleProbe(datastoreURL, probe)
+		return
+	}
+	klog.V(2).Infof("Releasing datastore %s from quarantine", datastoreURL)
+	delete(q.datastores, datastoreURL)
+	prometheus.DatastoreQuarantined.WithLabelValues(datastoreURL).Set(0)
+}
+
+func countFailures(failures []bool) int {
+	count := 0
+	for _, failure := range failures {
+		if failure {
+			count++
+		}
+	}
+	return count
+}
+
+// getFailedDatastore returns the datastore a CreateVolume failure among the candidate datastores can be blamed on,
+// or nil if it cannot tell. CNS picks one datastore among several candidates, hence the failure is only blamed
+// on one of them if its URL is in the error.
+func getFailedDatastore(err error, candidates []*vsphere.DatastoreInfo) *vsphere.DatastoreInfo {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	msg := err.Error()
+	for _, candidate := range candidates {
+		if strings.Contains(msg, candidate.Info.Url) {
+			return candidate
+		}
+	}
+	return nil
+}
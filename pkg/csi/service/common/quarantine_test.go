@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func newDatastoreInfo(url string) *vsphere.DatastoreInfo {
+	return &vsphere.DatastoreInfo{Info: &types.DatastoreInfo{Url: url}}
+}
+
+func TestDatastoreQuarantine(t *testing.T) {
+	q := newDatastoreQuarantine(3, time.Minute)
+	var probes []func()
+	q.afterFunc = func(d time.Duration, f func()) { probes = append(probes, f) }
+	flaky, healthy := newDatastoreInfo("ds:///flaky/"), newDatastoreInfo("ds:///healthy/")
+	datastores := []*vsphere.DatastoreInfo{flaky, healthy}
+
+	// Failures are counted among the latest outcomes only
+	q.RecordFailure(nil, flaky)
+	q.RecordFailure(nil, flaky)
+	for i := 0; i < quarantineWindow-1; i++ {
+		q.RecordSuccess(flaky.Info.Url)
+	}
+	q.RecordFailure(nil, flaky)
+	if available := q.Filter(datastores); len(available) != 2 {
+		t.Fatalf("Filter() returned %d datastores before quarantine, expected 2", len(available))
+	}
+	// Quarantined on the third failure among the latest outcomes
+	q.RecordFailure(nil, flaky)
+	q.RecordFailure(nil, flaky)
+	if available := q.Filter(datastores); len(available) != 1 || available[0] != healthy {
+		t.Fatalf("Filter() returned %v after quarantine, expected only %v", available, healthy)
+	}
+	if len(probes) != 1 {
+		t.Fatalf("%d probes scheduled after quarantine, expected 1", len(probes))
+	}
+	// All candidates quarantined are all returned
+	if available := q.Filter([]*vsphere.DatastoreInfo{flaky}); len(available) != 1 {
+		t.Errorf("Filter() returned %d datastores when all are quarantined, expected 1", len(available))
+	}
+
+	// The quarantine is extended while the probe fails and lifted once it succeeds
+	accessible := false
+	probe := func(ctx context.Context) (bool, error) {
+		if !accessible {
+			return false, errors.New("inaccessible")
+		}
+		return true, nil
+	}
+	q.probe(flaky.Info.Url, probe)
+	if len(probes) != 2 {
+		t.Fatalf("%d probes scheduled after a failed probe, expected 2", len(probes))
+	}
+	if available := q.Filter(datastores); len(available) != 1 {
+		t.Errorf("Filter() returned %d datastores after a failed probe, expected 1", len(available))
+	}
+	accessible = true
+	probes[1]()
+	if available := q.Filter(datastores); len(available) != 2 {
+		t.Errorf("Filter() returned %d datastores after a successful probe, expected 2", len(available))
+	}
+	if len(probes) != 2 {
+		t.Errorf("%d probes scheduled after a successful probe, expected 2", len(probes))
+	}
+}
+
+func TestDatastoreQuarantineDisabled(t *testing.T) {
+	var q *DatastoreQuarantine
+	datastores := []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///a/")}
+	q.RecordSuccess("ds:///a/")
+	q.RecordFailure(nil, datastores[0])
+	if available := q.Filter(datastores); len(available) != 1 {
+		t.Errorf("Filter() returned %d datastores with quarantine disabled, expected 1", len(available))
+	}
+}
+
+func TestGetFailedDatastore(t *testing.T) {
+	a, b := newDatastoreInfo("ds:///a/"), newDatastoreInfo("ds:///b/")
+	tests := []struct {
+		name       string
+		err        error
+		candidates []*vsphere.DatastoreInfo
+		expected   *vsphere.DatastoreInfo
+	}{
+		{"single candidate", errors.New("failed"), []*vsphere.DatastoreInfo{a}, a},
+		{"unknown among several", errors.New("failed"), []*vsphere.DatastoreInfo{a, b}, nil},
+		{"named among several", errors.New("failed to create disk on ds:///b/"), []*vsphere.DatastoreInfo{a, b}, b},
+	}
+	for _, test := range tests {
+		if actual := getFailedDatastore(test.err, test.candidates); actual != test.expected {
+			t.Errorf("%s: getFailedDatastore() = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
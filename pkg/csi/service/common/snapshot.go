@@ -0,0 +1,386 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// GetSnapshotID returns the CSI snapshot id of the First Class Disk snapshot with the given id of the given volume.
+func GetSnapshotID(volumeID string, fcdSnapshotID string) string {
+	return volumeID + SnapshotIDSeparator + fcdSnapshotID
+}
+
+// ParseSnapshotID returns the volume id and the First Class Disk snapshot id of the given CSI snapshot id.
+// ok is false if the given id is not a CSI snapshot id.
+func ParseSnapshotID(snapshotID string) (volumeID string, fcdSnapshotID string, ok bool) {
+	parts := strings.Split(snapshotID, SnapshotIDSeparator)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// getSnapshotDescription returns the description of the First Class Disk snapshot with the given name of a volume
+// with the given capacity.
+func getSnapshotDescription(name string, capacityMB int64) string {
+	return name + SnapshotDescriptionSeparator + strconv.FormatInt(capacityMB, 10)
+}
+
+// parseSnapshotDescription returns the name of the First Class Disk snapshot with the given description and the
+// capacity in MB of its volume at the time of the snapshot. Snapshots created before the capacity was recorded
+// are described with their name only, for which capacityMB is 0.
+func parseSnapshotDescription(description string) (name string, capacityMB int64) {
+	i := strings.LastIndex(description, SnapshotDescriptionSeparator)
+	if i < 0 {
+		return description, 0
+	}
+	capacityMB, err := strconv.ParseInt(description[i+len(SnapshotDescriptionSeparator):], 10, 64)
+	if err != nil || capacityMB <= 0 {
+		return description, 0
+	}
+	return description[:i], capacityMB
+}
+
+// CreateSnapshotUtil is the helper function to snapshot the First Class Disk backing the given volume.
+// The snapshot is described with the given name, so that a snapshot created by an earlier attempt with
+// the same name is returned instead of creating another one, and with the capacity of the volume, which is the
+// size of the snapshot. If changedBlockTracking is true, Changed Block Tracking
+// is enabled on the disk before it is snapshotted, so that backup products can compute the blocks changed
+// since the snapshot.
+func CreateSnapshotUtil(ctx context.Context, manager *Manager, volume *cnstypes.CnsVolume, name string,
+	changedBlockTracking bool) (*csi.Snapshot, error) {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return nil, err
+	}
+	volumeID := volume.VolumeId.Id
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return nil, err
+	}
+	if changedBlockTracking {
+		klog.V(4).Infof("Enabling Changed Block Tracking on volume %s", volumeID)
+		err = vc.SetVStorageObjectControlFlags(ctx, datastore.Reference(), volumeID,
+			[]string{string(types.VslmVStorageObjectControlFlagEnableChangedBlockTracking)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	snapshots, err := vc.RetrieveVStorageObjectSnapshots(ctx, datastore.Reference(), volumeID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := findSnapshot(snapshots, func(snapshot types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) bool {
+		snapshotName, _ := parseSnapshotDescription(snapshot.Description)
+		return snapshotName == name
+	})
+	if snapshot != nil {
+		klog.V(2).Infof("Snapshot %q of volume %s already exists with id %s", name, volumeID, snapshot.Id.Id)
+	} else {
+		klog.V(2).Infof("Creating snapshot %q of volume %s", name, volumeID)
+		description := getSnapshotDescription(name, cnsvolume.GetCapacityMB(volume))
+		fcdSnapshotID, err := vc.CreateVStorageObjectSnapshot(ctx, datastore.Reference(), volumeID, description)
+		if err != nil {
+			return nil, err
+		}
+		if snapshots, err = vc.RetrieveVStorageObjectSnapshots(ctx, datastore.Reference(), volumeID); err != nil {
+			return nil, err
+		}
+		snapshot = findSnapshot(snapshots, func(snapshot types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) bool {
+			return snapshot.Id.Id == fcdSnapshotID
+		})
+		if snapshot == nil {
+			return nil, fmt.Errorf("snapshot %s of volume %s not found after creating it", fcdSnapshotID, volumeID)
+		}
+	}
+	return toCSISnapshot(volume, snapshot)
+}
+
+// toCSISnapshot returns the CSI snapshot of the given First Class Disk snapshot of the given volume.
+// The size of the snapshot is the capacity of the volume recorded when the snapshot was created, as the volume
+// may have been expanded since. It is the current capacity of the volume for snapshots not recording it.
+func toCSISnapshot(volume *cnstypes.CnsVolume, snapshot *types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) (*csi.Snapshot, error) {
+	creationTime, err := ptypes.TimestampProto(snapshot.CreateTime)
+	if err != nil {
+		return nil, err
+	}
+	_, capacityMB := parseSnapshotDescription(snapshot.Description)
+	if capacityMB == 0 {
+		capacityMB = cnsvolume.GetCapacityMB(volume)
+	}
+	return &csi.Snapshot{
+		SizeBytes:      capacityMB * MbInBytes,
+		SnapshotId:     GetSnapshotID(volume.VolumeId.Id, snapshot.Id.Id),
+		SourceVolumeId: volume.VolumeId.Id,
+		CreationTime:   creationTime,
+		ReadyToUse:     true,
+	}, nil
+}
+
+// ListSnapshotsUtil is the helper function to list the First Class Disk snapshots of the given volumes in the order
+// of their CSI snapshot ids, starting at the given CSI snapshot id if any. At most maxEntries snapshots are returned,
+// all of them if maxEntries is 0, along with the CSI snapshot id to continue from if there are more.
+// The volumes are identified by their CSI volume ids, which getManager resolves to the Manager of their vCenter
+// and their CNS volume id. Only the snapshots of as many volumes as needed to fill the page are retrieved.
+func ListSnapshotsUtil(ctx context.Context, getManager func(volumeID string) (*Manager, string, error),
+	volumes []cnstypes.CnsVolume, startingToken string, maxEntries int) ([]*csi.Snapshot, string, error) {
+	vcs := make(map[string]*vsphere.VirtualCenter)
+	datastores := make(map[string]*vsphere.Datastore)
+	return pageSnapshots(volumes, func(volume *cnstypes.CnsVolume) ([]*csi.Snapshot, error) {
+		manager, cnsVolumeID, err := getManager(volume.VolumeId.Id)
+		if err != nil {
+			return nil, err
+		}
+		host := manager.VcenterConfig.Host
+		vc, ok := vcs[host]
+		if !ok {
+			if vc, err = GetVCenter(ctx, manager); err != nil {
+				klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+				return nil, err
+			}
+			vcs[host] = vc
+		}
+		datastoreKey := host + "/" + volume.DatastoreUrl
+		datastore, ok := datastores[datastoreKey]
+		if !ok {
+			if datastore, err = GetDatastoreByURL(ctx, vc, volume.DatastoreUrl); err != nil {
+				return nil, err
+			}
+			datastores[datastoreKey] = datastore
+		}
+		fcdSnapshots, err := vc.RetrieveVStorageObjectSnapshots(ctx, datastore.Reference(), cnsVolumeID)
+		if err != nil {
+			return nil, err
+		}
+		var snapshots []*csi.Snapshot
+		for i := range fcdSnapshots {
+			if fcdSnapshots[i].Id == nil {
+				continue
+			}
+			snapshot, err := toCSISnapshot(volume, &fcdSnapshots[i])
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		return snapshots, nil
+	}, startingToken, maxEntries)
+}
+
+// pageSnapshots returns the page of snapshots starting at the given CSI snapshot id, retrieving the snapshots
+// of the volumes with the given function, and the CSI snapshot id of the next page if there is one.
+func pageSnapshots(volumes []cnstypes.CnsVolume, retrieve func(volume *cnstypes.CnsVolume) ([]*csi.Snapshot, error),
+	startingToken string, maxEntries int) ([]*csi.Snapshot, string, error) {
+	startVolumeID, startFCDSnapshotID, _ := ParseSnapshotID(startingToken)
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].VolumeId.Id < volumes[j].VolumeId.Id
+	})
+	var page []*csi.Snapshot
+	for i := range volumes {
+		volumeID := volumes[i].VolumeId.Id
+		if volumeID < startVolumeID {
+			continue
+		}
+		snapshots, err := retrieve(&volumes[i])
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[i].SnapshotId < snapshots[j].SnapshotId
+		})
+		for _, snapshot := range snapshots {
+			if volumeID == startVolumeID && snapshot.SnapshotId < GetSnapshotID(startVolumeID, startFCDSnapshotID) {
+				continue
+			}
+			if maxEntries > 0 && len(page) == maxEntries {
+				return page, snapshot.SnapshotId, nil
+			}
+			page = append(page, snapshot)
+		}
+	}
+	return page, "", nil
+}
+
+// DeleteSnapshotUtil is the helper function to delete the First Class Disk snapshot with the given CSI snapshot id.
+// Snapshots of volumes which are not found, and snapshots which are not found, are assumed to be deleted already.
+func DeleteSnapshotUtil(ctx context.Context, manager *Manager, snapshotID string) error {
+	volumeID, fcdSnapshotID, ok := ParseSnapshotID(snapshotID)
+	if !ok {
+		klog.V(2).Infof("%q is not a snapshot id of this driver. Assuming the snapshot is already deleted", snapshotID)
+		return nil
+	}
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return err
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		klog.Errorf("Failed to query volume %s with error %+v", volumeID, err)
+		return err
+	}
+	if volume == nil {
+		klog.V(2).Infof("Volume %s not found in CNS. Assuming snapshot %s is already deleted", volumeID, fcdSnapshotID)
+		return nil
+	}
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return err
+	}
+	snapshots, err := vc.RetrieveVStorageObjectSnapshots(ctx, datastore.Reference(), volumeID)
+	if err != nil {
+		return err
+	}
+	snapshot := findSnapshot(snapshots, func(snapshot types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) bool {
+		return snapshot.Id.Id == fcdSnapshotID
+	})
+	if snapshot == nil {
+		klog.V(2).Infof("Snapshot %s of volume %s not found. Assuming it is already deleted", fcdSnapshotID, volumeID)
+		return nil
+	}
+	name, _ := parseSnapshotDescription(snapshot.Description)
+	klog.V(2).Infof("Deleting snapshot %q of volume %s", name, volumeID)
+	return vc.DeleteVStorageObjectSnapshot(ctx, datastore.Reference(), volumeID, fcdSnapshotID)
+}
+
+// findSnapshot returns the first snapshot matching the given function, or nil if none does.
+func findSnapshot(snapshots []types.VStorageObjectSnapshotInfoVStorageObjectSnapshot,
+	matches func(types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) bool) *types.VStorageObjectSnapshotInfoVStorageObjectSnapshot {
+	for i := range snapshots {
+		if snapshots[i].Id != nil && matches(snapshots[i]) {
+			return &snapshots[i]
+		}
+	}
+	return nil
+}
+
+// GetSnapshotUtil is the helper function to get the First Class Disk snapshot with the given CSI snapshot id.
+// Returns nil if the snapshot is not found.
+func GetSnapshotUtil(ctx context.Context, manager *Manager, snapshotID string) (*csi.Snapshot, error) {
+	volumeID, fcdSnapshotID, ok := ParseSnapshotID(snapshotID)
+	if !ok {
+		return nil, nil
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil || volume == nil {
+		return nil, err
+	}
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return nil, err
+	}
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := vc.RetrieveVStorageObjectSnapshots(ctx, datastore.Reference(), volumeID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := findSnapshot(snapshots, func(snapshot types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) bool {
+		return snapshot.Id.Id == fcdSnapshotID
+	})
+	if snapshot == nil {
+		return nil, nil
+	}
+	return toCSISnapshot(volume, snapshot)
+}
+
+// CreateVolumeFromSnapshotUtil is the helper function to create a CNS volume from the First Class Disk snapshot
+// with the given CSI snapshot id. The disk is created on the datastore of the snapshot, which has to be one of
+// the given shared datastores and compatible with the storage policy of the spec, and is then registered with CNS.
+func CreateVolumeFromSnapshotUtil(ctx context.Context, manager *Manager, spec *CreateVolumeSpec, snapshotID string,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return "", err
+	}
+	// A CreateVolume interrupted by a restart of the controller may have created the volume after all.
+	// Retries of the request carry the same name, hence return that volume rather than creating another disk.
+	existingVolumeID, err := getVolumeIDByName(ctx, manager, spec.Name)
+	if err != nil {
+		klog.Errorf("Failed to query volume %s, err: %+v", spec.Name, err)
+		return "", err
+	}
+	if existingVolumeID != "" {
+		return getExistingVolume(ctx, manager, vc, existingVolumeID, spec, sharedDatastores)
+	}
+	sourceVolumeID, fcdSnapshotID, ok := ParseSnapshotID(snapshotID)
+	if !ok {
+		return "", fmt.Errorf("invalid snapshot id %q", snapshotID)
+	}
+	sourceVolume, err := manager.VolumeManager.RefreshVolume(sourceVolumeID)
+	if err != nil {
+		return "", err
+	}
+	if sourceVolume == nil {
+		return "", fmt.Errorf("volume %s of snapshot %s not found", sourceVolumeID, snapshotID)
+	}
+	if spec.DatastoreURL != "" && spec.DatastoreURL != sourceVolume.DatastoreUrl {
+		return "", fmt.Errorf("snapshot %s resides on datastore %s, not on datastore %s specified in the storage class",
+			snapshotID, sourceVolume.DatastoreUrl, spec.DatastoreURL)
+	}
+	var datastore *vsphere.DatastoreInfo
+	for _, sharedDatastore := range sharedDatastores {
+		if sharedDatastore.Info.Url == sourceVolume.DatastoreUrl {
+			datastore = sharedDatastore
+			break
+		}
+	}
+	if datastore == nil {
+		return "", fmt.Errorf("datastore %s of snapshot %s is not accessible to all nodes", sourceVolume.DatastoreUrl, snapshotID)
+	}
+	profile, err := getProfileSpec(ctx, vc, spec)
+	if err != nil {
+		return "", err
+	}
+	if _, err = getCompatibleDatastores(ctx, vc, spec, []*vsphere.DatastoreInfo{datastore}); err != nil {
+		klog.Errorf("Failed to create volume %s from snapshot %s on datastore %s. err: %+v", spec.Name, snapshotID, datastore.Info.Url, err)
+		return "", err
+	}
+	journaledDatastore, diskID, err := getJournaledDisk(ctx, vc, spec.Name, []*vsphere.DatastoreInfo{datastore})
+	if err != nil {
+		return "", err
+	}
+	if journaledDatastore == nil {
+		klog.V(2).Infof("Creating disk %s from snapshot %s", spec.Name, snapshotID)
+		diskID, err = createJournaledDisk(ctx, vc, spec.Name, datastore, func() (string, error) {
+			return vc.CreateVStorageObjectFromSnapshot(ctx, datastore.Reference(), sourceVolumeID, fcdSnapshotID, spec.Name, profile)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return registerDiskUtil(ctx, manager, vc, spec, diskID, datastore, profile, sharedDatastores)
+}
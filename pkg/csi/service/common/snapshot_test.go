@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+func TestSnapshotID(t *testing.T) {
+	snapshotID := GetSnapshotID("98e5df87-88e8-49a4-ae54-51037a204cab", "8d2a8b8c-5d4f-4b5e-9d8e-4b6f8b0b9c4a")
+	if snapshotID != "98e5df87-88e8-49a4-ae54-51037a204cab+8d2a8b8c-5d4f-4b5e-9d8e-4b6f8b0b9c4a" {
+		t.Errorf("unexpected snapshot id %q", snapshotID)
+	}
+	volumeID, fcdSnapshotID, ok := ParseSnapshotID(snapshotID)
+	if !ok || volumeID != "98e5df87-88e8-49a4-ae54-51037a204cab" || fcdSnapshotID != "8d2a8b8c-5d4f-4b5e-9d8e-4b6f8b0b9c4a" {
+		t.Errorf("failed to parse snapshot id %q: got volume id %q, snapshot id %q, ok %t", snapshotID, volumeID, fcdSnapshotID, ok)
+	}
+	for _, invalidID := range []string{"98e5df87", "98e5df87+", "+8d2a8b8c", "98e5df87+8d2a8b8c+1"} {
+		if _, _, ok := ParseSnapshotID(invalidID); ok {
+			t.Errorf("expected %q to not be parsed as a snapshot id", invalidID)
+		}
+	}
+}
+
+func TestSnapshotDescription(t *testing.T) {
+	description := getSnapshotDescription("snapshot-1", 10240)
+	if description != "snapshot-1@10240" {
+		t.Errorf("unexpected snapshot description %q", description)
+	}
+	tests := []struct {
+		description        string
+		expectedName       string
+		expectedCapacityMB int64
+	}{
+		{description, "snapshot-1", 10240},
+		{"snapshot@a@2048", "snapshot@a", 2048},
+		{"snapshot-1", "snapshot-1", 0},
+		{"snapshot@", "snapshot@", 0},
+		{"snapshot@size", "snapshot@size", 0},
+	}
+	for _, test := range tests {
+		name, capacityMB := parseSnapshotDescription(test.description)
+		if name != test.expectedName || capacityMB != test.expectedCapacityMB {
+			t.Errorf("%q: expected name %q and capacity %d MB, got %q and %d MB",
+				test.description, test.expectedName, test.expectedCapacityMB, name, capacityMB)
+		}
+	}
+}
+
+func TestPageSnapshots(t *testing.T) {
+	volumes := []cnstypes.CnsVolume{
+		{VolumeId: cnstypes.CnsVolumeId{Id: "vol-c"}},
+		{VolumeId: cnstypes.CnsVolumeId{Id: "vol-a"}},
+		{VolumeId: cnstypes.CnsVolumeId{Id: "vol-b"}},
+	}
+	fcdSnapshotIDs := map[string][]string{
+		"vol-a": {"snap-2", "snap-1"},
+		"vol-b": {},
+		"vol-c": {"snap-3"},
+	}
+	tests := []struct {
+		name              string
+		startingToken     string
+		maxEntries        int
+		expected          []string
+		expectedNextToken string
+		expectedRetrieved []string
+	}{
+		{
+			name:              "all",
+			expected:          []string{"vol-a+snap-1", "vol-a+snap-2", "vol-c+snap-3"},
+			expectedRetrieved: []string{"vol-a", "vol-b", "vol-c"},
+		},
+		{
+			name:              "first page",
+			maxEntries:        1,
+			expected:          []string{"vol-a+snap-1"},
+			expectedNextToken: "vol-a+snap-2",
+			expectedRetrieved: []string{"vol-a"},
+		},
+		{
+			name:              "next page",
+			startingToken:     "vol-a+snap-2",
+			maxEntries:        2,
+			expected:          []string{"vol-a+snap-2", "vol-c+snap-3"},
+			expectedRetrieved: []string{"vol-a", "vol-b", "vol-c"},
+		},
+		{
+			name:              "starting snapshot deleted",
+			startingToken:     "vol-b+snap-0",
+			expected:          []string{"vol-c+snap-3"},
+			expectedRetrieved: []string{"vol-b", "vol-c"},
+		},
+	}
+	for _, test := range tests {
+		var retrieved []string
+		page, nextToken, err := pageSnapshots(volumes, func(volume *cnstypes.CnsVolume) ([]*csi.Snapshot, error) {
+			retrieved = append(retrieved, volume.VolumeId.Id)
+			var snapshots []*csi.Snapshot
+			for _, fcdSnapshotID := range fcdSnapshotIDs[volume.VolumeId.Id] {
+				snapshots = append(snapshots, &csi.Snapshot{SnapshotId: GetSnapshotID(volume.VolumeId.Id, fcdSnapshotID)})
+			}
+			return snapshots, nil
+		}, test.startingToken, test.maxEntries)
+		if err != nil {
+			t.Errorf("%s: pageSnapshots() failed: %v", test.name, err)
+			continue
+		}
+		var actual []string
+		for _, snapshot := range page {
+			actual = append(actual, snapshot.SnapshotId)
+		}
+		if !reflect.DeepEqual(actual, test.expected) || nextToken != test.expectedNextToken {
+			t.Errorf("%s: pageSnapshots() = %v, %q, expected %v, %q", test.name, actual, nextToken, test.expected, test.expectedNextToken)
+		}
+		if !reflect.DeepEqual(retrieved, test.expectedRetrieved) {
+			t.Errorf("%s: retrieved snapshots of %v, expected %v", test.name, retrieved, test.expectedRetrieved)
+		}
+	}
+}
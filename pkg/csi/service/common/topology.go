@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+// TopologyLevel is a level of the topology of the node VMs, such as the zone, given by their tags in a vSphere
+// tag category
+type TopologyLevel struct {
+	// Category is the vSphere tag category of the level
+	Category string
+	// Key is the topology key the level is published under
+	Key string
+	// Aliases are the other keys the level is published and accepted under, so that topology requirements
+	// given with the beta or the GA form of the region and zone labels both work
+	Aliases []string
+}
+
+// topologyKeyAliases are the aliases of the region and zone labels, each form being an alias of the other
+var topologyKeyAliases = map[string]string{
+	csitypes.LabelTopologyRegion:      csitypes.LabelRegionFailureDomain,
+	csitypes.LabelTopologyZone:        csitypes.LabelZoneFailureDomain,
+	csitypes.LabelRegionFailureDomain: csitypes.LabelTopologyRegion,
+	csitypes.LabelZoneFailureDomain:   csitypes.LabelTopologyZone,
+}
+
+// GetKeys returns the keys the topology level is published under, its key first
+func (level TopologyLevel) GetKeys() []string {
+	return append([]string{level.Key}, level.Aliases...)
+}
+
+// GetValue returns the value of the topology level in the given segments, found under its key or any of its
+// aliases, or an empty string if the segments do not set the level
+func (level TopologyLevel) GetValue(segments map[string]string) string {
+	for _, key := range level.GetKeys() {
+		if value := segments[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// GetTopologyLevels returns the levels of the topology of the node VMs in the config from the outermost. The region
+// and zone are published under the GA topology labels unless other topology keys are configured. Levels published
+// under the GA or the beta form of the region and zone labels are also published under the other form. Returns nil
+// if the topology of the nodes is not configured.
+func GetTopologyLevels(cfg *config.Config) []TopologyLevel {
+	categories, keys := cfg.GetTopologyCategories(), cfg.GetTopologyKeys()
+	if len(keys) == 0 && cfg.Labels.TopologyCategories == "" {
+		keys = []string{csitypes.LabelTopologyRegion, csitypes.LabelTopologyZone}
+	}
+	// The config validation rejects topology keys not matching the categories
+	if len(categories) == 0 || len(keys) != len(categories) {
+		return nil
+	}
+	levels := make([]TopologyLevel, len(categories))
+	for i := range categories {
+		levels[i] = TopologyLevel{Category: categories[i], Key: keys[i]}
+		if alias, ok := topologyKeyAliases[keys[i]]; ok {
+			levels[i].Aliases = []string{alias}
+		}
+	}
+	return levels
+}
+
+// GetTopologyCategories returns the tag categories of the given topology levels
+func GetTopologyCategories(levels []TopologyLevel) []string {
+	categories := make([]string, len(levels))
+	for i, level := range levels {
+		categories[i] = level.Category
+	}
+	return categories
+}
+
+// GetTopologySegments returns the topology segments of a node VM with the given tags by category, i.e. its tags
+// in the categories of the given levels under the keys and aliases of the levels. Levels the node VM has no tag for
+// are left out.
+func GetTopologySegments(levels []TopologyLevel, tags map[string]string) map[string]string {
+	segments := make(map[string]string)
+	for _, level := range levels {
+		if tag := tags[level.Category]; tag != "" {
+			for _, key := range level.GetKeys() {
+				segments[key] = tag
+			}
+		}
+	}
+	return segments
+}
+
+// IsTopologyMatch returns whether a node VM with the given topology segments belongs to the topology with the
+// given segments: the segments of the topology for the given levels must all match those of the node VM, and
+// at least one must be set. A level is matched under any of its keys, so either form of the region and zone
+// labels is accepted.
+func IsTopologyMatch(levels []TopologyLevel, nodeSegments map[string]string, segments map[string]string) bool {
+	matched := false
+	for _, level := range levels {
+		value := level.GetValue(segments)
+		if value == "" {
+			continue
+		}
+		if level.GetValue(nodeSegments) != value {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
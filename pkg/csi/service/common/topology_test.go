@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+func TestGetTopologyLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   config.Labels
+		expected []TopologyLevel
+	}{
+		{"no topology", config.Labels{}, nil},
+		{"zone only", config.Labels{Zone: "k8s-zone"}, nil},
+		{
+			"region and zone",
+			config.Labels{Region: "k8s-region", Zone: "k8s-zone"},
+			[]TopologyLevel{
+				{Category: "k8s-region", Key: csitypes.LabelTopologyRegion, Aliases: []string{csitypes.LabelRegionFailureDomain}},
+				{Category: "k8s-zone", Key: csitypes.LabelTopologyZone, Aliases: []string{csitypes.LabelZoneFailureDomain}},
+			},
+		},
+		{
+			"beta keys",
+			config.Labels{
+				TopologyCategories: "k8s-region,k8s-zone",
+				TopologyKeys:       csitypes.LabelRegionFailureDomain + "," + csitypes.LabelZoneFailureDomain,
+			},
+			[]TopologyLevel{
+				{Category: "k8s-region", Key: csitypes.LabelRegionFailureDomain, Aliases: []string{csitypes.LabelTopologyRegion}},
+				{Category: "k8s-zone", Key: csitypes.LabelZoneFailureDomain, Aliases: []string{csitypes.LabelTopologyZone}},
+			},
+		},
+		{
+			"categories",
+			config.Labels{
+				TopologyCategories: "k8s-region, k8s-zone, k8s-rack",
+				TopologyKeys:       "example.com/region,example.com/zone,example.com/rack",
+			},
+			[]TopologyLevel{
+				{Category: "k8s-region", Key: "example.com/region"},
+				{Category: "k8s-zone", Key: "example.com/zone"},
+				{Category: "k8s-rack", Key: "example.com/rack"},
+			},
+		},
+		{
+			"categories without keys",
+			config.Labels{TopologyCategories: "k8s-region,k8s-zone"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		cfg := &config.Config{Labels: test.labels}
+		if levels := GetTopologyLevels(cfg); !reflect.DeepEqual(levels, test.expected) {
+			t.Errorf("%s: GetTopologyLevels() = %v, expected %v", test.name, levels, test.expected)
+		}
+	}
+}
+
+func TestIsTopologyMatch(t *testing.T) {
+	levels := []TopologyLevel{
+		{Category: "k8s-region", Key: "example.com/region"},
+		{Category: "k8s-zone", Key: "example.com/zone"},
+		{Category: "k8s-rack", Key: "example.com/rack"},
+	}
+	nodeSegments := GetTopologySegments(levels, map[string]string{
+		"k8s-region": "region-1",
+		"k8s-zone":   "zone-a",
+		"k8s-rack":   "rack-1",
+		"other":      "value",
+	})
+	expectedSegments := map[string]string{
+		"example.com/region": "region-1",
+		"example.com/zone":   "zone-a",
+		"example.com/rack":   "rack-1",
+	}
+	if !reflect.DeepEqual(nodeSegments, expectedSegments) {
+		t.Errorf("GetTopologySegments() = %v, expected %v", nodeSegments, expectedSegments)
+	}
+	tests := []struct {
+		name     string
+		segments map[string]string
+		expected bool
+	}{
+		{"all levels", expectedSegments, true},
+		{"outer levels", map[string]string{"example.com/region": "region-1", "example.com/zone": "zone-a"}, true},
+		{"inner level", map[string]string{"example.com/rack": "rack-1"}, true},
+		{"other rack", map[string]string{"example.com/zone": "zone-a", "example.com/rack": "rack-2"}, false},
+		{"unknown key", map[string]string{"example.com/room": "room-1"}, false},
+		{"no segments", map[string]string{}, false},
+	}
+	for _, test := range tests {
+		if match := IsTopologyMatch(levels, nodeSegments, test.segments); match != test.expected {
+			t.Errorf("%s: IsTopologyMatch() = %v, expected %v", test.name, match, test.expected)
+		}
+	}
+}
+
+func TestIsTopologyMatchWithLabelForms(t *testing.T) {
+	levels := GetTopologyLevels(&config.Config{Labels: config.Labels{Region: "k8s-region", Zone: "k8s-zone"}})
+	nodeSegments := GetTopologySegments(levels, map[string]string{"k8s-region": "region-1", "k8s-zone": "zone-a"})
+	expectedSegments := map[string]string{
+		csitypes.LabelTopologyRegion:      "region-1",
+		csitypes.LabelTopologyZone:        "zone-a",
+		csitypes.LabelRegionFailureDomain: "region-1",
+		csitypes.LabelZoneFailureDomain:   "zone-a",
+	}
+	if !reflect.DeepEqual(nodeSegments, expectedSegments) {
+		t.Errorf("GetTopologySegments() = %v, expected %v", nodeSegments, expectedSegments)
+	}
+	tests := []struct {
+		name     string
+		segments map[string]string
+		expected bool
+	}{
+		{"GA labels", map[string]string{csitypes.LabelTopologyRegion: "region-1", csitypes.LabelTopologyZone: "zone-a"}, true},
+		{"beta labels", map[string]string{csitypes.LabelRegionFailureDomain: "region-1", csitypes.LabelZoneFailureDomain: "zone-a"}, true},
+		{"mixed labels", map[string]string{csitypes.LabelRegionFailureDomain: "region-1", csitypes.LabelTopologyZone: "zone-a"}, true},
+		{"other zone", map[string]string{csitypes.LabelZoneFailureDomain: "zone-b"}, false},
+	}
+	for _, test := range tests {
+		if match := IsTopologyMatch(levels, nodeSegments, test.segments); match != test.expected {
+			t.Errorf("%s: IsTopologyMatch() = %v, expected %v", test.name, match, test.expected)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// getTrashClusterTag returns the tag identifying the trash of the cluster with the given id in the names of
+// First Class Disks in trash. The cluster id is hashed so that the tag has a fixed length and no separator.
+func getTrashClusterTag(clusterID string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(clusterID))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// getTrashVolumeNamePrefix returns the prefix of the names of the First Class Disks in the trash of the cluster
+// with the given id.
+func getTrashVolumeNamePrefix(clusterID string) string {
+	return TrashVolumeNamePrefix + getTrashClusterTag(clusterID) + "-"
+}
+
+// GetTrashVolumeName returns the name given to a First Class Disk with the given name when the cluster with
+// the given id moves it to trash.
+func GetTrashVolumeName(name string, clusterID string, deletedAt time.Time) string {
+	return fmt.Sprintf("%s%d-%s", getTrashVolumeNamePrefix(clusterID), deletedAt.Unix(), name)
+}
+
+// ParseTrashVolumeName returns the original name of a First Class Disk in the trash of the cluster with the given
+// id and the time it was moved to trash. ok is false if the given name is not the name of a First Class Disk in
+// the trash of the cluster.
+func ParseTrashVolumeName(trashName string, clusterID string) (name string, deletedAt time.Time, ok bool) {
+	prefix := getTrashVolumeNamePrefix(clusterID)
+	if !strings.HasPrefix(trashName, prefix) {
+		return "", time.Time{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(trashName, prefix), "-", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", time.Time{}, false
+	}
+	deletedAtUnix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[1], time.Unix(deletedAtUnix, 0), true
+}
+
+// TrashVolumeUtil is the helper function to move the First Class Disk backing the given volume to trash.
+// The disk is renamed to record the cluster and the time of deletion, and the CNS volume is deleted without
+// deleting the disk.
+func TrashVolumeUtil(ctx context.Context, manager *Manager, volumeID string) error {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return err
+	}
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		klog.Errorf("Failed to query volume %s with error %+v", volumeID, err)
+		return err
+	}
+	if volume == nil {
+		klog.V(2).Infof("Volume %s not found in CNS. Assuming it is already moved to trash", volumeID)
+		return nil
+	}
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return err
+	}
+	vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), volumeID)
+	if err != nil {
+		return err
+	}
+	// Skip renaming if an earlier attempt renamed the disk but failed to delete the CNS volume.
+	clusterID := manager.CnsConfig.Global.ClusterID
+	if _, _, ok := ParseTrashVolumeName(vStorageObject.Config.Name, clusterID); !ok {
+		trashName := GetTrashVolumeName(vStorageObject.Config.Name, clusterID, time.Now())
+		klog.V(2).Infof("Moving volume %s to trash as %q", volumeID, trashName)
+		if err = vc.RenameVStorageObject(ctx, datastore.Reference(), volumeID, trashName); err != nil {
+			return err
+		}
+	}
+	return DeleteVolumeUtil(ctx, manager, volumeID, false)
+}
+
+// PurgeTrashVolumes deletes the First Class Disks on the given datastores which have been in the trash of the
+// cluster with the given id for longer than the given retention period. The trash of other clusters sharing
+// the datastores is left to them. Returns the ids of the deleted First Class Disks.
+func PurgeTrashVolumes(ctx context.Context, vc *vsphere.VirtualCenter, clusterID string, datastores []*vsphere.DatastoreInfo,
+	retention time.Duration) []string {
+	var purged []string
+	for _, datastore := range datastores {
+		volumeIDs, err := vc.ListVStorageObjects(ctx, datastore.Reference())
+		if err != nil {
+			klog.Warningf("Failed to list volumes on datastore %q. err: %v", datastore.Info.Url, err)
+			continue
+		}
+		for _, volumeID := range volumeIDs {
+			vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), volumeID.Id)
+			if err != nil {
+				continue
+			}
+			_, deletedAt, ok := ParseTrashVolumeName(vStorageObject.Config.Name, clusterID)
+			if !ok || time.Since(deletedAt) < retention {
+				continue
+			}
+			klog.V(2).Infof("Deleting volume %s %q from trash on datastore %q", volumeID.Id, vStorageObject.Config.Name, datastore.Info.Url)
+			if err = vc.DeleteVStorageObject(ctx, datastore.Reference(), volumeID.Id); err != nil {
+				klog.Warningf("Failed to delete volume %s from trash. err: %v", volumeID.Id, err)
+				continue
+			}
+			purged = append(purged, volumeID.Id)
+		}
+	}
+	return purged
+}
+
+// GetDatastoreByURL returns the datastore with the given URL from the datacenters of the given vCenter.
+func GetDatastoreByURL(ctx context.Context, vc *vsphere.VirtualCenter, datastoreURL string) (*vsphere.Datastore, error) {
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		klog.Errorf("Failed to find datacenters from VC: %+v, Error: %+v", vc.Config.Host, err)
+		return nil, err
+	}
+	for _, datacenter := range datacenters {
+		datastore, err := datacenter.GetDatastoreByURL(ctx, datastoreURL)
+		if err == nil {
+			return datastore, nil
+		}
+	}
+	return nil, fmt.Errorf("datastore with URL %q not found in VC %q", datastoreURL, vc.Config.Host)
+}
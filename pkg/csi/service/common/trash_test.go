@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashVolumeName(t *testing.T) {
+	deletedAt := time.Unix(1570000000, 0)
+	trashName := GetTrashVolumeName("pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba", "cluster-1", deletedAt)
+	prefix := "cns-trash-" + getTrashClusterTag("cluster-1") + "-"
+	if trashName != prefix+"1570000000-pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba" {
+		t.Errorf("unexpected trash name %q", trashName)
+	}
+	name, parsedDeletedAt, ok := ParseTrashVolumeName(trashName, "cluster-1")
+	if !ok || name != "pvc-2e3f8f1a-e8c4-11e9-a4bd-005056a4c8ba" || !parsedDeletedAt.Equal(deletedAt) {
+		t.Errorf("failed to parse trash name %q: got name %q, deletedAt %v, ok %t", trashName, name, parsedDeletedAt, ok)
+	}
+	if _, _, ok := ParseTrashVolumeName(trashName, "cluster-2"); ok {
+		t.Errorf("expected %q to not be parsed as a trash name of another cluster", trashName)
+	}
+	for _, invalidName := range []string{"pvc-2e3f8f1a", "cns-trash-", prefix, prefix + "abc-pvc", prefix + "1570000000-",
+		"cns-trash-1570000000-pvc-2e3f8f1a"} {
+		if _, _, ok := ParseTrashVolumeName(invalidName, "cluster-1"); ok {
+			t.Errorf("expected %q to not be parsed as a trash name", invalidName)
+		}
+	}
+}
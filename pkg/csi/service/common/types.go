@@ -41,6 +41,10 @@ type Manager struct {
 	CnsConfig      *config.Config
 	VolumeManager  cnsvolume.Manager
 	VcenterManager cnsvsphere.VirtualCenterManager
+	// DatastoreQuarantine keeps datastores with repeated CreateVolume failures out of placement, nil if disabled
+	DatastoreQuarantine *DatastoreQuarantine
+	// DatastorePlacement chooses the datastore of new volumes among the candidates, nil to leave the choice to CNS
+	DatastorePlacement *DatastorePlacement
 }
 
 // CreateVolumeSpec is the Volume Spec used by CSI driver
@@ -50,4 +54,14 @@ type CreateVolumeSpec struct {
 	StoragePolicyID   string
 	DatastoreURL      string
 	CapacityMB        int64
+	// MultiWriter provisions an eager zeroed thick disk which can be attached to several node VMs at once
+	MultiWriter bool
+	// FileVolume provisions a vSAN file share which nodes mount over NFS
+	FileVolume bool
+	// ProvisioningType is the provisioning type of the First Class Disk, left to CNS if empty
+	ProvisioningType string
+	// DatastoreCluster is the Storage DRS datastore cluster to place the volume in, if DatastoreURL is empty
+	DatastoreCluster string
+	// ChangedBlockTracking enables Changed Block Tracking on the First Class Disk, for incremental backups
+	ChangedBlockTracking bool
 }
@@ -18,12 +18,19 @@ package common
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 )
 
@@ -49,6 +56,22 @@ func GetUUIDFromProviderID(providerID string) string {
 	return strings.TrimPrefix(providerID, ProviderPrefix)
 }
 
+// ConvertUUID helps convert UUID to vSphere format, swapping the bytes of its first three fields
+// input uuid:    6B8C2042-0DD1-D037-156F-435F999D94C1
+// returned uuid: 42208c6b-d10d-37d0-156f-435f999d94c1
+func ConvertUUID(uuid string) (string, error) {
+	if len(uuid) != 36 {
+		return "", errors.New("uuid length should be 36")
+	}
+	convertedUUID := fmt.Sprintf("%s%s%s%s-%s%s-%s%s-%s-%s",
+		uuid[6:8], uuid[4:6], uuid[2:4], uuid[0:2],
+		uuid[11:13], uuid[9:11],
+		uuid[16:18], uuid[14:16],
+		uuid[19:23],
+		uuid[24:36])
+	return strings.ToLower(convertedUUID), nil
+}
+
 // FormatDiskUUID removes any spaces and hyphens in UUID
 // Example UUID input is 42375390-71f9-43a3-a770-56803bcd7baa and output after format is 4237539071f943a3a77056803bcd7baa
 func FormatDiskUUID(uuid string) string {
@@ -77,8 +100,15 @@ func GetLabelsMapFromKeyValue(labels []types.KeyValue) map[string]string {
 }
 
 // IsValidVolumeCapabilities is the helper function to validate capabilities of volume.
+// Multi-node access is supported for file volumes, and multi-writer access for raw block volumes.
 func IsValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	hasSupport := func(cap *csi.VolumeCapability) bool {
+		if isFileCapability(cap) {
+			return true
+		}
+		if isMultiWriterCapability(cap) {
+			return cap.GetBlock() != nil
+		}
 		for _, c := range VolumeCaps {
 			if c.GetMode() == cap.AccessMode.GetMode() {
 				return true
@@ -94,3 +124,176 @@ func IsValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	}
 	return foundAll
 }
+
+// IsMultiWriterVolume returns true if any of the capabilities requests a volume shared by several nodes
+// writing to it at the same time.
+func IsMultiWriterVolume(volCaps []*csi.VolumeCapability) bool {
+	for _, volCap := range volCaps {
+		if isMultiWriterCapability(volCap) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMultiWriterCapability(volCap *csi.VolumeCapability) bool {
+	return volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+}
+
+// IsFileVolumeRequest returns true if any of the capabilities requests a filesystem accessed by several nodes,
+// which is provisioned as a file volume backed by a vSAN file share.
+func IsFileVolumeRequest(volCaps []*csi.VolumeCapability) bool {
+	for _, volCap := range volCaps {
+		if isFileCapability(volCap) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFileCapability(volCap *csi.VolumeCapability) bool {
+	if volCap.GetMount() == nil {
+		return false
+	}
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// IsFileVolumeID returns true if the volume id is the id of a CNS file volume.
+func IsFileVolumeID(volumeID string) bool {
+	return strings.HasPrefix(volumeID, FileVolumePrefix)
+}
+
+// EncodeVolumeID returns the CSI volume id of the CNS volume with the given id on the vCenter with the given host,
+// which is the CNS volume id itself if the host is empty, i.e. for the volumes of the first vCenter of the config
+func EncodeVolumeID(cnsVolumeID string, vcHost string) string {
+	if vcHost == "" {
+		return cnsVolumeID
+	}
+	return cnsVolumeID + VirtualCenterSeparator + vcHost
+}
+
+// DecodeVolumeID returns the CNS volume id and the host of the vCenter owning the volume with the given CSI
+// volume id, which is empty for the volumes of the first vCenter of the config
+func DecodeVolumeID(volumeID string) (cnsVolumeID string, vcHost string) {
+	i := strings.LastIndex(volumeID, VirtualCenterSeparator)
+	if i < 0 {
+		return volumeID, ""
+	}
+	return volumeID[:i], volumeID[i+1:]
+}
+
+// supportedFsTypes are the filesystem types block volumes can be formatted with
+var supportedFsTypes = []string{"ext4", "ext3", "xfs", "btrfs"}
+
+// IsSupportedFsType returns true if block volumes can be formatted with the filesystem type
+func IsSupportedFsType(fsType string) bool {
+	for _, supported := range supportedFsTypes {
+		if fsType == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSupportedFsckMode returns true if the fsck mode is one of skip, fail and repair
+func IsSupportedFsckMode(fsckMode string) bool {
+	return fsckMode == FsckModeSkip || fsckMode == FsckModeFail || fsckMode == FsckModeRepair
+}
+
+// deniedMountFlags are the mount options that change the mount operation itself or let unprivileged users of the
+// volume escalate privileges, hence are rejected rather than passed to mount
+var deniedMountFlags = []string{"bind", "rbind", "move", "remount", "suid", "dev"}
+
+// ValidateMountFlags returns an error if any of the mount flags, each of which may hold several comma separated
+// options, is a denied mount option
+func ValidateMountFlags(flags []string) error {
+	for _, flag := range flags {
+		for _, option := range strings.Split(flag, ",") {
+			name := strings.TrimSpace(strings.SplitN(option, "=", 2)[0])
+			for _, denied := range deniedMountFlags {
+				if name == denied {
+					return fmt.Errorf("mount option %q is not allowed", name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetVolumeName returns the name of the volume of the PV with the given name, which replaces the "pvc-" prefix
+// of the PV name with the given prefix, or is the PV name if the prefix is empty.
+func GetVolumeName(prefix string, pvName string) string {
+	if prefix == "" {
+		return pvName
+	}
+	return prefix + strings.TrimPrefix(pvName, "pvc-")
+}
+
+// GetProvisioningType returns the provisioning type of First Class Disks for the diskformat parameter of the
+// Storage Class, or false if the disk format is not supported. The disk format is case insensitive.
+func GetProvisioningType(diskFormat string) (string, bool) {
+	switch strings.ToLower(diskFormat) {
+	case "thin":
+		return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeThin), true
+	case "zeroedthick":
+		return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeLazyZeroedThick), true
+	case "eagerzeroedthick":
+		return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick), true
+	}
+	return "", false
+}
+
+// GetVolumeReclaimMode returns the volume reclaim mode set in the X_CSI_VOLUME_RECLAIM_MODE environment
+// variable, VolumeReclaimModeDelete if unset, and an error if it is set to an unsupported mode.
+func GetVolumeReclaimMode() (string, error) {
+	reclaimMode := os.Getenv(EnvVolumeReclaimMode)
+	switch reclaimMode {
+	case "":
+		return VolumeReclaimModeDelete, nil
+	case VolumeReclaimModeDelete, VolumeReclaimModeDetachOnly, VolumeReclaimModeTrash:
+		return reclaimMode, nil
+	}
+	return "", fmt.Errorf("invalid %s: %q, supported values are %q, %q and %q", EnvVolumeReclaimMode, reclaimMode,
+		VolumeReclaimModeDelete, VolumeReclaimModeDetachOnly, VolumeReclaimModeTrash)
+}
+
+// IsDeleteDiskEnabled returns whether the underlying First Class Disk should be deleted along with the volume,
+// given the reclaim mode of the controller and the annotations on the PersistentVolume.
+// AnnDeleteDisk on the PersistentVolume takes precedence over the reclaim mode.
+func IsDeleteDiskEnabled(reclaimMode string, pvAnnotations map[string]string) bool {
+	if value, ok := pvAnnotations[AnnDeleteDisk]; ok {
+		return !strings.EqualFold(value, "false")
+	}
+	return reclaimMode != VolumeReclaimModeDetachOnly
+}
+
+// ToStatusError returns a gRPC status error with the given message, and the code of the vSphere fault or
+// network failure err is caused by if it has one, so that the CSI sidecars retry the operation accordingly,
+// otherwise the given code. If err is a CNS fault, the fault type, vCenter task ID, opId and localized
+// message are attached as status details, so that they can be used to open a support case.
+func ToStatusError(code codes.Code, msg string, err error) error {
+	if faultCode, ok := cnsvsphere.ErrorCode(err); ok {
+		code = faultCode
+	}
+	st := status.New(code, msg)
+	fault, ok := err.(*volume.Fault)
+	if !ok {
+		return st.Err()
+	}
+	stWithDetails, detailsErr := st.WithDetails(
+		&errdetails.DebugInfo{Detail: fault.Type},
+		&errdetails.RequestInfo{RequestId: fault.OpID, ServingData: fault.TaskID},
+		&errdetails.LocalizedMessage{Message: fault.Message},
+	)
+	if detailsErr != nil {
+		klog.Warningf("Failed to attach fault details to status. err=%v", detailsErr)
+		return st.Err()
+	}
+	return stWithDetails.Err()
+}
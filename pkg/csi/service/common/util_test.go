@@ -0,0 +1,270 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+)
+
+func TestIsDeleteDiskEnabled(t *testing.T) {
+	tests := []struct {
+		name          string
+		reclaimMode   string
+		pvAnnotations map[string]string
+		expected      bool
+	}{
+		{"default mode without annotation", VolumeReclaimModeDelete, nil, true},
+		{"detach-only mode without annotation", VolumeReclaimModeDetachOnly, nil, false},
+		{"default mode with annotation false", VolumeReclaimModeDelete, map[string]string{AnnDeleteDisk: "false"}, false},
+		{"default mode with annotation False", VolumeReclaimModeDelete, map[string]string{AnnDeleteDisk: "False"}, false},
+		{"detach-only mode with annotation true", VolumeReclaimModeDetachOnly, map[string]string{AnnDeleteDisk: "true"}, true},
+		{"unrelated annotation", VolumeReclaimModeDetachOnly, map[string]string{"foo": "false"}, false},
+	}
+	for _, test := range tests {
+		if actual := IsDeleteDiskEnabled(test.reclaimMode, test.pvAnnotations); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestGetVolumeReclaimMode(t *testing.T) {
+	defer os.Unsetenv(EnvVolumeReclaimMode)
+	tests := []struct {
+		env      string
+		expected string
+		ok       bool
+	}{
+		{"", VolumeReclaimModeDelete, true},
+		{VolumeReclaimModeDelete, VolumeReclaimModeDelete, true},
+		{VolumeReclaimModeDetachOnly, VolumeReclaimModeDetachOnly, true},
+		{VolumeReclaimModeTrash, VolumeReclaimModeTrash, true},
+		{"retain", "", false},
+	}
+	for _, test := range tests {
+		os.Setenv(EnvVolumeReclaimMode, test.env)
+		actual, err := GetVolumeReclaimMode()
+		if (err == nil) != test.ok || actual != test.expected {
+			t.Errorf("%q: expected %q and ok %t, got %q and err %v", test.env, test.expected, test.ok, actual, err)
+		}
+	}
+}
+
+func TestGetProvisioningType(t *testing.T) {
+	tests := []struct {
+		diskFormat string
+		expected   string
+		ok         bool
+	}{
+		{"thin", "thin", true},
+		{"zeroedthick", "lazyZeroedThick", true},
+		{"EagerZeroedThick", "eagerZeroedThick", true},
+		{"thick", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		provisioningType, ok := GetProvisioningType(test.diskFormat)
+		if provisioningType != test.expected || ok != test.ok {
+			t.Errorf("GetProvisioningType(%q) = %q, %t, expected %q, %t", test.diskFormat, provisioningType, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestGetVolumeName(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		pvName   string
+		expected string
+	}{
+		{"", "pvc-6f2e6a52-1b7e-4a6b-9b8e-0f3c6d1e2a4b", "pvc-6f2e6a52-1b7e-4a6b-9b8e-0f3c6d1e2a4b"},
+		{"pvc-cluster1-", "pvc-6f2e6a52-1b7e-4a6b-9b8e-0f3c6d1e2a4b", "pvc-cluster1-6f2e6a52-1b7e-4a6b-9b8e-0f3c6d1e2a4b"},
+		{"cluster1-", "static-pv", "cluster1-static-pv"},
+	}
+	for _, test := range tests {
+		if name := GetVolumeName(test.prefix, test.pvName); name != test.expected {
+			t.Errorf("GetVolumeName(%q, %q) = %q, expected %q", test.prefix, test.pvName, name, test.expected)
+		}
+	}
+}
+
+func TestValidateMountFlags(t *testing.T) {
+	tests := []struct {
+		flags []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"noatime", "nodiratime"}, true},
+		{[]string{"nosuid,nodev", "context=system_u:object_r:container_file_t:s0"}, true},
+		{[]string{"noatime", "suid"}, false},
+		{[]string{"rw,bind"}, false},
+		{[]string{" remount"}, false},
+	}
+	for _, test := range tests {
+		if err := ValidateMountFlags(test.flags); (err == nil) != test.valid {
+			t.Errorf("ValidateMountFlags(%q) returned %v, expected valid: %t", test.flags, err, test.valid)
+		}
+	}
+}
+
+func TestToStatusError(t *testing.T) {
+	err := ToStatusError(codes.Internal, "failed", errors.New("not a fault"))
+	if st := status.Convert(err); st.Code() != codes.Internal || st.Message() != "failed" || len(st.Details()) != 0 {
+		t.Errorf("unexpected status for a plain error: %+v", st.Proto())
+	}
+	fault := &volume.Fault{Type: "NotFound", Message: "The object was not found.", TaskID: "task-1", OpID: "op-1"}
+	st := status.Convert(ToStatusError(codes.Internal, "failed", fault))
+	if st.Code() != codes.NotFound || st.Message() != "failed" {
+		t.Errorf("unexpected status for a fault: %+v", st.Proto())
+	}
+	var found int
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.DebugInfo:
+			if d.Detail == fault.Type {
+				found++
+			}
+		case *errdetails.RequestInfo:
+			if d.RequestId == fault.OpID && d.ServingData == fault.TaskID {
+				found++
+			}
+		case *errdetails.LocalizedMessage:
+			if d.Message == fault.Message {
+				found++
+			}
+		}
+	}
+	if found != 3 {
+		t.Errorf("expected fault type, task, opId and message in status details, got %+v", st.Details())
+	}
+	tests := []struct {
+		faultType string
+		expected  codes.Code
+	}{
+		{"ResourceInUse", codes.FailedPrecondition},
+		{"InvalidState", codes.Aborted},
+		{"HostNotConnected", codes.Unavailable},
+		{"CnsFault", codes.Internal},
+	}
+	for _, test := range tests {
+		err := ToStatusError(codes.Internal, "failed", &volume.Fault{Type: test.faultType})
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s: ToStatusError() returned code %v, expected %v", test.faultType, code, test.expected)
+		}
+	}
+	netErr := &url.Error{Op: "Post", URL: "https://vcenter/sdk", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	if code := status.Code(ToStatusError(codes.Internal, "failed", netErr)); code != codes.Unavailable {
+		t.Errorf("network error: ToStatusError() returned code %v, expected %v", code, codes.Unavailable)
+	}
+}
+
+func TestIsValidVolumeCapabilities(t *testing.T) {
+	newCap := func(block bool, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		volCap := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+		if block {
+			volCap.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			volCap.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+		}
+		return volCap
+	}
+	tests := []struct {
+		name        string
+		volCap      *csi.VolumeCapability
+		valid       bool
+		multiWriter bool
+		file        bool
+	}{
+		{name: "mount single node writer", volCap: newCap(false, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), valid: true},
+		{name: "block single node writer", volCap: newCap(true, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), valid: true},
+		{
+			name:        "block multi node multi writer",
+			volCap:      newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			valid:       true,
+			multiWriter: true,
+		},
+		{
+			name:        "mount multi node multi writer",
+			volCap:      newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			valid:       true,
+			multiWriter: true,
+			file:        true,
+		},
+		{
+			name:   "mount multi node reader",
+			volCap: newCap(false, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			valid:  true,
+			file:   true,
+		},
+		{name: "block multi node reader", volCap: newCap(true, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)},
+	}
+	for _, test := range tests {
+		volCaps := []*csi.VolumeCapability{test.volCap}
+		if valid := IsValidVolumeCapabilities(volCaps); valid != test.valid {
+			t.Errorf("%s: IsValidVolumeCapabilities() = %t, expected %t", test.name, valid, test.valid)
+		}
+		if multiWriter := IsMultiWriterVolume(volCaps); multiWriter != test.multiWriter {
+			t.Errorf("%s: IsMultiWriterVolume() = %t, expected %t", test.name, multiWriter, test.multiWriter)
+		}
+		if file := IsFileVolumeRequest(volCaps); file != test.file {
+			t.Errorf("%s: IsFileVolumeRequest() = %t, expected %t", test.name, file, test.file)
+		}
+	}
+}
+
+func TestIsFileVolumeID(t *testing.T) {
+	tests := map[string]bool{
+		"file:53bf6fb7-8ff8-4e09-9a21-4a3f3bde3d53": true,
+		"98e5df87-88e8-49a4-ae54-51037a204cab":      false,
+		"":                                          false,
+	}
+	for volumeID, expected := range tests {
+		if actual := IsFileVolumeID(volumeID); actual != expected {
+			t.Errorf("IsFileVolumeID(%q) = %t, expected %t", volumeID, actual, expected)
+		}
+	}
+}
+
+func TestEncodeVolumeID(t *testing.T) {
+	tests := []struct {
+		cnsVolumeID string
+		vcHost      string
+		expected    string
+	}{
+		{"4b1d6e16-0a3e-4f3c-8e0b-8d2d5e4b1c1a", "", "4b1d6e16-0a3e-4f3c-8e0b-8d2d5e4b1c1a"},
+		{"4b1d6e16-0a3e-4f3c-8e0b-8d2d5e4b1c1a", "vcenter2", "4b1d6e16-0a3e-4f3c-8e0b-8d2d5e4b1c1a@vcenter2"},
+		{"file:53bf6fb7-8ff8-4e09-9a21-4a3f3bde3d53", "10.0.0.2", "file:53bf6fb7-8ff8-4e09-9a21-4a3f3bde3d53@10.0.0.2"},
+	}
+	for _, test := range tests {
+		volumeID := EncodeVolumeID(test.cnsVolumeID, test.vcHost)
+		if volumeID != test.expected {
+			t.Errorf("EncodeVolumeID(%q, %q) = %q, expected %q", test.cnsVolumeID, test.vcHost, volumeID, test.expected)
+		}
+		if cnsVolumeID, vcHost := DecodeVolumeID(volumeID); cnsVolumeID != test.cnsVolumeID || vcHost != test.vcHost {
+			t.Errorf("DecodeVolumeID(%q) = %q, %q, expected %q, %q", volumeID, cnsVolumeID, vcHost, test.cnsVolumeID, test.vcHost)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// datastoreNotAccessible is the DatastoreAccessibilityStatus CNS reports for volumes on an inaccessible datastore
+const datastoreNotAccessible = "notAccessible"
+
+// GetVolumeConditionUtil is the helper function to get the condition of the CNS volume. The volume is abnormal
+// if CNS reports its datastore inaccessible or if the First Class Disk backing it is not found.
+// File volumes are not backed by a First Class Disk, hence only their datastore is checked.
+func GetVolumeConditionUtil(ctx context.Context, manager *Manager, volume *cnstypes.CnsVolume) (*csi.VolumeCondition, error) {
+	if condition := getDatastoreCondition(volume); condition != nil {
+		return condition, nil
+	}
+	if volume.VolumeType == FileVolumeType {
+		return &csi.VolumeCondition{Message: "Volume is healthy"}, nil
+	}
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return nil, err
+	}
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = vc.RetrieveVStorageObject(ctx, datastore.Reference(), volume.VolumeId.Id); err != nil {
+		if vsphere.IsNotFoundError(err) {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("First Class Disk backing the volume is not found on datastore %s", volume.DatastoreUrl),
+			}, nil
+		}
+		return nil, err
+	}
+	return &csi.VolumeCondition{Message: "Volume is healthy"}, nil
+}
+
+// getDatastoreCondition returns an abnormal condition if CNS reports the datastore of the volume inaccessible,
+// otherwise nil
+func getDatastoreCondition(volume *cnstypes.CnsVolume) *csi.VolumeCondition {
+	if volume.DatastoreAccessibilityStatus != datastoreNotAccessible {
+		return nil
+	}
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  fmt.Sprintf("Datastore %s of the volume is not accessible", volume.DatastoreUrl),
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+func TestGetDatastoreCondition(t *testing.T) {
+	tests := []struct {
+		status   string
+		abnormal bool
+	}{
+		{status: "", abnormal: false},
+		{status: "accessible", abnormal: false},
+		{status: "notAccessible", abnormal: true},
+	}
+	for _, test := range tests {
+		volume := &cnstypes.CnsVolume{DatastoreUrl: "ds:///vmfs/volumes/ds-1/", DatastoreAccessibilityStatus: test.status}
+		condition := getDatastoreCondition(volume)
+		if abnormal := condition != nil && condition.Abnormal; abnormal != test.abnormal {
+			t.Errorf("getDatastoreCondition() with status %q = %+v, expected abnormal %t", test.status, condition, test.abnormal)
+		}
+	}
+}
@@ -20,12 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	cnstypes "github.com/vmware/govmomi/cns/types"
 	vim25types "github.com/vmware/govmomi/vim25/types"
+	vsanfstypes "github.com/vmware/govmomi/vsan/vsanfs/types"
 	"k8s.io/klog"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
 )
 
 // CreateVolumeUtil is the helper function to create CNS volume
@@ -35,6 +39,25 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
 		return "", err
 	}
+	// A CreateVolume interrupted by a restart of the controller may have created the volume after all.
+	// Retries of the request carry the same name, hence return that volume rather than creating another disk.
+	existingVolumeID, err := getVolumeIDByName(ctx, manager, spec.Name)
+	if err != nil {
+		klog.Errorf("Failed to query volume %s, err: %+v", spec.Name, err)
+		return "", err
+	}
+	if existingVolumeID != "" {
+		return getExistingVolume(ctx, manager, vc, existingVolumeID, spec, sharedDatastores)
+	}
+	if err = placeInDatastoreCluster(ctx, manager, vc, spec, sharedDatastores); err != nil {
+		return "", err
+	}
+	if spec.MultiWriter || spec.ProvisioningType != "" {
+		return createDiskVolume(ctx, manager, vc, spec, sharedDatastores)
+	}
+	if spec.FileVolume {
+		return createFileVolume(ctx, manager, vc, spec, sharedDatastores)
+	}
 	if spec.StoragePolicyName != "" {
 		// Get Storage Policy ID from Storage Policy Name
 		err = vc.ConnectPbm(ctx)
@@ -48,10 +71,21 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 			return "", err
 		}
 	}
+	sharedDatastores, err = getCompatibleDatastores(ctx, vc, spec, sharedDatastores)
+	if err != nil {
+		return "", err
+	}
 	var datastores []vim25types.ManagedObjectReference
+	// candidates are the datastores a CreateVolume failure can be blamed on
+	var candidates []*vsphere.DatastoreInfo
 	if spec.DatastoreURL == "" {
-		//  If DatastoreURL is not specified in StorageClass, get all shared datastores
-		datastores = getDatastoreMoRefs(sharedDatastores)
+		//  If DatastoreURL is not specified in StorageClass, get all shared datastores which are not quarantined
+		candidates, err = manager.DatastorePlacement.Order(spec, manager.DatastoreQuarantine.Filter(sharedDatastores))
+		if err != nil {
+			return "", err
+		}
+		candidates = manager.DatastorePlacement.Choose(candidates)
+		datastores = getDatastoreMoRefs(candidates)
 	} else {
 		// Check datastore specified in the StorageClass should be shared datastore across all nodes.
 
@@ -75,6 +109,7 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 			for _, sharedDatastore := range sharedDatastores {
 				if sharedDatastore.Info.Url == spec.DatastoreURL {
 					isSharedDatastoreURL = true
+					candidates = []*vsphere.DatastoreInfo{sharedDatastore}
 					break
 				}
 			}
@@ -89,8 +124,15 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		}
 		if isSharedDatastoreURL {
 			datastores = append(datastores, datastoreObj.Reference())
+			if _, err = getVvolProfileSpec(ctx, vc, spec, candidates[0]); err != nil {
+				return "", err
+			}
 		} else {
 			errMsg := fmt.Sprintf("Datastore: %s specified in the storage class is not accessible to all nodes.", spec.DatastoreURL)
+			if spec.StoragePolicyID != "" {
+				errMsg = fmt.Sprintf("Datastore: %s specified in the storage class is not accessible to all nodes "+
+					"or not compatible with the storage policy.", spec.DatastoreURL)
+			}
 			klog.Errorf(errMsg)
 			return "", errors.New(errMsg)
 		}
@@ -115,20 +157,652 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		createSpec.Profile = append(createSpec.Profile, profileSpec)
 	}
 	klog.V(4).Infof("vSphere CNS driver creating volume %s with create spec %+v", spec.Name, spew.Sdump(createSpec))
-	volumeID, err := manager.VolumeManager.CreateVolume(createSpec)
+	volumeID, err := manager.VolumeManager.CreateVolume(ctx, createSpec)
 	if err != nil {
 		klog.Errorf("Failed to create disk %s with error %+v", spec.Name, err)
+		if failedDatastore := getFailedDatastore(err, candidates); failedDatastore != nil {
+			manager.DatastoreQuarantine.RecordFailure(vc, failedDatastore)
+		}
+		return "", err
+	}
+	if err = setControlFlags(ctx, manager, vc, volumeID.Id, spec, sharedDatastores); err != nil {
 		return "", err
 	}
 	return volumeID.Id, nil
 }
 
+// createDiskVolume creates a First Class Disk of the provisioning type of the spec and registers it with CNS,
+// as CNS CreateVolume does not take the provisioning type of the disk. Multi-writer disks are eager zeroed thick,
+// so that they can be attached to several VMs with multi-writer sharing.
+func createDiskVolume(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	provisioningType := spec.ProvisioningType
+	if spec.MultiWriter {
+		provisioningType = string(vim25types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick)
+	}
+	profile, err := getProfileSpec(ctx, vc, spec)
+	if err != nil {
+		return "", err
+	}
+	candidates, err := getProvisioningTypeDatastores(spec.DatastoreURL, provisioningType,
+		manager.DatastoreQuarantine.Filter(sharedDatastores))
+	if err != nil {
+		return "", err
+	}
+	if candidates, err = getCompatibleDatastores(ctx, vc, spec, candidates); err != nil {
+		return "", err
+	}
+	if candidates, err = manager.DatastorePlacement.Order(spec, candidates); err != nil {
+		return "", err
+	}
+	target, diskID, err := getJournaledDisk(ctx, vc, spec.Name, sharedDatastores)
+	if err != nil {
+		return "", err
+	}
+	if target == nil {
+		if target, err = getPlacementDatastore(spec.DatastoreURL, "", candidates); err != nil {
+			return "", err
+		}
+	}
+	if profile == nil {
+		if profile, err = getVvolProfileSpec(ctx, vc, spec, target); err != nil {
+			return "", err
+		}
+	}
+	if diskID == "" {
+		klog.V(2).Infof("Creating %s disk %s of %d MB on datastore %s", provisioningType, spec.Name, spec.CapacityMB, target.Info.Url)
+		diskID, err = createJournaledDisk(ctx, vc, spec.Name, target, func() (string, error) {
+			return vc.CreateVStorageObject(ctx, target.Reference(), spec.Name, spec.CapacityMB, provisioningType, profile)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return registerDiskUtil(ctx, manager, vc, spec, diskID, target, profile, sharedDatastores)
+}
+
+// getProvisioningTypeDatastores returns the datastores among the given ones which support disks of the given
+// provisioning type. The provisioning type of disks on vSAN is set by the object space reservation of the storage
+// policy, hence thick disks are only created on other datastores.
+func getProvisioningTypeDatastores(datastoreURL string, provisioningType string,
+	datastores []*vsphere.DatastoreInfo) ([]*vsphere.DatastoreInfo, error) {
+	if provisioningType == string(vim25types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeThin) {
+		return datastores, nil
+	}
+	if strings.HasPrefix(datastoreURL, VsanDatastoreURLPrefix) {
+		return nil, fmt.Errorf("%s disks are not supported on vSAN datastore %s specified in the storage class, "+
+			"use a storage policy with object space reservation instead", provisioningType, datastoreURL)
+	}
+	var thickDatastores []*vsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if !strings.HasPrefix(datastore.Info.Url, VsanDatastoreURLPrefix) {
+			thickDatastores = append(thickDatastores, datastore)
+		}
+	}
+	if len(thickDatastores) == 0 {
+		return nil, fmt.Errorf("no datastore accessible to all nodes supports %s disks, "+
+			"vSAN datastores require a storage policy with object space reservation instead", provisioningType)
+	}
+	return thickDatastores, nil
+}
+
+// createFileVolume creates a CNS file volume backed by a vSAN file share on one of the given shared datastores
+// which are vSAN datastores, or on the datastore specified in the spec. The share can be mounted read-write by
+// any client, including as root, as the nodes mounting it are not known when it is created.
+func createFileVolume(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	profile, err := getProfileSpec(ctx, vc, spec)
+	if err != nil {
+		return "", err
+	}
+	if profile == nil && spec.StoragePolicyID != "" {
+		profile = append(profile, &vim25types.VirtualMachineDefinedProfileSpec{ProfileId: spec.StoragePolicyID})
+	}
+	candidates, err := getFileVolumeDatastores(spec.DatastoreURL, manager.DatastoreQuarantine.Filter(sharedDatastores))
+	if err != nil {
+		return "", err
+	}
+	if candidates, err = getCompatibleDatastores(ctx, vc, spec, candidates); err != nil {
+		return "", err
+	}
+	if candidates, err = manager.DatastorePlacement.Order(spec, candidates); err != nil {
+		return "", err
+	}
+	candidates = manager.DatastorePlacement.Choose(candidates)
+	createSpec := &cnstypes.CnsVolumeCreateSpec{
+		Name:       spec.Name,
+		VolumeType: FileVolumeType,
+		Datastores: getDatastoreMoRefs(candidates),
+		BackingObjectDetails: &cnstypes.CnsVsanFileShareBackingDetails{
+			CnsFileBackingDetails: cnstypes.CnsFileBackingDetails{
+				CnsBackingObjectDetails: cnstypes.CnsBackingObjectDetails{
+					CapacityInMb: spec.CapacityMB,
+				},
+			},
+		},
+		Metadata: cnstypes.CnsVolumeMetadata{
+			ContainerCluster: vsphere.GetContainerCluster(manager.CnsConfig.Global.ClusterID, manager.CnsConfig.VirtualCenter[vc.Config.Host].User),
+		},
+		CreateSpec: &cnstypes.CnsVSANFileCreateSpec{
+			SoftQuotaInMb: spec.CapacityMB,
+			Permission: []vsanfstypes.VsanFileShareNetPermission{
+				{
+					Ips:         "*",
+					Permissions: vsanfstypes.VsanFileShareAccessTypeREAD_WRITE,
+					AllowRoot:   true,
+				},
+			},
+		},
+		Profile: profile,
+	}
+	klog.V(4).Infof("vSphere CNS driver creating file volume %s with create spec %+v", spec.Name, spew.Sdump(createSpec))
+	volumeID, err := manager.VolumeManager.CreateVolume(ctx, createSpec)
+	if err != nil {
+		klog.Errorf("Failed to create file volume %s with error %+v", spec.Name, err)
+		if failedDatastore := getFailedDatastore(err, candidates); failedDatastore != nil {
+			manager.DatastoreQuarantine.RecordFailure(vc, failedDatastore)
+		}
+		return "", err
+	}
+	return volumeID.Id, nil
+}
+
+// getFileVolumeDatastores returns the vSAN datastores among the given ones a file volume can be created on,
+// which is only the one with the given URL if it is not empty.
+func getFileVolumeDatastores(datastoreURL string, datastores []*vsphere.DatastoreInfo) ([]*vsphere.DatastoreInfo, error) {
+	if datastoreURL != "" && !strings.HasPrefix(datastoreURL, VsanDatastoreURLPrefix) {
+		return nil, fmt.Errorf("datastore %s specified in the storage class is not a vSAN datastore, "+
+			"file volumes are only supported on vSAN datastores", datastoreURL)
+	}
+	var vsanDatastores []*vsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if !strings.HasPrefix(datastore.Info.Url, VsanDatastoreURLPrefix) {
+			continue
+		}
+		if datastoreURL == "" || datastore.Info.Url == datastoreURL {
+			vsanDatastores = append(vsanDatastores, datastore)
+		}
+	}
+	if len(vsanDatastores) == 0 {
+		if datastoreURL != "" {
+			return nil, fmt.Errorf("datastore %s specified in the storage class is not accessible to all nodes", datastoreURL)
+		}
+		return nil, errors.New("no vSAN datastore accessible to all nodes found for file volume")
+	}
+	return vsanDatastores, nil
+}
+
+// GetFileVolumeAccessPoint returns the NFSv4.1 access point of the vSAN file share backing the given file volume,
+// or "" if it has none.
+func GetFileVolumeAccessPoint(volume *cnstypes.CnsVolume) string {
+	if volume == nil {
+		return ""
+	}
+	details, ok := volume.BackingObjectDetails.(*cnstypes.CnsVsanFileShareBackingDetails)
+	if !ok {
+		return ""
+	}
+	for _, accessPoint := range details.AccessPoints {
+		if accessPoint.Key == Nfsv4AccessPointKey {
+			return accessPoint.Value
+		}
+	}
+	return ""
+}
+
+// CloneVolumeUtil is the helper function to create a CNS volume by cloning the First Class Disk backing the
+// given source volume. The clone is placed on the datastore specified in the spec if any, otherwise on the
+// datastore of the source volume if it is one of the given shared datastores, otherwise on another one of them.
+func CloneVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeSpec, sourceVolumeID string,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return "", err
+	}
+	// As in CreateVolumeFromSnapshotUtil, retries of the request carry the same name
+	existingVolumeID, err := getVolumeIDByName(ctx, manager, spec.Name)
+	if err != nil {
+		klog.Errorf("Failed to query volume %s, err: %+v", spec.Name, err)
+		return "", err
+	}
+	if existingVolumeID != "" {
+		return getExistingVolume(ctx, manager, vc, existingVolumeID, spec, sharedDatastores)
+	}
+	if err = placeInDatastoreCluster(ctx, manager, vc, spec, sharedDatastores); err != nil {
+		return "", err
+	}
+	sourceVolume, err := manager.VolumeManager.RefreshVolume(sourceVolumeID)
+	if err != nil {
+		return "", err
+	}
+	if sourceVolume == nil {
+		return "", fmt.Errorf("source volume %s not found", sourceVolumeID)
+	}
+	sourceDatastore, err := GetDatastoreByURL(ctx, vc, sourceVolume.DatastoreUrl)
+	if err != nil {
+		return "", err
+	}
+	profile, err := getProfileSpec(ctx, vc, spec)
+	if err != nil {
+		return "", err
+	}
+	candidates, err := getCompatibleDatastores(ctx, vc, spec, manager.DatastoreQuarantine.Filter(sharedDatastores))
+	if err != nil {
+		return "", err
+	}
+	if candidates, err = manager.DatastorePlacement.Order(spec, candidates); err != nil {
+		return "", err
+	}
+	target, diskID, err := getJournaledDisk(ctx, vc, spec.Name, sharedDatastores)
+	if err != nil {
+		return "", err
+	}
+	if target == nil {
+		if target, err = getPlacementDatastore(spec.DatastoreURL, sourceVolume.DatastoreUrl, candidates); err != nil {
+			return "", err
+		}
+	}
+	if profile == nil {
+		if profile, err = getVvolProfileSpec(ctx, vc, spec, target); err != nil {
+			return "", err
+		}
+	}
+	if diskID == "" {
+		klog.V(2).Infof("Cloning volume %s on datastore %s to disk %s on datastore %s",
+			sourceVolumeID, sourceVolume.DatastoreUrl, spec.Name, target.Info.Url)
+		diskID, err = createJournaledDisk(ctx, vc, spec.Name, target, func() (string, error) {
+			return vc.CloneVStorageObject(ctx, sourceDatastore.Reference(), sourceVolumeID, target.Reference(), spec.Name, profile)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return registerDiskUtil(ctx, manager, vc, spec, diskID, target, profile, sharedDatastores)
+}
+
+// getPlacementDatastore returns the datastore to place a volume on, among the given datastores: the datastore
+// with the given URL if any, otherwise the datastore of the source volume if any, otherwise the first one, which
+// is the preferred one of the placement strategy.
+func getPlacementDatastore(datastoreURL string, sourceDatastoreURL string, datastores []*vsphere.DatastoreInfo) (*vsphere.DatastoreInfo, error) {
+	if len(datastores) == 0 {
+		return nil, errors.New("no shared datastore to place the volume on")
+	}
+	for _, preferredURL := range []string{datastoreURL, sourceDatastoreURL} {
+		if preferredURL == "" {
+			continue
+		}
+		for _, datastore := range datastores {
+			if datastore.Info.Url == preferredURL {
+				return datastore, nil
+			}
+		}
+		if preferredURL == datastoreURL {
+			return nil, fmt.Errorf("datastore: %s specified in the storage class is not accessible to all nodes", datastoreURL)
+		}
+	}
+	return datastores[0], nil
+}
+
+// placeInDatastoreCluster sets the datastore URL of the spec to the member datastore of its Storage DRS
+// datastore cluster recommended by Storage DRS, among the given shared datastores which are not quarantined
+// and are compatible with the storage policy of the spec. The spec is left as is if it has no datastore cluster.
+func placeInDatastoreCluster(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) error {
+	if spec.DatastoreCluster == "" {
+		return nil
+	}
+	if _, err := getProfileSpec(ctx, vc, spec); err != nil {
+		return err
+	}
+	candidates, err := getCompatibleDatastores(ctx, vc, spec, manager.DatastoreQuarantine.Filter(sharedDatastores))
+	if err != nil {
+		return err
+	}
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get datacenters from vCenter, err: %+v", err)
+		return err
+	}
+	for _, dc := range datacenters {
+		pod, err := dc.GetDatastoreClusterByName(ctx, spec.DatastoreCluster)
+		if err != nil {
+			continue
+		}
+		recommendations, err := dc.RecommendDatastores(ctx, pod, spec.Name, spec.CapacityMB)
+		if err != nil {
+			return err
+		}
+		target := selectRecommendedDatastore(recommendations, candidates)
+		if target == nil {
+			return fmt.Errorf("none of the datastores recommended by Storage DRS in datastore cluster: %s is accessible to all nodes",
+				spec.DatastoreCluster)
+		}
+		klog.V(2).Infof("Storage DRS placed volume %s on datastore %s of datastore cluster %s",
+			spec.Name, target.Info.Url, spec.DatastoreCluster)
+		spec.DatastoreURL = target.Info.Url
+		return nil
+	}
+	return fmt.Errorf("datastore cluster: %s specified in the storage class is not found", spec.DatastoreCluster)
+}
+
+// selectRecommendedDatastore returns the first of the recommended datastores which is among the given
+// datastores, or nil if there is none.
+func selectRecommendedDatastore(recommendations []vim25types.ManagedObjectReference,
+	datastores []*vsphere.DatastoreInfo) *vsphere.DatastoreInfo {
+	for _, recommendation := range recommendations {
+		for _, datastore := range datastores {
+			if datastore.Reference() == recommendation {
+				return datastore
+			}
+		}
+	}
+	return nil
+}
+
+// getCompatibleDatastores returns the datastores among the given ones which are compatible with the storage policy
+// of the spec, or all of them if the spec has no storage policy. The storage policy ID must be resolved already.
+func getCompatibleDatastores(ctx context.Context, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec,
+	datastores []*vsphere.DatastoreInfo) ([]*vsphere.DatastoreInfo, error) {
+	if spec.StoragePolicyID == "" || len(datastores) == 0 {
+		return datastores, nil
+	}
+	if err := vc.ConnectPbm(ctx); err != nil {
+		klog.Errorf("Error occurred while connecting to PBM, err: %+v", err)
+		return nil, err
+	}
+	compatibleDatastores, err := vc.GetCompatibleDatastores(ctx, spec.StoragePolicyID, datastores)
+	if err != nil {
+		return nil, err
+	}
+	if len(compatibleDatastores) == 0 {
+		policy := spec.StoragePolicyName
+		if policy == "" {
+			policy = spec.StoragePolicyID
+		}
+		return nil, fmt.Errorf("no datastore accessible to all nodes is compatible with storage policy %q", policy)
+	}
+	klog.V(4).Infof("%d of %d datastores are compatible with storage policy %s",
+		len(compatibleDatastores), len(datastores), spec.StoragePolicyID)
+	return compatibleDatastores, nil
+}
+
+// getProfileSpec returns the profile of the storage policy in the spec, setting the id of the storage policy
+// in the spec, or nil if the spec has no storage policy.
+func getProfileSpec(ctx context.Context, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec) ([]vim25types.BaseVirtualMachineProfileSpec, error) {
+	if spec.StoragePolicyName == "" {
+		return nil, nil
+	}
+	err := vc.ConnectPbm(ctx)
+	if err != nil {
+		klog.Errorf("Error occurred while connecting to PBM, err: %+v", err)
+		return nil, err
+	}
+	spec.StoragePolicyID, err = vc.GetStoragePolicyIDByName(ctx, spec.StoragePolicyName)
+	if err != nil {
+		klog.Errorf("Error occurred while getting Profile Id from Profile Name: %s, err: %+v", spec.StoragePolicyName, err)
+		return nil, err
+	}
+	return []vim25types.BaseVirtualMachineProfileSpec{
+		&vim25types.VirtualMachineDefinedProfileSpec{ProfileId: spec.StoragePolicyID},
+	}, nil
+}
+
+// getVvolProfileSpec returns the profile of the default storage policy of the datastore, setting the id of the
+// storage policy in the spec, if the volume without storage policy is placed on a vVol datastore, or nil otherwise.
+// vVols take the storage policy of their VM, which First Class Disks have none of, hence need the policy explicitly.
+func getVvolProfileSpec(ctx context.Context, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec,
+	datastore *vsphere.DatastoreInfo) ([]vim25types.BaseVirtualMachineProfileSpec, error) {
+	if spec.StoragePolicyID != "" || !IsVvolDatastoreURL(datastore.Info.Url) {
+		return nil, nil
+	}
+	if err := vc.ConnectPbm(ctx); err != nil {
+		klog.Errorf("Error occurred while connecting to PBM, err: %+v", err)
+		return nil, err
+	}
+	storagePolicyID, err := vc.GetDefaultStoragePolicyID(ctx, datastore)
+	if err != nil || storagePolicyID == "" {
+		return nil, err
+	}
+	klog.V(2).Infof("Volume %s on vVol datastore %s gets the default storage policy %s of the datastore",
+		spec.Name, datastore.Info.Url, storagePolicyID)
+	spec.StoragePolicyID = storagePolicyID
+	return []vim25types.BaseVirtualMachineProfileSpec{
+		&vim25types.VirtualMachineDefinedProfileSpec{ProfileId: storagePolicyID},
+	}, nil
+}
+
+// IsVvolDatastoreURL returns true if the datastore URL is the URL of a vVol datastore
+func IsVvolDatastoreURL(datastoreURL string) bool {
+	return strings.HasPrefix(datastoreURL, VvolDatastoreURLPrefix)
+}
+
+// registerDiskUtil registers the First Class Disk with the given id residing on the given datastore with CNS
+// as a volume with the name of the spec. The disk is deleted if CNS fails to register it, as a retry of the request
+// would create another disk, unless deleting it fails, in which case the retry registers it again.
+func registerDiskUtil(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, spec *CreateVolumeSpec, diskID string,
+	datastore *vsphere.DatastoreInfo, profile []vim25types.BaseVirtualMachineProfileSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	createSpec := &cnstypes.CnsVolumeCreateSpec{
+		Name:       spec.Name,
+		VolumeType: BlockVolumeType,
+		Datastores: []vim25types.ManagedObjectReference{datastore.Reference()},
+		BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{
+			BackingDiskId: diskID,
+		},
+		Metadata: cnstypes.CnsVolumeMetadata{
+			ContainerCluster: vsphere.GetContainerCluster(manager.CnsConfig.Global.ClusterID, manager.CnsConfig.VirtualCenter[vc.Config.Host].User),
+		},
+		Profile: profile,
+	}
+	klog.V(4).Infof("vSphere CNS driver registering disk %s as volume %s with create spec %+v", diskID, spec.Name, spew.Sdump(createSpec))
+	volumeID, err := manager.VolumeManager.CreateVolume(ctx, createSpec)
+	if err != nil {
+		klog.Errorf("Failed to register disk %s as volume %s with error %+v", diskID, spec.Name, err)
+		if deleteErr := vc.DeleteVStorageObject(ctx, datastore.Reference(), diskID); deleteErr != nil {
+			klog.Warningf("Failed to delete disk %s. err: %+v", diskID, deleteErr)
+		} else {
+			removeJournaledDisk(vc, spec.Name)
+		}
+		return "", err
+	}
+	removeJournaledDisk(vc, spec.Name)
+	if err = setControlFlags(ctx, manager, vc, volumeID.Id, spec, sharedDatastores); err != nil {
+		return "", err
+	}
+	return volumeID.Id, nil
+}
+
+// getExistingVolume returns the id of the given volume created by an earlier attempt of the request with the
+// given spec, enabling Changed Block Tracking on it again if requested, as the failure to enable it fails the request.
+func getExistingVolume(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, volumeID string, spec *CreateVolumeSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) (string, error) {
+	klog.V(2).Infof("Volume %s already exists with volumeID %s", spec.Name, volumeID)
+	if spec.ChangedBlockTracking {
+		if err := setControlFlags(ctx, manager, vc, volumeID, spec, sharedDatastores); err != nil {
+			return "", err
+		}
+	}
+	return volumeID, nil
+}
+
+// getVolumeIDByName returns the ID of the volume of this cluster with the given name, or "" if there is none
+func getVolumeIDByName(ctx context.Context, manager *Manager, name string) (string, error) {
+	clusterID := manager.CnsConfig.Global.ClusterID
+	queryFilter := cnstypes.CnsQueryFilter{
+		Names:               []string{name},
+		ContainerClusterIds: []string{clusterID},
+	}
+	queryResult, err := manager.VolumeManager.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		return "", err
+	}
+	for _, volume := range queryResult.Volumes {
+		if volume.Name == name && volume.Metadata.ContainerCluster.ClusterId == clusterID {
+			return volume.VolumeId.Id, nil
+		}
+	}
+	return "", nil
+}
+
+// getDiskJournalKey returns the key of the First Class Disk with the given name in the task journal. Disks created
+// before registering them with CNS are recorded in the task journal until they are registered, by the URL of their
+// datastore followed by their id once it is known.
+func getDiskJournalKey(vc *vsphere.VirtualCenter, name string) string {
+	return volume.GetJournalKey(prometheus.TaskTypeCreateVStorageObject, vc.Config.Host, name)
+}
+
+// getJournaledDisk returns the datastore and the id of the First Class Disk with the given name recorded in the task
+// journal, or a nil datastore if there is none. Volumes whose disk is created before registering it with CNS have no
+// CNS volume with the name yet, hence a recorded disk is left over by an attempt interrupted before registering it,
+// e.g. by a restart of the controller, and is registered rather than creating another disk. Only if the attempt was
+// interrupted while creating the disk, its datastore is searched for the disk by name, as its id is not recorded.
+func getJournaledDisk(ctx context.Context, vc *vsphere.VirtualCenter, name string,
+	sharedDatastores []*vsphere.DatastoreInfo) (*vsphere.DatastoreInfo, string, error) {
+	journal := volume.GetTaskJournal()
+	if journal == nil {
+		return nil, "", nil
+	}
+	key := getDiskJournalKey(vc, name)
+	fields := strings.Fields(journal.Get(key))
+	if len(fields) == 0 {
+		return nil, "", nil
+	}
+	var datastore *vsphere.DatastoreInfo
+	for _, sharedDatastore := range sharedDatastores {
+		if sharedDatastore.Info.Url == fields[0] {
+			datastore = sharedDatastore
+			break
+		}
+	}
+	if datastore == nil {
+		klog.Warningf("Datastore %s of disk %q recorded under %q is not accessible to all nodes, creating another disk",
+			fields[0], name, key)
+		journal.Remove(key)
+		return nil, "", nil
+	}
+	var diskID string
+	if len(fields) > 1 {
+		if _, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), fields[1]); err != nil {
+			if vsphere.IsNetworkError(err) {
+				return nil, "", err
+			}
+			klog.Warningf("Disk %s %q recorded under %q is gone, creating another disk. err=%v", fields[1], name, key, err)
+			journal.Remove(key)
+			return nil, "", nil
+		}
+		diskID = fields[1]
+	} else {
+		var err error
+		if diskID, err = getDiskIDByName(ctx, vc, datastore, name); err != nil {
+			return nil, "", err
+		}
+		if diskID == "" {
+			journal.Remove(key)
+			return nil, "", nil
+		}
+	}
+	klog.V(2).Infof("Found disk %s %q on datastore %s not registered with CNS", diskID, name, datastore.Info.Url)
+	return datastore, diskID, nil
+}
+
+// createJournaledDisk creates the First Class Disk with the given name on the given datastore with create, recording
+// it in the task journal until registerDiskUtil registers it, see getJournaledDisk. The record is removed if create
+// fails, unless the disk may still get created as ctx is done or vCenter could not be reached.
+func createJournaledDisk(ctx context.Context, vc *vsphere.VirtualCenter, name string, datastore *vsphere.DatastoreInfo,
+	create func() (string, error)) (string, error) {
+	journal := volume.GetTaskJournal()
+	if journal == nil {
+		return create()
+	}
+	key := getDiskJournalKey(vc, name)
+	journal.Record(key, datastore.Info.Url)
+	diskID, err := create()
+	if err != nil {
+		if ctx.Err() == nil && !vsphere.IsNetworkError(err) {
+			journal.Remove(key)
+		}
+		return "", err
+	}
+	journal.Record(key, datastore.Info.Url+" "+diskID)
+	return diskID, nil
+}
+
+// removeJournaledDisk removes the First Class Disk with the given name from the task journal
+func removeJournaledDisk(vc *vsphere.VirtualCenter, name string) {
+	if journal := volume.GetTaskJournal(); journal != nil {
+		journal.Remove(getDiskJournalKey(vc, name))
+	}
+}
+
+// getDiskIDByName returns the ID of the First Class Disk with the given name on the given datastore, or ""
+// if there is none
+func getDiskIDByName(ctx context.Context, vc *vsphere.VirtualCenter, datastore *vsphere.DatastoreInfo,
+	name string) (string, error) {
+	diskIDs, err := vc.ListVStorageObjects(ctx, datastore.Reference())
+	if err != nil {
+		return "", err
+	}
+	for _, diskID := range diskIDs {
+		vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), diskID.Id)
+		if err != nil {
+			continue
+		}
+		if vStorageObject.Config.Name == name {
+			return diskID.Id, nil
+		}
+	}
+	return "", nil
+}
+
+// setControlFlags sets the keepAfterDeleteVm control flag on the First Class Disk backing the given volume, so that
+// it is not deleted along with a VM it gets attached to, and the enableChangedBlockTracking control flag if the spec
+// enables Changed Block Tracking. Failing to set keepAfterDeleteVm is not fatal as the flag is enforced again
+// on attach, unlike failing to enable Changed Block Tracking, which backups of the volume depend on.
+func setControlFlags(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, volumeID string, spec *CreateVolumeSpec,
+	sharedDatastores []*vsphere.DatastoreInfo) error {
+	controlFlags := []string{string(vim25types.VslmVStorageObjectControlFlagKeepAfterDeleteVm)}
+	if spec.ChangedBlockTracking {
+		controlFlags = append(controlFlags, string(vim25types.VslmVStorageObjectControlFlagEnableChangedBlockTracking))
+	}
+	err := setVolumeControlFlags(ctx, manager, vc, volumeID, controlFlags, sharedDatastores)
+	if err == nil {
+		return nil
+	}
+	if spec.ChangedBlockTracking {
+		klog.Errorf("Failed to enable Changed Block Tracking on volume %s. err: %+v", volumeID, err)
+		return err
+	}
+	klog.Warningf("Failed to set keepAfterDeleteVm on volume %s. err: %+v", volumeID, err)
+	return nil
+}
+
+// setVolumeControlFlags sets the given control flags on the First Class Disk backing the given volume.
+func setVolumeControlFlags(ctx context.Context, manager *Manager, vc *vsphere.VirtualCenter, volumeID string, controlFlags []string,
+	sharedDatastores []*vsphere.DatastoreInfo) error {
+	volume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return fmt.Errorf("volume %s not found", volumeID)
+	}
+	datastoreURL := volume.DatastoreUrl
+	manager.DatastoreQuarantine.RecordSuccess(datastoreURL)
+	for _, sharedDatastore := range sharedDatastores {
+		if sharedDatastore.Info.Url == datastoreURL {
+			return vc.SetVStorageObjectControlFlags(ctx, sharedDatastore.Reference(), volumeID, controlFlags)
+		}
+	}
+	return fmt.Errorf("datastore %s of volume %s not found in shared datastores", datastoreURL, volumeID)
+}
+
 // AttachVolumeUtil is the helper function to attach CNS volume to specified vm
 func AttachVolumeUtil(ctx context.Context, manager *Manager,
 	vm *vsphere.VirtualMachine,
 	volumeID string) (string, error) {
 	klog.V(4).Infof("vSphere CNS driver is attaching volume: %s to node vm: %s", volumeID, vm.InventoryPath)
-	diskUUID, err := manager.VolumeManager.AttachVolume(vm, volumeID)
+	diskUUID, err := manager.VolumeManager.AttachVolume(ctx, vm, volumeID)
 	if err != nil {
 		klog.Errorf("Failed to attach disk %s with err %+v", volumeID, err)
 		return "", err
@@ -137,12 +811,40 @@ func AttachVolumeUtil(ctx context.Context, manager *Manager,
 	return diskUUID, nil
 }
 
+// AttachVolumesUtil is the helper function to attach CNS volumes to specified vm in a single task. It returns the
+// disk UUIDs of the volumes attached and the errors of the volumes which failed to attach, by volume id.
+func AttachVolumesUtil(ctx context.Context, manager *Manager,
+	vm *vsphere.VirtualMachine,
+	volumeIDs []string) (map[string]string, map[string]error) {
+	klog.V(4).Infof("vSphere CNS driver is attaching volumes: %v to node vm: %s", volumeIDs, vm.InventoryPath)
+	diskUUIDs, errs := manager.VolumeManager.AttachVolumes(ctx, vm, volumeIDs)
+	for volumeID, err := range errs {
+		klog.Errorf("Failed to attach disk %s with err %+v", volumeID, err)
+	}
+	klog.V(4).Infof("Successfully attached disks to VM %v. Disk UUIDs are %v", vm, diskUUIDs)
+	return diskUUIDs, errs
+}
+
 // DetachVolumeUtil is the helper function to detach CNS volume from specified vm
 func DetachVolumeUtil(ctx context.Context, manager *Manager,
 	vm *vsphere.VirtualMachine,
 	volumeID string) error {
 	klog.V(4).Infof("vSphere CNS driver is detaching volume: %s from node vm: %s", volumeID, vm.InventoryPath)
-	err := manager.VolumeManager.DetachVolume(vm, volumeID)
+	// The volume may have been detached already, e.g. ahead of a node drain
+	diskUUID, err := volume.GetDiskAttachedToVM(ctx, vm, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to check if disk %s is attached to VM %v with err %+v", volumeID, vm, err)
+		return err
+	}
+	if diskUUID == "" {
+		klog.V(4).Infof("Disk %s is not attached to VM %v", volumeID, vm)
+		return nil
+	}
+	// Multi-writer disks are attached by reconfiguring the VM rather than through CNS, hence detached alike
+	if detached, err := volume.DetachMultiWriterDisk(ctx, vm, volumeID); err != nil || detached {
+		return err
+	}
+	err = manager.VolumeManager.DetachVolume(ctx, vm, volumeID)
 	if err != nil {
 		klog.Errorf("Failed to detach disk %s with err %+v", volumeID, err)
 		return err
@@ -155,7 +857,7 @@ func DetachVolumeUtil(ctx context.Context, manager *Manager,
 func DeleteVolumeUtil(ctx context.Context, manager *Manager, volumeID string, deleteDisk bool) error {
 	var err error
 	klog.V(4).Infof("vSphere Cloud Provider deleting volume: %s", volumeID)
-	err = manager.VolumeManager.DeleteVolume(volumeID, deleteDisk)
+	err = manager.VolumeManager.DeleteVolume(ctx, volumeID, deleteDisk)
 	if err != nil {
 		klog.Errorf("Failed to delete disk %s with error %+v", volumeID, err)
 		return err
@@ -164,6 +866,107 @@ func DeleteVolumeUtil(ctx context.Context, manager *Manager, volumeID string, de
 	return nil
 }
 
+// ErrVolumeInUse is returned by ExtendVolumeUtil if the volume is attached to a VM
+var ErrVolumeInUse = errors.New("volume is attached to a VM")
+
+// ExtendVolumeUtil is the helper function to extend the First Class Disk backing the CNS volume to the given
+// capacity. Returns the capacity of the volume, which is left as is if it is already at or above the given
+// capacity. As First Class Disks can only be extended offline, ErrVolumeInUse is returned if the volume is attached.
+func ExtendVolumeUtil(ctx context.Context, manager *Manager, volume *cnstypes.CnsVolume, capacityMB int64) (int64, error) {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return 0, err
+	}
+	volumeID := volume.VolumeId.Id
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return 0, err
+	}
+	vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if vStorageObject.Config.CapacityInMB >= capacityMB {
+		klog.V(2).Infof("Volume %s already has capacity %d MB, requested %d MB", volumeID, vStorageObject.Config.CapacityInMB, capacityMB)
+		return vStorageObject.Config.CapacityInMB, nil
+	}
+	if len(vStorageObject.Config.ConsumerId) > 0 {
+		return 0, ErrVolumeInUse
+	}
+	klog.V(4).Infof("vSphere Cloud Provider extending volume %s from %d MB to %d MB", volumeID, vStorageObject.Config.CapacityInMB, capacityMB)
+	err = vc.ExtendVStorageObject(ctx, datastore.Reference(), volumeID, capacityMB)
+	// The capacity of the cached volume is stale even if the task failed, as it may have extended the disk
+	manager.VolumeManager.EvictVolume(volumeID)
+	if err != nil {
+		klog.Errorf("Failed to extend volume %s with error %+v", volumeID, err)
+		return 0, err
+	}
+	klog.V(4).Infof("Successfully extended volume %s to %d MB", volumeID, capacityMB)
+	return capacityMB, nil
+}
+
+// RetrieveVolumeDiskUtil is the helper function to retrieve the First Class Disk backing the CNS volume
+func RetrieveVolumeDiskUtil(ctx context.Context, manager *Manager, volume *cnstypes.CnsVolume) (*vim25types.VStorageObject, error) {
+	vc, err := GetVCenter(ctx, manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter from Manager, err: %+v", err)
+		return nil, err
+	}
+	datastore, err := GetDatastoreByURL(ctx, vc, volume.DatastoreUrl)
+	if err != nil {
+		return nil, err
+	}
+	return vc.RetrieveVStorageObject(ctx, datastore.Reference(), volume.VolumeId.Id)
+}
+
+// IsMultiWriterDisk returns true if the First Class Disk can be attached to several VMs with multi-writer sharing
+func IsMultiWriterDisk(vStorageObject *vim25types.VStorageObject) bool {
+	backing, ok := vStorageObject.Config.Backing.(*vim25types.BaseConfigInfoDiskFileBackingInfo)
+	return ok && backing.ProvisioningType == string(vim25types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick)
+}
+
+// AttachMultiWriterVolumeUtil is the helper function to attach the CNS volume to the VM with multi-writer sharing,
+// alongside the other VMs it is attached to. Returns the UUID of the disk.
+func AttachMultiWriterVolumeUtil(ctx context.Context, manager *Manager, vm *vsphere.VirtualMachine, volumeID string) (string, error) {
+	cnsVolume, err := manager.VolumeManager.RefreshVolume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if cnsVolume == nil {
+		return "", fmt.Errorf("volume %s not found", volumeID)
+	}
+	vStorageObject, err := RetrieveVolumeDiskUtil(ctx, manager, cnsVolume)
+	if err != nil {
+		return "", err
+	}
+	if !IsMultiWriterDisk(vStorageObject) {
+		return "", fmt.Errorf("volume %s is not provisioned for multi-writer access", volumeID)
+	}
+	backing := vStorageObject.Config.Backing.(*vim25types.BaseConfigInfoDiskFileBackingInfo)
+	klog.V(4).Infof("vSphere CNS driver is attaching multi-writer volume: %s to node vm: %s", volumeID, vm.InventoryPath)
+	diskUUID, err := volume.AttachMultiWriterDisk(ctx, vm, volumeID, backing.Datastore, backing.FilePath)
+	if err != nil {
+		klog.Errorf("Failed to attach disk %s with err %+v", volumeID, err)
+		return "", err
+	}
+	klog.V(4).Infof("Successfully attached disk %s to VM %v. Disk UUID is %s", volumeID, vm, diskUUID)
+	return diskUUID, nil
+}
+
+// ExtendAttachedVolumeUtil is the helper function to extend the First Class Disk backing the CNS volume attached
+// to the VM to the given capacity, while the volume is in use.
+func ExtendAttachedVolumeUtil(ctx context.Context, manager *Manager, vm *vsphere.VirtualMachine, volumeID string, capacityMB int64) error {
+	err := volume.ExtendAttachedDisk(ctx, vm, volumeID, capacityMB)
+	manager.VolumeManager.EvictVolume(volumeID)
+	if err != nil {
+		klog.Errorf("Failed to extend volume %s attached to vm %v with error %+v", volumeID, vm, err)
+		return err
+	}
+	klog.V(4).Infof("Successfully extended volume %s attached to vm %v to %d MB", volumeID, vm, capacityMB)
+	return nil
+}
+
 // Helper function to get DatastoreMoRefs
 func getDatastoreMoRefs(datastores []*vsphere.DatastoreInfo) []vim25types.ManagedObjectReference {
 	var datastoreMoRefs []vim25types.ManagedObjectReference
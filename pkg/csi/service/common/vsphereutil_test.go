@@ -0,0 +1,289 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	vim25types "github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestGetPlacementDatastore(t *testing.T) {
+	datastores := []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///a/"), newDatastoreInfo("ds:///b/")}
+	tests := []struct {
+		name         string
+		datastoreURL string
+		sourceURL    string
+		datastores   []*vsphere.DatastoreInfo
+		expected     string
+		expectError  bool
+	}{
+		{name: "source datastore shared", sourceURL: "ds:///b/", datastores: datastores, expected: "ds:///b/"},
+		{name: "source datastore not shared", sourceURL: "ds:///c/", datastores: datastores, expected: "ds:///a/"},
+		{name: "datastore specified", datastoreURL: "ds:///a/", sourceURL: "ds:///b/", datastores: datastores, expected: "ds:///a/"},
+		{name: "datastore specified not shared", datastoreURL: "ds:///c/", sourceURL: "ds:///b/", datastores: datastores, expectError: true},
+		{name: "no shared datastore", sourceURL: "ds:///a/", expectError: true},
+	}
+	for _, test := range tests {
+		datastore, err := getPlacementDatastore(test.datastoreURL, test.sourceURL, test.datastores)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: getPlacementDatastore() returned %s, expected error", test.name, datastore.Info.Url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getPlacementDatastore() failed: %v", test.name, err)
+			continue
+		}
+		if datastore.Info.Url != test.expected {
+			t.Errorf("%s: getPlacementDatastore() returned %s, expected %s", test.name, datastore.Info.Url, test.expected)
+		}
+	}
+}
+
+func TestGetFileVolumeDatastores(t *testing.T) {
+	vsanA := "ds:///vmfs/volumes/vsan:52a1/"
+	vsanB := "ds:///vmfs/volumes/vsan:52b2/"
+	datastores := []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///vmfs/volumes/5c9b/"), newDatastoreInfo(vsanA), newDatastoreInfo(vsanB)}
+	tests := []struct {
+		name         string
+		datastoreURL string
+		datastores   []*vsphere.DatastoreInfo
+		expected     []string
+	}{
+		{name: "vSAN datastores", datastores: datastores, expected: []string{vsanA, vsanB}},
+		{name: "vSAN datastore specified", datastoreURL: vsanB, datastores: datastores, expected: []string{vsanB}},
+		{name: "vSAN datastore specified not shared", datastoreURL: "ds:///vmfs/volumes/vsan:52c3/", datastores: datastores},
+		{name: "VMFS datastore specified", datastoreURL: "ds:///vmfs/volumes/5c9b/", datastores: datastores},
+		{name: "no vSAN datastore", datastores: datastores[:1]},
+	}
+	for _, test := range tests {
+		candidates, err := getFileVolumeDatastores(test.datastoreURL, test.datastores)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%s: getFileVolumeDatastores() returned %d datastores, expected error", test.name, len(candidates))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getFileVolumeDatastores() failed: %v", test.name, err)
+			continue
+		}
+		var urls []string
+		for _, candidate := range candidates {
+			urls = append(urls, candidate.Info.Url)
+		}
+		if len(urls) != len(test.expected) {
+			t.Errorf("%s: getFileVolumeDatastores() returned %v, expected %v", test.name, urls, test.expected)
+			continue
+		}
+		for i := range urls {
+			if urls[i] != test.expected[i] {
+				t.Errorf("%s: getFileVolumeDatastores() returned %v, expected %v", test.name, urls, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestGetFileVolumeAccessPoint(t *testing.T) {
+	fileVolume := &cnstypes.CnsVolume{
+		BackingObjectDetails: &cnstypes.CnsVsanFileShareBackingDetails{
+			AccessPoints: []vim25types.KeyValue{
+				{Key: "NFSv3", Value: "10.83.28.38:/vsanfs/pvc-1"},
+				{Key: Nfsv4AccessPointKey, Value: "10.83.28.38:/52d7e15c"},
+			},
+		},
+	}
+	blockVolume := &cnstypes.CnsVolume{BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{BackingDiskId: "disk"}}
+	if accessPoint := GetFileVolumeAccessPoint(fileVolume); accessPoint != "10.83.28.38:/52d7e15c" {
+		t.Errorf("GetFileVolumeAccessPoint() of file volume returned %q", accessPoint)
+	}
+	if accessPoint := GetFileVolumeAccessPoint(blockVolume); accessPoint != "" {
+		t.Errorf("GetFileVolumeAccessPoint() of block volume returned %q", accessPoint)
+	}
+	if accessPoint := GetFileVolumeAccessPoint(nil); accessPoint != "" {
+		t.Errorf("GetFileVolumeAccessPoint() of nil volume returned %q", accessPoint)
+	}
+}
+
+func TestGetProvisioningTypeDatastores(t *testing.T) {
+	vmfs := "ds:///vmfs/volumes/5c9b/"
+	vsan := "ds:///vmfs/volumes/vsan:52a1/"
+	datastores := []*vsphere.DatastoreInfo{newDatastoreInfo(vmfs), newDatastoreInfo(vsan)}
+	tests := []struct {
+		name             string
+		datastoreURL     string
+		provisioningType string
+		datastores       []*vsphere.DatastoreInfo
+		expected         int
+		expectError      bool
+	}{
+		{name: "thin", provisioningType: "thin", datastores: datastores, expected: 2},
+		{name: "thin on vSAN", datastoreURL: vsan, provisioningType: "thin", datastores: datastores, expected: 2},
+		{name: "eager zeroed thick", provisioningType: "eagerZeroedThick", datastores: datastores, expected: 1},
+		{name: "lazy zeroed thick on VMFS", datastoreURL: vmfs, provisioningType: "lazyZeroedThick", datastores: datastores, expected: 1},
+		{name: "eager zeroed thick on vSAN", datastoreURL: vsan, provisioningType: "eagerZeroedThick", datastores: datastores, expectError: true},
+		{name: "eager zeroed thick without VMFS", provisioningType: "eagerZeroedThick", datastores: datastores[1:], expectError: true},
+	}
+	for _, test := range tests {
+		candidates, err := getProvisioningTypeDatastores(test.datastoreURL, test.provisioningType, test.datastores)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: getProvisioningTypeDatastores() returned %d datastores, expected error", test.name, len(candidates))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getProvisioningTypeDatastores() failed: %v", test.name, err)
+			continue
+		}
+		if len(candidates) != test.expected {
+			t.Errorf("%s: getProvisioningTypeDatastores() returned %d datastores, expected %d", test.name, len(candidates), test.expected)
+		}
+	}
+}
+
+func TestSelectRecommendedDatastore(t *testing.T) {
+	newDatastore := func(value string) *vsphere.DatastoreInfo {
+		ref := vim25types.ManagedObjectReference{Type: "Datastore", Value: value}
+		return &vsphere.DatastoreInfo{
+			Datastore: &vsphere.Datastore{Datastore: object.NewDatastore(nil, ref)},
+			Info:      &vim25types.DatastoreInfo{Url: "ds:///vmfs/volumes/" + value + "/"},
+		}
+	}
+	ref := func(value string) vim25types.ManagedObjectReference {
+		return vim25types.ManagedObjectReference{Type: "Datastore", Value: value}
+	}
+	datastores := []*vsphere.DatastoreInfo{newDatastore("datastore-1"), newDatastore("datastore-2")}
+	tests := []struct {
+		name            string
+		recommendations []vim25types.ManagedObjectReference
+		expected        string
+	}{
+		{name: "no recommendation"},
+		{name: "first recommendation", recommendations: []vim25types.ManagedObjectReference{ref("datastore-2"), ref("datastore-1")},
+			expected: "datastore-2"},
+		{name: "inaccessible recommendation", recommendations: []vim25types.ManagedObjectReference{ref("datastore-3"), ref("datastore-1")},
+			expected: "datastore-1"},
+		{name: "no accessible recommendation", recommendations: []vim25types.ManagedObjectReference{ref("datastore-3")}},
+	}
+	for _, test := range tests {
+		datastore := selectRecommendedDatastore(test.recommendations, datastores)
+		value := ""
+		if datastore != nil {
+			value = datastore.Reference().Value
+		}
+		if value != test.expected {
+			t.Errorf("%s: selectRecommendedDatastore() returned %q, expected %q", test.name, value, test.expected)
+		}
+	}
+}
+
+func TestIsVvolDatastoreURL(t *testing.T) {
+	tests := map[string]bool{
+		"ds:///vmfs/volumes/vvol:4c4cee3b7e4b4b3a-9bb5de3ae9d5e7f8/": true,
+		"ds:///vmfs/volumes/vsan:52cdfa80721ff516-ea1e993113acfc77/": false,
+		"ds:///vmfs/volumes/5c9bb20e-009c1e46-4b85-0200483b2a97/":    false,
+	}
+	for url, expected := range tests {
+		if actual := IsVvolDatastoreURL(url); actual != expected {
+			t.Errorf("IsVvolDatastoreURL(%q) returned %v, expected %v", url, actual, expected)
+		}
+	}
+}
+
+// mapJournal is a task journal kept in memory
+type mapJournal map[string]string
+
+func (j mapJournal) Get(key string) string            { return j[key] }
+func (j mapJournal) Record(key string, taskID string) { j[key] = taskID }
+func (j mapJournal) Remove(key string)                { delete(j, key) }
+func (j mapJournal) List(prefix string) map[string]string {
+	tasks := make(map[string]string)
+	for key, taskID := range j {
+		if strings.HasPrefix(key, prefix) {
+			tasks[strings.TrimPrefix(key, prefix)] = taskID
+		}
+	}
+	return tasks
+}
+
+func TestCreateJournaledDisk(t *testing.T) {
+	journal := make(mapJournal)
+	volume.SetTaskJournal(journal)
+	defer volume.SetTaskJournal(nil)
+	vc := &vsphere.VirtualCenter{Config: &vsphere.VirtualCenterConfig{Host: "vc"}}
+	datastore := newDatastoreInfo("ds:///a/")
+	key := getDiskJournalKey(vc, "pvc-1")
+
+	diskID, err := createJournaledDisk(context.TODO(), vc, "pvc-1", datastore, func() (string, error) {
+		if recorded := journal.Get(key); recorded != "ds:///a/" {
+			t.Errorf("createJournaledDisk() recorded %q while creating the disk, expected %q", recorded, "ds:///a/")
+		}
+		return "disk-1", nil
+	})
+	if err != nil || diskID != "disk-1" {
+		t.Fatalf("createJournaledDisk() = %q, %v, expected %q", diskID, err, "disk-1")
+	}
+	if recorded := journal.Get(key); recorded != "ds:///a/ disk-1" {
+		t.Errorf("createJournaledDisk() recorded %q, expected %q", recorded, "ds:///a/ disk-1")
+	}
+	removeJournaledDisk(vc, "pvc-1")
+	if recorded := journal.Get(key); recorded != "" {
+		t.Errorf("removeJournaledDisk() left %q", recorded)
+	}
+
+	if _, err = createJournaledDisk(context.TODO(), vc, "pvc-1", datastore, func() (string, error) {
+		return "", errors.New("create failed")
+	}); err == nil {
+		t.Errorf("createJournaledDisk() succeeded, expected error")
+	}
+	if recorded := journal.Get(key); recorded != "" {
+		t.Errorf("createJournaledDisk() left %q after create failed", recorded)
+	}
+}
+
+func TestGetJournaledDiskNotShared(t *testing.T) {
+	journal := make(mapJournal)
+	volume.SetTaskJournal(journal)
+	defer volume.SetTaskJournal(nil)
+	vc := &vsphere.VirtualCenter{Config: &vsphere.VirtualCenterConfig{Host: "vc"}}
+	key := getDiskJournalKey(vc, "pvc-1")
+
+	datastore, diskID, err := getJournaledDisk(context.TODO(), vc, "pvc-1", []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///a/")})
+	if err != nil || datastore != nil || diskID != "" {
+		t.Errorf("getJournaledDisk() of no disk = %v, %q, %v, expected none", datastore, diskID, err)
+	}
+	journal.Record(key, "ds:///b/ disk-1")
+	datastore, diskID, err = getJournaledDisk(context.TODO(), vc, "pvc-1", []*vsphere.DatastoreInfo{newDatastoreInfo("ds:///a/")})
+	if err != nil || datastore != nil || diskID != "" {
+		t.Errorf("getJournaledDisk() of disk on datastore not shared = %v, %q, %v, expected none", datastore, diskID, err)
+	}
+	if recorded := journal.Get(key); recorded != "" {
+		t.Errorf("getJournaledDisk() left %q of disk on datastore not shared", recorded)
+	}
+}
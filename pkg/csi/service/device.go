@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+const (
+	scsiHostDir = "/sys/class/scsi_host"
+	devMapper   = "/dev/mapper"
+)
+
+// deviceNotFoundError is returned when the disk of a volume does not show up
+// on the node within the disk discovery timeout
+type deviceNotFoundError struct {
+	diskID  string
+	timeout time.Duration
+}
+
+func (e *deviceNotFoundError) Error() string {
+	return fmt.Sprintf("disk: %s not found on node after %v, the volume may not be attached to the node VM", e.diskID, e.timeout)
+}
+
+// GRPCStatus returns the NotFound status the error is reported with
+func (e *deviceNotFoundError) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, e.Error())
+}
+
+// getDiskDiscoveryTimeout returns the disk discovery timeout read from
+// X_CSI_DISK_DISCOVERY_TIMEOUT_SECONDS if set and valid, otherwise the default of 60 seconds
+func getDiskDiscoveryTimeout() time.Duration {
+	timeoutSeconds := common.DefaultDiskDiscoveryTimeoutSeconds
+	if v := os.Getenv(common.EnvDiskDiscoveryTimeoutSeconds); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			timeoutSeconds = value
+		} else {
+			klog.Warningf("%s %s is invalid, will use the default timeout of %d seconds",
+				common.EnvDiskDiscoveryTimeoutSeconds, v, timeoutSeconds)
+		}
+	}
+	return time.Duration(timeoutSeconds) * time.Second
+}
+
+// waitForDevice waits for the disk with the given id to show up on the node and
+// returns its block device. Lookups are retried with the delays of the retry policy
+// in effect until the disk discovery timeout, after which a deviceNotFoundError is returned.
+func waitForDevice(ctx context.Context, diskID string) (*Device, error) {
+	timeout := getDiskDiscoveryTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	policy := backoff.Get()
+	for retry := 1; ; retry++ {
+		dev, err := findDevice(diskID)
+		if status.Code(err) != codes.NotFound {
+			return dev, err
+		}
+		delay := wait.Jitter(policy.Delay(retry), policy.Jitter)
+		klog.V(3).Infof("disk: %s not found on attempt %d. Retrying in %v", diskID, retry, delay)
+		select {
+		case <-ctx.Done():
+			return nil, &deviceNotFoundError{diskID: diskID, timeout: timeout}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// findDevice returns the block device of the disk with the given id, which is
+// the multipath device on top of it if the disk is claimed by multipathd.
+// When the disk is not found, the SCSI buses are rescanned before looking
+// for it again, as udev may not have picked up a disk that was just attached.
+// A NotFound error is returned if the disk is not attached to the node.
+func findDevice(diskID string) (*Device, error) {
+	volPath, err := getDiskPath(diskID, nil)
+	if err == nil && volPath == "" {
+		klog.V(4).Infof("disk: %s not found, rescanning SCSI hosts", diskID)
+		if err = rescanSCSIHosts(scsiHostDir); err != nil {
+			klog.Warningf("failed to rescan SCSI hosts for disk: %s, err: %v", diskID, err)
+		}
+		volPath, err = getDiskPath(diskID, nil)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Error trying to read attached disks: %v", err)
+	}
+	if volPath == "" {
+		return nil, status.Errorf(codes.NotFound,
+			"disk: %s not attached to node", diskID)
+	}
+	dev, err := getDevice(volPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting block device for disk: %s, err: %v", diskID, err)
+	}
+	holder, err := getMultipathHolder(sysBlockDir, filepath.Base(dev.RealDev))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting multipath device of disk: %s, err: %v", diskID, err)
+	}
+	if holder != "" {
+		klog.V(2).Infof("disk: %s at: %s is claimed by multipath device: %s", diskID, dev.RealDev, holder)
+		if dev, err = getDevice(holder); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"error getting multipath device of disk: %s, err: %v", diskID, err)
+		}
+	}
+	klog.V(2).Infof("found disk. diskID: %q, path: %q, device: %q", diskID, dev.FullPath, dev.RealDev)
+	return dev, nil
+}
+
+// rescanSCSIHosts asks the kernel to scan all channels, targets and LUNs of
+// the SCSI hosts under the given sysfs directory for new devices
+func rescanSCSIHosts(hostDir string) error {
+	hosts, err := ioutil.ReadDir(hostDir)
+	if err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		scan := filepath.Join(hostDir, host.Name(), "scan")
+		if err := ioutil.WriteFile(scan, []byte("- - -"), 0200); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMultipathHolder returns the path of the device mapper multipath device
+// holding the block device with the given name under the given sysfs block
+// directory, or an empty string if the device is not part of a multipath map
+func getMultipathHolder(blockDir string, name string) (string, error) {
+	holders, err := ioutil.ReadDir(filepath.Join(blockDir, name, "holders"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, holder := range holders {
+		dmDir := filepath.Join(blockDir, holder.Name(), "dm")
+		uuid, err := ioutil.ReadFile(filepath.Join(dmDir, "uuid"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		// Only multipath maps, not LVM or crypt devices built on the disk
+		if !strings.HasPrefix(string(uuid), "mpath-") {
+			continue
+		}
+		dmName, err := ioutil.ReadFile(filepath.Join(dmDir, "name"))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(devMapper, strings.TrimSpace(string(dmName))), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestGetMultipathHolder(t *testing.T) {
+	blockDir, err := ioutil.TempDir("", "sys-class-block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(blockDir)
+	writeFile := func(path string, content string) {
+		path = filepath.Join(blockDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sdb is a path of the multipath map dm-0, sdc holds an LVM volume dm-1, sdd has no holders
+	writeFile("sdb/holders/dm-0", "")
+	writeFile("dm-0/dm/uuid", "mpath-36000c29f1e8a3b7c6d5e4f3a2b1c0d9e\n")
+	writeFile("dm-0/dm/name", "mpatha\n")
+	writeFile("sdc/holders/dm-1", "")
+	writeFile("dm-1/dm/uuid", "LVM-k3j2h1g0f9e8d7c6b5a4\n")
+	writeFile("dm-1/dm/name", "vg-lv\n")
+	writeFile("sdd/size", "2097152\n")
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"sdb", "/dev/mapper/mpatha"},
+		{"sdc", ""},
+		{"sdd", ""},
+		{"sde", ""},
+	}
+	for _, test := range tests {
+		holder, err := getMultipathHolder(blockDir, test.name)
+		if err != nil || holder != test.expected {
+			t.Errorf("getMultipathHolder(%q) = %q, %v, expected %q", test.name, holder, err, test.expected)
+		}
+	}
+}
+
+func TestRescanSCSIHosts(t *testing.T) {
+	hostDir, err := ioutil.TempDir("", "sys-class-scsi-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostDir)
+	for _, host := range []string{"host0", "host1"} {
+		if err := os.Mkdir(filepath.Join(hostDir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rescanSCSIHosts(hostDir); err != nil {
+		t.Fatalf("rescanSCSIHosts() returned %v", err)
+	}
+	for _, host := range []string{"host0", "host1"} {
+		scan, err := ioutil.ReadFile(filepath.Join(hostDir, host, "scan"))
+		if err != nil || string(scan) != "- - -" {
+			t.Errorf("scan of %s = %q, %v, expected %q", host, scan, err, "- - -")
+		}
+	}
+}
+
+func TestDeviceNotFoundError(t *testing.T) {
+	var err error = &deviceNotFoundError{diskID: "6000c29f1e8a3b7c6d5e4f3a2b1c0d9e", timeout: time.Minute}
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("status.Code() of deviceNotFoundError = %v, expected %v", code, codes.NotFound)
+	}
+}
+
+func TestGetDiskDiscoveryTimeout(t *testing.T) {
+	defer os.Unsetenv(common.EnvDiskDiscoveryTimeoutSeconds)
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", time.Minute},
+		{"0", 0},
+		{"300", 5 * time.Minute},
+		{"-1", time.Minute},
+		{"1m", time.Minute},
+	}
+	for _, test := range tests {
+		os.Setenv(common.EnvDiskDiscoveryTimeoutSeconds, test.value)
+		if timeout := getDiskDiscoveryTimeout(); timeout != test.expected {
+			t.Errorf("getDiskDiscoveryTimeout() with %q = %v, expected %v", test.value, timeout, test.expected)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	csictx "github.com/rexray/gocsi/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// ephemeralVolume is the state of an ephemeral inline volume published on the node.
+// It is kept in a file named after the volume handle, as the NodeUnpublishVolume
+// request does not tell ephemeral volumes apart.
+type ephemeralVolume struct {
+	// VolumeID is the id of the CNS volume created for the ephemeral volume
+	VolumeID string `json:"volumeID"`
+}
+
+// isEphemeral returns whether the volume context is the one of an ephemeral inline volume
+func isEphemeral(volumeContext map[string]string) bool {
+	return volumeContext[common.AttributeEphemeral] == "true"
+}
+
+// getEphemeralStatePath returns the path of the state file of the ephemeral volume with the given handle
+func getEphemeralStatePath(volID string) string {
+	dir := os.Getenv(common.EnvEphemeralStateDir)
+	if dir == "" {
+		dir = common.DefaultEphemeralStateDir
+	}
+	return filepath.Join(dir, volID+".json")
+}
+
+// readEphemeralState returns the state of the ephemeral volume with the given handle,
+// or nil if the volume is not an ephemeral volume published on the node
+func readEphemeralState(volID string) (*ephemeralVolume, error) {
+	data, err := ioutil.ReadFile(getEphemeralStatePath(volID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &ephemeralVolume{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// writeEphemeralState saves the state of the ephemeral volume with the given handle
+func writeEphemeralState(volID string, state *ephemeralVolume) error {
+	statePath := getEphemeralStatePath(volID)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath, data, 0600)
+}
+
+// getEphemeralSpec returns the spec of the CNS volume to create for the ephemeral
+// volume with the given handle and volume attributes, and its filesystem type
+func getEphemeralSpec(volID string, volumeContext map[string]string) (*common.CreateVolumeSpec, string, error) {
+	spec := &common.CreateVolumeSpec{
+		Name:       volID,
+		CapacityMB: common.DefaultGbDiskSize * common.GbInBytes / common.MbInBytes,
+	}
+	fsType := common.DefaultFsType
+	for name, value := range volumeContext {
+		switch param := strings.ToLower(name); {
+		case param == common.AttributeSize:
+			size, err := resource.ParseQuantity(value)
+			if err != nil || size.Sign() <= 0 {
+				return nil, "", fmt.Errorf("invalid size %q of ephemeral volume", value)
+			}
+			spec.CapacityMB = common.RoundUpSize(size.Value(), common.MbInBytes)
+		case param == common.AttributeDatastoreURL:
+			spec.DatastoreURL = value
+		case param == common.AttributeStoragePolicyName:
+			spec.StoragePolicyName = value
+		case param == common.AttributeFsType:
+			if !common.IsSupportedFsType(value) {
+				return nil, "", fmt.Errorf("filesystem type %q of ephemeral volume is not supported", value)
+			}
+			fsType = value
+		case strings.HasPrefix(param, common.CreateMetadataPrefix):
+			// Pod information passed by kubelet
+		default:
+			return nil, "", fmt.Errorf("volume attribute %s is not a valid ephemeral volume attribute", name)
+		}
+	}
+	return spec, fsType, nil
+}
+
+// getNodeManager returns a Manager of the vCenter of the config of the node service, and the VM of the node
+func getNodeManager(ctx context.Context) (*common.Manager, *cnsvsphere.VirtualMachine, error) {
+	cfgPath := csictx.Getenv(ctx, cnsconfig.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = cnsconfig.DefaultCloudConfigPath
+	}
+	cfg, err := cnsconfig.GetCnsconfig(cfgPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read cnsconfig. Error: %v", err)
+	}
+	vcenter, err := getNodeVCenter(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeVM, err := getNodeVM()
+	if err != nil {
+		return nil, nil, err
+	}
+	manager := &common.Manager{
+		VcenterConfig:  vcenter.Config,
+		CnsConfig:      cfg,
+		VolumeManager:  cnsvolume.GetManager(vcenter),
+		VcenterManager: cnsvsphere.GetVirtualCenterManager(),
+	}
+	return manager, nodeVM, nil
+}
+
+// publishEphemeralVol creates the CNS volume of the ephemeral inline volume,
+// attaches it to the node VM, then formats and mounts it to the target path
+func publishEphemeralVol(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest) (
+	*csi.NodePublishVolumeResponse, error) {
+
+	volID := req.GetVolumeId()
+	if req.GetVolumeCapability().GetMount() == nil {
+		return nil, status.Error(codes.InvalidArgument,
+			"ephemeral volumes must have a mount access type")
+	}
+	spec, fsType, err := getEphemeralSpec(volID, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if mntFsType := req.GetVolumeCapability().GetMount().GetFsType(); mntFsType != "" {
+		fsType = mntFsType
+	}
+	target := req.GetTargetPath()
+	if m, err := getMount(target); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s", err.Error())
+	} else if m != nil {
+		klog.V(3).Infof("ephemeral volume: %s already published to target: %q", volID, target)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	manager, nodeVM, err := getNodeManager(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"ephemeral volumes need the vSphere config on the node. err: %v", err)
+	}
+	datastores, err := nodeVM.GetAllAccessibleDatastores(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to get datastores accessible from node VM: %v, err: %v", nodeVM, err)
+	}
+	// Volumes are created by name, so retries of a failed publish reuse the volume
+	volumeID, err := common.CreateVolumeUtil(ctx, manager, spec, datastores)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to create ephemeral volume: %s, err: %v", volID, err)
+	}
+	// Saved before attaching, so that the volume is cleaned up on unpublish if the publish fails from here
+	if err = writeEphemeralState(volID, &ephemeralVolume{VolumeID: volumeID}); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to save state of ephemeral volume: %s, err: %v", volID, err)
+	}
+	diskUUID, err := common.AttachVolumeUtil(ctx, manager, nodeVM, volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to attach ephemeral volume: %s to node VM: %v, err: %v", volID, nodeVM, err)
+	}
+	dev, err := waitForDevice(ctx, common.FormatDiskUUID(diskUUID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = mkdir(target); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to create target dir: %s, err: %v", target, err)
+	}
+	if err = formatDevice(ctx, dev.FullPath, fsType, ""); err != nil {
+		return nil, err
+	}
+	mntFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+	if err = common.ValidateMountFlags(mntFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err = gofsutil.FormatAndMount(ctx, dev.FullPath, target, fsType, mntFlags...); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error with format and mount of ephemeral volume: %s, err: %s", volID, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// cleanupEphemeralVol detaches the CNS volume of the unpublished ephemeral inline
+// volume from the node VM and deletes it
+func cleanupEphemeralVol(ctx context.Context, volID string, state *ephemeralVolume) error {
+	manager, nodeVM, err := getNodeManager(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal,
+			"failed to clean up ephemeral volume: %s, err: %v", volID, err)
+	}
+	if err = common.DetachVolumeUtil(ctx, manager, nodeVM, state.VolumeID); err != nil {
+		return status.Errorf(codes.Internal,
+			"failed to detach ephemeral volume: %s from node VM: %v, err: %v", volID, nodeVM, err)
+	}
+	if err = common.DeleteVolumeUtil(ctx, manager, state.VolumeID, true); err != nil {
+		return status.Errorf(codes.Internal,
+			"failed to delete ephemeral volume: %s, err: %v", volID, err)
+	}
+	if err = os.Remove(getEphemeralStatePath(volID)); err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal,
+			"failed to remove state of ephemeral volume: %s, err: %v", volID, err)
+	}
+	klog.V(2).Infof("cleaned up ephemeral volume: %s, CNS volume: %s", volID, state.VolumeID)
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestGetEphemeralSpec(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		capacityMB    int64
+		datastoreURL  string
+		fsType        string
+		expectError   bool
+	}{
+		{
+			name:          "defaults",
+			volumeContext: map[string]string{common.AttributeEphemeral: "true", "csi.storage.k8s.io/pod.name": "scratch"},
+			capacityMB:    common.DefaultGbDiskSize * 1024,
+			fsType:        common.DefaultFsType,
+		},
+		{
+			name:          "size, datastore and filesystem",
+			volumeContext: map[string]string{"size": "1536Mi", "DatastoreURL": "ds:///vmfs/volumes/vsan:1/", "fstype": "xfs"},
+			capacityMB:    1536,
+			datastoreURL:  "ds:///vmfs/volumes/vsan:1/",
+			fsType:        "xfs",
+		},
+		{
+			name:          "size rounded up",
+			volumeContext: map[string]string{"size": "1G"},
+			capacityMB:    954,
+			fsType:        common.DefaultFsType,
+		},
+		{name: "invalid size", volumeContext: map[string]string{"size": "big"}, expectError: true},
+		{name: "unknown attribute", volumeContext: map[string]string{"readonly": "true"}, expectError: true},
+	}
+	for _, test := range tests {
+		spec, fsType, err := getEphemeralSpec("csi-0123", test.volumeContext)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: getEphemeralSpec() succeeded, expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getEphemeralSpec() failed: %v", test.name, err)
+			continue
+		}
+		if spec.Name != "csi-0123" || spec.CapacityMB != test.capacityMB || spec.DatastoreURL != test.datastoreURL || fsType != test.fsType {
+			t.Errorf("%s: getEphemeralSpec() = %+v, %q, expected capacity %d MB, datastore %q and filesystem %q",
+				test.name, spec, fsType, test.capacityMB, test.datastoreURL, test.fsType)
+		}
+	}
+}
+
+func TestEphemeralState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemeral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(common.EnvEphemeralStateDir, dir)
+	defer os.Unsetenv(common.EnvEphemeralStateDir)
+
+	if state, err := readEphemeralState("csi-0123"); err != nil || state != nil {
+		t.Fatalf("readEphemeralState() of unknown volume = %v, %v, expected nil", state, err)
+	}
+	if err = writeEphemeralState("csi-0123", &ephemeralVolume{VolumeID: "fcd-1"}); err != nil {
+		t.Fatalf("writeEphemeralState() failed: %v", err)
+	}
+	state, err := readEphemeralState("csi-0123")
+	if err != nil || state == nil || state.VolumeID != "fcd-1" {
+		t.Fatalf("readEphemeralState() = %v, %v, expected volume fcd-1", state, err)
+	}
+}
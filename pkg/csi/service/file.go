@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// nfsv4MountVersion is the NFS version file volumes are mounted with, unless the mount flags set one
+const nfsv4MountVersion = "vers=4.1"
+
+// publishFileVol mounts the vSAN file share backing the file volume to the target over NFS.
+// File volumes are neither attached to the node VM nor staged.
+func publishFileVol(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest) (
+	*csi.NodePublishVolumeResponse, error) {
+
+	volID := req.GetVolumeId()
+	accessPoint := req.GetPublishContext()[common.Nfsv4AccessPoint]
+	if accessPoint == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"publish context of file volume: %s has no %s", volID, common.Nfsv4AccessPoint)
+	}
+	volCap := req.GetVolumeCapability()
+	if volCap.GetMount() == nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"file volume: %s can only be published as a mounted filesystem", volID)
+	}
+	ro := req.GetReadonly() ||
+		volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+
+	// We are responsible for creating target dir, per spec
+	target := req.GetTargetPath()
+	if _, err := mkdir(target); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to create target dir: %s, err: %v", target, err)
+	}
+
+	m, err := getMount(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+	if m != nil {
+		if m.Device != accessPoint && m.Source != accessPoint {
+			return nil, status.Errorf(codes.Internal,
+				"target: %s is already mounted from: %s", target, m.Device)
+		}
+		rwo := "rw"
+		if ro {
+			rwo = "ro"
+		}
+		if !contains(m.Opts, rwo) {
+			return nil, status.Error(codes.AlreadyExists,
+				"volume previously published with different options")
+		}
+		// Existing mount satisfies request
+		klog.V(3).Infof("file volume already published to target. volID: %q, accessPoint: %q, target: %q", volID, accessPoint, target)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := common.ValidateMountFlags(volCap.GetMount().GetMountFlags()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mntFlags := getFileMountFlags(volCap.GetMount().GetMountFlags(), ro)
+	klog.V(2).Infof("mounting file volume: %s from: %s to target: %s with flags: %v", volID, accessPoint, target, mntFlags)
+	if err := gofsutil.Mount(ctx, accessPoint, target, common.NfsV4FsType, mntFlags...); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error mounting file volume: %s to target path: %s, err: %s",
+			volID, target, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// unpublishFileVol unmounts the vSAN file share backing the file volume from the target
+func unpublishFileVol(
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest) (
+	*csi.NodeUnpublishVolumeResponse, error) {
+
+	volID := req.GetVolumeId()
+	target := req.GetTargetPath()
+	if _, err := os.Stat(target); err != nil {
+		if os.IsNotExist(err) {
+			// target path does not exist, so we must be Unpublished
+			return &csi.NodeUnpublishVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal,
+			"failed to stat target, err: %s", err.Error())
+	}
+	m, err := getMount(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+	if m != nil {
+		klog.V(2).Infof("unmounting file volume: %s from target: %s", volID, target)
+		if err := gofsutil.Unmount(ctx, target); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"Error unmounting target: %s", err.Error())
+		}
+	}
+	if err := rmpath(target); err != nil {
+		return nil, err
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// getFileMountFlags returns the flags to mount a file volume with, given the mount flags of the volume capability
+func getFileMountFlags(flags []string, ro bool) []string {
+	mntFlags := append([]string{}, flags...)
+	hasVersion := false
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "vers=") || strings.HasPrefix(flag, "nfsvers=") {
+			hasVersion = true
+		}
+	}
+	if !hasVersion {
+		mntFlags = append(mntFlags, nfsv4MountVersion)
+	}
+	if ro {
+		mntFlags = append(mntFlags, "ro")
+	}
+	return mntFlags
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetFileMountFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    []string
+		ro       bool
+		expected []string
+	}{
+		{name: "no flags", expected: []string{nfsv4MountVersion}},
+		{name: "read only", ro: true, expected: []string{nfsv4MountVersion, "ro"}},
+		{name: "flags", flags: []string{"hard"}, expected: []string{"hard", nfsv4MountVersion}},
+		{name: "version flag", flags: []string{"vers=4.2"}, expected: []string{"vers=4.2"}},
+		{name: "nfsvers flag", flags: []string{"nfsvers=4.1", "hard"}, ro: true, expected: []string{"nfsvers=4.1", "hard", "ro"}},
+	}
+	for _, test := range tests {
+		if mntFlags := getFileMountFlags(test.flags, test.ro); !reflect.DeepEqual(mntFlags, test.expected) {
+			t.Errorf("%s: getFileMountFlags() = %v, expected %v", test.name, mntFlags, test.expected)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// formatDevice creates a filesystem of the given type on the device with the given extra mkfs options, unless
+// the device is formatted already. gofsutil.FormatAndMount takes no mkfs options and ignores mkfs failures, hence
+// devices are formatted here before being mounted by it.
+func formatDevice(ctx context.Context, device string, fsType string, mkfsOptions string) error {
+	if !common.IsSupportedFsType(fsType) {
+		return status.Errorf(codes.InvalidArgument, "filesystem type %q is not supported", fsType)
+	}
+	existingFormat, err := gofsutil.GetDiskFormat(ctx, device)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get filesystem of device %s, err: %v", device, err)
+	}
+	if existingFormat != "" {
+		return nil
+	}
+	mkfs := "mkfs." + fsType
+	if _, err := exec.LookPath(mkfs); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "%s is not available on the node to format device %s", mkfs, device)
+	}
+	args := getMkfsArgs(fsType, mkfsOptions, device)
+	klog.V(2).Infof("formatting device %s with %s %v", device, mkfs, args)
+	if out, err := exec.CommandContext(ctx, mkfs, args...).CombinedOutput(); err != nil {
+		return status.Errorf(codes.Internal, "failed to format device %s with %s, err: %v, output: %s",
+			device, mkfs, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getMkfsArgs returns the arguments of mkfs to force the creation of a filesystem of the given type on the device
+// with the given whitespace separated extra options
+func getMkfsArgs(fsType string, mkfsOptions string, device string) []string {
+	force := "-f"
+	if strings.HasPrefix(fsType, "ext") {
+		force = "-F"
+	}
+	args := append([]string{force}, strings.Fields(mkfsOptions)...)
+	return append(args, device)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetMkfsArgs(t *testing.T) {
+	tests := []struct {
+		fsType      string
+		mkfsOptions string
+		expected    []string
+	}{
+		{"ext4", "", []string{"-F", "/dev/sdb"}},
+		{"ext3", "-m 0", []string{"-F", "-m", "0", "/dev/sdb"}},
+		{"xfs", " -i  size=512 ", []string{"-f", "-i", "size=512", "/dev/sdb"}},
+		{"btrfs", "", []string{"-f", "/dev/sdb"}},
+	}
+	for _, test := range tests {
+		if args := getMkfsArgs(test.fsType, test.mkfsOptions, "/dev/sdb"); !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("getMkfsArgs(%q, %q) = %v, expected %v", test.fsType, test.mkfsOptions, args, test.expected)
+		}
+	}
+}
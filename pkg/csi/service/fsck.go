@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// fsckResult is the outcome of checking a filesystem
+type fsckResult int
+
+const (
+	// fsckClean means the filesystem has no errors
+	fsckClean fsckResult = iota
+	// fsckRepaired means the errors of the filesystem were repaired
+	fsckRepaired
+	// fsckLogReplay means the filesystem has a log which is replayed when mounting it, hence was not checked
+	fsckLogReplay
+	// fsckErrors means the filesystem has errors left
+	fsckErrors
+)
+
+// checkFilesystem checks the ext4, ext3 or xfs filesystem of the device according to the fsck mode
+// before it is mounted, so that a filesystem corrupted by an unclean shutdown of the node VM is not
+// silently mounted read-only by the kernel. Errors are only repaired in repair mode, and fail the
+// staging of the volume in fail mode, or if they cannot be repaired.
+func checkFilesystem(ctx context.Context, device string, fsckMode string) error {
+	if fsckMode == "" || fsckMode == common.FsckModeSkip {
+		return nil
+	}
+	if !common.IsSupportedFsckMode(fsckMode) {
+		return status.Errorf(codes.InvalidArgument, "fsck mode %q is not supported", fsckMode)
+	}
+	fsType, err := gofsutil.GetDiskFormat(ctx, device)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get filesystem of device %s, err: %v", device, err)
+	}
+	cmd, args := getFsckCommand(fsType, fsckMode, device)
+	if cmd == "" {
+		// Unformatted devices are formatted rather than checked
+		if fsType != "" {
+			klog.V(2).Infof("skipping check of %s filesystem of device %s", fsType, device)
+		}
+		return nil
+	}
+	klog.V(2).Infof("checking filesystem of device %s with %s %v", device, cmd, args)
+	out, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput()
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return status.Errorf(codes.Internal, "failed to check filesystem of device %s with %s, err: %v", device, cmd, err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	switch getFsckResult(fsType, fsckMode, exitCode) {
+	case fsckRepaired:
+		klog.Warningf("repaired errors of filesystem of device %s, output: %s", device, strings.TrimSpace(string(out)))
+	case fsckLogReplay:
+		klog.V(2).Infof("filesystem of device %s has a log to replay on mount, skipping check", device)
+	case fsckErrors:
+		return status.Errorf(codes.FailedPrecondition, "filesystem of device %s has errors, %s exited with %d, output: %s",
+			device, cmd, exitCode, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getFsckCommand returns the command checking, or repairing in repair mode, the filesystem of the given type on
+// the device, or an empty command if filesystems of the type are not checked
+func getFsckCommand(fsType string, fsckMode string, device string) (string, []string) {
+	switch fsType {
+	case "ext3", "ext4":
+		if fsckMode == common.FsckModeRepair {
+			// Repairs what can be repaired without human intervention
+			return "fsck." + fsType, []string{"-p", device}
+		}
+		return "fsck." + fsType, []string{"-n", device}
+	case "xfs":
+		if fsckMode == common.FsckModeRepair {
+			return "xfs_repair", []string{device}
+		}
+		return "xfs_repair", []string{"-n", device}
+	}
+	return "", nil
+}
+
+// getFsckResult returns the outcome of checking a filesystem of the given type in the given mode from the exit
+// code of the fsck command
+func getFsckResult(fsType string, fsckMode string, exitCode int) fsckResult {
+	if exitCode == 0 {
+		return fsckClean
+	}
+	if fsType == "xfs" {
+		// The log of an xfs filesystem not cleanly unmounted is replayed on mount, which is
+		// what repairs it in most cases, and xfs_repair refuses to run until it is replayed
+		if exitCode == 2 {
+			return fsckLogReplay
+		}
+		return fsckErrors
+	}
+	// e2fsck exits with 1 if errors were corrected and with 2 if the system should be rebooted as well
+	if fsckMode == common.FsckModeRepair && exitCode <= 2 {
+		return fsckRepaired
+	}
+	return fsckErrors
+}
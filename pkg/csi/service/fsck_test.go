@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetFsckCommand(t *testing.T) {
+	tests := []struct {
+		fsType       string
+		fsckMode     string
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{"ext4", "fail", "fsck.ext4", []string{"-n", "/dev/sdb"}},
+		{"ext3", "repair", "fsck.ext3", []string{"-p", "/dev/sdb"}},
+		{"xfs", "fail", "xfs_repair", []string{"-n", "/dev/sdb"}},
+		{"xfs", "repair", "xfs_repair", []string{"/dev/sdb"}},
+		{"btrfs", "repair", "", nil},
+		{"", "fail", "", nil},
+	}
+	for _, test := range tests {
+		cmd, args := getFsckCommand(test.fsType, test.fsckMode, "/dev/sdb")
+		if cmd != test.expectedCmd || !reflect.DeepEqual(args, test.expectedArgs) {
+			t.Errorf("%s %s: getFsckCommand() = %s %v, expected %s %v",
+				test.fsType, test.fsckMode, cmd, args, test.expectedCmd, test.expectedArgs)
+		}
+	}
+}
+
+func TestGetFsckResult(t *testing.T) {
+	tests := []struct {
+		fsType   string
+		fsckMode string
+		exitCode int
+		expected fsckResult
+	}{
+		{"ext4", "fail", 0, fsckClean},
+		{"ext4", "fail", 4, fsckErrors},
+		{"ext4", "repair", 1, fsckRepaired},
+		{"ext4", "repair", 2, fsckRepaired},
+		{"ext4", "repair", 4, fsckErrors},
+		{"xfs", "fail", 0, fsckClean},
+		{"xfs", "fail", 1, fsckErrors},
+		{"xfs", "fail", 2, fsckLogReplay},
+		{"xfs", "repair", 2, fsckLogReplay},
+		{"xfs", "repair", 1, fsckErrors},
+	}
+	for _, test := range tests {
+		if actual := getFsckResult(test.fsType, test.fsckMode, test.exitCode); actual != test.expected {
+			t.Errorf("%s %s exit code %d: getFsckResult() = %d, expected %d",
+				test.fsType, test.fsckMode, test.exitCode, actual, test.expected)
+		}
+	}
+}
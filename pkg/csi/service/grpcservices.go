@@ -0,0 +1,190 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	channelzservice "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// auxiliaryServices serves the gRPC health service, and optionally the channelz service, on the CSI endpoint.
+// The gRPC server of the CSI endpoint is owned by gocsi, hence these services are registered on an
+// in-process gRPC server and calls to them are forwarded by the unknown service handler of the CSI endpoint.
+type auxiliaryServices struct {
+	health *health.Server
+	server *grpc.Server
+	conn   *grpc.ClientConn
+}
+
+// newAuxiliaryServices starts the in-process gRPC server of the auxiliary services
+func newAuxiliaryServices(enableChannelz bool) (*auxiliaryServices, error) {
+	lis := newPipeListener()
+	services := &auxiliaryServices{
+		health: health.NewServer(),
+		server: grpc.NewServer(),
+	}
+	healthpb.RegisterHealthServer(services.server, services.health)
+	if enableChannelz {
+		channelzservice.RegisterChannelzServiceToServer(services.server)
+	}
+	go func() {
+		if err := services.server.Serve(lis); err != nil {
+			klog.Errorf("Auxiliary gRPC server stopped. err: %v", err)
+		}
+	}()
+	var err error
+	services.conn, err = grpc.Dial("auxiliary", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return lis.dial(ctx)
+		}))
+	if err != nil {
+		services.server.Stop()
+		return nil, err
+	}
+	return services, nil
+}
+
+// setServingStatus sets the health of the given service. An empty service name sets the health of the endpoint.
+func (s *auxiliaryServices) setServingStatus(service string, servingStatus healthpb.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus(service, servingStatus)
+}
+
+// handleStream is the unknown service handler of the CSI endpoint.
+// It forwards the call to the auxiliary services, which return Unimplemented for unknown services.
+func (s *auxiliaryServices) handleStream(srv interface{}, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "failed to get method of the call")
+	}
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+	clientStream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			request := &frame{}
+			if err := serverStream.RecvMsg(request); err != nil {
+				if err == io.EOF {
+					clientStream.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			}
+			if err := clientStream.SendMsg(request); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		response := &frame{}
+		if err := clientStream.RecvMsg(response); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := serverStream.SendMsg(response); err != nil {
+			return err
+		}
+	}
+}
+
+// frame is a message forwarded as is between the CSI endpoint and the auxiliary services
+type frame struct {
+	payload []byte
+}
+
+func (f *frame) Reset()         { f.payload = nil }
+func (f *frame) String() string { return fmt.Sprintf("frame of %d bytes", len(f.payload)) }
+func (f *frame) ProtoMessage()  {}
+
+// Marshal returns the payload of the frame as the encoded message
+func (f *frame) Marshal() ([]byte, error) {
+	return f.payload, nil
+}
+
+// Unmarshal stores the encoded message as the payload of the frame
+func (f *frame) Unmarshal(data []byte) error {
+	f.payload = append([]byte(nil), data...)
+	return nil
+}
+
+// pipeListener is a net.Listener accepting in-process connections created by dial
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "auxiliary" }
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuxiliaryServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	auxServices, err := newAuxiliaryServices(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auxServices.setServingStatus(identityServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	// Serve an endpoint with no registered services, like the CSI endpoint in node mode without the node service
+	lis := newPipeListener()
+	server := grpc.NewServer(grpc.UnknownServiceHandler(auxServices.handleStream))
+	go server.Serve(lis)
+	defer server.Stop()
+	conn, err := grpc.DialContext(ctx, "endpoint", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return lis.dial(ctx)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	healthClient := healthpb.NewHealthClient(conn)
+	response, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: identityServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected %s to be SERVING, got %s", identityServiceName, response.Status)
+	}
+	if _, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: controllerServiceName}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for %s, got %v", controllerServiceName, err)
+	}
+
+	// channelz is not enabled
+	_, err = channelzpb.NewChannelzClient(conn).GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented for channelz, got %v", err)
+	}
+}
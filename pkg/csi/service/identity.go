@@ -20,10 +20,9 @@ import (
 	"context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-)
 
-// set via ldflags
-var version string
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/version"
+)
 
 func (s *service) Probe(
 	ctx context.Context,
@@ -38,9 +37,11 @@ func (s *service) GetPluginInfo(
 	req *csi.GetPluginInfoRequest) (
 	*csi.GetPluginInfoResponse, error) {
 
+	info := version.Get()
 	return &csi.GetPluginInfoResponse{
 		Name:          Name,
-		VendorVersion: version,
+		VendorVersion: info.Version,
+		Manifest:      info.Manifest(),
 	}, nil
 }
 
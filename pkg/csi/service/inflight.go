@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+)
+
+// inflightRequest is a CSI request being served. Only identifiers are recorded, as requests may carry secrets.
+type inflightRequest struct {
+	Method   string    `json:"method"`
+	OpID     string    `json:"opId,omitempty"`
+	VolumeID string    `json:"volumeId,omitempty"`
+	NodeID   string    `json:"nodeId,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Started  time.Time `json:"started"`
+	Elapsed  string    `json:"elapsed"`
+}
+
+// inflightRequests tracks the CSI requests being served for the support bundle
+type inflightRequests struct {
+	lock     sync.Mutex
+	next     uint64
+	requests map[uint64]inflightRequest
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{requests: make(map[uint64]inflightRequest)}
+}
+
+// intercept is a unary server interceptor recording the request while it is served
+func (r *inflightRequests) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	request := inflightRequest{Method: info.FullMethod, OpID: opid.FromContext(ctx), Started: time.Now()}
+	if v, ok := req.(interface{ GetVolumeId() string }); ok {
+		request.VolumeID = v.GetVolumeId()
+	}
+	if v, ok := req.(interface{ GetNodeId() string }); ok {
+		request.NodeID = v.GetNodeId()
+	}
+	if v, ok := req.(interface{ GetName() string }); ok {
+		request.Name = v.GetName()
+	}
+	r.lock.Lock()
+	id := r.next
+	r.next++
+	r.requests[id] = request
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.requests, id)
+		r.lock.Unlock()
+	}()
+	return handler(ctx, req)
+}
+
+// list returns the requests being served, oldest first
+func (r *inflightRequests) list() []inflightRequest {
+	r.lock.Lock()
+	requests := make([]inflightRequest, 0, len(r.requests))
+	for _, request := range r.requests {
+		request.Elapsed = time.Since(request.Started).String()
+		requests = append(requests, request)
+	}
+	r.lock.Unlock()
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Started.Before(requests[j].Started) })
+	return requests
+}
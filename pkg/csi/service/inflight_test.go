@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+)
+
+func TestInflightRequests(t *testing.T) {
+	r := newInflightRequests()
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId: "volume-1",
+		NodeId:   "node-1",
+		Secrets:  map[string]string{"password": "secret"},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ControllerPublishVolume"}
+	var inflight []inflightRequest
+	ctx := opid.NewContext(context.Background(), "csi-0000002a")
+	_, err := r.intercept(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		inflight = r.list()
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("intercept failed: %v", err)
+	}
+	if len(inflight) != 1 || inflight[0].Method != info.FullMethod || inflight[0].OpID != "csi-0000002a" || inflight[0].VolumeID != "volume-1" || inflight[0].NodeID != "node-1" {
+		t.Errorf("unexpected in-flight requests while serving: %+v", inflight)
+	}
+	if inflight := r.list(); len(inflight) != 0 {
+		t.Errorf("expected no in-flight requests after serving, got %+v", inflight)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rexray/gocsi"
+)
+
+const (
+	// Environment variables set by systemd to pass listening sockets to socket activated services
+	envListenPID = "LISTEN_PID"
+	envListenFDs = "LISTEN_FDS"
+
+	// listenFDsStart is the first file descriptor passed by systemd
+	listenFDsStart = 3
+
+	// NamedPipePrefix is the prefix of CSI endpoints served over a Windows named pipe,
+	// for example npipe://./pipe/csi-vsphere
+	NamedPipePrefix = "npipe://"
+)
+
+// GetEndpointListener returns the listener of the CSI endpoint if gocsi cannot listen on it,
+// that is the listener passed by systemd socket activation or the listener of a Windows named pipe.
+// Returns nil if gocsi is to listen on the endpoint.
+func GetEndpointListener() (net.Listener, error) {
+	lis, err := GetInheritedListener()
+	if lis != nil || err != nil {
+		return lis, err
+	}
+	endpoint := os.Getenv(gocsi.EnvVarEndpoint)
+	if isNamedPipeEndpoint(endpoint) {
+		return listenNamedPipe(namedPipePath(endpoint))
+	}
+	return nil, nil
+}
+
+// isNamedPipeEndpoint returns true if the CSI endpoint is a Windows named pipe
+func isNamedPipeEndpoint(endpoint string) bool {
+	return strings.HasPrefix(strings.ToLower(endpoint), NamedPipePrefix)
+}
+
+// namedPipePath returns the path of the Windows named pipe of the CSI endpoint.
+// Both npipe://./pipe/name and npipe:////./pipe/name are converted to \\.\pipe\name.
+func namedPipePath(endpoint string) string {
+	path := strings.TrimLeft(endpoint[len(NamedPipePrefix):], "/\\")
+	return `\\` + strings.Replace(path, "/", `\`, -1)
+}
+
+// GetInheritedListener returns the listener of the CSI endpoint passed by systemd socket activation,
+// or nil if the process is not socket activated.
+func GetInheritedListener() (net.Listener, error) {
+	if !isSocketActivated() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", envListenFDs, os.Getenv(envListenFDs))
+	}
+	if fds != 1 {
+		return nil, fmt.Errorf("expected 1 inherited listener, got %d", fds)
+	}
+	// Do not pass the listener on to child processes
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	file := os.NewFile(uintptr(listenFDsStart), "csi-endpoint")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// isSocketActivated returns true if systemd passed listening sockets to this process
+func isSocketActivated() bool {
+	pid, err := strconv.Atoi(os.Getenv(envListenPID))
+	return err == nil && pid == os.Getpid() && os.Getenv(envListenFDs) != ""
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestGetInheritedListener(t *testing.T) {
+	defer os.Unsetenv(envListenPID)
+	defer os.Unsetenv(envListenFDs)
+
+	// Not socket activated
+	if lis, err := GetInheritedListener(); lis != nil || err != nil {
+		t.Fatalf("expected no listener and no error, got %v and %v", lis, err)
+	}
+	// Sockets passed to another process
+	os.Setenv(envListenPID, strconv.Itoa(os.Getpid()+1))
+	os.Setenv(envListenFDs, "1")
+	if lis, err := GetInheritedListener(); lis != nil || err != nil {
+		t.Fatalf("expected no listener and no error, got %v and %v", lis, err)
+	}
+	// More than one socket passed
+	os.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+	os.Setenv(envListenFDs, "2")
+	if _, err := GetInheritedListener(); err == nil {
+		t.Fatalf("expected error for more than one inherited listener")
+	}
+}
+
+func TestNamedPipePath(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		path     string
+	}{
+		{"npipe://./pipe/csi-vsphere", `\\.\pipe\csi-vsphere`},
+		{"npipe:////./pipe/csi-vsphere", `\\.\pipe\csi-vsphere`},
+		{`npipe://\\.\pipe\csi-vsphere`, `\\.\pipe\csi-vsphere`},
+	}
+	for _, test := range tests {
+		if !isNamedPipeEndpoint(test.endpoint) {
+			t.Errorf("expected %s to be a named pipe endpoint", test.endpoint)
+		}
+		if path := namedPipePath(test.endpoint); path != test.path {
+			t.Errorf("namedPipePath(%s): expected %s, got %s", test.endpoint, test.path, path)
+		}
+	}
+	if isNamedPipeEndpoint("unix:///csi/csi.sock") {
+		t.Errorf("expected unix socket endpoint not to be a named pipe endpoint")
+	}
+}
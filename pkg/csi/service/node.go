@@ -18,13 +18,15 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/akutz/gofsutil"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -35,15 +37,21 @@ import (
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
-	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 )
 
 const (
 	devDiskID   = "/dev/disk/by-id"
 	blockPrefix = "wwn-0x"
+	// nvmePrefix is the prefix of the links to disks attached to virtual NVMe controllers, whose
+	// namespace identifier is the UUID of the disk
+	nvmePrefix  = "nvme-eui."
 	dmiDir      = "/sys/class/dmi"
+	sysBlockDir = "/sys/class/block"
 )
 
+// nodeVCenterLock serializes the registration of the vCenter used by the node service
+var nodeVCenterLock sync.Mutex
+
 func (s *service) NodeStageVolume(
 	ctx context.Context,
 	req *csi.NodeStageVolumeRequest) (
@@ -52,25 +60,23 @@ func (s *service) NodeStageVolume(
 	volID := req.GetVolumeId()
 	pubCtx := req.GetPublishContext()
 
+	if common.IsFileVolumeID(volID) {
+		// File volumes are mounted over NFS in NodePublishVolume
+		klog.V(4).Infof("Skipping staging of file volume: %s", volID)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	diskID, err := getDiskID(volID, pubCtx)
 	if err != nil {
 		klog.Errorf("Failed to get diskID. Error: %v", err)
 		return nil, err
 	}
 	klog.V(2).Infof("Checking if volume: %s with diskID: %s is attached", volID, diskID)
-	volPath, err := verifyVolumeAttached(diskID)
+	dev, err := waitForDevice(ctx, diskID)
 	if err != nil {
-		klog.Errorf("Failed to verify volume attachment. Error: %v", err)
+		klog.Errorf("Failed to find block device of volume. Error: %v", err)
 		return nil, err
 	}
-
-	// Check that block device looks good
-	dev, err := getDevice(volPath)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal,
-			"error getting block device for volume: %s, err: %s",
-			volID, err.Error())
-	}
 	// Check if this is a MountvVolume or BlockVolume
 	volCap := req.GetVolumeCapability()
 	if _, ok := volCap.GetAccessType().(*csi.VolumeCapability_Block); ok {
@@ -120,8 +126,16 @@ func (s *service) NodeStageVolume(
 			fs = fsType
 		}
 
+		fsckMode := attributes[common.AttributeFsckMode]
 		// If read-only access mode, we don't allow formatting
 		if ro {
+			// nor repairing
+			if fsckMode == common.FsckModeRepair {
+				fsckMode = common.FsckModeFail
+			}
+			if err := checkFilesystem(ctx, dev.FullPath, fsckMode); err != nil {
+				return nil, err
+			}
 			mntFlags = append(mntFlags, "ro")
 			if err := gofsutil.Mount(ctx, dev.FullPath, target, fs, mntFlags...); err != nil {
 				return nil, status.Errorf(codes.Internal,
@@ -130,6 +144,12 @@ func (s *service) NodeStageVolume(
 			}
 			return &csi.NodeStageVolumeResponse{}, nil
 		}
+		if err := checkFilesystem(ctx, dev.FullPath, fsckMode); err != nil {
+			return nil, err
+		}
+		if err := formatDevice(ctx, dev.FullPath, fs, attributes[common.AttributeMkfsOptions]); err != nil {
+			return nil, err
+		}
 		if err := gofsutil.FormatAndMount(ctx, dev.FullPath, target, fs, mntFlags...); err != nil {
 			return nil, status.Errorf(codes.Internal,
 				"error with format and mount during staging: %s",
@@ -172,6 +192,10 @@ func (s *service) NodeUnstageVolume(
 	*csi.NodeUnstageVolumeResponse, error) {
 
 	volID := req.GetVolumeId()
+	if common.IsFileVolumeID(volID) {
+		// File volumes are not staged
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
 
 	target := req.GetStagingTargetPath()
 	if err := verifyTargetDir(target); err != nil {
@@ -231,25 +255,25 @@ func (s *service) NodePublishVolume(
 	volID := req.GetVolumeId()
 	pubCtx := req.GetPublishContext()
 
+	if isEphemeral(req.GetVolumeContext()) {
+		// Ephemeral inline volumes are neither created, attached nor staged by the CO
+		return publishEphemeralVol(ctx, req)
+	}
+	if common.IsFileVolumeID(volID) {
+		return publishFileVol(ctx, req)
+	}
+
 	diskID, err := getDiskID(volID, pubCtx)
 	if err != nil {
 		return nil, err
 	}
 
 	klog.V(2).Infof("Checking if volume: %s with diskID: %s is attached", volID, diskID)
-	volPath, err := verifyVolumeAttached(diskID)
+	dev, err := waitForDevice(ctx, diskID)
 	if err != nil {
-		klog.Errorf("Failed to verify volume attachment. Error: %v", err)
+		klog.Errorf("Failed to find block device of volume. Error: %v", err)
 		return nil, err
 	}
-
-	// Get underlying block device
-	dev, err := getDevice(volPath)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal,
-			"error getting block device for volume: %s, err: %s",
-			volID, err.Error())
-	}
 	// check for Block vs Mount
 	volCap := req.GetVolumeCapability()
 	if _, ok := volCap.GetAccessType().(*csi.VolumeCapability_Block); ok {
@@ -266,6 +290,31 @@ func (s *service) NodeUnpublishVolume(
 	req *csi.NodeUnpublishVolumeRequest) (
 	*csi.NodeUnpublishVolumeResponse, error) {
 
+	volID := req.GetVolumeId()
+	if common.IsFileVolumeID(volID) {
+		return unpublishFileVol(ctx, req)
+	}
+	state, err := readEphemeralState(volID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to read state of volume: %s, err: %s", volID, err.Error())
+	}
+	resp, err := unpublishVol(ctx, req)
+	if err != nil || state == nil {
+		return resp, err
+	}
+	// The ephemeral inline volume goes away with the pod
+	if err := cleanupEphemeralVol(ctx, volID, state); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func unpublishVol(
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest) (
+	*csi.NodeUnpublishVolumeResponse, error) {
+
 	volID := req.GetVolumeId()
 
 	target := req.GetTargetPath()
@@ -330,6 +379,67 @@ func (s *service) NodeGetVolumeStats(
 	return nil, nil
 }
 
+func (s *service) NodeExpandVolume(
+	ctx context.Context,
+	req *csi.NodeExpandVolumeRequest) (
+	*csi.NodeExpandVolumeResponse, error) {
+
+	volID := req.GetVolumeId()
+	if volID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID required")
+	}
+	volPath := req.GetVolumePath()
+	if volPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path required")
+	}
+	mnt, err := getMount(volPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting mount of volume: %s at path: %s, err: %s", volID, volPath, err.Error())
+	}
+	if mnt == nil {
+		return nil, status.Errorf(codes.NotFound,
+			"volume: %s not mounted at path: %s", volID, volPath)
+	}
+	dev, err := getDevFromMount(volPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting block device for volume: %s, err: %s", volID, err.Error())
+	}
+
+	// Pick up the new size of the disk extended by ControllerExpandVolume
+	if err = rescanDevice(dev.RealDev); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error rescanning device: %s of volume: %s, err: %s", dev.RealDev, volID, err.Error())
+	}
+	sizeBytes, err := getDeviceSize(dev.RealDev)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting size of device: %s of volume: %s, err: %s", dev.RealDev, volID, err.Error())
+	}
+	if requiredBytes := req.GetCapacityRange().GetRequiredBytes(); sizeBytes < requiredBytes {
+		return nil, status.Errorf(codes.Internal,
+			"device: %s of volume: %s has size %d bytes, smaller than the required %d bytes",
+			dev.RealDev, volID, sizeBytes, requiredBytes)
+	}
+	if _, ok := req.GetVolumeCapability().GetAccessType().(*csi.VolumeCapability_Block); ok || mnt.Device == "devtmpfs" {
+		// Raw block volumes have no filesystem to grow
+		klog.V(2).Infof("skipping filesystem resize for block volume: %s, device: %s", volID, dev.RealDev)
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: sizeBytes}, nil
+	}
+	cmd, args, err := getResizeCommand(mnt.Type, dev.RealDev, volPath)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"error resizing filesystem of volume: %s, err: %s", volID, err.Error())
+	}
+	klog.V(2).Infof("resizing %s filesystem of volume: %s on device: %s mounted at: %s", mnt.Type, volID, dev.RealDev, volPath)
+	if out, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput(); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error resizing filesystem of volume: %s with %s, err: %s, output: %s", volID, cmd, err.Error(), string(out))
+	}
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: sizeBytes}, nil
+}
+
 func (s *service) NodeGetCapabilities(
 	ctx context.Context,
 	req *csi.NodeGetCapabilitiesRequest) (
@@ -344,6 +454,13 @@ func (s *service) NodeGetCapabilities(
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+					},
+				},
+			},
 		},
 	}, nil
 }
@@ -375,69 +492,105 @@ func (s *service) NodeGetInfo(
 	var accessibleTopology map[string]string
 	topology := &csi.Topology{}
 
-	if cfg.Labels.Zone != "" && cfg.Labels.Region != "" {
-		klog.V(2).Infof("Config file provided to node daemonset with zones and regions. Assuming topology aware cluster.")
-		vcenterconfig, err := cnsvsphere.GetVirtualCenterConfig(cfg)
-		if err != nil {
-			klog.Errorf("Failed to get VirtualCenterConfig from cns config. err=%v", err)
-			return nil, status.Errorf(codes.Internal, err.Error())
-		}
-		vcManager := cnsvsphere.GetVirtualCenterManager()
-		vcenter, err := vcManager.RegisterVirtualCenter(vcenterconfig)
-		if err != nil {
-			klog.Errorf("Failed to register vcenter with virtualCenterManager.")
+	if levels := common.GetTopologyLevels(cfg); len(levels) > 0 {
+		klog.V(2).Infof("Config file provided to node daemonset with topology categories. Assuming topology aware cluster.")
+		if _, err = getNodeVCenter(ctx, cfg); err != nil {
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
-		defer vcManager.UnregisterAllVirtualCenters()
-		//Connect to vCenter
-		err = vcenter.Connect(ctx)
+		nodeVM, err := getNodeVM()
 		if err != nil {
-			klog.Errorf("Failed to connect to vcenter host: %s. err=%v", vcenter.Config.Host, err)
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
-		// Get VM UUID
-		uuid, err := getSystemUUID()
-		if err != nil {
-			klog.Errorf("Failed to get system uuid for node VM")
-			return nil, status.Errorf(codes.Internal, err.Error())
-		}
-		klog.V(4).Infof("Successfully retrieved uuid:%s  from the node: %s", uuid, nodeID)
-		nodeVM, err := cnsvsphere.GetVirtualMachineByUUID(uuid, false)
-		if err != nil || nodeVM == nil {
-			klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
-			uuid, err = convertUUID(uuid)
-			if err != nil {
-				klog.Errorf("convertUUID failed with error: %v", err)
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
-			nodeVM, err = cnsvsphere.GetVirtualMachineByUUID(uuid, false)
-			if err != nil || nodeVM == nil {
-				klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
-		}
-		zone, region, err := nodeVM.GetZoneRegion(ctx, cfg.Labels.Zone, cfg.Labels.Region)
+		tags, err := nodeVM.GetTopology(ctx, common.GetTopologyCategories(levels))
 		if err != nil {
 			klog.Errorf("Failed to get accessibleTopology for vm: %v, err: %v", nodeVM.Reference(), err)
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
-		klog.V(4).Infof("zone: [%s], region: [%s], Node VM: [%s]", zone, region, nodeID)
-		if zone != "" && region != "" {
-			accessibleTopology = make(map[string]string)
-			accessibleTopology[csitypes.LabelRegionFailureDomain] = region
-			accessibleTopology[csitypes.LabelZoneFailureDomain] = zone
+		klog.V(4).Infof("topology tags: %v, Node VM: [%s]", tags, nodeID)
+		// Nodes are only published in a topology if they are tagged in all its levels. The region and zone are
+		// published under both the GA and the beta labels.
+		if len(tags) == len(levels) {
+			accessibleTopology = common.GetTopologySegments(levels, tags)
 		}
 	}
 	if len(accessibleTopology) > 0 {
 		topology.Segments = accessibleTopology
 	}
 
+	// The attach limit is left unset if the node VM cannot be queried, rather than failing the registration of the node
+	var maxVolumes int64
+	if _, err = getNodeVCenter(ctx, cfg); err != nil {
+		klog.Warningf("Failed to get vCenter to compute the attach limit of node %s. err: %v", nodeID, err)
+	} else if nodeVM, err := getNodeVM(); err != nil {
+		klog.Warningf("Failed to get node VM to compute the attach limit of node %s. err: %v", nodeID, err)
+	} else if maxVolumes, err = getNodeMaxVolumes(ctx, nodeVM); err != nil {
+		klog.Warningf("Failed to compute the attach limit of node %s. err: %v", nodeID, err)
+	}
+	maxVolumes = applyMaxVolumesOverride(maxVolumes, getNodeMaxVolumesOverride(nodeID))
+	klog.V(2).Infof("Node %s can have %d volumes attached", nodeID, maxVolumes)
+
 	return &csi.NodeGetInfoResponse{
 		NodeId:             nodeID,
 		AccessibleTopology: topology,
+		MaxVolumesPerNode:  maxVolumes,
 	}, nil
 }
 
+// getNodeVCenter returns the vCenter of the config, connected. The vCenter is registered
+// with the VirtualCenterManager on first use and stays registered, as it is shared by
+// the requests served by the node service.
+func getNodeVCenter(ctx context.Context, cfg *cnsconfig.Config) (*cnsvsphere.VirtualCenter, error) {
+	nodeVCenterLock.Lock()
+	defer nodeVCenterLock.Unlock()
+	vcenterconfig, err := cnsvsphere.GetVirtualCenterConfig(cfg)
+	if err != nil {
+		klog.Errorf("Failed to get VirtualCenterConfig from cns config. err=%v", err)
+		return nil, err
+	}
+	vcManager := cnsvsphere.GetVirtualCenterManager()
+	vcenter, err := vcManager.GetVirtualCenter(vcenterconfig.Host)
+	if err != nil {
+		vcenter, err = vcManager.RegisterVirtualCenter(vcenterconfig)
+		if err != nil {
+			klog.Errorf("Failed to register vcenter with virtualCenterManager.")
+			return nil, err
+		}
+	}
+	//Connect to vCenter
+	err = vcenter.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vcenter host: %s. err=%v", vcenter.Config.Host, err)
+		return nil, err
+	}
+	return vcenter, nil
+}
+
+// getNodeVM returns the VM of the node, looked up by the system uuid on the
+// vCenters registered with the VirtualCenterManager
+func getNodeVM() (*cnsvsphere.VirtualMachine, error) {
+	uuid, err := getSystemUUID()
+	if err != nil {
+		klog.Errorf("Failed to get system uuid for node VM")
+		return nil, err
+	}
+	klog.V(4).Infof("Successfully retrieved uuid:%s  from the node", uuid)
+	nodeVM, err := cnsvsphere.GetVirtualMachineByUUID(uuid, false)
+	if err != nil || nodeVM == nil {
+		klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
+		uuid, err = common.ConvertUUID(uuid)
+		if err != nil {
+			klog.Errorf("ConvertUUID failed with error: %v", err)
+			return nil, err
+		}
+		nodeVM, err = cnsvsphere.GetVirtualMachineByUUID(uuid, false)
+		if err != nil || nodeVM == nil {
+			klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
+			return nil, fmt.Errorf("failed to get node VM for uuid: %s. err: %v", uuid, err)
+		}
+	}
+	return nodeVM, nil
+}
+
 func publishMountVol(
 	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
@@ -520,8 +673,13 @@ func publishBlockVol(
 	dev *Device) (
 	*csi.NodePublishVolumeResponse, error) {
 
-	// We are responsible for creating target file, per spec
+	// We are responsible for creating target file, per spec. The CO may
+	// not have created the parent directory of the target file.
 	target := req.GetTargetPath()
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to create parent dir of target file: %s, err: %v", target, err)
+	}
 	_, err := mkfile(target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal,
@@ -546,30 +704,42 @@ func publishBlockVol(
 			err.Error())
 	}
 
-	// check if device is already mounted
-	if len(devMnts) == 0 {
-		// do the bind mount
-		mntFlags := make([]string, 0)
-		if err := gofsutil.BindMount(ctx, dev.FullPath, target, mntFlags...); err != nil {
-			return nil, status.Errorf(codes.Internal,
-				"error publish volume to target path: %s",
-				err.Error())
-		}
-	} else if len(devMnts) == 1 {
-		// already mounted, make sure it's what we want
-		if devMnts[0].Path != target {
-			return nil, status.Error(codes.Internal,
-				"device already in use and mounted elsewhere")
-		}
+	published, err := isBlockVolPublished(devMnts, dev, target)
+	if err != nil {
+		return nil, err
+	}
+	if published {
 		klog.V(3).Infof("volume already published to target. volumePath: %q, device: %q, target: %q", dev.FullPath, dev.RealDev, req.GetTargetPath())
-	} else {
-		return nil, status.Error(codes.Internal,
-			"block volume already mounted in more than one place")
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	// do the bind mount. The device may already be bind mounted for
+	// other pods on the node sharing the volume.
+	mntFlags := make([]string, 0)
+	if err := gofsutil.BindMount(ctx, dev.FullPath, target, mntFlags...); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error publish volume to target path: %s",
+			err.Error())
 	}
-	// existing or new mount satisfies request
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// isBlockVolPublished returns whether the device is already bind mounted to the
+// target, or an error if the device is mounted with a filesystem, as it is then
+// in use by a mount volume
+func isBlockVolPublished(devMnts []gofsutil.Info, dev *Device, target string) (bool, error) {
+	published := false
+	for _, m := range devMnts {
+		if m.Device == dev.RealDev {
+			return false, status.Errorf(codes.FailedPrecondition,
+				"device: %s is mounted with a filesystem at: %s", dev.RealDev, m.Path)
+		}
+		if m.Path == target {
+			published = true
+		}
+	}
+	return published, nil
+}
+
 // Device is a struct for holding details about a block device
 type Device struct {
 	FullPath string
@@ -625,10 +795,12 @@ func getDiskPath(id string, files []os.FileInfo) (string, error) {
 		devs = files
 	}
 
-	targetDisk := blockPrefix + id
+	// The disk is linked by its WWN when attached to a SCSI controller, or by
+	// its namespace identifier when attached to an NVMe controller
+	targetDisks := []string{blockPrefix + id, nvmePrefix + id}
 
 	for _, f := range devs {
-		if f.Name() == targetDisk {
+		if contains(targetDisks, f.Name()) {
 			return filepath.Join(devDiskID, f.Name()), nil
 		}
 	}
@@ -645,23 +817,6 @@ func contains(list []string, item string) bool {
 	return false
 }
 
-func verifyVolumeAttached(diskID string) (string, error) {
-
-	// Check that volume is attached
-	volPath, err := getDiskPath(diskID, nil)
-	if err != nil {
-		return "", status.Errorf(codes.Internal,
-			"Error trying to read attached disks: %v", err)
-	}
-	if volPath == "" {
-		return "", status.Errorf(codes.NotFound,
-			"disk: %s not attached to node", diskID)
-	}
-
-	klog.V(2).Infof("found disk. diskID: %q, path: %q", diskID, volPath)
-	return volPath, nil
-}
-
 func verifyTargetDir(target string) error {
 	if target == "" {
 		return status.Error(codes.InvalidArgument,
@@ -750,6 +905,9 @@ func ensureMountVol(volCap *csi.VolumeCapability) (string, []string, error) {
 	}
 	fs := mountVol.GetFsType()
 	mntFlags := mountVol.GetMountFlags()
+	if err := common.ValidateMountFlags(mntFlags); err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	return fs, mntFlags, nil
 }
@@ -784,22 +942,6 @@ func getSystemUUID() (string, error) {
 	return strings.ToLower(id), nil
 }
 
-// convertUUID helps convert UUID to vSphere format
-//input uuid:    6B8C2042-0DD1-D037-156F-435F999D94C1
-//returned uuid: 42208c6b-d10d-37d0-156f-435f999d94c1
-func convertUUID(uuid string) (string, error) {
-	if len(uuid) != 36 {
-		return "", errors.New("uuid length should be 36")
-	}
-	convertedUUID := fmt.Sprintf("%s%s%s%s-%s%s-%s%s-%s-%s",
-		uuid[6:8], uuid[4:6], uuid[2:4], uuid[0:2],
-		uuid[11:13], uuid[9:11],
-		uuid[16:18], uuid[14:16],
-		uuid[19:23],
-		uuid[24:36])
-	return strings.ToLower(convertedUUID), nil
-}
-
 func getDiskID(volID string, pubCtx map[string]string) (string, error) {
 	if volID == "" {
 		return "", status.Error(codes.InvalidArgument,
@@ -813,29 +955,77 @@ func getDiskID(volID string, pubCtx map[string]string) (string, error) {
 	return pubCtx[common.AttributeFirstClassDiskUUID], nil
 }
 
-func getDevFromMount(target string) (*Device, error) {
-
-	// Get list of all mounts on system
+// getMount returns the mount at the target, or nil if nothing is mounted to it
+func getMount(target string) (*gofsutil.Info, error) {
 	mnts, err := gofsutil.GetMounts(context.Background())
 	if err != nil {
 		return nil, err
 	}
-
-	for _, m := range mnts {
-		if m.Path == target {
-			// something is mounted to target, get underlying disk
-			d := m.Device
-			if m.Device == "devtmpfs" {
-				d = m.Source
-			}
-			dev, err := getDevice(d)
-			if err != nil {
-				return nil, err
-			}
-			return dev, nil
+	for i := range mnts {
+		if mnts[i].Path == target {
+			return &mnts[i], nil
 		}
 	}
-
-	// Did not identify a device mounted to target
 	return nil, nil
 }
+
+// rescanDevice makes the kernel re-read the capacity of the SCSI device, or of the namespaces
+// of the controller of the NVMe device
+func rescanDevice(realDev string) error {
+	return ioutil.WriteFile(getRescanPath(realDev), []byte("1"), 0200)
+}
+
+// getRescanPath returns the sysfs file to write to for rescanning the device
+func getRescanPath(realDev string) string {
+	name := filepath.Base(realDev)
+	if strings.HasPrefix(name, "nvme") {
+		// The device of an NVMe namespace is its controller
+		return filepath.Join(sysBlockDir, name, "device", "rescan_controller")
+	}
+	return filepath.Join(sysBlockDir, name, "device", "rescan")
+}
+
+// getDeviceSize returns the size of the block device in bytes
+func getDeviceSize(realDev string) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(sysBlockDir, filepath.Base(realDev), "size"))
+	if err != nil {
+		return 0, err
+	}
+	// The size is in 512 byte sectors regardless of the block size of the device
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sectors * 512, nil
+}
+
+// getResizeCommand returns the command growing the filesystem of the given type on the device mounted
+// at the path to the size of the device
+func getResizeCommand(fsType string, realDev string, path string) (string, []string, error) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return "resize2fs", []string{realDev}, nil
+	case "xfs":
+		// xfs can only be grown while mounted
+		return "xfs_growfs", []string{path}, nil
+	}
+	return "", nil, fmt.Errorf("resizing %q filesystems is not supported", fsType)
+}
+
+func getDevFromMount(target string) (*Device, error) {
+
+	m, err := getMount(target)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		// Did not identify a device mounted to target
+		return nil, nil
+	}
+	// something is mounted to target, get underlying disk
+	d := m.Device
+	if m.Device == "devtmpfs" {
+		d = m.Source
+	}
+	return getDevice(d)
+}
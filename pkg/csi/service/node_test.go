@@ -19,14 +19,18 @@ package service
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/akutz/gofsutil"
 )
 
 func TestGetDisk(t *testing.T) {
 	tests := []struct {
 		devs  []os.FileInfo
 		volID string
+		disk  string
 		match bool
 	}{
 		{
@@ -45,6 +49,15 @@ func TestGetDisk(t *testing.T) {
 			volID: "702438570234875",
 			match: false,
 		},
+		{
+			devs: []os.FileInfo{
+				&FakeFileInfo{name: "nvme-VMware_Virtual_NVMe_Disk_VMware_NVME_0000"},
+				&FakeFileInfo{name: "nvme-eui.702438570234875"},
+			},
+			volID: "702438570234875",
+			disk:  "nvme-eui.702438570234875",
+			match: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -57,6 +70,9 @@ func TestGetDisk(t *testing.T) {
 			}
 
 			disk := filepath.Join(devDiskID, blockPrefix+tt.volID)
+			if tt.disk != "" {
+				disk = filepath.Join(devDiskID, tt.disk)
+			}
 			if tt.match {
 				if d != disk {
 					t.Errorf("Expected disk: %s got: %s", disk, d)
@@ -97,3 +113,68 @@ func (fi *FakeFileInfo) IsDir() bool {
 func (fi *FakeFileInfo) Sys() interface{} {
 	return nil
 }
+
+func TestGetRescanPath(t *testing.T) {
+	tests := []struct {
+		realDev  string
+		expected string
+	}{
+		{"/dev/sdb", "/sys/class/block/sdb/device/rescan"},
+		{"/dev/nvme0n2", "/sys/class/block/nvme0n2/device/rescan_controller"},
+	}
+	for _, test := range tests {
+		if path := getRescanPath(test.realDev); path != test.expected {
+			t.Errorf("getRescanPath(%q) = %q, expected %q", test.realDev, path, test.expected)
+		}
+	}
+}
+
+func TestGetResizeCommand(t *testing.T) {
+	tests := []struct {
+		fsType       string
+		expectedCmd  string
+		expectedArgs []string
+		expectError  bool
+	}{
+		{fsType: "ext4", expectedCmd: "resize2fs", expectedArgs: []string{"/dev/sdb"}},
+		{fsType: "ext3", expectedCmd: "resize2fs", expectedArgs: []string{"/dev/sdb"}},
+		{fsType: "xfs", expectedCmd: "xfs_growfs", expectedArgs: []string{"/mnt/target"}},
+		{fsType: "btrfs", expectError: true},
+	}
+	for _, test := range tests {
+		cmd, args, err := getResizeCommand(test.fsType, "/dev/sdb", "/mnt/target")
+		if test.expectError {
+			if err == nil {
+				t.Errorf("getResizeCommand(%q) succeeded, expected error", test.fsType)
+			}
+			continue
+		}
+		if err != nil || cmd != test.expectedCmd || !reflect.DeepEqual(args, test.expectedArgs) {
+			t.Errorf("getResizeCommand(%q) = %q, %v, %v, expected %q, %v", test.fsType, cmd, args, err, test.expectedCmd, test.expectedArgs)
+		}
+	}
+}
+
+func TestIsBlockVolPublished(t *testing.T) {
+	dev := &Device{FullPath: "/dev/disk/by-id/wwn-0x6000c29", Name: "wwn-0x6000c29", RealDev: "/dev/sdb"}
+	bindMount := func(path string) gofsutil.Info {
+		return gofsutil.Info{Device: "devtmpfs", Source: "/dev/sdb", Path: path}
+	}
+	tests := []struct {
+		name        string
+		devMnts     []gofsutil.Info
+		published   bool
+		expectError bool
+	}{
+		{name: "not published", devMnts: nil},
+		{name: "published to target", devMnts: []gofsutil.Info{bindMount("/pods/a/target")}, published: true},
+		{name: "published for another pod", devMnts: []gofsutil.Info{bindMount("/pods/b/target")}},
+		{name: "mounted with a filesystem", devMnts: []gofsutil.Info{{Device: "/dev/sdb", Path: "/staging"}}, expectError: true},
+	}
+	for _, test := range tests {
+		published, err := isBlockVolPublished(test.devMnts, dev, "/pods/a/target")
+		if test.expectError != (err != nil) || published != test.published {
+			t.Errorf("%s: isBlockVolPublished() = %t, %v, expected %t, error: %t", test.name, published, err, test.published, test.expectError)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/opid"
+)
+
+// withOperationID is a unary server interceptor assigning an operation ID to the request. The ID is logged when
+// the request starts and ends, and is sent to vCenter with the calls made while serving the request.
+func withOperationID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	id := opid.New()
+	ctx = opid.NewContext(ctx, id)
+	start := time.Now()
+	klog.V(4).Infof("%s: opId %s started", info.FullMethod, id)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		klog.Errorf("%s: opId %s failed after %v. err=%v", info.FullMethod, id, time.Since(start), err)
+	} else {
+		klog.V(4).Infof("%s: opId %s completed in %v", info.FullMethod, id, time.Since(start))
+	}
+	return resp, err
+}
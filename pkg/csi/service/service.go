@@ -20,24 +20,36 @@ import (
 	"context"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/rexray/gocsi"
 	csictx "github.com/rexray/gocsi/context"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"k8s.io/klog"
 
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/version"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/cns"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	vTypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 )
 
 const (
 	// Name is the name of this CSI SP.
-	Name = "csi.vsphere.vmware.com"
+	Name = common.DriverName
 
 	// UnixSocketPrefix is the prefix before the path on disk
 	UnixSocketPrefix = "unix://"
+
+	// Names of the CSI services reported by the gRPC health service
+	identityServiceName   = "csi.v1.Identity"
+	controllerServiceName = "csi.v1.Controller"
+	nodeServiceName       = "csi.v1.Node"
 )
 
 var (
@@ -60,8 +72,16 @@ type service struct {
 // This works around a bug that if k8s node dies, this will clean up the sock file
 // left behind. This can't be done in BeforeServe because gocsi will already try to
 // bind and fail because the sock file already exists.
+// The sock file is left alone if it is passed by systemd socket activation,
+// and there is none if the endpoint is a Windows named pipe.
 func init() {
+	if isSocketActivated() {
+		return
+	}
 	sockPath := os.Getenv(gocsi.EnvVarEndpoint)
+	if isNamedPipeEndpoint(sockPath) {
+		return
+	}
 	sockPath = strings.TrimPrefix(sockPath, UnixSocketPrefix)
 	if len(sockPath) > 1 { // minimal valid path length
 		os.Remove(sockPath)
@@ -91,6 +111,20 @@ func (s *service) BeforeServe(
 	// Get the SP's operating mode.
 	s.mode = csictx.Getenv(ctx, gocsi.EnvVarMode)
 
+	// Serve the gRPC health service and optionally channelz on the CSI endpoint
+	enableChannelz, _ := strconv.ParseBool(csictx.Getenv(ctx, common.EnvEnableChannelz))
+	auxServices, err := newAuxiliaryServices(enableChannelz)
+	if err != nil {
+		klog.Errorf("Failed to start the gRPC health service. Error: %v", err)
+		return err
+	}
+	sp.ServerOpts = append(sp.ServerOpts, grpc.UnknownServiceHandler(auxServices.handleStream))
+	auxServices.setServingStatus(identityServiceName, healthpb.HealthCheckResponse_SERVING)
+	// Assign operation IDs first, so that the other interceptors see them
+	sp.Interceptors = append(sp.Interceptors, withOperationID)
+
+	klog.V(2).Infof("%s version: %+v", Name, version.Get())
+
 	if !strings.EqualFold(s.mode, "node") {
 		// Controller service is needed
 		var cfg *cnsconfig.Config
@@ -103,10 +137,27 @@ func (s *service) BeforeServe(
 			klog.Errorf("Failed to read cnsconfig. Error: %v", err)
 			return err
 		}
+		supportbundle.Register("csi-vsphere.conf", supportbundle.ConfigCollector(cfgPath))
+		inflight := newInflightRequests()
+		sp.Interceptors = append(sp.Interceptors, inflight.intercept)
+		supportbundle.Register("inflight-requests.json", supportbundle.JSONCollector(func() interface{} {
+			return inflight.list()
+		}))
 		if err := s.cs.Init(cfg); err != nil {
 			klog.Errorf("Failed to init controller. Error: %v", err)
 			return err
 		}
+		metricsAddress := csictx.Getenv(ctx, prometheus.EnvMetricsAddress)
+		if metricsAddress == "" {
+			metricsAddress = prometheus.DefaultControllerMetricsAddress
+		}
+		prometheus.ServeMetrics(metricsAddress)
+		auxServices.setServingStatus(controllerServiceName, healthpb.HealthCheckResponse_SERVING)
+	}
+	if !strings.EqualFold(s.mode, "controller") {
+		cleanupStaleMounts(ctx, getKubeletDir())
+		auxServices.setServingStatus(nodeServiceName, healthpb.HealthCheckResponse_SERVING)
 	}
+	auxServices.setServingStatus("", healthpb.HealthCheckResponse_SERVING)
 	return nil
 }
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// getKubeletDir returns the root directory of kubelet read from X_CSI_KUBELET_DIR if set,
+// otherwise the default of /var/lib/kubelet
+func getKubeletDir() string {
+	if dir := os.Getenv(common.EnvKubeletDir); dir != "" {
+		return filepath.Clean(dir)
+	}
+	return common.DefaultKubeletDir
+}
+
+// cleanupStaleMounts unmounts the staging and publish mounts under the kubelet directory whose
+// block device no longer exists, which are left behind when the node crashes or its VM is
+// restarted with volumes detached meanwhile, and would make NodeStageVolume fail for the volumes.
+func cleanupStaleMounts(ctx context.Context, kubeletDir string) {
+	mnts, err := gofsutil.GetMounts(ctx)
+	if err != nil {
+		klog.Warningf("Failed to list mounts to clean up stale mounts. err: %v", err)
+		return
+	}
+	for _, target := range getStaleMounts(mnts, kubeletDir, deviceExists) {
+		klog.Infof("Unmounting stale mount %s", target)
+		if err := gofsutil.Unmount(ctx, target); err != nil {
+			klog.Warningf("Failed to unmount stale mount %s. err: %v", target, err)
+		}
+	}
+}
+
+// getStaleMounts returns the targets of the mounts under the kubelet directory whose block device does not
+// exist, in reverse mount order so that publish mounts are unmounted before the staging mounts they bind
+func getStaleMounts(mnts []gofsutil.Info, kubeletDir string, exists func(string) bool) []string {
+	var targets []string
+	for i := len(mnts) - 1; i >= 0; i-- {
+		m := mnts[i]
+		if !strings.HasPrefix(m.Path, kubeletDir+"/") {
+			continue
+		}
+		device := m.Device
+		// Raw block volumes are published by bind mounting their device file
+		if device == "devtmpfs" {
+			device = m.Source
+		}
+		if !strings.HasPrefix(device, "/dev/") || exists(device) {
+			continue
+		}
+		targets = append(targets, m.Path)
+	}
+	return targets
+}
+
+// deviceExists returns false if the device file does not exist
+func deviceExists(device string) bool {
+	_, err := os.Stat(device)
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/akutz/gofsutil"
+)
+
+func TestGetStaleMounts(t *testing.T) {
+	const (
+		staging = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount"
+		publish = "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount"
+		block   = "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-2/uid"
+	)
+	mnts := []gofsutil.Info{
+		{Device: "/dev/sda1", Path: "/"},
+		{Device: "/dev/sdb", Path: "/mnt/data"},
+		{Device: "/dev/sdb", Path: staging},
+		{Device: "/dev/sdb", Path: publish},
+		{Device: "devtmpfs", Source: "/dev/sdc", Path: block},
+		{Device: "/dev/sdd", Path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-3/mount"},
+		{Device: "tmpfs", Path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~secret/token"},
+		{Device: "server:/share", Path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-4/mount"},
+	}
+	exists := func(device string) bool {
+		return device == "/dev/sda1" || device == "/dev/sdd"
+	}
+	expected := []string{block, publish, staging}
+	if actual := getStaleMounts(mnts, "/var/lib/kubelet", exists); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("getStaleMounts() = %v, expected %v", actual, expected)
+	}
+}
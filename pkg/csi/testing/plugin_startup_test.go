@@ -119,14 +119,32 @@ var _ = Describe("CSI plugin", func() {
 						Ω(err).ShouldNot(HaveOccurred())
 						Ω(res).ShouldNot(BeNil())
 						caps := res.GetCapabilities()
-						Ω(caps).Should(HaveLen(2))
+						Ω(caps).Should(HaveLen(11))
 						rpcTypes := []csi.ControllerServiceCapability_RPC_Type{
 							caps[0].GetRpc().Type,
 							caps[1].GetRpc().Type,
+							caps[2].GetRpc().Type,
+							caps[3].GetRpc().Type,
+							caps[4].GetRpc().Type,
+							caps[5].GetRpc().Type,
+							caps[6].GetRpc().Type,
+							caps[7].GetRpc().Type,
+							caps[8].GetRpc().Type,
+							caps[9].GetRpc().Type,
+							caps[10].GetRpc().Type,
 						}
 						Ω(rpcTypes).Should(ConsistOf(
 							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-							csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME))
+							csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+							csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+							csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+							csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+							csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+							csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+							csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+							csi.ControllerServiceCapability_RPC_GET_VOLUME,
+							csi.ControllerServiceCapability_RPC_VOLUME_CONDITION))
 					})
 				})
 			})
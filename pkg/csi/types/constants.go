@@ -21,4 +21,8 @@ const (
 	LabelRegionFailureDomain = "failure-domain.beta.kubernetes.io/region"
 	// LabelZoneFailureDomain is label placed on nodes and PV containing zone detail
 	LabelZoneFailureDomain = "failure-domain.beta.kubernetes.io/zone"
+	// LabelTopologyRegion is the GA label placed on nodes and PV containing region detail
+	LabelTopologyRegion = "topology.kubernetes.io/region"
+	// LabelTopologyZone is the GA label placed on nodes and PV containing zone detail
+	LabelTopologyZone = "topology.kubernetes.io/zone"
 )
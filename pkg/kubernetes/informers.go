@@ -19,6 +19,8 @@ package kubernetes
 import (
 	"time"
 
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
@@ -101,8 +103,45 @@ func (im *InformerManager) GetPVCLister() corelisters.PersistentVolumeClaimListe
 	return im.informerFactory.Core().V1().PersistentVolumeClaims().Lister()
 }
 
+// GetNodeLister returns Node Lister for the calling informer manager
+func (im *InformerManager) GetNodeLister() corelisters.NodeLister {
+	return im.informerFactory.Core().V1().Nodes().Lister()
+}
+
+// GetPodLister returns Pod Lister for the calling informer manager
+func (im *InformerManager) GetPodLister() corelisters.PodLister {
+	return im.informerFactory.Core().V1().Pods().Lister()
+}
+
+// WaitForCacheSync waits for the caches of the started informers to be populated.
+// It returns false if the informer manager is stopped first.
+func (im *InformerManager) WaitForCacheSync() bool {
+	for _, synced := range im.informerFactory.WaitForCacheSync(im.stopCh) {
+		if !synced {
+			return false
+		}
+	}
+	return true
+}
+
 // Listen starts the Informers
 func (im *InformerManager) Listen() (stopCh <-chan struct{}) {
 	go im.informerFactory.Start(im.stopCh)
 	return im.stopCh
 }
+
+// WatchSecret calls update with the secret of the given namespace and name when it is created or updated,
+// until stopCh is closed. Only the secret is listed and watched, rather than all secrets of the namespace.
+func WatchSecret(client clientset.Interface, namespace string, name string, update func(secret *v1.Secret), stopCh <-chan struct{}) {
+	listWatch := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "secrets", namespace,
+		fields.OneTermEqualSelector("metadata.name", name))
+	_, controller := cache.NewInformer(listWatch, &v1.Secret{}, noResyncPeriodFunc(), cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			update(obj.(*v1.Secret))
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			update(newObj.(*v1.Secret))
+		},
+	})
+	go controller.Run(stopCh)
+}
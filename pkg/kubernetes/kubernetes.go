@@ -17,29 +17,72 @@ limitations under the License.
 package kubernetes
 
 import (
+	"os"
+
 	"k8s.io/klog"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/slowlog"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 )
 
 // NewClient creates a newk8s client based on a service account
 func NewClient() (clientset.Interface, error) {
+	config, err := getRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	return clientset.NewForConfig(config)
+}
 
-	var config *restclient.Config
-	var err error
-	klog.V(2).Info("k8s client using in-cluster config")
-	config, err = restclient.InClusterConfig()
+// NewDynamicClient creates a new k8s dynamic client based on a service account
+func NewDynamicClient() (dynamic.Interface, error) {
+	config, err := getRestConfig()
 	if err != nil {
-		klog.Errorf("InClusterConfig failed %q", err)
 		return nil, err
 	}
+	return dynamic.NewForConfig(config)
+}
 
-	return clientset.NewForConfig(config)
+// NewEventRecorder creates an event recorder recording events of the given component with the given client
+func NewEventRecorder(client clientset.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
+
+// getRestConfig returns the in-cluster config, or the config of the kubeconfig file set in
+// the KUBECONFIG environment variable when running outside a cluster, e.g. in local development
+func getRestConfig() (*restclient.Config, error) {
+	var config *restclient.Config
+	var err error
+	if kubeConfigPath := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); kubeConfigPath != "" {
+		klog.V(2).Infof("k8s client using kubeconfig %s", kubeConfigPath)
+		config, err = clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+		if err != nil {
+			klog.Errorf("Failed to load kubeconfig %s. Err: %v", kubeConfigPath, err)
+			return nil, err
+		}
+	} else {
+		klog.V(2).Info("k8s client using in-cluster config")
+		config, err = restclient.InClusterConfig()
+		if err != nil {
+			klog.Errorf("InClusterConfig failed %q", err)
+			return nil, err
+		}
+	}
+	config.WrapTransport = slowlog.WrapTransport
+	return config, nil
 }
 
 // CreateKubernetesClientFromConfig creaates a newk8s client from given kubeConfig file
@@ -49,6 +92,7 @@ func CreateKubernetesClientFromConfig(kubeConfigPath string) (clientset.Interfac
 	if err != nil {
 		return nil, err
 	}
+	cfg.WrapTransport = slowlog.WrapTransport
 
 	client, err := clientset.NewForConfig(cfg)
 	if err != nil {
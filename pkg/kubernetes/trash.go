@@ -0,0 +1,160 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// invalidConfigMapKeyChars matches the characters not allowed in ConfigMap keys
+var invalidConfigMapKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// TrashedVolumeSpec is the spec of the PersistentVolume of a volume moved to trash, re-applied to the
+// PersistentVolume created when the volume is restored
+type TrashedVolumeSpec struct {
+	AccessModes      []v1.PersistentVolumeAccessMode  `json:"accessModes,omitempty"`
+	ReclaimPolicy    v1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	StorageClassName string                           `json:"storageClassName,omitempty"`
+	VolumeMode       *v1.PersistentVolumeMode         `json:"volumeMode,omitempty"`
+	FsType           string                           `json:"fsType,omitempty"`
+	// StoragePolicyID is the id of the storage policy of the volume in CNS
+	StoragePolicyID string `json:"storagePolicyId,omitempty"`
+}
+
+// NewTrashedVolumeSpec returns the spec of the given PersistentVolume of a volume with the given storage policy,
+// to be recorded when the volume is moved to trash
+func NewTrashedVolumeSpec(pv *v1.PersistentVolume, storagePolicyID string) *TrashedVolumeSpec {
+	spec := &TrashedVolumeSpec{
+		AccessModes:      pv.Spec.AccessModes,
+		ReclaimPolicy:    pv.Spec.PersistentVolumeReclaimPolicy,
+		StorageClassName: pv.Spec.StorageClassName,
+		VolumeMode:       pv.Spec.VolumeMode,
+		StoragePolicyID:  storagePolicyID,
+	}
+	if pv.Spec.CSI != nil {
+		spec.FsType = pv.Spec.CSI.FSType
+	}
+	return spec
+}
+
+// TrashedVolumeSpecs records the specs of the PersistentVolumes of the volumes in trash in a ConfigMap, by
+// volume id. The controller and the syncer both move volumes to trash, hence updates are retried on conflict.
+type TrashedVolumeSpecs struct {
+	client    clientset.Interface
+	namespace string
+}
+
+// NewTrashedVolumeSpecs returns the specs of the PersistentVolumes of the volumes in trash recorded in the
+// ConfigMap of the given namespace
+func NewTrashedVolumeSpecs(client clientset.Interface, namespace string) *TrashedVolumeSpecs {
+	return &TrashedVolumeSpecs{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// Get returns the spec recorded for the volume with the given id, nil if none is recorded
+func (s *TrashedVolumeSpecs) Get(volumeID string) (*TrashedVolumeSpec, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(common.TrashedVolumeSpecsName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[trashedVolumeKey(volumeID)]
+	if !ok {
+		return nil, nil
+	}
+	spec := &TrashedVolumeSpec{}
+	if err := json.Unmarshal([]byte(data), spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Record records the given spec for the volume with the given id
+func (s *TrashedVolumeSpecs) Record(volumeID string, spec *TrashedVolumeSpec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return s.update(func(specs map[string]string) bool {
+		specs[trashedVolumeKey(volumeID)] = string(data)
+		return true
+	})
+}
+
+// Remove removes the spec recorded for the volume with the given id
+func (s *TrashedVolumeSpecs) Remove(volumeID string) error {
+	return s.update(func(specs map[string]string) bool {
+		if _, ok := specs[trashedVolumeKey(volumeID)]; !ok {
+			return false
+		}
+		delete(specs, trashedVolumeKey(volumeID))
+		return true
+	})
+}
+
+// update applies the given change to the recorded specs and saves them if it returns true, creating the
+// ConfigMap if needed
+func (s *TrashedVolumeSpecs) update(change func(specs map[string]string) bool) error {
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(common.TrashedVolumeSpecsName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: common.TrashedVolumeSpecsName},
+				Data:       make(map[string]string),
+			}
+			if !change(cm.Data) {
+				return nil
+			}
+			_, err = configMaps.Create(cm)
+			if apierrors.IsAlreadyExists(err) {
+				// Created concurrently, retried as a conflict
+				return apierrors.NewConflict(v1.Resource("configmaps"), common.TrashedVolumeSpecsName, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		if !change(cm.Data) {
+			return nil
+		}
+		_, err = configMaps.Update(cm)
+		return err
+	})
+}
+
+// trashedVolumeKey returns the ConfigMap key of the spec of the volume with the given id
+func trashedVolumeKey(volumeID string) string {
+	return invalidConfigMapKeyChars.ReplaceAllString(volumeID, "_")
+}
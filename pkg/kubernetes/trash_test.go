@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTrashedVolumeSpecs(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	specs := NewTrashedVolumeSpecs(client, "kube-system")
+	block := v1.PersistentVolumeBlock
+	pv := &v1.PersistentVolume{
+		Spec: v1.PersistentVolumeSpec{
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+			StorageClassName:              "gold",
+			VolumeMode:                    &block,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: "vol-1@vc-b", FSType: "xfs"},
+			},
+		},
+	}
+	expected := &TrashedVolumeSpec{
+		AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+		ReclaimPolicy:    v1.PersistentVolumeReclaimRetain,
+		StorageClassName: "gold",
+		VolumeMode:       &block,
+		FsType:           "xfs",
+		StoragePolicyID:  "policy-1",
+	}
+	if err := specs.Record("vol-1@vc-b", NewTrashedVolumeSpec(pv, "policy-1")); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	if err := specs.Record("vol-2", &TrashedVolumeSpec{StorageClassName: "silver"}); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	spec, err := specs.Get("vol-1@vc-b")
+	if err != nil || !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Get() = %+v, %v, expected %+v", spec, err, expected)
+	}
+	if err := specs.Remove("vol-1@vc-b"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if spec, err := specs.Get("vol-1@vc-b"); err != nil || spec != nil {
+		t.Errorf("Get() of a removed spec = %+v, %v, expected nil", spec, err)
+	}
+	if spec, err := specs.Get("vol-2"); err != nil || spec == nil || spec.StorageClassName != "silver" {
+		t.Errorf("Get() = %+v, %v, expected the spec of storage class silver", spec, err)
+	}
+}
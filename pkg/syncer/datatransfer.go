@@ -0,0 +1,193 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// cnsDataTransferUpdated prepares the disk of the export or restore requested by the CnsDataTransfer
+func cnsDataTransferUpdated(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if u == nil || !ok {
+		klog.Warningf("CnsDataTransfer: unrecognized object %+v", obj)
+		return
+	}
+	transfer := &cnsv1alpha1.CnsDataTransfer{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, transfer); err != nil {
+		klog.Errorf("CnsDataTransfer: failed to convert %s. err: %v", u.GetName(), err)
+		return
+	}
+	if transfer.Status.Ready {
+		return
+	}
+	klog.V(2).Infof("CnsDataTransfer: preparing %s requested by %s", transfer.Spec.Operation, transfer.Name)
+	var err error
+	switch transfer.Spec.Operation {
+	case cnsv1alpha1.CnsDataTransferExport:
+		err = prepareExport(metadataSyncer, transfer)
+	case cnsv1alpha1.CnsDataTransferRestore:
+		volumeOperationsLock.Lock()
+		err = prepareRestore(metadataSyncer, transfer)
+		volumeOperationsLock.Unlock()
+	default:
+		err = conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("operation %q is not supported", transfer.Spec.Operation))
+	}
+	if err != nil {
+		klog.Errorf("CnsDataTransfer: failed to prepare %s requested by %s. err: %v", transfer.Spec.Operation, transfer.Name, err)
+		transfer.Status.Error = err.Error()
+		conditions.MarkFailed(&transfer.Status.Conditions, err, conditions.ReasonVCenterError)
+	} else {
+		klog.V(2).Infof("CnsDataTransfer: disk %s is ready for %s requested by %s", transfer.Status.DiskID,
+			transfer.Spec.Operation, transfer.Name)
+		transfer.Status.Ready = true
+		transfer.Status.Error = ""
+		conditions.MarkReady(&transfer.Status.Conditions, fmt.Sprintf("Disk %s is ready for %s", transfer.Status.DiskID, transfer.Spec.Operation))
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(transfer)
+	if err != nil {
+		klog.Errorf("CnsDataTransfer: failed to convert %s. err: %v", transfer.Name, err)
+		return
+	}
+	_, err = metadataSyncer.dynamicClient.Resource(cnsv1alpha1.CnsDataTransferGVR).UpdateStatus(
+		&unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("CnsDataTransfer: failed to update status of %s. err: %v", transfer.Name, err)
+	}
+}
+
+// validateDataTransferSpec returns an error if the spec of the CnsDataTransfer is invalid
+func validateDataTransferSpec(spec *cnsv1alpha1.CnsDataTransferSpec) error {
+	switch spec.Operation {
+	case cnsv1alpha1.CnsDataTransferExport:
+		if _, _, ok := common.ParseSnapshotID(spec.SnapshotID); !ok {
+			return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("snapshotID %q is not a valid snapshot handle", spec.SnapshotID))
+		}
+	case cnsv1alpha1.CnsDataTransferRestore:
+		if spec.CapacityInMB <= 0 {
+			return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("capacityInMB must be positive"))
+		}
+		if spec.DatastoreURL == "" {
+			return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("datastoreURL is not specified"))
+		}
+	default:
+		return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("operation %q is not supported", spec.Operation))
+	}
+	return nil
+}
+
+// prepareExport records in the status of the CnsDataTransfer the location of the snapshot to export,
+// after verifying that the snapshot exists
+func prepareExport(metadataSyncer *MetadataSyncInformer, transfer *cnsv1alpha1.CnsDataTransfer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := validateDataTransferSpec(&transfer.Spec); err != nil {
+		return err
+	}
+	csiVolumeID, fcdSnapshotID, _ := common.ParseSnapshotID(transfer.Spec.SnapshotID)
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(csiVolumeID)
+	if err != nil {
+		return conditions.NewError(conditions.ReasonNotFound, err)
+	}
+	queryFilter := cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	}
+	queryResult, err := vcSyncer.getVolumeManager().QueryVolume(ctx, queryFilter)
+	if err != nil {
+		return err
+	}
+	if len(queryResult.Volumes) == 0 {
+		return conditions.NewError(conditions.ReasonNotFound, fmt.Errorf("volume %s of snapshot %s not found", volumeID, transfer.Spec.SnapshotID))
+	}
+	datastore, vStorageObject, err := findVStorageObject(ctx, vcSyncer, volumeID, queryResult.Volumes[0].DatastoreUrl)
+	if err != nil {
+		return err
+	}
+	snapshots, err := vcSyncer.vcenter.RetrieveVStorageObjectSnapshots(ctx, datastore, volumeID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, snapshot := range snapshots {
+		if snapshot.Id.Id == fcdSnapshotID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return conditions.NewError(conditions.ReasonNotFound, fmt.Errorf("snapshot %s not found", transfer.Spec.SnapshotID))
+	}
+	setDataTransferDisk(&transfer.Status, vcSyncer.vcenter.Config.Host, datastore, vStorageObject)
+	transfer.Status.SnapshotID = fcdSnapshotID
+	return nil
+}
+
+// prepareRestore creates the disk to restore into requested by the CnsDataTransfer and records its location in the
+// status of the CnsDataTransfer. The disk is named after the CnsDataTransfer and is not registered with CNS until
+// the data mover imports it with a CnsRegisterVolume.
+func prepareRestore(metadataSyncer *MetadataSyncInformer, transfer *cnsv1alpha1.CnsDataTransfer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spec := &transfer.Spec
+	if err := validateDataTransferSpec(spec); err != nil {
+		return err
+	}
+	if transfer.Status.DiskID == "" {
+		datastore, err := common.GetDatastoreByURL(ctx, metadataSyncer.vcenter, spec.DatastoreURL)
+		if err != nil {
+			return err
+		}
+		diskID, err := metadataSyncer.vcenter.CreateVStorageObject(ctx, datastore.Reference(), transfer.Name, spec.CapacityInMB, "", nil)
+		if err != nil {
+			return err
+		}
+		klog.V(2).Infof("CnsDataTransfer: created disk %s of %d MB on datastore %s to restore into", diskID, spec.CapacityInMB, spec.DatastoreURL)
+		// The disk id is recorded even if the restore fails afterwards, so that the disk is not created again on retry
+		transfer.Status.DiskID = diskID
+	}
+	datastore, vStorageObject, err := findVStorageObject(ctx, metadataSyncer, transfer.Status.DiskID, spec.DatastoreURL)
+	if err != nil {
+		return err
+	}
+	setDataTransferDisk(&transfer.Status, metadataSyncer.vcenter.Config.Host, datastore, vStorageObject)
+	return nil
+}
+
+// setDataTransferDisk records in the status of a CnsDataTransfer the location of the given First Class Disk
+// residing on the given datastore of the given vCenter
+func setDataTransferDisk(status *cnsv1alpha1.CnsDataTransferStatus, vcHost string, datastore vimtypes.ManagedObjectReference,
+	vStorageObject *vimtypes.VStorageObject) {
+	status.VCenterHost = vcHost
+	status.Datastore = datastore.Value
+	status.DiskID = vStorageObject.Config.Id.Id
+	status.CapacityInMB = vStorageObject.Config.CapacityInMB
+	if backing, ok := vStorageObject.Config.Backing.(*vimtypes.BaseConfigInfoDiskFileBackingInfo); ok {
+		status.DiskPath = backing.FilePath
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestValidateDataTransferSpec(t *testing.T) {
+	snapshotID := common.GetSnapshotID("vol-1", "snap-1")
+	tests := []struct {
+		name  string
+		spec  cnsv1alpha1.CnsDataTransferSpec
+		valid bool
+	}{
+		{"export", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferExport, SnapshotID: snapshotID}, true},
+		{"export without snapshot", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferExport}, false},
+		{"export of volume", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferExport, SnapshotID: "vol-1"}, false},
+		{"restore", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferRestore, CapacityInMB: 1024, DatastoreURL: "ds:///vmfs/volumes/ds-1/"}, true},
+		{"restore without capacity", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferRestore, DatastoreURL: "ds:///vmfs/volumes/ds-1/"}, false},
+		{"restore without datastore", cnsv1alpha1.CnsDataTransferSpec{Operation: cnsv1alpha1.CnsDataTransferRestore, CapacityInMB: 1024}, false},
+		{"no operation", cnsv1alpha1.CnsDataTransferSpec{SnapshotID: snapshotID}, false},
+	}
+	for _, test := range tests {
+		err := validateDataTransferSpec(&test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: validateDataTransferSpec() = %v, expected valid %v", test.name, err, test.valid)
+		}
+		if err != nil && conditions.ReasonForError(err, "") != conditions.ReasonInvalidSpec {
+			t.Errorf("%s: validateDataTransferSpec() reason = %q, expected %q", test.name, conditions.ReasonForError(err, ""), conditions.ReasonInvalidSpec)
+		}
+	}
+}
+
+func TestSetDataTransferDisk(t *testing.T) {
+	vStorageObject := &vimtypes.VStorageObject{
+		Config: vimtypes.VStorageObjectConfigInfo{
+			BaseConfigInfo: vimtypes.BaseConfigInfo{
+				Id: vimtypes.ID{Id: "vol-1"},
+				Backing: &vimtypes.BaseConfigInfoDiskFileBackingInfo{
+					BaseConfigInfoFileBackingInfo: vimtypes.BaseConfigInfoFileBackingInfo{FilePath: "[ds-1] fcd/vol-1.vmdk"},
+				},
+			},
+			CapacityInMB: 1024,
+		},
+	}
+	status := &cnsv1alpha1.CnsDataTransferStatus{}
+	setDataTransferDisk(status, "vc1", vimtypes.ManagedObjectReference{Type: "Datastore", Value: "datastore-12"}, vStorageObject)
+	expected := cnsv1alpha1.CnsDataTransferStatus{
+		VCenterHost:  "vc1",
+		Datastore:    "datastore-12",
+		DiskID:       "vol-1",
+		DiskPath:     "[ds-1] fcd/vol-1.vmdk",
+		CapacityInMB: 1024,
+	}
+	if !reflect.DeepEqual(*status, expected) {
+		t.Errorf("setDataTransferDisk() = %+v, expected %+v", *status, expected)
+	}
+}
@@ -17,7 +17,10 @@ limitations under the License.
 package syncer
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	cnstypes "github.com/vmware/govmomi/cns/types"
@@ -29,53 +32,137 @@ import (
 
 	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 )
 
 // triggerFullSync triggers full sync
+// PVs are listed from Kubernetes in pages and only the CNS volumes backing each page are queried,
+// so that the entire PV and volume inventory is never loaded into memory at once.
 func triggerFullSync(k8sclient clientset.Interface, metadataSyncer *MetadataSyncInformer) {
 	klog.V(2).Infof("FullSync: start")
+	stats := &fullSyncStats{startTime: time.Now()}
+	recordFullSyncStart(metadataSyncer)
+	var err error
+	defer func() {
+		recordFullSyncStats(metadataSyncer, stats, err)
+	}()
 
-	// Get K8s PVs in State "Bound", "Available" or "Released"
-	k8sPVs, err := getPVsInBoundAvailableOrReleased(k8sclient)
+	// Initialize CNS volume maps
+	cnsVolumeToPodMap = make(map[string]string)
+	cnsVolumeToPvcMap = make(map[string]string)
+	cnsVolumeToEntityNamespaceMap = make(map[string]string)
+
+	// Map K8s PV's, by the CNS id of their volume, to the operation that needs to be performed on them
+	k8sPVsMap := make(map[string]string)
+	err = forEachPVPage(k8sclient, func(k8sPVs []*v1.PersistentVolume) error {
+		// The PVs of each vCenter are synced with the volumes of that vCenter
+		syncers, pvGroups := groupPVsByVirtualCenter(metadataSyncer, k8sPVs)
+		for i, vcSyncer := range syncers {
+			if err := fullSyncPVs(k8sclient, pvGroups[i], k8sPVsMap, vcSyncer, stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		klog.Warningf("FullSync: Failed to get PVs from kubernetes. Err: %v", err)
+		klog.Warningf("FullSync: Failed to sync PVs from kubernetes. Err: %v", err)
 		return
 	}
+	klog.V(4).Infof("FullSync: k8sPVMap %v", k8sPVsMap)
 
-	// pvToPVCMap maps pv name to corresponding PVC
-	// pvcToPodMap maps pvc to the mounted Pod
-	pvToPVCMap, pvcToPodMap := buildPVCMapPodMap(k8sclient, k8sPVs)
-	klog.V(4).Infof("FullSync: pvToPVCMap %v", pvToPVCMap)
-	klog.V(4).Infof("FullSync: pvcToPodMap %v", pvcToPodMap)
+	for _, vcSyncer := range metadataSyncer.getSyncers() {
+		if err = fullSyncDeletedVolumes(k8sclient, k8sPVsMap, vcSyncer, stats); err != nil {
+			klog.Warningf("FullSync: failed to query volumes of vCenter %q with err %v", vcSyncer.vcenter.Config.Host, err)
+			return
+		}
+	}
+
+	cleanupCnsMaps(k8sPVsMap)
+	klog.V(4).Infof("FullSync: cnsDeletionMap at end of cycle: %v", cnsDeletionMap)
+	klog.V(4).Infof("FullSync: cnsCreationMap at end of cycle: %v", cnsCreationMap)
+	klog.V(2).Infof("FullSync: end")
+}
 
-	//Call CNS QueryAll to get container volumes by cluster ID
+// fullSyncDeletedVolumes pages through the CNS volumes of this cluster on the vCenter of the informer to delete
+// the volumes without a PV in k8sPVsMap
+func fullSyncDeletedVolumes(k8sclient clientset.Interface, k8sPVsMap map[string]string, metadataSyncer *MetadataSyncInformer, stats *fullSyncStats) error {
+	var volToBeDeleted, orphans []cnstypes.CnsVolumeId
 	queryFilter := cnstypes.CnsQueryFilter{
 		ContainerClusterIds: []string{
 			metadataSyncer.cfg.Global.ClusterID,
 		},
 	}
-	querySelection := cnstypes.CnsQuerySelection{}
-	queryAllResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryAllVolume(queryFilter, querySelection)
+	err := volumes.ForEachVolumePage(context.Background(), metadataSyncer.getVolumeManager(), queryFilter, func(cnsVolumes []cnstypes.CnsVolume) error {
+		// Only volumes verified to belong to this cluster are ever deleted
+		var clusterVolumes []cnstypes.CnsVolume
+		for index, vol := range cnsVolumes {
+			if _, existsInK8s := k8sPVsMap[vol.VolumeId.Id]; existsInK8s {
+				continue
+			}
+			if !isVolumeOwnedByCluster(&cnsVolumes[index], metadataSyncer, true) {
+				klog.V(4).Infof("FullSync: Volume with id %s is not verified to belong to cluster %s, skipping", vol.VolumeId.Id, metadataSyncer.cfg.Global.ClusterID)
+				continue
+			}
+			stats.orphansFound++
+			clusterVolumes = append(clusterVolumes, vol)
+			orphans = append(orphans, vol.VolumeId)
+		}
+		if metadataSyncer.orphans == nil {
+			volToBeDeleted = append(volToBeDeleted, identifyVolumesToBeDeleted(clusterVolumes, k8sPVsMap)...)
+		}
+		return nil
+	})
 	if err != nil {
-		klog.Warningf("FullSync: failed to queryAllVolume with err %v", err)
-		return
+		return err
+	}
+	if metadataSyncer.orphans == nil {
+		fullSyncDeleteVolumes(volToBeDeleted, false, metadataSyncer, k8sclient, stats)
+	} else {
+		fullSyncDeleteVolumes(metadataSyncer.orphans.expired(orphans), true, metadataSyncer, k8sclient, stats)
 	}
-	cnsVolumeArray := queryAllResult.Volumes
+	return nil
+}
 
-	// Initialize CNS volume maps
-	cnsVolumeToPodMap = make(map[string]string)
-	cnsVolumeToPvcMap = make(map[string]string)
-	cnsVolumeToEntityNamespaceMap = make(map[string]string)
+// fullSyncPVs creates and updates CNS volumes for the given page of PVs, whose volume handles are the CNS ids
+// of volumes on the vCenter of the informer, and records the operation performed on each of them in k8sPVsMap
+func fullSyncPVs(k8sclient clientset.Interface, k8sPVs []*v1.PersistentVolume, k8sPVsMap map[string]string, metadataSyncer *MetadataSyncInformer, stats *fullSyncStats) error {
+	if len(k8sPVs) == 0 {
+		return nil
+	}
+	stats.volumesScanned += int64(len(k8sPVs))
+	// pvToPVCMap maps pv name to corresponding PVC
+	// pvcToPodMap maps pvc to the mounted Pod
+	pvToPVCMap, pvcToPodMap := buildPVCMapPodMap(k8sclient, k8sPVs)
+	klog.V(4).Infof("FullSync: pvToPVCMap %v", pvToPVCMap)
+	klog.V(4).Infof("FullSync: pvcToPodMap %v", pvcToPodMap)
 
-	// Map K8s PV's to the operation that needs to be performed on them
-	k8sPVsMap := buildVolumeMap(k8sPVs, cnsVolumeArray, pvToPVCMap, pvcToPodMap, metadataSyncer)
-	klog.V(4).Infof("FullSync: k8sPVMap %v", k8sPVsMap)
+	// Query CNS only for the volumes backing this page of PVs.
+	// Volumes are not filtered by cluster, so that volumes registered by other clusters are not mistaken
+	// for missing volumes, and are skipped by buildVolumeMap instead.
+	// CNS returns at most a page of volumes per query, hence the volumes are paged through.
+	queryFilter := cnstypes.CnsQueryFilter{}
+	for _, pv := range k8sPVs {
+		queryFilter.VolumeIds = append(queryFilter.VolumeIds, cnstypes.CnsVolumeId{Id: pv.Spec.CSI.VolumeHandle})
+	}
+	var cnsVolumes []cnstypes.CnsVolume
+	err := volumes.ForEachVolumePage(context.Background(), metadataSyncer.getVolumeManager(), queryFilter, func(page []cnstypes.CnsVolume) error {
+		cnsVolumes = append(cnsVolumes, page...)
+		return nil
+	})
+	if err != nil {
+		klog.Warningf("FullSync: failed to queryVolume with err %v", err)
+		return err
+	}
 
-	// Identify volumes to be created, updated and deleted
-	volToBeCreated, volToBeUpdated, volWithPvcEntryToBeDeleted, volWithPodEntryToBeDeleted := identifyVolumesToBeCreatedUpdated(k8sPVs, k8sPVsMap)
-	volToBeDeleted := identifyVolumesToBeDeleted(cnsVolumeArray, k8sPVsMap)
+	pageK8sPVsMap := buildVolumeMap(k8sPVs, cnsVolumes, pvToPVCMap, pvcToPodMap, metadataSyncer)
+	for volumeID, operation := range pageK8sPVsMap {
+		k8sPVsMap[volumeID] = operation
+	}
+
+	// Identify volumes to be created and updated
+	volToBeCreated, volToBeUpdated, volWithPvcEntryToBeDeleted, volWithPodEntryToBeDeleted := identifyVolumesToBeCreatedUpdated(k8sPVs, pageK8sPVsMap)
 
 	// Construct the cns spec for create and update operations
 	createSpecArray := constructCnsCreateSpec(volToBeCreated, pvToPVCMap, pvcToPodMap, metadataSyncer)
@@ -84,124 +171,183 @@ func triggerFullSync(k8sclient clientset.Interface, metadataSyncer *MetadataSync
 	updateSpecArray = append(updateSpecArray, constructCnsUpdateSpecWithPodToBeDeleted(volWithPodEntryToBeDeleted, metadataSyncer)...)
 
 	wg := sync.WaitGroup{}
-	wg.Add(3)
+	wg.Add(2)
 	// Perform operations
-	go fullSyncCreateVolumes(createSpecArray, metadataSyncer, k8sclient, &wg)
-	go fullSyncDeleteVolumes(volToBeDeleted, metadataSyncer, k8sclient, &wg)
-	go fullSyncUpdateVolumes(updateSpecArray, metadataSyncer, &wg)
+	go func() {
+		defer wg.Done()
+		fullSyncCreateVolumes(createSpecArray, metadataSyncer, k8sclient, stats)
+	}()
+	go func() {
+		defer wg.Done()
+		fullSyncUpdateVolumes(updateSpecArray, metadataSyncer, stats)
+	}()
 	wg.Wait()
-
-	cleanupCnsMaps(k8sPVsMap)
-	klog.V(4).Infof("FullSync: cnsDeletionMap at end of cycle: %v", cnsDeletionMap)
-	klog.V(4).Infof("FullSync: cnsCreationMap at end of cycle: %v", cnsCreationMap)
-	klog.V(2).Infof("FullSync: end")
+	return nil
 }
 
-// getPVsInBoundAvailableOrReleased return PVs in Bound, Available or Released state
-func getPVsInBoundAvailableOrReleased(k8sclient clientset.Interface) ([]*v1.PersistentVolume, error) {
-	var pvsInDesiredState []*v1.PersistentVolume
-	// Get all PVs from kubernetes
-	allPVs, err := k8sclient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+// forEachPVPage lists PVs from kubernetes in pages and calls processPage with
+// the vSphere CSI PVs in State "Bound", "Available" or "Released" of each page
+func forEachPVPage(k8sclient clientset.Interface, processPage func(pvs []*v1.PersistentVolume) error) error {
+	listOptions := metav1.ListOptions{
+		Limit: fullSyncPageSize,
 	}
-	for index, pv := range allPVs.Items {
-		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == service.Name {
-			klog.V(4).Infof("FullSync: pv %v is in state %v", pv.Spec.CSI.VolumeHandle, pv.Status.Phase)
-			if pv.Status.Phase == v1.VolumeBound || pv.Status.Phase == v1.VolumeAvailable || pv.Status.Phase == v1.VolumeReleased {
-				pvsInDesiredState = append(pvsInDesiredState, &allPVs.Items[index])
+	for {
+		pvList, err := k8sclient.CoreV1().PersistentVolumes().List(listOptions)
+		if err != nil {
+			return conditions.NewError(conditions.ReasonKubernetesError, err)
+		}
+		var pvsInDesiredState []*v1.PersistentVolume
+		for index, pv := range pvList.Items {
+			if isPVInBoundAvailableOrReleased(&pv) {
+				pvsInDesiredState = append(pvsInDesiredState, &pvList.Items[index])
 			}
 		}
+		if err = processPage(pvsInDesiredState); err != nil {
+			return err
+		}
+		if pvList.Continue == "" {
+			return nil
+		}
+		listOptions.Continue = pvList.Continue
 	}
-	return pvsInDesiredState, nil
+}
+
+// isPVInBoundAvailableOrReleased returns true if the PV is a vSphere CSI PV in State "Bound", "Available" or "Released"
+func isPVInBoundAvailableOrReleased(pv *v1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != service.Name {
+		return false
+	}
+	klog.V(4).Infof("FullSync: pv %v is in state %v", pv.Spec.CSI.VolumeHandle, pv.Status.Phase)
+	return pv.Status.Phase == v1.VolumeBound || pv.Status.Phase == v1.VolumeAvailable || pv.Status.Phase == v1.VolumeReleased
 }
 
 // fullSyncCreateVolumes create volumes with given array of createSpec
-// Before creating a volume, all current K8s volumes are retrieved
+// Before creating a volume, the K8s PV is retrieved to verify it still exists
 // If the volume is successfully created, it is removed from cnsCreationMap
-func fullSyncCreateVolumes(createSpecArray []cnstypes.CnsVolumeCreateSpec, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup) {
-	defer wg.Done()
-	currentK8sPVMap := make(map[string]bool)
+func fullSyncCreateVolumes(createSpecArray []cnstypes.CnsVolumeCreateSpec, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, stats *fullSyncStats) {
 	volumeOperationsLock.Lock()
 	defer volumeOperationsLock.Unlock()
-	// Get all K8s PVs
-	currentK8sPV, err := getPVsInBoundAvailableOrReleased(k8sclient)
-	if err != nil {
-		klog.Errorf("FullSync: fullSyncCreateVolumes failed to get PVs from kubernetes. Err: %v", err)
-		return
-	}
-	// Create map for easy lookup
-	for _, pv := range currentK8sPV {
-		currentK8sPVMap[pv.Spec.CSI.VolumeHandle] = true
-	}
-	for _, createSpec := range createSpecArray {
-		// Create volume if present in currentK8sPVMap
+	var mapLock sync.Mutex
+	runWithFullSyncWorkers(len(createSpecArray), func(index int) {
+		createSpec := createSpecArray[index]
+		// Create volume if the PV still exists in K8s
 		if createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails) == nil {
-			continue
+			return
 		}
-		if _, existsInK8s := currentK8sPVMap[createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId]; existsInK8s {
+		if existsInK8s(k8sclient, createSpec.Name, createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId) {
 			klog.V(4).Infof("FullSync: Calling CreateVolume for volume %s with id %s and create spec %+v", createSpec.Name, createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId, spew.Sdump(createSpec))
-			_, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolume(&createSpec)
+			_, err := metadataSyncer.getVolumeManager().CreateVolume(context.Background(), &createSpec)
 			if err != nil {
 				klog.Warningf("FullSync: Failed to create disk %s with id %s. Err: %+v", createSpec.Name, createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId, err)
-				continue
+				return
 			}
+			atomic.AddInt64(&stats.volumesCreated, 1)
 		}
+		mapLock.Lock()
 		delete(cnsCreationMap, (createSpec.BackingObjectDetails).(*cnstypes.CnsBlockBackingDetails).BackingDiskId)
-	}
+		mapLock.Unlock()
+	})
 }
 
 // fullSyncDeleteVolumes delete volumes with given array of volumeId
 // Before deleting a volume, all current K8s volumes are retrieved
 // If the volume is successfully deleted, it is removed from cnsDeletionMap
-func fullSyncDeleteVolumes(volumeIDDeleteArray []cnstypes.CnsVolumeId, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup) {
-	defer wg.Done()
-	deleteDisk := false
+// The disk backing the volume is deleted only if deleteDisk is set
+func fullSyncDeleteVolumes(volumeIDDeleteArray []cnstypes.CnsVolumeId, deleteDisk bool, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, stats *fullSyncStats) {
+	if len(volumeIDDeleteArray) == 0 {
+		return
+	}
 	currentK8sPVMap := make(map[string]bool)
 	volumeOperationsLock.Lock()
 	defer volumeOperationsLock.Unlock()
 	// Get all K8s PVs
-	currentK8sPV, err := getPVsInBoundAvailableOrReleased(k8sclient)
+	err := forEachPVPage(k8sclient, func(pvs []*v1.PersistentVolume) error {
+		// Create map for easy lookup
+		for _, pv := range pvs {
+			volumeID, _ := common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+			currentK8sPVMap[volumeID] = true
+		}
+		return nil
+	})
 	if err != nil {
 		klog.Errorf("FullSync: fullSyncDeleteVolumes failed to get PVs from kubernetes. Err: %v", err)
 		return
 	}
-	// Create map for easy lookup
-	for _, pv := range currentK8sPV {
-		currentK8sPVMap[pv.Spec.CSI.VolumeHandle] = true
-	}
-	for _, volID := range volumeIDDeleteArray {
+	var mapLock sync.Mutex
+	runWithFullSyncWorkers(len(volumeIDDeleteArray), func(index int) {
+		volID := volumeIDDeleteArray[index]
 		// Delete volume if not present in currentK8sPVMap
 		if _, existsInK8s := currentK8sPVMap[volID.Id]; !existsInK8s {
 			klog.V(4).Infof("FullSync: Calling DeleteVolume for volume %v with delete disk %v", volID, deleteDisk)
-			err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolume(volID.Id, deleteDisk)
+			err := metadataSyncer.getVolumeManager().DeleteVolume(context.Background(), volID.Id, deleteDisk)
 			if err != nil {
 				klog.Warningf("FullSync: Failed to delete volume %s with error %+v", volID, err)
-				continue
+				return
 			}
+			atomic.AddInt64(&stats.orphansDeleted, 1)
 		}
+		mapLock.Lock()
 		delete(cnsDeletionMap, volID.Id)
-	}
+		mapLock.Unlock()
+	})
 }
 
 // fullSyncUpdateVolumes update metadata for volumes with given array of createSpec
-func fullSyncUpdateVolumes(updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec, metadataSyncer *MetadataSyncInformer, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for _, updateSpec := range updateSpecArray {
+func fullSyncUpdateVolumes(updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec, metadataSyncer *MetadataSyncInformer, stats *fullSyncStats) {
+	runWithFullSyncWorkers(len(updateSpecArray), func(index int) {
+		updateSpec := updateSpecArray[index]
 		klog.V(4).Infof("FullSync: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-		if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(&updateSpec); err != nil {
+		if err := metadataSyncer.getVolumeManager().UpdateVolumeMetadata(context.Background(), &updateSpec); err != nil {
 			klog.Warningf("FullSync:UpdateVolumeMetadata failed with err %v", err)
+			return
 		}
+		atomic.AddInt64(&stats.metadataRepaired, 1)
+	})
+}
+
+// existsInK8s returns true if the PV with the given name backed by the volume with the given CNS id
+// is in State "Bound", "Available" or "Released"
+func existsInK8s(k8sclient clientset.Interface, pvName string, volumeID string) bool {
+	pv, err := k8sclient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("FullSync: Failed to get PV %s. Err: %v", pvName, err)
+		return false
+	}
+	if !isPVInBoundAvailableOrReleased(pv) {
+		return false
 	}
+	cnsVolumeID, _ := common.DecodeVolumeID(pv.Spec.CSI.VolumeHandle)
+	return cnsVolumeID == volumeID
+}
+
+// runWithFullSyncWorkers calls operation for every index in [0, count)
+// using at most the number of full sync workers concurrently
+func runWithFullSyncWorkers(count int, operation func(index int)) {
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < getFullSyncWorkers(); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				operation(index)
+			}
+		}()
+	}
+	for index := 0; index < count; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
 }
 
 // buildCnsUpdateMetadataList build metadata list for given PV
 // metadata list may include PV metadata, PVC metadata and POD metadata
-func buildCnsUpdateMetadataList(pv *v1.PersistentVolume, pvToPVCMap pvcMap, pvcToPodMap podMap) []cnstypes.BaseCnsEntityMetadata {
+func buildCnsUpdateMetadataList(pv *v1.PersistentVolume, pvToPVCMap pvcMap, pvcToPodMap podMap, clusterUID string) []cnstypes.BaseCnsEntityMetadata {
 	var metadataList []cnstypes.BaseCnsEntityMetadata
 
 	// get pv metadata
-	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pv.Name, pv.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePV), pv.Namespace)
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pv.Name, getPVLabels(pv, clusterUID), false, string(cnstypes.CnsKubernetesEntityTypePV), pv.Namespace)
 	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvMetadata))
 	if pvc, ok := pvToPVCMap[pv.Name]; ok {
 		// get pvc metadata
@@ -211,7 +357,7 @@ func buildCnsUpdateMetadataList(pv *v1.PersistentVolume, pvToPVCMap pvcMap, pvcT
 		key := pvc.Namespace + "/" + pvc.Name
 		if pod, ok := pvcToPodMap[key]; ok {
 			// get pod metadata
-			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, false, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
+			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, pod.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
 			metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(podMetadata))
 		}
 	}
@@ -219,40 +365,66 @@ func buildCnsUpdateMetadataList(pv *v1.PersistentVolume, pvToPVCMap pvcMap, pvcT
 	return metadataList
 }
 
+// isVolumeOwnedByCluster returns true if the CNS volume carries the container cluster metadata of this cluster.
+// If the cluster UID is known, volumes recording a different cluster UID do not belong to this cluster,
+// and neither do volumes recording no cluster UID if requireClusterUID is true.
+func isVolumeOwnedByCluster(vol *cnstypes.CnsVolume, metadataSyncer *MetadataSyncInformer, requireClusterUID bool) bool {
+	if vol.Metadata.ContainerCluster.ClusterId != metadataSyncer.cfg.Global.ClusterID {
+		return false
+	}
+	if metadataSyncer.clusterUID == "" {
+		return true
+	}
+	clusterUID := getVolumeClusterUID(vol)
+	if clusterUID == "" {
+		return !requireClusterUID
+	}
+	return clusterUID == metadataSyncer.clusterUID
+}
+
+// getVolumeClusterUID returns the cluster UID recorded in the PV metadata of the CNS volume, if any
+func getVolumeClusterUID(vol *cnstypes.CnsVolume) string {
+	for _, metadata := range vol.Metadata.EntityMetadata {
+		kubernetesMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok || kubernetesMetadata.EntityType != string(cnstypes.CnsKubernetesEntityTypePV) {
+			continue
+		}
+		for _, label := range kubernetesMetadata.Labels {
+			if label.Key == clusterUIDLabel {
+				return label.Value
+			}
+		}
+	}
+	return ""
+}
+
 // buildVolumeMap build k8sPVMap which maps volume id to a string "Create"/"Update" to indicate the PV need to be
 // created/updated in CNS cache
 // A volume mapped to an empty string implies either no operation has to be performed or that the volume will be
 // deleted
 func buildVolumeMap(pvList []*v1.PersistentVolume, cnsVolumeList []cnstypes.CnsVolume, pvToPVCMap pvcMap, pvcToPodMap podMap, metadataSyncer *MetadataSyncInformer) map[string]string {
 	k8sPVMap := make(map[string]string)
-	cnsVolumeMap := make(map[string]bool)
+	cnsVolumeMap := make(map[string]*cnstypes.CnsVolume)
 
-	for _, vol := range cnsVolumeList {
-		cnsVolumeMap[vol.VolumeId.Id] = true
+	for index, vol := range cnsVolumeList {
+		cnsVolumeMap[vol.VolumeId.Id] = &cnsVolumeList[index]
 	}
 	for _, pv := range pvList {
 		k8sPVMap[pv.Spec.CSI.VolumeHandle] = ""
-		if cnsVolumeMap[pv.Spec.CSI.VolumeHandle] {
-			// PV exist in both K8S and CNS cache, check metadata has been changed or not
-			queryFilter := cnstypes.CnsQueryFilter{
-				VolumeIds: []cnstypes.CnsVolumeId{
-					{
-						Id: pv.Spec.CSI.VolumeHandle,
-					},
-				},
+		if cnsVolume, ok := cnsVolumeMap[pv.Spec.CSI.VolumeHandle]; ok {
+			if !isVolumeOwnedByCluster(cnsVolume, metadataSyncer, false) {
+				klog.Warningf("FullSync: Volume %v of PV %s is registered by cluster %s, skipping", pv.Spec.CSI.VolumeHandle, pv.Name, cnsVolume.Metadata.ContainerCluster.ClusterId)
+				continue
 			}
-
-			queryResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryVolume(queryFilter)
-			if err == nil && queryResult != nil && len(queryResult.Volumes) > 0 {
-				if &queryResult.Volumes[0].Metadata != nil {
-					cnsMetadata := queryResult.Volumes[0].Metadata.EntityMetadata
-					metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
-					k8sPVMap[pv.Spec.CSI.VolumeHandle] = getCnsUpdateOperationType(metadataList, cnsMetadata, pv.Name)
-				} else {
-					// metadata does not exist in CNS cache even the volume has an entry in CNS cache
-					klog.Warningf("FullSync: No metadata found for volume %v", pv.Spec.CSI.VolumeHandle)
-					k8sPVMap[pv.Spec.CSI.VolumeHandle] = updateVolumeOperation
-				}
+			// PV exist in both K8S and CNS cache, check metadata has been changed or not
+			if len(cnsVolume.Metadata.EntityMetadata) != 0 {
+				cnsMetadata := cnsVolume.Metadata.EntityMetadata
+				metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap, metadataSyncer.clusterUID)
+				k8sPVMap[pv.Spec.CSI.VolumeHandle] = getCnsUpdateOperationType(metadataList, cnsMetadata, pv.Name)
+			} else {
+				// metadata does not exist in CNS cache even the volume has an entry in CNS cache
+				klog.Warningf("FullSync: No metadata found for volume %v", pv.Spec.CSI.VolumeHandle)
+				k8sPVMap[pv.Spec.CSI.VolumeHandle] = updateVolumeOperation
 			}
 		} else {
 			// PV exist in K8S but not in CNS cache, need to create
@@ -321,8 +493,13 @@ func identifyVolumesToBeDeleted(cnsVolumeList []cnstypes.CnsVolume, k8sPVMap map
 func constructCnsCreateSpec(pvList []*v1.PersistentVolume, pvToPVCMap pvcMap, pvcToPodMap podMap, metadataSyncer *MetadataSyncInformer) []cnstypes.CnsVolumeCreateSpec {
 	var createSpecArray []cnstypes.CnsVolumeCreateSpec
 	for _, pv := range pvList {
+		// Only First Class Disks can be registered with CNS, file shares are created by CNS
+		if common.IsFileVolumeID(pv.Spec.CSI.VolumeHandle) {
+			klog.Warningf("FullSync: file volume %v is not in CNS cache", pv.Spec.CSI.VolumeHandle)
+			continue
+		}
 		// Create new metadata spec
-		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
+		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap, metadataSyncer.clusterUID)
 		// volume exist in K8S, but not in CNS cache, need to create this volume
 		createSpec := cnstypes.CnsVolumeCreateSpec{
 			Name:       pv.Name,
@@ -335,6 +512,7 @@ func constructCnsCreateSpec(pvList []*v1.PersistentVolume, pvToPVCMap pvcMap, pv
 				CnsBackingObjectDetails: cnstypes.CnsBackingObjectDetails{},
 				BackingDiskId:           pv.Spec.CSI.VolumeHandle,
 			},
+			Profile: getProfileSpecs(pv),
 		}
 		klog.V(4).Infof("FullSync: volume %v is not in CNS cache", pv.Spec.CSI.VolumeHandle)
 		createSpecArray = append(createSpecArray, createSpec)
@@ -347,7 +525,7 @@ func constructCnsUpdateSpec(pvUpdateList []*v1.PersistentVolume, pvToPVCMap pvcM
 	var updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec
 	for _, pv := range pvUpdateList {
 		// Create new metadata spec with delete flag false
-		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
+		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap, metadataSyncer.clusterUID)
 		// volume exist in K8S and CNS cache, but metadata is different, need to update this volume
 		updateSpec := cnstypes.CnsVolumeMetadataUpdateSpec{
 			VolumeId: cnstypes.CnsVolumeId{
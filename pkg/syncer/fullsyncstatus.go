@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// fullSyncStats holds the counters of a single full sync run.
+// Counters updated by full sync workers are updated atomically.
+type fullSyncStats struct {
+	startTime        time.Time
+	volumesScanned   int64
+	volumesCreated   int64
+	metadataRepaired int64
+	orphansFound     int64
+	orphansDeleted   int64
+}
+
+// recordFullSyncStats records the metrics and the CnsFullSyncStatus of a full sync run which failed with runErr, if not nil
+func recordFullSyncStats(metadataSyncer *MetadataSyncInformer, stats *fullSyncStats, runErr error) {
+	endTime := time.Now()
+	status := cnsv1alpha1.CnsFullSyncStatusStatus{
+		LastRunStartTime: metav1.NewTime(stats.startTime),
+		LastRunEndTime:   metav1.NewTime(endTime),
+		VolumesScanned:   atomic.LoadInt64(&stats.volumesScanned),
+		VolumesCreated:   atomic.LoadInt64(&stats.volumesCreated),
+		MetadataRepaired: atomic.LoadInt64(&stats.metadataRepaired),
+		OrphansFound:     atomic.LoadInt64(&stats.orphansFound),
+		OrphansDeleted:   atomic.LoadInt64(&stats.orphansDeleted),
+	}
+	result := prometheus.FullSyncResultSuccess
+	if runErr != nil {
+		result = prometheus.FullSyncResultFailure
+		status.Error = runErr.Error()
+	}
+	klog.V(2).Infof("FullSync: %s in %v. volumes scanned: %d, created: %d, metadata repaired: %d, orphans found: %d, orphans deleted: %d",
+		result, endTime.Sub(stats.startTime), status.VolumesScanned, status.VolumesCreated, status.MetadataRepaired, status.OrphansFound, status.OrphansDeleted)
+
+	prometheus.FullSyncRuns.WithLabelValues(result).Inc()
+	prometheus.FullSyncDuration.Observe(endTime.Sub(stats.startTime).Seconds())
+	prometheus.FullSyncLastRunTimestamp.Set(float64(endTime.Unix()))
+	prometheus.FullSyncVolumesScanned.Set(float64(status.VolumesScanned))
+	prometheus.FullSyncVolumesCreated.Set(float64(status.VolumesCreated))
+	prometheus.FullSyncMetadataRepaired.Set(float64(status.MetadataRepaired))
+	prometheus.FullSyncOrphansFound.Set(float64(status.OrphansFound))
+	prometheus.FullSyncOrphansDeleted.Set(float64(status.OrphansDeleted))
+
+	updateFullSyncStatus(metadataSyncer, func(fullSyncStatus *cnsv1alpha1.CnsFullSyncStatusStatus) {
+		// Keep the time of the last successful run and the conditions across runs
+		status.LastSuccessTime = fullSyncStatus.LastSuccessTime
+		status.Conditions = fullSyncStatus.Conditions
+		if runErr != nil {
+			conditions.MarkFailed(&status.Conditions, runErr, conditions.ReasonVCenterError)
+		} else {
+			status.LastSuccessTime = status.LastRunEndTime
+			conditions.MarkReady(&status.Conditions, "Full sync completed")
+		}
+		*fullSyncStatus = status
+	})
+}
+
+// recordFullSyncStart marks the CnsFullSyncStatus as in progress
+func recordFullSyncStart(metadataSyncer *MetadataSyncInformer) {
+	updateFullSyncStatus(metadataSyncer, func(fullSyncStatus *cnsv1alpha1.CnsFullSyncStatusStatus) {
+		conditions.MarkInProgress(&fullSyncStatus.Conditions, "Full sync is running")
+	})
+}
+
+// updateFullSyncStatus applies the given update to the status of the CnsFullSyncStatus, if the dynamic client is set
+func updateFullSyncStatus(metadataSyncer *MetadataSyncInformer, update func(status *cnsv1alpha1.CnsFullSyncStatusStatus)) {
+	if metadataSyncer.dynamicClient == nil {
+		return
+	}
+	if err := updateFullSyncStatusWithClient(metadataSyncer, update); err != nil {
+		klog.Warningf("FullSync: failed to update CnsFullSyncStatus %s. err: %v", cnsv1alpha1.CnsFullSyncStatusName, err)
+	}
+}
+
+// updateFullSyncStatusWithClient creates the CnsFullSyncStatus if it does not exist and updates its status
+func updateFullSyncStatusWithClient(metadataSyncer *MetadataSyncInformer, update func(status *cnsv1alpha1.CnsFullSyncStatusStatus)) error {
+	client := metadataSyncer.dynamicClient.Resource(cnsv1alpha1.CnsFullSyncStatusGVR)
+	u, err := client.Get(cnsv1alpha1.CnsFullSyncStatusName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		fullSyncStatus := &cnsv1alpha1.CnsFullSyncStatus{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: cnsv1alpha1.SchemeGroupVersion.String(),
+				Kind:       "CnsFullSyncStatus",
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: cnsv1alpha1.CnsFullSyncStatusName},
+		}
+		content, convErr := runtime.DefaultUnstructuredConverter.ToUnstructured(fullSyncStatus)
+		if convErr != nil {
+			return convErr
+		}
+		u, err = client.Create(&unstructured.Unstructured{Object: content}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	fullSyncStatus := &cnsv1alpha1.CnsFullSyncStatus{}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, fullSyncStatus); err != nil {
+		return err
+	}
+	update(&fullSyncStatus.Status)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(fullSyncStatus)
+	if err != nil {
+		return err
+	}
+	_, err = client.UpdateStatus(&unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	return err
+}
@@ -29,11 +29,18 @@ import (
 	csictx "github.com/rexray/gocsi/context"
 	cnstypes "github.com/vmware/govmomi/cns/types"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 
-	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/backoff"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/supportbundle"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
@@ -67,6 +74,31 @@ func getFullSyncIntervalInMin() int {
 	return fullSyncIntervalInMin
 }
 
+// getFullSyncWorkers returns the number of workers for each operation of a full sync.
+// If enviroment variable FULL_SYNC_WORKERS is set and valid,
+// return the value read from enviroment variable
+// otherwise, use the default value 4
+func getFullSyncWorkers() int {
+	fullSyncWorkers := defaultFullSyncWorkers
+	if v := os.Getenv(envFullSyncWorkers); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value > 0 {
+			fullSyncWorkers = value
+		} else {
+			klog.Warningf("FullSync: FULL_SYNC_WORKERS %s is invalid, will use the default of %d workers", v, fullSyncWorkers)
+		}
+	}
+	return fullSyncWorkers
+}
+
+// getTrashedVolumeSpecsNamespace returns the namespace of the ConfigMap recording the specs of the PVs of the
+// volumes in trash, which is the namespace of the config secret as for the controller
+func getTrashedVolumeSpecsNamespace() string {
+	if namespace := os.Getenv(common.EnvConfigSecretNamespace); namespace != "" {
+		return namespace
+	}
+	return common.DefaultConfigSecretNamespace
+}
+
 // Init initializes the Metadata Sync Informer
 func (metadataSyncer *MetadataSyncInformer) Init() error {
 	var err error
@@ -78,16 +110,18 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 		cfgPath = cnsconfig.DefaultCloudConfigPath
 	}
 	metadataSyncer.cfg, err = cnsconfig.GetCnsconfig(cfgPath)
+	supportbundle.Register("csi-vsphere.conf", supportbundle.ConfigCollector(cfgPath))
 	if err != nil {
 		klog.Errorf("Failed to parse config. Err: %v", err)
 		return err
 	}
 
-	metadataSyncer.vcconfig, err = cnsvsphere.GetVirtualCenterConfig(metadataSyncer.cfg)
+	vcconfigs, err := cnsvsphere.GetVirtualCenterConfigs(metadataSyncer.cfg)
 	if err != nil {
 		klog.Errorf("Failed to get VirtualCenterConfig. err=%v", err)
 		return err
 	}
+	metadataSyncer.vcconfig = vcconfigs[0]
 
 	// Initialize the virtual center manager
 	metadataSyncer.virtualcentermanager = cnsvsphere.GetVirtualCenterManager()
@@ -111,11 +145,51 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 		klog.Errorf("Creating Kubernetes client failed. Err: %v", err)
 		return err
 	}
+	metadataSyncer.k8sClient = k8sclient
+	metadataSyncer.clusterUID, err = getClusterUID(k8sclient)
+	if err != nil {
+		klog.Errorf("Failed to get the UID of the cluster. Err: %v", err)
+		return err
+	}
+	klog.V(2).Infof("Cluster %s has UID %s", metadataSyncer.cfg.Global.ClusterID, metadataSyncer.clusterUID)
+	metadataSyncer.reclaimMode, err = common.GetVolumeReclaimMode()
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	metadataSyncer.trashedVolumeSpecs = k8s.NewTrashedVolumeSpecs(k8sclient, getTrashedVolumeSpecsNamespace())
+	metadataSyncer.dynamicClient, err = k8s.NewDynamicClient()
+	if err != nil {
+		klog.Errorf("Creating Kubernetes dynamic client failed. Err: %v", err)
+		return err
+	}
+
+	metricsAddress := os.Getenv(prometheus.EnvMetricsAddress)
+	if metricsAddress == "" {
+		metricsAddress = prometheus.DefaultSyncerMetricsAddress
+	}
+	prometheus.ServeMetrics(metricsAddress)
 
 	// Initialize cnsDeletionMap used by Full Sync
 	cnsDeletionMap = make(map[string]bool)
 	// Initialize cnsCreationMap used by Full Sync
 	cnsCreationMap = make(map[string]bool)
+	metadataSyncer.orphans = getOrphanVolumeCleaner()
+	// The volumes of the other vCenters are synced by copies of the informer, made once it is set up for vCenter
+	metadataSyncer.syncers = make(map[string]*MetadataSyncInformer)
+	for _, vcconfig := range vcconfigs[1:] {
+		vc, err := metadataSyncer.virtualcentermanager.RegisterVirtualCenter(vcconfig)
+		if err != nil {
+			klog.Errorf("Failed to register VirtualCenter %q. err=%v", vcconfig.Host, err)
+			return err
+		}
+		if err = vc.Connect(ctx); err != nil {
+			klog.Errorf("Failed to connect to VirtualCenter host: %q. err=%v", vcconfig.Host, err)
+			return err
+		}
+		metadataSyncer.syncers[vcconfig.Host] = metadataSyncer.newVirtualCenterSyncer(vc)
+		klog.V(2).Infof("Registered VirtualCenter %q", vcconfig.Host)
+	}
 
 	ticker := time.NewTicker(time.Duration(getFullSyncIntervalInMin()) * time.Minute)
 	// Trigger full sync
@@ -156,8 +230,47 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 		})
 	metadataSyncer.pvLister = metadataSyncer.k8sInformerManager.GetPVLister()
 	metadataSyncer.pvcLister = metadataSyncer.k8sInformerManager.GetPVCLister()
+
+	// Set up listener for CnsVolumeRestore to restore volumes in trash
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(metadataSyncer.dynamicClient, 0)
+	dynamicInformerFactory.ForResource(cnsv1alpha1.CnsVolumeRestoreGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cnsVolumeRestoreUpdated(obj, metadataSyncer)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			cnsVolumeRestoreUpdated(newObj, metadataSyncer)
+		},
+	})
+	// Set up listener for CnsRegisterVolume to import existing disks
+	dynamicInformerFactory.ForResource(cnsv1alpha1.CnsRegisterVolumeGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cnsRegisterVolumeUpdated(obj, metadataSyncer)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			cnsRegisterVolumeUpdated(newObj, metadataSyncer)
+		},
+	})
+	// Set up listener for CnsUnregisterVolume to hand disks back to non-Kubernetes consumers
+	dynamicInformerFactory.ForResource(cnsv1alpha1.CnsUnregisterVolumeGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cnsUnregisterVolumeUpdated(obj, metadataSyncer)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			cnsUnregisterVolumeUpdated(newObj, metadataSyncer)
+		},
+	})
+	// Set up listener for CnsDataTransfer to let backup data movers export snapshots and restore into new disks
+	dynamicInformerFactory.ForResource(cnsv1alpha1.CnsDataTransferGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cnsDataTransferUpdated(obj, metadataSyncer)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			cnsDataTransferUpdated(newObj, metadataSyncer)
+		},
+	})
 	klog.V(2).Infof("Initialized metadata syncer")
 	stopCh := metadataSyncer.k8sInformerManager.Listen()
+	go dynamicInformerFactory.Start(stopCh)
 	<-(stopCh)
 	<-(stopFullSync)
 	return nil
@@ -199,6 +312,12 @@ func pvcUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer
 		return
 	}
 
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		klog.Errorf("PVCUpdated: %v", err)
+		return
+	}
+
 	// Create updateSpec
 	var metadataList []cnstypes.BaseCnsEntityMetadata
 	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPvc.Name, newPvc.Labels, false, string(cnstypes.CnsKubernetesEntityTypePVC), newPvc.Namespace)
@@ -206,16 +325,16 @@ func pvcUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer
 
 	updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
 		VolumeId: cnstypes.CnsVolumeId{
-			Id: pv.Spec.CSI.VolumeHandle,
+			Id: volumeID,
 		},
 		Metadata: cnstypes.CnsVolumeMetadata{
-			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[vcSyncer.vcenter.Config.Host].User),
 			EntityMetadata:   metadataList,
 		},
 	}
 
 	klog.V(4).Infof("PVCUpdated: Calling UpdateVolumeMetadata with updateSpec: %+v", spew.Sdump(updateSpec))
-	if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+	if err := vcSyncer.getVolumeManager().UpdateVolumeMetadata(context.Background(), updateSpec); err != nil {
 		klog.Errorf("PVCUpdated: UpdateVolumeMetadata failed with err %v", err)
 	}
 }
@@ -250,6 +369,12 @@ func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 		return
 	}
 
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		klog.Errorf("PVCDeleted: %v", err)
+		return
+	}
+
 	// If the PV reclaim policy is retain we need to delete PVC labels
 	var metadataList []cnstypes.BaseCnsEntityMetadata
 	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pvc.Name, nil, true, string(cnstypes.CnsKubernetesEntityTypePVC), pvc.Namespace)
@@ -257,16 +382,16 @@ func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 
 	updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
 		VolumeId: cnstypes.CnsVolumeId{
-			Id: pv.Spec.CSI.VolumeHandle,
+			Id: volumeID,
 		},
 		Metadata: cnstypes.CnsVolumeMetadata{
-			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[vcSyncer.vcenter.Config.Host].User),
 			EntityMetadata:   metadataList,
 		},
 	}
 
 	klog.V(4).Infof("PVCDeleted: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-	if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+	if err := vcSyncer.getVolumeManager().UpdateVolumeMetadata(context.Background(), updateSpec); err != nil {
 		klog.Errorf("PVCDeleted: UpdateVolumeMetadata failed with err %v", err)
 	}
 }
@@ -311,42 +436,52 @@ func pvUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer)
 		return
 	}
 
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(newPv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		klog.Errorf("PVUpdated: %v", err)
+		return
+	}
+
 	var metadataList []cnstypes.BaseCnsEntityMetadata
-	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPv.Name, newPv.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePV), newPv.Namespace)
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPv.Name, getPVLabels(newPv, metadataSyncer.clusterUID), false, string(cnstypes.CnsKubernetesEntityTypePV), newPv.Namespace)
 	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvMetadata))
 
 	if oldPv.Status.Phase == v1.VolumeAvailable || newPv.Spec.StorageClassName != "" {
 		updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
 			VolumeId: cnstypes.CnsVolumeId{
-				Id: newPv.Spec.CSI.VolumeHandle,
+				Id: volumeID,
 			},
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[vcSyncer.vcenter.Config.Host].User),
 				EntityMetadata:   metadataList,
 			},
 		}
 
 		klog.V(4).Infof("PVUpdated: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-		if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+		if err := vcSyncer.getVolumeManager().UpdateVolumeMetadata(context.Background(), updateSpec); err != nil {
 			klog.Errorf("PVUpdated: UpdateVolumeMetadata failed with err %v", err)
 		}
+	} else if common.IsFileVolumeID(oldPv.Spec.CSI.VolumeHandle) {
+		// Only First Class Disks can be registered with CNS, file shares are created by CNS
+		klog.Warningf("PVUpdated: file volume %s of PV %s cannot be registered with CNS", oldPv.Spec.CSI.VolumeHandle, oldPv.Name)
 	} else {
 		createSpec := &cnstypes.CnsVolumeCreateSpec{
 			Name:       oldPv.Name,
 			VolumeType: common.BlockVolumeType,
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[vcSyncer.vcenter.Config.Host].User),
 				EntityMetadata:   metadataList,
 			},
 			BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{
 				CnsBackingObjectDetails: cnstypes.CnsBackingObjectDetails{},
-				BackingDiskId:           oldPv.Spec.CSI.VolumeHandle,
+				BackingDiskId:           volumeID,
 			},
+			Profile: getProfileSpecs(oldPv),
 		}
 		volumeOperationsLock.Lock()
 		defer volumeOperationsLock.Unlock()
 		klog.V(4).Infof("PVUpdated: vSphere provisioner creating volume %s with create spec %+v", oldPv.Name, spew.Sdump(createSpec))
-		_, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolume(createSpec)
+		_, err := vcSyncer.getVolumeManager().CreateVolume(context.Background(), createSpec)
 
 		if err != nil {
 			klog.Errorf("PVUpdated: Failed to create disk %s with error %+v", oldPv.Name, err)
@@ -368,7 +503,7 @@ func pvDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 		klog.V(3).Infof("PVDeleted: Not a Vsphere CSI Volume: %+v", pv)
 		return
 	}
-	var deleteDisk bool
+	var deleteDisk, trash bool
 	if pv.Spec.ClaimRef != nil && (pv.Status.Phase == v1.VolumeAvailable || pv.Status.Phase == v1.VolumeReleased) && pv.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimDelete {
 		klog.V(3).Infof("PVDeleted: Volume deletion will be handled by Controller")
 		return
@@ -380,13 +515,30 @@ func pvDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 	} else {
 		// We set delete disk=true for the case where PV status is failed after deletion of pvc
 		// In this case, metadatasyncer will remove the volume
-		klog.V(4).Infof("PVDeleted: Setting DeleteDisk to true")
-		deleteDisk = true
+		deleteDisk = common.IsDeleteDiskEnabled(metadataSyncer.reclaimMode, pv.Annotations)
+		trash = deleteDisk && metadataSyncer.reclaimMode == common.VolumeReclaimModeTrash
+		klog.V(4).Infof("PVDeleted: Setting DeleteDisk to %t", deleteDisk)
+	}
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		klog.Errorf("PVDeleted: %v", err)
+		return
 	}
 	volumeOperationsLock.Lock()
 	defer volumeOperationsLock.Unlock()
+	if trash {
+		klog.V(4).Infof("PVDeleted: vSphere provisioner moving volume %v to trash", pv)
+		if err := recordTrashedVolumeSpec(metadataSyncer, vcSyncer, pv, volumeID); err != nil {
+			klog.Errorf("PVDeleted: Failed to record the spec of disk %s before moving it to trash with error %+v", pv.Spec.CSI.VolumeHandle, err)
+			return
+		}
+		if err := common.TrashVolumeUtil(context.Background(), vcSyncer.getManager(), volumeID); err != nil {
+			klog.Errorf("PVDeleted: Failed to move disk %s to trash with error %+v", pv.Spec.CSI.VolumeHandle, err)
+		}
+		return
+	}
 	klog.V(4).Infof("PVDeleted: vSphere provisioner deleting volume %v with delete disk %v", pv, deleteDisk)
-	if err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolume(pv.Spec.CSI.VolumeHandle, deleteDisk); err != nil {
+	if err := vcSyncer.getVolumeManager().DeleteVolume(context.Background(), volumeID, deleteDisk); err != nil {
 		klog.Errorf("PVDeleted: Failed to delete disk %s with error %+v", pv.Spec.CSI.VolumeHandle, err)
 		return
 	}
@@ -406,8 +558,9 @@ func podUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer
 		return
 	}
 
-	// If old pod is in pending state and new pod is running, update metadata
-	if oldPod.Status.Phase == v1.PodPending && newPod.Status.Phase == v1.PodRunning {
+	// If old pod is in pending state and new pod is running, or the labels of the running pod changed, update metadata
+	if newPod.Status.Phase == v1.PodRunning &&
+		(oldPod.Status.Phase == v1.PodPending || !reflect.DeepEqual(oldPod.Labels, newPod.Labels)) {
 
 		klog.V(3).Infof("PodUpdated: Pod %s calling updatePodMetadata", newPod.Name)
 		// Update pod metadata
@@ -468,21 +621,30 @@ func updatePodMetadata(pod *v1.Pod, metadataSyncer *MetadataSyncInformer, delete
 				klog.V(3).Infof("Not a Vsphere CSI Volume")
 				continue
 			}
+			vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(pv.Spec.CSI.VolumeHandle)
+			if err != nil {
+				errorList = append(errorList, err)
+				continue
+			}
 			var metadataList []cnstypes.BaseCnsEntityMetadata
-			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, deleteFlag, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
+			var podLabels map[string]string
+			if !deleteFlag {
+				podLabels = pod.Labels
+			}
+			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, podLabels, deleteFlag, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
 			metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(podMetadata))
 			updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
 				VolumeId: cnstypes.CnsVolumeId{
-					Id: pv.Spec.CSI.VolumeHandle,
+					Id: volumeID,
 				},
 				Metadata: cnstypes.CnsVolumeMetadata{
-					ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+					ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[vcSyncer.vcenter.Config.Host].User),
 					EntityMetadata:   metadataList,
 				},
 			}
 
 			klog.V(4).Infof("Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-			if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+			if err := vcSyncer.getVolumeManager().UpdateVolumeMetadata(context.Background(), updateSpec); err != nil {
 				msg := fmt.Sprintf("UpdateVolumeMetadata failed for volume %s with err: %v", volume.Name, err)
 				errorList = append(errorList, errors.New(msg))
 			}
@@ -490,3 +652,28 @@ func updatePodMetadata(pod *v1.Pod, metadataSyncer *MetadataSyncInformer, delete
 	}
 	return errorList
 }
+
+// getClusterUID returns the UID of the kube-system namespace, which uniquely identifies the cluster
+func getClusterUID(k8sclient clientset.Interface) (string, error) {
+	var namespace *v1.Namespace
+	err := backoff.OnError(context.Background(), "Getting namespace "+metav1.NamespaceSystem, backoff.Always, func() (err error) {
+		namespace, err = k8sclient.CoreV1().Namespaces().Get(metav1.NamespaceSystem, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(namespace.UID), nil
+}
+
+// getPVLabels returns the labels of the PV to be recorded in CNS, along with the cluster UID if known
+func getPVLabels(pv *v1.PersistentVolume, clusterUID string) map[string]string {
+	if clusterUID == "" {
+		return pv.GetLabels()
+	}
+	labels := map[string]string{clusterUIDLabel: clusterUID}
+	for key, value := range pv.GetLabels() {
+		labels[key] = value
+	}
+	return labels
+}
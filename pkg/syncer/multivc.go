@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// getVolumeManager returns the manager of the volumes of the vCenter of the informer
+func (metadataSyncer *MetadataSyncInformer) getVolumeManager() volumes.Manager {
+	if metadataSyncer.volumeManager == nil {
+		return volumes.GetManager(metadataSyncer.vcenter)
+	}
+	return metadataSyncer.volumeManager
+}
+
+// getManager returns the manager of the volumes of the vCenter of the informer, for the helpers the informer
+// shares with the controller
+func (metadataSyncer *MetadataSyncInformer) getManager() *common.Manager {
+	return &common.Manager{
+		VcenterConfig:  metadataSyncer.vcconfig,
+		CnsConfig:      metadataSyncer.cfg,
+		VolumeManager:  metadataSyncer.getVolumeManager(),
+		VcenterManager: metadataSyncer.virtualcentermanager,
+	}
+}
+
+// newVirtualCenterSyncer returns a copy of the informer which syncs the volumes of the given vCenter, other than
+// the first vCenter of the config. The copy shares the config and the kubernetes clients of the informer and is
+// only used for the calls to vCenter, the kubernetes listers being read from the informer.
+func (metadataSyncer *MetadataSyncInformer) newVirtualCenterSyncer(vc *cnsvsphere.VirtualCenter) *MetadataSyncInformer {
+	vcSyncer := *metadataSyncer
+	vcSyncer.vcconfig = vc.Config
+	vcSyncer.vcenter = vc
+	vcSyncer.volumeManager = volumes.NewManager(vc)
+	vcSyncer.syncers = nil
+	// Orphan volumes are tracked per vCenter, as a full sync finds them one vCenter at a time
+	if metadataSyncer.orphans != nil {
+		vcSyncer.orphans = newOrphanVolumeCleaner(metadataSyncer.orphans.dryRun, metadataSyncer.orphans.grace)
+	}
+	return &vcSyncer
+}
+
+// getVolumeSyncer returns the informer syncing the volumes of the vCenter owning the volume with the given
+// CSI volume id, along with the CNS id of the volume
+func (metadataSyncer *MetadataSyncInformer) getVolumeSyncer(volumeID string) (*MetadataSyncInformer, string, error) {
+	cnsVolumeID, vcHost := common.DecodeVolumeID(volumeID)
+	if vcHost == "" || vcHost == metadataSyncer.vcenter.Config.Host {
+		return metadataSyncer, cnsVolumeID, nil
+	}
+	vcSyncer, ok := metadataSyncer.syncers[vcHost]
+	if !ok {
+		return nil, "", fmt.Errorf("vCenter %q of volume %q is not configured", vcHost, volumeID)
+	}
+	return vcSyncer, cnsVolumeID, nil
+}
+
+// getSyncers returns the informers syncing the volumes of all the vCenters of the config, the one of the first
+// vCenter first and the others ordered by host
+func (metadataSyncer *MetadataSyncInformer) getSyncers() []*MetadataSyncInformer {
+	hosts := make([]string, 0, len(metadataSyncer.syncers))
+	for host := range metadataSyncer.syncers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	syncers := []*MetadataSyncInformer{metadataSyncer}
+	for _, host := range hosts {
+		syncers = append(syncers, metadataSyncer.syncers[host])
+	}
+	return syncers
+}
+
+// groupPVsByVirtualCenter returns the given PVs grouped by the informer syncing the volumes of their vCenter,
+// in the order of getSyncers. The PVs are copied with the CNS id of their volume as volume handle, so that they
+// are compared as is with the volumes of the vCenter. PVs of vCenters not in the config are left out.
+func groupPVsByVirtualCenter(metadataSyncer *MetadataSyncInformer, pvs []*v1.PersistentVolume) ([]*MetadataSyncInformer, [][]*v1.PersistentVolume) {
+	groups := make(map[*MetadataSyncInformer][]*v1.PersistentVolume)
+	for _, pv := range pvs {
+		vcSyncer, cnsVolumeID, err := metadataSyncer.getVolumeSyncer(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			klog.Warningf("FullSync: skipping PV %s. Err: %v", pv.Name, err)
+			continue
+		}
+		if cnsVolumeID != pv.Spec.CSI.VolumeHandle {
+			pv = pv.DeepCopy()
+			pv.Spec.CSI.VolumeHandle = cnsVolumeID
+		}
+		groups[vcSyncer] = append(groups[vcSyncer], pv)
+	}
+	var syncers []*MetadataSyncInformer
+	var pvGroups [][]*v1.PersistentVolume
+	for _, vcSyncer := range metadataSyncer.getSyncers() {
+		if group, ok := groups[vcSyncer]; ok {
+			syncers = append(syncers, vcSyncer)
+			pvGroups = append(pvGroups, group)
+		}
+	}
+	return syncers, pvGroups
+}
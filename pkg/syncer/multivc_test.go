@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestGroupPVsByVirtualCenter(t *testing.T) {
+	newVirtualCenter := func(host string) *cnsvsphere.VirtualCenter {
+		return &cnsvsphere.VirtualCenter{Config: &cnsvsphere.VirtualCenterConfig{Host: host}}
+	}
+	newPV := func(name, volumeHandle string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: volumeHandle},
+				},
+			},
+		}
+	}
+	first := &MetadataSyncInformer{vcenter: newVirtualCenter("vc-a")}
+	second := &MetadataSyncInformer{vcenter: newVirtualCenter("vc-b")}
+	first.syncers = map[string]*MetadataSyncInformer{"vc-b": second}
+	pvs := []*v1.PersistentVolume{
+		newPV("pv-1", "fcd-1@vc-b"),
+		newPV("pv-2", "fcd-2"),
+		newPV("pv-3", "fcd-3@vc-c"),
+		newPV("pv-4", "fcd-4@vc-a"),
+	}
+	syncers, pvGroups := groupPVsByVirtualCenter(first, pvs)
+	if !reflect.DeepEqual(syncers, []*MetadataSyncInformer{first, second}) {
+		t.Fatalf("groupPVsByVirtualCenter() syncers = %v, expected the informers of vc-a and vc-b", syncers)
+	}
+	expected := [][]*v1.PersistentVolume{
+		{newPV("pv-2", "fcd-2"), newPV("pv-4", "fcd-4")},
+		{newPV("pv-1", "fcd-1")},
+	}
+	if !reflect.DeepEqual(pvGroups, expected) {
+		t.Errorf("groupPVsByVirtualCenter() pvs = %v, expected %v", pvGroups, expected)
+	}
+	// The PVs of the lister are not modified
+	if pvs[0].Spec.CSI.VolumeHandle != "fcd-1@vc-b" {
+		t.Errorf("groupPVsByVirtualCenter() modified the volume handle of PV pv-1 to %q", pvs[0].Spec.CSI.VolumeHandle)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+)
+
+// orphanVolumeCleaner tracks the volumes of the cluster found in CNS without a PV during full sync, which are
+// leaked by failed provisioning, and picks those orphaned for longer than the grace period for deletion along
+// with their disk. In dry-run mode, orphan volumes are only reported.
+// A nil cleaner leaves orphan volumes to the default full sync handling, which only removes them from CNS.
+type orphanVolumeCleaner struct {
+	dryRun    bool
+	grace     time.Duration
+	firstSeen map[string]time.Time
+	now       func() time.Time
+}
+
+// getOrphanVolumeCleaner returns the orphan volume cleaner in the mode read from FULL_SYNC_ORPHAN_CLEANUP,
+// or nil if orphan volume cleanup is off. The grace period is read from FULL_SYNC_ORPHAN_GRACE_MINUTES.
+func getOrphanVolumeCleaner() *orphanVolumeCleaner {
+	mode := os.Getenv(envFullSyncOrphanCleanup)
+	switch mode {
+	case "", orphanCleanupOff:
+		return nil
+	case orphanCleanupDryRun, orphanCleanupDelete:
+	default:
+		klog.Warningf("FullSync: FULL_SYNC_ORPHAN_CLEANUP %s is invalid, orphan volume cleanup is off", mode)
+		return nil
+	}
+	graceMinutes := defaultFullSyncOrphanGraceMinutes
+	if v := os.Getenv(envFullSyncOrphanGraceMinutes); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value >= 0 {
+			graceMinutes = value
+		} else {
+			klog.Warningf("FullSync: FULL_SYNC_ORPHAN_GRACE_MINUTES %s is invalid, will use the default of %d minutes", v, graceMinutes)
+		}
+	}
+	klog.V(2).Infof("FullSync: orphan volume cleanup is in %s mode with a grace period of %d minutes", mode, graceMinutes)
+	return newOrphanVolumeCleaner(mode == orphanCleanupDryRun, time.Duration(graceMinutes)*time.Minute)
+}
+
+// newOrphanVolumeCleaner returns an orphan volume cleaner with the given mode and grace period
+func newOrphanVolumeCleaner(dryRun bool, grace time.Duration) *orphanVolumeCleaner {
+	return &orphanVolumeCleaner{
+		dryRun:    dryRun,
+		grace:     grace,
+		firstSeen: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// expired records the orphan volumes found by a full sync and returns those orphaned for longer than the grace
+// period, which are to be deleted. Volumes no longer orphaned are forgotten. In dry-run mode, the expired volumes
+// are logged and none is returned.
+// The time a volume was first found orphaned is not persisted, so the grace period starts over on restart.
+func (c *orphanVolumeCleaner) expired(orphans []cnstypes.CnsVolumeId) []cnstypes.CnsVolumeId {
+	now := c.now()
+	seen := make(map[string]time.Time, len(orphans))
+	var volToBeDeleted []cnstypes.CnsVolumeId
+	for _, volID := range orphans {
+		firstSeen, ok := c.firstSeen[volID.Id]
+		if !ok {
+			klog.V(2).Infof("FullSync: Volume with id %s has no PV in the cluster, it is orphaned", volID.Id)
+			firstSeen = now
+		}
+		seen[volID.Id] = firstSeen
+		if now.Sub(firstSeen) < c.grace {
+			continue
+		}
+		if c.dryRun {
+			klog.Warningf("FullSync: Volume with id %s is orphaned since %v and would be deleted, dry-run mode is on",
+				volID.Id, firstSeen.Format(time.RFC3339))
+			continue
+		}
+		volToBeDeleted = append(volToBeDeleted, volID)
+	}
+	c.firstSeen = seen
+	return volToBeDeleted
+}
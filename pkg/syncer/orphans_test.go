@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+func TestOrphanVolumeCleaner(t *testing.T) {
+	start := time.Now()
+	volIDs := func(ids ...string) []cnstypes.CnsVolumeId {
+		var volumeIDs []cnstypes.CnsVolumeId
+		for _, id := range ids {
+			volumeIDs = append(volumeIDs, cnstypes.CnsVolumeId{Id: id})
+		}
+		return volumeIDs
+	}
+	tests := []struct {
+		name     string
+		dryRun   bool
+		elapsed  []time.Duration
+		orphans  [][]cnstypes.CnsVolumeId
+		expected [][]cnstypes.CnsVolumeId
+	}{
+		{
+			name:     "deleted after grace period",
+			elapsed:  []time.Duration{0, 30 * time.Minute, time.Hour},
+			orphans:  [][]cnstypes.CnsVolumeId{volIDs("vol-1"), volIDs("vol-1", "vol-2"), volIDs("vol-1", "vol-2")},
+			expected: [][]cnstypes.CnsVolumeId{nil, nil, volIDs("vol-1")},
+		},
+		{
+			name:     "forgotten once no longer orphaned",
+			elapsed:  []time.Duration{0, 30 * time.Minute, time.Hour},
+			orphans:  [][]cnstypes.CnsVolumeId{volIDs("vol-1"), nil, volIDs("vol-1")},
+			expected: [][]cnstypes.CnsVolumeId{nil, nil, nil},
+		},
+		{
+			name:     "dry-run",
+			dryRun:   true,
+			elapsed:  []time.Duration{0, time.Hour},
+			orphans:  [][]cnstypes.CnsVolumeId{volIDs("vol-1"), volIDs("vol-1")},
+			expected: [][]cnstypes.CnsVolumeId{nil, nil},
+		},
+	}
+	for _, test := range tests {
+		cleaner := newOrphanVolumeCleaner(test.dryRun, time.Hour)
+		for i, orphans := range test.orphans {
+			now := start.Add(test.elapsed[i])
+			cleaner.now = func() time.Time { return now }
+			if volToBeDeleted := cleaner.expired(orphans); !reflect.DeepEqual(volToBeDeleted, test.expected[i]) {
+				t.Errorf("%s: expired() at %v = %v, expected %v", test.name, test.elapsed[i], volToBeDeleted, test.expected[i])
+			}
+		}
+	}
+}
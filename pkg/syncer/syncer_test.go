@@ -168,7 +168,7 @@ func runMetadataSyncerTest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeID, err := volumeManager.CreateVolume(&createSpec)
+	volumeID, err := volumeManager.CreateVolume(ctx, &createSpec)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -210,7 +210,7 @@ func runMetadataSyncerTest(t *testing.T) {
 	}
 
 	// Delete volume with DeleteDisk=false
-	err = volumeManager.DeleteVolume(volumeID.Id, false)
+	err = volumeManager.DeleteVolume(ctx, volumeID.Id, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,14 +259,14 @@ func runMetadataSyncerTest(t *testing.T) {
 	}
 
 	// Create Pod on K8S with claim = recently created pvc
-	pod := getPodSpec(pvc.Name, v1.PodRunning)
+	pod := getPodSpec(pvc.Name, v1.PodRunning, nil)
 	if pod, err = k8sclient.CoreV1().Pods(namespace).Create(pod); err != nil {
 		t.Fatal(err)
 	}
 
 	// Test podUpdate workflow on VC
-	oldPod := getPodSpec(pvc.Name, v1.PodPending)
-	newPod := getPodSpec(pvc.Name, v1.PodRunning)
+	oldPod := getPodSpec(pvc.Name, v1.PodPending, nil)
+	newPod := getPodSpec(pvc.Name, v1.PodRunning, nil)
 	podUpdated(oldPod, newPod, metadataSyncer)
 
 	// Verify pod name associated with volume matches updated pod name
@@ -277,6 +277,19 @@ func runMetadataSyncerTest(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Test podUpdate workflow on VC for the labels of a running pod
+	oldPod = newPod
+	newPod = getPodSpec(pvc.Name, v1.PodRunning, map[string]string{testPodLabelName: testPodLabelValue})
+	podUpdated(oldPod, newPod, metadataSyncer)
+
+	// Verify pod label of volume matches that of updated metadata
+	if queryResult, err = metadataSyncer.vcenter.CnsClient.QueryVolume(ctx, queryFilter); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyUpdateOperation(queryResult, volumeID.Id, POD, newPod.Name, testPodLabelValue); err != nil {
+		t.Fatal(err)
+	}
+
 	// Test podDeleted workflow on VC
 	podDeleted(newPod, metadataSyncer)
 	if queryResult, err = metadataSyncer.vcenter.CnsClient.QueryVolume(ctx, queryFilter); err != nil {
@@ -335,7 +348,7 @@ func runFullSyncTest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeID, err := volumeManager.CreateVolume(&createSpec)
+	volumeID, err := volumeManager.CreateVolume(ctx, &createSpec)
 	if err != nil {
 		t.Errorf("Failed to create volume. Error: %+v", err)
 		t.Fatal(err)
@@ -445,7 +458,7 @@ func runFullSyncTest(t *testing.T) {
 	// fullsync should update the POD in CNS cache
 
 	// Create Pod on K8S with claim = recently created pvc
-	pod := getPodSpec(pvc.Name, v1.PodRunning)
+	pod := getPodSpec(pvc.Name, v1.PodRunning, nil)
 	if pod, err = k8sclient.CoreV1().Pods(testNamespace).Create(pod); err != nil {
 		t.Fatal(err)
 	}
@@ -472,7 +485,7 @@ func runFullSyncTest(t *testing.T) {
 	}
 
 	// Cleanup in CNS to delete the volume
-	if err = volumeManager.DeleteVolume(volumeID.Id, true); err != nil {
+	if err = volumeManager.DeleteVolume(ctx, volumeID.Id, true); err != nil {
 		t.Logf("Failed to delete volume %v from CNS", volumeID.Id)
 	}
 	t.Log("End FullSync test")
@@ -513,7 +526,7 @@ func verifyUpdateOperation(queryResult *cnstypes.CnsQueryResult, volumeID string
 	entityMetadata := queryResult.Volumes[0].Metadata.EntityMetadata
 	for _, baseMetadata := range entityMetadata {
 		metadata := interface{}(baseMetadata).(*cnstypes.CnsKubernetesEntityMetadata)
-		if resourceType == POD && metadata.EntityType == POD && metadata.EntityName == resourceName && len(metadata.Labels) == 0 {
+		if resourceType == POD && metadata.EntityType == POD && metadata.EntityName == resourceName && resourceNewLabel == "" && len(metadata.Labels) == 0 {
 			return nil
 		}
 		if len(metadata.Labels) == 0 {
@@ -527,6 +540,9 @@ func verifyUpdateOperation(queryResult *cnstypes.CnsQueryResult, volumeID string
 		if resourceType == PV && metadata.EntityType == PV && metadata.EntityName == resourceName && queryLabel == testPVLabelName && queryValue == resourceNewLabel {
 			return nil
 		}
+		if resourceType == POD && metadata.EntityType == POD && metadata.EntityName == resourceName && queryLabel == testPodLabelName && queryValue == resourceNewLabel {
+			return nil
+		}
 	}
 	return fmt.Errorf("update operation failed for volume Id: %s for resource type %s with queryResult: %v", volumeID, resourceType, spew.Sdump(queryResult))
 }
@@ -648,7 +664,7 @@ func getPersistentVolumeClaimSpec(namespace string, labels map[string]string, pv
 }
 
 // getPodSpec returns a pod spec with given phase
-func getPodSpec(pvcName string, phase v1.PodPhase) *v1.Pod {
+func getPodSpec(pvcName string, phase v1.PodPhase, labels map[string]string) *v1.Pod {
 	var pod *v1.Pod
 	podVolume := []v1.Volume{
 		{
@@ -682,6 +698,7 @@ func getPodSpec(pvcName string, phase v1.PodPhase) *v1.Pod {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      testPodName,
 			Namespace: testNamespace,
+			Labels:    labels,
 		},
 		Spec: v1.PodSpec{
 			Volumes:    podVolume,
@@ -693,3 +710,42 @@ func getPodSpec(pvcName string, phase v1.PodPhase) *v1.Pod {
 	}
 	return pod
 }
+
+func TestIsVolumeOwnedByCluster(t *testing.T) {
+	getVolume := func(clusterID string, clusterUID string) *cnstypes.CnsVolume {
+		var labels map[string]string
+		if clusterUID != "" {
+			labels = map[string]string{clusterUIDLabel: clusterUID}
+		}
+		return &cnstypes.CnsVolume{
+			Metadata: cnstypes.CnsVolumeMetadata{
+				ContainerCluster: cnsvsphere.GetContainerCluster(clusterID, ""),
+				EntityMetadata: []cnstypes.BaseCnsEntityMetadata{
+					cnsvsphere.GetCnsKubernetesEntityMetaData(testVolumeName, labels, false, PV, ""),
+				},
+			},
+		}
+	}
+	syncer := &MetadataSyncInformer{cfg: &cnsconfig.Config{}}
+	syncer.cfg.Global.ClusterID = testClusterName
+	tests := []struct {
+		name              string
+		clusterUID        string
+		volume            *cnstypes.CnsVolume
+		requireClusterUID bool
+		expected          bool
+	}{
+		{"other cluster ID", "", getVolume("other-cluster", ""), false, false},
+		{"unknown cluster UID", "", getVolume(testClusterName, ""), true, true},
+		{"same cluster UID", "uid-1", getVolume(testClusterName, "uid-1"), true, true},
+		{"other cluster UID", "uid-1", getVolume(testClusterName, "uid-2"), false, false},
+		{"no cluster UID", "uid-1", getVolume(testClusterName, ""), false, true},
+		{"no cluster UID required", "uid-1", getVolume(testClusterName, ""), true, false},
+	}
+	for _, test := range tests {
+		syncer.clusterUID = test.clusterUID
+		if owned := isVolumeOwnedByCluster(test.volume, syncer, test.requireClusterUID); owned != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, owned)
+		}
+	}
+}
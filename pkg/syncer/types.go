@@ -20,8 +20,11 @@ import (
 	"sync"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
@@ -43,6 +46,35 @@ const (
 
 	// Env variable for FullSync interval
 	envFullSyncIntervalMinutes = "FULL_SYNC_INTERVAL_MINUTES"
+
+	// default number of workers performing create, update and delete operations of a full sync
+	defaultFullSyncWorkers = 4
+
+	// number of PVs listed from kubernetes per page during full sync
+	fullSyncPageSize = 500
+
+	// Env variable for the number of FullSync workers
+	envFullSyncWorkers = "FULL_SYNC_WORKERS"
+
+	// Env variable for the orphan volume cleanup mode of FullSync, one of off, dry-run and delete
+	envFullSyncOrphanCleanup = "FULL_SYNC_ORPHAN_CLEANUP"
+
+	// Env variable for how long a volume stays orphaned before FullSync deletes it
+	envFullSyncOrphanGraceMinutes = "FULL_SYNC_ORPHAN_GRACE_MINUTES"
+
+	// default grace period of orphan volumes, long enough for the provisioner to retry failed provisioning
+	defaultFullSyncOrphanGraceMinutes = 1440
+
+	// Orphan volume cleanup modes
+	// Orphan volumes are removed from CNS across two fullsync cycles, preserving their disk. This is the default.
+	orphanCleanupOff = "off"
+	// Orphan volumes are reported but left in place
+	orphanCleanupDryRun = "dry-run"
+	// Orphan volumes are deleted along with their disk after the grace period
+	orphanCleanupDelete = "delete"
+
+	// CNS label on the PV metadata of volumes recording the UID of the cluster the volume belongs to
+	clusterUIDLabel = "cns.vmware.com/cluster-uid"
 )
 
 var (
@@ -91,4 +123,18 @@ type MetadataSyncInformer struct {
 	vcenter              *cnsvsphere.VirtualCenter
 	pvLister             corelisters.PersistentVolumeLister
 	pvcLister            corelisters.PersistentVolumeClaimLister
+	k8sClient            clientset.Interface
+	dynamicClient        dynamic.Interface
+	// volumeManager is the manager of the volumes of vcenter, the volume Manager singleton if nil
+	volumeManager volumes.Manager
+	// syncers holds the informers syncing the volumes of the vCenters of the config other than vcenter, by host
+	syncers map[string]*MetadataSyncInformer
+	// clusterUID is the UID of the kube-system namespace, used to tell apart clusters sharing a cluster ID
+	clusterUID string
+	// reclaimMode is the volume reclaim mode, as set for the controller
+	reclaimMode string
+	// orphans picks the orphan volumes FullSync deletes along with their disk, nil if orphan volume cleanup is off
+	orphans *orphanVolumeCleaner
+	// trashedVolumeSpecs records the spec of the PV of each volume moved to trash, re-applied on restore
+	trashedVolumeSpecs *k8s.TrashedVolumeSpecs
 }
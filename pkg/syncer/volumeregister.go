@@ -0,0 +1,228 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// registeredPVNamePrefix is the prefix of the names of the PVs created for imported volumes, followed by the volume id
+const registeredPVNamePrefix = "static-pv-"
+
+// cnsRegisterVolumeUpdated imports the disk requested by the CnsRegisterVolume
+func cnsRegisterVolumeUpdated(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if u == nil || !ok {
+		klog.Warningf("CnsRegisterVolume: unrecognized object %+v", obj)
+		return
+	}
+	register := &cnsv1alpha1.CnsRegisterVolume{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, register); err != nil {
+		klog.Errorf("CnsRegisterVolume: failed to convert %s/%s. err: %v", u.GetNamespace(), u.GetName(), err)
+		return
+	}
+	if register.Status.Registered {
+		return
+	}
+	klog.V(2).Infof("CnsRegisterVolume: registering volume requested by %s/%s", register.Namespace, register.Name)
+	volumeOperationsLock.Lock()
+	volumeID, pvName, err := registerVolume(metadataSyncer, register)
+	volumeOperationsLock.Unlock()
+	// The volume id is recorded even if the registration fails afterwards, so that a VMDK registered as a
+	// First Class Disk is not registered again on retry
+	register.Status.VolumeID = volumeID
+	if err != nil {
+		klog.Errorf("CnsRegisterVolume: failed to register volume requested by %s/%s. err: %v", register.Namespace, register.Name, err)
+		register.Status.Error = err.Error()
+		conditions.MarkFailed(&register.Status.Conditions, err, conditions.ReasonVCenterError)
+	} else {
+		klog.V(2).Infof("CnsRegisterVolume: registered volume %s as PV %s bound to PVC %s/%s", volumeID, pvName,
+			register.Namespace, register.Spec.PvcName)
+		register.Status.Registered = true
+		register.Status.PersistentVolumeName = pvName
+		register.Status.Error = ""
+		conditions.MarkReady(&register.Status.Conditions, fmt.Sprintf("Volume registered as PersistentVolume %s", pvName))
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(register)
+	if err != nil {
+		klog.Errorf("CnsRegisterVolume: failed to convert %s/%s. err: %v", register.Namespace, register.Name, err)
+		return
+	}
+	_, err = metadataSyncer.dynamicClient.Resource(cnsv1alpha1.CnsRegisterVolumeGVR).Namespace(register.Namespace).UpdateStatus(
+		&unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("CnsRegisterVolume: failed to update status of %s/%s. err: %v", register.Namespace, register.Name, err)
+	}
+}
+
+// validateRegisterVolumeSpec returns an error if the spec of the CnsRegisterVolume is invalid
+func validateRegisterVolumeSpec(spec *cnsv1alpha1.CnsRegisterVolumeSpec) error {
+	if spec.PvcName == "" {
+		return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("pvcName is not specified"))
+	}
+	if (spec.VolumeID == "") == (spec.DiskURLPath == "") {
+		return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("exactly one of volumeID and diskURLPath must be specified"))
+	}
+	if spec.AccessMode != "" && spec.AccessMode != v1.ReadWriteOnce {
+		return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("access mode %s is not supported for disks", spec.AccessMode))
+	}
+	return nil
+}
+
+// registerVolume registers the disk requested by the CnsRegisterVolume as a First Class Disk if given by its URL path,
+// then creates a PV for it and a PVC bound to the PV. It returns the id of the volume, even if creating the PV or
+// PVC fails afterwards. The PV is statically provisioned, hence the volume is registered with CNS by pvUpdated once
+// the PV is available. The PV is retained when the PVC is deleted, as the disk was not provisioned by the driver.
+func registerVolume(metadataSyncer *MetadataSyncInformer, register *cnsv1alpha1.CnsRegisterVolume) (string, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spec := &register.Spec
+	if err := validateRegisterVolumeSpec(spec); err != nil {
+		return "", "", err
+	}
+	volumeID := spec.VolumeID
+	if volumeID == "" {
+		volumeID = register.Status.VolumeID
+	}
+	if volumeID == "" {
+		vStorageObject, err := metadataSyncer.vcenter.RegisterDisk(ctx, spec.DiskURLPath, "")
+		if err != nil {
+			return "", "", err
+		}
+		volumeID = vStorageObject.Config.Id.Id
+		klog.V(2).Infof("CnsRegisterVolume: registered disk %q as First Class Disk %s", spec.DiskURLPath, volumeID)
+	}
+	pvName := registeredPVNamePrefix + volumeID
+	pv, err := metadataSyncer.k8sClient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return volumeID, "", conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	if apierrors.IsNotFound(err) {
+		// Volumes registered with CNS already belong to a cluster
+		queryFilter := cnstypes.CnsQueryFilter{
+			VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+		}
+		queryResult, err := metadataSyncer.getVolumeManager().QueryVolume(ctx, queryFilter)
+		if err != nil {
+			return volumeID, "", err
+		}
+		if len(queryResult.Volumes) != 0 {
+			return volumeID, "", conditions.NewError(conditions.ReasonConflict, fmt.Errorf("volume %s is already registered with CNS", volumeID))
+		}
+		_, vStorageObject, err := findVStorageObject(ctx, metadataSyncer, volumeID, "")
+		if err != nil {
+			return volumeID, "", err
+		}
+		pv = newRegisteredPV(pvName, volumeID, vStorageObject.Config.CapacityInMB*common.MbInBytes, register)
+		if pv, err = metadataSyncer.k8sClient.CoreV1().PersistentVolumes().Create(pv); err != nil {
+			return volumeID, "", conditions.NewError(conditions.ReasonKubernetesError, err)
+		}
+	} else if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != volumeID {
+		return volumeID, "", conditions.NewError(conditions.ReasonConflict, fmt.Errorf("PV %s exists for another volume", pvName))
+	}
+	pvc := newRegisteredPVC(pv, register)
+	_, err = metadataSyncer.k8sClient.CoreV1().PersistentVolumeClaims(register.Namespace).Create(pvc)
+	if apierrors.IsAlreadyExists(err) {
+		existingPvc, getErr := metadataSyncer.k8sClient.CoreV1().PersistentVolumeClaims(register.Namespace).Get(pvc.Name, metav1.GetOptions{})
+		if getErr == nil && existingPvc.Spec.VolumeName == pvName {
+			// PVC was created by an earlier attempt.
+			return volumeID, pvName, nil
+		}
+		return volumeID, "", conditions.NewError(conditions.ReasonConflict, fmt.Errorf("PVC %s/%s exists for another volume", register.Namespace, pvc.Name))
+	}
+	if err != nil {
+		return volumeID, "", conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	return volumeID, pvName, nil
+}
+
+// newRegisteredPV returns the PV of the volume with the given id and capacity imported by the CnsRegisterVolume,
+// reserved for the PVC requested by the CnsRegisterVolume
+func newRegisteredPV(pvName string, volumeID string, capacityInBytes int64, register *cnsv1alpha1.CnsRegisterVolume) *v1.PersistentVolume {
+	fsType := register.Spec.FsType
+	if fsType == "" {
+		fsType = common.DefaultFsType
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceStorage: *resource.NewQuantity(capacityInBytes, resource.BinarySI),
+			},
+			AccessModes:                   []v1.PersistentVolumeAccessMode{getRegisterAccessMode(register)},
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+			ClaimRef: &v1.ObjectReference{
+				Kind:      "PersistentVolumeClaim",
+				Namespace: register.Namespace,
+				Name:      register.Spec.PvcName,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       service.Name,
+					VolumeHandle: volumeID,
+					FSType:       fsType,
+				},
+			},
+		},
+	}
+}
+
+// newRegisteredPVC returns the PVC requested by the CnsRegisterVolume, bound to the given PV.
+// The storage class is set to empty so that no volume is provisioned for the PVC.
+func newRegisteredPVC(pv *v1.PersistentVolume, register *cnsv1alpha1.CnsRegisterVolume) *v1.PersistentVolumeClaim {
+	storageClassName := ""
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: register.Namespace,
+			Name:      register.Spec.PvcName,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{getRegisterAccessMode(register)},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: pv.Spec.Capacity[v1.ResourceStorage],
+				},
+			},
+			VolumeName:       pv.Name,
+			StorageClassName: &storageClassName,
+		},
+	}
+}
+
+// getRegisterAccessMode returns the access mode requested by the CnsRegisterVolume, ReadWriteOnce by default
+func getRegisterAccessMode(register *cnsv1alpha1.CnsRegisterVolume) v1.PersistentVolumeAccessMode {
+	if register.Spec.AccessMode == "" {
+		return v1.ReadWriteOnce
+	}
+	return register.Spec.AccessMode
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestValidateRegisterVolumeSpec(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  cnsv1alpha1.CnsRegisterVolumeSpec
+		valid bool
+	}{
+		{"volume id", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", VolumeID: "vol-1"}, true},
+		{"disk url path", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", DiskURLPath: "https://vc/folder/disk.vmdk?dcPath=dc&dsName=ds"}, true},
+		{"read write once", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", VolumeID: "vol-1", AccessMode: v1.ReadWriteOnce}, true},
+		{"no pvc name", cnsv1alpha1.CnsRegisterVolumeSpec{VolumeID: "vol-1"}, false},
+		{"no disk", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc"}, false},
+		{"both disks", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", VolumeID: "vol-1", DiskURLPath: "https://vc/folder/disk.vmdk"}, false},
+		{"read write many", cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", VolumeID: "vol-1", AccessMode: v1.ReadWriteMany}, false},
+	}
+	for _, test := range tests {
+		err := validateRegisterVolumeSpec(&test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: validateRegisterVolumeSpec() = %v, expected valid %v", test.name, err, test.valid)
+		}
+		if err != nil && conditions.ReasonForError(err, "") != conditions.ReasonInvalidSpec {
+			t.Errorf("%s: validateRegisterVolumeSpec() reason = %q, expected %q", test.name, conditions.ReasonForError(err, ""), conditions.ReasonInvalidSpec)
+		}
+	}
+}
+
+func TestNewRegisteredPVAndPVC(t *testing.T) {
+	register := &cnsv1alpha1.CnsRegisterVolume{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "import"},
+		Spec:       cnsv1alpha1.CnsRegisterVolumeSpec{PvcName: "pvc", VolumeID: "vol-1"},
+	}
+	pv := newRegisteredPV(registeredPVNamePrefix+"vol-1", "vol-1", common.GbInBytes, register)
+	if pv.Spec.CSI.VolumeHandle != "vol-1" || pv.Spec.CSI.FSType != common.DefaultFsType {
+		t.Errorf("newRegisteredPV() CSI source = %+v, expected volume vol-1 with fsType %s", pv.Spec.CSI, common.DefaultFsType)
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != "ns" || pv.Spec.ClaimRef.Name != "pvc" {
+		t.Errorf("newRegisteredPV() claimRef = %+v, expected ns/pvc", pv.Spec.ClaimRef)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		t.Errorf("newRegisteredPV() reclaim policy = %s, expected %s", pv.Spec.PersistentVolumeReclaimPolicy, v1.PersistentVolumeReclaimRetain)
+	}
+	pvc := newRegisteredPVC(pv, register)
+	if pvc.Namespace != "ns" || pvc.Name != "pvc" || pvc.Spec.VolumeName != pv.Name {
+		t.Errorf("newRegisteredPVC() = %s/%s bound to %q, expected ns/pvc bound to %q", pvc.Namespace, pvc.Name, pvc.Spec.VolumeName, pv.Name)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != "" {
+		t.Errorf("newRegisteredPVC() storage class = %v, expected empty", pvc.Spec.StorageClassName)
+	}
+	request := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if request.Value() != common.GbInBytes {
+		t.Errorf("newRegisteredPVC() request = %d, expected %d", request.Value(), common.GbInBytes)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+// annProvisionedBy is the annotation set by the external provisioner on dynamically provisioned PVs.
+// Setting it on restored PVs lets the external provisioner delete them when the reclaim policy is Delete.
+const annProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+// cnsVolumeRestoreUpdated restores the volume in trash requested by the CnsVolumeRestore
+func cnsVolumeRestoreUpdated(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if u == nil || !ok {
+		klog.Warningf("CnsVolumeRestore: unrecognized object %+v", obj)
+		return
+	}
+	restore := &cnsv1alpha1.CnsVolumeRestore{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, restore); err != nil {
+		klog.Errorf("CnsVolumeRestore: failed to convert %s. err: %v", u.GetName(), err)
+		return
+	}
+	if restore.Status.Restored {
+		return
+	}
+	klog.V(2).Infof("CnsVolumeRestore: restoring volume %s requested by %s", restore.Spec.VolumeID, restore.Name)
+	volumeOperationsLock.Lock()
+	pvName, err := restoreTrashVolume(metadataSyncer, &restore.Spec)
+	volumeOperationsLock.Unlock()
+	if err != nil {
+		klog.Errorf("CnsVolumeRestore: failed to restore volume %s. err: %v", restore.Spec.VolumeID, err)
+		restore.Status.Error = err.Error()
+		conditions.MarkFailed(&restore.Status.Conditions, err, conditions.ReasonVCenterError)
+	} else {
+		klog.V(2).Infof("CnsVolumeRestore: restored volume %s as PV %s", restore.Spec.VolumeID, pvName)
+		restore.Status.Restored = true
+		restore.Status.PersistentVolumeName = pvName
+		restore.Status.Error = ""
+		conditions.MarkReady(&restore.Status.Conditions, fmt.Sprintf("Volume restored as PersistentVolume %s", pvName))
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(restore)
+	if err != nil {
+		klog.Errorf("CnsVolumeRestore: failed to convert %s. err: %v", restore.Name, err)
+		return
+	}
+	_, err = metadataSyncer.dynamicClient.Resource(cnsv1alpha1.CnsVolumeRestoreGVR).UpdateStatus(
+		&unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("CnsVolumeRestore: failed to update status of %s. err: %v", restore.Name, err)
+	}
+}
+
+// restoreTrashVolume renames the volume in trash back to its original name and creates a PV for it.
+// The PV is statically provisioned, hence the volume is registered with CNS by pvUpdated once the PV is available.
+func restoreTrashVolume(metadataSyncer *MetadataSyncInformer, spec *cnsv1alpha1.CnsVolumeRestoreSpec) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if spec.VolumeID == "" {
+		return "", conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("volumeID is not specified"))
+	}
+	vcSyncer, volumeID, err := metadataSyncer.getVolumeSyncer(spec.VolumeID)
+	if err != nil {
+		return "", conditions.NewError(conditions.ReasonNotFound, err)
+	}
+	queryFilter := cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	}
+	queryResult, err := vcSyncer.getVolumeManager().QueryVolume(ctx, queryFilter)
+	if err != nil {
+		return "", err
+	}
+	if len(queryResult.Volumes) != 0 {
+		return "", conditions.NewError(conditions.ReasonConflict, fmt.Errorf("volume %s is registered with CNS, hence not in trash", spec.VolumeID))
+	}
+	datastore, vStorageObject, err := findVStorageObject(ctx, vcSyncer, volumeID, spec.DatastoreURL)
+	if err != nil {
+		return "", err
+	}
+	// Only volumes this cluster moved to trash are restored, not any unregistered disk on the datastore
+	name, _, ok := common.ParseTrashVolumeName(vStorageObject.Config.Name, vcSyncer.cfg.Global.ClusterID)
+	if !ok {
+		return "", conditions.NewError(conditions.ReasonNotFound,
+			fmt.Errorf("volume %s named %q is not in the trash of this cluster", spec.VolumeID, vStorageObject.Config.Name))
+	}
+	klog.V(2).Infof("CnsVolumeRestore: renaming volume %s from %q to %q", spec.VolumeID, vStorageObject.Config.Name, name)
+	if err = vcSyncer.vcenter.RenameVStorageObject(ctx, datastore, volumeID, name); err != nil {
+		return "", err
+	}
+	pvName := spec.PersistentVolumeName
+	if pvName == "" {
+		pvName = name
+	}
+	trashedSpec, err := metadataSyncer.trashedVolumeSpecs.Get(spec.VolumeID)
+	if err != nil {
+		return "", conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	if trashedSpec == nil {
+		klog.Warningf("CnsVolumeRestore: no spec recorded for volume %s, restoring it as a ReadWriteOnce volume", spec.VolumeID)
+		trashedSpec = &k8s.TrashedVolumeSpec{}
+	}
+	fsType := spec.FsType
+	if fsType == "" {
+		fsType = trashedSpec.FsType
+	}
+	if fsType == "" {
+		fsType = common.DefaultFsType
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pvName,
+			Annotations: map[string]string{annProvisionedBy: service.Name},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceStorage: *resource.NewQuantity(vStorageObject.Config.CapacityInMB*common.MbInBytes, resource.BinarySI),
+			},
+			AccessModes:                   trashedSpec.AccessModes,
+			PersistentVolumeReclaimPolicy: trashedSpec.ReclaimPolicy,
+			StorageClassName:              trashedSpec.StorageClassName,
+			VolumeMode:                    trashedSpec.VolumeMode,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       service.Name,
+					VolumeHandle: spec.VolumeID,
+					FSType:       fsType,
+				},
+			},
+		},
+	}
+	if len(pv.Spec.AccessModes) == 0 {
+		pv.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy == "" {
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimDelete
+	}
+	if trashedSpec.StoragePolicyID != "" {
+		// Applied by pvUpdated when it registers the volume with CNS
+		pv.Spec.CSI.VolumeAttributes = map[string]string{common.AttributeStoragePolicyID: trashedSpec.StoragePolicyID}
+	}
+	_, err = metadataSyncer.k8sClient.CoreV1().PersistentVolumes().Create(pv)
+	if apierrors.IsAlreadyExists(err) {
+		existingPv, getErr := metadataSyncer.k8sClient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+		if getErr == nil && existingPv.Spec.CSI != nil && existingPv.Spec.CSI.VolumeHandle == spec.VolumeID {
+			// PV was created by an earlier attempt.
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	if err = metadataSyncer.trashedVolumeSpecs.Remove(spec.VolumeID); err != nil {
+		klog.Warningf("CnsVolumeRestore: failed to remove the spec recorded for volume %s. err: %v", spec.VolumeID, err)
+	}
+	return pvName, nil
+}
+
+// recordTrashedVolumeSpec records the spec of the given PV of the volume with the given CNS id on the vCenter of
+// the given informer before the volume is moved to trash, so that it is re-applied when the volume is restored
+func recordTrashedVolumeSpec(metadataSyncer *MetadataSyncInformer, vcSyncer *MetadataSyncInformer, pv *v1.PersistentVolume,
+	volumeID string) error {
+	volume, err := vcSyncer.getVolumeManager().RefreshVolume(volumeID)
+	if err != nil || volume == nil {
+		// Volumes no longer registered with CNS are already in trash, see TrashVolumeUtil
+		return err
+	}
+	return metadataSyncer.trashedVolumeSpecs.Record(pv.Spec.CSI.VolumeHandle, k8s.NewTrashedVolumeSpec(pv, volume.StoragePolicyId))
+}
+
+// getProfileSpecs returns the storage policy to register the volume of the given PV with, which is recorded on
+// the PVs of volumes restored from trash
+func getProfileSpecs(pv *v1.PersistentVolume) []vimtypes.BaseVirtualMachineProfileSpec {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeAttributes[common.AttributeStoragePolicyID] == "" {
+		return nil
+	}
+	return []vimtypes.BaseVirtualMachineProfileSpec{
+		&vimtypes.VirtualMachineDefinedProfileSpec{ProfileId: pv.Spec.CSI.VolumeAttributes[common.AttributeStoragePolicyID]},
+	}
+}
+
+// findVStorageObject returns the datastore and the VStorageObject of the volume with the given id.
+// All datastores are searched if the datastore URL is empty.
+func findVStorageObject(ctx context.Context, metadataSyncer *MetadataSyncInformer, volumeID string, datastoreURL string) (vimtypes.ManagedObjectReference, *vimtypes.VStorageObject, error) {
+	vc := metadataSyncer.vcenter
+	if datastoreURL != "" {
+		datastore, err := common.GetDatastoreByURL(ctx, vc, datastoreURL)
+		if err != nil {
+			return vimtypes.ManagedObjectReference{}, nil, err
+		}
+		vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), volumeID)
+		if err != nil {
+			return vimtypes.ManagedObjectReference{}, nil, err
+		}
+		return datastore.Reference(), vStorageObject, nil
+	}
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		return vimtypes.ManagedObjectReference{}, nil, err
+	}
+	for _, datacenter := range datacenters {
+		datastores, err := datacenter.GetAllDatastores(ctx)
+		if err != nil {
+			klog.Warningf("Failed to get datastores of datacenter %q. err: %v", datacenter.InventoryPath, err)
+			continue
+		}
+		for _, datastore := range datastores {
+			vStorageObject, err := vc.RetrieveVStorageObject(ctx, datastore.Reference(), volumeID)
+			if err == nil {
+				return datastore.Reference(), vStorageObject, nil
+			}
+		}
+	}
+	return vimtypes.ManagedObjectReference{}, nil, conditions.NewError(conditions.ReasonNotFound, fmt.Errorf("volume %s not found on any datastore", volumeID))
+}
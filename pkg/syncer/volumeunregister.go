@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+
+	cnsv1alpha1 "sigs.k8s.io/vsphere-csi-driver/pkg/apis/cns/v1alpha1"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/conditions"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// cnsUnregisterVolumeUpdated unregisters the volume requested by the CnsUnregisterVolume
+func cnsUnregisterVolumeUpdated(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if u == nil || !ok {
+		klog.Warningf("CnsUnregisterVolume: unrecognized object %+v", obj)
+		return
+	}
+	unregister := &cnsv1alpha1.CnsUnregisterVolume{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, unregister); err != nil {
+		klog.Errorf("CnsUnregisterVolume: failed to convert %s/%s. err: %v", u.GetNamespace(), u.GetName(), err)
+		return
+	}
+	if unregister.Status.Unregistered {
+		return
+	}
+	klog.V(2).Infof("CnsUnregisterVolume: unregistering volume %s requested by %s/%s", unregister.Spec.VolumeID,
+		unregister.Namespace, unregister.Name)
+	volumeOperationsLock.Lock()
+	err := unregisterVolume(metadataSyncer, unregister)
+	volumeOperationsLock.Unlock()
+	if err != nil {
+		klog.Errorf("CnsUnregisterVolume: failed to unregister volume %s. err: %v", unregister.Spec.VolumeID, err)
+		unregister.Status.Error = err.Error()
+		conditions.MarkFailed(&unregister.Status.Conditions, err, conditions.ReasonVCenterError)
+	} else {
+		klog.V(2).Infof("CnsUnregisterVolume: unregistered volume %s, its disk is preserved", unregister.Spec.VolumeID)
+		unregister.Status.Unregistered = true
+		unregister.Status.Error = ""
+		conditions.MarkReady(&unregister.Status.Conditions, "Volume unregistered, its disk is preserved")
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(unregister)
+	if err != nil {
+		klog.Errorf("CnsUnregisterVolume: failed to convert %s/%s. err: %v", unregister.Namespace, unregister.Name, err)
+		return
+	}
+	_, err = metadataSyncer.dynamicClient.Resource(cnsv1alpha1.CnsUnregisterVolumeGVR).Namespace(unregister.Namespace).UpdateStatus(
+		&unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("CnsUnregisterVolume: failed to update status of %s/%s. err: %v", unregister.Namespace, unregister.Name, err)
+	}
+}
+
+// unregisterVolume deletes the PVC and PV of the volume requested by the CnsUnregisterVolume, then removes the volume
+// from CNS, preserving its disk. The PV is annotated to preserve the disk and set to retain the volume first, so that
+// neither the controller nor pvDeleted deletes the disk. Volumes in use by a pod or attached to a node are refused.
+func unregisterVolume(metadataSyncer *MetadataSyncInformer, unregister *cnsv1alpha1.CnsUnregisterVolume) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	volumeID := unregister.Spec.VolumeID
+	if volumeID == "" {
+		return conditions.NewError(conditions.ReasonInvalidSpec, fmt.Errorf("volumeID is not specified"))
+	}
+	vcSyncer, cnsVolumeID, err := metadataSyncer.getVolumeSyncer(volumeID)
+	if err != nil {
+		return conditions.NewError(conditions.ReasonNotFound, err)
+	}
+	k8sclient := metadataSyncer.k8sClient
+	pvList, err := k8sclient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	var pv *v1.PersistentVolume
+	for i := range pvList.Items {
+		if csi := pvList.Items[i].Spec.CSI; csi != nil && csi.Driver == service.Name && csi.VolumeHandle == volumeID {
+			pv = &pvList.Items[i]
+			break
+		}
+	}
+	if pv != nil {
+		if err := preserveAndDeletePV(metadataSyncer, pv, unregister.Namespace); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("CnsUnregisterVolume: removing volume %s from CNS, preserving its disk", volumeID)
+	return vcSyncer.getVolumeManager().DeleteVolume(ctx, cnsVolumeID, false)
+}
+
+// preserveAndDeletePV deletes the given PV and the PVC bound to it, which must be in the given namespace,
+// after setting the PV to preserve the disk of the volume
+func preserveAndDeletePV(metadataSyncer *MetadataSyncInformer, pv *v1.PersistentVolume, namespace string) error {
+	k8sclient := metadataSyncer.k8sClient
+	claimRef := pv.Spec.ClaimRef
+	if claimRef != nil && claimRef.Namespace != namespace {
+		return conditions.NewError(conditions.ReasonConflict,
+			fmt.Errorf("PV %s is bound to PVC %s/%s in another namespace", pv.Name, claimRef.Namespace, claimRef.Name))
+	}
+	if claimRef != nil {
+		podList, err := k8sclient.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return conditions.NewError(conditions.ReasonKubernetesError, err)
+		}
+		if pods := getPodsUsingPVC(podList.Items, claimRef.Name); len(pods) != 0 {
+			return conditions.NewError(conditions.ReasonConflict, fmt.Errorf("PVC %s/%s is in use by pods %v", namespace, claimRef.Name, pods))
+		}
+	}
+	vaList, err := k8sclient.StorageV1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		return conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	for _, va := range vaList.Items {
+		if va.Spec.Source.PersistentVolumeName != nil && *va.Spec.Source.PersistentVolumeName == pv.Name {
+			return conditions.NewError(conditions.ReasonConflict, fmt.Errorf("PV %s is attached to node %s", pv.Name, va.Spec.NodeName))
+		}
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = make(map[string]string)
+	}
+	pv.Annotations[common.AnnDeleteDisk] = "false"
+	pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	if _, err := k8sclient.CoreV1().PersistentVolumes().Update(pv); err != nil {
+		return conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	if claimRef != nil {
+		klog.V(2).Infof("CnsUnregisterVolume: deleting PVC %s/%s", namespace, claimRef.Name)
+		err := k8sclient.CoreV1().PersistentVolumeClaims(namespace).Delete(claimRef.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return conditions.NewError(conditions.ReasonKubernetesError, err)
+		}
+	}
+	klog.V(2).Infof("CnsUnregisterVolume: deleting PV %s", pv.Name)
+	err = k8sclient.CoreV1().PersistentVolumes().Delete(pv.Name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return conditions.NewError(conditions.ReasonKubernetesError, err)
+	}
+	return nil
+}
+
+// getPodsUsingPVC returns the names of the pods which are not terminated and use the PVC with the given name
+func getPodsUsingPVC(pods []v1.Pod, pvcName string) []string {
+	var names []string
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvcName {
+				names = append(names, pod.Name)
+				break
+			}
+		}
+	}
+	return names
+}
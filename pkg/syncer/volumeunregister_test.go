@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPodsUsingPVC(t *testing.T) {
+	pod := func(name string, phase v1.PodPhase, claimNames ...string) v1.Pod {
+		p := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+		for _, claimName := range claimNames {
+			p.Spec.Volumes = append(p.Spec.Volumes, v1.Volume{
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			})
+		}
+		return p
+	}
+	pods := []v1.Pod{
+		pod("running", v1.PodRunning, "pvc-1", "pvc-2"),
+		pod("pending", v1.PodPending, "pvc-2"),
+		pod("succeeded", v1.PodSucceeded, "pvc-1"),
+		pod("failed", v1.PodFailed, "pvc-2"),
+		pod("no-volumes", v1.PodRunning),
+	}
+	tests := []struct {
+		pvcName  string
+		expected []string
+	}{
+		{"pvc-1", []string{"running"}},
+		{"pvc-2", []string{"running", "pending"}},
+		{"pvc-3", nil},
+	}
+	for _, test := range tests {
+		if names := getPodsUsingPVC(pods, test.pvcName); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("getPodsUsingPVC(%q) = %v, expected %v", test.pvcName, names, test.expected)
+		}
+	}
+}
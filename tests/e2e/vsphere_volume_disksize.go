@@ -85,7 +85,7 @@ var _ = ginkgo.Describe("[csi-block-e2e] Volume Disk Size ", func() {
 		}
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 		ginkgo.By("Verifying disk size specified in PVC in honored")
-		if queryResult.Volumes[0].BackingObjectDetails.CapacityInMb != diskSizeInMb {
+		if queryResult.Volumes[0].BackingObjectDetails.GetCnsBackingObjectDetails().CapacityInMb != diskSizeInMb {
 			err = fmt.Errorf("Wrong disk size provisioned ")
 		}
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())